@@ -0,0 +1,410 @@
+// Package audit records patient mutations and reads of patient records in
+// the audit_logs table. Entries belong to the clinic of their patient and
+// are only read back by that clinic.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/encryption"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/tenant"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// Entry is one row of the audit_logs table. OldValue and NewValue hold the
+// JSON encoded patient before and after the operation, and are nil when
+// there is no such state (e.g. OldValue of a create, or both for a read).
+// They hold PHI, so they are stored encrypted when encryption is enabled and
+// decrypted when entries are read.
+// ClientIP is the IP the request came from, nil outside of HTTP requests.
+type Entry struct {
+	ID          int       `json:"id"`
+	Operation   string    `json:"operation"`
+	PatientID   int       `json:"patientId"`
+	PerformedBy string    `json:"performedBy"`
+	ClientIP    *string   `json:"clientIp"`
+	ClinicID    int       `json:"clinicId"`
+	OccurredAt  time.Time `json:"occurredAt"`
+	OldValue    *string   `json:"oldValue"`
+	NewValue    *string   `json:"newValue"`
+}
+
+// Filter narrows Search to entries matching every set field.
+type Filter struct {
+	PatientID   *int
+	PerformedBy string
+	Operation   string
+	From        *time.Time
+	To          *time.Time
+}
+
+const entryColumns = "id, operation, patient_id, performed_by, client_ip, clinic_id, occurred_at, old_value, new_value"
+
+// valueField is the associated data of the encrypted old and new values.
+const valueField = "audit_value"
+
+// AuditLogger writes and reads audit entries.
+type AuditLogger struct {
+	db     *sql.DB
+	cipher *encryption.Cipher
+}
+
+// NewAuditLogger returns an AuditLogger reading entries from db, encrypting
+// the old and new values with cipher, nil to store them in plaintext.
+func NewAuditLogger(db *sql.DB, cipher *encryption.Cipher) *AuditLogger {
+	return &AuditLogger{db: db, cipher: cipher}
+}
+
+// Log records operation on patientID inside tx, so the entry is only kept
+// if the mutation it describes commits. oldValue and newValue are encoded
+// as JSON and encrypted; pass nil when there is no value. The entry belongs to the clinic
+// ctx acts for.
+func (l *AuditLogger) Log(ctx context.Context, tx *sql.Tx, operation string, patientID int, performedBy string, oldValue, newValue interface{}) error {
+	clinicID, ok := tenant.ClinicFromContext(ctx)
+	if !ok {
+		return utils.ErrNoClinic
+	}
+
+	oldJSON, err := l.encode(oldValue)
+	if err != nil {
+		return err
+	}
+
+	newJSON, err := l.encode(newValue)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"insert into audit_logs(operation, patient_id, performed_by, client_ip, old_value, new_value, clinic_id) values($1, $2, $3, $4, $5, $6, $7)",
+		operation, patientID, performedBy, clientIP(ctx), oldJSON, newJSON, clinicID)
+
+	return err
+}
+
+// LogAccess records that performedBy read the patients in patientIDs, with
+// one entry per patient and operation naming how they were read, such as
+// "read" or "export". Each entry belongs to the clinic of its patient, so
+// the cross-clinic reads of admins are logged with the clinics they read.
+func (l *AuditLogger) LogAccess(ctx context.Context, operation, performedBy string, patientIDs []int) error {
+	if len(patientIDs) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(patientIDs))
+	for i, id := range patientIDs {
+		ids[i] = int64(id)
+	}
+
+	_, err := l.db.ExecContext(ctx,
+		"insert into audit_logs(operation, patient_id, performed_by, client_ip, clinic_id) select $1, id, $3, $4, clinic_id from patients where id = any($2::integer[])",
+		operation, pq.Array(ids), performedBy, clientIP(ctx))
+
+	return err
+}
+
+// Entries returns a page of the audit entries for patientID, newest first.
+func (l *AuditLogger) Entries(ctx context.Context, patientID, limit, offset int) ([]*Entry, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := l.db.QueryContext(ctx,
+		"select "+entryColumns+" from audit_logs where patient_id = $1 and ($4::integer is null or clinic_id = $4) order by occurred_at desc, id desc limit $2 offset $3",
+		patientID, limit, offset, clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return l.scanEntries(rows)
+}
+
+// Search returns a page of the entries matching filter, newest first, and
+// the number of matching entries across all pages.
+func (l *AuditLogger) Search(ctx context.Context, filter Filter, limit, offset int) ([]*Entry, int, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	where, args := filterClause(filter, clinic)
+
+	var total int
+	if err := l.db.QueryRowContext(ctx, "select count(*) from audit_logs"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf("select "+entryColumns+" from audit_logs%s order by occurred_at desc, id desc limit $%d offset $%d",
+		where, len(args)+1, len(args)+2)
+
+	rows, err := l.db.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries, err := l.scanEntries(rows)
+	return entries, total, err
+}
+
+// filterClause builds the where clause of filter and the clinicScope
+// argument clinic with numbered placeholders.
+func filterClause(filter Filter, clinic interface{}) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	add("($%[1]d::integer is null or clinic_id = $%[1]d)", clinic)
+
+	if filter.PatientID != nil {
+		add("patient_id = $%d", *filter.PatientID)
+	}
+	if filter.PerformedBy != "" {
+		add("performed_by = $%d", filter.PerformedBy)
+	}
+	if filter.Operation != "" {
+		add("operation = $%d", filter.Operation)
+	}
+	if filter.From != nil {
+		add("occurred_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("occurred_at < $%d", *filter.To)
+	}
+
+	return " where " + strings.Join(conditions, " and "), args
+}
+
+func (l *AuditLogger) scanEntries(rows *sql.Rows) ([]*Entry, error) {
+	entries := []*Entry{}
+	for rows.Next() {
+		entry := &Entry{}
+
+		err := rows.Scan(&entry.ID, &entry.Operation, &entry.PatientID, &entry.PerformedBy,
+			&entry.ClientIP, &entry.ClinicID, &entry.OccurredAt, &entry.OldValue, &entry.NewValue)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, value := range []*string{entry.OldValue, entry.NewValue} {
+			if value == nil {
+				continue
+			}
+
+			plaintext, err := l.cipher.DecryptJSON(valueField, []byte(*value))
+			if err != nil {
+				return nil, err
+			}
+			*value = string(plaintext)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// clinicScope returns the clinic ctx reads the entries of, or nil for every
+// clinic under tenant.WithAllClinics. It fails with utils.ErrNoClinic when
+// ctx acts for no clinic.
+func clinicScope(ctx context.Context) (interface{}, error) {
+	if tenant.AllClinics(ctx) {
+		return nil, nil
+	}
+
+	clinicID, ok := tenant.ClinicFromContext(ctx)
+	if !ok {
+		return nil, utils.ErrNoClinic
+	}
+
+	return clinicID, nil
+}
+
+type txKey struct{}
+
+// ContextWithTx returns ctx carrying tx, which WithTx then joins.
+func ContextWithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext returns the transaction stored by ContextWithTx, if any.
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+	return tx, ok
+}
+
+// WithTx runs fn in a transaction started with BeginTx, committing when fn
+// returns nil and rolling back otherwise. When ctx carries a transaction
+// from ContextWithTx, fn runs in it instead and committing it is left to
+// whoever started it.
+func WithTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	if tx, ok := TxFromContext(ctx); ok {
+		return fn(tx)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// clientIP returns the client IP stored in ctx by the HTTP middleware, or
+// nil so the column is left NULL.
+func clientIP(ctx context.Context) interface{} {
+	if ip, ok := middleware.ClientIPFromContext(ctx); ok {
+		return ip
+	}
+
+	return nil
+}
+
+func (l *AuditLogger) encode(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := l.cipher.EncryptJSON(valueField, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(sealed), nil
+}
+
+// reencryptBatchSize is how many entries Reencrypt updates per transaction.
+const reencryptBatchSize = 500
+
+// Reencrypt encrypts the old and new values of every entry with the current
+// key, like store.PatientStore.Reencrypt, so older keys can be removed.
+// Values stored before encryption was enabled are encrypted too. It returns
+// how many entries were updated.
+func (l *AuditLogger) Reencrypt(ctx context.Context) (int, error) {
+	updated, lastID := 0, 0
+
+	for {
+		var n, read int
+
+		err := WithTx(ctx, l.db, func(tx *sql.Tx) error {
+			var err error
+			n, read, lastID, err = l.reencryptBatch(ctx, tx, lastID)
+			return err
+		})
+		if err != nil {
+			return updated, err
+		}
+
+		updated += n
+		if read < reencryptBatchSize {
+			return updated, nil
+		}
+	}
+}
+
+// reencryptBatch re-encrypts the values of the next reencryptBatchSize
+// entries after afterID, returning how many it updated and read and the
+// last id read.
+func (l *AuditLogger) reencryptBatch(ctx context.Context, tx *sql.Tx, afterID int) (updated, read, lastID int, err error) {
+	rows, err := tx.QueryContext(ctx, `select id, old_value, new_value from audit_logs
+		where id > $1 and (old_value is not null or new_value is not null) order by id limit $2 for update`, afterID, reencryptBatchSize)
+	if err != nil {
+		return 0, 0, afterID, err
+	}
+
+	type row struct {
+		id       int
+		oldValue *string
+		newValue *string
+	}
+
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.oldValue, &r.newValue); err != nil {
+			rows.Close()
+			return 0, 0, afterID, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, afterID, err
+	}
+
+	lastID = afterID
+	for _, r := range batch {
+		lastID = r.id
+
+		if l.current(r.oldValue) && l.current(r.newValue) {
+			continue
+		}
+
+		oldValue, err := l.reseal(r.oldValue)
+		if err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		newValue, err := l.reseal(r.newValue)
+		if err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		_, err = tx.ExecContext(ctx, "update audit_logs set old_value = $1, new_value = $2 where id = $3", oldValue, newValue, r.id)
+		if err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		updated++
+	}
+
+	return updated, len(batch), lastID, nil
+}
+
+func (l *AuditLogger) current(stored *string) bool {
+	return stored == nil || l.cipher.CurrentJSON([]byte(*stored))
+}
+
+// reseal decrypts the stored value and encrypts it again with the current
+// key, leaving nil as it is.
+func (l *AuditLogger) reseal(stored *string) (interface{}, error) {
+	if stored == nil {
+		return nil, nil
+	}
+
+	plaintext, err := l.cipher.DecryptJSON(valueField, []byte(*stored))
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := l.cipher.EncryptJSON(valueField, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(sealed), nil
+}
@@ -0,0 +1,106 @@
+// Package cache keeps recently read patients in memory or in Redis, so
+// repeated reads of the same records do not each reach Postgres.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache stores values by key for a while. Implementations are safe for
+// concurrent use.
+type Cache interface {
+	// Get returns the value stored under key, or false when there is none
+	// or it expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key for ttl, or until it is evicted when ttl is
+	// zero.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes keys, ignoring those that are not stored.
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Memory is a Cache in the memory of the process, evicting the least
+// recently used value once it holds maxEntries. Each process has its own, so
+// the values other instances change stay cached until they expire.
+type Memory struct {
+	mu         sync.Mutex
+	maxEntries int
+	// order holds the *memoryEntry values, most recently used first.
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemory returns an empty Memory holding up to maxEntries values.
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{maxEntries: maxEntries, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		m.remove(element)
+		return nil, false, nil
+	}
+
+	m.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := &memoryEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+
+	if element, ok := m.entries[key]; ok {
+		element.Value = entry
+		m.order.MoveToFront(element)
+		return nil
+	}
+
+	m.entries[key] = m.order.PushFront(entry)
+	for m.maxEntries > 0 && m.order.Len() > m.maxEntries {
+		m.remove(m.order.Back())
+	}
+
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := m.entries[key]; ok {
+			m.remove(element)
+		}
+	}
+
+	return nil
+}
+
+// remove drops element, with m.mu held.
+func (m *Memory) remove(element *list.Element) {
+	m.order.Remove(element)
+	delete(m.entries, element.Value.(*memoryEntry).key)
+}
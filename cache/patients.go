@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/encryption"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+// generationKey holds the generation of the cached patient lists. Every
+// write changes it, so lists cached before are no longer looked up and
+// expire unread.
+const generationKey = "patients:generation"
+
+// cacheField is the associated data of cached values encrypted with the PHI
+// cipher.
+const cacheField = "cache"
+
+// Patients is a PatientRepository caching the patients returned by Get for
+// patientTTL and the pages returned by List for listTTL. Create, Update,
+// Delete, Restore, Purge, Upsert, Merge and Erase invalidate the patients they
+// change and every cached page. A zero TTL disables that cache. Calls made
+// in a transaction carried by the context bypass the cache, so it never
+// holds uncommitted values. Values are cached per clinic scope of the
+// context, so a clinic never reads what another clinic cached. Cache failures are logged and the repository is
+// read instead. The other methods are not cached, and neither are pages
+// filtered on sharing consent, since granting or revoking a consent does not
+// invalidate the cached pages.
+type Patients struct {
+	store.PatientRepository
+
+	cache  Cache
+	cipher *encryption.Cipher
+
+	patientTTL time.Duration
+	listTTL    time.Duration
+}
+
+// NewPatients returns a Patients reading through to patients. Cached values
+// are encrypted with cipher unless it is nil, since they hold PHI.
+func NewPatients(patients store.PatientRepository, c Cache, cipher *encryption.Cipher, patientTTL, listTTL time.Duration) *Patients {
+	return &Patients{PatientRepository: patients, cache: c, cipher: cipher, patientTTL: patientTTL, listTTL: listTTL}
+}
+
+// page is a cached result of List.
+type page struct {
+	Patients []*store.Patient `json:"patients"`
+	Total    int              `json:"total"`
+}
+
+func (p *Patients) Get(ctx context.Context, id int, includeDeleted bool) (*store.Patient, error) {
+	scope, ok := clinicScope(ctx)
+	if p.patientTTL <= 0 || inTx(ctx) || !ok {
+		return p.PatientRepository.Get(ctx, id, includeDeleted)
+	}
+
+	key := patientKey(scope, id, includeDeleted)
+
+	cached := &store.Patient{}
+	if p.load(ctx, key, cached) {
+		return cached, nil
+	}
+
+	patient, err := p.PatientRepository.Get(ctx, id, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	p.save(ctx, key, patient, p.patientTTL)
+	return patient, nil
+}
+
+func (p *Patients) List(ctx context.Context, opts store.ListOptions) ([]*store.Patient, int, error) {
+	scope, ok := clinicScope(ctx)
+	if p.listTTL <= 0 || inTx(ctx) || !ok || opts.Filter.SharingConsent != "" {
+		return p.PatientRepository.List(ctx, opts)
+	}
+
+	key, err := p.listKey(ctx, scope, opts)
+	if err != nil {
+		return p.PatientRepository.List(ctx, opts)
+	}
+
+	var cached page
+	if p.load(ctx, key, &cached) {
+		return cached.Patients, cached.Total, nil
+	}
+
+	patients, total, err := p.PatientRepository.List(ctx, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p.save(ctx, key, page{Patients: patients, Total: total}, p.listTTL)
+	return patients, total, nil
+}
+
+func (p *Patients) Create(ctx context.Context, actor, name, email, phone string, demographics store.Demographics) (*store.Patient, error) {
+	patient, err := p.PatientRepository.Create(ctx, actor, name, email, phone, demographics)
+	p.invalidate(ctx)
+	return patient, err
+}
+
+func (p *Patients) Update(ctx context.Context, actor string, id int, changes store.PatientChanges) (*store.Patient, error) {
+	patient, err := p.PatientRepository.Update(ctx, actor, id, changes)
+	p.invalidate(ctx, id)
+	return patient, err
+}
+
+func (p *Patients) Delete(ctx context.Context, actor string, id int) (*store.Patient, error) {
+	patient, err := p.PatientRepository.Delete(ctx, actor, id)
+	p.invalidate(ctx, id)
+	return patient, err
+}
+
+func (p *Patients) Restore(ctx context.Context, actor string, id int) (*store.Patient, error) {
+	patient, err := p.PatientRepository.Restore(ctx, actor, id)
+	p.invalidate(ctx, id)
+	return patient, err
+}
+
+func (p *Patients) Purge(ctx context.Context, actor string, id int) (*store.Patient, error) {
+	patient, err := p.PatientRepository.Purge(ctx, actor, id)
+	p.invalidate(ctx, id)
+	return patient, err
+}
+
+func (p *Patients) Upsert(ctx context.Context, actor string, patients []*store.Patient) ([]store.UpsertResult, error) {
+	results, err := p.PatientRepository.Upsert(ctx, actor, patients)
+
+	var ids []int
+	for _, result := range results {
+		if result.Patient != nil {
+			ids = append(ids, result.Patient.ID)
+		}
+	}
+	p.invalidate(ctx, ids...)
+
+	return results, err
+}
+
+func (p *Patients) Merge(ctx context.Context, actor string, primaryID, duplicateID int) (*store.Patient, error) {
+	patient, err := p.PatientRepository.Merge(ctx, actor, primaryID, duplicateID)
+	p.invalidate(ctx, primaryID, duplicateID)
+	return patient, err
+}
+
+func (p *Patients) Erase(ctx context.Context, actor string, id int, justification string) ([]*store.Patient, error) {
+	erased, err := p.PatientRepository.Erase(ctx, actor, id, justification)
+
+	ids := []int{id}
+	for _, patient := range erased {
+		ids = append(ids, patient.ID)
+	}
+	p.invalidate(ctx, ids...)
+
+	return erased, err
+}
+
+// invalidate drops the cached patients with ids and starts a new generation
+// of cached lists. Writes are made for the clinic of their patients, so the
+// patients are dropped from the scope of that clinic and of every clinic.
+// A write in a transaction invalidates before it commits, so a read in
+// between may cache the old values again until they expire.
+func (p *Patients) invalidate(ctx context.Context, ids ...int) {
+	scopes := []string{allClinics}
+	if clinicID, ok := tenant.ClinicFromContext(ctx); ok {
+		scopes = append(scopes, strconv.Itoa(clinicID))
+	}
+
+	var keys []string
+	for _, scope := range scopes {
+		for _, id := range ids {
+			keys = append(keys, patientKey(scope, id, false), patientKey(scope, id, true))
+		}
+	}
+
+	if err := p.cache.Delete(ctx, keys...); err != nil {
+		slog.WarnContext(ctx, "could not invalidate cached patients", "patient_ids", ids, "error", err)
+	}
+
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := p.cache.Set(ctx, generationKey, []byte(generation), 0); err != nil {
+		slog.WarnContext(ctx, "could not invalidate cached patient lists", "error", err)
+	}
+}
+
+// listKey returns the key of the page opts selects in scope in the current
+// generation.
+func (p *Patients) listKey(ctx context.Context, scope string, opts store.ListOptions) (string, error) {
+	generation, _, err := p.cache.Get(ctx, generationKey)
+	if err != nil {
+		slog.WarnContext(ctx, "could not read the cached patient list generation", "error", err)
+		return "", err
+	}
+
+	encoded, err := json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+
+	return fmt.Sprintf("patients:list:%s:%s:%s", scope, generation, hex.EncodeToString(sum[:])), nil
+}
+
+// load decodes the value cached under key into v, reporting whether there
+// was one.
+func (p *Patients) load(ctx context.Context, key string, v interface{}) bool {
+	value, ok, err := p.cache.Get(ctx, key)
+	if err != nil {
+		slog.WarnContext(ctx, "could not read the patient cache", "key", key, "error", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	plaintext, err := p.cipher.Decrypt(cacheField, string(value))
+	if err == nil {
+		err = json.Unmarshal([]byte(plaintext), v)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "could not decode a cached patient value", "key", key, "error", err)
+		return false
+	}
+
+	return true
+}
+
+// save caches v under key for ttl.
+func (p *Patients) save(ctx context.Context, key string, v interface{}, ttl time.Duration) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		slog.WarnContext(ctx, "could not encode a patient value to cache", "key", key, "error", err)
+		return
+	}
+
+	sealed, err := p.cipher.Encrypt(cacheField, string(encoded))
+	if err == nil {
+		err = p.cache.Set(ctx, key, []byte(sealed), ttl)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "could not write the patient cache", "key", key, "error", err)
+	}
+}
+
+func patientKey(scope string, id int, includeDeleted bool) string {
+	return fmt.Sprintf("patient:%s:%d:%t", scope, id, includeDeleted)
+}
+
+// allClinics is the scope of contexts reading every clinic.
+const allClinics = "all"
+
+// clinicScope returns the scope ctx reads values in: the id of its clinic,
+// or allClinics under tenant.WithAllClinics. It is false when ctx acts for
+// no clinic, which the repository refuses.
+func clinicScope(ctx context.Context) (string, bool) {
+	if tenant.AllClinics(ctx) {
+		return allClinics, true
+	}
+
+	clinicID, ok := tenant.ClinicFromContext(ctx)
+	return strconv.Itoa(clinicID), ok
+}
+
+// inTx reports whether ctx carries a transaction, whose reads may see
+// changes other callers cannot yet.
+func inTx(ctx context.Context) bool {
+	_, ok := audit.TxFromContext(ctx)
+	return ok
+}
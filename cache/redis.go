@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix namespaces the keys of the service in a Redis shared with
+// others.
+const keyPrefix = "smart-emerge:"
+
+// Redis is a Cache in a Redis server, shared by every instance of the
+// service, so a write through any of them invalidates the values of all.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis connects to the Redis server at url, such as
+// redis://:password@localhost:6379/0, and checks that it answers.
+func NewRedis(ctx context.Context, url string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("could not reach redis: %w", err)
+	}
+
+	return &Redis{client: client}, nil
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.Get(ctx, keyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, keyPrefix+key, value, ttl).Err()
+}
+
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = keyPrefix + key
+	}
+
+	return r.client.Del(ctx, prefixed...).Err()
+}
+
+// Close closes the connections to the server.
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,30 @@
+package chain
+
+import (
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// RequireRoles fails the fields it wraps with utils.ErrUnauthenticated when
+// there is no signed in caller and with utils.ErrForbidden when the caller
+// has none of roles, before their resolver runs. Combine it with Only to
+// restrict some fields, such as
+//
+//	chain.Only(chain.Fields("Mutations.purge"), chain.RequireRoles(middleware.RoleAdmin))
+func RequireRoles(roles ...string) Middleware {
+	return func(field Field, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			if _, ok := middleware.UserIDFromContext(p.Context); !ok {
+				return nil, utils.ErrUnauthenticated
+			}
+
+			if !middleware.HasRole(p.Context, roles...) {
+				return nil, utils.ErrForbidden
+			}
+
+			return next(p)
+		}
+	}
+}
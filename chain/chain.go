@@ -0,0 +1,105 @@
+// Package chain composes middlewares around the GraphQL field resolvers, so
+// concerns shared by many fields, such as logging, metrics, tracing, role
+// checks and argument validation, are written once instead of in every
+// resolver. The schema registry applies them when it builds the schema.
+package chain
+
+import (
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+// Field is a field being wrapped, for middlewares to decide whether and how
+// to wrap its resolver.
+type Field struct {
+	// Object is the name of the type of the field, such as "Patient", or
+	// "Query" and "Mutations" for the root fields.
+	Object string
+	Name   string
+	// Root reports whether the field is a root query, mutation or
+	// subscription field.
+	Root       bool
+	Definition *graphql.FieldDefinition
+}
+
+// String returns the field as "Object.name", such as "Query.getPatient".
+func (f Field) String() string {
+	return f.Object + "." + f.Name
+}
+
+// Leaf reports whether the field returns scalars or enums, whose resolvers
+// only format a value of the object and run once per row.
+func (f Field) Leaf() bool {
+	switch graphql.GetNamed(f.Definition.Type).(type) {
+	case *graphql.Scalar, *graphql.Enum:
+		return true
+	}
+
+	return false
+}
+
+// Middleware wraps next, the resolver of field, once when the schema is
+// built. It returns next itself to leave the field alone. Resolvers
+// returning a thunk, such as those batching through a loader, return before
+// the thunk is resolved, so a middleware sees the thunk rather than its
+// result.
+type Middleware func(field Field, next graphql.FieldResolveFn) graphql.FieldResolveFn
+
+// Compose returns the middleware running middlewares in order, the first
+// one outermost.
+func Compose(middlewares ...Middleware) Middleware {
+	return func(field Field, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](field, next)
+		}
+
+		return next
+	}
+}
+
+// Only applies middleware to the fields match reports true for.
+func Only(match func(Field) bool, middleware Middleware) Middleware {
+	return func(field Field, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+		if !match(field) {
+			return next
+		}
+
+		return middleware(field, next)
+	}
+}
+
+// Fields matches the fields named, as "Object.name", by names.
+func Fields(names ...string) func(Field) bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+
+	return func(field Field) bool {
+		return set[field.String()]
+	}
+}
+
+// Apply wraps the resolver of every field of s that has one of its own in
+// middleware. Fields left to the default resolver of graphql-go, which reads
+// the field of the source value, are not wrapped.
+func Apply(s graphql.Schema, middleware Middleware) {
+	roots := map[*graphql.Object]bool{s.QueryType(): true, s.MutationType(): true, s.SubscriptionType(): true}
+
+	for name, t := range s.TypeMap() {
+		object, ok := t.(*graphql.Object)
+		if !ok || strings.HasPrefix(name, "__") {
+			continue
+		}
+
+		for fieldName, definition := range object.Fields() {
+			if definition.Resolve == nil {
+				continue
+			}
+
+			field := Field{Object: name, Name: fieldName, Root: roots[object], Definition: definition}
+			definition.Resolve = middleware(field, definition.Resolve)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package chain
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// Logging logs the root fields and the fields returning objects as they are
+// resolved, with how long they took and the code of their error, at DEBUG
+// level, and at WARN level when they took longer than slow, unless slow is
+// zero or less. Fields returning scalars are left alone so they do not log a
+// line per row. Error messages are not logged, as they may hold patient
+// data.
+func Logging(log *slog.Logger, slow time.Duration) Middleware {
+	return func(field Field, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+		if !field.Root && field.Leaf() {
+			return next
+		}
+
+		name := field.String()
+
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			start := time.Now()
+			result, err := next(p)
+			elapsed := time.Since(start)
+
+			level, message := slog.LevelDebug, "resolved field"
+			if slow > 0 && elapsed > slow {
+				level, message = slog.LevelWarn, "slow resolver"
+			}
+
+			if log.Enabled(p.Context, level) {
+				attrs := []interface{}{"field", name, "duration_ms", float64(elapsed.Microseconds()) / 1000}
+				if err != nil {
+					attrs = append(attrs, "error_code", ErrorCode(err))
+				}
+
+				log.Log(p.Context, level, message, attrs...)
+			}
+
+			return result, err
+		}
+	}
+}
+
+// ErrorCode returns the code in the extensions of err, or "UNKNOWN".
+func ErrorCode(err error) string {
+	var extended gqlerrors.ExtendedError
+	if errors.As(err, &extended) {
+		if code, ok := extended.Extensions()["code"].(string); ok {
+			return code
+		}
+	}
+
+	return "UNKNOWN"
+}
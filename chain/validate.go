@@ -0,0 +1,67 @@
+package chain
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// MaxStringLength rejects the string arguments longer than max characters,
+// including those in input objects and lists, before the resolver runs,
+// with validation.Errors naming each of them as "patient.name" or
+// "ids[2]". It bounds the input of every field, those without a limit of
+// their own included. Fields without arguments are left alone.
+func MaxStringLength(max int) Middleware {
+	return func(field Field, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+		if len(field.Definition.Args) == 0 {
+			return next
+		}
+
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			if errs := checkLength(nil, "", p.Args, max); len(errs) > 0 {
+				return nil, errs
+			}
+
+			return next(p)
+		}
+	}
+}
+
+// checkLength appends to errs an error for each string in value, the
+// argument or input field at path, longer than max characters. The
+// arguments themselves are at the empty path.
+func checkLength(errs validation.Errors, path string, value interface{}, max int) validation.Errors {
+	switch value := value.(type) {
+	case string:
+		if utf8.RuneCountInString(value) > max {
+			errs = append(errs, validation.FieldError{Field: path, Message: fmt.Sprintf("%s must be at most %d characters", path, max)})
+		}
+	case map[string]interface{}:
+		// The arguments are checked in order of name, so the errors are
+		// listed the same way every time.
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+
+			errs = checkLength(errs, fieldPath, value[name], max)
+		}
+	case []interface{}:
+		for i, item := range value {
+			errs = checkLength(errs, fmt.Sprintf("%s[%d]", path, i), item, max)
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/config"
+	"github.com/codixir/smart-emerge-starter/encryption"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/migrate"
+	"github.com/codixir/smart-emerge-starter/migrations"
+	"github.com/codixir/smart-emerge-starter/outbox"
+	"github.com/codixir/smart-emerge-starter/persisted"
+	"github.com/codixir/smart-emerge-starter/seed"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+// cliActor is who the audit log records the writes of commands as.
+const cliActor = "cli"
+
+// runMigrate applies the pending migrations, or reverts the newest -steps of
+// them with down.
+func runMigrate(args []string) error {
+	if len(args) == 0 || args[0] != "up" && args[0] != "down" {
+		return fmt.Errorf("usage: migrate up|down [-steps n]")
+	}
+	direction := args[0]
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	steps := flags.Int("steps", 1, "how many migrations down reverts")
+	flags.Parse(args[1:])
+
+	if *steps < 1 {
+		return fmt.Errorf("-steps must be at least 1, got %d", *steps)
+	}
+
+	cfg, err := loadConfig(config.LoadForMigrations)
+	if err != nil {
+		return err
+	}
+
+	db, err := openPostgres(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if direction == "down" {
+		return migrate.Rollback(db, migrations.FS, *steps)
+	}
+
+	return migrate.RunMigrations(db, migrations.FS)
+}
+
+// rotateKeys re-encrypts the PHI of patients, emergency contacts and
+// insurance policies with the current key and recomputes their blind
+// indexes, then re-encrypts the audit log values and outbox payloads, which
+// copy it.
+func rotateKeys(args []string) error {
+	flag.NewFlagSet("rotate-keys", flag.ExitOnError).Parse(args)
+
+	cfg, err := loadConfig(config.LoadForKeyRotation)
+	if err != nil {
+		return err
+	}
+
+	cipher, err := encryption.New(cfg.Encryption.Keys, cfg.Encryption.IndexKey)
+	if err != nil {
+		return err
+	}
+
+	db, err := openMigrated(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	auditLogger := audit.NewAuditLogger(db, cipher)
+	ctx := context.Background()
+
+	updated, err := store.NewPatientStore(db, nil, auditLogger, nil, cipher, 0).Reencrypt(ctx)
+	if err != nil {
+		return err
+	}
+
+	updatedContacts, err := store.NewEmergencyContactStore(db, auditLogger, cipher).Reencrypt(ctx)
+	if err != nil {
+		return err
+	}
+
+	updatedPolicies, err := store.NewInsurancePolicyStore(db, auditLogger, cipher).Reencrypt(ctx)
+	if err != nil {
+		return err
+	}
+
+	updatedEntries, err := auditLogger.Reencrypt(ctx)
+	if err != nil {
+		return err
+	}
+
+	updatedEvents, err := outbox.New(db, cipher).Reencrypt(ctx)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("patient PHI re-encrypted", "key", cfg.Encryption.Keys[0].ID, "updated", updated, "updated_contacts", updatedContacts,
+		"updated_policies", updatedPolicies, "updated_audit_entries", updatedEntries, "updated_events", updatedEvents)
+	return nil
+}
+
+// approveQueries approves the queries of the persisted query manifest named
+// by the argument.
+func approveQueries(args []string) error {
+	flags := flag.NewFlagSet("approve-queries", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: approve-queries <manifest>")
+	}
+	path := flags.Arg(0)
+
+	cfg, err := loadConfig(config.LoadForMigrations)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := persisted.ReadManifest(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	db, err := openMigrated(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	approved, err := persisted.Approve(context.Background(), db, manifest)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("persisted queries approved", "manifest", path, "approved", approved)
+	return nil
+}
+
+// seedCommand creates -count made up patients in -clinic, through the
+// patient store, so they are encrypted and audited like any other. It is
+// refused with APP_ENV=production.
+func seedCommand(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	count := flags.Int("count", 100, "how many patients to create")
+	clinic := flags.Int("clinic", 1, "the clinic the patients belong to")
+	flags.Parse(args)
+
+	if *count < 1 {
+		return fmt.Errorf("-count must be at least 1, got %d", *count)
+	}
+
+	cfg, err := loadConfig(config.LoadForSeeding)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Server.Production {
+		return fmt.Errorf("seed is refused with APP_ENV=production")
+	}
+
+	var cipher *encryption.Cipher
+	if cfg.Encryption.Enabled() {
+		if cipher, err = encryption.New(cfg.Encryption.Keys, cfg.Encryption.IndexKey); err != nil {
+			return err
+		}
+	}
+
+	db, err := openMigrated(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Seeded patients publish no events, so webhooks are not flooded.
+	patients := store.NewPatientStore(db, nil, audit.NewAuditLogger(db, cipher), nil, cipher, 0)
+
+	start := time.Now()
+	created, err := seed.Patients(tenant.WithClinic(context.Background(), *clinic), patients, cliActor, *count)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("patients seeded", "clinic", *clinic, "count", *count, "created", created, "updated", *count-created,
+		"elapsed_ms", time.Since(start).Milliseconds())
+	return nil
+}
+
+// createUser prints a bearer token for -subject with -role. Users are not
+// stored: the server trusts every token signed with JWT_SECRET, so the
+// token is all a user is.
+func createUser(args []string) error {
+	flags := flag.NewFlagSet("create-user", flag.ExitOnError)
+	subject := flags.String("subject", "", "the user id, the sub claim of the token")
+	role := flags.String("role", "", fmt.Sprintf("the role of the user, one of %v", middleware.Roles))
+	clinic := flags.Int("clinic", 0, "the clinic the user belongs to, 0 for none")
+	ttl := flags.Duration("ttl", 24*time.Hour, "how long the token is valid")
+	flags.Parse(args)
+
+	if *subject == "" {
+		return fmt.Errorf("-subject must be set")
+	}
+
+	valid := false
+	for _, r := range middleware.Roles {
+		valid = valid || r == *role
+	}
+	if !valid {
+		return fmt.Errorf("-role must be one of %v, got %q", middleware.Roles, *role)
+	}
+
+	if *clinic < 0 {
+		return fmt.Errorf("-clinic must not be negative, got %d", *clinic)
+	}
+
+	if *ttl <= 0 {
+		return fmt.Errorf("-ttl must be positive, got %s", *ttl)
+	}
+
+	cfg, err := loadConfig(config.Load)
+	if err != nil {
+		return err
+	}
+
+	var clinicID *int
+	if *clinic > 0 {
+		clinicID = clinic
+	}
+
+	token, err := middleware.IssueToken(cfg.Server.JWTSecret, *subject, []string{*role}, clinicID, *ttl)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("token issued", "subject", *subject, "role", *role, "clinic", *clinic, "ttl", ttl.String())
+	fmt.Println(token)
+	return nil
+}
+
+// reindex rebuilds the indexes of the patients table and refreshes its
+// statistics, after bulk imports or seeding.
+func reindex(args []string) error {
+	flag.NewFlagSet("reindex", flag.ExitOnError).Parse(args)
+
+	cfg, err := loadConfig(config.LoadForMigrations)
+	if err != nil {
+		return err
+	}
+
+	db, err := openMigrated(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	start := time.Now()
+	if err := store.NewPatientStore(db, nil, nil, nil, nil, 0).Reindex(context.Background()); err != nil {
+		return err
+	}
+
+	slog.Info("patient indexes rebuilt", "elapsed_ms", time.Since(start).Milliseconds())
+	return nil
+}
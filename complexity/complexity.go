@@ -0,0 +1,189 @@
+// Package complexity measures the depth and estimated cost of a GraphQL
+// query before it is executed, so expensive queries can be refused without
+// touching the database.
+package complexity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// Limits are the largest depth and complexity a query may have. A zero
+// value disables that check.
+type Limits struct {
+	MaxDepth      int
+	MaxComplexity int
+	// DisableIntrospection refuses queries selecting __schema or __type.
+	DisableIntrospection bool
+}
+
+// MaxNodes is the most selections Analyze walks. Fragments are measured
+// once however often they are spread, so only a query this large in itself
+// reaches it.
+const MaxNodes = 10000
+
+// ErrTooManyNodes is returned by Analyze and Check for a query with more
+// than MaxNodes selections.
+var ErrTooManyNodes = fmt.Errorf("query has more than %d selections", MaxNodes)
+
+// maxCost caps the measured complexity, which grows exponentially with
+// fragments spreading each other several times.
+const maxCost = math.MaxInt32
+
+// Costs maps a field name to its cost. Fields not listed cost 1, and the
+// cost of a field is added to the cost of everything selected below it.
+type Costs map[string]int
+
+// Result is the measured depth and complexity of an operation.
+type Result struct {
+	Depth      int
+	Complexity int
+	// Introspection is set when the operation selects __schema or __type.
+	// __typename alone does not count.
+	Introspection bool
+}
+
+// Analyze measures the operation named operationName in query, or its only
+// operation when the name is empty. Introspection fields are not counted.
+func Analyze(query, operationName string, costs Costs) (Result, error) {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return Result{}, err
+	}
+
+	a := &analyzer{costs: costs, fragments: map[string]*ast.FragmentDefinition{}, measured: map[string]measure{}}
+	var operation *ast.OperationDefinition
+
+	for _, definition := range doc.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			a.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			name := ""
+			if definition.Name != nil {
+				name = definition.Name.Value
+			}
+			if operationName == "" || name == operationName {
+				operation = definition
+			}
+		}
+	}
+
+	if operation == nil {
+		return Result{}, fmt.Errorf("complexity: operation %q not found", operationName)
+	}
+
+	m := a.selectionSet(operation.SelectionSet, map[string]bool{})
+	if a.nodes > MaxNodes {
+		return Result{}, ErrTooManyNodes
+	}
+
+	return Result{Depth: m.depth, Complexity: m.cost, Introspection: a.introspection}, nil
+}
+
+// Check returns an error describing the first limit the operation exceeds.
+// Queries that fail to parse are let through, so the executor can report the
+// syntax error itself, but queries with more than MaxNodes selections fail
+// with ErrTooManyNodes.
+func Check(query, operationName string, costs Costs, limits Limits) error {
+	result, err := Analyze(query, operationName, costs)
+	if errors.Is(err, ErrTooManyNodes) {
+		return err
+	}
+	if err != nil {
+		return nil
+	}
+
+	if limits.DisableIntrospection && result.Introspection {
+		return fmt.Errorf("introspection is not allowed")
+	}
+
+	if limits.MaxDepth > 0 && result.Depth > limits.MaxDepth {
+		return fmt.Errorf("query depth %d exceeds the maximum of %d", result.Depth, limits.MaxDepth)
+	}
+
+	if limits.MaxComplexity > 0 && result.Complexity > limits.MaxComplexity {
+		return fmt.Errorf("query complexity %d exceeds the maximum of %d", result.Complexity, limits.MaxComplexity)
+	}
+
+	return nil
+}
+
+type analyzer struct {
+	costs     Costs
+	fragments map[string]*ast.FragmentDefinition
+	// measured holds the depth and cost of the fragments already walked.
+	measured      map[string]measure
+	nodes         int
+	introspection bool
+}
+
+type measure struct {
+	depth, cost int
+}
+
+// selectionSet returns the depth and cost of set. visiting holds the
+// fragments being expanded, to stop on cyclic spreads. It stops once more
+// than MaxNodes selections were walked.
+func (a *analyzer) selectionSet(set *ast.SelectionSet, visiting map[string]bool) measure {
+	var m measure
+	if set == nil {
+		return m
+	}
+
+	for _, selection := range set.Selections {
+		if a.nodes++; a.nodes > MaxNodes {
+			return m
+		}
+
+		var s measure
+
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if name := selection.Name.Value; strings.HasPrefix(name, "__") {
+				a.introspection = a.introspection || name == "__schema" || name == "__type"
+				continue
+			}
+
+			s = a.selectionSet(selection.SelectionSet, visiting)
+			s.depth++
+			s.cost = min(s.cost+a.cost(selection.Name.Value), maxCost)
+		case *ast.InlineFragment:
+			s = a.selectionSet(selection.SelectionSet, visiting)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragment, ok := a.fragments[name]
+			if !ok || visiting[name] {
+				continue
+			}
+
+			if measured, ok := a.measured[name]; ok {
+				s = measured
+				break
+			}
+
+			visiting[name] = true
+			s = a.selectionSet(fragment.SelectionSet, visiting)
+			delete(visiting, name)
+			a.measured[name] = s
+		}
+
+		m.depth = max(m.depth, s.depth)
+		m.cost = min(m.cost+s.cost, maxCost)
+	}
+
+	return m
+}
+
+func (a *analyzer) cost(field string) int {
+	if cost, ok := a.costs[field]; ok {
+		return cost
+	}
+
+	return 1
+}
@@ -0,0 +1,124 @@
+package complexity
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	limits := Limits{MaxDepth: 3, MaxComplexity: 10, DisableIntrospection: true}
+	costs := Costs{"patients": 5}
+
+	tests := []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{"within limits", `{ patient(id: 1) { id name } }`, ""},
+		{"depth at limit", `{ a { b { c } } }`, ""},
+		{"depth over limit", `{ a { b { c { d } } } }`, "query depth 4 exceeds the maximum of 3"},
+		{"depth through fragment", `{ a { ...F } } fragment F on T { b { c { d } } }`, "query depth 4 exceeds the maximum of 3"},
+		{"cost over limit", `{ patients { id name } other { id } more { id } }`, "query complexity 11 exceeds the maximum of 10"},
+		{"cost of spreads", `{ ...F ...F } fragment F on T { patients }`, ""},
+		{"cost of spreads over limit", `{ ...F ...F ...F } fragment F on T { patients }`, "query complexity 15 exceeds the maximum of 10"},
+		{"schema introspection", `{ __schema { types { name } } }`, "introspection is not allowed"},
+		{"type introspection in fragment", `{ ...F } fragment F on Query { __type(name: "Patient") { name } }`, "introspection is not allowed"},
+		{"typename", `{ patient(id: 1) { __typename id } }`, ""},
+		{"cyclic fragments", `{ ...A } fragment A on T { a ...B } fragment B on T { b ...A }`, ""},
+		{"syntax error", `{ patient(`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.query, "", costs, limits)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("Check() = %v, want nil", err)
+			case tt.err != "" && (err == nil || err.Error() != tt.err):
+				t.Fatalf("Check() = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAnalyzeOperationName(t *testing.T) {
+	query := `query Small { a } query Deep { a { b { c } } }`
+
+	tests := []struct {
+		operation string
+		depth     int
+	}{
+		{"Small", 1},
+		{"Deep", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.operation, func(t *testing.T) {
+			result, err := Analyze(query, tt.operation, nil)
+			if err != nil {
+				t.Fatalf("Analyze() error = %v", err)
+			}
+			if result.Depth != tt.depth {
+				t.Fatalf("Analyze() depth = %d, want %d", result.Depth, tt.depth)
+			}
+		})
+	}
+}
+
+// fragmentBomb returns a query of n fragments each spreading the next one
+// twice, whose expansion has 2^n fields.
+func fragmentBomb(n int) string {
+	var b strings.Builder
+	b.WriteString("{ ...F0 }")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, " fragment F%d on T { ...F%d ...F%d }", i, i+1, i+1)
+	}
+	fmt.Fprintf(&b, " fragment F%d on T { a }", n)
+
+	return b.String()
+}
+
+func TestFragmentBomb(t *testing.T) {
+	tests := []struct {
+		name       string
+		fragments  int
+		complexity int
+	}{
+		{"small", 4, 16},
+		{"large", 40, maxCost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			result, err := Analyze(fragmentBomb(tt.fragments), "", nil)
+			if err != nil {
+				t.Fatalf("Analyze() error = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("Analyze() took %v", elapsed)
+			}
+			if result.Complexity != tt.complexity {
+				t.Fatalf("Analyze() complexity = %d, want %d", result.Complexity, tt.complexity)
+			}
+		})
+	}
+
+	if err := Check(fragmentBomb(40), "", nil, Limits{MaxComplexity: 1000}); err == nil {
+		t.Fatal("Check() = nil, want the complexity limit exceeded")
+	}
+}
+
+func TestTooManyNodes(t *testing.T) {
+	query := "{" + strings.Repeat(" a", MaxNodes+1) + " }"
+
+	if _, err := Analyze(query, "", nil); !errors.Is(err, ErrTooManyNodes) {
+		t.Fatalf("Analyze() error = %v, want ErrTooManyNodes", err)
+	}
+	if err := Check(query, "", nil, Limits{}); !errors.Is(err, ErrTooManyNodes) {
+		t.Fatalf("Check() = %v, want ErrTooManyNodes", err)
+	}
+}
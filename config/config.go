@@ -0,0 +1,1059 @@
+// Package config loads the service settings from environment variables,
+// applying defaults and validating them before anything is started.
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/encryption"
+	"github.com/codixir/smart-emerge-starter/persisted"
+	"github.com/codixir/smart-emerge-starter/server"
+)
+
+// Config holds every setting of the service.
+type Config struct {
+	Database
+	// Server holds the HTTP server settings.
+	Server   server.Config
+	LogLevel slog.Level
+	// ShutdownTimeout is how long open requests may run after SIGINT or
+	// SIGTERM.
+	ShutdownTimeout time.Duration
+	// IdempotencyTTL is how long the idempotency key of a create mutation
+	// is kept, so a retry of the request within it is not created again.
+	IdempotencyTTL time.Duration
+	// ExplainCostThreshold logs getPatients query plans above this cost; zero
+	// disables it.
+	ExplainCostThreshold float64
+	// SlowResolverThreshold logs the GraphQL resolvers taking longer at WARN
+	// level; zero disables it.
+	SlowResolverThreshold time.Duration
+	// MaxStringArgumentLength is the longest string argument of a GraphQL
+	// field, in characters; zero disables the limit.
+	MaxStringArgumentLength int
+	// TLSCertFile and TLSKeyFile, when set, make the server listen for HTTPS.
+	TLSCertFile string
+	TLSKeyFile  string
+	// AutocertDomains, when set instead, make the server listen for HTTPS
+	// with certificates obtained from Let's Encrypt for these domains, kept
+	// in AutocertCacheDir. AutocertEmail is given to Let's Encrypt to warn
+	// about expiring certificates.
+	AutocertDomains  []string
+	AutocertCacheDir string
+	AutocertEmail    string
+	// HTTPRedirectAddr, when set, is a plain HTTP address redirecting to
+	// HTTPS, which also answers the ACME challenges of autocert.
+	HTTPRedirectAddr string
+	Encryption       Encryption
+	Cache            Cache
+	Events           Events
+	Reminders        Reminders
+	Documents        Documents
+	// PersistedQueries is which queries are run, by hash or by text.
+	PersistedQueries persisted.Mode
+}
+
+// Events holds where patient lifecycle events are published.
+type Events struct {
+	// WebhookURLs receive every event, signed with WebhookSecret.
+	WebhookURLs   []string
+	WebhookSecret string
+	// NATSURL is the NATS server events are published to, on subjects under
+	// NATSSubjectPrefix.
+	NATSURL           string
+	NATSSubjectPrefix string
+	// PollInterval is how often the outbox is checked for events to deliver,
+	// and Timeout how long one delivery may take.
+	PollInterval time.Duration
+	Timeout      time.Duration
+	// MaxAttempts is how many times an event is tried before it is given up.
+	MaxAttempts int
+}
+
+// Enabled reports whether events are published.
+func (e Events) Enabled() bool {
+	return len(e.WebhookURLs) > 0 || e.NATSURL != ""
+}
+
+// Reminders holds how appointment reminders are sent.
+type Reminders struct {
+	// SMTPHost, when set, is the SMTP server reminders are emailed through,
+	// from SMTPFrom, authenticating with SMTPUsername and SMTPPassword
+	// unless SMTPUsername is empty.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	// TwilioAccountSID, when set, is the Twilio account reminders are sent
+	// by SMS with, from the number TwilioFrom.
+	TwilioAccountSID string
+	TwilioAuthToken  string
+	TwilioFrom       string
+	// Lead is how long before an appointment its reminders are sent.
+	Lead time.Duration
+	// PollInterval is how often reminders are looked for, and Timeout how
+	// long one send may take.
+	PollInterval time.Duration
+	Timeout      time.Duration
+	// MaxAttempts is how many times a reminder is tried before it is given
+	// up.
+	MaxAttempts int
+	// Location is the time zone of the appointment times in reminders.
+	Location *time.Location
+}
+
+// Enabled reports whether reminders are sent on any channel.
+func (r Reminders) Enabled() bool {
+	return r.SMTPHost != "" || r.TwilioAccountSID != ""
+}
+
+// Documents holds where the files attached to patients are kept.
+type Documents struct {
+	// Storage is "local", "s3", or empty to disable documents.
+	Storage string
+	// Dir is the directory of the local storage.
+	Dir string
+	// S3Bucket is the bucket of the s3 storage in S3Region, reached at
+	// S3Endpoint when set, for S3 compatible services, and at AWS otherwise.
+	S3Bucket          string
+	S3Region          string
+	S3Endpoint        string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3SessionToken    string
+	// MaxBytes bounds the size of an uploaded file.
+	MaxBytes int64
+	// URLTTL is how long a download link stays valid.
+	URLTTL time.Duration
+	// SweepInterval is how often the files of deleted documents are removed
+	// from the storage.
+	SweepInterval time.Duration
+}
+
+// Enabled reports whether documents can be attached.
+func (d Documents) Enabled() bool {
+	return d.Storage != ""
+}
+
+// Cache holds the settings of the patient read cache.
+type Cache struct {
+	// Backend is "memory", "redis", or empty to disable the cache.
+	Backend string
+	// RedisURL is the server of the redis backend.
+	RedisURL string
+	// MaxEntries bounds the values the memory backend holds.
+	MaxEntries int
+	// PatientTTL and ListTTL are how long a patient and a page of patients
+	// stay cached; zero disables that cache.
+	PatientTTL time.Duration
+	ListTTL    time.Duration
+}
+
+// Enabled reports whether patient reads are cached.
+func (c Cache) Enabled() bool {
+	return c.Backend != ""
+}
+
+// Encryption holds the keys encrypting patient emails and phones at rest.
+// Without keys they are stored in plaintext.
+type Encryption struct {
+	// Keys are the AES-256 keys, the first one encrypting new values.
+	Keys []encryption.Key
+	// IndexKey keys the blind indexes the encrypted values are looked up by.
+	IndexKey []byte
+}
+
+// Enabled reports whether emails and phones are encrypted.
+func (e Encryption) Enabled() bool {
+	return len(e.Keys) > 0
+}
+
+// The database drivers DB_DRIVER chooses between.
+const (
+	DriverPostgres = "postgres"
+	// DriverMemory keeps the records in the memory of the process, for
+	// local development without a Postgres server.
+	DriverMemory = "memory"
+)
+
+// Database holds the settings needed to connect to the database.
+type Database struct {
+	// Driver is DriverPostgres or DriverMemory.
+	Driver string
+	// URL is the lib/pq connection string converted from DB_URL.
+	URL string
+	// ReplicaURL is the connection string of the read replica converted
+	// from DB_REPLICA_URL, empty when there is none. The replica uses the
+	// same pool settings as the primary.
+	ReplicaURL string
+	Pool       Pool
+}
+
+// Memory reports whether the records are kept in memory rather than in
+// Postgres.
+func (d Database) Memory() bool {
+	return d.Driver == DriverMemory
+}
+
+// Pool holds the database connection pool settings.
+type Pool struct {
+	MaxOpen     int
+	MaxIdle     int
+	MaxLifetime time.Duration
+}
+
+// TLS reports whether the server listens for HTTPS.
+func (c Config) TLS() bool {
+	return c.TLSCertFile != "" || c.Autocert()
+}
+
+// Autocert reports whether the HTTPS certificates come from Let's Encrypt.
+func (c Config) Autocert() bool {
+	return len(c.AutocertDomains) > 0
+}
+
+// LoadDotEnv loads the variables in a .env file in the working directory
+// when there is one. Variables already set in the environment take
+// precedence, so containers can be configured without the file.
+func LoadDotEnv() error {
+	err := godotenv.Load()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading .env: %w", err)
+	}
+
+	return nil
+}
+
+// Load reads the settings from the environment, returning a descriptive error
+// for the first invalid one.
+func Load() (Config, error) {
+	var cfg Config
+	var err error
+
+	if cfg, err = LoadForMigrations(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Server, err = serverConfig(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Encryption, err = encryptionConfig(); err != nil {
+		return cfg, err
+	}
+
+	shutdownSeconds, err := envInt("SHUTDOWN_TIMEOUT_SECONDS", 15)
+	if err != nil {
+		return cfg, err
+	}
+	if shutdownSeconds < 0 {
+		return cfg, fmt.Errorf("SHUTDOWN_TIMEOUT_SECONDS must not be negative, got %d", shutdownSeconds)
+	}
+	cfg.ShutdownTimeout = time.Duration(shutdownSeconds) * time.Second
+
+	idempotencyHours, err := envInt("IDEMPOTENCY_KEY_TTL_HOURS", 24)
+	if err != nil {
+		return cfg, err
+	}
+	if idempotencyHours < 1 {
+		return cfg, fmt.Errorf("IDEMPOTENCY_KEY_TTL_HOURS must be at least 1, got %d", idempotencyHours)
+	}
+	cfg.IdempotencyTTL = time.Duration(idempotencyHours) * time.Hour
+
+	if cfg.ExplainCostThreshold, err = envFloat("EXPLAIN_COST_THRESHOLD", 0); err != nil {
+		return cfg, err
+	}
+
+	slowResolverMillis, err := envInt("SLOW_RESOLVER_MS", 1000)
+	if err != nil {
+		return cfg, err
+	}
+	if slowResolverMillis < 0 {
+		return cfg, fmt.Errorf("SLOW_RESOLVER_MS must not be negative, got %d", slowResolverMillis)
+	}
+	cfg.SlowResolverThreshold = time.Duration(slowResolverMillis) * time.Millisecond
+
+	if cfg.MaxStringArgumentLength, err = envInt("MAX_STRING_ARGUMENT_LENGTH", 65536); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxStringArgumentLength < 0 {
+		return cfg, fmt.Errorf("MAX_STRING_ARGUMENT_LENGTH must not be negative, got %d", cfg.MaxStringArgumentLength)
+	}
+
+	if err := tlsConfig(&cfg); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Cache, err = cacheConfig(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Events, err = eventsConfig(cfg.Server.Production); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Reminders, err = remindersConfig(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Documents, err = documentsConfig(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.PersistedQueries, err = persistedQueriesMode(cfg.Database.Memory()); err != nil {
+		return cfg, err
+	}
+
+	// The outbox, the reminders, the documents and the persisted queries are
+	// tables of their own, which the memory driver does not have.
+	if cfg.Database.Memory() {
+		if cfg.Events.Enabled() {
+			return cfg, fmt.Errorf("WEBHOOK_URLS and NATS_URL need DB_DRIVER=postgres")
+		}
+		if cfg.Reminders.Enabled() {
+			return cfg, fmt.Errorf("SMTP_HOST and TWILIO_ACCOUNT_SID need DB_DRIVER=postgres")
+		}
+		if cfg.Documents.Enabled() {
+			return cfg, fmt.Errorf("DOCUMENT_STORAGE needs DB_DRIVER=postgres")
+		}
+		if cfg.PersistedQueries != persisted.Off {
+			return cfg, fmt.Errorf("PERSISTED_QUERIES needs DB_DRIVER=postgres, set it to off")
+		}
+	}
+
+	return cfg, nil
+}
+
+// remindersConfig reads the SMTP and Twilio settings of the reminder
+// channels, and when and how often reminders are sent.
+func remindersConfig() (Reminders, error) {
+	r := Reminders{
+		SMTPHost:         os.Getenv("SMTP_HOST"),
+		SMTPUsername:     os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:     os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:         os.Getenv("SMTP_FROM"),
+		TwilioAccountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+		TwilioAuthToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+		TwilioFrom:       os.Getenv("TWILIO_FROM_NUMBER"),
+	}
+
+	var err error
+	if r.SMTPPort, err = envInt("SMTP_PORT", 587); err != nil {
+		return r, err
+	}
+	if r.SMTPPort < 1 || r.SMTPPort > 65535 {
+		return r, fmt.Errorf("SMTP_PORT must be a port number, got %d", r.SMTPPort)
+	}
+
+	if r.SMTPHost != "" {
+		if _, err := mail.ParseAddress(r.SMTPFrom); err != nil {
+			return r, fmt.Errorf("SMTP_FROM must be an email address when SMTP_HOST is set: %v", err)
+		}
+	}
+
+	if r.TwilioAccountSID != "" && (r.TwilioAuthToken == "" || r.TwilioFrom == "") {
+		return r, fmt.Errorf("TWILIO_AUTH_TOKEN and TWILIO_FROM_NUMBER must be set when TWILIO_ACCOUNT_SID is set")
+	}
+
+	for _, duration := range []struct {
+		name  string
+		value *time.Duration
+		def   int
+		unit  time.Duration
+	}{
+		{"REMINDER_LEAD_HOURS", &r.Lead, 24, time.Hour},
+		{"REMINDER_POLL_INTERVAL_SECONDS", &r.PollInterval, 60, time.Second},
+		{"REMINDER_SEND_TIMEOUT_SECONDS", &r.Timeout, 10, time.Second},
+	} {
+		value, err := envInt(duration.name, duration.def)
+		if err != nil {
+			return r, err
+		}
+		if value < 1 {
+			return r, fmt.Errorf("%s must be positive, got %d", duration.name, value)
+		}
+		*duration.value = time.Duration(value) * duration.unit
+	}
+
+	if r.MaxAttempts, err = envInt("REMINDER_MAX_ATTEMPTS", 5); err != nil {
+		return r, err
+	}
+	if r.MaxAttempts < 1 {
+		return r, fmt.Errorf("REMINDER_MAX_ATTEMPTS must be positive, got %d", r.MaxAttempts)
+	}
+
+	zone := os.Getenv("REMINDER_TIME_ZONE")
+	if zone == "" {
+		zone = "UTC"
+	}
+	if r.Location, err = time.LoadLocation(zone); err != nil {
+		return r, fmt.Errorf("REMINDER_TIME_ZONE must be an IANA time zone such as Europe/Berlin, got %q", zone)
+	}
+
+	return r, nil
+}
+
+// documentsConfig reads the storage of documents and the limits of their
+// uploads and download links.
+func documentsConfig() (Documents, error) {
+	d := Documents{
+		Storage:           strings.ToLower(os.Getenv("DOCUMENT_STORAGE")),
+		Dir:               os.Getenv("DOCUMENT_DIR"),
+		S3Bucket:          os.Getenv("DOCUMENT_S3_BUCKET"),
+		S3Region:          os.Getenv("DOCUMENT_S3_REGION"),
+		S3Endpoint:        os.Getenv("DOCUMENT_S3_ENDPOINT"),
+		S3AccessKeyID:     os.Getenv("DOCUMENT_S3_ACCESS_KEY_ID"),
+		S3SecretAccessKey: os.Getenv("DOCUMENT_S3_SECRET_ACCESS_KEY"),
+		S3SessionToken:    os.Getenv("DOCUMENT_S3_SESSION_TOKEN"),
+	}
+
+	switch d.Storage {
+	case "":
+	case "local":
+		if d.Dir == "" {
+			d.Dir = "documents"
+		}
+	case "s3":
+		if d.S3Bucket == "" || d.S3AccessKeyID == "" || d.S3SecretAccessKey == "" {
+			return d, fmt.Errorf("DOCUMENT_S3_BUCKET, DOCUMENT_S3_ACCESS_KEY_ID and DOCUMENT_S3_SECRET_ACCESS_KEY must be set when DOCUMENT_STORAGE is s3")
+		}
+		if d.S3Region == "" {
+			d.S3Region = "us-east-1"
+		}
+		if d.S3Endpoint != "" {
+			u, err := url.Parse(d.S3Endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return d, fmt.Errorf("DOCUMENT_S3_ENDPOINT must be an http or https URL, got %q", d.S3Endpoint)
+			}
+		}
+	default:
+		return d, fmt.Errorf("DOCUMENT_STORAGE must be local or s3, got %q", d.Storage)
+	}
+
+	maxMB, err := envInt("DOCUMENT_MAX_SIZE_MB", 20)
+	if err != nil {
+		return d, err
+	}
+	if maxMB < 1 {
+		return d, fmt.Errorf("DOCUMENT_MAX_SIZE_MB must be positive, got %d", maxMB)
+	}
+	d.MaxBytes = int64(maxMB) << 20
+
+	for _, duration := range []struct {
+		name  string
+		value *time.Duration
+		def   int
+	}{
+		{"DOCUMENT_URL_TTL_SECONDS", &d.URLTTL, 300},
+		{"DOCUMENT_SWEEP_INTERVAL_SECONDS", &d.SweepInterval, 300},
+	} {
+		value, err := envInt(duration.name, duration.def)
+		if err != nil {
+			return d, err
+		}
+		if value < 1 {
+			return d, fmt.Errorf("%s must be positive, got %d", duration.name, value)
+		}
+		*duration.value = time.Duration(value) * time.Second
+	}
+
+	return d, nil
+}
+
+// persistedQueriesMode reads PERSISTED_QUERIES, off, automatic or allowlist.
+// It defaults to automatic, or off when memory is set.
+func persistedQueriesMode(memory bool) (persisted.Mode, error) {
+	value := os.Getenv("PERSISTED_QUERIES")
+	if value == "" && memory {
+		return persisted.Off, nil
+	}
+	if value == "" {
+		return persisted.Automatic, nil
+	}
+
+	for _, mode := range persisted.Modes {
+		if persisted.Mode(strings.ToLower(value)) == mode {
+			return mode, nil
+		}
+	}
+
+	return "", fmt.Errorf("PERSISTED_QUERIES must be off, automatic or allowlist, got %q", value)
+}
+
+// LoadForMigrations reads only the log level and the database settings, all
+// that the migration commands need, so they run without JWT_SECRET and the
+// other server settings.
+func LoadForMigrations() (Config, error) {
+	var cfg Config
+	var err error
+
+	if cfg.LogLevel, err = logLevel(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Database, err = database(); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// LoadForKeyRotation reads the settings of LoadForMigrations and the
+// encryption keys, which must be set, for the rotate-keys command.
+func LoadForKeyRotation() (Config, error) {
+	cfg, err := LoadForMigrations()
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.Encryption, err = encryptionConfig(); err != nil {
+		return cfg, err
+	}
+
+	if !cfg.Encryption.Enabled() {
+		return cfg, fmt.Errorf("PHI_ENCRYPTION_KEYS and PHI_INDEX_KEY must be set to rotate keys")
+	}
+
+	return cfg, nil
+}
+
+// LoadForSeeding reads the settings of LoadForMigrations, the encryption
+// keys when they are set, and whether APP_ENV is production, for the seed
+// command.
+func LoadForSeeding() (Config, error) {
+	cfg, err := LoadForMigrations()
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.Encryption, err = encryptionConfig(); err != nil {
+		return cfg, err
+	}
+
+	cfg.Server.Production = os.Getenv("APP_ENV") == "production"
+	return cfg, nil
+}
+
+// tlsConfig reads the HTTPS settings into cfg: TLS_CERT_FILE and
+// TLS_KEY_FILE, or TLS_AUTOCERT_DOMAINS with TLS_AUTOCERT_CACHE_DIR (default
+// autocert) and TLS_AUTOCERT_EMAIL, HTTP_REDIRECT_PORT, and
+// HSTS_MAX_AGE_SECONDS (default one year), which only applies to HTTPS.
+func tlsConfig(cfg *Config) error {
+	cfg.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	for _, file := range []struct{ name, path string }{
+		{"TLS_CERT_FILE", cfg.TLSCertFile},
+		{"TLS_KEY_FILE", cfg.TLSKeyFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+
+		if _, err := os.Stat(file.path); err != nil {
+			return fmt.Errorf("%s: %v", file.name, err)
+		}
+	}
+
+	cfg.AutocertDomains = splitList(os.Getenv("TLS_AUTOCERT_DOMAINS"))
+	if cfg.Autocert() && cfg.TLSCertFile != "" {
+		return fmt.Errorf("TLS_AUTOCERT_DOMAINS cannot be combined with TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	cfg.AutocertCacheDir = os.Getenv("TLS_AUTOCERT_CACHE_DIR")
+	if cfg.AutocertCacheDir == "" {
+		cfg.AutocertCacheDir = "autocert"
+	}
+	cfg.AutocertEmail = os.Getenv("TLS_AUTOCERT_EMAIL")
+
+	redirectPort, err := envInt("HTTP_REDIRECT_PORT", 0)
+	if err != nil {
+		return err
+	}
+
+	if redirectPort != 0 {
+		if redirectPort < 1 || redirectPort > 65535 {
+			return fmt.Errorf("HTTP_REDIRECT_PORT must be between 1 and 65535, got %d", redirectPort)
+		}
+
+		if !cfg.TLS() {
+			return fmt.Errorf("HTTP_REDIRECT_PORT needs HTTPS, set TLS_CERT_FILE and TLS_KEY_FILE or TLS_AUTOCERT_DOMAINS")
+		}
+
+		if _, port, _ := net.SplitHostPort(cfg.Server.Addr); port == strconv.Itoa(redirectPort) {
+			return fmt.Errorf("HTTP_REDIRECT_PORT must differ from the HTTPS port %s", port)
+		}
+
+		cfg.HTTPRedirectAddr = net.JoinHostPort(os.Getenv("SERVER_HOST"), strconv.Itoa(redirectPort))
+	}
+
+	hstsSeconds, err := envInt("HSTS_MAX_AGE_SECONDS", 365*24*60*60)
+	if err != nil {
+		return err
+	}
+	if hstsSeconds < 0 {
+		return fmt.Errorf("HSTS_MAX_AGE_SECONDS must not be negative, got %d", hstsSeconds)
+	}
+
+	if cfg.TLS() {
+		cfg.Server.HSTSMaxAge = time.Duration(hstsSeconds) * time.Second
+	}
+
+	return nil
+}
+
+// cacheConfig reads CACHE_BACKEND, empty, memory or redis, REDIS_URL, which
+// the redis backend needs, CACHE_MEMORY_MAX_ENTRIES (default 10000), and
+// CACHE_PATIENT_TTL_SECONDS and CACHE_LIST_TTL_SECONDS (default 60 and 15).
+func cacheConfig() (Cache, error) {
+	c := Cache{Backend: os.Getenv("CACHE_BACKEND"), RedisURL: os.Getenv("REDIS_URL")}
+
+	switch c.Backend {
+	case "", "memory":
+	case "redis":
+		if c.RedisURL == "" {
+			return c, fmt.Errorf("REDIS_URL must be set when CACHE_BACKEND is redis")
+		}
+	default:
+		return c, fmt.Errorf("CACHE_BACKEND must be memory or redis, got %q", c.Backend)
+	}
+
+	var err error
+	if c.MaxEntries, err = envInt("CACHE_MEMORY_MAX_ENTRIES", 10000); err != nil {
+		return c, err
+	}
+	if c.MaxEntries < 1 {
+		return c, fmt.Errorf("CACHE_MEMORY_MAX_ENTRIES must be positive, got %d", c.MaxEntries)
+	}
+
+	for _, ttl := range []struct {
+		name  string
+		value *time.Duration
+		def   int
+	}{
+		{"CACHE_PATIENT_TTL_SECONDS", &c.PatientTTL, 60},
+		{"CACHE_LIST_TTL_SECONDS", &c.ListTTL, 15},
+	} {
+		seconds, err := envInt(ttl.name, ttl.def)
+		if err != nil {
+			return c, err
+		}
+		if seconds < 0 {
+			return c, fmt.Errorf("%s must not be negative, got %d", ttl.name, seconds)
+		}
+		*ttl.value = time.Duration(seconds) * time.Second
+	}
+
+	return c, nil
+}
+
+// eventsConfig reads WEBHOOK_URLS, comma separated, which must use https in
+// production, WEBHOOK_SECRET, at least 32 characters and required with
+// them, NATS_URL and NATS_SUBJECT_PREFIX (default smart-emerge), and
+// OUTBOX_POLL_INTERVAL_SECONDS, EVENT_DELIVERY_TIMEOUT_SECONDS and
+// OUTBOX_MAX_ATTEMPTS (default 1, 10 and 20).
+func eventsConfig(production bool) (Events, error) {
+	e := Events{
+		WebhookURLs:       splitList(os.Getenv("WEBHOOK_URLS")),
+		WebhookSecret:     os.Getenv("WEBHOOK_SECRET"),
+		NATSURL:           os.Getenv("NATS_URL"),
+		NATSSubjectPrefix: os.Getenv("NATS_SUBJECT_PREFIX"),
+	}
+
+	for _, webhook := range e.WebhookURLs {
+		u, err := url.Parse(webhook)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return e, fmt.Errorf("WEBHOOK_URLS must hold http or https URLs, got %q", webhook)
+		}
+		if production && u.Scheme != "https" {
+			return e, fmt.Errorf("WEBHOOK_URLS must use https when APP_ENV=production, got %q", webhook)
+		}
+	}
+
+	if len(e.WebhookURLs) > 0 && len(e.WebhookSecret) < 32 {
+		return e, fmt.Errorf("WEBHOOK_SECRET must be at least 32 characters when WEBHOOK_URLS is set")
+	}
+
+	if e.NATSSubjectPrefix == "" {
+		e.NATSSubjectPrefix = "smart-emerge"
+	}
+
+	for _, duration := range []struct {
+		name  string
+		value *time.Duration
+		def   int
+	}{
+		{"OUTBOX_POLL_INTERVAL_SECONDS", &e.PollInterval, 1},
+		{"EVENT_DELIVERY_TIMEOUT_SECONDS", &e.Timeout, 10},
+	} {
+		seconds, err := envInt(duration.name, duration.def)
+		if err != nil {
+			return e, err
+		}
+		if seconds < 1 {
+			return e, fmt.Errorf("%s must be positive, got %d", duration.name, seconds)
+		}
+		*duration.value = time.Duration(seconds) * time.Second
+	}
+
+	var err error
+	if e.MaxAttempts, err = envInt("OUTBOX_MAX_ATTEMPTS", 20); err != nil {
+		return e, err
+	}
+	if e.MaxAttempts < 1 {
+		return e, fmt.Errorf("OUTBOX_MAX_ATTEMPTS must be positive, got %d", e.MaxAttempts)
+	}
+
+	return e, nil
+}
+
+// encryptionConfig reads PHI_ENCRYPTION_KEYS, a comma separated list of
+// id:base64 AES-256 keys with the current key first, and PHI_INDEX_KEY, the
+// base64 blind index key. Both or neither must be set.
+func encryptionConfig() (Encryption, error) {
+	var e Encryption
+
+	keys, indexKey := os.Getenv("PHI_ENCRYPTION_KEYS"), os.Getenv("PHI_INDEX_KEY")
+	if keys == "" && indexKey == "" {
+		return e, nil
+	}
+	if keys == "" || indexKey == "" {
+		return e, fmt.Errorf("PHI_ENCRYPTION_KEYS and PHI_INDEX_KEY must be set together")
+	}
+
+	var err error
+	if e.Keys, err = encryption.ParseKeys(keys); err != nil {
+		return e, fmt.Errorf("PHI_ENCRYPTION_KEYS: %v", err)
+	}
+
+	if e.IndexKey, err = base64.StdEncoding.DecodeString(indexKey); err != nil {
+		return e, fmt.Errorf("PHI_INDEX_KEY is not valid base64: %v", err)
+	}
+
+	if len(e.IndexKey) < encryption.MinIndexKeyLength {
+		return e, fmt.Errorf("PHI_INDEX_KEY must be at least %d bytes, got %d", encryption.MinIndexKeyLength, len(e.IndexKey))
+	}
+
+	return e, nil
+}
+
+// database reads DB_DRIVER, postgres (the default) or memory, and for
+// postgres DB_URL (required), DB_REPLICA_URL and the pool settings.
+func database() (Database, error) {
+	db := Database{Driver: os.Getenv("DB_DRIVER")}
+
+	switch db.Driver {
+	case "":
+		db.Driver = DriverPostgres
+	case DriverPostgres:
+	case DriverMemory:
+		return db, nil
+	default:
+		return db, fmt.Errorf("DB_DRIVER must be postgres or memory, got %q", db.Driver)
+	}
+
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		return db, fmt.Errorf("DB_URL must be set")
+	}
+
+	var err error
+	if db.URL, err = pq.ParseURL(dbURL); err != nil {
+		return db, fmt.Errorf("DB_URL is not a valid postgres url: %v", err)
+	}
+
+	if replicaURL := os.Getenv("DB_REPLICA_URL"); replicaURL != "" {
+		if db.ReplicaURL, err = pq.ParseURL(replicaURL); err != nil {
+			return db, fmt.Errorf("DB_REPLICA_URL is not a valid postgres url: %v", err)
+		}
+	}
+
+	if db.Pool, err = pool(); err != nil {
+		return db, err
+	}
+
+	return db, nil
+}
+
+// logLevel reads LOG_LEVEL: debug, info (default), warn or error.
+func logLevel() (slog.Level, error) {
+	level := slog.LevelInfo
+
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			return level, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", v)
+		}
+	}
+
+	return level, nil
+}
+
+// pool reads the DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and
+// DB_CONN_MAX_LIFETIME_SECONDS settings of the connection pool.
+func pool() (Pool, error) {
+	var p Pool
+
+	maxOpen, err := envInt("DB_MAX_OPEN_CONNS", 25)
+	if err != nil {
+		return p, err
+	}
+
+	maxIdle, err := envInt("DB_MAX_IDLE_CONNS", 5)
+	if err != nil {
+		return p, err
+	}
+
+	lifetime, err := envInt("DB_CONN_MAX_LIFETIME_SECONDS", 300)
+	if err != nil {
+		return p, err
+	}
+
+	if maxOpen < 1 || maxIdle < 0 || lifetime < 0 {
+		return p, fmt.Errorf("database pool settings must not be negative and DB_MAX_OPEN_CONNS must be at least 1")
+	}
+
+	if maxIdle > maxOpen {
+		return p, fmt.Errorf("DB_MAX_IDLE_CONNS (%d) must not exceed DB_MAX_OPEN_CONNS (%d)", maxIdle, maxOpen)
+	}
+
+	return Pool{MaxOpen: maxOpen, MaxIdle: maxIdle, MaxLifetime: time.Duration(lifetime) * time.Second}, nil
+}
+
+// serverConfig reads the server settings: SERVER_HOST (default all
+// interfaces), SERVER_PORT or else PORT (default 8000),
+// SERVER_READ_TIMEOUT_SECONDS and SERVER_WRITE_TIMEOUT_SECONDS (default 10),
+// SERVER_IDLE_TIMEOUT_SECONDS (default 60), JWT_SECRET (required),
+// RATE_LIMIT_RPS and RATE_LIMIT_BURST, RATE_LIMIT_TOKEN_RPS and
+// RATE_LIMIT_TOKEN_BURST, MAX_REQUEST_BYTES,
+// MAX_QUERY_DEPTH and MAX_QUERY_COMPLEXITY, REQUEST_TIMEOUT_SECONDS (default 5),
+// GRAPHQL_ENDPOINT, APP_ENV, TRUST_PROXY, CORS_ALLOWED_ORIGINS, which must not allow any
+// origin in production, GRAPHIQL_ENABLED and INTROSPECTION_ENABLED
+// (default on outside production), GRAPHIQL_ASSETS_DIR, overriding the
+// embedded GraphiQL files, and SIMULATE_LOAD_ENABLED, which production
+// refuses.
+func serverConfig() (server.Config, error) {
+	var cfg server.Config
+
+	portVariable := "SERVER_PORT"
+	if os.Getenv(portVariable) == "" && os.Getenv("PORT") != "" {
+		portVariable = "PORT"
+	}
+
+	port, err := envInt(portVariable, 8000)
+	if err != nil {
+		return cfg, err
+	}
+
+	if port < 1 || port > 65535 {
+		return cfg, fmt.Errorf("%s must be between 1 and 65535, got %d", portVariable, port)
+	}
+
+	cfg.Addr = net.JoinHostPort(os.Getenv("SERVER_HOST"), strconv.Itoa(port))
+
+	for _, timeout := range []struct {
+		name     string
+		fallback int
+		value    *time.Duration
+	}{
+		{"SERVER_READ_TIMEOUT_SECONDS", 10, &cfg.ReadTimeout},
+		{"SERVER_WRITE_TIMEOUT_SECONDS", 10, &cfg.WriteTimeout},
+		{"SERVER_IDLE_TIMEOUT_SECONDS", 60, &cfg.IdleTimeout},
+		{"REQUEST_TIMEOUT_SECONDS", 5, &cfg.RequestTimeout},
+	} {
+		seconds, err := envInt(timeout.name, timeout.fallback)
+		if err != nil {
+			return cfg, err
+		}
+
+		if seconds < 0 {
+			return cfg, fmt.Errorf("%s must not be negative, got %d", timeout.name, seconds)
+		}
+
+		*timeout.value = time.Duration(seconds) * time.Second
+	}
+
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return cfg, fmt.Errorf("JWT_SECRET must be set")
+	}
+	cfg.JWTSecret = []byte(jwtSecret)
+
+	if cfg.RateLimitRPS, err = envFloat("RATE_LIMIT_RPS", 10); err != nil {
+		return cfg, err
+	}
+
+	if cfg.RateLimitBurst, err = envInt("RATE_LIMIT_BURST", 20); err != nil {
+		return cfg, err
+	}
+
+	if cfg.RateLimitRPS < 0 || cfg.RateLimitBurst < 0 {
+		return cfg, fmt.Errorf("RATE_LIMIT_RPS and RATE_LIMIT_BURST must not be negative")
+	}
+
+	// An empty bucket lets no request through at all.
+	if cfg.RateLimitRPS > 0 && cfg.RateLimitBurst == 0 {
+		return cfg, fmt.Errorf("RATE_LIMIT_BURST must be at least 1 when RATE_LIMIT_RPS is set")
+	}
+
+	if cfg.TokenRateLimitRPS, err = envFloat("RATE_LIMIT_TOKEN_RPS", 10); err != nil {
+		return cfg, err
+	}
+
+	if cfg.TokenRateLimitBurst, err = envInt("RATE_LIMIT_TOKEN_BURST", 20); err != nil {
+		return cfg, err
+	}
+
+	if cfg.TokenRateLimitRPS < 0 || cfg.TokenRateLimitBurst < 0 {
+		return cfg, fmt.Errorf("RATE_LIMIT_TOKEN_RPS and RATE_LIMIT_TOKEN_BURST must not be negative")
+	}
+
+	if cfg.TokenRateLimitRPS > 0 && cfg.TokenRateLimitBurst == 0 {
+		return cfg, fmt.Errorf("RATE_LIMIT_TOKEN_BURST must be at least 1 when RATE_LIMIT_TOKEN_RPS is set")
+	}
+
+	maxRequestBytes, err := envInt("MAX_REQUEST_BYTES", 1<<20)
+	if err != nil {
+		return cfg, err
+	}
+	if maxRequestBytes < 0 {
+		return cfg, fmt.Errorf("MAX_REQUEST_BYTES must not be negative, got %d", maxRequestBytes)
+	}
+	cfg.MaxRequestBytes = int64(maxRequestBytes)
+
+	if cfg.CompressionMinBytes, err = envInt("COMPRESSION_MIN_BYTES", 1024); err != nil {
+		return cfg, err
+	}
+	if cfg.CompressionMinBytes < 0 {
+		return cfg, fmt.Errorf("COMPRESSION_MIN_BYTES must not be negative, got %d", cfg.CompressionMinBytes)
+	}
+
+	if cfg.QueryLimits.MaxDepth, err = envInt("MAX_QUERY_DEPTH", 5); err != nil {
+		return cfg, err
+	}
+
+	if cfg.QueryLimits.MaxComplexity, err = envInt("MAX_QUERY_COMPLEXITY", 100); err != nil {
+		return cfg, err
+	}
+
+	if cfg.QueryLimits.MaxDepth < 0 || cfg.QueryLimits.MaxComplexity < 0 {
+		return cfg, fmt.Errorf("MAX_QUERY_DEPTH and MAX_QUERY_COMPLEXITY must not be negative")
+	}
+
+	cfg.GraphQLEndpoint = os.Getenv("GRAPHQL_ENDPOINT")
+	if cfg.GraphQLEndpoint == "" {
+		cfg.GraphQLEndpoint = "/patient"
+	}
+
+	cfg.Production = os.Getenv("APP_ENV") == "production"
+
+	if cfg.TrustProxy, err = envBool("TRUST_PROXY", false); err != nil {
+		return cfg, err
+	}
+
+	cfg.CORSAllowedOrigins = splitList(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	for _, origin := range cfg.CORSAllowedOrigins {
+		if origin == "*" && cfg.Production {
+			return cfg, fmt.Errorf("CORS_ALLOWED_ORIGINS must list the allowed origins rather than * when APP_ENV=production")
+		}
+	}
+
+	if cfg.GraphiQL, err = envBool("GRAPHIQL_ENABLED", !cfg.Production); err != nil {
+		return cfg, err
+	}
+	cfg.GraphiQLAssetsDir = os.Getenv("GRAPHIQL_ASSETS_DIR")
+
+	introspection, err := envBool("INTROSPECTION_ENABLED", !cfg.Production)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.QueryLimits.DisableIntrospection = !introspection
+
+	if cfg.SimulateLoad, err = envBool("SIMULATE_LOAD_ENABLED", false); err != nil {
+		return cfg, err
+	}
+	if cfg.SimulateLoad && cfg.Production {
+		return cfg, fmt.Errorf("SIMULATE_LOAD_ENABLED must not be set when APP_ENV=production")
+	}
+
+	return cfg, nil
+}
+
+// splitList splits a comma separated environment value, dropping blanks.
+func splitList(value string) []string {
+	var items []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// envInt reads an integer environment variable, returning fallback when it is
+// unset and a descriptive error when it is not a valid integer.
+func envInt(name string, fallback int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer, got %q", name, v)
+	}
+
+	return n, nil
+}
+
+// envBool reads a true or false environment variable like envInt.
+func envBool(name string, fallback bool) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %q", name, v)
+	}
+
+	return b, nil
+}
+
+// envFloat reads a float environment variable like envInt.
+func envFloat(name string, fallback float64) (float64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number, got %q", name, v)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,313 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/server"
+)
+
+// setEnv sets the environment variables of env for the test, with
+// JWT_SECRET set unless env sets it.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	if _, ok := env["JWT_SECRET"]; !ok {
+		t.Setenv("JWT_SECRET", "test-secret-of-at-least-32-bytes!")
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+// checkErr fails the test unless err contains want, or is nil when want is
+// "".
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("error = %v, want none", err)
+	case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+		t.Fatalf("error = %v, want one containing %q", err, want)
+	}
+}
+
+func TestServerConfigCORS(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins string
+		appEnv  string
+		want    []string
+		err     string
+	}{
+		{name: "unset"},
+		{name: "one origin", origins: "https://app.example.com", want: []string{"https://app.example.com"}},
+		{name: "comma separated", origins: " https://app.example.com, ,https://admin.example.com ", want: []string{"https://app.example.com", "https://admin.example.com"}},
+		{name: "any origin", origins: "*", want: []string{"*"}},
+		{name: "any origin in production", origins: "*", appEnv: "production", err: "rather than *"},
+		{name: "listed origins in production", origins: "https://app.example.com", appEnv: "production", want: []string{"https://app.example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"CORS_ALLOWED_ORIGINS": tt.origins, "APP_ENV": tt.appEnv})
+
+			cfg, err := serverConfig()
+			checkErr(t, err, tt.err)
+			if tt.err != "" {
+				return
+			}
+
+			if fmt.Sprint(cfg.CORSAllowedOrigins) != fmt.Sprint(tt.want) {
+				t.Errorf("CORSAllowedOrigins = %v, want %v", cfg.CORSAllowedOrigins, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerConfigSimulateLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled string
+		appEnv  string
+		want    bool
+		err     string
+	}{
+		{name: "unset"},
+		{name: "enabled", enabled: "true", want: true},
+		{name: "enabled in production", enabled: "true", appEnv: "production", err: "SIMULATE_LOAD_ENABLED must not be set"},
+		{name: "disabled in production", enabled: "false", appEnv: "production"},
+		{name: "not a boolean", enabled: "sometimes", err: "SIMULATE_LOAD_ENABLED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"SIMULATE_LOAD_ENABLED": tt.enabled, "APP_ENV": tt.appEnv})
+
+			cfg, err := serverConfig()
+			checkErr(t, err, tt.err)
+			if tt.err != "" {
+				return
+			}
+
+			if cfg.SimulateLoad != tt.want {
+				t.Errorf("SimulateLoad = %v, want %v", cfg.SimulateLoad, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   string
+	}{
+		{"", 15 * time.Second, ""},
+		{"30", 30 * time.Second, ""},
+		{"0", 0, ""},
+		{"-1", 0, "SHUTDOWN_TIMEOUT_SECONDS must not be negative"},
+		{"soon", 0, "SHUTDOWN_TIMEOUT_SECONDS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			setEnv(t, map[string]string{"DB_DRIVER": DriverMemory, "SHUTDOWN_TIMEOUT_SECONDS": tt.value})
+
+			cfg, err := Load()
+			checkErr(t, err, tt.err)
+			if tt.err == "" && cfg.ShutdownTimeout != tt.want {
+				t.Errorf("ShutdownTimeout = %v, want %v", cfg.ShutdownTimeout, tt.want)
+			}
+		})
+	}
+}
+
+func TestPool(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want Pool
+		err  string
+	}{
+		{name: "defaults", want: Pool{MaxOpen: 25, MaxIdle: 5, MaxLifetime: 300 * time.Second}},
+		{
+			name: "set",
+			env:  map[string]string{"DB_MAX_OPEN_CONNS": "50", "DB_MAX_IDLE_CONNS": "10", "DB_CONN_MAX_LIFETIME_SECONDS": "60"},
+			want: Pool{MaxOpen: 50, MaxIdle: 10, MaxLifetime: time.Minute},
+		},
+		{name: "idle equal to open", env: map[string]string{"DB_MAX_OPEN_CONNS": "5", "DB_MAX_IDLE_CONNS": "5"}, want: Pool{MaxOpen: 5, MaxIdle: 5, MaxLifetime: 300 * time.Second}},
+		{name: "open not a number", env: map[string]string{"DB_MAX_OPEN_CONNS": "abc"}, err: `DB_MAX_OPEN_CONNS must be an integer, got "abc"`},
+		{name: "idle not a number", env: map[string]string{"DB_MAX_IDLE_CONNS": "many"}, err: `DB_MAX_IDLE_CONNS must be an integer, got "many"`},
+		{name: "lifetime not a number", env: map[string]string{"DB_CONN_MAX_LIFETIME_SECONDS": "5m"}, err: `DB_CONN_MAX_LIFETIME_SECONDS must be an integer, got "5m"`},
+		{name: "no open connections", env: map[string]string{"DB_MAX_OPEN_CONNS": "0"}, err: "DB_MAX_OPEN_CONNS must be at least 1"},
+		{name: "negative idle", env: map[string]string{"DB_MAX_IDLE_CONNS": "-1"}, err: "must not be negative"},
+		{name: "negative lifetime", env: map[string]string{"DB_CONN_MAX_LIFETIME_SECONDS": "-1"}, err: "must not be negative"},
+		{name: "more idle than open", env: map[string]string{"DB_MAX_OPEN_CONNS": "5", "DB_MAX_IDLE_CONNS": "10"}, err: "DB_MAX_IDLE_CONNS (10) must not exceed DB_MAX_OPEN_CONNS (5)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"DB_MAX_OPEN_CONNS": "", "DB_MAX_IDLE_CONNS": "", "DB_CONN_MAX_LIFETIME_SECONDS": ""})
+			setEnv(t, tt.env)
+
+			p, err := pool()
+			checkErr(t, err, tt.err)
+			if tt.err == "" && p != tt.want {
+				t.Errorf("pool() = %+v, want %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		err  string
+	}{
+		{name: "memory", env: map[string]string{"DB_DRIVER": DriverMemory}},
+		{name: "postgres", env: map[string]string{"DB_URL": "postgres://app@localhost/app"}},
+		{name: "no url", env: map[string]string{"DB_URL": ""}, err: "DB_URL must be set"},
+		{name: "unknown driver", env: map[string]string{"DB_DRIVER": "mysql"}, err: `DB_DRIVER must be postgres or memory, got "mysql"`},
+		{name: "invalid pool", env: map[string]string{"DB_URL": "postgres://app@localhost/app", "DB_MAX_OPEN_CONNS": "abc"}, err: "DB_MAX_OPEN_CONNS must be an integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"DB_DRIVER": "", "DB_URL": "", "DB_REPLICA_URL": ""})
+			setEnv(t, tt.env)
+
+			_, err := database()
+			checkErr(t, err, tt.err)
+		})
+	}
+}
+
+func TestServerConfigJWTSecret(t *testing.T) {
+	setEnv(t, map[string]string{"JWT_SECRET": ""})
+	_, err := serverConfig()
+	checkErr(t, err, "JWT_SECRET must be set")
+
+	setEnv(t, map[string]string{"JWT_SECRET": "test-secret-of-at-least-32-bytes!"})
+	cfg, err := serverConfig()
+	checkErr(t, err, "")
+	if string(cfg.JWTSecret) != "test-secret-of-at-least-32-bytes!" {
+		t.Errorf("JWTSecret = %q", cfg.JWTSecret)
+	}
+}
+
+func TestServerConfigRateLimit(t *testing.T) {
+	tests := []struct {
+		name              string
+		env               map[string]string
+		rps, tokenRPS     float64
+		burst, tokenBurst int
+		err               string
+	}{
+		{name: "defaults", env: map[string]string{}, rps: 10, burst: 20, tokenRPS: 10, tokenBurst: 20},
+		{
+			name: "set",
+			env:  map[string]string{"RATE_LIMIT_RPS": "2.5", "RATE_LIMIT_BURST": "5", "RATE_LIMIT_TOKEN_RPS": "1", "RATE_LIMIT_TOKEN_BURST": "3"},
+			rps:  2.5, burst: 5, tokenRPS: 1, tokenBurst: 3,
+		},
+		{name: "disabled", env: map[string]string{"RATE_LIMIT_RPS": "0", "RATE_LIMIT_BURST": "0", "RATE_LIMIT_TOKEN_RPS": "0"}, tokenBurst: 20},
+		{name: "negative", env: map[string]string{"RATE_LIMIT_RPS": "-1"}, err: "must not be negative"},
+		{name: "negative token burst", env: map[string]string{"RATE_LIMIT_TOKEN_BURST": "-1"}, err: "must not be negative"},
+		{name: "not a number", env: map[string]string{"RATE_LIMIT_RPS": "fast"}, err: "RATE_LIMIT_RPS"},
+		{name: "empty bucket", env: map[string]string{"RATE_LIMIT_BURST": "0"}, err: "RATE_LIMIT_BURST must be at least 1"},
+		{name: "empty token bucket", env: map[string]string{"RATE_LIMIT_TOKEN_BURST": "0"}, err: "RATE_LIMIT_TOKEN_BURST must be at least 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+
+			cfg, err := serverConfig()
+			checkErr(t, err, tt.err)
+			if tt.err != "" {
+				return
+			}
+
+			if cfg.RateLimitRPS != tt.rps || cfg.RateLimitBurst != tt.burst {
+				t.Errorf("rate limit = %v/%d, want %v/%d", cfg.RateLimitRPS, cfg.RateLimitBurst, tt.rps, tt.burst)
+			}
+			if cfg.TokenRateLimitRPS != tt.tokenRPS || cfg.TokenRateLimitBurst != tt.tokenBurst {
+				t.Errorf("token rate limit = %v/%d, want %v/%d", cfg.TokenRateLimitRPS, cfg.TokenRateLimitBurst, tt.tokenRPS, tt.tokenBurst)
+			}
+		})
+	}
+}
+
+func TestServerConfigListen(t *testing.T) {
+	tests := []struct {
+		name                     string
+		env                      map[string]string
+		addr                     string
+		read, write, idle, limit time.Duration
+		err                      string
+	}{
+		{
+			name: "defaults", env: map[string]string{},
+			addr: ":8000", read: 10 * time.Second, write: 10 * time.Second, idle: 60 * time.Second, limit: 5 * time.Second,
+		},
+		{
+			name: "set",
+			env: map[string]string{
+				"SERVER_HOST": "127.0.0.1", "SERVER_PORT": "9090", "SERVER_READ_TIMEOUT_SECONDS": "3",
+				"SERVER_WRITE_TIMEOUT_SECONDS": "4", "SERVER_IDLE_TIMEOUT_SECONDS": "30", "REQUEST_TIMEOUT_SECONDS": "2",
+			},
+			addr: "127.0.0.1:9090", read: 3 * time.Second, write: 4 * time.Second, idle: 30 * time.Second, limit: 2 * time.Second,
+		},
+		{
+			name: "IPv6 host", env: map[string]string{"SERVER_HOST": "::1"},
+			addr: "[::1]:8000", read: 10 * time.Second, write: 10 * time.Second, idle: 60 * time.Second, limit: 5 * time.Second,
+		},
+		{
+			name: "PORT", env: map[string]string{"PORT": "3000"},
+			addr: ":3000", read: 10 * time.Second, write: 10 * time.Second, idle: 60 * time.Second, limit: 5 * time.Second,
+		},
+		{
+			name: "SERVER_PORT before PORT", env: map[string]string{"SERVER_PORT": "9090", "PORT": "3000"},
+			addr: ":9090", read: 10 * time.Second, write: 10 * time.Second, idle: 60 * time.Second, limit: 5 * time.Second,
+		},
+		{name: "port out of range", env: map[string]string{"SERVER_PORT": "70000"}, err: "SERVER_PORT must be between 1 and 65535"},
+		{name: "PORT out of range", env: map[string]string{"PORT": "0"}, err: "PORT must be between 1 and 65535"},
+		{name: "port not a number", env: map[string]string{"SERVER_PORT": "http"}, err: "SERVER_PORT"},
+		{name: "negative timeout", env: map[string]string{"SERVER_READ_TIMEOUT_SECONDS": "-1"}, err: "SERVER_READ_TIMEOUT_SECONDS must not be negative"},
+		{name: "timeout not a number", env: map[string]string{"SERVER_IDLE_TIMEOUT_SECONDS": "1m"}, err: "SERVER_IDLE_TIMEOUT_SECONDS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// PORT is set by some platforms the tests may run on.
+			if _, ok := tt.env["PORT"]; !ok {
+				tt.env["PORT"] = ""
+			}
+			setEnv(t, tt.env)
+
+			cfg, err := serverConfig()
+			checkErr(t, err, tt.err)
+			if tt.err != "" {
+				return
+			}
+
+			if cfg.RequestTimeout != tt.limit {
+				t.Errorf("RequestTimeout = %v, want %v", cfg.RequestTimeout, tt.limit)
+			}
+
+			srv := server.New(cfg, server.Deps{})
+			defer srv.Shutdown(context.Background())
+
+			got := [4]interface{}{srv.Addr, srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout}
+			want := [4]interface{}{tt.addr, tt.read, tt.write, tt.idle}
+			if got != want {
+				t.Errorf("server address and timeouts = %v, want %v", got, want)
+			}
+		})
+	}
+}
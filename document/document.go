@@ -0,0 +1,41 @@
+// Package document keeps the files attached to patients, such as insurance
+// cards, referral letters and lab results, in a Storage on local disk or in
+// S3. Their metadata is kept by store.DocumentRepository. Files are
+// downloaded through links signed by a Signer, which expire, and the files
+// of deleted documents are removed from the storage by a Sweeper.
+package document
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotFound is returned by Storage.Open when there is no file under the
+// key.
+var ErrNotFound = errors.New("document: file not found")
+
+// Storage keeps the content of documents under keys chosen by NewKey.
+type Storage interface {
+	// Put stores the size bytes read from r under key.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns the content stored under key, or ErrNotFound.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the content stored under key, succeeding when there is
+	// none.
+	Delete(ctx context.Context, key string) error
+}
+
+// NewKey returns a new storage key for a document of a patient. Keys are
+// random, so they reveal nothing of the file and cannot be guessed.
+func NewKey(clinicID, patientID int) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("clinics/%d/patients/%d/%s", clinicID, patientID, hex.EncodeToString(b)), nil
+}
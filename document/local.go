@@ -0,0 +1,77 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Local keeps documents as files under a directory, at the path of their
+// key.
+type Local struct {
+	dir string
+}
+
+// NewLocal returns a Local keeping files under dir, which is created when
+// it does not exist.
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("document directory %s: %w", dir, err)
+	}
+
+	return &Local{dir: dir}, nil
+}
+
+func (l *Local) path(key string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(key))
+}
+
+// Put writes the file to a temporary file first and renames it into place,
+// so a failed upload leaves no partial file behind.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("document %s: wrote %d bytes, expected %d", key, written, size)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return file, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	err := os.Remove(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,205 @@
+package document
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3 keeps documents as objects of a bucket of Amazon S3, or of a service
+// with the same API such as MinIO, signing its requests with AWS Signature
+// Version 4. The payloads are not signed, which S3 allows over HTTPS.
+type S3 struct {
+	// base is the URL of the bucket, object keys being appended to it.
+	base         string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// NewS3 returns an S3 keeping documents in bucket of region. With an empty
+// endpoint the bucket is reached at its AWS virtual-hosted URL, otherwise
+// at <endpoint>/<bucket>, as S3 compatible services expect. sessionToken is
+// only needed with temporary credentials.
+func NewS3(endpoint, region, bucket, accessKey, secretKey, sessionToken string) (*S3, error) {
+	base := "https://" + bucket + ".s3." + region + ".amazonaws.com"
+	if endpoint != "" {
+		base = strings.TrimSuffix(endpoint, "/") + "/" + url.PathEscape(bucket)
+	}
+
+	if _, err := url.Parse(base); err != nil {
+		return nil, fmt.Errorf("s3 bucket url: %w", err)
+	}
+
+	return &S3{
+		base:         base,
+		region:       region,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: sessionToken,
+		client:       &http.Client{},
+	}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	req, err := s.request(ctx, http.MethodPut, key, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// request returns a signed request for the object key.
+func (s *S3) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.base+"/"+escapeKey(key), body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.sign(req, time.Now().UTC())
+	return req, nil
+}
+
+// do sends req, failing with ErrNotFound when the object does not exist and
+// with the status otherwise unless it is a 2xx one.
+func (s *S3) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	return nil, fmt.Errorf("s3 %s %s answered %s", req.Method, req.URL.Path, resp.Status)
+}
+
+// sign adds the AWS Signature Version 4 headers of req at now.
+func (s *S3) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := map[string]string{"host": req.URL.Host, "x-amz-content-sha256": "UNSIGNED-PAYLOAD", "x-amz-date": amzDate}
+	if s.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+		values["x-amz-security-token"] = s.sessionToken
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapeKey escapes the segments of an object key as Signature Version 4
+// expects, every byte but the unreserved characters of RFC 3986.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		var b strings.Builder
+		for _, c := range []byte(segment) {
+			if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+				b.WriteByte(c)
+				continue
+			}
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+		segments[i] = b.String()
+	}
+
+	return strings.Join(segments, "/")
+}
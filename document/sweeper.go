@@ -0,0 +1,102 @@
+package document
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// sweepBatchSize is how many files one sweep deletes at most.
+const sweepBatchSize = 100
+
+// Sweeper deletes from the storage the files queued in the
+// document_deletions table by deleted documents and by purged or erased
+// patients, every interval. Queued rows are locked while their files are
+// deleted, so several instances can run a Sweeper on the same database.
+type Sweeper struct {
+	db       *sql.DB
+	storage  Storage
+	interval time.Duration
+}
+
+func NewSweeper(db *sql.DB, storage Storage, interval time.Duration) *Sweeper {
+	return &Sweeper{db: db, storage: storage, interval: interval}
+}
+
+// Run deletes queued files until ctx is cancelled. A full batch is followed
+// by the next one straight away.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := s.sweep(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "deleting document files", "error", err)
+		}
+
+		if deleted == sweepBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep deletes a batch of queued files and returns how many it deleted. A
+// file that cannot be deleted stays queued for the next sweep.
+func (s *Sweeper) sweep(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	keys, err := queuedKeys(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			slog.WarnContext(ctx, "deleting document file", "error", err)
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, "delete from document_deletions where storage_key = $1", key); err != nil {
+			return 0, err
+		}
+		deleted++
+	}
+
+	return deleted, tx.Commit()
+}
+
+// queuedKeys locks the next batch of queued storage keys, skipping those
+// another Sweeper is deleting.
+func queuedKeys(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	rows, err := tx.QueryContext(ctx,
+		"select storage_key from document_deletions order by created_at limit $1 for update skip locked", sweepBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
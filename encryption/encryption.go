@@ -0,0 +1,245 @@
+// Package encryption encrypts the PHI fields of patients at rest with
+// AES-256-GCM and computes blind indexes, keyed HMACs of their values, so
+// they can still be looked up by exact value.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// prefix starts every encrypted value, which reads
+// enc:<key id>:<base64 of the nonce followed by the sealed value>.
+const prefix = "enc:"
+
+// MinIndexKeyLength is the shortest blind index key accepted, in bytes.
+const MinIndexKeyLength = 32
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Key is an AES-256 key with the id stored next to the values it encrypts,
+// so they can be decrypted after newer keys are added.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKeys parses a comma separated list of id:base64 keys, the first one
+// being the current key. Every key must decode to 32 bytes.
+func ParseKeys(value string) ([]Key, error) {
+	var keys []Key
+	seen := map[string]bool{}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok || !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("keys must be written id:base64 with an id of letters, digits, _ and -")
+		}
+
+		if seen[id] {
+			return nil, fmt.Errorf("key %q is listed twice", id)
+		}
+		seen[id] = true
+
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not valid base64: %v", id, err)
+		}
+
+		if len(secret) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, got %d", id, len(secret))
+		}
+
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys given")
+	}
+
+	return keys, nil
+}
+
+// Cipher encrypts with its current key and decrypts with any of its keys. A
+// nil *Cipher stands for encryption being disabled: it stores values as they
+// are, computes no indexes and only decrypts plaintext.
+type Cipher struct {
+	current  string
+	aeads    map[string]cipher.AEAD
+	indexKey []byte
+}
+
+// New returns a Cipher encrypting with the first of keys and computing blind
+// indexes with indexKey.
+func New(keys []Key, indexKey []byte) (*Cipher, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("encryption needs at least one key")
+	}
+
+	if len(indexKey) < MinIndexKeyLength {
+		return nil, fmt.Errorf("the blind index key must be at least %d bytes, got %d", MinIndexKeyLength, len(indexKey))
+	}
+
+	c := &Cipher{current: keys[0].ID, aeads: make(map[string]cipher.AEAD, len(keys)), indexKey: indexKey}
+
+	for _, key := range keys {
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", key.ID, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", key.ID, err)
+		}
+
+		c.aeads[key.ID] = aead
+	}
+
+	return c, nil
+}
+
+// Enabled reports whether values are encrypted.
+func (c *Cipher) Enabled() bool {
+	return c != nil
+}
+
+// Encrypt seals the value of field with the current key. The field name is
+// authenticated with it, so a value copied to another field fails to
+// decrypt.
+func (c *Cipher) Encrypt(field, plaintext string) (string, error) {
+	if c == nil {
+		return plaintext, nil
+	}
+
+	aead := c.aeads[c.current]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(field))
+
+	return prefix + c.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value of field written by Encrypt. Values without the
+// encrypted prefix, stored before encryption was enabled, are returned as
+// they are.
+func (c *Cipher) Decrypt(field, stored string) (string, error) {
+	if !strings.HasPrefix(stored, prefix) {
+		return stored, nil
+	}
+
+	if c == nil {
+		return "", errors.New("the value is encrypted but no encryption keys are configured")
+	}
+
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(stored, prefix), ":")
+	if !ok {
+		return "", errors.New("the encrypted value is malformed")
+	}
+
+	aead, ok := c.aeads[id]
+	if !ok {
+		return "", fmt.Errorf("the value is encrypted with key %q, which is not configured", id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("the encrypted value is malformed")
+	}
+
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, sealed, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt the value with key %q: %v", id, err)
+	}
+
+	return string(plaintext), nil
+}
+
+// EncryptJSON seals the JSON document doc of field for a JSON column, which
+// then holds the encrypted value as a JSON string. When c is nil doc is
+// returned as it is.
+func (c *Cipher) EncryptJSON(field string, doc []byte) ([]byte, error) {
+	if c == nil {
+		return doc, nil
+	}
+
+	sealed, err := c.Encrypt(field, string(doc))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(sealed)
+}
+
+// DecryptJSON opens a JSON column value of field written by EncryptJSON.
+// Documents stored before encryption was enabled are returned as they are.
+func (c *Cipher) DecryptJSON(field string, stored []byte) ([]byte, error) {
+	var sealed string
+	if json.Unmarshal(stored, &sealed) != nil || !strings.HasPrefix(sealed, prefix) {
+		return stored, nil
+	}
+
+	plaintext, err := c.Decrypt(field, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(plaintext), nil
+}
+
+// CurrentJSON reports whether the JSON column value stored is encrypted
+// with the current key, like Current.
+func (c *Cipher) CurrentJSON(stored []byte) bool {
+	var sealed string
+	if json.Unmarshal(stored, &sealed) != nil {
+		sealed = string(stored)
+	}
+
+	return c.Current(sealed)
+}
+
+// Current reports whether stored is encrypted with the current key, so key
+// rotation can leave it alone.
+func (c *Cipher) Current(stored string) bool {
+	if c == nil {
+		return !strings.HasPrefix(stored, prefix)
+	}
+
+	return strings.HasPrefix(stored, prefix+c.current+":")
+}
+
+// Index returns the blind index of the value of field, or "" when c is nil.
+// Callers normalize value first, since only equal values share an index.
+func (c *Cipher) Index(field, value string) string {
+	if c == nil {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,107 @@
+package encryption
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testCipher(t *testing.T, ids ...string) *Cipher {
+	t.Helper()
+
+	var keys []Key
+	for _, id := range ids {
+		keys = append(keys, Key{ID: id, Secret: bytes.Repeat([]byte(id[len(id)-1:]), 32)})
+	}
+
+	c, err := New(keys, bytes.Repeat([]byte{9}, MinIndexKeyLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestEncryptJSON(t *testing.T) {
+	doc := []byte(`{"email":"ada@example.com","phone":"+14155552671"}`)
+
+	tests := []struct {
+		name   string
+		cipher *Cipher
+		sealed bool
+	}{
+		{"disabled", nil, false},
+		{"enabled", testCipher(t, "k1"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored, err := tt.cipher.EncryptJSON("audit_value", doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if leaked := bytes.Contains(stored, []byte("ada@example.com")); leaked == tt.sealed {
+				t.Fatalf("EncryptJSON() = %s, sealed %v", stored, tt.sealed)
+			}
+			if tt.sealed && !strings.HasPrefix(string(stored), `"enc:k1:`) {
+				t.Fatalf("EncryptJSON() = %s, want a JSON string", stored)
+			}
+
+			opened, err := tt.cipher.DecryptJSON("audit_value", stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(opened, doc) {
+				t.Fatalf("DecryptJSON() = %s, want %s", opened, doc)
+			}
+
+			if !tt.cipher.CurrentJSON(stored) {
+				t.Fatalf("CurrentJSON(%s) = false", stored)
+			}
+		})
+	}
+}
+
+func TestDecryptJSON(t *testing.T) {
+	old := testCipher(t, "k1")
+	rotated := testCipher(t, "k2", "k1")
+
+	sealed, err := old.EncryptJSON("outbox_payload", []byte(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cipher  *Cipher
+		field   string
+		stored  string
+		want    string
+		current bool
+		err     bool
+	}{
+		{"plaintext document", rotated, "outbox_payload", `{"id":1}`, `{"id":1}`, false, false},
+		{"plaintext without encryption", nil, "outbox_payload", `{"id":1}`, `{"id":1}`, true, false},
+		{"older key", rotated, "outbox_payload", string(sealed), `{"id":1}`, false, false},
+		{"current key", old, "outbox_payload", string(sealed), `{"id":1}`, true, false},
+		{"other field", old, "audit_value", string(sealed), "", true, true},
+		{"no keys", nil, "outbox_payload", string(sealed), "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opened, err := tt.cipher.DecryptJSON(tt.field, []byte(tt.stored))
+			if (err != nil) != tt.err {
+				t.Fatalf("DecryptJSON() error = %v, want error %v", err, tt.err)
+			}
+			if err == nil && string(opened) != tt.want {
+				t.Fatalf("DecryptJSON() = %s, want %s", opened, tt.want)
+			}
+
+			if current := tt.cipher.CurrentJSON([]byte(tt.stored)); current != tt.current {
+				t.Fatalf("CurrentJSON() = %v, want %v", current, tt.current)
+			}
+		})
+	}
+}
@@ -0,0 +1,508 @@
+package fhir
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/codixir/smart-emerge-starter/logger"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// ContentType is the media type of FHIR JSON resources.
+const ContentType = "application/fhir+json"
+
+// Register adds the Patient endpoints to r, which should be routed under
+// /fhir:
+//
+//	GET  /Patient/{id}  read a patient, 410 when it is soft-deleted
+//	GET  /Patient       search by name, identifier, email or phone
+//	POST /Patient       create a patient
+//	PUT  /Patient/{id}  replace a patient's name, contact points and demographics
+//
+// Creates and updates are published to events like the GraphQL mutations,
+// and reads and searches are recorded in accessLog. Only the patients with a
+// data sharing consent covering store.ConsentScopeFHIR, read from consents,
+// are read, found or updated: the others answer 403 and searches leave them
+// out.
+func Register(r *mux.Router, patients store.PatientRepository, consents store.ConsentRepository, events Publisher, accessLog AccessLog) {
+	r.HandleFunc("/Patient", searchPatients(patients, consents, accessLog)).Methods("GET")
+	r.HandleFunc("/Patient", createPatient(patients, events)).Methods("POST")
+	r.HandleFunc("/Patient/{id:[0-9]+}", readPatient(patients, consents, accessLog)).Methods("GET")
+	r.HandleFunc("/Patient/{id:[0-9]+}", updatePatient(patients, consents, events)).Methods("PUT")
+}
+
+// Publisher receives the patients changed through this API.
+type Publisher interface {
+	Publish(topic string, payload interface{})
+}
+
+// AccessLog records reads of patient records.
+type AccessLog interface {
+	LogAccess(ctx context.Context, operation, performedBy string, patientIDs []int) error
+}
+
+func readPatient(patients store.PatientRepository, consents store.ConsentRepository, accessLog AccessLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authorize(w, r, resolvers.ReadRoles)
+		if !ok {
+			return
+		}
+
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+		patient, err := patients.Get(r.Context(), id, true)
+		if errors.Is(err, store.ErrNotFound) {
+			writeOutcome(w, http.StatusNotFound, "not-found", fmt.Sprintf("Patient/%d is not known", id))
+			return
+		}
+		if err != nil {
+			internalError(w, r, err, "could not read patient %d", id)
+			return
+		}
+
+		if patient.DeletedAt != nil {
+			writeOutcome(w, http.StatusGone, "deleted", fmt.Sprintf("Patient/%d has been deleted", id))
+			return
+		}
+
+		if !requireConsent(w, r, consents, id) {
+			return
+		}
+
+		if err := accessLog.LogAccess(r.Context(), "read", userID, []int{patient.ID}); err != nil {
+			internalError(w, r, err, "could not record read of patient %d", id)
+			return
+		}
+
+		writeResource(w, http.StatusOK, fromStore(patient))
+	}
+}
+
+// searchPatients answers with a searchset Bundle of the patients that are
+// not deleted, consented to sharing their data through FHIR and match every
+// given parameter. name, email and phone match as case-insensitive
+// substrings and identifier takes [system|]value.
+// _count sets the page size (default 20, at most 100) and _offset skips
+// matches, with a next link when there are more.
+func searchPatients(patients store.PatientRepository, consents store.ConsentRepository, accessLog AccessLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authorize(w, r, resolvers.ReadRoles)
+		if !ok {
+			return
+		}
+
+		query := r.URL.Query()
+
+		count, err := nonNegativeParam(query, "_count", resolvers.DefaultPageLimit)
+		if err != nil || count == 0 {
+			writeOutcome(w, http.StatusBadRequest, "invalid", "_count must be a positive integer")
+			return
+		}
+		if count > resolvers.MaxPageLimit {
+			count = resolvers.MaxPageLimit
+		}
+
+		offset, err := nonNegativeParam(query, "_offset", 0)
+		if err != nil {
+			writeOutcome(w, http.StatusBadRequest, "invalid", "_offset must not be negative")
+			return
+		}
+
+		var matches []*store.Patient
+		var total int
+
+		if identifier := query.Get("identifier"); identifier != "" {
+			matches, err = searchByIdentifier(r, patients, consents, identifier)
+			total = len(matches)
+			if offset > 0 {
+				matches = nil
+			}
+		} else {
+			matches, total, err = patients.List(r.Context(), store.ListOptions{
+				Filter: store.PatientFilter{
+					Name:           query.Get("name"),
+					Email:          query.Get("email"),
+					Phone:          query.Get("phone"),
+					SharingConsent: store.ConsentScopeFHIR,
+				},
+				Limit:  count,
+				Offset: offset,
+			})
+		}
+		if err != nil {
+			internalError(w, r, err, "could not search patients")
+			return
+		}
+
+		ids := make([]int, len(matches))
+		for i, patient := range matches {
+			ids[i] = patient.ID
+		}
+
+		if err := accessLog.LogAccess(r.Context(), "search", userID, ids); err != nil {
+			internalError(w, r, err, "could not record search of patients")
+			return
+		}
+
+		base := baseURL(r)
+		bundle := &Bundle{
+			ResourceType: "Bundle",
+			Type:         "searchset",
+			Total:        total,
+			Link:         []BundleLink{{Relation: "self", URL: base + r.URL.RequestURI()}},
+			Entry:        []BundleEntry{},
+		}
+
+		if offset+len(matches) < total {
+			next := r.URL.Query()
+			next.Set("_count", strconv.Itoa(count))
+			next.Set("_offset", strconv.Itoa(offset+len(matches)))
+			bundle.Link = append(bundle.Link, BundleLink{Relation: "next", URL: base + r.URL.Path + "?" + next.Encode()})
+		}
+
+		for _, patient := range matches {
+			bundle.Entry = append(bundle.Entry, BundleEntry{
+				FullURL:  fmt.Sprintf("%s/fhir/Patient/%d", base, patient.ID),
+				Resource: fromStore(patient),
+				Search:   &EntrySearch{Mode: "match"},
+			})
+		}
+
+		writeResource(w, http.StatusOK, bundle)
+	}
+}
+
+// searchByIdentifier finds the patient with an identifier given as
+// [system|]value, unless they did not consent to sharing their data through
+// FHIR. Only IdentifierSystem identifiers are known, so other systems match
+// nothing.
+func searchByIdentifier(r *http.Request, patients store.PatientRepository, consents store.ConsentRepository, identifier string) ([]*store.Patient, error) {
+	value := identifier
+	if system, v, ok := strings.Cut(identifier, "|"); ok {
+		if system != "" && system != IdentifierSystem {
+			return nil, nil
+		}
+		value = v
+	}
+
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, nil
+	}
+
+	patient, err := patients.Get(r.Context(), id, false)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if consented, err := sharingConsented(r.Context(), consents, id); err != nil || !consented {
+		return nil, err
+	}
+
+	return []*store.Patient{patient}, nil
+}
+
+func createPatient(patients store.PatientRepository, events Publisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authorize(w, r, resolvers.WriteRoles)
+		if !ok {
+			return
+		}
+
+		resource, ok := readResource(w, r)
+		if !ok {
+			return
+		}
+
+		changes, err := resourceChanges(resource)
+		if err != nil {
+			writeInvalid(w, err)
+			return
+		}
+
+		var demographics store.Demographics
+		changes.ApplyDemographics(&demographics)
+
+		patient, err := patients.Create(r.Context(), userID, *changes.Name, *changes.Email, *changes.Phone, demographics)
+		if err != nil {
+			internalError(w, r, err, "could not create patient")
+			return
+		}
+
+		events.Publish(resolvers.Topic(resolvers.PatientCreated, patient.ClinicID), patient)
+
+		w.Header().Set("Location", fmt.Sprintf("%s/fhir/Patient/%d", baseURL(r), patient.ID))
+		writeResource(w, http.StatusCreated, fromStore(patient))
+	}
+}
+
+func updatePatient(patients store.PatientRepository, consents store.ConsentRepository, events Publisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authorize(w, r, resolvers.WriteRoles)
+		if !ok {
+			return
+		}
+
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+		resource, ok := readResource(w, r)
+		if !ok {
+			return
+		}
+
+		if resource.ID != "" && resource.ID != strconv.Itoa(id) {
+			writeOutcome(w, http.StatusBadRequest, "invalid", "resource id does not match the URL")
+			return
+		}
+
+		changes, err := resourceChanges(resource)
+		if err != nil {
+			writeInvalid(w, err)
+			return
+		}
+
+		if _, err := patients.Get(r.Context(), id, false); errors.Is(err, store.ErrNotFound) {
+			writeOutcome(w, http.StatusNotFound, "not-found", fmt.Sprintf("Patient/%d is not known", id))
+			return
+		} else if err != nil {
+			internalError(w, r, err, "could not read patient %d", id)
+			return
+		}
+
+		if !requireConsent(w, r, consents, id) {
+			return
+		}
+
+		patient, err := patients.Update(r.Context(), userID, id, changes)
+		if errors.Is(err, store.ErrNotFound) {
+			writeOutcome(w, http.StatusNotFound, "not-found", fmt.Sprintf("Patient/%d is not known", id))
+			return
+		}
+		if err != nil {
+			internalError(w, r, err, "could not update patient %d", id)
+			return
+		}
+
+		events.Publish(resolvers.Topic(resolvers.PatientUpdated, patient.ClinicID), patient)
+
+		writeResource(w, http.StatusOK, fromStore(patient))
+	}
+}
+
+// resourceChanges reads and checks the fields of a resource as changes
+// replacing all of them, returning validation.Errors describing every
+// invalid one. The name is taken from the structured name when the resource
+// names the patient in neither text nor given and family names.
+func resourceChanges(resource *Patient) (store.PatientChanges, error) {
+	name, email, phone := resource.fields()
+	changes, birthDate := resource.demographics()
+
+	demographicsErr := resolvers.ValidateDemographics(&changes, &birthDate)
+	if name == "" {
+		name = changes.StructuredName.String()
+	}
+	changes.Name, changes.Email, changes.Phone = &name, &email, &phone
+
+	var errs validation.Errors
+	for _, err := range []error{validation.Patient(&name, &email, &phone), demographicsErr} {
+		if err != nil {
+			errs = append(errs, err.(validation.Errors)...)
+		}
+	}
+	if len(errs) > 0 {
+		return changes, errs
+	}
+
+	return changes, nil
+}
+
+// authorize answers 401 or 403 with an OperationOutcome unless the caller
+// has one of roles, and returns the caller's user id.
+func authorize(w http.ResponseWriter, r *http.Request, roles []string) (string, bool) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		writeOutcome(w, http.StatusUnauthorized, "login", "authentication required")
+		return "", false
+	}
+
+	if !middleware.HasRole(r.Context(), roles...) {
+		writeOutcome(w, http.StatusForbidden, "forbidden", "the caller's roles do not allow this interaction")
+		return "", false
+	}
+
+	return userID, true
+}
+
+// requireConsent answers 403 unless patient id consented to sharing their
+// data through FHIR, reporting whether they did.
+func requireConsent(w http.ResponseWriter, r *http.Request, consents store.ConsentRepository, id int) bool {
+	consented, err := sharingConsented(r.Context(), consents, id)
+	if err != nil {
+		internalError(w, r, err, "could not read the consents of patient %d", id)
+		return false
+	}
+
+	if !consented {
+		writeOutcome(w, http.StatusForbidden, "suppressed", fmt.Sprintf("Patient/%d has not consented to sharing their data through FHIR", id))
+		return false
+	}
+
+	return true
+}
+
+// sharingConsented reports whether patient id has a data sharing consent in
+// effect covering store.ConsentScopeFHIR.
+func sharingConsented(ctx context.Context, consents store.ConsentRepository, id int) (bool, error) {
+	byPatient, err := consents.ListByPatients(ctx, []int{id})
+	if err != nil {
+		return false, err
+	}
+
+	for _, c := range byPatient[id] {
+		if c.Covers(store.ConsentDataSharing, store.ConsentScopeFHIR) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// readResource decodes a Patient resource from the request body.
+func readResource(w http.ResponseWriter, r *http.Request) (*Patient, bool) {
+	resource := &Patient{}
+	if err := json.NewDecoder(r.Body).Decode(resource); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeOutcome(w, http.StatusRequestEntityTooLarge, "too-long", fmt.Sprintf("the resource is larger than %d bytes", tooLarge.Limit))
+			return nil, false
+		}
+
+		writeOutcome(w, http.StatusBadRequest, "structure", fmt.Sprintf("invalid JSON: %v", err))
+		return nil, false
+	}
+
+	if resource.ResourceType != "Patient" {
+		writeOutcome(w, http.StatusBadRequest, "invalid", "resourceType must be Patient")
+		return nil, false
+	}
+
+	return resource, true
+}
+
+// internalError logs a failed database operation and answers 500, or 503
+// when the request timeout cut it off. The diagnostics name the request ID,
+// so the failure can be found in the logs.
+func internalError(w http.ResponseWriter, r *http.Request, err error, format string, args ...interface{}) {
+	operation := fmt.Sprintf(format, args...)
+	requestID := ""
+	if id, ok := logger.RequestIDFromContext(r.Context()); ok {
+		requestID = " (request ID " + id + ")"
+	}
+
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		slog.WarnContext(r.Context(), "database operation timed out", "operation", operation, "error", err)
+		writeOutcome(w, http.StatusServiceUnavailable, "timeout", operation+": the request timed out"+requestID)
+		return
+	}
+
+	slog.ErrorContext(r.Context(), "database error", "operation", operation, "error", err)
+	writeOutcome(w, http.StatusInternalServerError, "exception", operation+requestID)
+}
+
+// writeInvalid answers 400 with an issue for every invalid field in err.
+func writeInvalid(w http.ResponseWriter, err error) {
+	outcome := &OperationOutcome{ResourceType: "OperationOutcome"}
+
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		for _, fieldErr := range fieldErrs {
+			outcome.Issue = append(outcome.Issue, Issue{
+				Severity:    "error",
+				Code:        "invalid",
+				Diagnostics: fieldErr.Message,
+				Expression:  []string{fieldExpression(fieldErr.Field)},
+			})
+		}
+	} else {
+		outcome.Issue = []Issue{{Severity: "error", Code: "invalid", Diagnostics: err.Error()}}
+	}
+
+	writeResource(w, http.StatusBadRequest, outcome)
+}
+
+// fieldExpressions are the FHIRPath locations of the validated fields.
+var fieldExpressions = map[string]string{
+	"name":              "Patient.name",
+	"email":             "Patient.telecom",
+	"phone":             "Patient.telecom",
+	"structuredName":    "Patient.name",
+	"birthDate":         "Patient.birthDate",
+	"gender":            "Patient.gender",
+	"preferredLanguage": "Patient.communication",
+	"addresses":         "Patient.address",
+	"telecoms":          "Patient.telecom",
+	"identifiers":       "Patient.identifier",
+}
+
+// fieldExpression returns the FHIRPath location of a validated field, which
+// for a field of a list entry such as "addresses[0].city" is the location
+// of the list.
+func fieldExpression(field string) string {
+	if i := strings.IndexAny(field, "[."); i >= 0 {
+		field = field[:i]
+	}
+
+	return fieldExpressions[field]
+}
+
+func writeOutcome(w http.ResponseWriter, status int, code, diagnostics string) {
+	writeResource(w, status, &OperationOutcome{
+		ResourceType: "OperationOutcome",
+		Issue:        []Issue{{Severity: "error", Code: code, Diagnostics: diagnostics}},
+	})
+}
+
+func writeResource(w http.ResponseWriter, status int, resource interface{}) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resource)
+}
+
+// baseURL returns the scheme and host the request was sent to.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
+
+func nonNegativeParam(query url.Values, name string, fallback int) (int, error) {
+	v := query.Get(name)
+	if v == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err == nil && n < 0 {
+		err = strconv.ErrRange
+	}
+
+	return n, err
+}
@@ -0,0 +1,215 @@
+package fhir
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/pubsub"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/store/memory"
+)
+
+var testSecret = []byte("test-secret-of-at-least-32-bytes!")
+
+// consentEnv serves the FHIR endpoints from memory repositories holding a
+// patient per consent: the ids are keyed by the scope of their data sharing
+// consent, with "none" for the patient who gave none and "revoked" for the
+// one who withdrew theirs.
+type consentEnv struct {
+	router *mux.Router
+	ids    map[string]int
+}
+
+func newConsentEnv(t *testing.T) *consentEnv {
+	t.Helper()
+
+	db := memory.New()
+	patients := memory.NewPatientStore(db)
+	consents := memory.NewConsentStore(db)
+
+	router := mux.NewRouter()
+	router.Use(middleware.JWTMiddleware(testSecret))
+	Register(router.PathPrefix("/fhir").Subrouter(), patients, consents, pubsub.NewBroker(), memory.NewAuditLogger(db))
+
+	ctx := authenticated(t)
+	env := &consentEnv{router: router, ids: map[string]int{}}
+
+	for i, name := range []string{store.ConsentScopeFHIR, store.ConsentScopeAll, store.ConsentScopeEvents, "none", "revoked"} {
+		patient, err := patients.Create(ctx, "admin-1", "Patient "+name, fmt.Sprintf("patient%d@example.com", i), fmt.Sprintf("+1415555010%d", i), store.Demographics{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.ids[name] = patient.ID
+
+		scope := name
+		switch name {
+		case "none":
+			continue
+		case "revoked":
+			scope = store.ConsentScopeFHIR
+		}
+
+		consent := &store.Consent{PatientID: patient.ID, Type: store.ConsentDataSharing, Scope: scope, GrantedAt: time.Now()}
+		if err := consents.Grant(ctx, "admin-1", consent); err != nil {
+			t.Fatal(err)
+		}
+
+		if name == "revoked" {
+			if _, err := consents.Revoke(ctx, "admin-1", consent.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return env
+}
+
+func authenticated(t *testing.T) context.Context {
+	t.Helper()
+
+	ctx, err := middleware.Authenticate(context.Background(), "Bearer "+token(t), "", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ctx
+}
+
+func token(t *testing.T) string {
+	t.Helper()
+
+	clinic := 1
+	token, err := middleware.IssueToken(testSecret, "admin-1", []string{middleware.RoleAdmin}, &clinic, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func (env *consentEnv) serve(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token(t))
+
+	rec := httptest.NewRecorder()
+	env.router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestConsentGatesRead(t *testing.T) {
+	env := newConsentEnv(t)
+
+	tests := []struct {
+		patient string
+		status  int
+	}{
+		{store.ConsentScopeFHIR, http.StatusOK},
+		{store.ConsentScopeAll, http.StatusOK},
+		{store.ConsentScopeEvents, http.StatusForbidden},
+		{"none", http.StatusForbidden},
+		{"revoked", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.patient, func(t *testing.T) {
+			rec := env.serve(t, "GET", fmt.Sprintf("/fhir/Patient/%d", env.ids[tt.patient]), "")
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			if tt.status == http.StatusForbidden && strings.Contains(rec.Body.String(), "@example.com") {
+				t.Errorf("body %s holds the patient's data", rec.Body)
+			}
+		})
+	}
+
+	if rec := env.serve(t, "GET", "/fhir/Patient/999", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown patient: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestConsentGatesSearch(t *testing.T) {
+	env := newConsentEnv(t)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"by name", "name=patient", []string{store.ConsentScopeFHIR, store.ConsentScopeAll}},
+		{"by name without consent", "name=none", nil},
+		{"by identifier", fmt.Sprintf("identifier=%d", env.ids[store.ConsentScopeFHIR]), []string{store.ConsentScopeFHIR}},
+		{"by identifier without consent", fmt.Sprintf("identifier=%d", env.ids["none"]), nil},
+		{"by identifier after revocation", fmt.Sprintf("identifier=%d", env.ids["revoked"]), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.serve(t, "GET", "/fhir/Patient?"+tt.query, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			var bundle struct {
+				Total int `json:"total"`
+				Entry []struct {
+					Resource Patient `json:"resource"`
+				} `json:"entry"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &bundle); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, entry := range bundle.Entry {
+				for name, id := range env.ids {
+					if entry.Resource.ID == fmt.Sprint(id) {
+						got = append(got, name)
+					}
+				}
+			}
+
+			if bundle.Total != len(tt.want) || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("matches = %v of %d, want %v", got, bundle.Total, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsentGatesUpdate(t *testing.T) {
+	env := newConsentEnv(t)
+
+	tests := []struct {
+		patient string
+		status  int
+	}{
+		{store.ConsentScopeFHIR, http.StatusOK},
+		{store.ConsentScopeEvents, http.StatusForbidden},
+		{"none", http.StatusForbidden},
+		{"revoked", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.patient, func(t *testing.T) {
+			body := `{"resourceType": "Patient", "name": [{"text": "Renamed"}],
+				"telecom": [{"system": "email", "value": "renamed@example.com"}, {"system": "phone", "value": "+14155550199"}]}`
+
+			rec := env.serve(t, "PUT", fmt.Sprintf("/fhir/Patient/%d", env.ids[tt.patient]), body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
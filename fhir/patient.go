@@ -0,0 +1,299 @@
+// Package fhir serves patients as FHIR R4 Patient resources over REST,
+// alongside the GraphQL API and backed by the same store.
+package fhir
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+const (
+	// IdentifierSystem is the identifier system of the patient ids assigned
+	// by this service.
+	IdentifierSystem = "urn:smart-emerge:patient-id"
+	// IdentifierTypeSystem is the HL7 v2 table 0203 the types of identifiers
+	// are coded in.
+	IdentifierTypeSystem = "http://terminology.hl7.org/CodeSystem/v2-0203"
+	// LanguageSystem is the code system of BCP 47 language tags.
+	LanguageSystem = "urn:ietf:bcp:47"
+)
+
+// identifierTypeCodes are the table 0203 codes of the identifier types of
+// the store. Other identifiers are sent without a type.
+var identifierTypeCodes = map[string]string{
+	store.IdentifierMRN:        "MR",
+	store.IdentifierNationalID: "NI",
+	store.IdentifierPassport:   "PPN",
+}
+
+// Patient is the subset of the FHIR R4 Patient resource this service stores.
+type Patient struct {
+	ResourceType  string          `json:"resourceType"`
+	ID            string          `json:"id,omitempty"`
+	Identifier    []Identifier    `json:"identifier,omitempty"`
+	Active        *bool           `json:"active,omitempty"`
+	Name          []HumanName     `json:"name,omitempty"`
+	Telecom       []ContactPoint  `json:"telecom,omitempty"`
+	Gender        string          `json:"gender,omitempty"`
+	BirthDate     string          `json:"birthDate,omitempty"`
+	Address       []Address       `json:"address,omitempty"`
+	Communication []Communication `json:"communication,omitempty"`
+}
+
+type Identifier struct {
+	Type   *CodeableConcept `json:"type,omitempty"`
+	System string           `json:"system,omitempty"`
+	Value  string           `json:"value,omitempty"`
+}
+
+type HumanName struct {
+	Text   string   `json:"text,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+	Prefix []string `json:"prefix,omitempty"`
+	Suffix []string `json:"suffix,omitempty"`
+}
+
+type ContactPoint struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Use    string `json:"use,omitempty"`
+}
+
+type Address struct {
+	Use        string   `json:"use,omitempty"`
+	Line       []string `json:"line,omitempty"`
+	City       string   `json:"city,omitempty"`
+	State      string   `json:"state,omitempty"`
+	PostalCode string   `json:"postalCode,omitempty"`
+	Country    string   `json:"country,omitempty"`
+}
+
+type Communication struct {
+	Language  CodeableConcept `json:"language"`
+	Preferred bool            `json:"preferred,omitempty"`
+}
+
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+type Coding struct {
+	System string `json:"system,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+// code returns the code of the first coding of c in system, or "".
+func (c *CodeableConcept) code(system string) string {
+	if c == nil {
+		return ""
+	}
+
+	for _, coding := range c.Coding {
+		if coding.System == system {
+			return coding.Code
+		}
+	}
+
+	return ""
+}
+
+// fromStore maps a stored patient to a FHIR resource. Soft-deleted patients
+// are reported as inactive. The phone and email come first in telecom, then
+// the other contact points of the patient.
+func fromStore(p *store.Patient) *Patient {
+	active := p.DeletedAt == nil
+	id := strconv.Itoa(p.ID)
+
+	n := p.StructuredName
+	name := HumanName{Text: p.Name, Family: n.Family, Given: n.Given}
+	if n.Prefix != "" {
+		name.Prefix = []string{n.Prefix}
+	}
+	if n.Suffix != "" {
+		name.Suffix = []string{n.Suffix}
+	}
+
+	resource := &Patient{
+		ResourceType: "Patient",
+		ID:           id,
+		Identifier:   []Identifier{{System: IdentifierSystem, Value: id}},
+		Active:       &active,
+		Name:         []HumanName{name},
+		Telecom: []ContactPoint{
+			{System: "phone", Value: p.Phone},
+			{System: "email", Value: p.Email},
+		},
+		Gender: p.Gender,
+	}
+
+	for _, identifier := range p.Identifiers {
+		converted := Identifier{System: identifier.System, Value: identifier.Value}
+		if code, ok := identifierTypeCodes[identifier.Type]; ok {
+			converted.Type = &CodeableConcept{Coding: []Coding{{System: IdentifierTypeSystem, Code: code}}}
+		}
+		resource.Identifier = append(resource.Identifier, converted)
+	}
+
+	for _, t := range p.Telecoms {
+		resource.Telecom = append(resource.Telecom, ContactPoint{System: t.System, Value: t.Value, Use: t.Use})
+	}
+
+	if p.BirthDate != nil {
+		resource.BirthDate = p.BirthDate.Format(time.DateOnly)
+	}
+
+	for _, a := range p.Addresses {
+		resource.Address = append(resource.Address, Address{
+			Use: a.Use, Line: a.Lines, City: a.City, State: a.State, PostalCode: a.PostalCode, Country: a.Country,
+		})
+	}
+
+	if p.PreferredLanguage != "" {
+		resource.Communication = []Communication{{
+			Language:  CodeableConcept{Coding: []Coding{{System: LanguageSystem, Code: p.PreferredLanguage}}},
+			Preferred: true,
+		}}
+	}
+
+	return resource
+}
+
+// fields returns the name, email and phone of a resource. The name is the
+// text of the first name, or its given and family names when it has no
+// text; the email and phone are the first telecom entries of each system.
+func (p *Patient) fields() (name, email, phone string) {
+	if len(p.Name) > 0 {
+		n := p.Name[0]
+		name = strings.TrimSpace(n.Text)
+		if name == "" {
+			name = strings.TrimSpace(strings.Join(append(append([]string{}, n.Given...), n.Family), " "))
+		}
+	}
+
+	for _, t := range p.Telecom {
+		switch {
+		case t.System == "email" && email == "":
+			email = t.Value
+		case t.System == "phone" && phone == "":
+			phone = t.Value
+		}
+	}
+
+	return name, email, phone
+}
+
+// demographics returns the demographics of a resource as changes replacing
+// all of them, and its birth date, "" when it has none. The structured name
+// is taken from the first name; the telecom entries fields does not take
+// become the other contact points, and the preferred communication, or the
+// first one, the preferred language. Identifiers of this service are
+// skipped.
+func (p *Patient) demographics() (changes store.PatientChanges, birthDate string) {
+	name := store.HumanName{Given: []string{}}
+	if len(p.Name) > 0 {
+		n := p.Name[0]
+		name.Prefix = strings.Join(n.Prefix, " ")
+		name.Given = append(name.Given, n.Given...)
+		name.Family = n.Family
+		name.Suffix = strings.Join(n.Suffix, " ")
+	}
+
+	telecoms := []store.ContactPoint{}
+	primary := map[string]bool{}
+	for _, t := range p.Telecom {
+		if (t.System == "email" || t.System == "phone") && !primary[t.System] {
+			primary[t.System] = true
+			continue
+		}
+		telecoms = append(telecoms, store.ContactPoint{System: t.System, Value: t.Value, Use: t.Use})
+	}
+
+	addresses := []store.Address{}
+	for _, a := range p.Address {
+		addresses = append(addresses, store.Address{
+			Use: a.Use, Lines: append([]string{}, a.Line...), City: a.City, State: a.State, PostalCode: a.PostalCode, Country: a.Country,
+		})
+	}
+
+	identifiers := []store.Identifier{}
+	for _, identifier := range p.Identifier {
+		if identifier.System == IdentifierSystem {
+			continue
+		}
+
+		kind := store.IdentifierOther
+		code := identifier.Type.code(IdentifierTypeSystem)
+		for t, c := range identifierTypeCodes {
+			if c == code {
+				kind = t
+			}
+		}
+		identifiers = append(identifiers, store.Identifier{Type: kind, System: identifier.System, Value: identifier.Value})
+	}
+
+	language := ""
+	for i, c := range p.Communication {
+		if c.Preferred || i == 0 {
+			language = c.Language.code(LanguageSystem)
+			if language == "" && len(c.Language.Coding) > 0 {
+				language = c.Language.Coding[0].Code
+			}
+		}
+		if c.Preferred {
+			break
+		}
+	}
+
+	gender := p.Gender
+
+	return store.PatientChanges{
+		StructuredName:    &name,
+		Gender:            &gender,
+		PreferredLanguage: &language,
+		Addresses:         &addresses,
+		Telecoms:          &telecoms,
+		Identifiers:       &identifiers,
+	}, p.BirthDate
+}
+
+// Bundle is a FHIR R4 searchset Bundle.
+type Bundle struct {
+	ResourceType string        `json:"resourceType"`
+	Type         string        `json:"type"`
+	Total        int           `json:"total"`
+	Link         []BundleLink  `json:"link,omitempty"`
+	Entry        []BundleEntry `json:"entry"`
+}
+
+type BundleLink struct {
+	Relation string `json:"relation"`
+	URL      string `json:"url"`
+}
+
+type BundleEntry struct {
+	FullURL  string       `json:"fullUrl"`
+	Resource *Patient     `json:"resource"`
+	Search   *EntrySearch `json:"search,omitempty"`
+}
+
+type EntrySearch struct {
+	Mode string `json:"mode"`
+}
+
+// OperationOutcome reports an error to FHIR clients.
+type OperationOutcome struct {
+	ResourceType string  `json:"resourceType"`
+	Issue        []Issue `json:"issue"`
+}
+
+type Issue struct {
+	Severity    string   `json:"severity"`
+	Code        string   `json:"code"`
+	Diagnostics string   `json:"diagnostics,omitempty"`
+	Expression  []string `json:"expression,omitempty"`
+}
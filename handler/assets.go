@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+//go:generate sh fetch_assets.sh
+
+// vendored holds the release files of the browser IDEs, which
+// fetch_assets.sh downloads at pinned versions into assets/, so they are
+// served from the binary rather than a CDN.
+//
+//go:embed assets
+var vendored embed.FS
+
+// PlaygroundFS and GraphiQLFS hold the vendored files of the GraphQL
+// Playground and of GraphiQL.
+var (
+	PlaygroundFS = vendoredDir("playground")
+	GraphiQLFS   = vendoredDir("graphiql")
+)
+
+func vendoredDir(dir string) fs.FS {
+	sub, err := fs.Sub(vendored, "assets/"+dir)
+	if err != nil {
+		panic(err)
+	}
+
+	return sub
+}
+
+// missingAssets returns the names that assets, which may be nil, lacks.
+func missingAssets(assets fs.FS, names []string) []string {
+	if assets == nil {
+		return names
+	}
+
+	var missing []string
+	for _, name := range names {
+		if _, err := fs.Stat(assets, name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing
+}
+
+// assetsHandler serves the files of names from assets, with the path
+// stripped of prefix, and answers 404 for any other file or unless enabled.
+func assetsHandler(prefix string, enabled bool, assets fs.FS, names []string) http.Handler {
+	files := http.StripPrefix(prefix, http.FileServer(http.FS(assets)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		if !enabled || assets == nil || !contains(names, name) {
+			http.NotFound(w, r)
+			return
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
+
+// render writes the HTML page of tmpl executed with data, or a 500 when it
+// cannot be executed.
+func render(w http.ResponseWriter, r *http.Request, tmpl *template.Template, data interface{}) {
+	var page bytes.Buffer
+	if err := tmpl.Execute(&page, data); err != nil {
+		slog.ErrorContext(r.Context(), "could not render the page", "page", tmpl.Name(), "error", err)
+		http.Error(w, "the page could not be rendered", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
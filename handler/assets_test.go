@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tmpl := template.Must(template.New("page").Parse("<p>{{.Endpoint}}</p>"))
+
+	rec := httptest.NewRecorder()
+	render(rec, httptest.NewRequest("GET", "/", nil), tmpl, struct{ Endpoint string }{"/patient"})
+	if rec.Code != http.StatusOK || rec.Body.String() != "<p>/patient</p>" {
+		t.Errorf("render() = %d %q, want 200 <p>/patient</p>", rec.Code, rec.Body)
+	}
+
+	// A page that fails half way is not sent in part.
+	rec = httptest.NewRecorder()
+	render(rec, httptest.NewRequest("GET", "/", nil), tmpl, struct{}{})
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "<p>") {
+		t.Errorf("render() of a failing template = %d %q, want a 500 without the page", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,30 @@
+#!/bin/sh
+# Downloads the release files of the browser IDEs, at the versions pinned
+# below, into assets/ to be embedded in the binary. The checksums of the
+# files are kept in assets/SHA256SUMS: a fetch with the file present checks
+# the downloads against it, so a changed release is not vendored unnoticed.
+set -eu
+
+cd "$(dirname "$0")/assets"
+
+PLAYGROUND=graphql-playground-react@1.7.28
+GRAPHIQL=graphiql@3.0.10
+REACT=18.2.0
+
+fetch() {
+	mkdir -p "$(dirname "$2")"
+	curl -fsSL -o "$2" "https://cdn.jsdelivr.net/npm/$1"
+}
+
+fetch "$PLAYGROUND/build/static/js/middleware.js" playground/middleware.js
+fetch "$PLAYGROUND/build/static/css/index.css" playground/index.css
+fetch "$GRAPHIQL/graphiql.min.js" graphiql/graphiql.min.js
+fetch "$GRAPHIQL/graphiql.min.css" graphiql/graphiql.min.css
+fetch "react@$REACT/umd/react.production.min.js" graphiql/react.production.min.js
+fetch "react-dom@$REACT/umd/react-dom.production.min.js" graphiql/react-dom.production.min.js
+
+if [ -f SHA256SUMS ]; then
+	sha256sum -c SHA256SUMS
+else
+	find . -type f ! -name SHA256SUMS ! -name README.md | sort | xargs sha256sum > SHA256SUMS
+fi
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+)
+
+//go:embed graphiql.html
+var graphiqlHTML string
+
+var graphiqlTemplate = template.Must(template.New("graphiql").Parse(graphiqlHTML))
+
+// GraphiQLAssets are the files of the GraphiQL IDE and of the React version
+// it runs on, which GraphiQL serves itself rather than from a CDN so no
+// third-party script runs with the credentials of its users.
+var GraphiQLAssets = []string{
+	"react.production.min.js",
+	"react-dom.production.min.js",
+	"graphiql.min.js",
+	"graphiql.min.css",
+}
+
+// GraphiQL serves the GraphiQL IDE, sending its queries to endpoint, with
+// its files from assets, such as GraphiQLFS, under graphiql/assets/ next to
+// the page. The page is only served to admins; its queries carry the bearer
+// token entered in its headers editor and are authorized like any other. It
+// answers 404 unless enabled, and 503 when assets lacks any of
+// GraphiQLAssets.
+func GraphiQL(endpoint string, enabled bool, assets fs.FS) http.HandlerFunc {
+	missing := missingAssets(assets, GraphiQLAssets)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !enabled {
+			http.NotFound(w, r)
+			return
+		}
+
+		if _, ok := middleware.UserIDFromContext(r.Context()); !ok {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		if !middleware.HasRole(r.Context(), middleware.RoleAdmin) {
+			http.Error(w, "GraphiQL needs the admin role", http.StatusForbidden)
+			return
+		}
+
+		if len(missing) > 0 {
+			http.Error(w, fmt.Sprintf("GraphiQL is not vendored: run go generate ./handler, or set GRAPHIQL_ASSETS_DIR to a directory holding %s", strings.Join(missing, ", ")),
+				http.StatusServiceUnavailable)
+			return
+		}
+
+		render(w, r, graphiqlTemplate, struct{ Endpoint string }{endpoint})
+	}
+}
+
+// GraphiQLAssetsHandler serves the files of GraphiQLAssets from assets,
+// with the path stripped of prefix, and answers 404 for any other file or
+// unless enabled.
+func GraphiQLAssetsHandler(prefix string, enabled bool, assets fs.FS) http.Handler {
+	return assetsHandler(prefix, enabled, assets, GraphiQLAssets)
+}
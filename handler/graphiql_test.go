@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+)
+
+var testSecret = []byte("test-secret-of-at-least-32-bytes!")
+
+// serve runs h behind the JWT middleware on a GET of path, with a token of
+// a user with roles unless roles is nil.
+func serve(t *testing.T, h http.Handler, path string, roles []string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", path, nil)
+	if roles != nil {
+		clinic := 1
+		token, err := middleware.IssueToken(testSecret, "user-1", roles, &clinic, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	middleware.JWTMiddleware(testSecret)(h).ServeHTTP(rec, req)
+
+	return rec
+}
+
+func graphiqlAssets() fstest.MapFS {
+	assets := fstest.MapFS{"secret.txt": {Data: []byte("not an asset")}}
+	for _, name := range GraphiQLAssets {
+		assets[name] = &fstest.MapFile{Data: []byte("/* " + name + " */")}
+	}
+
+	return assets
+}
+
+func TestGraphiQL(t *testing.T) {
+	admin := []string{middleware.RoleAdmin}
+
+	tests := []struct {
+		name    string
+		enabled bool
+		assets  fs.FS
+		roles   []string
+		status  int
+	}{
+		{"admin", true, graphiqlAssets(), admin, http.StatusOK},
+		{"disabled", false, graphiqlAssets(), admin, http.StatusNotFound},
+		{"anonymous", true, graphiqlAssets(), nil, http.StatusUnauthorized},
+		{"clinician", true, graphiqlAssets(), []string{middleware.RoleClinician}, http.StatusForbidden},
+		{"no assets", true, nil, admin, http.StatusServiceUnavailable},
+		{"missing asset", true, fstest.MapFS{"graphiql.min.js": {}}, admin, http.StatusServiceUnavailable},
+		{"not vendored", true, fstest.MapFS{}, admin, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, GraphiQL("/patient", tt.enabled, tt.assets), "/graphiql", tt.roles)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			body := rec.Body.String()
+			if strings.Contains(body, "https://") {
+				t.Error("the page loads files from another origin")
+			}
+			for _, name := range GraphiQLAssets {
+				if !strings.Contains(body, `"graphiql/assets/`+name+`"`) {
+					t.Errorf("the page does not load %s", name)
+				}
+			}
+			if !strings.Contains(body, `const endpoint = "/patient";`) {
+				t.Error("the page does not send its queries to the endpoint")
+			}
+		})
+	}
+}
+
+func TestGraphiQLAssetsHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		path    string
+		status  int
+	}{
+		{"asset", true, "/graphiql/assets/graphiql.min.js", http.StatusOK},
+		{"other file", true, "/graphiql/assets/secret.txt", http.StatusNotFound},
+		{"directory", true, "/graphiql/assets/", http.StatusNotFound},
+		{"disabled", false, "/graphiql/assets/graphiql.min.js", http.StatusNotFound},
+	}
+
+	h := func(enabled bool) http.Handler {
+		return GraphiQLAssetsHandler("/graphiql/assets/", enabled, graphiqlAssets())
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h(tt.enabled).ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
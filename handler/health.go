@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/migrate"
+)
+
+const readyTimeout = 2 * time.Second
+
+type healthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Checks holds the result of every dependency checked, by name.
+	Checks map[string]check `json:"checks,omitempty"`
+}
+
+// check is the result of one readiness check.
+type check struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"durationMs"`
+	// The migration check also reports the newest applied and known
+	// versions, and the migrations not applied yet.
+	Current *int64   `json:"current,omitempty"`
+	Latest  *int64   `json:"latest,omitempty"`
+	Pending []string `json:"pending,omitempty"`
+}
+
+// Healthz is the liveness probe. It answers 200 as long as the process is
+// able to serve requests, without checking its dependencies, so a database
+// outage does not get the process restarted.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// Readyz returns the readiness probe, which answers 200 when db can be pinged
+// and every migration in migrations is applied, within two seconds between
+// them, and 503 otherwise. The result of each check is in the JSON body.
+// Migrations newer than the ones in migrations, applied by a newer release
+// rolling out, do not fail the check. A nil migrations skips it, and a nil
+// db, as with the memory driver, both checks.
+func Readyz(db *sql.DB, migrations fs.FS) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		status := healthStatus{Status: "ok", Checks: map[string]check{}}
+
+		if db == nil {
+			writeStatus(w, http.StatusOK, status)
+			return
+		}
+
+		start := time.Now()
+		database := check{Status: "ok"}
+		if err := db.PingContext(ctx); err != nil {
+			database = check{Status: "unavailable", Error: err.Error()}
+		}
+		database.DurationMS = time.Since(start).Milliseconds()
+		status.Checks["database"] = database
+
+		if migrations != nil && database.Status == "ok" {
+			status.Checks["migrations"] = checkMigrations(ctx, db, migrations)
+		}
+
+		code := http.StatusOK
+		for _, c := range status.Checks {
+			if c.Status != "ok" {
+				status.Status = "unavailable"
+				code = http.StatusServiceUnavailable
+			}
+		}
+
+		writeStatus(w, code, status)
+	}
+}
+
+// checkMigrations reports whether every migration in fsys is applied to db.
+func checkMigrations(ctx context.Context, db *sql.DB, fsys fs.FS) check {
+	start := time.Now()
+
+	migrations, err := migrate.CheckStatus(ctx, db, fsys)
+	c := check{Status: "ok", DurationMS: time.Since(start).Milliseconds()}
+
+	switch {
+	case err != nil:
+		c.Status, c.Error = "unavailable", err.Error()
+	case len(migrations.Pending) > 0:
+		c.Status, c.Error = "unavailable", "migrations are pending"
+		fallthrough
+	default:
+		c.Current, c.Latest, c.Pending = &migrations.Current, &migrations.Latest, migrations.Pending
+	}
+
+	return c
+}
+
+func writeStatus(w http.ResponseWriter, code int, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
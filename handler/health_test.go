@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// pingDriver opens connections named "up" and fails to open the others, as
+// a database that is down.
+type pingDriver struct{}
+
+func (pingDriver) Open(name string) (driver.Conn, error) {
+	if name != "up" {
+		return nil, errors.New("dial tcp 127.0.0.1:5432: connection refused")
+	}
+
+	return pingConn{}, nil
+}
+
+type pingConn struct{}
+
+func (pingConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (pingConn) Close() error                              { return nil }
+func (pingConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func init() {
+	sql.Register("ping", pingDriver{})
+}
+
+func TestHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Healthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"status":"ok"}` {
+		t.Errorf("Healthz = %d %s, want 200 {\"status\":\"ok\"}", rec.Code, rec.Body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		database   string
+		migrations fs.FS
+		memory     bool
+		status     int
+		checks     map[string]string
+		err        string
+	}{
+		{name: "memory driver", memory: true, status: http.StatusOK, checks: map[string]string{}},
+		{name: "database up", database: "up", status: http.StatusOK, checks: map[string]string{"database": "ok"}},
+		{name: "database down", database: "down", status: http.StatusServiceUnavailable, checks: map[string]string{"database": "unavailable"}, err: "connection refused"},
+		{
+			name:       "database down skips the migrations",
+			database:   "down",
+			migrations: fstest.MapFS{"0001_init.up.sql": {Data: []byte("select 1")}},
+			status:     http.StatusServiceUnavailable,
+			checks:     map[string]string{"database": "unavailable"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var db *sql.DB
+			if !tt.memory {
+				var err error
+				if db, err = sql.Open("ping", tt.database); err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+			}
+
+			rec := httptest.NewRecorder()
+			Readyz(db, tt.migrations)(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+
+			var body healthStatus
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			wantStatus := "ok"
+			if tt.status != http.StatusOK {
+				wantStatus = "unavailable"
+			}
+			if body.Status != wantStatus {
+				t.Errorf("status = %q, want %q", body.Status, wantStatus)
+			}
+
+			if len(body.Checks) != len(tt.checks) {
+				t.Errorf("checks = %+v, want %v", body.Checks, tt.checks)
+			}
+			for name, status := range tt.checks {
+				if body.Checks[name].Status != status {
+					t.Errorf("check %s = %q, want %q", name, body.Checks[name].Status, status)
+				}
+			}
+			if tt.err != "" && !strings.Contains(body.Checks["database"].Error, tt.err) {
+				t.Errorf("database error = %q, want it to contain %q", body.Checks["database"].Error, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+//go:embed playground.html
+var playgroundHTML string
+
+var playgroundTemplate = template.Must(template.New("playground").Parse(playgroundHTML))
+
+// PlaygroundAssets are the files of the GraphQL Playground release the
+// page loads, from build/static of graphql-playground-react.
+var PlaygroundAssets = []string{
+	"middleware.js",
+	"index.css",
+}
+
+// Playground serves the GraphQL Playground, sending its queries to endpoint,
+// with its files from assets, under playground/assets/ next to the page. It
+// answers 404 when production is true so the schema is not advertised
+// there, and 503 when assets lacks any of PlaygroundAssets.
+func Playground(endpoint string, production bool, assets fs.FS) http.HandlerFunc {
+	missing := missingAssets(assets, PlaygroundAssets)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if production {
+			http.NotFound(w, r)
+			return
+		}
+
+		if len(missing) > 0 {
+			http.Error(w, fmt.Sprintf("the GraphQL Playground is not vendored: run go generate ./handler to fetch %s", strings.Join(missing, ", ")),
+				http.StatusServiceUnavailable)
+			return
+		}
+
+		render(w, r, playgroundTemplate, struct{ Endpoint string }{endpoint})
+	}
+}
+
+// PlaygroundAssetsHandler serves the files of PlaygroundAssets from assets,
+// with the path stripped of prefix, and answers 404 for any other file or in
+// production.
+func PlaygroundAssetsHandler(prefix string, production bool, assets fs.FS) http.Handler {
+	return assetsHandler(prefix, !production, assets, PlaygroundAssets)
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func playgroundAssets() fstest.MapFS {
+	assets := fstest.MapFS{"secret.txt": {Data: []byte("not an asset")}}
+	for _, name := range PlaygroundAssets {
+		assets[name] = &fstest.MapFile{Data: []byte("/* " + name + " */")}
+	}
+
+	return assets
+}
+
+func TestPlayground(t *testing.T) {
+	tests := []struct {
+		name       string
+		production bool
+		assets     fs.FS
+		status     int
+	}{
+		{"development", false, playgroundAssets(), http.StatusOK},
+		{"production", true, playgroundAssets(), http.StatusNotFound},
+		{"no assets", false, nil, http.StatusServiceUnavailable},
+		{"missing asset", false, fstest.MapFS{"middleware.js": {}}, http.StatusServiceUnavailable},
+		{"not vendored", false, fstest.MapFS{}, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Playground("/api/patient", tt.production, tt.assets).ServeHTTP(rec, httptest.NewRequest("GET", "/playground", nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			if got := rec.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+				t.Errorf("Content-Type = %q", got)
+			}
+
+			body := rec.Body.String()
+			if strings.Contains(body, "https://") {
+				t.Error("the page loads files from another origin")
+			}
+			for _, name := range PlaygroundAssets {
+				if !strings.Contains(body, `"playground/assets/`+name+`"`) {
+					t.Errorf("the page does not load %s", name)
+				}
+			}
+			if !strings.Contains(body, `const endpoint = "/api/patient";`) {
+				t.Error("the page does not send its queries to the endpoint")
+			}
+		})
+	}
+}
+
+func TestPlaygroundAssetsHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		production bool
+		path       string
+		status     int
+	}{
+		{"asset", false, "/playground/assets/middleware.js", http.StatusOK},
+		{"other file", false, "/playground/assets/secret.txt", http.StatusNotFound},
+		{"directory", false, "/playground/assets/", http.StatusNotFound},
+		{"production", true, "/playground/assets/middleware.js", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			PlaygroundAssetsHandler("/playground/assets/", tt.production, playgroundAssets()).ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package hl7
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// The acknowledgement codes of MSA-1.
+const (
+	// AckAccept acknowledges a message that was processed.
+	AckAccept = "AA"
+	// AckError answers a message that could not be processed, because of
+	// its content or a failure of the receiver.
+	AckError = "AE"
+	// AckReject answers a message of a type the receiver does not process.
+	AckReject = "AR"
+)
+
+// application is the name acknowledgements are sent by, in MSH-3.
+const application = "SMART-EMERGE"
+
+// escaper escapes the standard separators in free text.
+var escaper = strings.NewReplacer(`\`, `\E\`, "|", `\F\`, "^", `\S\`, "~", `\R\`, "&", `\T\`, "\r", " ", "\n", " ")
+
+// Ack returns the ACK message answering the message of header h with code,
+// one of AckAccept, AckError and AckReject. text explains the code in MSA-3
+// and may be empty. The acknowledgement uses the standard separators and
+// the version and processing ID of h, its sender and receiver swapped.
+func Ack(h Header, code, text string) string {
+	version, processing := h.Version, h.Processing
+	if version == "" {
+		version = "2.5"
+	}
+	if processing == "" {
+		processing = "P"
+	}
+
+	messageType := "ACK"
+	if h.Event != "" {
+		messageType = "ACK^" + h.Event + "^ACK"
+	}
+
+	msh := strings.Join([]string{
+		"MSH", `^~\&`,
+		application, h.ReceivingFacility,
+		h.SendingApplication, h.SendingFacility,
+		time.Now().UTC().Format("20060102150405"), "",
+		messageType, controlID(), processing, version,
+	}, "|")
+
+	msa := "MSA|" + code + "|" + h.ControlID
+	if text != "" {
+		msa += "|" + escaper.Replace(text)
+	}
+
+	return msh + "\r" + msa + "\r"
+}
+
+// controlID returns a new random MSH-10 message control ID.
+func controlID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,285 @@
+// Package hl7 reads patient details from HL7 v2 messages and builds the
+// acknowledgements answering them.
+package hl7
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// ErrUnsupported is returned by ParseADT for messages other than ADT^A01,
+// ADT^A04 and ADT^A08.
+var ErrUnsupported = errors.New("hl7: expected an ADT^A01, ADT^A04 or ADT^A08 message")
+
+// Patient holds the patient fields extracted from an HL7 v2 message. Email
+// is "" when the message has none. BirthDate is a date such as 1961-06-15,
+// "" when not given, and Gender one of store.Genders or "".
+type Patient struct {
+	Name        string
+	Email       string
+	Phone       string
+	BirthDate   string
+	Gender      string
+	Identifiers []store.Identifier
+	Addresses   []store.Address
+}
+
+// identifierTypes maps the HL7 table 0203 identifier type codes of PID-3 to
+// the types of identifiers; others are store.IdentifierOther.
+var identifierTypes = map[string]string{
+	"MR":  store.IdentifierMRN,
+	"SS":  store.IdentifierNationalID,
+	"NI":  store.IdentifierNationalID,
+	"PPN": store.IdentifierPassport,
+}
+
+// genders maps the HL7 table 0001 administrative sex codes of PID-8 to the
+// genders.
+var genders = map[string]string{
+	"M": store.GenderMale,
+	"F": store.GenderFemale,
+	"O": store.GenderOther,
+	"A": store.GenderOther,
+	"N": store.GenderOther,
+	"U": store.GenderUnknown,
+}
+
+// addressUses maps the HL7 table 0190 address types of PID-11 to the uses of
+// addresses; others leave the use unknown.
+var addressUses = map[string]string{
+	"H": store.UseHome,
+	"B": store.UseWork,
+	"O": store.UseWork,
+	"C": store.UseTemp,
+}
+
+// Header holds the MSH fields of a message needed to acknowledge it.
+type Header struct {
+	SendingApplication   string
+	SendingFacility      string
+	ReceivingApplication string
+	ReceivingFacility    string
+	// Type is the message type of MSH-9, such as ADT, and Event its
+	// trigger event, such as A04.
+	Type  string
+	Event string
+	// ControlID is MSH-10, which the acknowledgement refers to.
+	ControlID  string
+	Processing string
+	Version    string
+}
+
+// Message is an ADT message read by ParseADT.
+type Message struct {
+	Header
+	Patient Patient
+}
+
+// separators are the encoding characters a message declares in MSH-1 and
+// MSH-2.
+type separators struct {
+	field, component, repetition, subcomponent string
+}
+
+// split returns the segments of message, which may be terminated by \r as
+// the standard requires or by the newlines files and HTTP clients add.
+func split(message string) []string {
+	message = strings.NewReplacer("\r\n", "\r", "\n", "\r").Replace(strings.TrimSpace(message))
+	return strings.Split(message, "\r")
+}
+
+// ParseHeader reads the MSH segment starting message.
+func ParseHeader(message string) (Header, error) {
+	header, _, err := parseHeader(split(message)[0])
+	return header, err
+}
+
+func parseHeader(segment string) (Header, separators, error) {
+	var header Header
+
+	if !strings.HasPrefix(segment, "MSH") || len(segment) < 8 {
+		return header, separators{}, fmt.Errorf("hl7: message must start with an MSH segment")
+	}
+
+	seps := separators{field: string(segment[3]), component: string(segment[4]), repetition: string(segment[5]), subcomponent: string(segment[7])}
+
+	// MSH-1 is the field separator itself, so MSH-n sits at index n-1.
+	msh := strings.Split(segment, seps.field)
+	field := func(n int) string {
+		if n-1 < len(msh) {
+			return msh[n-1]
+		}
+		return ""
+	}
+
+	messageType := strings.Split(field(9), seps.component)
+	header = Header{
+		SendingApplication:   field(3),
+		SendingFacility:      field(4),
+		ReceivingApplication: field(5),
+		ReceivingFacility:    field(6),
+		Type:                 messageType[0],
+		ControlID:            field(10),
+		Processing:           field(11),
+		Version:              field(12),
+	}
+	if len(messageType) > 1 {
+		header.Event = messageType[1]
+	}
+
+	if header.Type == "" {
+		return header, seps, fmt.Errorf("hl7: MSH-9 message type is empty")
+	}
+
+	return header, seps, nil
+}
+
+// ParseADT extracts the header and patient details of a raw HL7 v2 ADT^A01
+// (admit), ADT^A04 (register) or ADT^A08 (update patient information)
+// message. The name comes from PID-5, the phone and the optional email from
+// the PID-13 home telecom repetitions, the identifiers from PID-3, the birth
+// date from PID-7, the gender from PID-8 and the addresses from PID-11.
+// Address countries are kept only when they are ISO 3166-1 alpha-2 codes.
+func ParseADT(message string) (*Message, error) {
+	segments := split(message)
+
+	header, seps, err := parseHeader(segments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case header.Type != "ADT":
+		return nil, fmt.Errorf("%w, got %s", ErrUnsupported, header.Type)
+	case header.Event != "A01" && header.Event != "A04" && header.Event != "A08":
+		return nil, fmt.Errorf("%w, got ADT^%s", ErrUnsupported, header.Event)
+	}
+
+	var pid []string
+	for _, segment := range segments[1:] {
+		if strings.HasPrefix(segment, "PID"+seps.field) {
+			pid = strings.Split(segment, seps.field)
+			break
+		}
+	}
+
+	if pid == nil {
+		return nil, fmt.Errorf("hl7: message has no PID segment")
+	}
+
+	field := func(n int) string {
+		if n < len(pid) {
+			return pid[n]
+		}
+		return ""
+	}
+
+	var patient Patient
+
+	// PID-5 is family^given^middle; only the first repetition is used.
+	name := strings.Split(strings.Split(field(5), seps.repetition)[0], seps.component)
+	var parts []string
+	for _, i := range []int{1, 2, 0} {
+		if i < len(name) && name[i] != "" {
+			parts = append(parts, name[i])
+		}
+	}
+	patient.Name = strings.Join(parts, " ")
+
+	for _, telecom := range strings.Split(field(13), seps.repetition) {
+		components := strings.Split(telecom, seps.component)
+
+		if len(components) > 3 && components[3] != "" && patient.Email == "" {
+			patient.Email = components[3]
+		}
+
+		if components[0] != "" && patient.Phone == "" {
+			patient.Phone = components[0]
+		}
+	}
+
+	switch {
+	case patient.Name == "":
+		return nil, fmt.Errorf("hl7: PID-5 patient name is empty")
+	case patient.Phone == "":
+		return nil, fmt.Errorf("hl7: PID-13 has no phone number")
+	}
+
+	// PID-3 repeats ID^check digit^scheme^assigning authority^type, the
+	// authority's first subcomponent naming the system.
+	for _, cx := range strings.Split(field(3), seps.repetition) {
+		components := strings.Split(cx, seps.component)
+		if components[0] == "" {
+			continue
+		}
+
+		id := store.Identifier{Type: store.IdentifierOther, Value: components[0]}
+		if len(components) > 3 {
+			id.System = strings.Split(components[3], seps.subcomponent)[0]
+		}
+		if len(components) > 4 && identifierTypes[components[4]] != "" {
+			id.Type = identifierTypes[components[4]]
+		}
+		patient.Identifiers = append(patient.Identifiers, id)
+	}
+
+	// PID-7 is a timestamp starting with the date of birth.
+	if birth := field(7); birth != "" {
+		date, err := time.Parse("20060102", birth[:min(len(birth), 8)])
+		if err != nil {
+			return nil, fmt.Errorf("hl7: PID-7 birth date must start with a date such as 19610615, got %q", birth)
+		}
+		patient.BirthDate = date.Format(time.DateOnly)
+	}
+
+	if sex := field(8); sex != "" {
+		if patient.Gender = genders[sex]; patient.Gender == "" {
+			return nil, fmt.Errorf("hl7: PID-8 administrative sex %q is not one of M, F, O, A, N or U", sex)
+		}
+	}
+
+	// PID-11 repeats street^other designation^city^state^zip^country^type.
+	for _, xad := range strings.Split(field(11), seps.repetition) {
+		components := strings.Split(xad, seps.component)
+		component := func(i int) string {
+			if i < len(components) {
+				return components[i]
+			}
+			return ""
+		}
+
+		a := store.Address{City: component(2), State: component(3), PostalCode: component(4), Use: addressUses[component(6)]}
+		for _, line := range []string{component(0), component(1)} {
+			if line != "" {
+				a.Lines = append(a.Lines, line)
+			}
+		}
+		if country := component(5); len(country) == 2 {
+			a.Country = country
+		}
+
+		if len(a.Lines) > 0 || a.City != "" || a.PostalCode != "" {
+			patient.Addresses = append(patient.Addresses, a)
+		}
+	}
+
+	return &Message{Header: header, Patient: patient}, nil
+}
+
+// ParseADTA04 extracts patient details from a raw HL7 v2 ADT^A04 message,
+// like ParseADT.
+func ParseADTA04(message string) (Patient, error) {
+	parsed, err := ParseADT(message)
+	if errors.Is(err, ErrUnsupported) || err == nil && parsed.Event != "A04" {
+		return Patient{}, fmt.Errorf("hl7: expected an ADT^A04 message")
+	}
+	if err != nil {
+		return Patient{}, err
+	}
+
+	return parsed.Patient, nil
+}
@@ -0,0 +1,130 @@
+package hl7
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// adt returns an ADT message of event with the PID segment pid, its segments
+// separated by \r.
+func adt(event, pid string) string {
+	return strings.Join([]string{
+		"MSH|^~\\&|REGADT|MCM|IFENG|IFENG|20240301100000||ADT^" + event + "|MSG00001|P|2.5",
+		"EVN|" + event + "|20240301100000",
+		pid,
+	}, "\r")
+}
+
+const pid = "PID|||12345^^^MCM^MR||Doe^John^Q||19610615|M||C|1200 N ELM STREET^^GREENSBORO^NC^27401-1020||+14155552671^PRN^PH^john@example.com"
+
+// john is the patient of pid.
+var john = Patient{
+	Name:        "John Q Doe",
+	Email:       "john@example.com",
+	Phone:       "+14155552671",
+	BirthDate:   "1961-06-15",
+	Gender:      store.GenderMale,
+	Identifiers: []store.Identifier{{Type: store.IdentifierMRN, System: "MCM", Value: "12345"}},
+	Addresses:   []store.Address{{Lines: []string{"1200 N ELM STREET"}, City: "GREENSBORO", State: "NC", PostalCode: "27401-1020"}},
+}
+
+func TestParseADT(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    Patient
+		event   string
+		err     string
+	}{
+		{name: "A04", message: adt("A04", pid), want: john, event: "A04"},
+		{name: "A01", message: adt("A01", pid), want: john, event: "A01"},
+		{name: "A08", message: adt("A08", pid), want: john, event: "A08"},
+		{name: "newline separated", message: strings.ReplaceAll(adt("A04", pid), "\r", "\n"), want: john, event: "A04"},
+		{
+			name:    "telecom repetitions",
+			message: adt("A04", "PID|||1||Roe^Jane||||||||+14155550100^PRN^PH~^NET^Internet^jane@example.com"),
+			want:    Patient{Name: "Jane Roe", Email: "jane@example.com", Phone: "+14155550100", Identifiers: []store.Identifier{{Type: store.IdentifierOther, Value: "1"}}},
+			event:   "A04",
+		},
+		{name: "other message type", message: strings.Replace(adt("A04", pid), "ADT^A04", "ORU^R01", 1), err: "ORU"},
+		{name: "other event", message: adt("A03", pid), err: "ADT^A03"},
+		{name: "no PID segment", message: adt("A04", "NK1|1|Doe^Jane"), err: "no PID segment"},
+		{name: "no name", message: adt("A04", "PID|||1||||||||||+14155550100^PRN^PH^a@example.com"), err: "PID-5"},
+		{
+			name:    "no email",
+			message: adt("A04", "PID|||1||Doe^John||||||||+14155550100^PRN^PH"),
+			want:    Patient{Name: "John Doe", Phone: "+14155550100", Identifiers: []store.Identifier{{Type: store.IdentifierOther, Value: "1"}}},
+			event:   "A04",
+		},
+		{
+			name: "repeated identifiers and addresses",
+			message: adt("A04", "PID|||12345^^^MCM&1.2.3&ISO^MR~123-45-6789^^^SSA^SS~X99^^^^PPN~^^^MCM^MR||Roe^Jane||19800229120000|F||"+
+				"|1 Main St^Apt 2^Springfield^IL^62701^US^H~PO Box 9^^Springfield^^62705^USA^M~^^^^^^B||+14155550100^PRN^PH"),
+			want: Patient{
+				Name:      "Jane Roe",
+				Phone:     "+14155550100",
+				BirthDate: "1980-02-29",
+				Gender:    store.GenderFemale,
+				Identifiers: []store.Identifier{
+					{Type: store.IdentifierMRN, System: "MCM", Value: "12345"},
+					{Type: store.IdentifierNationalID, System: "SSA", Value: "123-45-6789"},
+					{Type: store.IdentifierPassport, Value: "X99"},
+				},
+				Addresses: []store.Address{
+					{Use: store.UseHome, Lines: []string{"1 Main St", "Apt 2"}, City: "Springfield", State: "IL", PostalCode: "62701", Country: "US"},
+					{Lines: []string{"PO Box 9"}, City: "Springfield", PostalCode: "62705"},
+				},
+			},
+			event: "A04",
+		},
+		{name: "bad birth date", message: adt("A04", "PID|||1||Doe^John||1961-06-15||||||+14155550100^PRN^PH"), err: "PID-7"},
+		{name: "unknown sex", message: adt("A04", "PID|||1||Doe^John|||X|||||+14155550100^PRN^PH"), err: "PID-8"},
+		{name: "no phone", message: adt("A04", "PID|||1||Doe^John||||||||^NET^Internet^a@example.com"), err: "no phone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := ParseADT(tt.message)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseADT() error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(message.Patient, tt.want) {
+				t.Errorf("Patient = %+v, want %+v", message.Patient, tt.want)
+			}
+			if message.Event != tt.event || message.ControlID != "MSG00001" {
+				t.Errorf("Header = %+v, want event %s and control id MSG00001", message.Header, tt.event)
+			}
+		})
+	}
+}
+
+func TestParseADTA04(t *testing.T) {
+	patient, err := ParseADTA04(adt("A04", pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patient.Name != "John Q Doe" || patient.Phone != "+14155552671" {
+		t.Errorf("ParseADTA04() = %+v, want John Q Doe, +14155552671", patient)
+	}
+
+	for _, event := range []string{"A01", "A08"} {
+		if _, err := ParseADTA04(adt(event, pid)); err == nil || !strings.Contains(err.Error(), "ADT^A04") {
+			t.Errorf("ParseADTA04() of ADT^%s error = %v, want it to expect ADT^A04", event, err)
+		}
+	}
+
+	if _, err := ParseADT(adt("A03", pid)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("ParseADT() of ADT^A03 error = %v, want ErrUnsupported", err)
+	}
+}
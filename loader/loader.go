@@ -0,0 +1,93 @@
+// Package loader batches lookups made while resolving a single GraphQL
+// request, so nested fields cost one query per level instead of one per
+// parent object.
+package loader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BatchFunc fetches the values of many keys at once. Keys without a value
+// may be left out of the returned map.
+type BatchFunc[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+// Loader collects the keys passed to Load and fetches them with a single
+// BatchFunc call, either once wait has passed or as soon as one of the
+// returned thunks is called, whichever happens first. Results are cached, so
+// a Loader should live for a single request.
+type Loader[K comparable, V any] struct {
+	fetch BatchFunc[K, V]
+	wait  time.Duration
+
+	mu      sync.Mutex
+	pending *batch[K, V]
+	batches map[K]*batch[K, V]
+}
+
+type batch[K comparable, V any] struct {
+	ctx     context.Context
+	keys    []K
+	once    sync.Once
+	done    chan struct{}
+	results map[K]V
+	err     error
+}
+
+// New returns a Loader that fetches keys with fetch, waiting at most wait for
+// more keys to arrive before doing so.
+func New[K comparable, V any](fetch BatchFunc[K, V], wait time.Duration) *Loader[K, V] {
+	return &Loader[K, V]{
+		fetch:   fetch,
+		wait:    wait,
+		batches: make(map[K]*batch[K, V]),
+	}
+}
+
+// Load queues key for the next batch and returns a thunk that blocks until
+// that batch has been fetched. The thunk returns the zero value of V for keys
+// the batch did not return.
+//
+// graphql-go calls the resolvers of every sibling field before resolving the
+// thunks they return, so a resolver returning the thunk lets all of its
+// siblings share one batch.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) func() (V, error) {
+	l.mu.Lock()
+
+	b, ok := l.batches[key]
+	if !ok {
+		if l.pending == nil {
+			l.pending = &batch[K, V]{ctx: ctx, done: make(chan struct{})}
+			pending := l.pending
+			time.AfterFunc(l.wait, func() { l.dispatch(pending) })
+		}
+
+		b = l.pending
+		b.keys = append(b.keys, key)
+		l.batches[key] = b
+	}
+
+	l.mu.Unlock()
+
+	return func() (V, error) {
+		l.dispatch(b)
+		<-b.done
+
+		return b.results[key], b.err
+	}
+}
+
+// dispatch fetches b once, closing it to further keys first.
+func (l *Loader[K, V]) dispatch(b *batch[K, V]) {
+	b.once.Do(func() {
+		l.mu.Lock()
+		if l.pending == b {
+			l.pending = nil
+		}
+		l.mu.Unlock()
+
+		b.results, b.err = l.fetch(b.ctx, b.keys)
+		close(b.done)
+	})
+}
@@ -0,0 +1,158 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a BatchFunc returning the square of every key but those in
+// missing, recording the keys of each call.
+type recorder struct {
+	mu      sync.Mutex
+	calls   [][]int
+	missing map[int]bool
+	err     error
+}
+
+func (r *recorder) fetch(ctx context.Context, keys []int) (map[int]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sorted := append([]int(nil), keys...)
+	sort.Ints(sorted)
+	r.calls = append(r.calls, sorted)
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	values := make(map[int]int, len(keys))
+	for _, key := range keys {
+		if !r.missing[key] {
+			values[key] = key * key
+		}
+	}
+
+	return values, nil
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []int
+		missing map[int]bool
+		err     error
+		want    []int
+		calls   [][]int
+	}{
+		{"one key", []int{3}, nil, nil, []int{9}, [][]int{{3}}},
+		{"batched", []int{1, 2, 3}, nil, nil, []int{1, 4, 9}, [][]int{{1, 2, 3}}},
+		{"duplicate keys fetched once", []int{2, 2, 5}, nil, nil, []int{4, 4, 25}, [][]int{{2, 5}}},
+		{"missing key", []int{1, 7}, map[int]bool{7: true}, nil, []int{1, 0}, [][]int{{1, 7}}},
+		{"error", []int{1, 2}, nil, errors.New("connection refused"), []int{0, 0}, [][]int{{1, 2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{missing: tt.missing, err: tt.err}
+			l := New(r.fetch, time.Hour)
+
+			var thunks []func() (int, error)
+			for _, key := range tt.keys {
+				thunks = append(thunks, l.Load(context.Background(), key))
+			}
+
+			var got []int
+			for _, thunk := range thunks {
+				value, err := thunk()
+				if err != tt.err {
+					t.Errorf("err = %v, want %v", err, tt.err)
+				}
+				got = append(got, value)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(r.calls, tt.calls) {
+				t.Errorf("fetched %v, want %v", r.calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestLoadCaches(t *testing.T) {
+	r := &recorder{}
+	l := New(r.fetch, time.Hour)
+
+	if _, err := l.Load(context.Background(), 1)(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := l.Load(context.Background(), 2)
+	again := l.Load(context.Background(), 1)
+	second()
+	if value, _ := again(); value != 1 {
+		t.Errorf("value = %d, want 1", value)
+	}
+
+	if want := [][]int{{1}, {2}}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("fetched %v, want %v, with the key loaded first cached", r.calls, want)
+	}
+}
+
+func TestLoadDispatchesAfterWait(t *testing.T) {
+	r := &recorder{}
+	l := New(r.fetch, time.Millisecond)
+
+	l.Load(context.Background(), 1)
+	l.Load(context.Background(), 2)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.mu.Lock()
+		calls := fmt.Sprint(r.calls)
+		r.mu.Unlock()
+
+		if calls == "[[1 2]]" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("fetched %s, want [[1 2]] once the wait passed", calls)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLoadConcurrently(t *testing.T) {
+	r := &recorder{}
+	l := New(r.fetch, 10*time.Millisecond)
+
+	var thunks []func() (int, error)
+	for i := 0; i < 50; i++ {
+		thunks = append(thunks, l.Load(context.Background(), i))
+	}
+
+	var wg sync.WaitGroup
+	for i, thunk := range thunks {
+		wg.Add(1)
+		go func(key int, thunk func() (int, error)) {
+			defer wg.Done()
+
+			if value, err := thunk(); err != nil || value != key*key {
+				t.Errorf("Load(%d) = %d, %v", key, value, err)
+			}
+		}(i, thunk)
+	}
+	wg.Wait()
+
+	if len(r.calls) != 1 || len(r.calls[0]) != 50 {
+		t.Errorf("fetched %d batches, want one of 50 keys", len(r.calls))
+	}
+}
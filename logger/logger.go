@@ -0,0 +1,134 @@
+// Package logger provides the structured JSON logger used across the server
+// and the middleware that logs every HTTP request with a request ID.
+package logger
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// NewLogger returns a logger writing JSON lines to stdout at level and
+// above. Records logged with a request context carry its request_id.
+func NewLogger(level slog.Level) *slog.Logger {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	return slog.New(contextHandler{handler})
+}
+
+// RequestIDFromContext returns the ID RequestLogger gave the request.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey).(string)
+	return id, ok
+}
+
+// RequestLogger gives each request an ID, stored in the request context and
+// returned in the X-Request-ID header, and logs the method, path, status,
+// duration and client IP of the request once it completes.
+func RequestLogger(log *slog.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := r.Header.Get("X-Request-ID")
+			if id == "" || len(id) > 128 {
+				id = newRequestID()
+			}
+
+			ctx := context.WithValue(r.Context(), requestIDKey, id)
+			w.Header().Set("X-Request-ID", id)
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			log.InfoContext(ctx, "request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rec.status,
+				"duration_ms", float64(time.Since(start).Microseconds())/1000,
+				"client_ip", remoteIP(r),
+			)
+		})
+	}
+}
+
+// contextHandler adds the request ID found in the context to every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := RequestIDFromContext(ctx); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes on to streaming responses such as the export.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades take over the connection, which is logged
+// with status 101.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", r.ResponseWriter)
+	}
+
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// newRequestID returns a random version 4 UUID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
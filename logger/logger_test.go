@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// jsonLogger returns a logger like NewLogger's writing to the returned
+// buffer.
+func jsonLogger() (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return slog.New(contextHandler{slog.NewJSONHandler(&buf, nil)}), &buf
+}
+
+func TestRequestLogger(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		status    int
+		// keepID tells whether the request ID sent is the one used.
+		keepID bool
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "not found", status: http.StatusNotFound},
+		{name: "server error", status: http.StatusInternalServerError},
+		{name: "request ID sent", requestID: "req-42", status: http.StatusOK, keepID: true},
+		{name: "request ID too long", requestID: strings.Repeat("x", 129), status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, buf := jsonLogger()
+
+			var handlerID string
+			h := RequestLogger(log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerID, _ = RequestIDFromContext(r.Context())
+				if tt.status != http.StatusOK {
+					w.WriteHeader(tt.status)
+				}
+			}))
+
+			req := httptest.NewRequest("POST", "/patient?query=x", nil)
+			req.RemoteAddr = "10.0.0.1:5000"
+			if tt.requestID != "" {
+				req.Header.Set("X-Request-ID", tt.requestID)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			var entry map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("log %q is not a JSON line: %v", buf, err)
+			}
+
+			for _, key := range []string{"request_id", "duration_ms", "status", "method", "path", "client_ip"} {
+				if _, ok := entry[key]; !ok {
+					t.Errorf("log %s has no %s", buf, key)
+				}
+			}
+
+			id, _ := entry["request_id"].(string)
+			if tt.keepID && id != tt.requestID {
+				t.Errorf("request_id = %q, want %q", id, tt.requestID)
+			}
+			if !tt.keepID && !uuidPattern.MatchString(id) {
+				t.Errorf("request_id = %q, want a UUID", id)
+			}
+			if got := rec.Header().Get("X-Request-ID"); got != id || handlerID != id {
+				t.Errorf("X-Request-ID = %q and context ID = %q, want the logged %q", got, handlerID, id)
+			}
+
+			if status, _ := entry["status"].(float64); int(status) != tt.status {
+				t.Errorf("status = %v, want %d", entry["status"], tt.status)
+			}
+			if entry["method"] != "POST" || entry["path"] != "/patient" || entry["client_ip"] != "10.0.0.1" {
+				t.Errorf("log %s, want POST /patient from 10.0.0.1", buf)
+			}
+			if duration, ok := entry["duration_ms"].(float64); !ok || duration < 0 {
+				t.Errorf("duration_ms = %v, want a duration", entry["duration_ms"])
+			}
+		})
+	}
+}
+
+func TestRequestIDOnlyWithRequestContext(t *testing.T) {
+	log, buf := jsonLogger()
+
+	log.InfoContext(context.Background(), "started")
+	if strings.Contains(buf.String(), "request_id") {
+		t.Errorf("log %s has a request_id without a request", buf)
+	}
+
+	buf.Reset()
+	log.With("component", "outbox").InfoContext(context.WithValue(context.Background(), requestIDKey, "req-1"), "sent")
+	if !strings.Contains(buf.String(), `"request_id":"req-1"`) || !strings.Contains(buf.String(), `"component":"outbox"`) {
+		t.Errorf("log %s, want the request_id and the attributes of With", buf)
+	}
+}
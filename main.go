@@ -1,258 +1,140 @@
+// Command smart-emerge runs the patient API server, and the maintenance
+// commands operators run against the same database with the same settings:
+//
+//	smart-emerge [serve]
+//	smart-emerge migrate up|down [-steps n]
+//	smart-emerge rotate-keys
+//	smart-emerge approve-queries <manifest>
+//	smart-emerge seed [-count n] [-clinic id]
+//	smart-emerge create-user -subject <id> -role <role> [-clinic id] [-ttl duration]
+//	smart-emerge reindex
 package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
-
-	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
-	"github.com/lib/pq"
-
-	"github.com/graphql-go/graphql"
+	"sort"
+	"strings"
+
+	"github.com/codixir/smart-emerge-starter/config"
+	"github.com/codixir/smart-emerge-starter/logger"
+	"github.com/codixir/smart-emerge-starter/migrate"
+	"github.com/codixir/smart-emerge-starter/migrations"
+	"github.com/codixir/smart-emerge-starter/tracing"
 )
 
-type Patient struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
-	Phone string `json:"phone"`
-}
-
+// logFatal logs err and exits with status 1 unless err is nil.
 func logFatal(err error) {
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("exiting", "error", err)
+		os.Exit(1)
 	}
 }
 
-func init() {
-	err := godotenv.Load()
+// configurePool applies the pool settings to db.
+func configurePool(db *sql.DB, pool config.Pool) {
+	db.SetMaxOpenConns(pool.MaxOpen)
+	db.SetMaxIdleConns(pool.MaxIdle)
+	db.SetConnMaxLifetime(pool.MaxLifetime)
 
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
+	slog.Info("database pool configured", "max_open", pool.MaxOpen, "max_idle", pool.MaxIdle, "max_lifetime_seconds", int(pool.MaxLifetime.Seconds()))
 }
 
-var db *sql.DB
-
-func main() {
-
-	pgURL, err := pq.ParseURL(os.Getenv("DB_URL"))
-	logFatal(err)
-
-	db, err = sql.Open("postgres", pgURL)
-	logFatal(err)
-
-	err = db.Ping()
-	logFatal(err)
-
-	//step 1, a patientType
-
-	var patientType = graphql.NewObject(
-		graphql.ObjectConfig{
-			Name:        "Patient",
-			Description: "This is a patient type.",
-			Fields: graphql.Fields{
-				"id": &graphql.Field{
-					Type: graphql.Int,
-				},
-				"name": &graphql.Field{
-					Type: graphql.String,
-				},
-				"email": &graphql.Field{
-					Type: graphql.String,
-				},
-				"phone": &graphql.Field{
-					Type: graphql.String,
-				},
-			},
-		},
-	)
-
-	//step 2, a queryType --- queries the database / does not modify/mutate the data
-
-	var queryType = graphql.NewObject(
-		graphql.ObjectConfig{
-			Name: "Query",
-			Fields: graphql.Fields{
-				"getPatient": &graphql.Field{
-					Type:        patientType,
-					Description: "Get a patient by id",
-					Args: graphql.FieldConfigArgument{
-						"id": &graphql.ArgumentConfig{
-							Type: graphql.Int,
-						},
-					},
-					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-						id, _ := p.Args["id"].(int)
-						patient := &Patient{}
-
-						err := db.QueryRow("select id, name, email, phone from patients where id=$1", id).
-							Scan(&patient.ID, &patient.Name, &patient.Email, &patient.Phone)
-
-						logFatal(err)
-
-						return patient, nil
-					},
-				},
-				"getPatients": &graphql.Field{
-					Type:        graphql.NewList(patientType),
-					Description: "Gets a patient list",
-					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-						var patients []*Patient
-
-						stmt := "select * from patients"
-						rows, err := db.Query(stmt)
-						logFatal(err)
-
-						for rows.Next() {
-							patient := &Patient{}
-
-							err = rows.Scan(&patient.ID, &patient.Name, &patient.Email,
-								&patient.Phone)
-
-							patients = append(patients, patient)
-						}
-
-						return patients, nil
-					},
-				},
-			},
-		},
-	)
-
-	//step 3, a mutationType --- queries the database / but it changes/mutates the data
-
-	var mutationType = graphql.NewObject(
-		graphql.ObjectConfig{
-			Name: "Mutations",
-			Fields: graphql.Fields{
-				"create": &graphql.Field{
-					Type:        patientType,
-					Description: "Creates a new patient",
-					Args: graphql.FieldConfigArgument{
-						"name": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.String),
-						},
-						"email": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.String),
-						},
-						"phone": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.String),
-						},
-					},
-					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-						patient := Patient{}
-
-						name, _ := params.Args["name"].(string)
-						email, _ := params.Args["email"].(string)
-						phone, _ := params.Args["phone"].(string)
-
-						fmt.Println(name, email, phone)
-
-						stmt := "insert into patients(name, email, phone) values($1, $2, $3) returning id;"
-						var lastInsertID int
-
-						err := db.QueryRow(stmt, name, email, phone).Scan(&lastInsertID)
-
-						logFatal(err)
-
-						patient.ID = lastInsertID
-						patient.Name = name
-						patient.Email = email
-						patient.Phone = phone
-
-						return patient, nil
-					},
-				},
-				"update": &graphql.Field{
-					Type:        patientType,
-					Description: "Updates an existing patient.",
-					Args: graphql.FieldConfigArgument{
-						"id": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.Int),
-						},
-						"name": &graphql.ArgumentConfig{
-							Type: graphql.String,
-						},
-						"email": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.String),
-						},
-						"phone": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.String),
-						},
-					},
-					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-						patient := Patient{}
-						id, _ := params.Args["id"].(int)
-						name, _ := params.Args["name"].(string)
-						email, _ := params.Args["email"].(string)
-						phone, _ := params.Args["phone"].(string)
+// command is a subcommand, run with the arguments after its name.
+type command struct {
+	run     func(args []string) error
+	summary string
+}
 
-						stmt, err := db.Prepare("update patients set name = $1, email = $2, phone = $3 where id =$4")
-						logFatal(err)
+var commands = map[string]command{
+	"serve":           {serve, "run the server, applying pending migrations first (the default)"},
+	"migrate":         {runMigrate, "apply pending migrations (up) or revert the newest ones (down -steps n) and exit"},
+	"rotate-keys":     {rotateKeys, "re-encrypt PHI with the current key and recompute the blind indexes"},
+	"approve-queries": {approveQueries, "approve the queries of an Apollo persisted query manifest"},
+	"seed":            {seedCommand, "create made up patients in a clinic, for development and load tests"},
+	"create-user":     {createUser, "print a bearer token for a user with a role"},
+	"reindex":         {reindex, "rebuild the patient indexes and refresh their statistics"},
+}
 
-						_, err = stmt.Exec(name, email, phone, id)
-						logFatal(err)
+func main() {
+	// Until the configured level is known, log at info so configuration
+	// errors are logged as JSON too.
+	slog.SetDefault(logger.NewLogger(slog.LevelInfo))
+
+	// Without a command, or with only the flags of the commands from before
+	// there were commands, the server runs.
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-						patient.ID = id
-						patient.Name = name
-						patient.Email = email
-						patient.Phone = phone
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
 
-						return patient, nil
-					},
-				},
-				"delete": &graphql.Field{
-					Type:        patientType,
-					Description: "Delete a patient by id",
-					Args: graphql.FieldConfigArgument{
-						"id": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.Int),
-						},
-					},
-					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-						id, _ := params.Args["id"].(int)
+	logFatal(config.LoadDotEnv())
+	logFatal(cmd.run(args))
+}
 
-						stmt, err := db.Prepare("delete from patients where id = $1")
-						logFatal(err)
+// usage prints the commands to stderr.
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-						_, err = stmt.Exec(id)
-						logFatal(err)
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun %s <command> -h for the flags of a command\n", os.Args[0])
+}
 
-						return nil, nil
-					},
-				},
-			},
-		},
-	)
+// loadConfig reads the settings with load and logs at their level from then
+// on.
+func loadConfig(load func() (config.Config, error)) (config.Config, error) {
+	cfg, err := load()
+	if err != nil {
+		return cfg, err
+	}
 
-	//step 4, a schema -- an object that has the queryType and mutationType
+	slog.SetDefault(logger.NewLogger(cfg.LogLevel))
+	return cfg, nil
+}
 
-	var schema, _ = graphql.NewSchema(
-		graphql.SchemaConfig{
-			Query:    queryType,
-			Mutation: mutationType,
-		},
-	)
+// openMigrated opens the Postgres database of cfg and applies the pending
+// migrations, for the commands that need its tables. It fails with the
+// memory driver, whose records would be gone when the command exits.
+func openMigrated(cfg config.Database) (*sql.DB, error) {
+	db, err := openPostgres(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	//step 5, a graphql method called Do, that takes schema and a requestString and
-	//returns a result..
+	if err := migrate.RunMigrations(db, migrations.FS); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	r := mux.NewRouter()
-	r.HandleFunc("/patient", func(w http.ResponseWriter, r *http.Request) {
+	return db, nil
+}
 
-		result := graphql.Do(graphql.Params{
-			Schema:        schema,
-			RequestString: r.URL.Query().Get("query"),
-		})
+func openPostgres(cfg config.Database) (*sql.DB, error) {
+	if cfg.Memory() {
+		return nil, fmt.Errorf("the command needs DB_DRIVER=postgres")
+	}
 
-		json.NewEncoder(w).Encode(result)
-	})
+	db, err := tracing.OpenPostgres(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Println("Listening on port 8000")
-	http.ListenAndServe(":8000", r)
+	configurePool(db, cfg.Pool)
+	return db, nil
 }
@@ -0,0 +1,183 @@
+// Package metrics collects the Prometheus metrics of the service: HTTP
+// requests, GraphQL operations and resolvers, and the database pool.
+package metrics
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/codixir/smart-emerge-starter/chain"
+)
+
+// Metrics holds the collectors, registered on a registry of their own so
+// only these metrics and the Go runtime's are exposed.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	operations        *prometheus.CounterVec
+	operationDuration *prometheus.HistogramVec
+
+	resolverDuration *prometheus.HistogramVec
+	resolverErrors   *prometheus.CounterVec
+}
+
+// New returns Metrics reporting the connection pool statistics of db and of
+// the read replica, each left out when nil.
+func New(db, replica *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "graphql_operations_total",
+			Help: "GraphQL operations by type, root fields and outcome (success or error).",
+		}, []string{"type", "operation", "outcome"}),
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "graphql_operation_duration_seconds",
+			Help:    "GraphQL operation latency by type and root fields.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"type", "operation"}),
+		resolverDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "graphql_resolver_duration_seconds",
+			Help:    "Latency of the GraphQL field resolvers by Type.field.",
+			Buckets: []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"field"}),
+		resolverErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "graphql_resolver_errors_total",
+			Help: "Errors returned by the GraphQL field resolvers by Type.field and error code.",
+		}, []string{"field", "code"}),
+	}
+
+	m.registry.MustRegister(
+		m.httpRequests, m.httpDuration,
+		m.operations, m.operationDuration,
+		m.resolverDuration, m.resolverErrors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+	}
+	if replica != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(replica, "postgres_replica"))
+	}
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts and times requests, labelled by the path template of
+// the matched route so ids in paths do not create new series.
+func (m *Metrics) Middleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			route := "unknown"
+			if current := mux.CurrentRoute(r); current != nil {
+				if template, err := current.GetPathTemplate(); err == nil {
+					route = template
+				}
+			}
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			m.httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+			m.httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// ObserveOperation records a GraphQL operation of operationType selecting
+// rootFields that took d and failed when result has errors.
+func (m *Metrics) ObserveOperation(operationType string, rootFields []string, d time.Duration, result *graphql.Result) {
+	operation := strings.Join(rootFields, ",")
+	if operation == "" {
+		operation = "unknown"
+	}
+
+	outcome := "success"
+	if result.HasErrors() {
+		outcome = "error"
+	}
+
+	m.operations.WithLabelValues(operationType, operation, outcome).Inc()
+	m.operationDuration.WithLabelValues(operationType, operation).Observe(d.Seconds())
+}
+
+// ResolverMiddleware records the latency and errors of every field that has
+// its own resolver. Resolvers returning a thunk are timed until the thunk is
+// returned, not until it is resolved.
+func (m *Metrics) ResolverMiddleware() chain.Middleware {
+	return func(field chain.Field, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+		name := field.String()
+		duration := m.resolverDuration.WithLabelValues(name)
+
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			start := time.Now()
+			result, err := next(p)
+			duration.Observe(time.Since(start).Seconds())
+
+			if err != nil {
+				m.resolverErrors.WithLabelValues(name, chain.ErrorCode(err)).Inc()
+			}
+
+			return result, err
+		}
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes on to streaming responses such as the export.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades take over the connection.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", r.ResponseWriter)
+	}
+
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+type contextKey int
+
+const (
+	userIDKey contextKey = iota
+	rolesKey
+	clientIPKey
+)
+
+// The roles a token can grant in its roles claim.
+const (
+	RoleAdmin     = "admin"
+	RoleClinician = "clinician"
+	RoleReadonly  = "readonly"
+	// RolePrivacyOfficer exports and erases the data of patients on their
+	// request.
+	RolePrivacyOfficer = "privacy_officer"
+)
+
+// Roles are the roles a token can grant.
+var Roles = []string{RoleAdmin, RoleClinician, RoleReadonly, RolePrivacyOfficer}
+
+// ClinicHeader selects the clinic an admin acts for.
+const ClinicHeader = "X-Clinic-ID"
+
+// ErrClinicForbidden is returned by Authenticate when the token may not act
+// for the clinic asked for.
+var ErrClinicForbidden = errors.New("the token does not grant access to this clinic")
+
+// claims are the JWT claims read from bearer tokens.
+type claims struct {
+	Roles []string `json:"roles"`
+	// ClinicID is the clinic the caller belongs to.
+	ClinicID *int `json:"clinic_id"`
+	jwt.RegisteredClaims
+}
+
+// JWTMiddleware authenticates requests carrying an HS256 signed bearer token
+// and stores the token's sub and roles claims in the request context, along
+// with the clinic the request acts for (see Authenticate). Requests without
+// an Authorization header pass through anonymously; requests with an invalid
+// or expired token are rejected with 401, and those asking for a clinic the
+// token does not grant with 403.
+func JWTMiddleware(secretKey []byte) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, err := Authenticate(r.Context(), header, r.Header.Get(ClinicHeader), secretKey)
+			if errors.Is(err, ErrClinicForbidden) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Authenticate verifies a "Bearer <token>" authorization value like
+// JWTMiddleware and returns ctx carrying the token's user ID and roles, and
+// the clinic of its clinic_id claim. Admins may act for another clinic by
+// passing its id as clinic, the value of the X-Clinic-ID header; other
+// callers may only pass their own, and fail with ErrClinicForbidden. It is
+// used where the token does not arrive in a header, such as the
+// connection_init message of a WebSocket.
+func Authenticate(ctx context.Context, authorization, clinic string, secretKey []byte) (context.Context, error) {
+	claims, err := parseToken(authorization, secretKey)
+	if err != nil {
+		return ctx, err
+	}
+
+	clinicID := 0
+	if claims.ClinicID != nil {
+		clinicID = *claims.ClinicID
+	}
+
+	if clinic != "" {
+		id, err := strconv.Atoi(clinic)
+		if err != nil || id < 1 {
+			return ctx, fmt.Errorf("%s must be a clinic id, got %q", ClinicHeader, clinic)
+		}
+
+		if id != clinicID && !hasRole(claims.Roles, RoleAdmin) {
+			return ctx, ErrClinicForbidden
+		}
+		clinicID = id
+	}
+
+	// A clinic of an earlier token in ctx, such as the one of a WebSocket
+	// handshake, is replaced as well.
+	ctx = context.WithValue(ctx, userIDKey, claims.Subject)
+	ctx = context.WithValue(ctx, rolesKey, claims.Roles)
+	return tenant.WithClinic(ctx, clinicID), nil
+}
+
+// UserIDFromContext returns the authenticated user ID stored by JWTMiddleware.
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userIDKey).(string)
+	return userID, ok && userID != ""
+}
+
+// RolesFromContext returns the roles of the authenticated user stored by
+// JWTMiddleware.
+func RolesFromContext(ctx context.Context) []string {
+	roles, _ := ctx.Value(rolesKey).([]string)
+	return roles
+}
+
+// HasRole reports whether the authenticated user has at least one of roles.
+func HasRole(ctx context.Context, roles ...string) bool {
+	return hasRole(RolesFromContext(ctx), roles...)
+}
+
+// hasRole reports whether granted holds at least one of roles.
+func hasRole(granted []string, roles ...string) bool {
+	for _, have := range granted {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// IssueToken returns an HS256 bearer token signed with secretKey for the
+// user subject, granting roles and acting for clinic when it is not nil, and
+// expiring after ttl. Users are not stored: any token signed with the key of
+// the server is trusted.
+func IssueToken(secretKey []byte, subject string, roles []string, clinic *int, ttl time.Duration) (string, error) {
+	now := time.Now()
+	c := claims{
+		Roles:    roles,
+		ClinicID: clinic,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(secretKey)
+}
+
+func parseToken(header string, secretKey []byte) (*claims, error) {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, fmt.Errorf("authorization header must use the Bearer scheme")
+	}
+
+	// Only HS256 is accepted, so that a token cannot pick another algorithm
+	// to be checked with, such as none.
+	c := &claims{}
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(header, "Bearer "), c, func(*jwt.Token) (interface{}, error) {
+		return secretKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+
+	if c.Subject == "" {
+		return nil, fmt.Errorf("invalid token: missing sub claim")
+	}
+
+	return c, nil
+}
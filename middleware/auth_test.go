@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+var testSecret = []byte("test-secret-of-at-least-32-bytes!")
+
+func signed(t *testing.T, method jwt.SigningMethod, key interface{}, c claims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, c).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	clinic, other := 1, 2
+	valid, err := IssueToken(testSecret, "user-1", []string{RoleClinician}, &clinic, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := IssueToken(testSecret, "admin-1", []string{RoleAdmin}, &clinic, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := IssueToken(testSecret, "user-1", []string{RoleClinician}, &clinic, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongKey, err := IssueToken([]byte("another-secret-of-at-least-32-bytes"), "user-1", []string{RoleClinician}, &clinic, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs512 := signed(t, jwt.SigningMethodHS512, testSecret, claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "user-1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}})
+	unsigned := signed(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "user-1"}})
+	noSubject := signed(t, jwt.SigningMethodHS256, testSecret, claims{Roles: []string{RoleAdmin}, RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}})
+
+	tests := []struct {
+		name          string
+		authorization string
+		clinicHeader  string
+		status        int
+		user          string
+		roles         []string
+		clinic        int
+		err           string
+	}{
+		{name: "no token", status: http.StatusOK},
+		{name: "valid token", authorization: "Bearer " + valid, status: http.StatusOK, user: "user-1", roles: []string{RoleClinician}, clinic: 1},
+		{name: "expired token", authorization: "Bearer " + expired, status: http.StatusUnauthorized, err: "expired"},
+		{name: "wrong signature", authorization: "Bearer " + wrongKey, status: http.StatusUnauthorized, err: "signature is invalid"},
+		{name: "other algorithm", authorization: "Bearer " + hs512, status: http.StatusUnauthorized, err: "signing method HS512 is invalid"},
+		{name: "unsigned token", authorization: "Bearer " + unsigned, status: http.StatusUnauthorized, err: "invalid token"},
+		{name: "no sub claim", authorization: "Bearer " + noSubject, status: http.StatusUnauthorized, err: "missing sub claim"},
+		{name: "other scheme", authorization: "Basic dXNlcjpwYXNz", status: http.StatusUnauthorized, err: "Bearer scheme"},
+		{name: "garbage", authorization: "Bearer not.a.token", status: http.StatusUnauthorized, err: "invalid token"},
+		{name: "own clinic", authorization: "Bearer " + valid, clinicHeader: "1", status: http.StatusOK, user: "user-1", roles: []string{RoleClinician}, clinic: 1},
+		{name: "other clinic", authorization: "Bearer " + valid, clinicHeader: "2", status: http.StatusForbidden, err: ErrClinicForbidden.Error()},
+		{name: "admin acting for another clinic", authorization: "Bearer " + admin, clinicHeader: "2", status: http.StatusOK, user: "admin-1", roles: []string{RoleAdmin}, clinic: other},
+		{name: "invalid clinic", authorization: "Bearer " + admin, clinicHeader: "two", status: http.StatusUnauthorized, err: ClinicHeader + " must be a clinic id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user string
+			var authenticated bool
+			var roles []string
+			var clinicID int
+			h := JWTMiddleware(testSecret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user, authenticated = UserIDFromContext(r.Context())
+				roles = RolesFromContext(r.Context())
+				clinicID, _ = tenant.ClinicFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest("POST", "/patient", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.clinicHeader != "" {
+				req.Header.Set(ClinicHeader, tt.clinicHeader)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.err != "" && !strings.Contains(rec.Body.String(), tt.err) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body, tt.err)
+			}
+			if tt.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+
+			if user != tt.user || authenticated != (tt.user != "") {
+				t.Errorf("UserIDFromContext() = %q, %v, want %q", user, authenticated, tt.user)
+			}
+			if strings.Join(roles, ",") != strings.Join(tt.roles, ",") {
+				t.Errorf("RolesFromContext() = %v, want %v", roles, tt.roles)
+			}
+			if clinicID != tt.clinic {
+				t.Errorf("clinic = %d, want %d", clinicID, tt.clinic)
+			}
+		})
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		granted []string
+		roles   []string
+		want    bool
+	}{
+		{[]string{RoleAdmin}, []string{RoleAdmin}, true},
+		{[]string{RoleClinician}, []string{RoleAdmin, RoleClinician}, true},
+		{[]string{RoleReadonly}, []string{RoleAdmin, RoleClinician}, false},
+		{nil, []string{RoleReadonly}, false},
+		{[]string{RoleAdmin}, nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := hasRole(tt.granted, tt.roles...); got != tt.want {
+			t.Errorf("hasRole(%v, %v) = %v, want %v", tt.granted, tt.roles, got, tt.want)
+		}
+	}
+}
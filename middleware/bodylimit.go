@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// MaxBodySize answers 413 to requests whose Content-Length exceeds n bytes.
+// Bodies sent without one fail to read past n bytes with an
+// *http.MaxBytesError, which the handlers answer with 413 too. An n of zero
+// or less disables the limit.
+func MaxBodySize(n int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if n <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, fmt.Sprintf("the request body is larger than %d bytes", n), http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
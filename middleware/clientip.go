@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ClientIPMiddleware stores the IP of the client in the request context,
+// taken from the address the proxy appended to X-Forwarded-For with
+// trustProxy like RateLimiter.
+func ClientIPMiddleware(trustProxy bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIPKey, clientIP(r, trustProxy))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIPFromContext returns the client IP stored by ClientIPMiddleware.
+func ClientIPFromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(clientIPKey).(string)
+	return ip, ok && ip != ""
+}
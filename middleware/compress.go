@@ -0,0 +1,280 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// The content codings Compress negotiates.
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}}
+	flateWriters = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return w
+	}}
+)
+
+// Compress compresses the bodies of responses of at least minBytes with
+// gzip or deflate, whichever the Accept-Encoding header of the request
+// prefers, gzip on a tie. Only text, JSON and JavaScript bodies are
+// compressed, and not those already encoded or answering a range request. A
+// body is held back until it reaches minBytes or the handler flushes it, so
+// streamed responses such as the export are compressed as they are written.
+// WebSocket upgrades are passed through. A minBytes of zero or less disables
+// compression.
+func Compress(minBytes int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if minBytes <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minBytes: minBytes}
+			defer cw.close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding returns the coding of header, an Accept-Encoding value,
+// Compress answers with, or "" for none. A q of 0 refuses a coding, and *
+// stands for the codings not listed.
+func negotiateEncoding(header string) string {
+	q := map[string]float64{}
+	wildcard := -1.0
+
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		weight := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				parsed, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					parsed = 0
+				}
+				weight = parsed
+			}
+		}
+
+		if name == "*" {
+			wildcard = weight
+		} else if name != "" {
+			q[name] = weight
+		}
+	}
+
+	weight := func(coding string) float64 {
+		if w, ok := q[coding]; ok {
+			return w
+		}
+		return max(wildcard, 0)
+	}
+
+	gz, deflate := weight(encodingGzip), weight(encodingDeflate)
+	switch {
+	case gz > 0 && gz >= deflate:
+		return encodingGzip
+	case deflate > 0:
+		return encodingDeflate
+	}
+
+	return ""
+}
+
+// compressible reports whether bodies of contentType are worth compressing.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "/json") ||
+		strings.HasSuffix(mediaType, "+json") || mediaType == "application/javascript"
+}
+
+// compressWriter holds back the start of a body until it can tell whether to
+// compress it, then writes it through enc, or as is when enc is nil.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minBytes int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	// Informational responses are sent on straight away.
+	if status < http.StatusOK {
+		c.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	if !c.decided {
+		c.buf = append(c.buf, p...)
+		if len(c.buf) < c.minBytes {
+			return len(p), nil
+		}
+
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if c.enc != nil {
+		return c.enc.Write(p)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// decide sends the header, compressing the body when large says it is large
+// enough and its status and headers allow it, and writes the held back
+// start of the body.
+func (c *compressWriter) decide(large bool) error {
+	c.decided = true
+
+	header := c.Header()
+	if header.Get("Content-Type") == "" && len(c.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+
+	compress := large && compressible(header.Get("Content-Type")) &&
+		header.Get("Content-Encoding") == "" && header.Get("Content-Range") == "" &&
+		c.status != http.StatusNoContent && c.status != http.StatusNotModified && c.status != http.StatusPartialContent
+
+	if compress {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", c.encoding)
+
+		if c.encoding == encodingGzip {
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(c.ResponseWriter)
+			c.enc = gz
+		} else {
+			fl := flateWriters.Get().(*flate.Writer)
+			fl.Reset(c.ResponseWriter)
+			c.enc = fl
+		}
+	}
+
+	if c.status != 0 {
+		c.ResponseWriter.WriteHeader(c.status)
+	}
+
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if c.enc != nil {
+		_, err = c.enc.Write(buf)
+	} else {
+		_, err = c.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what was written so far, compressing the body from then on
+// even when it is still shorter than minBytes, as it is streamed.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		if c.status == 0 {
+			c.status = http.StatusOK
+		}
+		c.decide(true)
+	}
+
+	if c.enc != nil {
+		c.enc.Flush()
+	}
+
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets a handler take over the connection, which it may only do
+// before writing.
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := c.ResponseWriter.(http.Hijacker)
+	if !ok || c.decided {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", c.ResponseWriter)
+	}
+
+	c.decided = true
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// close sends a body that stayed shorter than minBytes as is, and ends a
+// compressed one.
+func (c *compressWriter) close() {
+	if !c.decided {
+		c.decide(false)
+	}
+
+	if c.enc == nil {
+		return
+	}
+
+	c.enc.Close()
+	switch enc := c.enc.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(enc)
+	case *flate.Writer:
+		flateWriters.Put(enc)
+	}
+	c.enc = nil
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// IdempotencyKeyHeader makes the create mutations of a GraphQL request safe
+// to retry, like their idempotencyKey argument.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	corsAllowedMethods = "GET, POST, PUT, OPTIONS"
+	corsAllowedHeaders = "Content-Type, Authorization, " + ClinicHeader + ", " + IdempotencyKeyHeader
+	// corsMaxAge is how long browsers may cache a preflight, in seconds.
+	corsMaxAge = "600"
+)
+
+// CORSMiddleware sets the CORS headers for requests coming from one of
+// allowedOrigins, where "*" allows any origin. Requests from other origins get
+// no Access-Control-Allow-Origin header, so browsers do not hand them the
+// response. Preflight OPTIONS requests are answered without reaching the
+// wrapped handler, with 204 for allowed origins and 403 for the others.
+func CORSMiddleware(allowedOrigins []string) mux.MiddlewareFunc {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[origin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			originAllowed := origin != "" && (allowed[origin] || allowed["*"])
+
+			w.Header().Add("Vary", "Origin")
+			if originAllowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+				w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			}
+
+			if r.Method == http.MethodOptions {
+				if origin != "" && !originAllowed {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
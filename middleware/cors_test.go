@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		method  string
+		origin  string
+		status  int
+		acao    string
+		reached bool
+	}{
+		{"preflight from an allowed origin", []string{"https://app.example.com"}, "OPTIONS", "https://app.example.com", http.StatusNoContent, "https://app.example.com", false},
+		{"preflight from another origin", []string{"https://app.example.com"}, "OPTIONS", "https://evil.example.com", http.StatusForbidden, "", false},
+		{"preflight without an origin", []string{"https://app.example.com"}, "OPTIONS", "", http.StatusNoContent, "", false},
+		{"request from an allowed origin", []string{"https://app.example.com"}, "POST", "https://app.example.com", http.StatusOK, "https://app.example.com", true},
+		{"request from another origin", []string{"https://app.example.com"}, "POST", "https://evil.example.com", http.StatusOK, "", true},
+		{"any origin", []string{"*"}, "POST", "https://any.example.com", http.StatusOK, "https://any.example.com", true},
+		{"no origins allowed", nil, "POST", "https://app.example.com", http.StatusOK, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			h := CORSMiddleware(tt.allowed)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+
+			req := httptest.NewRequest(tt.method, "/patient", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			req.Header.Set("Access-Control-Request-Method", "POST")
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if reached != tt.reached {
+				t.Errorf("handler reached = %v, want %v", reached, tt.reached)
+			}
+
+			header := rec.Header()
+			if got := header.Get("Access-Control-Allow-Origin"); got != tt.acao {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.acao)
+			}
+			if tt.acao != "" {
+				if got := header.Get("Access-Control-Allow-Methods"); got != corsAllowedMethods {
+					t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, corsAllowedMethods)
+				}
+				if got := header.Get("Access-Control-Allow-Headers"); got != corsAllowedHeaders {
+					t.Errorf("Access-Control-Allow-Headers = %q, want %q", got, corsAllowedHeaders)
+				}
+			} else if got := header.Get("Access-Control-Allow-Methods"); got != "" {
+				t.Errorf("Access-Control-Allow-Methods = %q for a disallowed origin", got)
+			}
+			if got := header.Get("Vary"); got != "Origin" {
+				t.Errorf("Vary = %q, want Origin", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+)
+
+const (
+	limiterTTL      = 5 * time.Minute
+	limiterEviction = time.Minute
+)
+
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen int64 // unix nanoseconds, accessed atomically
+}
+
+// RateLimiter limits every client IP to rps requests per second with bursts
+// of up to burst requests, answering 429 with a Retry-After header once the
+// bucket is empty. The client IP is taken from X-Forwarded-For with
+// trustProxy, as clientIP does, and from the connection otherwise. Limiters of clients not seen
+// for five minutes are dropped in the background until ctx is done.
+func RateLimiter(ctx context.Context, rps float64, burst int, trustProxy bool) mux.MiddlewareFunc {
+	return keyedRateLimiter(ctx, rps, burst, func(r *http.Request) (string, bool) {
+		return clientIP(r, trustProxy), true
+	})
+}
+
+// TokenRateLimiter limits the requests of every authenticated user, the
+// subject of their bearer token, like RateLimiter does for client IPs, so
+// clients sharing an IP behind a proxy or NAT do not share a limit and one
+// token cannot use the limits of many IPs. It must run after JWTMiddleware;
+// anonymous requests are left to RateLimiter.
+func TokenRateLimiter(ctx context.Context, rps float64, burst int) mux.MiddlewareFunc {
+	return keyedRateLimiter(ctx, rps, burst, func(r *http.Request) (string, bool) {
+		userID, ok := UserIDFromContext(r.Context())
+		return userID, ok && userID != ""
+	})
+}
+
+// keyedRateLimiter limits the requests sharing a key to rps requests per
+// second with bursts of up to burst requests. Requests key returns false for
+// are not limited. The limiters are evicted until ctx is done.
+func keyedRateLimiter(ctx context.Context, rps float64, burst int, key func(*http.Request) (string, bool)) mux.MiddlewareFunc {
+	var visitors sync.Map
+
+	go func() {
+		ticker := time.NewTicker(limiterEviction)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			evictVisitors(&visitors, time.Now().Add(-limiterTTL))
+		}
+	}()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k, ok := key(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			now := time.Now()
+
+			value, ok := visitors.Load(k)
+			if !ok {
+				value, _ = visitors.LoadOrStore(k, &visitor{limiter: rate.NewLimiter(rate.Limit(rps), burst)})
+			}
+			v := value.(*visitor)
+			atomic.StoreInt64(&v.lastSeen, now.UnixNano())
+
+			reservation := v.limiter.ReserveN(now, 1)
+			if delay := reservation.DelayFrom(now); !reservation.OK() || delay > 0 {
+				reservation.CancelAt(now)
+
+				retryAfter := int(math.Ceil(delay.Seconds()))
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// evictVisitors drops the visitors last seen before cutoff.
+func evictVisitors(visitors *sync.Map, cutoff time.Time) {
+	visitors.Range(func(key, value interface{}) bool {
+		if atomic.LoadInt64(&value.(*visitor).lastSeen) < cutoff.UnixNano() {
+			visitors.Delete(key)
+		}
+		return true
+	})
+}
+
+// clientIP returns the IP of the client that sent r. With trustProxy the
+// last address of X-Forwarded-For is used when it is an IP: the trusted
+// proxy appends the address it was connected from, while the addresses
+// before it are sent by the client, which can write anything there.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if forwarded := r.Header.Values("X-Forwarded-For"); trustProxy && len(forwarded) > 0 {
+		addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+		if ip := net.ParseIP(strings.TrimSpace(addresses[len(addresses)-1])); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"connection", false, "10.0.0.1:5000", nil, "10.0.0.1"},
+		{"forwarded ignored", false, "10.0.0.1:5000", []string{"203.0.113.7"}, "10.0.0.1"},
+		{"forwarded trusted", true, "10.0.0.1:5000", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"spoofed address ignored", true, "10.0.0.1:5000", []string{"198.51.100.9, 203.0.113.7"}, "203.0.113.7"},
+		{"spoofed header ignored", true, "10.0.0.1:5000", []string{"198.51.100.9", "203.0.113.7"}, "203.0.113.7"},
+		{"IPv6", true, "10.0.0.1:5000", []string{"2001:DB8::1"}, "2001:db8::1"},
+		{"not an IP", true, "10.0.0.1:5000", []string{"203.0.113.7, evil"}, "10.0.0.1"},
+		{"trusted without header", true, "10.0.0.1:5000", nil, "10.0.0.1"},
+		{"address without port", false, "10.0.0.1", nil, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, forwarded := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", forwarded)
+			}
+
+			var got string
+			ClientIPMiddleware(tt.trustProxy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = ClientIPFromContext(r.Context())
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Fatalf("client IP = %q, want %q", got, tt.want)
+			}
+			if direct := clientIP(req, tt.trustProxy); direct != tt.want {
+				t.Fatalf("clientIP() = %q, want %q", direct, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvictVisitors(t *testing.T) {
+	now := time.Now()
+
+	var visitors sync.Map
+	visitors.Store("stale", &visitor{limiter: rate.NewLimiter(1, 1), lastSeen: now.Add(-limiterTTL - time.Second).UnixNano()})
+	visitors.Store("recent", &visitor{limiter: rate.NewLimiter(1, 1), lastSeen: now.UnixNano()})
+
+	evictVisitors(&visitors, now.Add(-limiterTTL))
+
+	if _, ok := visitors.Load("stale"); ok {
+		t.Error("the stale visitor was kept")
+	}
+	if _, ok := visitors.Load("recent"); !ok {
+		t.Error("the recent visitor was dropped")
+	}
+}
+
+func TestRateLimiterTrustProxy(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		// status is the status of a request from a second forwarded client
+		// once the first one used its burst.
+		status int
+	}{
+		{"trusted", true, http.StatusOK},
+		{"untrusted", false, http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			h := RateLimiter(ctx, 0.01, 1, tt.trustProxy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			send := func(forwarded string) int {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.RemoteAddr = "10.0.0.1:5000"
+				req.Header.Set("X-Forwarded-For", forwarded)
+
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			if status := send("203.0.113.7"); status != http.StatusOK {
+				t.Fatalf("first request status = %d", status)
+			}
+			if status := send("203.0.113.8"); status != tt.status {
+				t.Fatalf("second client status = %d, want %d", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestRateLimiterIgnoresSpoofedForwarding(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := RateLimiter(ctx, 0.01, 1, true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// The client rotates the address it sends, which the proxy forwards with
+	// the client's own address appended.
+	for i, spoofed := range []string{"198.51.100.1", "198.51.100.2"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:5000"
+		req.Header.Set("X-Forwarded-For", spoofed+", 203.0.113.7")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if want := []int{http.StatusOK, http.StatusTooManyRequests}[i]; rec.Code != want {
+			t.Fatalf("request %d status = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		rps      float64
+		burst    int
+		requests int
+		// ok is the number of requests let through, the rest getting 429.
+		ok int
+	}{
+		{"below the limit", 1, 5, 4, 4},
+		{"at the limit", 1, 5, 5, 5},
+		{"above the limit", 1, 5, 8, 5},
+		{"burst of one", 1, 1, 3, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			h := RateLimiter(ctx, tt.rps, tt.burst, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			for i := 0; i < tt.requests; i++ {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.RemoteAddr = "10.0.0.1:5000"
+
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				want, retryAfter := http.StatusOK, ""
+				if i >= tt.ok {
+					want, retryAfter = http.StatusTooManyRequests, "1"
+				}
+				if rec.Code != want {
+					t.Fatalf("request %d: status = %d, want %d", i+1, rec.Code, want)
+				}
+				if got := rec.Header().Get("Retry-After"); got != retryAfter {
+					t.Errorf("request %d: Retry-After = %q, want %q", i+1, got, retryAfter)
+				}
+			}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "10.0.0.2:5000"
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Errorf("another client: status = %d, want %d", rec.Code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := RateLimiter(ctx, 0.1, 1, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	}
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Retry-After = %q, want 10 seconds at 0.1 requests per second", got)
+	}
+}
+
+func TestTokenRateLimiter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := TokenRateLimiter(ctx, 0.01, 1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(user string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		if user != "" {
+			req = req.WithContext(context.WithValue(req.Context(), userIDKey, user))
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		user   string
+		status int
+	}{
+		{"user-1", http.StatusOK},
+		{"user-1", http.StatusTooManyRequests},
+		{"user-2", http.StatusOK},
+		{"", http.StatusOK},
+		{"", http.StatusOK},
+	}
+
+	for i, tt := range tests {
+		if status := send(tt.user); status != tt.status {
+			t.Errorf("request %d as %q: status = %d, want %d", i+1, tt.user, status, tt.status)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Timeout cancels the context of requests still running after d, which
+// aborts their database queries. A d of zero or less disables the timeout.
+func Timeout(d time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,298 @@
+// Package migrate applies the SQL migrations found in a file system to a
+// Postgres database, recording applied versions in schema_migrations.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version BIGINT PRIMARY KEY,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// downSuffix ends the file names of down migrations, which undo the
+// migration with the same version.
+const downSuffix = ".down.sql"
+
+// Migration is a single numbered SQL file and the file reverting it, if any.
+type Migration struct {
+	Version int64
+	Name    string
+	Down    string
+}
+
+// RunMigrations applies, in version order, every migration in fsys that is
+// not yet recorded in schema_migrations. Each migration runs in its own
+// transaction together with its bookkeeping row, so a failed migration leaves
+// no trace and running again is always safe.
+func RunMigrations(db *sql.DB, fsys fs.FS) error {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createMigrationsTable); err != nil {
+		return fmt.Errorf("migrate: could not create schema_migrations: %w", err)
+	}
+
+	for _, m := range migrations {
+		applied, err := apply(db, fsys, m)
+		if err != nil {
+			return err
+		}
+
+		if applied {
+			slog.Info("migration applied", "migration", m.Name)
+		}
+	}
+
+	return nil
+}
+
+// Load lists the migrations in fsys sorted by version. File names must start
+// with a numeric version followed by an underscore, e.g. 001_create_patients.sql,
+// and the matching down migration is named 001_create_patients.down.sql.
+func Load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int64]string)
+	downs := make(map[int64]string)
+
+	for _, name := range names {
+		prefix := strings.SplitN(path.Base(name), "_", 2)[0]
+
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %s does not start with a version number", name)
+		}
+
+		if strings.HasSuffix(name, downSuffix) {
+			if other, ok := downs[version]; ok {
+				return nil, fmt.Errorf("migrate: %s and %s share version %d", other, name, version)
+			}
+			downs[version] = name
+			continue
+		}
+
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrate: %s and %s share version %d", other, name, version)
+		}
+		seen[version] = name
+
+		migrations = append(migrations, Migration{Version: version, Name: name})
+	}
+
+	for version, down := range downs {
+		if _, ok := seen[version]; !ok {
+			return nil, fmt.Errorf("migrate: %s has no up migration", down)
+		}
+	}
+
+	for i := range migrations {
+		migrations[i].Down = downs[migrations[i].Version]
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Status is how the migrations of a file system compare with the ones
+// applied to a database.
+type Status struct {
+	// Current is the newest applied version, 0 when there is none. It is
+	// above Latest while a newer release that added migrations is rolling
+	// out.
+	Current int64
+	// Latest is the newest version in the file system.
+	Latest int64
+	// Pending lists the migrations of the file system not applied yet.
+	Pending []string
+}
+
+// CheckStatus compares the migrations in fsys with those recorded in
+// schema_migrations, without applying or locking anything.
+func CheckStatus(ctx context.Context, db *sql.DB, fsys fs.FS) (Status, error) {
+	var status Status
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		return status, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return status, fmt.Errorf("migrate: could not read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return status, err
+		}
+
+		applied[version] = true
+		if version > status.Current {
+			status.Current = version
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return status, err
+	}
+
+	for _, m := range migrations {
+		status.Latest = m.Version
+		if !applied[m.Version] {
+			status.Pending = append(status.Pending, m.Name)
+		}
+	}
+
+	return status, nil
+}
+
+// Rollback reverts the steps most recently applied migrations, newest first,
+// using their down migrations. It stops early when no migrations are left
+// and fails, before touching the database, when a migration to revert has
+// no down migration.
+func Rollback(db *sql.DB, fsys fs.FS, steps int) error {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return err
+	}
+
+	byVersion := make(map[int64]Migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	if _, err := db.Exec(createMigrationsTable); err != nil {
+		return fmt.Errorf("migrate: could not create schema_migrations: %w", err)
+	}
+
+	rows, err := db.Query("SELECT version FROM schema_migrations ORDER BY version DESC LIMIT $1", steps)
+	if err != nil {
+		return err
+	}
+
+	var pending []Migration
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+
+		m, ok := byVersion[version]
+		if !ok || m.Down == "" {
+			rows.Close()
+			return fmt.Errorf("migrate: version %d has no down migration", version)
+		}
+
+		pending = append(pending, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		reverted, err := revert(db, fsys, m)
+		if err != nil {
+			return err
+		}
+
+		if reverted {
+			slog.Info("migration reverted", "migration", m.Name)
+		}
+	}
+
+	return nil
+}
+
+// apply runs m unless it is already recorded. The exclusive lock on
+// schema_migrations keeps two instances starting at once from applying the
+// same migration twice.
+func apply(db *sql.DB, fsys fs.FS, m Migration) (bool, error) {
+	body, err := fs.ReadFile(fsys, m.Name)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("LOCK TABLE schema_migrations IN EXCLUSIVE MODE"); err != nil {
+		return false, fmt.Errorf("migrate: could not lock schema_migrations: %w", err)
+	}
+
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.Version).Scan(&exists)
+	if err != nil || exists {
+		return false, err
+	}
+
+	if _, err := tx.Exec(string(body)); err != nil {
+		return false, fmt.Errorf("migrate: %s failed: %w", m.Name, err)
+	}
+
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", m.Version); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// revert runs the down migration of m if m is still the newest applied
+// migration, under the same lock as apply.
+func revert(db *sql.DB, fsys fs.FS, m Migration) (bool, error) {
+	body, err := fs.ReadFile(fsys, m.Down)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("LOCK TABLE schema_migrations IN EXCLUSIVE MODE"); err != nil {
+		return false, fmt.Errorf("migrate: could not lock schema_migrations: %w", err)
+	}
+
+	var latest sql.NullInt64
+	if err := tx.QueryRow("SELECT max(version) FROM schema_migrations").Scan(&latest); err != nil {
+		return false, err
+	}
+	if !latest.Valid || latest.Int64 != m.Version {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(string(body)); err != nil {
+		return false, fmt.Errorf("migrate: %s failed: %w", m.Down, err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.Version); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
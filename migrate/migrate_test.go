@@ -0,0 +1,403 @@
+package migrate_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/migrate"
+	"github.com/codixir/smart-emerge-starter/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []migrate.Migration
+		err   string
+	}{
+		{"empty", nil, nil, ""},
+		{
+			"sorted by version",
+			[]string{"010_c.sql", "002_b.sql", "001_a.sql", "001_a.down.sql", "README.md"},
+			[]migrate.Migration{
+				{Version: 1, Name: "001_a.sql", Down: "001_a.down.sql"},
+				{Version: 2, Name: "002_b.sql"},
+				{Version: 10, Name: "010_c.sql"},
+			},
+			"",
+		},
+		{"no version", []string{"create_patients.sql"}, nil, "does not start with a version number"},
+		{"shared version", []string{"001_a.sql", "001_b.sql"}, nil, "share version 1"},
+		{"shared down version", []string{"001_a.sql", "001_a.down.sql", "001_b.down.sql"}, nil, "share version 1"},
+		{"down without up", []string{"001_a.sql", "002_b.down.sql"}, nil, "has no up migration"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, name := range tt.files {
+				fsys[name] = &fstest.MapFile{Data: []byte("select 1")}
+			}
+
+			got, err := migrate.Load(fsys)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEmbeddedMigrations checks the migrations shipped with the server are
+// numbered without gaps, start with the patients table and can be reverted.
+func TestEmbeddedMigrations(t *testing.T) {
+	got, err := migrate.Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) == 0 || got[0].Name != "001_create_patients.sql" {
+		t.Fatalf("migrations = %+v, want 001_create_patients.sql first", got)
+	}
+
+	for i, m := range got {
+		if m.Version != int64(i+1) {
+			t.Errorf("%s has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("%s has no down migration", m.Name)
+		}
+	}
+}
+
+func TestRunMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001_a.sql":      {Data: []byte("create table a ()")},
+		"001_a.down.sql": {Data: []byte("drop table a")},
+		"002_b.sql":      {Data: []byte("create table b ()")},
+		"002_b.down.sql": {Data: []byte("drop table b")},
+	}
+
+	db, fake := fakeDB(t)
+
+	if err := migrate.RunMigrations(db, fsys); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate.RunMigrations(db, fsys); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+
+	if want := []string{"create table a ()", "create table b ()"}; !reflect.DeepEqual(fake.ran, want) {
+		t.Errorf("ran %q, want each migration once: %q", fake.ran, want)
+	}
+	if want := []int64{1, 2}; !reflect.DeepEqual(fake.versions(), want) {
+		t.Errorf("schema_migrations = %v, want %v", fake.versions(), want)
+	}
+
+	status, err := migrate.CheckStatus(context.Background(), db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Current != 2 || status.Latest != 2 || len(status.Pending) != 0 {
+		t.Errorf("status = %+v, want every migration applied", status)
+	}
+
+	if err := migrate.Rollback(db, fsys, 1); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{1}; !reflect.DeepEqual(fake.versions(), want) {
+		t.Errorf("schema_migrations = %v after rollback, want %v", fake.versions(), want)
+	}
+
+	fake.ran = nil
+	if err := migrate.RunMigrations(db, fsys); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"create table b ()"}; !reflect.DeepEqual(fake.ran, want) {
+		t.Errorf("ran %q after rollback, want %q", fake.ran, want)
+	}
+}
+
+func TestRunMigrationsFailure(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001_a.sql": {Data: []byte("create table a ()")},
+		"002_b.sql": {Data: []byte("fail")},
+		"003_c.sql": {Data: []byte("create table c ()")},
+	}
+
+	db, fake := fakeDB(t)
+
+	err := migrate.RunMigrations(db, fsys)
+	if err == nil || !strings.Contains(err.Error(), "002_b.sql failed") {
+		t.Fatalf("err = %v, want 002_b.sql to fail", err)
+	}
+
+	if want := []int64{1}; !reflect.DeepEqual(fake.versions(), want) {
+		t.Errorf("schema_migrations = %v, want %v without the failed migration", fake.versions(), want)
+	}
+	if want := []string{"create table a ()"}; !reflect.DeepEqual(fake.ran, want) {
+		t.Errorf("ran %q, want %q", fake.ran, want)
+	}
+}
+
+func TestRollbackWithoutDown(t *testing.T) {
+	fsys := fstest.MapFS{"001_a.sql": {Data: []byte("create table a ()")}}
+
+	db, fake := fakeDB(t)
+	if err := migrate.RunMigrations(db, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	err := migrate.Rollback(db, fsys, 1)
+	if err == nil || !strings.Contains(err.Error(), "no down migration") {
+		t.Fatalf("err = %v, want a missing down migration", err)
+	}
+	if want := []int64{1}; !reflect.DeepEqual(fake.versions(), want) {
+		t.Errorf("schema_migrations = %v, want %v", fake.versions(), want)
+	}
+}
+
+// TestRunMigrationsPostgres applies the embedded migrations twice to the
+// Postgres database of TEST_DATABASE_URL, a lib/pq connection string, and
+// reverts them all. It is skipped when the variable is not set.
+func TestRunMigrationsPostgres(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := migrate.RunMigrations(db, migrations.FS); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+
+	status, err := migrate.CheckStatus(context.Background(), db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Pending) != 0 || status.Current != status.Latest {
+		t.Fatalf("status = %+v, want every migration applied", status)
+	}
+
+	var patients int
+	if err := db.QueryRow("select count(*) from patients").Scan(&patients); err != nil {
+		t.Fatalf("patients table: %v", err)
+	}
+
+	if err := migrate.Rollback(db, migrations.FS, int(status.Latest)); err != nil {
+		t.Fatal(err)
+	}
+
+	var applied int
+	if err := db.QueryRow("select count(*) from schema_migrations").Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != 0 {
+		t.Errorf("%d migrations left after reverting them all", applied)
+	}
+}
+
+// fake is a database holding schema_migrations only, understanding the
+// statements of the migrate package. Migrations run are recorded in ran on
+// commit, and a migration "fail" fails.
+type fake struct {
+	mu      sync.Mutex
+	applied map[int64]bool
+	ran     []string
+}
+
+var (
+	fakes     sync.Map
+	fakeCount int
+)
+
+func init() {
+	sql.Register("migrate-fake", fakeDriver{})
+}
+
+func fakeDB(t *testing.T) (*sql.DB, *fake) {
+	t.Helper()
+
+	fakeCount++
+	name := fmt.Sprintf("fake-%d", fakeCount)
+	f := &fake{applied: map[int64]bool{}}
+	fakes.Store(name, f)
+
+	db, err := sql.Open("migrate-fake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakes.Delete(name)
+	})
+
+	return db, f
+}
+
+func (f *fake) versions() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var versions []int64
+	for v := range f.applied {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	return versions
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	f, ok := fakes.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("no fake database %q", name)
+	}
+
+	return &fakeConn{db: f.(*fake)}, nil
+}
+
+type fakeConn struct {
+	db *fake
+	tx *fakeTx
+}
+
+// fakeTx holds the changes of a transaction until it commits.
+type fakeTx struct {
+	conn    *fakeConn
+	added   []int64
+	removed []int64
+	ran     []string
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("the fake driver does not prepare statements")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.tx = &fakeTx{conn: c}
+	return c.tx, nil
+}
+
+func (tx *fakeTx) Commit() error {
+	db := tx.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, v := range tx.added {
+		db.applied[v] = true
+	}
+	for _, v := range tx.removed {
+		delete(db.applied, v)
+	}
+	db.ran = append(db.ran, tx.ran...)
+	tx.conn.tx = nil
+
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migrations"),
+		strings.HasPrefix(query, "LOCK TABLE schema_migrations"):
+		return driver.RowsAffected(0), nil
+	case c.tx == nil:
+		return nil, fmt.Errorf("%q outside a transaction", query)
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		c.tx.added = append(c.tx.added, args[0].Value.(int64))
+	case strings.HasPrefix(query, "DELETE FROM schema_migrations"):
+		c.tx.removed = append(c.tx.removed, args[0].Value.(int64))
+	case query == "fail":
+		return nil, fmt.Errorf("syntax error")
+	default:
+		c.tx.ran = append(c.tx.ran, query)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	versions := c.db.versions()
+
+	switch {
+	case strings.HasPrefix(query, "SELECT EXISTS"):
+		return &fakeRows{column: "exists", values: []driver.Value{c.db.applied[args[0].Value.(int64)]}}, nil
+	case strings.HasPrefix(query, "SELECT max(version)"):
+		var latest driver.Value
+		if len(versions) > 0 {
+			latest = versions[len(versions)-1]
+		}
+		return &fakeRows{column: "max", values: []driver.Value{latest}}, nil
+	case strings.HasPrefix(query, "SELECT version FROM schema_migrations ORDER BY version DESC LIMIT"):
+		var values []driver.Value
+		for i := len(versions) - 1; i >= 0 && len(values) < int(args[0].Value.(int64)); i-- {
+			values = append(values, versions[i])
+		}
+		return &fakeRows{column: "version", values: values}, nil
+	case strings.HasPrefix(query, "SELECT version FROM schema_migrations"):
+		var values []driver.Value
+		for _, v := range versions {
+			values = append(values, v)
+		}
+		return &fakeRows{column: "version", values: values}, nil
+	}
+
+	return nil, fmt.Errorf("the fake driver does not understand %q", query)
+}
+
+// fakeRows are rows of a single column.
+type fakeRows struct {
+	column string
+	values []driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{r.column} }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	dest[0] = r.values[0]
+	r.values = r.values[1:]
+	return nil
+}
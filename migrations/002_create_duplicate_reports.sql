@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS duplicate_reports (
+  id SERIAL PRIMARY KEY,
+  reported_patient_id INTEGER NOT NULL REFERENCES patients(id),
+  suspected_duplicate_id INTEGER NOT NULL REFERENCES patients(id),
+  reported_by TEXT,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'reviewed', 'dismissed'))
+);
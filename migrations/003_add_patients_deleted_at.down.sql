@@ -0,0 +1 @@
+ALTER TABLE patients DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1 @@
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
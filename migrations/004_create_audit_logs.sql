@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS audit_logs (
+  id SERIAL PRIMARY KEY,
+  operation TEXT NOT NULL,
+  patient_id INTEGER NOT NULL,
+  performed_by TEXT NOT NULL,
+  occurred_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  old_value JSONB,
+  new_value JSONB
+);
+
+CREATE INDEX IF NOT EXISTS audit_logs_patient_id_idx ON audit_logs (patient_id, occurred_at);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS appointments (
+  id SERIAL PRIMARY KEY,
+  patient_id INTEGER NOT NULL REFERENCES patients(id),
+  scheduled_at TIMESTAMPTZ NOT NULL,
+  reason TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'scheduled' CHECK (status IN ('scheduled', 'completed', 'cancelled', 'no_show'))
+);
+
+CREATE INDEX IF NOT EXISTS appointments_patient_id_idx ON appointments (patient_id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS appointments_scheduled_at_idx;
+DROP INDEX IF EXISTS appointments_provider_id_scheduled_at_idx;
+
+ALTER TABLE appointments DROP COLUMN IF EXISTS notes;
+ALTER TABLE appointments DROP COLUMN IF EXISTS ends_at;
+ALTER TABLE appointments DROP COLUMN IF EXISTS provider_id;
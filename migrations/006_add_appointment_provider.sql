@@ -0,0 +1,9 @@
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS provider_id INTEGER;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS ends_at TIMESTAMPTZ;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT '';
+
+UPDATE appointments SET ends_at = scheduled_at + INTERVAL '30 minutes' WHERE ends_at IS NULL;
+ALTER TABLE appointments ALTER COLUMN ends_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS appointments_provider_id_scheduled_at_idx ON appointments (provider_id, scheduled_at);
+CREATE INDEX IF NOT EXISTS appointments_scheduled_at_idx ON appointments (scheduled_at);
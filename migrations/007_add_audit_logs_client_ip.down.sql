@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS audit_logs_performed_by_idx;
+DROP INDEX IF EXISTS audit_logs_occurred_at_idx;
+
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS client_ip;
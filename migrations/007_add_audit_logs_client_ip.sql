@@ -0,0 +1,4 @@
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS client_ip TEXT;
+
+CREATE INDEX IF NOT EXISTS audit_logs_occurred_at_idx ON audit_logs (occurred_at);
+CREATE INDEX IF NOT EXISTS audit_logs_performed_by_idx ON audit_logs (performed_by, occurred_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS care_team_members;
+DROP TABLE IF EXISTS providers;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS providers (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  specialty TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS care_team_members (
+  patient_id INTEGER NOT NULL REFERENCES patients(id),
+  provider_id INTEGER NOT NULL REFERENCES providers(id),
+  assigned_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (patient_id, provider_id)
+);
+
+CREATE INDEX IF NOT EXISTS care_team_members_provider_id_idx ON care_team_members (provider_id);
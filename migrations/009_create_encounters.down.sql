@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS encounter_revisions;
+DROP FUNCTION IF EXISTS forbid_encounter_revision_update();
+DROP TABLE IF EXISTS encounters;
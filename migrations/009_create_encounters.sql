@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS encounters (
+  id SERIAL PRIMARY KEY,
+  patient_id INTEGER NOT NULL REFERENCES patients(id),
+  provider_id INTEGER REFERENCES providers(id),
+  encountered_at TIMESTAMPTZ NOT NULL,
+  created_by TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS encounters_patient_id_encountered_at_idx ON encounters (patient_id, encountered_at DESC);
+
+-- Every change to an encounter is a new revision; the latest one is current.
+-- Revision 1 is the encounter as recorded, later ones are amendments with a
+-- reason.
+CREATE TABLE IF NOT EXISTS encounter_revisions (
+  encounter_id INTEGER NOT NULL REFERENCES encounters(id),
+  revision INTEGER NOT NULL,
+  chief_complaint TEXT NOT NULL,
+  notes TEXT NOT NULL DEFAULT '',
+  diagnosis_codes TEXT[] NOT NULL DEFAULT '{}',
+  reason TEXT,
+  recorded_by TEXT NOT NULL,
+  recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (encounter_id, revision),
+  CHECK (revision = 1 OR reason IS NOT NULL)
+);
+
+CREATE OR REPLACE FUNCTION forbid_encounter_revision_update() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'encounter revisions cannot be changed, record an amendment instead';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS encounter_revisions_immutable ON encounter_revisions;
+CREATE TRIGGER encounter_revisions_immutable BEFORE UPDATE ON encounter_revisions
+  FOR EACH ROW EXECUTE PROCEDURE forbid_encounter_revision_update();
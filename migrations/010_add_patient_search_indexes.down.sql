@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS patients_phone_digits_trgm_idx;
+DROP INDEX IF EXISTS patients_email_trgm_idx;
+DROP INDEX IF EXISTS patients_name_trgm_idx;
@@ -0,0 +1,5 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS patients_name_trgm_idx ON patients USING gin (lower(name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS patients_email_trgm_idx ON patients USING gin (lower(email) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS patients_phone_digits_trgm_idx ON patients USING gin (regexp_replace(phone, '[^0-9]', '', 'g') gin_trgm_ops);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS patients_phone_last4_index_idx;
+DROP INDEX IF EXISTS patients_phone_index_idx;
+DROP INDEX IF EXISTS patients_email_index_idx;
+
+ALTER TABLE patients DROP COLUMN IF EXISTS phone_last4_index;
+ALTER TABLE patients DROP COLUMN IF EXISTS phone_index;
+ALTER TABLE patients DROP COLUMN IF EXISTS email_index;
@@ -0,0 +1,7 @@
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS email_index TEXT;
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS phone_index TEXT;
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS phone_last4_index TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS patients_email_index_idx ON patients (email_index);
+CREATE INDEX IF NOT EXISTS patients_phone_index_idx ON patients (phone_index);
+CREATE INDEX IF NOT EXISTS patients_phone_last4_index_idx ON patients (phone_last4_index);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS emergency_contacts (
+  id SERIAL PRIMARY KEY,
+  patient_id INTEGER NOT NULL REFERENCES patients(id),
+  name TEXT NOT NULL,
+  relationship TEXT NOT NULL,
+  phone TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS emergency_contacts_patient_id_idx ON emergency_contacts (patient_id);
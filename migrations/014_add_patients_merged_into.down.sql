@@ -0,0 +1 @@
+ALTER TABLE patients DROP COLUMN IF EXISTS merged_into_id;
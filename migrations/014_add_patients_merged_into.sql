@@ -0,0 +1,3 @@
+-- merged_into_id points an archived duplicate at the patient it was merged
+-- into. Purging that patient clears it.
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS merged_into_id INTEGER REFERENCES patients(id) ON DELETE SET NULL;
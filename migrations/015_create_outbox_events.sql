@@ -0,0 +1,16 @@
+-- outbox_events holds the patient events waiting to be published, written in
+-- the transaction of the change they describe. Delivered events are deleted;
+-- failed_at is set once an event ran out of attempts.
+CREATE TABLE IF NOT EXISTS outbox_events (
+  id BIGSERIAL PRIMARY KEY,
+  event_type TEXT NOT NULL,
+  patient_id INTEGER NOT NULL,
+  payload JSONB NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_error TEXT,
+  failed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_pending_idx ON outbox_events (next_attempt_at) WHERE failed_at IS NULL;
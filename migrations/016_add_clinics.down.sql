@@ -0,0 +1,39 @@
+-- Restoring the deployment-wide unique emails fails when two clinics share
+-- one; resolve those patients first.
+DROP INDEX IF EXISTS audit_logs_clinic_id_occurred_at_idx;
+DROP INDEX IF EXISTS duplicate_reports_clinic_id_idx;
+DROP INDEX IF EXISTS appointments_clinic_id_scheduled_at_idx;
+DROP INDEX IF EXISTS providers_clinic_id_idx;
+
+ALTER TABLE duplicate_reports DROP CONSTRAINT IF EXISTS duplicate_reports_suspected_clinic_fkey;
+ALTER TABLE duplicate_reports DROP CONSTRAINT IF EXISTS duplicate_reports_reported_clinic_fkey;
+ALTER TABLE care_team_members DROP CONSTRAINT IF EXISTS care_team_members_provider_clinic_fkey;
+ALTER TABLE care_team_members DROP CONSTRAINT IF EXISTS care_team_members_patient_clinic_fkey;
+ALTER TABLE emergency_contacts DROP CONSTRAINT IF EXISTS emergency_contacts_patient_clinic_fkey;
+ALTER TABLE encounter_revisions DROP CONSTRAINT IF EXISTS encounter_revisions_encounter_clinic_fkey;
+ALTER TABLE encounters DROP CONSTRAINT IF EXISTS encounters_provider_clinic_fkey;
+ALTER TABLE encounters DROP CONSTRAINT IF EXISTS encounters_patient_clinic_fkey;
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_provider_clinic_fkey;
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_patient_clinic_fkey;
+
+DROP INDEX IF EXISTS encounters_id_clinic_id_idx;
+DROP INDEX IF EXISTS providers_id_clinic_id_idx;
+DROP INDEX IF EXISTS patients_id_clinic_id_idx;
+
+DROP INDEX IF EXISTS patients_clinic_id_email_index_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS patients_email_index_idx ON patients (email_index);
+DROP INDEX IF EXISTS patients_clinic_id_email_idx;
+ALTER TABLE patients ADD CONSTRAINT patients_email_key UNIQUE (email);
+
+ALTER TABLE outbox_events DROP COLUMN IF EXISTS clinic_id;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS clinic_id;
+ALTER TABLE duplicate_reports DROP COLUMN IF EXISTS clinic_id;
+ALTER TABLE care_team_members DROP COLUMN IF EXISTS clinic_id;
+ALTER TABLE emergency_contacts DROP COLUMN IF EXISTS clinic_id;
+ALTER TABLE encounter_revisions DROP COLUMN IF EXISTS clinic_id;
+ALTER TABLE encounters DROP COLUMN IF EXISTS clinic_id;
+ALTER TABLE appointments DROP COLUMN IF EXISTS clinic_id;
+ALTER TABLE providers DROP COLUMN IF EXISTS clinic_id;
+ALTER TABLE patients DROP COLUMN IF EXISTS clinic_id;
+
+DROP TABLE IF EXISTS clinics;
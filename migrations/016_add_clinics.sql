@@ -0,0 +1,70 @@
+-- clinics are the tenants sharing the deployment. Every row belongs to one,
+-- and the rows existing before are backfilled into clinic 1, created here.
+CREATE TABLE IF NOT EXISTS clinics (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO clinics (id, name) VALUES (1, 'Default clinic') ON CONFLICT (id) DO NOTHING;
+SELECT setval(pg_get_serial_sequence('clinics', 'id'), (SELECT max(id) FROM clinics));
+
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS clinic_id INTEGER NOT NULL DEFAULT 1 REFERENCES clinics(id);
+ALTER TABLE providers ADD COLUMN IF NOT EXISTS clinic_id INTEGER NOT NULL DEFAULT 1 REFERENCES clinics(id);
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS clinic_id INTEGER NOT NULL DEFAULT 1 REFERENCES clinics(id);
+ALTER TABLE encounters ADD COLUMN IF NOT EXISTS clinic_id INTEGER NOT NULL DEFAULT 1 REFERENCES clinics(id);
+ALTER TABLE encounter_revisions ADD COLUMN IF NOT EXISTS clinic_id INTEGER NOT NULL DEFAULT 1 REFERENCES clinics(id);
+ALTER TABLE emergency_contacts ADD COLUMN IF NOT EXISTS clinic_id INTEGER NOT NULL DEFAULT 1 REFERENCES clinics(id);
+ALTER TABLE care_team_members ADD COLUMN IF NOT EXISTS clinic_id INTEGER NOT NULL DEFAULT 1 REFERENCES clinics(id);
+ALTER TABLE duplicate_reports ADD COLUMN IF NOT EXISTS clinic_id INTEGER NOT NULL DEFAULT 1 REFERENCES clinics(id);
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS clinic_id INTEGER NOT NULL DEFAULT 1 REFERENCES clinics(id);
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS clinic_id INTEGER NOT NULL DEFAULT 1 REFERENCES clinics(id);
+
+-- New rows must name their clinic.
+ALTER TABLE patients ALTER COLUMN clinic_id DROP DEFAULT;
+ALTER TABLE providers ALTER COLUMN clinic_id DROP DEFAULT;
+ALTER TABLE appointments ALTER COLUMN clinic_id DROP DEFAULT;
+ALTER TABLE encounters ALTER COLUMN clinic_id DROP DEFAULT;
+ALTER TABLE encounter_revisions ALTER COLUMN clinic_id DROP DEFAULT;
+ALTER TABLE emergency_contacts ALTER COLUMN clinic_id DROP DEFAULT;
+ALTER TABLE care_team_members ALTER COLUMN clinic_id DROP DEFAULT;
+ALTER TABLE duplicate_reports ALTER COLUMN clinic_id DROP DEFAULT;
+ALTER TABLE audit_logs ALTER COLUMN clinic_id DROP DEFAULT;
+ALTER TABLE outbox_events ALTER COLUMN clinic_id DROP DEFAULT;
+
+-- Emails are unique within a clinic rather than across the deployment.
+ALTER TABLE patients DROP CONSTRAINT IF EXISTS patients_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS patients_clinic_id_email_idx ON patients (clinic_id, email);
+DROP INDEX IF EXISTS patients_email_index_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS patients_clinic_id_email_index_idx ON patients (clinic_id, email_index);
+
+-- Records referring to a patient or provider must be in its clinic.
+CREATE UNIQUE INDEX IF NOT EXISTS patients_id_clinic_id_idx ON patients (id, clinic_id);
+CREATE UNIQUE INDEX IF NOT EXISTS providers_id_clinic_id_idx ON providers (id, clinic_id);
+CREATE UNIQUE INDEX IF NOT EXISTS encounters_id_clinic_id_idx ON encounters (id, clinic_id);
+
+ALTER TABLE appointments ADD CONSTRAINT appointments_patient_clinic_fkey
+  FOREIGN KEY (patient_id, clinic_id) REFERENCES patients (id, clinic_id);
+ALTER TABLE appointments ADD CONSTRAINT appointments_provider_clinic_fkey
+  FOREIGN KEY (provider_id, clinic_id) REFERENCES providers (id, clinic_id);
+ALTER TABLE encounters ADD CONSTRAINT encounters_patient_clinic_fkey
+  FOREIGN KEY (patient_id, clinic_id) REFERENCES patients (id, clinic_id);
+ALTER TABLE encounters ADD CONSTRAINT encounters_provider_clinic_fkey
+  FOREIGN KEY (provider_id, clinic_id) REFERENCES providers (id, clinic_id);
+ALTER TABLE encounter_revisions ADD CONSTRAINT encounter_revisions_encounter_clinic_fkey
+  FOREIGN KEY (encounter_id, clinic_id) REFERENCES encounters (id, clinic_id);
+ALTER TABLE emergency_contacts ADD CONSTRAINT emergency_contacts_patient_clinic_fkey
+  FOREIGN KEY (patient_id, clinic_id) REFERENCES patients (id, clinic_id);
+ALTER TABLE care_team_members ADD CONSTRAINT care_team_members_patient_clinic_fkey
+  FOREIGN KEY (patient_id, clinic_id) REFERENCES patients (id, clinic_id);
+ALTER TABLE care_team_members ADD CONSTRAINT care_team_members_provider_clinic_fkey
+  FOREIGN KEY (provider_id, clinic_id) REFERENCES providers (id, clinic_id);
+ALTER TABLE duplicate_reports ADD CONSTRAINT duplicate_reports_reported_clinic_fkey
+  FOREIGN KEY (reported_patient_id, clinic_id) REFERENCES patients (id, clinic_id);
+ALTER TABLE duplicate_reports ADD CONSTRAINT duplicate_reports_suspected_clinic_fkey
+  FOREIGN KEY (suspected_duplicate_id, clinic_id) REFERENCES patients (id, clinic_id);
+
+CREATE INDEX IF NOT EXISTS providers_clinic_id_idx ON providers (clinic_id);
+CREATE INDEX IF NOT EXISTS appointments_clinic_id_scheduled_at_idx ON appointments (clinic_id, scheduled_at);
+CREATE INDEX IF NOT EXISTS duplicate_reports_clinic_id_idx ON duplicate_reports (clinic_id);
+CREATE INDEX IF NOT EXISTS audit_logs_clinic_id_occurred_at_idx ON audit_logs (clinic_id, occurred_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS patient_erasures;
+ALTER TABLE patients DROP COLUMN IF EXISTS erased_at;
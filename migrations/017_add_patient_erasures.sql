@@ -0,0 +1,16 @@
+-- erased_at marks patients anonymized on request. Their records are kept,
+-- stripped of what identifies them, and cannot be restored.
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS erased_at TIMESTAMPTZ;
+
+-- patient_erasures records who erased a patient and why. It outlives purges
+-- of the patient, so it has no foreign key to patients.
+CREATE TABLE IF NOT EXISTS patient_erasures (
+  id SERIAL PRIMARY KEY,
+  patient_id INTEGER NOT NULL,
+  clinic_id INTEGER NOT NULL REFERENCES clinics(id),
+  performed_by TEXT NOT NULL,
+  justification TEXT NOT NULL,
+  erased_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS patient_erasures_patient_id_idx ON patient_erasures (patient_id);
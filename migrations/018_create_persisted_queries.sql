@@ -0,0 +1,10 @@
+-- persisted_queries holds GraphQL queries by the hex SHA-256 of their text.
+-- Clients register queries automatically unless the server only runs the
+-- approved ones, loaded from a manifest with -approve-queries.
+CREATE TABLE IF NOT EXISTS persisted_queries (
+  hash TEXT PRIMARY KEY,
+  query TEXT NOT NULL,
+  approved BOOLEAN NOT NULL DEFAULT false,
+  operation_name TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
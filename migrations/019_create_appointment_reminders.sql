@@ -0,0 +1,22 @@
+-- appointment_reminders holds a reminder per channel of each appointment
+-- about to start, written by the reminder scheduler before it is sent.
+-- status is pending until the reminder is sent, given up on after its
+-- attempts (failed), or skipped because the appointment moved, was cancelled
+-- or the patient has no address on the channel. A rescheduled appointment
+-- gets new reminders for its new time.
+CREATE TABLE IF NOT EXISTS appointment_reminders (
+  id BIGSERIAL PRIMARY KEY,
+  appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+  clinic_id INTEGER NOT NULL REFERENCES clinics(id),
+  channel TEXT NOT NULL CHECK (channel IN ('email', 'sms')),
+  scheduled_for TIMESTAMPTZ NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed', 'skipped')),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_error TEXT,
+  sent_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (appointment_id, channel, scheduled_for)
+);
+
+CREATE INDEX IF NOT EXISTS appointment_reminders_pending_idx ON appointment_reminders (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,26 @@
+-- documents holds the metadata of the files attached to patients, whose
+-- content is kept by the document storage under storage_key.
+CREATE TABLE IF NOT EXISTS documents (
+  id SERIAL PRIMARY KEY,
+  patient_id INTEGER NOT NULL REFERENCES patients(id),
+  clinic_id INTEGER NOT NULL REFERENCES clinics(id),
+  kind TEXT NOT NULL CHECK (kind IN ('insurance_card', 'referral_letter', 'lab_result', 'other')),
+  filename TEXT NOT NULL,
+  content_type TEXT NOT NULL,
+  size_bytes BIGINT NOT NULL,
+  sha256 TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  storage_key TEXT NOT NULL UNIQUE,
+  uploaded_by TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS documents_patient_id_idx ON documents (patient_id);
+
+-- document_deletions holds the storage keys of deleted documents, written in
+-- the transaction removing them, until their files are deleted from the
+-- storage.
+CREATE TABLE IF NOT EXISTS document_deletions (
+  storage_key TEXT PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
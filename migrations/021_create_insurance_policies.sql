@@ -0,0 +1,21 @@
+-- insurance_policies holds the coverage of patients. A policy is in effect
+-- from effective_date through expiry_date, or indefinitely when it has none,
+-- until it is deactivated. Only one active primary policy of a patient may
+-- be in effect on any day, which the store checks.
+CREATE TABLE IF NOT EXISTS insurance_policies (
+  id SERIAL PRIMARY KEY,
+  patient_id INTEGER NOT NULL REFERENCES patients(id),
+  clinic_id INTEGER NOT NULL REFERENCES clinics(id),
+  payer TEXT NOT NULL,
+  member_id TEXT NOT NULL,
+  group_number TEXT NOT NULL DEFAULT '',
+  effective_date DATE NOT NULL,
+  expiry_date DATE,
+  priority TEXT NOT NULL CHECK (priority IN ('primary', 'secondary', 'tertiary')),
+  deactivated_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CHECK (expiry_date IS NULL OR expiry_date >= effective_date)
+);
+
+CREATE INDEX IF NOT EXISTS insurance_policies_patient_id_idx ON insurance_policies (patient_id);
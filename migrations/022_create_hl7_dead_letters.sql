@@ -0,0 +1,14 @@
+-- hl7_dead_letters holds the HL7 v2 messages that could not be ingested,
+-- with the reason they were rejected, for an operator to fix and resend.
+-- The messages are kept as received and so hold PHI in plaintext.
+CREATE TABLE IF NOT EXISTS hl7_dead_letters (
+  id BIGSERIAL PRIMARY KEY,
+  clinic_id INTEGER NOT NULL REFERENCES clinics(id),
+  control_id TEXT NOT NULL DEFAULT '',
+  message TEXT NOT NULL,
+  error TEXT NOT NULL,
+  received_by TEXT NOT NULL,
+  received_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS hl7_dead_letters_received_at_idx ON hl7_dead_letters (received_at);
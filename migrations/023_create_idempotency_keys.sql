@@ -0,0 +1,23 @@
+-- idempotency_keys holds the keys clients sent with create mutations, with
+-- their outcome, so a retried request is answered with the records the
+-- first one created instead of creating them again. The response holds the
+-- ids of those records rather than the records, so no PHI is kept here.
+-- A row without a response belongs to a request still running; it is only
+-- ever seen committed with its response, as both are written in the
+-- transaction of the mutation.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  id BIGSERIAL PRIMARY KEY,
+  clinic_id INTEGER NOT NULL REFERENCES clinics(id),
+  user_id TEXT NOT NULL,
+  key TEXT NOT NULL,
+  operation TEXT NOT NULL,
+  -- request_hash is the SHA-256 of the arguments of the mutation, so a key
+  -- sent again with other arguments is refused.
+  request_hash TEXT NOT NULL,
+  response JSONB,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  UNIQUE (clinic_id, user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
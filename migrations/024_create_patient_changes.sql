@@ -0,0 +1,16 @@
+-- patient_changes is the change feed of the patients, one row for every
+-- change written in the transaction of the change, and never updated or
+-- deleted. tx_id is the transaction that wrote the row: the feed is read in
+-- (tx_id, id) order, and only up to the oldest transaction still running,
+-- so a change committing late is never read after the changes that follow
+-- it. Rows hold no PHI, only which patient changed and how.
+CREATE TABLE IF NOT EXISTS patient_changes (
+  id BIGSERIAL PRIMARY KEY,
+  clinic_id INTEGER NOT NULL REFERENCES clinics(id),
+  patient_id INTEGER NOT NULL,
+  change_type TEXT NOT NULL,
+  tx_id BIGINT NOT NULL DEFAULT txid_current(),
+  changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS patient_changes_feed_idx ON patient_changes (clinic_id, tx_id, id);
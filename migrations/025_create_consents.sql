@@ -0,0 +1,20 @@
+-- consents records the consents of patients to treatment, to sharing their
+-- data and to marketing, each covering a scope: all, or the integration it
+-- was given for. A consent is in effect from granted_at until it is
+-- revoked; revoked consents are kept as the record of what was consented
+-- to when. A patient has at most one consent in effect per type and scope.
+CREATE TABLE IF NOT EXISTS consents (
+  id SERIAL PRIMARY KEY,
+  patient_id INTEGER NOT NULL REFERENCES patients(id),
+  clinic_id INTEGER NOT NULL REFERENCES clinics(id),
+  consent_type TEXT NOT NULL CHECK (consent_type IN ('treatment', 'data_sharing', 'marketing')),
+  scope TEXT NOT NULL,
+  granted_at TIMESTAMPTZ NOT NULL,
+  recorded_by TEXT NOT NULL,
+  revoked_at TIMESTAMPTZ,
+  revoked_by TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS consents_patient_id_idx ON consents (patient_id);
+CREATE UNIQUE INDEX IF NOT EXISTS consents_in_effect_idx ON consents (patient_id, consent_type, scope) WHERE revoked_at IS NULL;
@@ -0,0 +1,9 @@
+ALTER TABLE patients
+  DROP COLUMN IF EXISTS name_prefix,
+  DROP COLUMN IF EXISTS given_names,
+  DROP COLUMN IF EXISTS family_name,
+  DROP COLUMN IF EXISTS name_suffix,
+  DROP COLUMN IF EXISTS birth_date,
+  DROP COLUMN IF EXISTS gender,
+  DROP COLUMN IF EXISTS preferred_language,
+  DROP COLUMN IF EXISTS demographics;
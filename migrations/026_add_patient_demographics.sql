@@ -0,0 +1,16 @@
+-- The demographics of patients beyond name, email and phone, which stay the
+-- display name and the primary contact points. The structured name is kept
+-- alongside name, which may be written in another order than its parts.
+-- demographics holds the addresses, other contact points and identifiers of
+-- the patient as JSON, encrypted like email and phone, and is empty when
+-- none were recorded.
+ALTER TABLE patients
+  ADD COLUMN IF NOT EXISTS name_prefix TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS given_names TEXT[] NOT NULL DEFAULT '{}',
+  ADD COLUMN IF NOT EXISTS family_name TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS name_suffix TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS birth_date DATE,
+  ADD COLUMN IF NOT EXISTS gender TEXT NOT NULL DEFAULT ''
+    CHECK (gender IN ('', 'male', 'female', 'other', 'unknown')),
+  ADD COLUMN IF NOT EXISTS preferred_language TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS demographics TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,12 @@
+-- patient_notes holds free-text clinical notes about patients, such as the
+-- summaries imported from legacy systems by batchCreateNotes.
+CREATE TABLE IF NOT EXISTS patient_notes (
+  id SERIAL PRIMARY KEY,
+  patient_id INTEGER NOT NULL REFERENCES patients(id),
+  clinic_id INTEGER NOT NULL REFERENCES clinics(id),
+  body TEXT NOT NULL,
+  created_by TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS patient_notes_patient_id_idx ON patient_notes (patient_id);
@@ -0,0 +1,11 @@
+// Package migrations embeds the numbered SQL files that make up the database
+// schema, so the server binary can apply them without the source tree.
+package migrations
+
+import "embed"
+
+// FS holds every migration file, named <version>_<description>.sql, and the
+// down migrations reverting them, named <version>_<description>.down.sql.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,204 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	// batchSize is how many events one poll delivers at most.
+	batchSize = 100
+	// maxBackoff caps the wait before retrying an event.
+	maxBackoff = time.Hour
+)
+
+// Publisher delivers an event, returning an error when it must be retried.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Publishers delivers every event to each of its publishers, failing when
+// any of them fails, in which case all of them get the event again.
+type Publishers []Publisher
+
+func (p Publishers) Publish(ctx context.Context, event Event) error {
+	var errs []error
+	for _, publisher := range p {
+		if err := publisher.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Dispatcher polls the outbox for events due and hands them to a Publisher.
+// An event that fails is retried after a backoff doubling from one second up
+// to an hour, and left in the table with failed_at set after maxAttempts
+// attempts. Events are locked while they are delivered, so several instances
+// can run a Dispatcher on the same table.
+type Dispatcher struct {
+	outbox      *Outbox
+	publisher   Publisher
+	interval    time.Duration
+	timeout     time.Duration
+	maxAttempts int
+}
+
+// NewDispatcher returns a Dispatcher delivering the events of outbox with
+// publisher every interval, giving each delivery up to timeout.
+func NewDispatcher(outbox *Outbox, publisher Publisher, interval, timeout time.Duration, maxAttempts int) *Dispatcher {
+	return &Dispatcher{outbox: outbox, publisher: publisher, interval: interval, timeout: timeout, maxAttempts: maxAttempts}
+}
+
+// Run delivers the events due until ctx is cancelled. A full batch is
+// followed by the next one straight away.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		handled, err := d.deliver(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "delivering outbox events", "error", err)
+		}
+
+		if handled == batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver publishes a batch of events due, in the order they were written,
+// and returns how many it delivered, dropped or rescheduled. Once an event
+// of a patient fails, the later events of that patient wait until it is
+// delivered or given up on, so they are not delivered ahead of it. Events
+// that are not Shareable are dropped unpublished.
+func (d *Dispatcher) deliver(ctx context.Context) (int, error) {
+	tx, err := d.outbox.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	events, err := d.due(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	handled := 0
+	failed := map[int]bool{}
+	for _, event := range events {
+		if failed[event.PatientID] {
+			continue
+		}
+		handled++
+
+		if !event.Shareable() {
+			slog.InfoContext(ctx, "dropping outbox event without data sharing consent", "event_id", event.ID,
+				"event_type", event.Type, "patient_id", event.PatientID)
+		} else if err := d.publish(ctx, event); err != nil {
+			failed[event.PatientID] = true
+
+			if err := d.retry(ctx, tx, event, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, "delete from outbox_events where id = $1", event.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	return handled, tx.Commit()
+}
+
+func (d *Dispatcher) publish(ctx context.Context, event Event) error {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	return d.publisher.Publish(ctx, event)
+}
+
+// retry records that event failed with cause, scheduling its next attempt
+// or giving up on it.
+func (d *Dispatcher) retry(ctx context.Context, tx *sql.Tx, event Event, cause error) error {
+	attempts := event.Attempts + 1
+
+	if attempts >= d.maxAttempts {
+		slog.ErrorContext(ctx, "giving up on outbox event", "event_id", event.ID, "event_type", event.Type,
+			"patient_id", event.PatientID, "attempts", attempts, "error", cause)
+
+		_, err := tx.ExecContext(ctx,
+			"update outbox_events set attempts = $1, last_error = $2, failed_at = now() where id = $3",
+			attempts, cause.Error(), event.ID)
+		return err
+	}
+
+	wait := backoff(attempts)
+	slog.WarnContext(ctx, "outbox event delivery failed", "event_id", event.ID, "event_type", event.Type,
+		"patient_id", event.PatientID, "attempts", attempts, "retry_in", wait.String(), "error", cause)
+
+	_, err := tx.ExecContext(ctx,
+		"update outbox_events set attempts = $1, last_error = $2, next_attempt_at = now() + $3 * interval '1 millisecond' where id = $4",
+		attempts, cause.Error(), wait.Milliseconds(), event.ID)
+	return err
+}
+
+// due locks the next batch of events due for delivery, skipping those
+// another Dispatcher is delivering and those behind an earlier event of the
+// same patient still waiting for delivery, and decrypts their payloads. The
+// consent of each patient is read in the same transaction, so an event is
+// never published after the consent was revoked.
+func (d *Dispatcher) due(ctx context.Context, tx *sql.Tx) ([]Event, error) {
+	rows, err := tx.QueryContext(ctx, `select id, event_type, patient_id, clinic_id, payload, created_at, attempts,
+			exists (
+				select 1 from consents c
+				where c.patient_id = e.patient_id and c.consent_type = $2 and c.revoked_at is null and c.scope in ($3, $4)
+			)
+		from outbox_events e
+		where failed_at is null and next_attempt_at <= now() and not exists (
+			select 1 from outbox_events earlier
+			where earlier.patient_id = e.patient_id and earlier.id < e.id and earlier.failed_at is null
+		)
+		order by id limit $1 for update skip locked`, batchSize, sharingConsentType, consentScopeAll, ConsentScope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.Type, &event.PatientID, &event.ClinicID, &payload, &event.OccurredAt, &event.Attempts, &event.consented); err != nil {
+			return nil, err
+		}
+
+		if event.Payload, err = d.outbox.cipher.DecryptJSON(payloadField, payload); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// backoff is the wait after the given number of failed attempts.
+func backoff(attempts int) time.Duration {
+	if attempts > 12 {
+		return maxBackoff
+	}
+
+	return min(time.Second<<(attempts-1), maxBackoff)
+}
@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS publishes events to a JetStream stream, on the subject
+// "<prefix>.<event type>", such as smart-emerge.patient.created. A publish
+// only succeeds once the stream stored the event, and the event ID is sent
+// as the Nats-Msg-Id, so a retried event the stream already has is dropped
+// as a duplicate within its deduplication window.
+type NATS struct {
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+	prefix string
+}
+
+// NewNATS connects to the NATS server at url. A stream capturing the
+// subjects under prefix must exist.
+func NewNATS(url, prefix string) (*NATS, error) {
+	conn, err := nats.Connect(url, nats.Name("smart-emerge"))
+	if err != nil {
+		return nil, fmt.Errorf("could not reach nats: %w", err)
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats jetstream: %w", err)
+	}
+
+	return &NATS{conn: conn, js: js, prefix: prefix}, nil
+}
+
+func (n *NATS) Publish(ctx context.Context, event Event) error {
+	body, err := event.Body()
+	if err != nil {
+		return err
+	}
+
+	_, err = n.js.Publish(n.prefix+"."+event.Type, body,
+		nats.MsgId(strconv.FormatInt(event.ID, 10)), nats.Context(ctx))
+	return err
+}
+
+// Close flushes and closes the connection to the server.
+func (n *NATS) Close() error {
+	return n.conn.Drain()
+}
@@ -0,0 +1,224 @@
+// Package outbox publishes patient lifecycle events to webhooks and NATS.
+// Events are written to the outbox_events table in the transaction of the
+// change they describe, and a Dispatcher delivers them afterwards, retrying
+// with backoff, so an event is neither lost when delivery fails nor sent for
+// a change that rolled back. Delivery is at least once: receivers should
+// ignore an event ID they have already seen.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/encryption"
+	"github.com/codixir/smart-emerge-starter/tenant"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// The event types.
+const (
+	PatientCreated = "patient.created"
+	PatientUpdated = "patient.updated"
+	PatientDeleted = "patient.deleted"
+	// PatientErased carries the anonymized patient, so receivers can erase
+	// their copies too.
+	PatientErased = "patient.erased"
+	// PatientBulkImportCompleted carries the counts of a CSV import of
+	// patients instead of a patient, and is written with patient id 0.
+	PatientBulkImportCompleted = "patient.bulk_import_completed"
+)
+
+// ConsentScope is the scope of the data sharing consents under which the
+// events of a patient are published. It is store.ConsentScopeEvents, which
+// store defines from it as store imports this package.
+const ConsentScope = "events"
+
+// The consent type and the scope covering every integration that, with
+// ConsentScope, make up the consents events are published under. They are
+// store.ConsentDataSharing and store.ConsentScopeAll.
+const (
+	sharingConsentType = "data_sharing"
+	consentScopeAll    = "all"
+)
+
+// Event is a row of the outbox_events table. Payload is the JSON encoded
+// patient after the change, or the import of a PatientBulkImportCompleted,
+// which is stored encrypted when encryption is enabled.
+type Event struct {
+	ID         int64
+	Type       string
+	PatientID  int
+	ClinicID   int
+	Payload    json.RawMessage
+	OccurredAt time.Time
+	// Attempts is how many deliveries failed before this one.
+	Attempts int
+	// consented is whether the patient had a data sharing consent covering
+	// ConsentScope in effect when the event was read for delivery.
+	consented bool
+}
+
+// Shareable reports whether event may be published: the patient consented to
+// sharing their data with the receivers of events, or the event holds no
+// personal data. PatientErased lets receivers erase what they got before
+// the consent was withdrawn, and PatientBulkImportCompleted only counts
+// patients.
+func (e Event) Shareable() bool {
+	return e.consented || e.Type == PatientErased || e.Type == PatientBulkImportCompleted
+}
+
+// envelope is the JSON body events are published as.
+type envelope struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	ClinicID   int             `json:"clinicId"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Patient    json.RawMessage `json:"patient,omitempty"`
+	Import     json.RawMessage `json:"import,omitempty"`
+}
+
+// Body returns the JSON document event is published as, holding its ID,
+// type, clinic, time and patient, or import for PatientBulkImportCompleted.
+func (e Event) Body() ([]byte, error) {
+	body := envelope{ID: e.ID, Type: e.Type, ClinicID: e.ClinicID, OccurredAt: e.OccurredAt, Patient: e.Payload}
+	if e.Type == PatientBulkImportCompleted {
+		body.Patient, body.Import = nil, e.Payload
+	}
+
+	return json.Marshal(body)
+}
+
+// payloadField is the associated data of the encrypted payloads.
+const payloadField = "outbox_payload"
+
+// Outbox writes events to the outbox_events table, which a Dispatcher reads
+// them back from. A nil *Outbox writes nothing, for deployments publishing
+// no events.
+type Outbox struct {
+	db     *sql.DB
+	cipher *encryption.Cipher
+}
+
+// New returns an Outbox keeping its events in db, encrypting their payloads
+// with cipher, nil to store them in plaintext.
+func New(db *sql.DB, cipher *encryption.Cipher) *Outbox {
+	return &Outbox{db: db, cipher: cipher}
+}
+
+// Write records an event of eventType about patientID inside tx, so it is
+// only published if the change it describes commits. patient, the payload
+// of the event, is encoded as JSON and encrypted, and the event belongs to
+// the clinic ctx acts for.
+func (o *Outbox) Write(ctx context.Context, tx *sql.Tx, eventType string, patientID int, patient interface{}) error {
+	if o == nil {
+		return nil
+	}
+
+	clinicID, ok := tenant.ClinicFromContext(ctx)
+	if !ok {
+		return utils.ErrNoClinic
+	}
+
+	payload, err := json.Marshal(patient)
+	if err != nil {
+		return err
+	}
+
+	if payload, err = o.cipher.EncryptJSON(payloadField, payload); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"insert into outbox_events(event_type, patient_id, payload, clinic_id) values($1, $2, $3, $4)",
+		eventType, patientID, string(payload), clinicID)
+
+	return err
+}
+
+// reencryptBatchSize is how many events Reencrypt updates per transaction.
+const reencryptBatchSize = 500
+
+// Reencrypt encrypts the payload of every event still in the outbox with
+// the current key, like store.PatientStore.Reencrypt, so older keys can be
+// removed. Payloads stored before encryption was enabled are encrypted too.
+// It returns how many events were updated.
+func (o *Outbox) Reencrypt(ctx context.Context) (int, error) {
+	updated, lastID := 0, int64(0)
+
+	for {
+		n, read, last, err := o.reencryptBatch(ctx, lastID)
+		if err != nil {
+			return updated, err
+		}
+
+		updated, lastID = updated+n, last
+		if read < reencryptBatchSize {
+			return updated, nil
+		}
+	}
+}
+
+// reencryptBatch re-encrypts the payloads of the next reencryptBatchSize
+// events after afterID in a transaction, returning how many it updated and
+// read and the last id read.
+func (o *Outbox) reencryptBatch(ctx context.Context, afterID int64) (updated, read int, lastID int64, err error) {
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, afterID, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "select id, payload from outbox_events where id > $1 order by id limit $2 for update",
+		afterID, reencryptBatchSize)
+	if err != nil {
+		return 0, 0, afterID, err
+	}
+
+	type row struct {
+		id      int64
+		payload []byte
+	}
+
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.payload); err != nil {
+			rows.Close()
+			return 0, 0, afterID, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, afterID, err
+	}
+
+	lastID = afterID
+	for _, r := range batch {
+		lastID = r.id
+
+		if o.cipher.CurrentJSON(r.payload) {
+			continue
+		}
+
+		plaintext, err := o.cipher.DecryptJSON(payloadField, r.payload)
+		if err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		sealed, err := o.cipher.EncryptJSON(payloadField, plaintext)
+		if err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		if _, err := tx.ExecContext(ctx, "update outbox_events set payload = $1 where id = $2", string(sealed), r.id); err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		updated++
+	}
+
+	return updated, len(batch), lastID, tx.Commit()
+}
@@ -0,0 +1,56 @@
+package outbox
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestShareable(t *testing.T) {
+	tests := []struct {
+		eventType string
+		consented bool
+		want      bool
+	}{
+		{PatientCreated, true, true},
+		{PatientUpdated, true, true},
+		{PatientDeleted, true, true},
+		{PatientErased, true, true},
+		{PatientCreated, false, false},
+		{PatientUpdated, false, false},
+		{PatientDeleted, false, false},
+		{PatientErased, false, true},
+		{PatientBulkImportCompleted, true, true},
+		{PatientBulkImportCompleted, false, true},
+	}
+
+	for _, tt := range tests {
+		event := Event{Type: tt.eventType, consented: tt.consented}
+		if got := event.Shareable(); got != tt.want {
+			t.Errorf("Shareable() of %s with consented %v = %v, want %v", tt.eventType, tt.consented, got, tt.want)
+		}
+	}
+}
+
+func TestBody(t *testing.T) {
+	occurredAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		eventType string
+		want      string
+	}{
+		{PatientCreated, `{"id":7,"type":"patient.created","clinicId":1,"occurredAt":"2024-03-01T10:00:00Z","patient":{"n":1}}`},
+		{PatientBulkImportCompleted, `{"id":7,"type":"patient.bulk_import_completed","clinicId":1,"occurredAt":"2024-03-01T10:00:00Z","import":{"n":1}}`},
+	}
+
+	for _, tt := range tests {
+		event := Event{ID: 7, Type: tt.eventType, ClinicID: 1, OccurredAt: occurredAt, Payload: json.RawMessage(`{"n":1}`)}
+		body, err := event.Body()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != tt.want {
+			t.Errorf("Body() of %s = %s, want %s", tt.eventType, body, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Webhook POSTs events as JSON to a set of URLs. Each request carries the
+// event ID in X-Webhook-Id, its type in X-Webhook-Event, and an
+// X-Webhook-Signature of the form t=<unix seconds>,v1=<hex HMAC-SHA256>,
+// signing "<t>.<body>" with the shared secret, so receivers can check the
+// event came from this service and reject replays outside their tolerance.
+type Webhook struct {
+	urls   []string
+	secret []byte
+	client *http.Client
+}
+
+// NewWebhook returns a Webhook delivering to urls and signing with secret.
+func NewWebhook(urls []string, secret string) *Webhook {
+	return &Webhook{urls: urls, secret: []byte(secret), client: &http.Client{}}
+}
+
+// Publish delivers event to every URL, failing unless each answers with a
+// 2xx status within the deadline of ctx.
+func (w *Webhook) Publish(ctx context.Context, event Event) error {
+	body, err := event.Body()
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := "t=" + timestamp + ",v1=" + w.sign(timestamp, body)
+
+	for _, url := range w.urls {
+		if err := w.post(ctx, url, event, body, signature); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *Webhook) post(ctx context.Context, url string, event Event, body []byte, signature string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(event.ID, 10))
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-Signature", signature)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+// sign returns the hex HMAC-SHA256 of "<timestamp>.<body>".
+func (w *Webhook) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
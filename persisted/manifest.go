@@ -0,0 +1,74 @@
+package persisted
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Manifest is an Apollo persisted query manifest, as written by
+// generate-persisted-query-manifest from the operations of a client.
+type Manifest struct {
+	Format     string      `json:"format"`
+	Version    int         `json:"version"`
+	Operations []Operation `json:"operations"`
+}
+
+// Operation is a query of a Manifest. ID is the hex SHA-256 of Body.
+type Operation struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Body string `json:"body"`
+}
+
+// ReadManifest decodes a manifest from r, checking that every operation has
+// a body and the ID of its hash.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid persisted query manifest: %w", err)
+	}
+
+	for i, op := range manifest.Operations {
+		if strings.TrimSpace(op.Body) == "" {
+			return nil, fmt.Errorf("operation %d of the manifest has no body", i)
+		}
+		if op.ID != "" && !strings.EqualFold(op.ID, Hash(op.Body)) {
+			return nil, fmt.Errorf("operation %d of the manifest (%s) has the id %s, but its body hashes to %s", i, op.Name, op.ID, Hash(op.Body))
+		}
+	}
+
+	return &manifest, nil
+}
+
+// Approve persists the operations of manifest as approved queries, so they
+// run in AllowList mode, and returns how many were not approved before.
+func Approve(ctx context.Context, db *sql.DB, manifest *Manifest) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	approved := 0
+	for _, op := range manifest.Operations {
+		result, err := tx.ExecContext(ctx, `insert into persisted_queries(hash, query, operation_name, approved) values($1, $2, nullif($3, ''), true)
+			on conflict (hash) do update set approved = true, operation_name = excluded.operation_name
+			where not persisted_queries.approved`, Hash(op.Body), op.Body, op.Name)
+		if err != nil {
+			return 0, fmt.Errorf("approving operation %s: %w", op.Name, err)
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		approved += int(n)
+	}
+
+	return approved, tx.Commit()
+}
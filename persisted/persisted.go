@@ -0,0 +1,187 @@
+// Package persisted implements Apollo style automatic persisted queries:
+// clients send the SHA-256 hash of a query instead of its text once the
+// server knows it, and the server can be limited to the queries approved
+// ahead of time. Queries are kept in the persisted_queries table.
+package persisted
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/cache"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// Mode chooses which queries the server runs.
+type Mode string
+
+const (
+	// Off runs the queries sent as text and ignores hashes.
+	Off Mode = "off"
+	// Automatic also runs known queries by hash, and registers the queries
+	// authenticated callers send with their hash.
+	Automatic Mode = "automatic"
+	// AllowList only runs approved queries, by hash or text.
+	AllowList Mode = "allowlist"
+)
+
+// Modes lists the valid modes.
+var Modes = []Mode{Off, Automatic, AllowList}
+
+// The errors of persisted query lookups, with the codes Apollo clients
+// recognize.
+var (
+	// ErrNotFound makes Apollo clients send the query again with its text.
+	ErrNotFound = &utils.CodedError{Code: "PERSISTED_QUERY_NOT_FOUND", Message: "PersistedQueryNotFound"}
+	// ErrNotSupported makes Apollo clients stop sending hashes alone.
+	ErrNotSupported = &utils.CodedError{Code: "PERSISTED_QUERY_NOT_SUPPORTED", Message: "PersistedQueryNotSupported"}
+	// ErrNotAllowed rejects queries that are not approved in AllowList mode.
+	ErrNotAllowed = &utils.CodedError{Code: "PERSISTED_QUERY_NOT_ALLOWED", Message: "only approved persisted queries may be run"}
+	// ErrHashMismatch rejects queries whose text does not have the hash
+	// sent with it.
+	ErrHashMismatch = &utils.CodedError{Code: "BAD_USER_INPUT", Message: "provided sha does not match query"}
+)
+
+// Extension is the persistedQuery request extension.
+type Extension struct {
+	Version    int    `json:"version"`
+	SHA256Hash string `json:"sha256Hash"`
+}
+
+// Hash returns the hex SHA-256 of query, which is how it is persisted.
+func Hash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheEntries is how many queries are kept in memory, so the queries in
+// use are not read from Postgres on every request, and cacheTTL how long,
+// which bounds how long a new approval takes to reach running servers.
+const (
+	cacheEntries = 1000
+	cacheTTL     = 5 * time.Minute
+)
+
+// Queries looks up and registers persisted queries. A nil *Queries is the
+// Off mode.
+type Queries struct {
+	db    *sql.DB
+	mode  Mode
+	cache cache.Cache
+}
+
+// New returns Queries in mode, keeping the queries in db.
+func New(db *sql.DB, mode Mode) *Queries {
+	return &Queries{db: db, mode: mode, cache: cache.NewMemory(cacheEntries)}
+}
+
+// entry is a cached row of persisted_queries.
+type entry struct {
+	Query    string `json:"query"`
+	Approved bool   `json:"approved"`
+}
+
+// Resolve returns the text of the query a request runs, from query or from
+// the query persisted under the hash of ext, which may be nil. It fails with
+// ErrNotFound for unknown hashes, so the client sends the text, with
+// ErrNotAllowed for queries that are not approved in AllowList mode, and
+// with ErrNotSupported for hashes alone in Off mode.
+func (q *Queries) Resolve(ctx context.Context, query string, ext *Extension) (string, error) {
+	hash := ""
+	if ext != nil {
+		hash = strings.ToLower(ext.SHA256Hash)
+	}
+
+	if q == nil || q.mode == Off {
+		if query == "" && hash != "" {
+			return "", ErrNotSupported
+		}
+
+		return query, nil
+	}
+
+	if ext != nil && ext.Version != 1 {
+		return "", &utils.CodedError{Code: "BAD_USER_INPUT", Message: fmt.Sprintf("unsupported persistedQuery version %d", ext.Version)}
+	}
+
+	if query != "" {
+		if hash != "" && hash != Hash(query) {
+			return "", ErrHashMismatch
+		}
+		if q.mode != AllowList {
+			return query, nil
+		}
+		hash = Hash(query)
+	}
+
+	if hash == "" {
+		return query, nil
+	}
+
+	stored, ok, err := q.lookup(ctx, hash)
+	switch {
+	case err != nil:
+		return "", err
+	case !ok && q.mode == AllowList:
+		return "", ErrNotAllowed
+	case !ok:
+		return "", ErrNotFound
+	case q.mode == AllowList && !stored.Approved:
+		return "", ErrNotAllowed
+	}
+
+	return stored.Query, nil
+}
+
+// Register persists query under the hash of ext in Automatic mode, once it
+// has passed the checks of the server. It does nothing in the other modes
+// or without ext.
+func (q *Queries) Register(ctx context.Context, query, operationName string, ext *Extension) error {
+	if q == nil || q.mode != Automatic || ext == nil || query == "" {
+		return nil
+	}
+
+	hash := Hash(query)
+	if _, ok, err := q.lookup(ctx, hash); err != nil || ok {
+		return err
+	}
+
+	_, err := q.db.ExecContext(ctx,
+		"insert into persisted_queries(hash, query, operation_name) values($1, $2, nullif($3, '')) on conflict (hash) do nothing",
+		hash, query, operationName)
+	return err
+}
+
+// lookup returns the query persisted under hash, reporting whether there is
+// one.
+func (q *Queries) lookup(ctx context.Context, hash string) (entry, bool, error) {
+	var stored entry
+
+	if value, ok, err := q.cache.Get(ctx, hash); err == nil && ok && json.Unmarshal(value, &stored) == nil {
+		return stored, true, nil
+	}
+
+	err := q.db.QueryRowContext(ctx, "select query, approved from persisted_queries where hash = $1", hash).
+		Scan(&stored.Query, &stored.Approved)
+	if err == sql.ErrNoRows {
+		return stored, false, nil
+	}
+	if err != nil {
+		return stored, false, err
+	}
+
+	if value, err := json.Marshal(stored); err == nil {
+		if err := q.cache.Set(ctx, hash, value, cacheTTL); err != nil {
+			slog.WarnContext(ctx, "could not cache a persisted query", "hash", hash, "error", err)
+		}
+	}
+
+	return stored, true, nil
+}
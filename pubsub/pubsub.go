@@ -0,0 +1,61 @@
+// Package pubsub is an in-process publish/subscribe broker used to push
+// patient changes to GraphQL subscribers.
+package pubsub
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// bufferSize is how many events a subscriber may fall behind before further
+// events to it are dropped.
+const bufferSize = 16
+
+// Broker fans published payloads out to the subscribers of a topic. It is
+// safe for concurrent use.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan interface{}]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[string]map[chan interface{}]struct{}{}}
+}
+
+// Subscribe returns a channel receiving the payloads published to topic from
+// now on, and a function that unsubscribes and closes the channel.
+func (b *Broker) Subscribe(topic string) (<-chan interface{}, func()) {
+	ch := make(chan interface{}, bufferSize)
+
+	b.mu.Lock()
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = map[chan interface{}]struct{}{}
+	}
+	b.subscribers[topic][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers[topic], ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends payload to every subscriber of topic without blocking.
+// Subscribers whose buffer is full miss the event, which is logged.
+func (b *Broker) Publish(topic string, payload interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[topic] {
+		select {
+		case ch <- payload:
+		default:
+			slog.Warn("dropping event for slow subscriber", "topic", topic)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Package reminder reminds patients of their upcoming appointments by email
+// and SMS. A Scheduler writes a row to the appointment_reminders table for
+// each channel of every scheduled appointment starting within its lead
+// time, then sends the pending ones through a Notifier, retrying failures
+// with backoff and recording the outcome of each. Rows are claimed before
+// they are sent, so several instances can run a Scheduler on the same
+// database.
+package reminder
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+// The channels reminders are sent on.
+const (
+	Email = "email"
+	SMS   = "sms"
+)
+
+const (
+	// batchSize is how many reminders one poll sends at most.
+	batchSize = 100
+	// maxBackoff caps the wait before retrying a reminder.
+	maxBackoff = time.Hour
+)
+
+// Message is a reminder as a Notifier sends it. To is an email address or
+// an E.164 phone number, depending on the channel.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier sends messages on one channel, returning an error when the
+// message must be retried.
+type Notifier interface {
+	Notify(ctx context.Context, message Message) error
+}
+
+// Scheduler sends the reminders of the appointments starting within lead,
+// every interval, through the notifier of each channel. Patients are read
+// with patients, so deleted patients get no reminders and encrypted
+// addresses are decrypted. A reminder that fails is retried after a backoff
+// doubling from one minute up to an hour, and marked failed after
+// maxAttempts attempts or skipped once its appointment has started.
+type Scheduler struct {
+	db          *sql.DB
+	patients    store.PatientRepository
+	notifiers   map[string]Notifier
+	lead        time.Duration
+	interval    time.Duration
+	timeout     time.Duration
+	maxAttempts int
+	// location is the time zone of the appointment times in messages.
+	location *time.Location
+}
+
+// NewScheduler returns a Scheduler sending reminders through notifiers by
+// channel, giving each send up to timeout and writing times in location.
+func NewScheduler(db *sql.DB, patients store.PatientRepository, notifiers map[string]Notifier,
+	lead, interval, timeout time.Duration, maxAttempts int, location *time.Location) *Scheduler {
+	return &Scheduler{
+		db:          db,
+		patients:    patients,
+		notifiers:   notifiers,
+		lead:        lead,
+		interval:    interval,
+		timeout:     timeout,
+		maxAttempts: maxAttempts,
+		location:    location,
+	}
+}
+
+// Channels returns the channels s sends reminders on, sorted.
+func (s *Scheduler) Channels() []string {
+	channels := make([]string, 0, len(s.notifiers))
+	for channel := range s.notifiers {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	return channels
+}
+
+// Run schedules and sends reminders until ctx is cancelled. A full batch is
+// followed by the next one straight away.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		handled := 0
+
+		err := s.schedule(ctx)
+		if err == nil {
+			handled, err = s.send(ctx)
+		}
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "sending appointment reminders", "error", err)
+		}
+
+		if handled == batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// schedule adds a pending reminder on each channel for the scheduled
+// appointments starting within the lead time that have none for their
+// current start.
+func (s *Scheduler) schedule(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `insert into appointment_reminders (appointment_id, clinic_id, channel, scheduled_for)
+		select a.id, a.clinic_id, c.channel, a.scheduled_at
+		from appointments a cross join unnest($1::text[]) as c(channel)
+		where a.status = 'scheduled' and a.scheduled_at > now() and a.scheduled_at <= now() + $2 * interval '1 second'
+		on conflict (appointment_id, channel, scheduled_for) do nothing`,
+		pq.Array(s.Channels()), s.lead.Seconds())
+	return err
+}
+
+// due is a pending reminder with what its message needs.
+type due struct {
+	id            int64
+	appointmentID int
+	patientID     int
+	clinicID      int
+	clinicName    string
+	channel       string
+	scheduledFor  time.Time
+	attempts      int
+	// current is false once the appointment was cancelled or moved away
+	// from scheduledFor.
+	current bool
+}
+
+// send sends a batch of the reminders due, soonest appointment first, and
+// returns how many it sent, rescheduled or gave up on. No transaction is
+// open while messages are sent: the batch is claimed first, and the outcome
+// of each reminder is recorded on its own once it is sent, so a failure
+// later in the batch does not send the reminders before it again.
+func (s *Scheduler) send(ctx context.Context) (int, error) {
+	reminders, err := s.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, r := range reminders {
+		if err := s.deliver(ctx, r); err != nil {
+			return i, err
+		}
+	}
+
+	return len(reminders), nil
+}
+
+// claim locks the next batch of reminders due and postpones their next
+// attempt until the batch had the time to be sent, so no other Scheduler
+// sends them meanwhile. The reminders of a Scheduler that stops while
+// sending are sent again once the claim runs out.
+func (s *Scheduler) claim(ctx context.Context) ([]due, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	reminders, err := dueReminders(ctx, tx)
+	if err != nil || len(reminders) == 0 {
+		return nil, err
+	}
+
+	ids := make([]int64, len(reminders))
+	for i, r := range reminders {
+		ids[i] = r.id
+	}
+
+	lease := time.Duration(len(reminders))*s.timeout + time.Minute
+	_, err = tx.ExecContext(ctx,
+		"update appointment_reminders set next_attempt_at = now() + $1 * interval '1 millisecond' where id = any($2)",
+		lease.Milliseconds(), pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	return reminders, tx.Commit()
+}
+
+// deliver sends r, or skips it when it is no longer wanted, and records the
+// outcome. A patient that cannot be read is retried like a failed send.
+func (s *Scheduler) deliver(ctx context.Context, r due) error {
+	switch {
+	case !r.current:
+		return s.skip(ctx, r, "the appointment was cancelled or moved")
+	case !r.scheduledFor.After(time.Now()):
+		return s.skip(ctx, r, "the appointment started before the reminder could be sent")
+	}
+
+	notifier, ok := s.notifiers[r.channel]
+	if !ok {
+		return s.skip(ctx, r, "reminders are no longer sent by "+r.channel)
+	}
+
+	patient, err := s.patients.Get(tenant.WithClinic(ctx, r.clinicID), r.patientID, false)
+	if errors.Is(err, store.ErrNotFound) {
+		return s.skip(ctx, r, "the patient is deleted")
+	}
+	if err != nil {
+		return s.retry(ctx, r, fmt.Errorf("could not read the patient: %w", err))
+	}
+
+	message := s.message(r, patient)
+	if message.To == "" {
+		return s.skip(ctx, r, "the patient has no address for "+r.channel)
+	}
+
+	if err := s.notify(ctx, notifier, message); err != nil {
+		return s.retry(ctx, r, err)
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		"update appointment_reminders set status = 'sent', attempts = attempts + 1, last_error = null, sent_at = now() where id = $1", r.id)
+	return err
+}
+
+func (s *Scheduler) notify(ctx context.Context, notifier Notifier, message Message) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return notifier.Notify(ctx, message)
+}
+
+// message returns the reminder r sends to patient.
+func (s *Scheduler) message(r due, patient *store.Patient) Message {
+	to := patient.Email
+	if r.channel == SMS {
+		to = patient.Phone
+	}
+
+	when := r.scheduledFor.In(s.location).Format("Monday 2 January 2006 at 15:04 MST")
+
+	return Message{
+		To:      to,
+		Subject: "Appointment reminder from " + r.clinicName,
+		Body:    fmt.Sprintf("Hello %s, this is a reminder of your appointment at %s on %s.", patient.Name, r.clinicName, when),
+	}
+}
+
+// retry records that r failed with cause, scheduling its next attempt or
+// giving up on it.
+func (s *Scheduler) retry(ctx context.Context, r due, cause error) error {
+	attempts := r.attempts + 1
+
+	if attempts >= s.maxAttempts {
+		slog.ErrorContext(ctx, "giving up on appointment reminder", "reminder_id", r.id, "appointment_id", r.appointmentID,
+			"channel", r.channel, "attempts", attempts, "error", cause)
+
+		_, err := s.db.ExecContext(ctx,
+			"update appointment_reminders set status = 'failed', attempts = $1, last_error = $2 where id = $3",
+			attempts, cause.Error(), r.id)
+		return err
+	}
+
+	wait := backoff(attempts)
+	slog.WarnContext(ctx, "appointment reminder failed", "reminder_id", r.id, "appointment_id", r.appointmentID,
+		"channel", r.channel, "attempts", attempts, "retry_in", wait.String(), "error", cause)
+
+	_, err := s.db.ExecContext(ctx,
+		"update appointment_reminders set attempts = $1, last_error = $2, next_attempt_at = now() + $3 * interval '1 millisecond' where id = $4",
+		attempts, cause.Error(), wait.Milliseconds(), r.id)
+	return err
+}
+
+// skip marks r skipped for reason without sending it.
+func (s *Scheduler) skip(ctx context.Context, r due, reason string) error {
+	_, err := s.db.ExecContext(ctx,
+		"update appointment_reminders set status = 'skipped', last_error = $1 where id = $2", reason, r.id)
+	return err
+}
+
+// dueReminders locks the next batch of pending reminders due, skipping
+// those another Scheduler is sending.
+func dueReminders(ctx context.Context, tx *sql.Tx) ([]due, error) {
+	rows, err := tx.QueryContext(ctx, `select r.id, r.appointment_id, a.patient_id, r.clinic_id, c.name, r.channel, r.scheduled_for, r.attempts,
+			a.status = 'scheduled' and a.scheduled_at = r.scheduled_for
+		from appointment_reminders r
+			join appointments a on a.id = r.appointment_id
+			join clinics c on c.id = r.clinic_id
+		where r.status = 'pending' and r.next_attempt_at <= now()
+		order by r.scheduled_for, r.id limit $1 for update of r skip locked`, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []due
+	for rows.Next() {
+		var r due
+		err := rows.Scan(&r.id, &r.appointmentID, &r.patientID, &r.clinicID, &r.clinicName, &r.channel, &r.scheduledFor, &r.attempts, &r.current)
+		if err != nil {
+			return nil, err
+		}
+
+		reminders = append(reminders, r)
+	}
+
+	return reminders, rows.Err()
+}
+
+// backoff is the wait after the given number of failed attempts.
+func backoff(attempts int) time.Duration {
+	if attempts > 7 {
+		return maxBackoff
+	}
+
+	return min(time.Minute<<(attempts-1), maxBackoff)
+}
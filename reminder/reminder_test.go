@@ -0,0 +1,340 @@
+package reminder
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// fakeReminder is a row of the appointment_reminders table of a fakeDB.
+type fakeReminder struct {
+	due
+	status string
+	// waiting is set while the next attempt of a pending reminder is not
+	// due, after a claim or a failed attempt.
+	waiting bool
+}
+
+// fakeDB is an appointment_reminders table answering the statements of a
+// Scheduler. Statements in a transaction only apply once it commits.
+type fakeDB struct {
+	mu        sync.Mutex
+	reminders map[int64]*fakeReminder
+	// openTx is the number of transactions begun and not ended.
+	openTx int
+}
+
+var fakeDBs sync.Map
+
+func init() {
+	sql.Register("reminder-fake", fakeDriver{})
+}
+
+// newFakeDB returns a database holding reminders, pending and due.
+func newFakeDB(t *testing.T, reminders ...due) (*sql.DB, *fakeDB) {
+	t.Helper()
+
+	fake := &fakeDB{reminders: map[int64]*fakeReminder{}}
+	for _, r := range reminders {
+		fake.reminders[r.id] = &fakeReminder{due: r, status: "pending"}
+	}
+
+	fakeDBs.Store(t.Name(), fake)
+	db, err := sql.Open("reminder-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBs.Delete(t.Name())
+	})
+
+	return db, fake
+}
+
+// reminder returns a copy of the row of id.
+func (f *fakeDB) reminder(id int64) fakeReminder {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return *f.reminders[id]
+}
+
+// elapse makes the pending reminders due again, as after their claim or
+// backoff ran out.
+func (f *fakeDB) elapse() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, r := range f.reminders {
+		r.waiting = false
+	}
+}
+
+// apply returns the change query makes with args.
+func (f *fakeDB) apply(query string, args []driver.NamedValue) (func(), error) {
+	id := func(i int) *fakeReminder { return f.reminders[int64(asInt(args[i].Value))] }
+
+	switch {
+	case strings.HasPrefix(query, "update appointment_reminders set next_attempt_at"):
+		ids := args[1].Value.(*pq.Int64Array)
+		return func() {
+			for _, i := range *ids {
+				f.reminders[i].waiting = true
+			}
+		}, nil
+	case strings.HasPrefix(query, "update appointment_reminders set status = 'sent'"):
+		return func() { r := id(0); r.status = "sent"; r.attempts++ }, nil
+	case strings.HasPrefix(query, "update appointment_reminders set status = 'failed'"):
+		return func() { r := id(2); r.status = "failed"; r.attempts = asInt(args[0].Value) }, nil
+	case strings.HasPrefix(query, "update appointment_reminders set attempts"):
+		return func() { r := id(3); r.attempts = asInt(args[0].Value); r.waiting = true }, nil
+	case strings.HasPrefix(query, "update appointment_reminders set status = 'skipped'"):
+		return func() { id(1).status = "skipped" }, nil
+	}
+
+	return nil, fmt.Errorf("unexpected statement %q", query)
+}
+
+// asInt returns an integer argument, which is passed on as is.
+func asInt(v driver.Value) int {
+	switch v := v.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	}
+
+	panic(fmt.Sprintf("%v is not an integer", v))
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fake, ok := fakeDBs.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("no fake database %q", name)
+	}
+
+	return &fakeConn{db: fake.(*fakeDB)}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+	// staged are the changes of the open transaction, nil outside one.
+	staged []func()
+	inTx   bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("the fake driver does not prepare statements")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.inTx, c.staged = true, nil
+	c.db.openTx++
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	for _, change := range c.staged {
+		change()
+	}
+	c.inTx, c.staged = false, nil
+	c.db.openTx--
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.inTx, c.staged = false, nil
+	c.db.openTx--
+	return nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	change, err := c.db.apply(query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.inTx {
+		c.staged = append(c.staged, change)
+	} else {
+		change()
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// QueryContext answers the query of dueReminders with the pending reminders
+// due.
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if !strings.Contains(query, "from appointment_reminders r") {
+		return nil, fmt.Errorf("unexpected query %q", query)
+	}
+
+	rows := &fakeRows{}
+	for _, r := range c.db.reminders {
+		if r.status == "pending" && !r.waiting {
+			rows.rows = append(rows.rows, []driver.Value{r.id, int64(r.appointmentID), int64(r.patientID), int64(r.clinicID),
+				r.clinicName, r.channel, r.scheduledFor, int64(r.attempts), r.current})
+		}
+	}
+	sort.Slice(rows.rows, func(i, j int) bool { return rows.rows[i][0].(int64) < rows.rows[j][0].(int64) })
+
+	return rows, nil
+}
+
+// CheckNamedValue accepts every argument as is, such as the pq arrays.
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id", "appointment_id", "patient_id", "clinic_id", "name", "channel", "scheduled_for", "attempts", "current"}
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// lookupPatients returns a patient for every id, failing the lookups of
+// failing.
+type lookupPatients struct {
+	store.PatientRepository
+	mu      sync.Mutex
+	failing map[int]bool
+}
+
+func (p *lookupPatients) Get(ctx context.Context, id int, includeDeleted bool) (*store.Patient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failing[id] {
+		return nil, errors.New("connection reset")
+	}
+
+	return &store.Patient{ID: id, Name: fmt.Sprintf("Patient %d", id), Email: fmt.Sprintf("patient%d@example.com", id)}, nil
+}
+
+// recordingNotifier records the addresses of the messages it sends, failing
+// the test when a transaction is open while it does.
+type recordingNotifier struct {
+	t    *testing.T
+	db   *fakeDB
+	mu   sync.Mutex
+	sent []string
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, message Message) error {
+	n.db.mu.Lock()
+	if n.db.openTx != 0 {
+		n.t.Errorf("%d transactions are open while sending to %s", n.db.openTx, message.To)
+	}
+	n.db.mu.Unlock()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.sent = append(n.sent, message.To)
+	return nil
+}
+
+func TestSendRecordsEachReminder(t *testing.T) {
+	scheduledFor := time.Now().Add(time.Hour)
+	db, fake := newFakeDB(t,
+		due{id: 1, appointmentID: 1, patientID: 10, clinicID: 1, clinicName: "Main", channel: Email, scheduledFor: scheduledFor, current: true},
+		due{id: 2, appointmentID: 2, patientID: 20, clinicID: 1, clinicName: "Main", channel: Email, scheduledFor: scheduledFor, current: true},
+	)
+
+	patients := &lookupPatients{failing: map[int]bool{20: true}}
+	notifier := &recordingNotifier{t: t, db: fake}
+	s := NewScheduler(db, patients, map[string]Notifier{Email: notifier}, time.Hour, time.Minute, time.Second, 5, time.UTC)
+
+	if handled, err := s.send(context.Background()); err != nil || handled != 2 {
+		t.Fatalf("send() = %d, %v, want 2 handled", handled, err)
+	}
+	if r := fake.reminder(1); r.status != "sent" || r.attempts != 1 {
+		t.Errorf("first reminder is %s after %d attempts, want sent after 1", r.status, r.attempts)
+	}
+	if r := fake.reminder(2); r.status != "pending" || r.attempts != 1 || !r.waiting {
+		t.Errorf("second reminder is %s after %d attempts, waiting %v, want pending and retried later after 1", r.status, r.attempts, r.waiting)
+	}
+
+	// Once the backoff runs out the second reminder is sent, and only it.
+	fake.elapse()
+	patients.mu.Lock()
+	patients.failing = nil
+	patients.mu.Unlock()
+
+	if handled, err := s.send(context.Background()); err != nil || handled != 1 {
+		t.Fatalf("second send() = %d, %v, want 1 handled", handled, err)
+	}
+
+	want := []string{"patient10@example.com", "patient20@example.com"}
+	if fmt.Sprint(notifier.sent) != fmt.Sprint(want) {
+		t.Errorf("sent to %v, want %v", notifier.sent, want)
+	}
+	if r := fake.reminder(2); r.status != "sent" || r.attempts != 2 {
+		t.Errorf("second reminder is %s after %d attempts, want sent after 2", r.status, r.attempts)
+	}
+}
+
+func TestSendClaimsTheBatch(t *testing.T) {
+	db, fake := newFakeDB(t,
+		due{id: 1, appointmentID: 1, patientID: 10, clinicID: 1, clinicName: "Main", channel: Email, scheduledFor: time.Now().Add(time.Hour), current: true},
+	)
+
+	// A Scheduler polling while the reminder is being sent finds nothing due.
+	notifier := &recordingNotifier{t: t, db: fake}
+	s := NewScheduler(db, &lookupPatients{}, map[string]Notifier{Email: notifier}, time.Hour, time.Minute, time.Second, 5, time.UTC)
+
+	claimed, err := s.claim(context.Background())
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("claim() = %v, %v, want the reminder", claimed, err)
+	}
+	if handled, err := s.send(context.Background()); err != nil || handled != 0 {
+		t.Errorf("send() of a claimed batch = %d, %v, want nothing handled", handled, err)
+	}
+	if len(notifier.sent) != 0 {
+		t.Errorf("sent to %v, want nothing", notifier.sent)
+	}
+}
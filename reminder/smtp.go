@@ -0,0 +1,108 @@
+package reminder
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTP sends reminders by email through an SMTP server, upgrading the
+// connection with STARTTLS when the server offers it. Servers that only
+// accept TLS from the start of the connection, usually on port 465, are not
+// supported.
+type SMTP struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTP returns an SMTP sending from the address from through the server
+// at host and port, authenticating with username and password unless
+// username is empty.
+func NewSMTP(host string, port int, username, password, from string) *SMTP {
+	return &SMTP{
+		addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		host:     host,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+// Notify sends message as a plain text email, failing unless the server
+// accepts it within the deadline of ctx.
+func (s *SMTP) Notify(ctx context.Context, message Message) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("smtp %s: %w", s.addr, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return fmt.Errorf("smtp %s: %w", s.addr, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return fmt.Errorf("smtp %s: starttls: %w", s.addr, err)
+		}
+	}
+
+	if s.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return fmt.Errorf("smtp %s: auth: %w", s.addr, err)
+		}
+	}
+
+	if err := client.Mail(s.from); err != nil {
+		return fmt.Errorf("smtp %s: %w", s.addr, err)
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return fmt.Errorf("smtp %s: %w", s.addr, err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp %s: %w", s.addr, err)
+	}
+	if _, err := w.Write(s.email(message)); err != nil {
+		return fmt.Errorf("smtp %s: %w", s.addr, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp %s: %w", s.addr, err)
+	}
+
+	return client.Quit()
+}
+
+// email returns message as an RFC 5322 email.
+func (s *SMTP) email(message Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", message.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(message.Body)
+	b.WriteString("\r\n")
+
+	return b.Bytes()
+}
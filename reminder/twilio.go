@@ -0,0 +1,64 @@
+package reminder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// twilioAPI is the base URL of the Twilio REST API.
+const twilioAPI = "https://api.twilio.com/2010-04-01"
+
+// Twilio sends reminders by SMS with the Messages resource of the Twilio
+// API.
+type Twilio struct {
+	accountSID string
+	authToken  string
+	from       string
+	client     *http.Client
+}
+
+// NewTwilio returns a Twilio sending from the phone number from with the
+// credentials of the account accountSID.
+func NewTwilio(accountSID, authToken, from string) *Twilio {
+	return &Twilio{accountSID: accountSID, authToken: authToken, from: from, client: &http.Client{}}
+}
+
+// Notify sends the body of message as an SMS, failing unless Twilio accepts
+// it within the deadline of ctx.
+func (t *Twilio) Notify(ctx context.Context, message Message) error {
+	form := url.Values{"To": {message.To}, "From": {t.from}, "Body": {message.Body}}
+	endpoint := twilioAPI + "/Accounts/" + url.PathEscape(t.accountSID) + "/Messages.json"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.accountSID, t.authToken)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("twilio: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return nil
+	}
+
+	// Only the error code is kept: the messages of Twilio errors may quote
+	// the phone number.
+	var apiErr struct {
+		Code int `json:"code"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+
+	return fmt.Errorf("twilio answered %s, error code %d", resp.Status, apiErr.Code)
+}
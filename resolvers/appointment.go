@@ -0,0 +1,281 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// PatientAppointments resolves the appointments field of a patient.
+func (r *Resolver) PatientAppointments(params graphql.ResolveParams) (interface{}, error) {
+	patient, ok := params.Source.(*store.Patient)
+	if !ok {
+		return nil, nil
+	}
+
+	thunk := load(params.Context, appointmentLoaderKey{}, r.appointments.ListByPatients, patient.ID)
+
+	return func() (interface{}, error) {
+		appointments, err := thunk()
+		if err != nil {
+			return nil, dbError(params.Context, err, "could not list appointments of patient %d", patient.ID)
+		}
+
+		return appointments, nil
+	}, nil
+}
+
+func (r *Resolver) GetAppointment(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, ReadRoles...); err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	appointment, err := r.appointments.Get(params.Context, id)
+	if isNotFound(err) {
+		return nil, utils.NotFound("appointment %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not get appointment %d", id)
+	}
+
+	return appointment, nil
+}
+
+func (r *Resolver) GetAppointmentsByPatient(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, ReadRoles...); err != nil {
+		return nil, err
+	}
+
+	patientID, _ := params.Args["patientId"].(int)
+
+	appointments, err := r.appointments.ListByPatient(params.Context, patientID)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not list appointments of patient %d", patientID)
+	}
+
+	return appointments, nil
+}
+
+func (r *Resolver) GetAppointmentsByDateRange(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, ReadRoles...); err != nil {
+		return nil, err
+	}
+
+	from, err := timeArg(params.Args, "from")
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := timeArg(params.Args, "to")
+	if err != nil {
+		return nil, err
+	}
+
+	if !to.After(*from) {
+		return nil, fmt.Errorf("to must be after from")
+	}
+
+	var providerID *int
+	if id, ok := params.Args["providerId"].(int); ok {
+		providerID = &id
+	}
+
+	appointments, err := r.appointments.ListBetween(params.Context, *from, *to, providerID)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not list appointments")
+	}
+
+	return appointments, nil
+}
+
+// ScheduledPatient is a patient on the schedule of a clinician with the
+// appointment they are on it for.
+type ScheduledPatient struct {
+	Patient     *store.Patient     `json:"patient"`
+	Appointment *store.Appointment `json:"appointment"`
+}
+
+// GetClinicianSchedule lists the patients who are not deleted of the
+// scheduled appointments a provider has starting on a day in UTC, soonest
+// first, recording the reads in the audit log.
+func (r *Resolver) GetClinicianSchedule(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, ReadRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	providerID, _ := params.Args["clinicianId"].(int)
+	date, _ := params.Args["date"].(string)
+
+	day, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return nil, fmt.Errorf("date must be a date such as 2024-06-30, got %q", date)
+	}
+
+	appointments, err := r.appointments.ListScheduled(params.Context, providerID, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not list appointments of provider %d", providerID)
+	}
+
+	schedule := make([]*ScheduledPatient, len(appointments))
+	patients := make([]*store.Patient, len(appointments))
+	for i, appointment := range appointments {
+		schedule[i] = &ScheduledPatient{Patient: appointment.Patient, Appointment: appointment}
+		patients[i] = appointment.Patient
+	}
+
+	if err := r.logAccess(params.Context, userID, "read", patients...); err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+func (r *Resolver) CreateAppointment(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	appointment, err := appointmentFromArgs(params.Args)
+	if err != nil {
+		return nil, err
+	}
+	appointment.PatientID, _ = params.Args["patientId"].(int)
+
+	key, err := idempotencyKey(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// A replay gets the appointment the first request booked.
+	var id int
+	_, err = r.idempotent(params.Context, userID, key, params.Info.FieldName, params.Args, &id, func(ctx context.Context) error {
+		var err error
+		id, err = r.appointments.Book(ctx, appointment)
+		return err
+	})
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", appointment.PatientID)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not create appointment")
+	}
+
+	appointment, err = r.appointments.Get(params.Context, id)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not get appointment %d", id)
+	}
+
+	return appointment, nil
+}
+
+// appointmentFromArgs reads the scheduledAt, endsAt, providerId, reason and
+// notes arguments of a booking, defaulting endsAt to
+// store.DefaultAppointmentDuration after scheduledAt.
+func appointmentFromArgs(args map[string]interface{}) (*store.Appointment, error) {
+	appointment := &store.Appointment{}
+	appointment.Reason, _ = args["reason"].(string)
+	appointment.Notes, _ = args["notes"].(string)
+	if providerID, ok := args["providerId"].(int); ok {
+		appointment.ProviderID = &providerID
+	}
+
+	scheduledAt, err := timeArg(args, "scheduledAt")
+	if err != nil {
+		return nil, err
+	}
+	appointment.ScheduledAt = *scheduledAt
+
+	endsAt, err := timeArg(args, "endsAt")
+	if err != nil {
+		return nil, err
+	}
+	appointment.EndsAt = appointment.ScheduledAt.Add(store.DefaultAppointmentDuration)
+	if endsAt != nil {
+		appointment.EndsAt = *endsAt
+	}
+
+	if !appointment.EndsAt.After(appointment.ScheduledAt) {
+		return nil, fmt.Errorf("endsAt must be after scheduledAt")
+	}
+
+	return appointment, nil
+}
+
+func (r *Resolver) RescheduleAppointment(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, WriteRoles...); err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	scheduledAt, err := timeArg(params.Args, "scheduledAt")
+	if err != nil {
+		return nil, err
+	}
+
+	endsAt, err := timeArg(params.Args, "endsAt")
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.appointments.Reschedule(params.Context, id, *scheduledAt, endsAt)
+	if isNotFound(err) {
+		return nil, utils.NotFound("appointment %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not reschedule appointment %d", id)
+	}
+
+	appointment, err := r.appointments.Get(params.Context, id)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not get appointment %d", id)
+	}
+
+	return appointment, nil
+}
+
+func (r *Resolver) UpdateAppointmentStatus(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, WriteRoles...); err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+	status, _ := params.Args["status"].(string)
+
+	appointment, err := r.appointments.SetStatus(params.Context, id, status)
+	if isNotFound(err) {
+		return nil, utils.NotFound("appointment %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not update appointment %d", id)
+	}
+
+	return appointment, nil
+}
+
+func (r *Resolver) CancelAppointment(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, WriteRoles...); err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	appointment, err := r.appointments.SetStatus(params.Context, id, "cancelled")
+	if isNotFound(err) {
+		return nil, utils.NotFound("appointment %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not cancel appointment %d", id)
+	}
+
+	return appointment, nil
+}
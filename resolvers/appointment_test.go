@@ -0,0 +1,227 @@
+package resolvers_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+)
+
+type appointment struct {
+	ID          int
+	PatientID   int
+	ScheduledAt string
+	EndsAt      string
+	Reason      string
+	Status      string
+	Patient     struct{ Name string }
+}
+
+const appointmentFields = "id patientId scheduledAt endsAt reason status patient { name }"
+
+func TestAppointmentLifecycle(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := clinician(t)
+	patientID := env.createPatient(t, ctx, "Ann Lee", "ann@example.com", "+14155550100")
+
+	var created struct{ CreateAppointment appointment }
+	env.mustDo(t, ctx, `mutation($patientId: Int!) {
+		createAppointment(patientId: $patientId, scheduledAt: "2030-03-01T10:00:00Z", reason: "Checkup") { `+appointmentFields+` }
+	}`, map[string]interface{}{"patientId": patientID}, &created)
+
+	got := created.CreateAppointment
+	want := appointment{
+		ID: got.ID, PatientID: patientID, ScheduledAt: "2030-03-01T10:00:00Z", EndsAt: "2030-03-01T10:30:00Z",
+		Reason: "Checkup", Status: "SCHEDULED", Patient: struct{ Name string }{"Ann Lee"},
+	}
+	if got != want {
+		t.Fatalf("createAppointment = %+v, want %+v", got, want)
+	}
+	id := got.ID
+
+	var read struct{ GetAppointment appointment }
+	env.mustDo(t, ctx, `query($id: Int!) { getAppointment(id: $id) { `+appointmentFields+` } }`, map[string]interface{}{"id": id}, &read)
+	if read.GetAppointment != want {
+		t.Errorf("getAppointment = %+v, want %+v", read.GetAppointment, want)
+	}
+
+	var byPatient struct {
+		GetAppointmentsByPatient []appointment
+		GetPatient               struct{ Appointments []struct{ ID int } }
+	}
+	env.mustDo(t, ctx, `query($patientId: Int!) {
+		getAppointmentsByPatient(patientId: $patientId) { `+appointmentFields+` }
+		getPatient(id: $patientId) { appointments { id } }
+	}`, map[string]interface{}{"patientId": patientID}, &byPatient)
+	if len(byPatient.GetAppointmentsByPatient) != 1 || byPatient.GetAppointmentsByPatient[0] != want {
+		t.Errorf("getAppointmentsByPatient = %+v, want [%+v]", byPatient.GetAppointmentsByPatient, want)
+	}
+	if apps := byPatient.GetPatient.Appointments; len(apps) != 1 || apps[0].ID != id {
+		t.Errorf("patient appointments = %+v, want [%d]", apps, id)
+	}
+
+	var rescheduled struct{ RescheduleAppointment appointment }
+	env.mustDo(t, ctx, `mutation($id: Int!) {
+		rescheduleAppointment(id: $id, scheduledAt: "2030-03-02T09:00:00Z") { `+appointmentFields+` }
+	}`, map[string]interface{}{"id": id}, &rescheduled)
+	if r := rescheduled.RescheduleAppointment; r.ScheduledAt != "2030-03-02T09:00:00Z" || r.EndsAt != "2030-03-02T09:30:00Z" {
+		t.Errorf("rescheduleAppointment = %s to %s, want 09:00 to 09:30 on March 2", r.ScheduledAt, r.EndsAt)
+	}
+
+	var updated struct{ UpdateAppointmentStatus appointment }
+	env.mustDo(t, ctx, `mutation($id: Int!) { updateAppointmentStatus(id: $id, status: NO_SHOW) { status } }`,
+		map[string]interface{}{"id": id}, &updated)
+	if updated.UpdateAppointmentStatus.Status != "NO_SHOW" {
+		t.Errorf("updateAppointmentStatus: status = %s, want NO_SHOW", updated.UpdateAppointmentStatus.Status)
+	}
+
+	var cancelled struct{ CancelAppointment appointment }
+	env.mustDo(t, ctx, `mutation($id: Int!) { cancelAppointment(id: $id) { status } }`, map[string]interface{}{"id": id}, &cancelled)
+	if cancelled.CancelAppointment.Status != "CANCELLED" {
+		t.Errorf("cancelAppointment: status = %s, want CANCELLED", cancelled.CancelAppointment.Status)
+	}
+
+	result := env.do(ctx, `mutation($id: Int!) { rescheduleAppointment(id: $id, scheduledAt: "2030-03-03T09:00:00Z") { id } }`,
+		map[string]interface{}{"id": id})
+	if code := errorCode(t, result); code != "BAD_USER_INPUT" {
+		t.Errorf("rescheduling a cancelled appointment: code = %q, want BAD_USER_INPUT: %v", code, result.Errors)
+	}
+}
+
+func TestGetClinicianSchedule(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := clinician(t)
+
+	var providers struct {
+		A struct{ ID int }
+		B struct{ ID int }
+	}
+	env.mustDo(t, admin(t), `mutation {
+		a: createProvider(name: "Dr. Jane Smith", specialty: "Cardiology") { id }
+		b: createProvider(name: "Dr. Sam Wu", specialty: "Dermatology") { id }
+	}`, nil, &providers)
+
+	ids := map[string]int{
+		"ann": env.createPatient(t, ctx, "Ann Lee", "ann@example.com", "+14155550100"),
+		"bob": env.createPatient(t, ctx, "Bob Ray", "bob@example.com", "+14155550111"),
+		"cy":  env.createPatient(t, ctx, "Cy Day", "cy@example.com", "+14155550122"),
+		"dee": env.createPatient(t, ctx, "Dee Fox", "dee@example.com", "+14155550133"),
+	}
+	bookings := []struct {
+		patient     string
+		providerID  int
+		scheduledAt string
+		endsAt      string
+		cancelled   bool
+	}{
+		{"ann", providers.A.ID, "2030-03-01T14:00:00Z", "", false},
+		{"bob", providers.A.ID, "2030-03-01T09:00:00Z", "", false},
+		{"cy", providers.B.ID, "2030-03-01T10:00:00Z", "", false},
+		{"cy", providers.A.ID, "2030-03-01T11:30:00Z", "", false},
+		{"ann", providers.A.ID, "2030-03-02T09:00:00Z", "", false},
+		{"bob", providers.A.ID, "2030-02-28T23:45:00Z", "2030-03-01T00:15:00Z", false},
+		{"ann", providers.A.ID, "2030-03-01T08:00:00Z", "", true},
+		{"dee", providers.A.ID, "2030-03-01T10:00:00Z", "", false},
+	}
+	for _, b := range bookings {
+		vars := map[string]interface{}{"patientId": ids[b.patient], "providerId": b.providerID, "scheduledAt": b.scheduledAt}
+		if b.endsAt != "" {
+			vars["endsAt"] = b.endsAt
+		}
+		var created struct{ CreateAppointment struct{ ID int } }
+		env.mustDo(t, ctx, `mutation($patientId: Int!, $providerId: Int, $scheduledAt: String!, $endsAt: String) {
+			createAppointment(patientId: $patientId, providerId: $providerId, scheduledAt: $scheduledAt, endsAt: $endsAt, reason: "Checkup") { id }
+		}`, vars, &created)
+
+		if b.cancelled {
+			env.mustDo(t, ctx, `mutation($id: Int!) { cancelAppointment(id: $id) { id } }`, map[string]interface{}{"id": created.CreateAppointment.ID}, nil)
+		}
+	}
+	env.mustDo(t, ctx, `mutation($id: Int!) { delete(id: $id) { id } }`, map[string]interface{}{"id": ids["dee"]}, nil)
+
+	var data struct {
+		GetClinicianSchedule []struct {
+			Patient     struct{ ID int }
+			Appointment struct{ PatientID int }
+		}
+	}
+	env.mustDo(t, asUser(t, "readonly-1", middleware.RoleReadonly), `query($clinicianId: Int!) {
+		getClinicianSchedule(clinicianId: $clinicianId, date: "2030-03-01") { patient { id } appointment { patientId } }
+	}`, map[string]interface{}{"clinicianId": providers.A.ID}, &data)
+
+	want := []int{ids["bob"], ids["cy"], ids["ann"]}
+	got := []int{}
+	for _, s := range data.GetClinicianSchedule {
+		got = append(got, s.Patient.ID)
+		if s.Appointment.PatientID != s.Patient.ID {
+			t.Errorf("appointment of patient %d is for patient %d", s.Patient.ID, s.Appointment.PatientID)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("patients on the schedule = %v, want %v", got, want)
+	}
+
+	var log struct {
+		GetAuditLog []struct{ Operation, PerformedBy string }
+	}
+	env.mustDo(t, ctx, `query($patientId: Int!) { getAuditLog(patientId: $patientId, limit: 1) { operation performedBy } }`,
+		map[string]interface{}{"patientId": ids["cy"]}, &log)
+	if len(log.GetAuditLog) != 1 || log.GetAuditLog[0].Operation != "read" || log.GetAuditLog[0].PerformedBy != "readonly-1" {
+		t.Errorf("latest audit entries = %+v, want a read by readonly-1", log.GetAuditLog)
+	}
+}
+
+func TestAppointmentErrors(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := clinician(t)
+	patientID := env.createPatient(t, ctx, "Ann Lee", "ann@example.com", "+14155550100")
+
+	tests := []struct {
+		name  string
+		query string
+		code  string
+		err   string
+	}{
+		{"get unknown", `{ getAppointment(id: 999) { id } }`, "NOT_FOUND", ""},
+		{"create for unknown patient", `mutation { createAppointment(patientId: 999, scheduledAt: "2030-03-01T10:00:00Z", reason: "Checkup") { id } }`, "NOT_FOUND", ""},
+		{"create with a bad time", `mutation($patientId: Int!) { createAppointment(patientId: $patientId, scheduledAt: "tomorrow", reason: "Checkup") { id } }`, "", "scheduledAt"},
+		{
+			"create ending before it starts",
+			`mutation($patientId: Int!) { createAppointment(patientId: $patientId, scheduledAt: "2030-03-01T10:00:00Z", endsAt: "2030-03-01T09:00:00Z", reason: "Checkup") { id } }`,
+			"", "endsAt must be after scheduledAt",
+		},
+		{"reschedule unknown", `mutation { rescheduleAppointment(id: 999, scheduledAt: "2030-03-01T10:00:00Z") { id } }`, "NOT_FOUND", ""},
+		{"update unknown", `mutation { updateAppointmentStatus(id: 999, status: COMPLETED) { id } }`, "NOT_FOUND", ""},
+		{"cancel unknown", `mutation { cancelAppointment(id: 999) { id } }`, "NOT_FOUND", ""},
+		{"schedule of a bad date", `{ getClinicianSchedule(clinicianId: 1, date: "2030-03-01T00:00:00Z") { patient { id } } }`, "", "date must be a date"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := env.do(ctx, tt.query, map[string]interface{}{"patientId": patientID})
+			if !result.HasErrors() {
+				t.Fatal("no error")
+			}
+			if code := errorCode(t, result); tt.code != "" && code != tt.code {
+				t.Errorf("code = %q, want %q: %v", code, tt.code, result.Errors)
+			}
+			if !strings.Contains(result.Errors[0].Message, tt.err) {
+				t.Errorf("error = %q, want one containing %q", result.Errors[0].Message, tt.err)
+			}
+		})
+	}
+}
+
+func TestAppointmentMutationsNeedWriteRole(t *testing.T) {
+	env := newTestEnv(t)
+	patientID := env.createPatient(t, clinician(t), "Ann Lee", "ann@example.com", "+14155550100")
+	readonly := asUser(t, "readonly-1", middleware.RoleReadonly)
+
+	result := env.do(readonly, `mutation($patientId: Int!) {
+		createAppointment(patientId: $patientId, scheduledAt: "2030-03-01T10:00:00Z", reason: "Checkup") { id }
+	}`, map[string]interface{}{"patientId": patientID})
+	if code := errorCode(t, result); code != "FORBIDDEN" {
+		t.Errorf("code = %q, want FORBIDDEN: %v", code, result.Errors)
+	}
+}
@@ -0,0 +1,107 @@
+package resolvers
+
+import (
+	"context"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// AuditEntryConnection is one page of audit entries along with the
+// information needed to request the next one.
+type AuditEntryConnection struct {
+	Entries     []*audit.Entry `json:"entries"`
+	TotalCount  int            `json:"totalCount"`
+	HasNextPage bool           `json:"hasNextPage"`
+}
+
+func (r *Resolver) GetAuditLog(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, ReadRoles...); err != nil {
+		return nil, err
+	}
+
+	patientID, _ := params.Args["patientId"].(int)
+
+	limit, offset, err := pageArgs(params.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := r.auditLog.Entries(params.Context, patientID, limit, offset)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not read audit log of patient %d", patientID)
+	}
+
+	return entries, nil
+}
+
+// GetAuditEntries pages through the whole audit log, reads included, and is
+// only open to admins.
+func (r *Resolver) GetAuditEntries(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, middleware.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	limit, offset, err := pageArgs(params.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter audit.Filter
+	if patientID, ok := params.Args["patientId"].(int); ok {
+		filter.PatientID = &patientID
+	}
+	filter.PerformedBy, _ = params.Args["performedBy"].(string)
+	filter.Operation, _ = params.Args["operation"].(string)
+
+	if filter.From, err = timeArg(params.Args, "from"); err != nil {
+		return nil, err
+	}
+	if filter.To, err = timeArg(params.Args, "to"); err != nil {
+		return nil, err
+	}
+
+	entries, total, err := r.auditLog.Search(params.Context, filter, limit, offset)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not search the audit log")
+	}
+
+	return &AuditEntryConnection{
+		Entries:     entries,
+		TotalCount:  total,
+		HasNextPage: offset+len(entries) < total,
+	}, nil
+}
+
+type loadTestCtxKey struct{}
+
+// WithLoadTest returns ctx marking its queries as a load test, whose reads
+// are not recorded in the audit log. Only the load simulation uses it, which
+// discards the results rather than returning the patients to anyone.
+func WithLoadTest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loadTestCtxKey{}, true)
+}
+
+// logAccess records that userID read patients. Reads fail when the entry
+// cannot be written, so patient data is never returned unaudited.
+func (r *Resolver) logAccess(ctx context.Context, userID, operation string, patients ...*store.Patient) error {
+	ids := make([]int, len(patients))
+	for i, patient := range patients {
+		ids[i] = patient.ID
+	}
+
+	return r.logAccessByID(ctx, userID, operation, ids...)
+}
+
+// logAccessByID is logAccess for records of the patients with ids, such as
+// their encounters.
+func (r *Resolver) logAccessByID(ctx context.Context, userID, operation string, ids ...int) error {
+	if loadTest, _ := ctx.Value(loadTestCtxKey{}).(bool); loadTest {
+		return nil
+	}
+
+	return dbError(ctx, r.auditLog.LogAccess(ctx, operation, userID, ids), "could not record %s of patients", operation)
+}
@@ -0,0 +1,269 @@
+package resolvers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// MaxBatchSize is the most patients a bulk mutation takes.
+const MaxBatchSize = 500
+
+// The modes of the bulk mutations.
+const (
+	// BatchAtomic writes every patient of a batch in one transaction, or
+	// none of them when one fails.
+	BatchAtomic = "atomic"
+	// BatchBestEffort writes each patient of a batch on its own, so the
+	// ones that fail leave the others written.
+	BatchBestEffort = "best_effort"
+)
+
+// PatientResult is the outcome of one patient of a bulk mutation, which has
+// either Patient or Error set.
+type PatientResult struct {
+	// Index is the position of the patient in the input list.
+	Index   int            `json:"index"`
+	Patient *store.Patient `json:"patient"`
+	Error   *ItemError     `json:"error"`
+	// replayed is set when Patient was created by an earlier request with
+	// the same idempotency key, so it is not published again.
+	replayed bool
+}
+
+// ItemError is why one patient of a bulk mutation was not written, with the
+// code its mutation on its own would have failed with.
+type ItemError struct {
+	Code    string                  `json:"code"`
+	Message string                  `json:"message"`
+	Fields  []validation.FieldError `json:"fields"`
+}
+
+// errNotWritten is the error of the patients of an atomic batch that were
+// valid but rolled back, or never tried, because another one failed.
+var errNotWritten = &utils.CodedError{Code: "NOT_WRITTEN", Message: "not written, another patient of the atomic batch failed"}
+
+// itemError converts the error of a patient of a bulk mutation, which must
+// already be safe to show to clients, to an ItemError.
+func itemError(err error) *ItemError {
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		return &ItemError{Code: "BAD_USER_INPUT", Message: err.Error(), Fields: fieldErrs}
+	}
+
+	item := &ItemError{Code: "INTERNAL_SERVER_ERROR", Message: err.Error()}
+	if extended, ok := err.(interface{ Extensions() map[string]interface{} }); ok {
+		if code, ok := extended.Extensions()["code"].(string); ok {
+			item.Code = code
+		}
+	}
+
+	return item
+}
+
+// batchInputs returns the list argument name of a bulk mutation, refusing
+// lists longer than MaxBatchSize.
+func batchInputs(args map[string]interface{}, name string) ([]map[string]interface{}, error) {
+	list, _ := args[name].([]interface{})
+	if len(list) > MaxBatchSize {
+		return nil, &utils.CodedError{
+			Code:    "BAD_USER_INPUT",
+			Message: fmt.Sprintf("%s takes at most %d patients, got %d; split it into several calls", name, MaxBatchSize, len(list)),
+		}
+	}
+
+	inputs := make([]map[string]interface{}, len(list))
+	for i, item := range list {
+		inputs[i], _ = item.(map[string]interface{})
+	}
+
+	return inputs, nil
+}
+
+// runBatch writes the patients of results that have no error yet with
+// write, called with their index, in mode, and fills in their outcome. In
+// atomic mode nothing is written when a patient is already invalid, and
+// every patient but the failing one gets errNotWritten when a write fails.
+// The written patients are published on topic. It returns an error only
+// when the batch as a whole failed, such as on a failed commit.
+func (r *Resolver) runBatch(ctx context.Context, mode string, results []*PatientResult, topic, operation string,
+	write func(ctx context.Context, i int) (*store.Patient, error)) error {
+	if mode == BatchBestEffort {
+		for i, result := range results {
+			if result.Error != nil {
+				continue
+			}
+
+			patient, err := write(ctx, i)
+			if err != nil {
+				result.Error = itemError(dbError(ctx, err, "%s: item %d", operation, i))
+				continue
+			}
+
+			result.Patient = patient
+			if !result.replayed {
+				r.publish(topic, patient)
+			}
+		}
+
+		return nil
+	}
+
+	failed := -1
+	for i, result := range results {
+		if result.Error != nil {
+			failed = i
+			break
+		}
+	}
+
+	if failed < 0 {
+		err := r.tx.InTx(ctx, func(ctx context.Context) error {
+			for i, result := range results {
+				patient, err := write(ctx, i)
+				if err != nil {
+					failed = i
+					result.Error = itemError(dbError(ctx, err, "%s: item %d", operation, i))
+					return err
+				}
+
+				result.Patient = patient
+			}
+
+			return nil
+		})
+		if err != nil && failed < 0 {
+			return dbError(ctx, err, "%s", operation)
+		}
+	}
+
+	if failed >= 0 {
+		for _, result := range results {
+			result.Patient = nil
+			if result.Error == nil {
+				result.Error = itemError(errNotWritten)
+			}
+		}
+
+		return nil
+	}
+
+	for _, result := range results {
+		if !result.replayed {
+			r.publish(topic, result.Patient)
+		}
+	}
+
+	return nil
+}
+
+// CreatePatients creates many patients at once, for migration scripts, in
+// the mode argument. Every patient is validated before anything is written.
+// With an idempotency key, each patient is created once under the key and
+// its index, so a batch sent again returns the patients already created and
+// only tries the others.
+func (r *Resolver) CreatePatients(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := batchInputs(params.Args, "patients")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := idempotencyKey(params)
+	if err != nil {
+		return nil, err
+	}
+
+	patients := make([]store.PatientChanges, len(inputs))
+	results := make([]*PatientResult, len(inputs))
+	for i, args := range inputs {
+		results[i] = &PatientResult{Index: i}
+		if patients[i], err = patientInput(args, false); err != nil {
+			results[i].Error = itemError(err)
+		}
+	}
+
+	mode, _ := params.Args["mode"].(string)
+
+	err = r.runBatch(params.Context, mode, results, PatientCreated, "could not create patients",
+		func(ctx context.Context, i int) (*store.Patient, error) {
+			itemKey := key
+			if key != "" {
+				itemKey = fmt.Sprintf("%s#%d", key, i)
+			}
+
+			patient, replayed, err := r.idempotentPatient(ctx, userID, itemKey, params.Info.FieldName, inputs[i],
+				func(ctx context.Context) (*store.Patient, error) {
+					p := patients[i]
+					return r.patients.Create(ctx, userID, *p.Name, *p.Email, *p.Phone, newDemographics(p))
+				})
+			results[i].replayed = replayed
+			return patient, err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// UpdatePatients applies changes to many patients at once like
+// UpdatePatient, in the mode argument. Every change is validated before
+// anything is written.
+func (r *Resolver) UpdatePatients(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := batchInputs(params.Args, "patients")
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(inputs))
+	changes := make([]store.PatientChanges, len(inputs))
+	results := make([]*PatientResult, len(inputs))
+	for i, args := range inputs {
+		ids[i], _ = args["id"].(int)
+		results[i] = &PatientResult{Index: i}
+		if changes[i], err = patientInput(args, true); err != nil {
+			results[i].Error = itemError(err)
+			continue
+		}
+
+		if changes[i].Empty() {
+			results[i].Error = &ItemError{Code: "BAD_USER_INPUT", Message: "update needs at least one field to change"}
+			continue
+		}
+
+		version, _ := args["version"].(int)
+		changes[i].Version = &version
+	}
+
+	mode, _ := params.Args["mode"].(string)
+
+	err = r.runBatch(params.Context, mode, results, PatientUpdated, "could not update patients",
+		func(ctx context.Context, i int) (*store.Patient, error) {
+			patient, err := r.patients.Update(ctx, userID, ids[i], changes[i])
+			if isNotFound(err) {
+				return nil, utils.NotFound("patient %d not found", ids[i])
+			}
+			return patient, err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
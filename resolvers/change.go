@@ -0,0 +1,92 @@
+package resolvers
+
+import (
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+const (
+	DefaultChangesLimit = 100
+	MaxChangesLimit     = 1000
+)
+
+// PatientChangeConnection is one page of the change feed. EndCursor is the
+// cursor of its last change, or the since argument when it has none, to
+// read the next page from.
+type PatientChangeConnection struct {
+	Changes     []*store.PatientChangeEvent `json:"changes"`
+	EndCursor   string                      `json:"endCursor"`
+	HasNextPage bool                        `json:"hasNextPage"`
+}
+
+// PatientChanges pages through the change feed of the patients, for systems
+// keeping a copy of them in sync. The changes only carry the patients with a
+// data sharing consent in effect for ChangeFeedConsentScope, and the patients
+// returned are recorded in the audit log as read, once each.
+func (r *Resolver) PatientChanges(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, ReadRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	sinceArg, _ := params.Args["since"].(string)
+	since, err := store.ParseChangeCursor(sinceArg)
+	if err != nil {
+		return nil, &utils.CodedError{Code: "BAD_USER_INPUT", Message: "since must be the endCursor or a cursor of an earlier patientChanges page"}
+	}
+
+	first, _ := params.Args["first"].(int)
+	if first < 1 {
+		return nil, &utils.CodedError{Code: "BAD_USER_INPUT", Message: "first must be a positive number"}
+	}
+	first = min(first, MaxChangesLimit)
+
+	// One change more than asked for tells whether there is a next page.
+	changes, err := r.patients.Changes(params.Context, since, first+1)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not read the patient changes")
+	}
+
+	connection := &PatientChangeConnection{Changes: changes, EndCursor: sinceArg}
+	if len(changes) > first {
+		connection.Changes, connection.HasNextPage = changes[:first], true
+	}
+
+	var ids []int
+	for _, c := range connection.Changes {
+		if c.Patient != nil {
+			ids = append(ids, c.PatientID)
+		}
+	}
+
+	// The feed feeds systems outside the clinic, so only the patients who
+	// consented to sharing their data with them are returned.
+	consented, err := r.sharingConsented(params.Context, ChangeFeedConsentScope, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	read := map[int]bool{}
+	var patients []*store.Patient
+	for _, c := range connection.Changes {
+		if !consented[c.PatientID] {
+			c.Patient = nil
+		}
+		if c.Patient != nil && !read[c.PatientID] {
+			read[c.PatientID] = true
+			patients = append(patients, c.Patient)
+		}
+	}
+
+	if err := r.logAccess(params.Context, userID, "sync", patients...); err != nil {
+		return nil, err
+	}
+
+	if n := len(connection.Changes); n > 0 {
+		connection.EndCursor = connection.Changes[n-1].Cursor.String()
+	}
+
+	return connection, nil
+}
@@ -0,0 +1,73 @@
+package resolvers
+
+import (
+	"strings"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/tenant"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// GetClinics lists the clinics of the deployment, which only admins see.
+func (r *Resolver) GetClinics(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, middleware.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	clinics, err := r.clinics.List(params.Context)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not list clinics")
+	}
+
+	return clinics, nil
+}
+
+// CreateClinic adds a clinic. Its records are then created by requests
+// acting for it, with its id in the clinic_id claim or X-Clinic-ID header.
+func (r *Resolver) CreateClinic(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, middleware.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	clinic := &store.Clinic{}
+	clinic.Name, _ = params.Args["name"].(string)
+	clinic.Name = strings.TrimSpace(clinic.Name)
+
+	if message := validation.ValidateName(clinic.Name); message != "" {
+		return nil, validation.Errors{{Field: "name", Message: message}}
+	}
+
+	if err := r.clinics.Create(params.Context, clinic); err != nil {
+		return nil, dbError(params.Context, err, "could not create clinic")
+	}
+
+	return clinic, nil
+}
+
+// GetPatientsAcrossClinics is getPatients over the patients of every
+// clinic, for admins.
+func (r *Resolver) GetPatientsAcrossClinics(params graphql.ResolveParams) (interface{}, error) {
+	return r.acrossClinics(params, r.GetPatients)
+}
+
+// GetAuditEntriesAcrossClinics is getAuditEntries over the audit log of
+// every clinic, for admins.
+func (r *Resolver) GetAuditEntriesAcrossClinics(params graphql.ResolveParams) (interface{}, error) {
+	return r.acrossClinics(params, r.GetAuditEntries)
+}
+
+// acrossClinics runs resolve reading the records of every clinic, once the
+// caller is known to be an admin. Only the field itself reads across
+// clinics: the fields selected below it stay scoped to the clinic of the
+// request.
+func (r *Resolver) acrossClinics(params graphql.ResolveParams, resolve graphql.FieldResolveFn) (interface{}, error) {
+	if _, err := authorize(params.Context, middleware.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	params.Context = tenant.WithAllClinics(params.Context)
+	return resolve(params)
+}
@@ -0,0 +1,115 @@
+package resolvers
+
+import (
+	"fmt"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// The choices of resolveConflict for a field of a patient.
+const (
+	// ConflictLocal keeps the value of the update that conflicted.
+	ConflictLocal = "local"
+	// ConflictRemote keeps the value stored by the update that won.
+	ConflictRemote = "remote"
+	// ConflictCustom replaces both with a value of its own.
+	ConflictCustom = "custom"
+)
+
+// conflictFields are the patient fields resolveConflict chooses between.
+var conflictFields = []string{"name", "email", "phone"}
+
+// ResolveConflict settles a VERSION_CONFLICT of update: it writes the value
+// resolution chooses for each field to the patient at its latest version, and
+// returns the patient unchanged when every field keeps its remote value.
+func (r *Resolver) ResolveConflict(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["patientId"].(int)
+	resolution, _ := params.Args["resolution"].(map[string]interface{})
+
+	current, err := r.patients.Get(params.Context, id, false)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not get patient %d", id)
+	}
+
+	changes, err := resolveFields(resolution, current)
+	if err != nil {
+		return nil, err
+	}
+
+	if changes.Empty() {
+		return current, nil
+	}
+
+	if err := validation.Patient(changes.Name, changes.Email, changes.Phone); err != nil {
+		return nil, err
+	}
+
+	changes.Version = &current.Version
+
+	patient, err := r.patients.Update(params.Context, userID, id, changes)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not update patient %d", id)
+	}
+
+	r.publish(PatientUpdated, patient)
+
+	return patient, nil
+}
+
+// resolveFields returns the changes that turn current, the remote version of
+// a patient, into the one resolution chooses. Fields it leaves out keep
+// their remote value. A field choosing a value it does not give fails with
+// validation.Errors.
+func resolveFields(resolution map[string]interface{}, current *store.Patient) (store.PatientChanges, error) {
+	local, _ := resolution["local"].(map[string]interface{})
+	remote := map[string]string{"name": current.Name, "email": current.Email, "phone": current.Phone}
+
+	chosen := make(map[string]*string)
+	var errs validation.Errors
+	for _, field := range conflictFields {
+		choice, _ := resolution[field].(map[string]interface{})
+		if choice == nil {
+			continue
+		}
+
+		var value interface{}
+		switch choice["choice"] {
+		case ConflictLocal:
+			value = local[field]
+		case ConflictCustom:
+			value = choice["custom"]
+		default:
+			continue
+		}
+
+		s, ok := value.(string)
+		if !ok {
+			errs = append(errs, validation.FieldError{Field: field, Message: fmt.Sprintf("%s needs a %s value to resolve to", field, choice["choice"])})
+			continue
+		}
+		if s != remote[field] {
+			chosen[field] = &s
+		}
+	}
+
+	if errs != nil {
+		return store.PatientChanges{}, errs
+	}
+
+	return store.PatientChanges{Name: chosen["name"], Email: chosen["email"], Phone: chosen["phone"]}, nil
+}
@@ -0,0 +1,135 @@
+package resolvers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+)
+
+type conflictPatient struct {
+	Name    string
+	Email   string
+	Phone   string
+	Version int
+}
+
+// conflict creates Ann Lee and makes two updates to her first version: the
+// remote one, which wins, and the local one, which fails with a
+// VERSION_CONFLICT. It returns her id.
+func conflict(t *testing.T, env *testEnv) int {
+	t.Helper()
+
+	ctx := clinician(t)
+	id := env.createPatient(t, ctx, "Ann Lee", "ann@example.com", "+14155550100")
+
+	env.mustDo(t, ctx, `mutation($id: Int!) {
+		update(id: $id, version: 1, name: "Ann Park", email: "ann.park@example.com", phone: "+14155550101") { id }
+	}`, map[string]interface{}{"id": id}, nil)
+
+	result := env.do(ctx, `mutation($id: Int!) {
+		update(id: $id, version: 1, name: "Ann Lee-Park", email: "ann.lee@example.com", phone: "+14155550102") { id }
+	}`, map[string]interface{}{"id": id})
+	if code := errorCode(t, result); code != "VERSION_CONFLICT" {
+		t.Fatalf("out-of-order update: code = %q, want VERSION_CONFLICT: %v", code, result.Errors)
+	}
+
+	return id
+}
+
+const conflictLocal = `local: {name: "Ann Lee-Park", email: "ann.lee@example.com", phone: "+14155550102"}`
+
+func TestResolveConflict(t *testing.T) {
+	remote := conflictPatient{"Ann Park", "ann.park@example.com", "+14155550101", 2}
+
+	tests := []struct {
+		name       string
+		resolution string
+		want       conflictPatient
+	}{
+		{
+			"remote for all fields",
+			conflictLocal + `, name: {choice: REMOTE}, email: {choice: REMOTE}, phone: {choice: REMOTE}`,
+			remote,
+		},
+		{"no choices", conflictLocal, remote},
+		{
+			"local for all fields",
+			conflictLocal + `, name: {choice: LOCAL}, email: {choice: LOCAL}, phone: {choice: LOCAL}`,
+			conflictPatient{"Ann Lee-Park", "ann.lee@example.com", "+14155550102", 3},
+		},
+		{
+			"mixed",
+			conflictLocal + `, name: {choice: CUSTOM, custom: "Ann L. Park"}, phone: {choice: LOCAL}`,
+			conflictPatient{"Ann L. Park", "ann.park@example.com", "+14155550102", 3},
+		},
+		{
+			"custom without local values",
+			`email: {choice: CUSTOM, custom: "ann@test.org"}`,
+			conflictPatient{"Ann Park", "ann@test.org", "+14155550101", 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			id := conflict(t, env)
+			ctx := clinician(t)
+
+			var resolved struct{ ResolveConflict conflictPatient }
+			env.mustDo(t, ctx, `mutation($id: Int!) {
+				resolveConflict(patientId: $id, resolution: {`+tt.resolution+`}) { name email phone version }
+			}`, map[string]interface{}{"id": id}, &resolved)
+			if resolved.ResolveConflict != tt.want {
+				t.Errorf("resolveConflict = %+v, want %+v", resolved.ResolveConflict, tt.want)
+			}
+
+			var stored struct{ GetPatient conflictPatient }
+			env.mustDo(t, ctx, `query($id: Int!) { getPatient(id: $id) { name email phone version } }`,
+				map[string]interface{}{"id": id}, &stored)
+			if stored.GetPatient != tt.want {
+				t.Errorf("stored patient = %+v, want %+v", stored.GetPatient, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveConflictErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       string
+		patientID  int
+		resolution string
+		code       string
+		err        string
+	}{
+		{"unknown patient", middleware.RoleClinician, 999, `name: {choice: REMOTE}`, "NOT_FOUND", ""},
+		{"local without a local value", middleware.RoleClinician, 0, `name: {choice: LOCAL}`, "BAD_USER_INPUT", "name needs a local value"},
+		{"custom without a value", middleware.RoleClinician, 0, `phone: {choice: CUSTOM}`, "BAD_USER_INPUT", "phone needs a custom value"},
+		{"invalid custom value", middleware.RoleClinician, 0, `email: {choice: CUSTOM, custom: "not an email"}`, "BAD_USER_INPUT", ""},
+		{"readonly", middleware.RoleReadonly, 0, conflictLocal + `, name: {choice: LOCAL}`, "FORBIDDEN", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			id := conflict(t, env)
+			if tt.patientID != 0 {
+				id = tt.patientID
+			}
+
+			result := env.do(asUser(t, "user-1", tt.role), `mutation($id: Int!) {
+				resolveConflict(patientId: $id, resolution: {`+tt.resolution+`}) { id }
+			}`, map[string]interface{}{"id": id})
+			if !result.HasErrors() {
+				t.Fatal("no error")
+			}
+			if code := errorCode(t, result); tt.code != "" && code != tt.code {
+				t.Errorf("code = %q, want %q: %v", code, tt.code, result.Errors)
+			}
+			if !strings.Contains(result.Errors[0].Message, tt.err) {
+				t.Errorf("error = %q, want one containing %q", result.Errors[0].Message, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package resolvers
+
+import (
+	"context"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// ChangeFeedConsentScope is the scope of the data sharing consents letting
+// patientChanges return the patient with the changes.
+const ChangeFeedConsentScope = "change_feed"
+
+// ConsentStatus tells which types of consent of a patient are in effect for
+// a scope.
+type ConsentStatus struct {
+	Treatment   bool `json:"treatment"`
+	DataSharing bool `json:"dataSharing"`
+	Marketing   bool `json:"marketing"`
+}
+
+// PatientConsents resolves the consents field of a patient.
+func (r *Resolver) PatientConsents(params graphql.ResolveParams) (interface{}, error) {
+	patient, ok := params.Source.(*store.Patient)
+	if !ok {
+		return nil, nil
+	}
+
+	thunk := load(params.Context, consentLoaderKey{}, r.consents.ListByPatients, patient.ID)
+
+	return func() (interface{}, error) {
+		consents, err := thunk()
+		if err != nil {
+			return nil, dbError(params.Context, err, "could not list consents of patient %d", patient.ID)
+		}
+
+		return consents, nil
+	}, nil
+}
+
+// PatientConsentStatus resolves the consentStatus field of a patient, for the
+// scope argument.
+func (r *Resolver) PatientConsentStatus(params graphql.ResolveParams) (interface{}, error) {
+	patient, ok := params.Source.(*store.Patient)
+	if !ok {
+		return nil, nil
+	}
+
+	scope, _ := params.Args["scope"].(string)
+	thunk := load(params.Context, consentLoaderKey{}, r.consents.ListByPatients, patient.ID)
+
+	return func() (interface{}, error) {
+		consents, err := thunk()
+		if err != nil {
+			return nil, dbError(params.Context, err, "could not list consents of patient %d", patient.ID)
+		}
+
+		status := &ConsentStatus{}
+		for _, c := range consents {
+			status.Treatment = status.Treatment || c.Covers(store.ConsentTreatment, scope)
+			status.DataSharing = status.DataSharing || c.Covers(store.ConsentDataSharing, scope)
+			status.Marketing = status.Marketing || c.Covers(store.ConsentMarketing, scope)
+		}
+
+		return status, nil
+	}, nil
+}
+
+// GrantConsent records a consent given by a patient.
+func (r *Resolver) GrantConsent(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	consentArgs, _ := params.Args["consent"].(map[string]interface{})
+
+	consent := &store.Consent{}
+	consent.PatientID, _ = params.Args["patientId"].(int)
+	consent.Type, _ = consentArgs["type"].(string)
+	consent.Scope, _ = consentArgs["scope"].(string)
+
+	var grantedAt *string
+	if granted, ok := consentArgs["grantedAt"].(string); ok {
+		grantedAt = &granted
+	}
+
+	if err := validation.Consent(&consent.Scope, grantedAt); err != nil {
+		return nil, err
+	}
+
+	consent.GrantedAt = time.Now().UTC()
+	if grantedAt != nil {
+		consent.GrantedAt, _ = time.Parse(time.RFC3339, *grantedAt)
+	}
+
+	err = r.consents.Grant(params.Context, userID, consent)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", consent.PatientID)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not grant consent")
+	}
+
+	return consent, nil
+}
+
+// RevokeConsent ends a consent, which stays listed as revoked.
+func (r *Resolver) RevokeConsent(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	consent, err := r.consents.Revoke(params.Context, userID, id)
+	if isNotFound(err) {
+		return nil, utils.NotFound("consent %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not revoke consent %d", id)
+	}
+
+	return consent, nil
+}
+
+// sharingConsented returns the ids of the patients among patientIDs with a
+// data sharing consent in effect for scope.
+func (r *Resolver) sharingConsented(ctx context.Context, scope string, patientIDs []int) (map[int]bool, error) {
+	consented := map[int]bool{}
+	if len(patientIDs) == 0 {
+		return consented, nil
+	}
+
+	byPatient, err := r.consents.ListByPatients(ctx, patientIDs)
+	if err != nil {
+		return nil, dbError(ctx, err, "could not check the consents of the patients")
+	}
+
+	for id, consents := range byPatient {
+		for _, c := range consents {
+			if c.Covers(store.ConsentDataSharing, scope) {
+				consented[id] = true
+				break
+			}
+		}
+	}
+
+	return consented, nil
+}
@@ -0,0 +1,27 @@
+package resolvers
+
+import (
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// PatientEmergencyContacts resolves the emergencyContacts field of a
+// patient.
+func (r *Resolver) PatientEmergencyContacts(params graphql.ResolveParams) (interface{}, error) {
+	patient, ok := params.Source.(*store.Patient)
+	if !ok {
+		return nil, nil
+	}
+
+	thunk := load(params.Context, contactLoaderKey{}, r.contacts.ListByPatients, patient.ID)
+
+	return func() (interface{}, error) {
+		contacts, err := thunk()
+		if err != nil {
+			return nil, dbError(params.Context, err, "could not list emergency contacts of patient %d", patient.ID)
+		}
+
+		return contacts, nil
+	}, nil
+}
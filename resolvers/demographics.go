@@ -0,0 +1,290 @@
+package resolvers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/hl7"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// patientInput reads and checks the patient arguments of a create or, when
+// partial is set, of a partial update. A create may leave out the name when
+// it gives structuredName, whose parts then make the name.
+func patientInput(args map[string]interface{}, partial bool) (store.PatientChanges, error) {
+	changes := patientChanges(args)
+
+	var birthDate *string
+	if value, ok := args["birthDate"].(string); ok {
+		birthDate = &value
+	}
+
+	demographicsErr := ValidateDemographics(&changes, birthDate)
+
+	if !partial {
+		for _, field := range []**string{&changes.Name, &changes.Email, &changes.Phone} {
+			if *field == nil {
+				empty := ""
+				*field = &empty
+			}
+		}
+
+		if *changes.Name == "" && changes.StructuredName != nil {
+			name := changes.StructuredName.String()
+			changes.Name = &name
+		}
+	}
+
+	var errs validation.Errors
+	for _, err := range []error{validation.Patient(changes.Name, changes.Email, changes.Phone), demographicsErr} {
+		if err != nil {
+			errs = append(errs, err.(validation.Errors)...)
+		}
+	}
+	if len(errs) > 0 {
+		return changes, errs
+	}
+
+	return changes, nil
+}
+
+// HL7Patient normalizes the patient read from an HL7 message in place and
+// returns its demographics, or validation.Errors describing every invalid
+// field like patientInput does.
+func HL7Patient(p *hl7.Patient) (store.Demographics, error) {
+	changes := store.PatientChanges{Gender: &p.Gender}
+	if len(p.Addresses) > 0 {
+		changes.Addresses = &p.Addresses
+	}
+	if len(p.Identifiers) > 0 {
+		changes.Identifiers = &p.Identifiers
+	}
+
+	var birthDate *string
+	if p.BirthDate != "" {
+		birthDate = &p.BirthDate
+	}
+
+	var errs validation.Errors
+	for _, err := range []error{validation.Patient(&p.Name, &p.Email, &p.Phone), ValidateDemographics(&changes, birthDate)} {
+		if err != nil {
+			errs = append(errs, err.(validation.Errors)...)
+		}
+	}
+	if len(errs) > 0 {
+		return store.Demographics{}, errs
+	}
+
+	return newDemographics(changes), nil
+}
+
+// newDemographics returns the demographics a create with changes gives the
+// patient.
+func newDemographics(changes store.PatientChanges) store.Demographics {
+	var demographics store.Demographics
+	changes.ApplyDemographics(&demographics)
+
+	return demographics
+}
+
+// ValidateDemographics normalizes the demographics changes sets in place and
+// returns validation.Errors describing every invalid one, or nil. birthDate,
+// nil when it is not changed, is parsed into changes and clears the birth
+// date when "". The codes are checked too: GraphQL enums already hold them
+// to the store lists but other APIs, such as FHIR, take them as strings.
+func ValidateDemographics(changes *store.PatientChanges, birthDate *string) error {
+	var errs validation.Errors
+	add := func(path string, err error) {
+		if err == nil {
+			return
+		}
+		if path == "" {
+			errs = append(errs, err.(validation.Errors)...)
+			return
+		}
+		errs = append(errs, err.(validation.Errors).Within(path)...)
+	}
+
+	if n := changes.StructuredName; n != nil {
+		add("structuredName", validation.HumanName(&n.Prefix, &n.Given, &n.Family, &n.Suffix))
+	}
+
+	if birthDate != nil {
+		date := time.Time{}
+		if *birthDate != "" {
+			err := validation.BirthDate(birthDate)
+			add("", err)
+			if err == nil {
+				date, _ = time.Parse(time.DateOnly, *birthDate)
+			}
+		}
+		changes.BirthDate = &date
+	}
+
+	if changes.Gender != nil && *changes.Gender != "" && !contains(store.Genders, *changes.Gender) {
+		add("", codeError("gender", *changes.Gender, store.Genders))
+	}
+
+	if changes.PreferredLanguage != nil {
+		*changes.PreferredLanguage = validation.NormalizeLanguage(*changes.PreferredLanguage)
+		if message := validation.ValidateLanguage(*changes.PreferredLanguage); message != "" {
+			errs = append(errs, validation.FieldError{Field: "preferredLanguage", Message: message})
+		}
+	}
+
+	if changes.Addresses != nil {
+		add("", maxEntries("addresses", len(*changes.Addresses)))
+		for i := range *changes.Addresses {
+			a := &(*changes.Addresses)[i]
+			path := fmt.Sprintf("addresses[%d]", i)
+
+			if a.Use != "" && !contains(store.AddressUses, a.Use) {
+				add(path, codeError("use", a.Use, store.AddressUses))
+			}
+			add(path, validation.Address(&a.Lines, &a.City, &a.State, &a.PostalCode, &a.Country))
+		}
+	}
+
+	if changes.Telecoms != nil {
+		add("", maxEntries("telecoms", len(*changes.Telecoms)))
+		for i := range *changes.Telecoms {
+			t := &(*changes.Telecoms)[i]
+			path := fmt.Sprintf("telecoms[%d]", i)
+
+			if !contains(store.TelecomSystems, t.System) {
+				add(path, codeError("system", t.System, store.TelecomSystems))
+				continue
+			}
+			if t.Use != "" && !contains(store.TelecomUses, t.Use) {
+				add(path, codeError("use", t.Use, store.TelecomUses))
+			}
+			add(path, validation.ContactPoint(t.System, &t.Value))
+		}
+	}
+
+	if changes.Identifiers != nil {
+		add("", maxEntries("identifiers", len(*changes.Identifiers)))
+		for i := range *changes.Identifiers {
+			id := &(*changes.Identifiers)[i]
+			path := fmt.Sprintf("identifiers[%d]", i)
+
+			if !contains(store.IdentifierTypes, id.Type) {
+				add(path, codeError("type", id.Type, store.IdentifierTypes))
+			}
+			add(path, validation.Identifier(&id.System, &id.Value))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func maxEntries(field string, n int) error {
+	if n <= validation.MaxDemographicsEntries {
+		return nil
+	}
+
+	return validation.Errors{{Field: field, Message: fmt.Sprintf("%s must hold at most %d entries", field, validation.MaxDemographicsEntries)}}
+}
+
+func codeError(field, code string, codes []string) error {
+	return validation.Errors{{Field: field, Message: fmt.Sprintf("%s %q must be one of %v", field, code, codes)}}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// demographicsChanges reads the demographic arguments, other than
+// birthDate, that were supplied to a create or a partial update into
+// changes. Lists replace the ones of the patient, so [] clears them.
+func demographicsChanges(args map[string]interface{}, changes *store.PatientChanges) {
+	if name, ok := args["structuredName"].(map[string]interface{}); ok {
+		var n store.HumanName
+		n.Prefix, _ = name["prefix"].(string)
+		n.Given = stringList(name["given"])
+		n.Family, _ = name["family"].(string)
+		n.Suffix, _ = name["suffix"].(string)
+		changes.StructuredName = &n
+	}
+
+	for _, f := range []struct {
+		name  string
+		field **string
+	}{
+		{"gender", &changes.Gender},
+		{"preferredLanguage", &changes.PreferredLanguage},
+	} {
+		if value, ok := args[f.name].(string); ok {
+			*f.field = &value
+		}
+	}
+
+	if list, ok := args["addresses"].([]interface{}); ok {
+		addresses := make([]store.Address, 0, len(list))
+		for _, item := range list {
+			values, _ := item.(map[string]interface{})
+
+			var a store.Address
+			a.Use, _ = values["use"].(string)
+			a.Lines = stringList(values["lines"])
+			a.City, _ = values["city"].(string)
+			a.State, _ = values["state"].(string)
+			a.PostalCode, _ = values["postalCode"].(string)
+			a.Country, _ = values["country"].(string)
+			addresses = append(addresses, a)
+		}
+		changes.Addresses = &addresses
+	}
+
+	if list, ok := args["telecoms"].([]interface{}); ok {
+		telecoms := make([]store.ContactPoint, 0, len(list))
+		for _, item := range list {
+			values, _ := item.(map[string]interface{})
+
+			var t store.ContactPoint
+			t.System, _ = values["system"].(string)
+			t.Value, _ = values["value"].(string)
+			t.Use, _ = values["use"].(string)
+			telecoms = append(telecoms, t)
+		}
+		changes.Telecoms = &telecoms
+	}
+
+	if list, ok := args["identifiers"].([]interface{}); ok {
+		identifiers := make([]store.Identifier, 0, len(list))
+		for _, item := range list {
+			values, _ := item.(map[string]interface{})
+
+			var id store.Identifier
+			id.Type, _ = values["type"].(string)
+			id.System, _ = values["system"].(string)
+			id.Value, _ = values["value"].(string)
+			identifiers = append(identifiers, id)
+		}
+		changes.Identifiers = &identifiers
+	}
+}
+
+func stringList(value interface{}) []string {
+	list, _ := value.([]interface{})
+
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			values = append(values, s)
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,66 @@
+package resolvers
+
+import (
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// PatientDocuments resolves the documents field of a patient.
+func (r *Resolver) PatientDocuments(params graphql.ResolveParams) (interface{}, error) {
+	patient, ok := params.Source.(*store.Patient)
+	if !ok {
+		return nil, nil
+	}
+
+	thunk := load(params.Context, documentLoaderKey{}, r.documents.ListByPatients, patient.ID)
+
+	return func() (interface{}, error) {
+		documents, err := thunk()
+		if err != nil {
+			return nil, dbError(params.Context, err, "could not list documents of patient %d", patient.ID)
+		}
+
+		return documents, nil
+	}, nil
+}
+
+// DocumentDownloadURL resolves the downloadUrl field of a document, a link
+// signed for the caller and the clinic of the document. It is null when
+// documents are not stored or the caller may not read patients.
+func (r *Resolver) DocumentDownloadURL(params graphql.ResolveParams) (interface{}, error) {
+	d, ok := params.Source.(*store.Document)
+	if !ok || r.signer == nil {
+		return nil, nil
+	}
+
+	userID, ok := middleware.UserIDFromContext(params.Context)
+	if !ok || !middleware.HasRole(params.Context, ReadRoles...) {
+		return nil, nil
+	}
+
+	return r.signer.URL(d.ID, userID, d.ClinicID), nil
+}
+
+// DeleteDocument removes a document. Its file is deleted from the storage
+// shortly after.
+func (r *Resolver) DeleteDocument(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	d, err := r.documents.Delete(params.Context, userID, id)
+	if isNotFound(err) {
+		return nil, utils.NotFound("document %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not delete document %d", id)
+	}
+
+	return d, nil
+}
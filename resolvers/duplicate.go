@@ -0,0 +1,128 @@
+package resolvers
+
+import (
+	"fmt"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// DefaultDuplicateSimilarity is how alike, from 0 to 1, the names of two
+// patients sharing an email or phone must be for findDuplicatePatients to
+// report them by default.
+const DefaultDuplicateSimilarity = 0.4
+
+func (r *Resolver) GetPendingDuplicateReports(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, middleware.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	reports, err := r.duplicates.ListPending(params.Context)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not list duplicate reports")
+	}
+
+	return reports, nil
+}
+
+func (r *Resolver) ReportDuplicate(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &store.DuplicateReport{Status: "pending", ReportedBy: &userID}
+	report.ReportedPatientID, _ = params.Args["patientId"].(int)
+	report.SuspectedDuplicateID, _ = params.Args["suspectedDuplicateId"].(int)
+
+	if report.ReportedPatientID == report.SuspectedDuplicateID {
+		return nil, fmt.Errorf("a patient cannot be a duplicate of itself")
+	}
+
+	if err := r.duplicates.Create(params.Context, report); err != nil {
+		return nil, dbError(params.Context, err, "could not create duplicate report")
+	}
+
+	return report, nil
+}
+
+func (r *Resolver) ReviewDuplicateReport(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, middleware.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+	status, _ := params.Args["status"].(string)
+
+	report, err := r.duplicates.SetStatus(params.Context, id, status)
+	if isNotFound(err) {
+		return nil, utils.NotFound("duplicate report %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not update duplicate report %d", id)
+	}
+
+	return report, nil
+}
+
+func (r *Resolver) FindDuplicatePatients(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, ReadRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, _, err := pageArgs(params.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	minSimilarity, _ := params.Args["minSimilarity"].(float64)
+	if minSimilarity < 0 || minSimilarity > 1 {
+		return nil, validation.Errors{{Field: "minSimilarity", Message: "minSimilarity must be between 0 and 1"}}
+	}
+
+	candidates, err := r.patients.FindDuplicates(params.Context, minSimilarity, limit)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not find duplicate patients")
+	}
+
+	var patients []*store.Patient
+	for _, candidate := range candidates {
+		patients = append(patients, candidate.Patient, candidate.Duplicate)
+	}
+
+	if err := r.logAccess(params.Context, userID, "search", patients...); err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+func (r *Resolver) MergePatients(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	primaryID, _ := params.Args["primaryId"].(int)
+	duplicateID, _ := params.Args["duplicateId"].(int)
+
+	primary, err := r.patients.Merge(params.Context, userID, primaryID, duplicateID)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patients %d and %d must both exist and not be deleted", primaryID, duplicateID)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not merge patient %d into %d", duplicateID, primaryID)
+	}
+
+	r.publish(PatientUpdated, primary)
+	if duplicate, err := r.patients.Get(params.Context, duplicateID, true); err == nil {
+		r.publish(PatientDeleted, duplicate)
+	}
+
+	return primary, nil
+}
@@ -0,0 +1,79 @@
+package resolvers_test
+
+import (
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+)
+
+type duplicateReport struct {
+	ID                   int
+	ReportedPatientID    int
+	SuspectedDuplicateID int
+	Status               string
+}
+
+func TestDuplicateReports(t *testing.T) {
+	env := newTestEnv(t)
+	first := env.createPatient(t, clinician(t), "John Doe", "john@example.com", "+14155550101")
+	second := env.createPatient(t, clinician(t), "Jon Doe", "jon@example.com", "+14155550102")
+
+	var reported struct{ ReportDuplicate duplicateReport }
+	env.mustDo(t, clinician(t), `mutation($a: Int!, $b: Int!) {
+		reportDuplicate(patientId: $a, suspectedDuplicateId: $b) { id reportedPatientId suspectedDuplicateId status }
+	}`, map[string]interface{}{"a": first, "b": second}, &reported)
+
+	if r := reported.ReportDuplicate; r.ReportedPatientID != first || r.SuspectedDuplicateID != second || r.Status != "PENDING" {
+		t.Fatalf("reportDuplicate = %+v", r)
+	}
+
+	pending := func() []duplicateReport {
+		var data struct{ GetPendingDuplicateReports []duplicateReport }
+		env.mustDo(t, admin(t), `{ getPendingDuplicateReports { id status } }`, nil, &data)
+		return data.GetPendingDuplicateReports
+	}
+
+	if reports := pending(); len(reports) != 1 || reports[0].ID != reported.ReportDuplicate.ID {
+		t.Fatalf("getPendingDuplicateReports = %+v, want the report", reports)
+	}
+
+	var dismissed struct{ ReviewDuplicateReport duplicateReport }
+	env.mustDo(t, admin(t), `mutation($id: Int!) { reviewDuplicateReport(id: $id, status: DISMISSED) { id status } }`,
+		map[string]interface{}{"id": reported.ReportDuplicate.ID}, &dismissed)
+	if dismissed.ReviewDuplicateReport.Status != "DISMISSED" {
+		t.Fatalf("reviewDuplicateReport = %+v", dismissed.ReviewDuplicateReport)
+	}
+
+	if reports := pending(); len(reports) != 0 {
+		t.Fatalf("getPendingDuplicateReports = %+v after dismissing, want none", reports)
+	}
+}
+
+func TestDuplicateReportsAdminOnly(t *testing.T) {
+	env := newTestEnv(t)
+	first := env.createPatient(t, clinician(t), "John Doe", "john@example.com", "+14155550101")
+	second := env.createPatient(t, clinician(t), "Jon Doe", "jon@example.com", "+14155550102")
+
+	var reported struct{ ReportDuplicate duplicateReport }
+	env.mustDo(t, clinician(t), `mutation($a: Int!, $b: Int!) { reportDuplicate(patientId: $a, suspectedDuplicateId: $b) { id } }`,
+		map[string]interface{}{"a": first, "b": second}, &reported)
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"list pending", `{ getPendingDuplicateReports { id } }`},
+		{"review", `mutation($id: Int!) { reviewDuplicateReport(id: $id, status: REVIEWED) { id } }`},
+	}
+
+	for _, tt := range tests {
+		for _, role := range []string{middleware.RoleClinician, middleware.RoleReadonly} {
+			t.Run(tt.name+" as "+role, func(t *testing.T) {
+				result := env.do(asUser(t, "user-1", role), tt.query, map[string]interface{}{"id": reported.ReportDuplicate.ID})
+				if code := errorCode(t, result); code != "FORBIDDEN" {
+					t.Fatalf("error code = %q, want FORBIDDEN: %v", code, result.Errors)
+				}
+			})
+		}
+	}
+}
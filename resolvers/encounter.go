@@ -0,0 +1,199 @@
+package resolvers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// EncounterConnection is one page of encounters along with the information
+// needed to request the next one.
+type EncounterConnection struct {
+	Entries     []*store.Encounter `json:"entries"`
+	TotalCount  int                `json:"totalCount"`
+	HasNextPage bool               `json:"hasNextPage"`
+}
+
+// GetEncounter returns an encounter, recording the read in the audit log of
+// its patient.
+func (r *Resolver) GetEncounter(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, ReadRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	encounter, err := r.encounters.Get(params.Context, id)
+	if isNotFound(err) {
+		return nil, utils.NotFound("encounter %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not get encounter %d", id)
+	}
+
+	if err := r.logAccessByID(params.Context, userID, "read", encounter.PatientID); err != nil {
+		return nil, err
+	}
+
+	return encounter, nil
+}
+
+// PatientEncounters resolves the encounters field of a patient, a page of
+// its encounters, newest first.
+func (r *Resolver) PatientEncounters(params graphql.ResolveParams) (interface{}, error) {
+	patient, ok := params.Source.(*store.Patient)
+	if !ok {
+		return nil, nil
+	}
+
+	limit, offset, err := pageArgs(params.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	thunk := loadValue(params.Context, encounterLoaderKey{}, r.encounterPages, encounterPageKey{patient.ID, limit, offset})
+
+	return func() (interface{}, error) {
+		page, err := thunk()
+		if err != nil {
+			return nil, dbError(params.Context, err, "could not list encounters of patient %d", patient.ID)
+		}
+		if page == nil {
+			page = &store.EncounterPage{Encounters: []*store.Encounter{}}
+		}
+
+		return &EncounterConnection{
+			Entries:     page.Encounters,
+			TotalCount:  page.TotalCount,
+			HasNextPage: offset+len(page.Encounters) < page.TotalCount,
+		}, nil
+	}, nil
+}
+
+// EncounterRevisions resolves the revisions field of an encounter.
+func (r *Resolver) EncounterRevisions(params graphql.ResolveParams) (interface{}, error) {
+	encounter, ok := params.Source.(*store.Encounter)
+	if !ok {
+		return nil, nil
+	}
+
+	thunk := load(params.Context, revisionLoaderKey{}, r.encounters.Revisions, encounter.ID)
+
+	return func() (interface{}, error) {
+		revisions, err := thunk()
+		if err != nil {
+			return nil, dbError(params.Context, err, "could not list revisions of encounter %d", encounter.ID)
+		}
+
+		return revisions, nil
+	}, nil
+}
+
+// CreateEncounter records an encounter of a patient as its first revision.
+func (r *Resolver) CreateEncounter(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	encounter := &store.Encounter{}
+	encounter.PatientID, _ = params.Args["patientId"].(int)
+	encounter.ChiefComplaint, _ = params.Args["chiefComplaint"].(string)
+	encounter.Notes, _ = params.Args["notes"].(string)
+	encounter.DiagnosisCodes = stringsArg(params.Args, "diagnosisCodes")
+	if providerID, ok := params.Args["providerId"].(int); ok {
+		encounter.ProviderID = &providerID
+	}
+
+	encounteredAt, err := timeArg(params.Args, "encounteredAt")
+	if err != nil {
+		return nil, err
+	}
+	encounter.EncounteredAt = *encounteredAt
+
+	if err := validation.Encounter(&encounter.ChiefComplaint, &encounter.DiagnosisCodes); err != nil {
+		return nil, err
+	}
+
+	err = r.encounters.Create(params.Context, userID, encounter)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", encounter.PatientID)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not create encounter")
+	}
+
+	return encounter, nil
+}
+
+// AmendEncounter records a new revision of an encounter. The fields left out
+// keep their current value and a reason is required.
+func (r *Resolver) AmendEncounter(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	var amendment store.EncounterAmendment
+	if chiefComplaint, ok := params.Args["chiefComplaint"].(string); ok {
+		amendment.ChiefComplaint = &chiefComplaint
+	}
+	if notes, ok := params.Args["notes"].(string); ok {
+		amendment.Notes = &notes
+	}
+	if _, ok := params.Args["diagnosisCodes"]; ok {
+		codes := stringsArg(params.Args, "diagnosisCodes")
+		amendment.DiagnosisCodes = &codes
+	}
+	reason, _ := params.Args["reason"].(string)
+	amendment.Reason = strings.TrimSpace(reason)
+
+	if amendment.ChiefComplaint == nil && amendment.Notes == nil && amendment.DiagnosisCodes == nil {
+		return nil, fmt.Errorf("an amendment must change chiefComplaint, notes or diagnosisCodes")
+	}
+
+	err = validation.Encounter(amendment.ChiefComplaint, amendment.DiagnosisCodes)
+	if amendment.Reason == "" {
+		errs, _ := err.(validation.Errors)
+		err = append(errs, validation.FieldError{Field: "reason", Message: "reason must not be empty"})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	encounter, err := r.encounters.Amend(params.Context, userID, id, amendment)
+	if isNotFound(err) {
+		return nil, utils.NotFound("encounter %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not amend encounter %d", id)
+	}
+
+	return encounter, nil
+}
+
+// stringsArg returns the list of strings argument name, or nil when it was
+// not given.
+func stringsArg(args map[string]interface{}, name string) []string {
+	values, _ := args[name].([]interface{})
+	if values == nil {
+		return nil
+	}
+
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+
+	return strs
+}
@@ -0,0 +1,150 @@
+package resolvers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// MaxIdempotencyKeyLength bounds the length of idempotency keys.
+const MaxIdempotencyKeyLength = 255
+
+// errIdempotencyKeyReused is returned when a key is sent again with other
+// arguments, or to another mutation, than it was first sent with.
+var errIdempotencyKeyReused = &utils.CodedError{
+	Code:    "IDEMPOTENCY_KEY_REUSED",
+	Message: "the idempotency key was already sent with other arguments; use a new key for a new request",
+}
+
+type idempotencyKeyCtxKey struct{}
+
+// WithIdempotencyKey returns ctx carrying key, the Idempotency-Key header of
+// the request, which the create mutations use when they are not given an
+// idempotencyKey argument.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, idempotencyKeyCtxKey{}, key)
+}
+
+// idempotencyKey returns the idempotencyKey argument of params, or the key
+// of the request, and an empty key when there is neither.
+func idempotencyKey(params graphql.ResolveParams) (string, error) {
+	key, ok := params.Args["idempotencyKey"].(string)
+	if !ok {
+		key, _ = params.Context.Value(idempotencyKeyCtxKey{}).(string)
+	}
+
+	if len(key) > MaxIdempotencyKeyLength {
+		return "", &utils.CodedError{
+			Code:    "BAD_USER_INPUT",
+			Message: fmt.Sprintf("the idempotency key must be at most %d characters", MaxIdempotencyKeyLength),
+		}
+	}
+
+	return key, nil
+}
+
+// requestHash returns the SHA-256 of args, but for the idempotency key
+// itself. The keys of maps are encoded sorted, so equal arguments hash the
+// same.
+func requestHash(args map[string]interface{}) (string, error) {
+	hashed := make(map[string]interface{}, len(args))
+	for name, value := range args {
+		if name != "idempotencyKey" {
+			hashed[name] = value
+		}
+	}
+
+	b, err := json.Marshal(hashed)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// idempotent runs create, which writes the records of operation, called
+// with args, and sets ref to what identifies them, such as their ids. With a
+// key, create runs in a transaction with the claim of the key, and ref is
+// stored as its response: a later request with the same key gets ref set
+// from the response instead, without create running, and replayed is true.
+// A request failing stores nothing, so it can be sent again with its key.
+// Without a key, create just runs.
+func (r *Resolver) idempotent(ctx context.Context, userID, key, operation string, args map[string]interface{},
+	ref interface{}, create func(ctx context.Context) error) (replayed bool, err error) {
+	if key == "" {
+		return false, create(ctx)
+	}
+
+	hash, err := requestHash(args)
+	if err != nil {
+		return false, err
+	}
+
+	err = r.tx.InTx(ctx, func(ctx context.Context) error {
+		stored, err := r.idempotencyKeys.Claim(ctx, userID, key, operation, hash, time.Now().Add(r.idempotencyTTL))
+		if err != nil {
+			return err
+		}
+
+		if stored != nil {
+			if stored.Operation != operation || stored.RequestHash != hash {
+				return errIdempotencyKeyReused
+			}
+
+			replayed = true
+			return json.Unmarshal(stored.Response, ref)
+		}
+
+		if err := create(ctx); err != nil {
+			return err
+		}
+
+		response, err := json.Marshal(ref)
+		if err != nil {
+			return err
+		}
+
+		return r.idempotencyKeys.Complete(ctx, userID, key, response)
+	})
+
+	return replayed, err
+}
+
+// idempotentPatient creates a patient with create like idempotent, and
+// returns the patient the first request created on a replay.
+func (r *Resolver) idempotentPatient(ctx context.Context, userID, key, operation string, args map[string]interface{},
+	create func(ctx context.Context) (*store.Patient, error)) (patient *store.Patient, replayed bool, err error) {
+	var id int
+	replayed, err = r.idempotent(ctx, userID, key, operation, args, &id, func(ctx context.Context) error {
+		var err error
+		if patient, err = create(ctx); err != nil {
+			return err
+		}
+
+		id = patient.ID
+		return nil
+	})
+	if err != nil || !replayed {
+		return patient, replayed, err
+	}
+
+	patient, err = r.patients.Get(ctx, id, false)
+	if isNotFound(err) {
+		return nil, true, utils.NotFound("patient %d not found", id)
+	}
+
+	return patient, true, err
+}
@@ -0,0 +1,129 @@
+package resolvers
+
+import (
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// PatientInsurancePolicies resolves the insurancePolicies field of a
+// patient.
+func (r *Resolver) PatientInsurancePolicies(params graphql.ResolveParams) (interface{}, error) {
+	patient, ok := params.Source.(*store.Patient)
+	if !ok {
+		return nil, nil
+	}
+
+	thunk := load(params.Context, policyLoaderKey{}, r.policies.ListByPatients, patient.ID)
+
+	return func() (interface{}, error) {
+		policies, err := thunk()
+		if err != nil {
+			return nil, dbError(params.Context, err, "could not list insurance policies of patient %d", patient.ID)
+		}
+
+		return policies, nil
+	}, nil
+}
+
+// AddInsurancePolicy adds a policy to the coverage of a patient.
+func (r *Resolver) AddInsurancePolicy(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := policyFromArgs(params.Args)
+	if err != nil {
+		return nil, err
+	}
+	policy.PatientID, _ = params.Args["patientId"].(int)
+
+	err = r.policies.Add(params.Context, userID, policy)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", policy.PatientID)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not add insurance policy")
+	}
+
+	return policy, nil
+}
+
+// UpdateInsurancePolicy replaces the fields of an active policy.
+func (r *Resolver) UpdateInsurancePolicy(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := policyFromArgs(params.Args)
+	if err != nil {
+		return nil, err
+	}
+	policy.ID, _ = params.Args["id"].(int)
+
+	updated, err := r.policies.Update(params.Context, userID, policy)
+	if isNotFound(err) {
+		return nil, utils.NotFound("insurance policy %d not found", policy.ID)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not update insurance policy %d", policy.ID)
+	}
+
+	return updated, nil
+}
+
+// DeactivateInsurancePolicy ends a policy, which stays listed as inactive.
+func (r *Resolver) DeactivateInsurancePolicy(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	policy, err := r.policies.Deactivate(params.Context, userID, id)
+	if isNotFound(err) {
+		return nil, utils.NotFound("insurance policy %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not deactivate insurance policy %d", id)
+	}
+
+	return policy, nil
+}
+
+// policyFromArgs reads and validates the policy argument, an
+// InsurancePolicyInput.
+func policyFromArgs(args map[string]interface{}) (*store.InsurancePolicy, error) {
+	policyArgs, _ := args["policy"].(map[string]interface{})
+
+	policy := &store.InsurancePolicy{}
+	policy.Payer, _ = policyArgs["payer"].(string)
+	policy.MemberID, _ = policyArgs["memberId"].(string)
+	policy.GroupNumber, _ = policyArgs["groupNumber"].(string)
+	policy.Priority, _ = policyArgs["priority"].(string)
+
+	effectiveDate, _ := policyArgs["effectiveDate"].(string)
+	var expiryDate *string
+	if expiry, ok := policyArgs["expiryDate"].(string); ok {
+		expiryDate = &expiry
+	}
+
+	if err := validation.InsurancePolicy(&policy.Payer, &policy.MemberID, &policy.GroupNumber, &effectiveDate, expiryDate); err != nil {
+		return nil, err
+	}
+
+	policy.EffectiveDate, _ = time.Parse(time.DateOnly, effectiveDate)
+	if expiryDate != nil {
+		expiry, _ := time.Parse(time.DateOnly, *expiryDate)
+		policy.ExpiryDate = &expiry
+	}
+
+	return policy, nil
+}
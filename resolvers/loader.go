@@ -0,0 +1,153 @@
+package resolvers
+
+import (
+	"context"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/loader"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// loaderWait is how long the loaders wait for more keys before querying.
+const loaderWait = 2 * time.Millisecond
+
+// Context keys of the loaders, which batch the lookups of a nested field for
+// every parent in a response into one query.
+type (
+	patientLoaderKey     struct{}
+	appointmentLoaderKey struct{}
+	careTeamLoaderKey    struct{}
+	panelLoaderKey       struct{}
+	encounterLoaderKey   struct{}
+	revisionLoaderKey    struct{}
+	contactLoaderKey     struct{}
+	noteLoaderKey        struct{}
+	documentLoaderKey    struct{}
+	policyLoaderKey      struct{}
+	consentLoaderKey     struct{}
+)
+
+// WithLoaders returns a copy of ctx carrying fresh loaders for one request.
+func (r *Resolver) WithLoaders(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, patientLoaderKey{}, loader.New(r.patients.GetMany, loaderWait))
+	ctx = context.WithValue(ctx, appointmentLoaderKey{}, loader.New(r.appointments.ListByPatients, loaderWait))
+	ctx = context.WithValue(ctx, careTeamLoaderKey{}, loader.New(r.providers.CareTeams, loaderWait))
+	ctx = context.WithValue(ctx, panelLoaderKey{}, loader.New(r.providers.Panels, loaderWait))
+	ctx = context.WithValue(ctx, encounterLoaderKey{}, loader.New(r.encounterPages, loaderWait))
+	ctx = context.WithValue(ctx, revisionLoaderKey{}, loader.New(r.encounters.Revisions, loaderWait))
+	ctx = context.WithValue(ctx, contactLoaderKey{}, loader.New(r.contacts.ListByPatients, loaderWait))
+	ctx = context.WithValue(ctx, noteLoaderKey{}, loader.New(r.notes.ListByPatients, loaderWait))
+	ctx = context.WithValue(ctx, documentLoaderKey{}, loader.New(r.documents.ListByPatients, loaderWait))
+	ctx = context.WithValue(ctx, policyLoaderKey{}, loader.New(r.policies.ListByPatients, loaderWait))
+	ctx = context.WithValue(ctx, consentLoaderKey{}, loader.New(r.consents.ListByPatients, loaderWait))
+
+	return ctx
+}
+
+// BatchGetPatients returns the patients of ids who are not soft-deleted, in
+// the order of ids, with a NOT_FOUND error in place of the others. The caller
+// needs one of ReadRoles and the patients returned are recorded as read in
+// the audit log like getPatient does; when the caller is refused or the
+// reads cannot be recorded, every id fails with that error. Calls made with
+// the same context from WithLoaders, from resolvers or REST handlers alike
+// and concurrently or not, share one query; without loaders each call makes
+// its own.
+func (r *Resolver) BatchGetPatients(ctx context.Context, ids []int) ([]*store.Patient, []error) {
+	patients := make([]*store.Patient, len(ids))
+	errs := make([]error, len(ids))
+	fail := func(err error) ([]*store.Patient, []error) {
+		for i := range ids {
+			patients[i], errs[i] = nil, err
+		}
+		return patients, errs
+	}
+
+	userID, err := authorize(ctx, ReadRoles...)
+	if err != nil {
+		return fail(err)
+	}
+
+	thunks := make([]func() (*store.Patient, error), len(ids))
+	for i, id := range ids {
+		thunks[i] = loadValue(ctx, patientLoaderKey{}, r.patients.GetMany, id)
+	}
+
+	var found []*store.Patient
+	for i, thunk := range thunks {
+		patients[i], errs[i] = thunk()
+		if patients[i] == nil && errs[i] == nil {
+			errs[i] = utils.NotFound("patient %d not found", ids[i])
+		}
+		if patients[i] != nil {
+			found = append(found, patients[i])
+		}
+	}
+
+	if err := r.logAccess(ctx, userID, "read", found...); err != nil {
+		return fail(err)
+	}
+
+	return patients, errs
+}
+
+// loadValue returns a thunk yielding the value of key from the loader stored
+// in ctx under loaderKey, batched with the other keys of the request. Without
+// a loader in ctx, fetch is called for key alone.
+func loadValue[K comparable, V any](ctx context.Context, loaderKey interface{}, fetch loader.BatchFunc[K, V], key K) func() (V, error) {
+	l, ok := ctx.Value(loaderKey).(*loader.Loader[K, V])
+	if !ok {
+		return func() (V, error) {
+			values, err := fetch(ctx, []K{key})
+			return values[key], err
+		}
+	}
+
+	return l.Load(ctx, key)
+}
+
+// load is loadValue for the lists of a nested field keyed by the id of their
+// parent. Keys without values yield an empty list rather than null.
+func load[V any](ctx context.Context, loaderKey interface{}, fetch loader.BatchFunc[int, []V], key int) func() ([]V, error) {
+	thunk := loadValue(ctx, loaderKey, fetch, key)
+	return func() ([]V, error) {
+		values, err := thunk()
+		if values == nil && err == nil {
+			values = []V{}
+		}
+
+		return values, err
+	}
+}
+
+// encounterPageKey identifies a page of the encounters of a patient.
+type encounterPageKey struct {
+	patientID     int
+	limit, offset int
+}
+
+// encounterPages fetches the encounter pages of keys with one query for each
+// distinct page asked for, usually a single one.
+func (r *Resolver) encounterPages(ctx context.Context, keys []encounterPageKey) (map[encounterPageKey]*store.EncounterPage, error) {
+	type page struct{ limit, offset int }
+
+	patientIDs := map[page][]int{}
+	for _, key := range keys {
+		p := page{key.limit, key.offset}
+		patientIDs[p] = append(patientIDs[p], key.patientID)
+	}
+
+	pages := make(map[encounterPageKey]*store.EncounterPage, len(keys))
+	for p, ids := range patientIDs {
+		byPatient, err := r.encounters.ListByPatients(ctx, ids, p.limit, p.offset)
+		if err != nil {
+			return nil, err
+		}
+
+		for patientID, encounters := range byPatient {
+			pages[encounterPageKey{patientID, p.limit, p.offset}] = encounters
+		}
+	}
+
+	return pages, nil
+}
@@ -0,0 +1,237 @@
+package resolvers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/store/memory"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// countingAppointments counts the queries for the appointments of patients.
+type countingAppointments struct {
+	store.AppointmentRepository
+	queries atomic.Int64
+}
+
+func (a *countingAppointments) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*store.Appointment, error) {
+	a.queries.Add(1)
+	return a.AppointmentRepository.ListByPatients(ctx, patientIDs)
+}
+
+const patientsWithAppointments = `{ getPatients(limit: 50) { patients { id appointments { id } } } }`
+
+// newAppointmentsEnv returns a testEnv holding patients patients with an
+// appointment each, counting the queries for their appointments.
+func newAppointmentsEnv(tb testing.TB, patients int) (*testEnv, *countingAppointments) {
+	tb.Helper()
+
+	db := memory.New()
+	appointments := &countingAppointments{AppointmentRepository: memory.NewAppointmentStore(db)}
+	env := newTestEnvWith(tb, testRepos{db: db, appointments: appointments})
+
+	ctx := clinician(tb)
+	for i := 0; i < patients; i++ {
+		id := env.createPatient(tb, ctx, fmt.Sprintf("Patient %d", i), fmt.Sprintf("patient%d@example.com", i), fmt.Sprintf("+1415555%04d", i))
+		env.mustDo(tb, ctx, `mutation($id: Int!) {
+			createAppointment(patientId: $id, scheduledAt: "2030-03-01T10:00:00Z", reason: "Checkup") { id }
+		}`, map[string]interface{}{"id": id}, nil)
+	}
+
+	return env, appointments
+}
+
+// doWithoutLoaders runs query like do but without the loaders of a request,
+// so every patient fetches its own appointments.
+func (e *testEnv) doWithoutLoaders(ctx context.Context, query string) *graphql.Result {
+	return graphql.Do(graphql.Params{Context: ctx, Schema: e.schema, RequestString: query})
+}
+
+func TestAppointmentsAreBatched(t *testing.T) {
+	env, appointments := newAppointmentsEnv(t, 50)
+	ctx := clinician(t)
+
+	tests := []struct {
+		name    string
+		do      func() *graphql.Result
+		queries int64
+	}{
+		{"without loaders", func() *graphql.Result { return env.doWithoutLoaders(ctx, patientsWithAppointments) }, 50},
+		{"with loaders", func() *graphql.Result { return env.do(ctx, patientsWithAppointments, nil) }, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appointments.queries.Store(0)
+
+			result := tt.do()
+			if result.HasErrors() {
+				t.Fatal(result.Errors)
+			}
+
+			var data struct {
+				GetPatients struct {
+					Patients []struct{ Appointments []struct{ ID int } }
+				}
+			}
+			decode(t, result.Data, &data)
+			if len(data.GetPatients.Patients) != 50 {
+				t.Fatalf("%d patients, want 50", len(data.GetPatients.Patients))
+			}
+			for i, patient := range data.GetPatients.Patients {
+				if len(patient.Appointments) != 1 {
+					t.Errorf("patient %d has %d appointments, want 1", i, len(patient.Appointments))
+				}
+			}
+
+			if got := appointments.queries.Load(); got != tt.queries {
+				t.Errorf("%d appointment queries, want %d", got, tt.queries)
+			}
+		})
+	}
+}
+
+// BenchmarkPatientAppointments lists 50 patients with their appointments,
+// reporting the appointment queries of each request: one per patient
+// without the loaders and one in all with them.
+func BenchmarkPatientAppointments(b *testing.B) {
+	env, appointments := newAppointmentsEnv(b, 50)
+	ctx := clinician(b)
+
+	benchmarks := []struct {
+		name string
+		do   func() *graphql.Result
+	}{
+		{"without loaders", func() *graphql.Result { return env.doWithoutLoaders(ctx, patientsWithAppointments) }},
+		{"with loaders", func() *graphql.Result { return env.do(ctx, patientsWithAppointments, nil) }},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			appointments.queries.Store(0)
+
+			for i := 0; i < b.N; i++ {
+				if result := bm.do(); result.HasErrors() {
+					b.Fatal(result.Errors)
+				}
+			}
+
+			b.ReportMetric(float64(appointments.queries.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
+
+// countingPatients counts the queries for many patients at once.
+type countingPatients struct {
+	store.PatientRepository
+	queries atomic.Int64
+}
+
+func (p *countingPatients) GetMany(ctx context.Context, ids []int) (map[int]*store.Patient, error) {
+	p.queries.Add(1)
+	return p.PatientRepository.GetMany(ctx, ids)
+}
+
+func TestBatchGetPatients(t *testing.T) {
+	db := memory.New()
+	patients := &countingPatients{PatientRepository: memory.NewPatientStore(db)}
+	env := newTestEnvWith(t, testRepos{db: db, patients: patients})
+
+	ctx := clinician(t)
+	var ids []int
+	for i := 0; i < 5; i++ {
+		ids = append(ids, env.createPatient(t, ctx, fmt.Sprintf("Patient %d", i), fmt.Sprintf("patient%d@example.com", i), "+14155550100"))
+	}
+	env.mustDo(t, ctx, `mutation($id: Int!) { delete(id: $id) { id } }`, map[string]interface{}{"id": ids[4]}, nil)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		ids     []int
+		queries int64
+		// found are the ids expected to be returned, the others NOT_FOUND.
+		found []int
+	}{
+		{"with loaders", env.resolver.WithLoaders(ctx), ids[:4], 1, ids[:4]},
+		{"without loaders", ctx, ids[:4], 8, ids[:4]},
+		{"unknown and deleted", env.resolver.WithLoaders(ctx), []int{ids[0], 999, ids[4]}, 1, ids[:1]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patients.queries.Store(0)
+
+			type result struct {
+				patients []*store.Patient
+				errs     []error
+			}
+			results := make([]result, 2)
+			var wg sync.WaitGroup
+			for i := range results {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					results[i].patients, results[i].errs = env.resolver.BatchGetPatients(tt.ctx, tt.ids)
+				}(i)
+			}
+			wg.Wait()
+
+			found := map[int]bool{}
+			for _, id := range tt.found {
+				found[id] = true
+			}
+			for _, r := range results {
+				for i, id := range tt.ids {
+					if found[id] {
+						if r.errs[i] != nil || r.patients[i] == nil || r.patients[i].ID != id {
+							t.Errorf("patient %d = %v, %v, want it", id, r.patients[i], r.errs[i])
+						}
+						continue
+					}
+
+					var coded *utils.CodedError
+					if r.patients[i] != nil || !errors.As(r.errs[i], &coded) || coded.Code != "NOT_FOUND" {
+						t.Errorf("patient %d = %v, %v, want NOT_FOUND", id, r.patients[i], r.errs[i])
+					}
+				}
+			}
+
+			if got := patients.queries.Load(); got != tt.queries {
+				t.Errorf("%d patient queries, want %d", got, tt.queries)
+			}
+		})
+	}
+
+	// The patients returned are recorded as read by the caller.
+	reader := asUser(t, "readonly-1", middleware.RoleReadonly)
+	if _, errs := env.resolver.BatchGetPatients(reader, ids[1:2]); errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	var log struct {
+		GetAuditLog []struct{ Operation, PerformedBy string }
+	}
+	env.mustDo(t, ctx, `query($patientId: Int!) { getAuditLog(patientId: $patientId, limit: 1) { operation performedBy } }`,
+		map[string]interface{}{"patientId": ids[1]}, &log)
+	if len(log.GetAuditLog) != 1 || log.GetAuditLog[0].Operation != "read" || log.GetAuditLog[0].PerformedBy != "readonly-1" {
+		t.Errorf("latest audit entries = %+v, want a read by readonly-1", log.GetAuditLog)
+	}
+
+	patients.queries.Store(0)
+	found, errs := env.resolver.BatchGetPatients(context.Background(), ids[:2])
+	for i := range found {
+		if found[i] != nil || !errors.Is(errs[i], utils.ErrUnauthenticated) {
+			t.Errorf("anonymous read of patient %d = %v, %v, want unauthenticated", ids[i], found[i], errs[i])
+		}
+	}
+	if got := patients.queries.Load(); got != 0 {
+		t.Errorf("%d patient queries for an anonymous caller, want none", got)
+	}
+}
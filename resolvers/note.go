@@ -0,0 +1,105 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// BatchNotesResult is the outcome of batchCreateNotes.
+type BatchNotesResult struct {
+	Created int `json:"created"`
+	// Errors holds the notes that were not created, in the order of the
+	// input.
+	Errors []*BatchNoteError `json:"errors"`
+}
+
+// BatchNoteError is why one note of batchCreateNotes was not created.
+type BatchNoteError struct {
+	// Index is the position of the note in the input list.
+	Index     int    `json:"index"`
+	PatientID int    `json:"patientId"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// PatientNotes resolves the notes field of a patient.
+func (r *Resolver) PatientNotes(params graphql.ResolveParams) (interface{}, error) {
+	patient, ok := params.Source.(*store.Patient)
+	if !ok {
+		return nil, nil
+	}
+
+	thunk := load(params.Context, noteLoaderKey{}, r.notes.ListByPatients, patient.ID)
+
+	return func() (interface{}, error) {
+		notes, err := thunk()
+		if err != nil {
+			return nil, dbError(params.Context, err, "could not list notes of patient %d", patient.ID)
+		}
+
+		return notes, nil
+	}, nil
+}
+
+// BatchCreateNotes adds up to MaxBatchSize notes to patients in one
+// transaction. A note that is invalid or whose patient is unknown or deleted
+// is reported and skipped, and the others are still created; any other
+// failure creates none.
+func (r *Resolver) BatchCreateNotes(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, _ := params.Args["notes"].([]interface{})
+	if len(inputs) > MaxBatchSize {
+		return nil, &utils.CodedError{
+			Code:    "BAD_USER_INPUT",
+			Message: fmt.Sprintf("notes takes at most %d notes, got %d; split it into several calls", MaxBatchSize, len(inputs)),
+		}
+	}
+
+	var result *BatchNotesResult
+	err = r.tx.InTx(params.Context, func(ctx context.Context) error {
+		result = &BatchNotesResult{Errors: []*BatchNoteError{}}
+
+		for i, input := range inputs {
+			args, _ := input.(map[string]interface{})
+			note := &store.Note{}
+			note.PatientID, _ = args["patientId"].(int)
+			note.Body, _ = args["body"].(string)
+
+			err := validation.Note(&note.Body)
+			if err == nil {
+				err = r.notes.Add(ctx, userID, note)
+				if isNotFound(err) {
+					err = utils.NotFound("patient %d not found", note.PatientID)
+				} else if err != nil {
+					return err
+				}
+			}
+			if err != nil {
+				item := itemError(err)
+				result.Errors = append(result.Errors, &BatchNoteError{
+					Index: i, PatientID: note.PatientID, Code: item.Code, Message: item.Message,
+				})
+				continue
+			}
+
+			result.Created++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not create notes")
+	}
+
+	return result, nil
+}
@@ -0,0 +1,171 @@
+package resolvers_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/store/memory"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+type batchNoteError struct {
+	Index     int
+	PatientID int
+	Code      string
+}
+
+// notesEnv returns a testEnv holding the patients ann and bob, and deleted,
+// who is soft-deleted, with their ids by name. unknown is the id of no
+// patient.
+func notesEnv(t *testing.T) (*testEnv, map[string]int) {
+	t.Helper()
+
+	env := newTestEnv(t)
+	ctx := clinician(t)
+	ids := map[string]int{
+		"ann":     env.createPatient(t, ctx, "Ann Lee", "ann@example.com", "+14155550100"),
+		"bob":     env.createPatient(t, ctx, "Bob Ray", "bob@example.com", "+14155550111"),
+		"deleted": env.createPatient(t, ctx, "Cy Day", "cy@example.com", "+14155550122"),
+		"unknown": 999,
+	}
+	env.mustDo(t, ctx, `mutation($id: Int!) { delete(id: $id) { id } }`, map[string]interface{}{"id": ids["deleted"]}, nil)
+
+	return env, ids
+}
+
+func TestBatchCreateNotes(t *testing.T) {
+	type note struct{ patient, body string }
+	type noteError struct {
+		index   int
+		patient string
+		code    string
+	}
+
+	tests := []struct {
+		name    string
+		notes   []note
+		created int
+		errors  []noteError
+		// bodies are the notes of ann and bob after the batch.
+		bodies map[string][]string
+	}{
+		{
+			name:    "one unknown patient",
+			notes:   []note{{"ann", "Summary 1"}, {"bob", "Summary 2"}, {"unknown", "Summary 3"}, {"ann", "  Summary 4 "}, {"bob", "Summary 5"}},
+			created: 4,
+			errors:  []noteError{{2, "unknown", "NOT_FOUND"}},
+			bodies:  map[string][]string{"ann": {"Summary 1", "Summary 4"}, "bob": {"Summary 2", "Summary 5"}},
+		},
+		{
+			name:    "invalid bodies and a deleted patient",
+			notes:   []note{{"ann", " "}, {"deleted", "Summary"}, {"bob", strings.Repeat("a", validation.MaxNoteLength+1)}, {"bob", "Summary"}},
+			created: 1,
+			errors:  []noteError{{0, "ann", "BAD_USER_INPUT"}, {1, "deleted", "NOT_FOUND"}, {2, "bob", "BAD_USER_INPUT"}},
+			bodies:  map[string][]string{"ann": {}, "bob": {"Summary"}},
+		},
+		{name: "empty", bodies: map[string][]string{"ann": {}, "bob": {}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, ids := notesEnv(t)
+			ctx := clinician(t)
+
+			notes := []interface{}{}
+			for _, n := range tt.notes {
+				notes = append(notes, map[string]interface{}{"patientId": ids[n.patient], "body": n.body})
+			}
+			want := []batchNoteError{}
+			for _, e := range tt.errors {
+				want = append(want, batchNoteError{e.index, ids[e.patient], e.code})
+			}
+
+			var data struct {
+				BatchCreateNotes struct {
+					Created int
+					Errors  []batchNoteError
+				}
+			}
+			env.mustDo(t, ctx, `mutation($notes: [NoteInput!]!) {
+				batchCreateNotes(notes: $notes) { created errors { index patientId code } }
+			}`, map[string]interface{}{"notes": notes}, &data)
+			if got := data.BatchCreateNotes; got.Created != tt.created || !reflect.DeepEqual(got.Errors, want) {
+				t.Errorf("batchCreateNotes = %+v, want %d created and errors %+v", got, tt.created, want)
+			}
+
+			for patient, want := range tt.bodies {
+				var data struct {
+					GetPatient struct {
+						Notes []struct{ Body, CreatedBy string }
+					}
+				}
+				env.mustDo(t, ctx, `query($id: Int!) { getPatient(id: $id) { notes { body createdBy } } }`,
+					map[string]interface{}{"id": ids[patient]}, &data)
+
+				bodies := []string{}
+				for _, n := range data.GetPatient.Notes {
+					bodies = append(bodies, n.Body)
+					if n.CreatedBy != "clinician-1" {
+						t.Errorf("note %q created by %q, want clinician-1", n.Body, n.CreatedBy)
+					}
+				}
+				if !reflect.DeepEqual(bodies, want) {
+					t.Errorf("notes of %s = %q, want %q", patient, bodies, want)
+				}
+			}
+		})
+	}
+}
+
+// failingNotes fails to add the note with body failOn.
+type failingNotes struct {
+	store.NoteRepository
+	failOn string
+}
+
+func (n *failingNotes) Add(ctx context.Context, actor string, note *store.Note) error {
+	if note.Body == n.failOn {
+		return errors.New("connection reset")
+	}
+
+	return n.NoteRepository.Add(ctx, actor, note)
+}
+
+func TestBatchCreateNotesIsOneTransaction(t *testing.T) {
+	db := memory.New()
+	env := newTestEnvWith(t, testRepos{db: db, notes: &failingNotes{NoteRepository: memory.NewNoteStore(db), failOn: "Summary 2"}})
+	ctx := clinician(t)
+	patientID := env.createPatient(t, ctx, "Ann Lee", "ann@example.com", "+14155550100")
+
+	result := env.do(ctx, `mutation($id: Int!) {
+		batchCreateNotes(notes: [{patientId: $id, body: "Summary 1"}, {patientId: $id, body: "Summary 2"}]) { created }
+	}`, map[string]interface{}{"id": patientID})
+	if !result.HasErrors() || !strings.Contains(result.Errors[0].Message, "could not create notes") {
+		t.Fatalf("errors = %v, want could not create notes", result.Errors)
+	}
+
+	var data struct {
+		GetPatient struct{ Notes []struct{ Body string } }
+	}
+	env.mustDo(t, ctx, `query($id: Int!) { getPatient(id: $id) { notes { body } } }`, map[string]interface{}{"id": patientID}, &data)
+	if len(data.GetPatient.Notes) != 0 {
+		t.Errorf("notes = %+v, want none after the failed batch", data.GetPatient.Notes)
+	}
+}
+
+func TestBatchCreateNotesNeedsWriteRole(t *testing.T) {
+	env := newTestEnv(t)
+	patientID := env.createPatient(t, clinician(t), "Ann Lee", "ann@example.com", "+14155550100")
+
+	result := env.do(asUser(t, "readonly-1", middleware.RoleReadonly), `mutation($id: Int!) {
+		batchCreateNotes(notes: [{patientId: $id, body: "Summary"}]) { created }
+	}`, map[string]interface{}{"id": patientID})
+	if code := errorCode(t, result); code != "FORBIDDEN" {
+		t.Errorf("code = %q, want FORBIDDEN: %v", code, result.Errors)
+	}
+}
@@ -0,0 +1,435 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/hl7"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// PatientConnection is one page of patients along with the information
+// needed to request the next one.
+type PatientConnection struct {
+	Patients    []*store.Patient `json:"patients"`
+	TotalCount  int              `json:"totalCount"`
+	HasNextPage bool             `json:"hasNextPage"`
+}
+
+// FilterFromArgs converts a PatientFilterInput argument to a store filter.
+func FilterFromArgs(args map[string]interface{}) store.PatientFilter {
+	var filter store.PatientFilter
+
+	filter.Name, _ = args["name"].(string)
+	filter.Email, _ = args["email"].(string)
+	filter.Phone, _ = args["phone"].(string)
+	filter.EmailEquals, _ = args["emailEquals"].(string)
+
+	return filter
+}
+
+// ErrDeletedForbidden is returned when a caller other than an admin asks for
+// soft-deleted patients.
+var ErrDeletedForbidden = &utils.CodedError{Code: "FORBIDDEN", Message: "only admins may read deleted patients"}
+
+// includeDeletedArg reads the includeDeleted argument, which only admins may
+// set.
+func includeDeletedArg(ctx context.Context, args map[string]interface{}) (bool, error) {
+	includeDeleted, _ := args["includeDeleted"].(bool)
+	if includeDeleted && !middleware.HasRole(ctx, middleware.RoleAdmin) {
+		return false, ErrDeletedForbidden
+	}
+
+	return includeDeleted, nil
+}
+
+func (r *Resolver) GetPatient(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(p.Context, ReadRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := p.Args["id"].(int)
+	includeDeleted, err := includeDeletedArg(p.Context, p.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	patient, err := r.patients.Get(p.Context, id, includeDeleted)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(p.Context, err, "could not get patient %d", id)
+	}
+
+	if err := r.logAccess(p.Context, userID, "read", patient); err != nil {
+		return nil, err
+	}
+
+	return patient, nil
+}
+
+func (r *Resolver) GetPatients(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, ReadRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, offset, err := pageArgs(params.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := store.ListOptions{Limit: limit, Offset: offset}
+	opts.SortBy, _ = params.Args["sortBy"].(string)
+	opts.SortOrder, _ = params.Args["sortOrder"].(string)
+	if opts.IncludeDeleted, err = includeDeletedArg(params.Context, params.Args); err != nil {
+		return nil, err
+	}
+	if filter, ok := params.Args["filter"].(map[string]interface{}); ok {
+		opts.Filter = FilterFromArgs(filter)
+	}
+
+	patients, total, err := r.patients.List(params.Context, opts)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not list patients")
+	}
+
+	if err := r.logAccess(params.Context, userID, "search", patients...); err != nil {
+		return nil, err
+	}
+
+	return &PatientConnection{
+		Patients:    patients,
+		TotalCount:  total,
+		HasNextPage: offset+len(patients) < total,
+	}, nil
+}
+
+func (r *Resolver) GetPatientStatusSummary(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, ReadRoles...); err != nil {
+		return nil, err
+	}
+
+	counts, err := r.patients.StatusSummary(params.Context)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not count patients by status")
+	}
+
+	return counts, nil
+}
+
+// MinSearchTermLength is the shortest term searchPatients accepts, in
+// characters. Shorter terms cannot use the trigram indexes.
+const MinSearchTermLength = 3
+
+// SearchPatients finds patients by part of their name, email or phone,
+// best matches first, recording the search in the audit log.
+func (r *Resolver) SearchPatients(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, ReadRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, _, err := pageArgs(params.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	term, _ := params.Args["term"].(string)
+	term = strings.TrimSpace(term)
+	if utf8.RuneCountInString(term) < MinSearchTermLength {
+		return nil, validation.Errors{{
+			Field:   "term",
+			Message: fmt.Sprintf("term must be at least %d characters", MinSearchTermLength),
+		}}
+	}
+
+	matches, err := r.patients.Search(params.Context, term, limit)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not search patients")
+	}
+
+	patients := make([]*store.Patient, len(matches))
+	for i, match := range matches {
+		patients[i] = match.Patient
+	}
+
+	if err := r.logAccess(params.Context, userID, "search", patients...); err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+// CreatePatient creates a patient once per idempotency key, like the other
+// create mutations: sent again with the key of a request that succeeded, it
+// returns the patient that request created.
+func (r *Resolver) CreatePatient(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := patientInput(params.Args, false)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := idempotencyKey(params)
+	if err != nil {
+		return nil, err
+	}
+
+	patient, replayed, err := r.idempotentPatient(params.Context, userID, key, params.Info.FieldName, params.Args,
+		func(ctx context.Context) (*store.Patient, error) {
+			return r.patients.Create(ctx, userID, *changes.Name, *changes.Email, *changes.Phone, newDemographics(changes))
+		})
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not create patient")
+	}
+
+	if !replayed {
+		slog.InfoContext(params.Context, "patient created", "patient_id", patient.ID)
+		r.publish(PatientCreated, patient)
+	}
+
+	return patient, nil
+}
+
+// RegisterPatient creates a patient with their first appointment and an
+// emergency contact in one transaction, so a step that fails, such as a
+// booking that conflicts, leaves none of them behind.
+func (r *Resolver) RegisterPatient(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	contactArgs, _ := params.Args["emergencyContact"].(map[string]interface{})
+	contact := &store.EmergencyContact{}
+	contact.Name, _ = contactArgs["name"].(string)
+	contact.Relationship, _ = contactArgs["relationship"].(string)
+	contact.Phone, _ = contactArgs["phone"].(string)
+
+	var errs validation.Errors
+	changes, err := patientInput(params.Args, false)
+	if err != nil {
+		errs = append(errs, err.(validation.Errors)...)
+	}
+	if err := validation.EmergencyContact(&contact.Name, &contact.Relationship, &contact.Phone); err != nil {
+		for _, fieldErr := range err.(validation.Errors) {
+			fieldErr.Field = "emergencyContact." + fieldErr.Field
+			errs = append(errs, fieldErr)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	appointmentArgs, _ := params.Args["appointment"].(map[string]interface{})
+	appointment, err := appointmentFromArgs(appointmentArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := idempotencyKey(params)
+	if err != nil {
+		return nil, err
+	}
+
+	patient, replayed, err := r.idempotentPatient(params.Context, userID, key, params.Info.FieldName, params.Args,
+		func(ctx context.Context) (patient *store.Patient, err error) {
+			err = r.tx.InTx(ctx, func(ctx context.Context) error {
+				var err error
+				patient, err = r.patients.Create(ctx, userID, *changes.Name, *changes.Email, *changes.Phone, newDemographics(changes))
+				if err != nil {
+					return err
+				}
+
+				appointment.PatientID = patient.ID
+				if _, err := r.appointments.Book(ctx, appointment); err != nil {
+					return err
+				}
+
+				contact.PatientID = patient.ID
+				return r.contacts.Add(ctx, userID, contact)
+			})
+			return patient, err
+		})
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not register patient")
+	}
+
+	if !replayed {
+		r.publish(PatientCreated, patient)
+	}
+
+	return patient, nil
+}
+
+func (r *Resolver) CreatePatientFromHL7(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	message, _ := params.Args["message"].(string)
+
+	parsed, err := hl7.ParseADTA04(message)
+	if err != nil {
+		return nil, err
+	}
+
+	demographics, err := HL7Patient(&parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := idempotencyKey(params)
+	if err != nil {
+		return nil, err
+	}
+
+	patient, replayed, err := r.idempotentPatient(params.Context, userID, key, params.Info.FieldName, params.Args,
+		func(ctx context.Context) (*store.Patient, error) {
+			return r.patients.Create(ctx, userID, parsed.Name, parsed.Email, parsed.Phone, demographics)
+		})
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not create patient")
+	}
+
+	if !replayed {
+		r.publish(PatientCreated, patient)
+	}
+
+	return patient, nil
+}
+
+func (r *Resolver) UpdatePatient(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	changes, err := patientInput(params.Args, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if changes.Empty() {
+		return nil, fmt.Errorf("update needs at least one field to change")
+	}
+
+	version, _ := params.Args["version"].(int)
+	changes.Version = &version
+
+	patient, err := r.patients.Update(params.Context, userID, id, changes)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not update patient %d", id)
+	}
+
+	r.publish(PatientUpdated, patient)
+
+	return patient, nil
+}
+
+func (r *Resolver) DeletePatient(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	patient, err := r.patients.Delete(params.Context, userID, id)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not delete patient %d", id)
+	}
+
+	r.publish(PatientDeleted, patient)
+
+	return patient, nil
+}
+
+func (r *Resolver) RestorePatient(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	patient, err := r.patients.Restore(params.Context, userID, id)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d does not exist or is not deleted", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not restore patient %d", id)
+	}
+
+	r.publish(PatientUpdated, patient)
+
+	return patient, nil
+}
+
+// PurgePatient permanently removes a soft-deleted patient. Only admins may
+// purge, and only after a delete, so a single call cannot erase a record.
+func (r *Resolver) PurgePatient(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, middleware.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	patient, err := r.patients.Purge(params.Context, userID, id)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d does not exist or is not deleted", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not purge patient %d", id)
+	}
+
+	return patient, nil
+}
+
+// patientChanges reads the name, email, phone and demographic arguments,
+// other than birthDate, that were supplied to a partial update, leaving the
+// others nil.
+func patientChanges(args map[string]interface{}) store.PatientChanges {
+	var changes store.PatientChanges
+
+	for _, f := range []struct {
+		name  string
+		field **string
+	}{
+		{"name", &changes.Name},
+		{"email", &changes.Email},
+		{"phone", &changes.Phone},
+	} {
+		if value, ok := args[f.name].(string); ok {
+			*f.field = &value
+		}
+	}
+	demographicsChanges(args, &changes)
+
+	return changes
+}
@@ -0,0 +1,762 @@
+package resolvers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+const adtA04 = "MSH|^~\\&|REGADT|MCM|IFENG|IFENG|20240301100000||ADT^A04|MSG00001|P|2.5\r" +
+	"EVN|A04|20240301100000\r" +
+	"PID|||12345^^^MCM^MR||Doe^John||19610615|M|||1200 N ELM STREET^^GREENSBORO^NC^27401||+14155552671^PRN^PH^john@example.com"
+
+func TestCreatePatientFromHL7(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+		err     string
+	}{
+		{name: "ADT^A04", message: adtA04, want: "John Doe"},
+		{name: "ADT^A08", message: strings.ReplaceAll(adtA04, "A04", "A08"), err: "expected an ADT^A04 message"},
+		{name: "invalid phone", message: strings.Replace(adtA04, "+14155552671", "call me", 1), err: "phone"},
+		{name: "no email", message: strings.Replace(adtA04, "^john@example.com", "", 1), err: "email"},
+		{name: "birth date in the future", message: strings.Replace(adtA04, "19610615", "29610615", 1), err: "birthDate must not be in the future"},
+		{name: "not HL7", message: "hello", err: "MSH"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			ctx := clinician(t)
+
+			result := env.do(ctx, `mutation($message: String!) {
+				createPatientFromHL7(message: $message) { id name email phone }
+			}`, map[string]interface{}{"message": tt.message})
+
+			if tt.err != "" {
+				if !result.HasErrors() || !strings.Contains(result.Errors[0].Message, tt.err) {
+					t.Fatalf("errors = %v, want one containing %q", result.Errors, tt.err)
+				}
+				return
+			}
+			if result.HasErrors() {
+				t.Fatal(result.Errors)
+			}
+
+			var data struct {
+				CreatePatientFromHL7 struct {
+					ID                 int
+					Name, Email, Phone string
+				}
+			}
+			decode(t, result.Data, &data)
+
+			created := data.CreatePatientFromHL7
+			if created.Name != tt.want || created.Email != "john@example.com" || created.Phone != "+14155552671" {
+				t.Errorf("created %+v, want %s, john@example.com, +14155552671", created, tt.want)
+			}
+
+			var stored struct {
+				GetPatient struct {
+					Name, Phone, BirthDate, Gender string
+					Identifiers                    []struct{ Type, System, Value string }
+					Addresses                      []struct {
+						Lines                   []string
+						City, State, PostalCode string
+					}
+				}
+			}
+			env.mustDo(t, ctx, `query($id: Int!) {
+				getPatient(id: $id) { name phone birthDate gender identifiers { type system value } addresses { lines city state postalCode } }
+			}`, map[string]interface{}{"id": created.ID}, &stored)
+			got := stored.GetPatient
+			if got.Name != tt.want || got.Phone != "+14155552671" {
+				t.Errorf("stored %+v, want %s, +14155552671", got, tt.want)
+			}
+			if got.BirthDate != "1961-06-15" || got.Gender != "MALE" {
+				t.Errorf("stored birth date %q and gender %q, want 1961-06-15 and MALE", got.BirthDate, got.Gender)
+			}
+			if fmt.Sprint(got.Identifiers) != "[{MRN MCM 12345}]" {
+				t.Errorf("stored identifiers %+v, want the MCM medical record number 12345", got.Identifiers)
+			}
+			if fmt.Sprint(got.Addresses) != "[{[1200 N ELM STREET] GREENSBORO NC 27401}]" {
+				t.Errorf("stored addresses %+v, want 1200 N ELM STREET, GREENSBORO, NC 27401", got.Addresses)
+			}
+		})
+	}
+
+	result := newTestEnv(t).do(asUser(t, "reader-1", middleware.RoleReadonly), `mutation($message: String!) {
+		createPatientFromHL7(message: $message) { id }
+	}`, map[string]interface{}{"message": adtA04})
+	if code := errorCode(t, result); code != "FORBIDDEN" {
+		t.Errorf("readonly caller: code = %q, want FORBIDDEN", code)
+	}
+}
+
+// failingPatients is a PatientRepository whose reads and writes fail with
+// err, as when the database is down.
+type failingPatients struct {
+	store.PatientRepository
+	err error
+}
+
+func (p failingPatients) Get(ctx context.Context, id int, includeDeleted bool) (*store.Patient, error) {
+	return nil, p.err
+}
+
+func (p failingPatients) List(ctx context.Context, opts store.ListOptions) ([]*store.Patient, int, error) {
+	return nil, 0, p.err
+}
+
+func (p failingPatients) Create(ctx context.Context, actor, name, email, phone string, demographics store.Demographics) (*store.Patient, error) {
+	return nil, p.err
+}
+
+func (p failingPatients) Update(ctx context.Context, actor string, id int, changes store.PatientChanges) (*store.Patient, error) {
+	return nil, p.err
+}
+
+func (p failingPatients) Delete(ctx context.Context, actor string, id int) (*store.Patient, error) {
+	return nil, p.err
+}
+
+func TestDatabaseErrorsAreReturned(t *testing.T) {
+	env := newTestEnvWith(t, testRepos{patients: failingPatients{err: errors.New("dial tcp 10.0.0.5:5432: connection refused")}})
+
+	tests := []struct {
+		name    string
+		query   string
+		message string
+	}{
+		{"getPatient", `{ getPatient(id: 1) { id } }`, "could not get patient 1"},
+		{"getPatients", `{ getPatients { totalCount patients { id } } }`, "could not list patients"},
+		{"create", `mutation { create(name: "Ada", email: "ada@example.com", phone: "+14155550100") { id } }`, "could not create patient"},
+		{"update", `mutation { update(id: 1, version: 1, name: "Ada") { id } }`, "could not update patient 1"},
+		{"delete", `mutation { delete(id: 1) { id } }`, "could not delete patient 1"},
+	}
+
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			result := env.do(admin(t), tt.query, nil)
+
+			if !loggedDatabaseError(t, &logs, tt.message) {
+				t.Errorf("logs %s have no ERROR entry of operation %q", logs.String(), tt.message)
+			}
+			if code := errorCode(t, result); code != "INTERNAL_SERVER_ERROR" {
+				t.Fatalf("code = %q, want INTERNAL_SERVER_ERROR: %v", code, result.Errors)
+			}
+			if message := result.Errors[0].Message; !strings.HasPrefix(message, tt.message) || strings.Contains(message, "connection refused") {
+				t.Errorf("message = %q, want %q without the driver error", message, tt.message)
+			}
+		})
+	}
+}
+
+func TestCreatePatientLogsNoPHI(t *testing.T) {
+	env := newTestEnv(t)
+
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	id := env.createPatient(t, clinician(t), "Ann Lee", "ann@example.com", "+14155550100")
+
+	for _, phi := range []string{"Ann Lee", "ann@example.com", "+14155550100"} {
+		if strings.Contains(logs.String(), phi) {
+			t.Errorf("logs %s hold %q", logs.String(), phi)
+		}
+	}
+	if !strings.Contains(logs.String(), fmt.Sprintf(`"msg":"patient created","patient_id":%d`, id)) {
+		t.Errorf("logs %s do not name patient %d as created", logs.String(), id)
+	}
+}
+
+// loggedDatabaseError reports whether the JSON lines of logs hold a database
+// error of operation logged at ERROR.
+func loggedDatabaseError(t *testing.T, logs *bytes.Buffer, operation string) bool {
+	t.Helper()
+
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry struct {
+			Level, Msg, Operation, Error string
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log %q is not a JSON line: %v", line, err)
+		}
+
+		if entry.Level == "ERROR" && entry.Msg == "database error" && entry.Operation == operation && entry.Error != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestGetPatientsFilter(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := clinician(t)
+
+	env.createPatient(t, ctx, "Ann Lee", "ann@example.com", "+14155550100")
+	env.createPatient(t, ctx, "Anna Park", "anna@test.org", "+14155550111")
+	env.createPatient(t, ctx, "Bob Stone", "bob@example.com", "+14155550122")
+
+	tests := []struct {
+		name   string
+		filter map[string]interface{}
+		want   []string
+	}{
+		{"none", nil, []string{"Ann Lee", "Anna Park", "Bob Stone"}},
+		{"name", map[string]interface{}{"name": "ANN"}, []string{"Ann Lee", "Anna Park"}},
+		{"email", map[string]interface{}{"email": "example.com"}, []string{"Ann Lee", "Bob Stone"}},
+		{"phone", map[string]interface{}{"phone": "0111"}, []string{"Anna Park"}},
+		{"name and email", map[string]interface{}{"name": "ann", "email": "example"}, []string{"Ann Lee"}},
+		{"name and phone", map[string]interface{}{"name": "ann", "phone": "0122"}, nil},
+		{"email and phone", map[string]interface{}{"email": "example", "phone": "0122"}, []string{"Bob Stone"}},
+		{"all three", map[string]interface{}{"name": "anna", "email": "test", "phone": "0111"}, []string{"Anna Park"}},
+		{"whole email", map[string]interface{}{"emailEquals": "ANN@example.com"}, []string{"Ann Lee"}},
+		{"no match", map[string]interface{}{"name": "zed"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data struct {
+				GetPatients struct {
+					TotalCount int
+					Patients   []struct{ Name string }
+				}
+			}
+			env.mustDo(t, ctx, `query($filter: PatientFilterInput) {
+				getPatients(filter: $filter) { totalCount patients { name } }
+			}`, map[string]interface{}{"filter": tt.filter}, &data)
+
+			var got []string
+			for _, patient := range data.GetPatients.Patients {
+				got = append(got, patient.Name)
+			}
+
+			if data.GetPatients.TotalCount != len(tt.want) || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("getPatients = %v of %d, want %v", got, data.GetPatients.TotalCount, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdatePatientPartial(t *testing.T) {
+	const (
+		name  = "Ann Lee"
+		email = "ann@example.com"
+		phone = "+14155550100"
+	)
+
+	tests := []struct {
+		name    string
+		changes map[string]interface{}
+		want    [3]string
+		err     string
+	}{
+		{"nothing", map[string]interface{}{}, [3]string{name, email, phone}, "at least one field"},
+		{"name", map[string]interface{}{"name": "Ann Park"}, [3]string{"Ann Park", email, phone}, ""},
+		{"email", map[string]interface{}{"email": "ann@test.org"}, [3]string{name, "ann@test.org", phone}, ""},
+		{"phone", map[string]interface{}{"phone": "+14155550199"}, [3]string{name, email, "+14155550199"}, ""},
+		{"name and email", map[string]interface{}{"name": "Ann Park", "email": "ann@test.org"}, [3]string{"Ann Park", "ann@test.org", phone}, ""},
+		{"name and phone", map[string]interface{}{"name": "Ann Park", "phone": "+14155550199"}, [3]string{"Ann Park", email, "+14155550199"}, ""},
+		{"email and phone", map[string]interface{}{"email": "ann@test.org", "phone": "+14155550199"}, [3]string{name, "ann@test.org", "+14155550199"}, ""},
+		{
+			"name, email and phone", map[string]interface{}{"name": "Ann Park", "email": "ann@test.org", "phone": "+14155550199"},
+			[3]string{"Ann Park", "ann@test.org", "+14155550199"}, "",
+		},
+		{"empty name", map[string]interface{}{"name": ""}, [3]string{name, email, phone}, "name"},
+		{"invalid email", map[string]interface{}{"name": "Ann Park", "email": "notanemail"}, [3]string{name, email, phone}, "email must be a valid email address"},
+		{"invalid phone", map[string]interface{}{"phone": "abc"}, [3]string{name, email, phone}, "phone must be an E.164 number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			ctx := clinician(t)
+			id := env.createPatient(t, ctx, name, email, phone)
+
+			variables := map[string]interface{}{"id": id}
+			for field, value := range tt.changes {
+				variables[field] = value
+			}
+
+			result := env.do(ctx, `mutation($id: Int!, $name: String, $email: String, $phone: String) {
+				update(id: $id, version: 1, name: $name, email: $email, phone: $phone) { id }
+			}`, variables)
+			switch {
+			case tt.err == "" && result.HasErrors():
+				t.Fatal(result.Errors)
+			case tt.err != "" && (!result.HasErrors() || !strings.Contains(result.Errors[0].Message, tt.err)):
+				t.Fatalf("errors = %v, want one containing %q", result.Errors, tt.err)
+			}
+
+			var data struct {
+				GetPatient struct{ Name, Email, Phone string }
+			}
+			env.mustDo(t, ctx, `query($id: Int!) { getPatient(id: $id) { name email phone } }`, map[string]interface{}{"id": id}, &data)
+
+			got := [3]string{data.GetPatient.Name, data.GetPatient.Email, data.GetPatient.Phone}
+			if got != tt.want {
+				t.Errorf("patient = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutationsNeedAuthentication(t *testing.T) {
+	env := newTestEnv(t)
+	id := env.createPatient(t, clinician(t), "Ann Lee", "ann@example.com", "+14155550100")
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"create", `mutation { create(name: "Bob", email: "bob@example.com", phone: "+14155550111") { id } }`},
+		{"update", `mutation($id: Int!) { update(id: $id, version: 1, name: "Ann Park") { id } }`},
+		{"delete", `mutation($id: Int!) { delete(id: $id) { id } }`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := env.do(context.Background(), tt.query, map[string]interface{}{"id": id})
+			if code := errorCode(t, result); code != "UNAUTHENTICATED" {
+				t.Errorf("code = %q, want UNAUTHENTICATED: %v", code, result.Errors)
+			}
+		})
+	}
+
+	var data struct {
+		GetPatient struct{ Name string }
+	}
+	env.mustDo(t, clinician(t), `query($id: Int!) { getPatient(id: $id) { name } }`, map[string]interface{}{"id": id}, &data)
+	if data.GetPatient.Name != "Ann Lee" {
+		t.Errorf("name = %q after unauthenticated mutations, want Ann Lee", data.GetPatient.Name)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	env := newTestEnv(t)
+	id := env.createPatient(t, clinician(t), "Ann Lee", "ann@example.com", "+14155550100")
+	env.createPatient(t, clinician(t), "Bob Ray", "bob@example.com", "+14155550111")
+
+	var deleted struct {
+		Delete struct {
+			ID        int
+			DeletedAt *string
+		}
+	}
+	env.mustDo(t, clinician(t), `mutation($id: Int!) { delete(id: $id) { id deletedAt } }`, map[string]interface{}{"id": id}, &deleted)
+	if deleted.Delete.ID != id || deleted.Delete.DeletedAt == nil {
+		t.Fatalf("delete = %+v, want patient %d with deletedAt", deleted.Delete, id)
+	}
+
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		includeDeleted bool
+		found          bool
+		total          int
+		code           string
+	}{
+		{"hidden", clinician(t), false, false, 1, "NOT_FOUND"},
+		{"hidden from admins", admin(t), false, false, 1, "NOT_FOUND"},
+		{"included for admins", admin(t), true, true, 2, ""},
+		{"forbidden to clinicians", clinician(t), true, false, 0, "FORBIDDEN"},
+		{"forbidden to readonly", asUser(t, "readonly-1", middleware.RoleReadonly), true, false, 0, "FORBIDDEN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variables := map[string]interface{}{"id": id, "includeDeleted": tt.includeDeleted}
+
+			result := env.do(tt.ctx, `query($id: Int!, $includeDeleted: Boolean) {
+				getPatient(id: $id, includeDeleted: $includeDeleted) { id deletedAt }
+			}`, variables)
+			if tt.found {
+				var data struct {
+					GetPatient struct{ DeletedAt *string }
+				}
+				if result.HasErrors() {
+					t.Fatal(result.Errors)
+				}
+				decode(t, result.Data, &data)
+				if data.GetPatient.DeletedAt == nil {
+					t.Error("getPatient: deletedAt is not set")
+				}
+			} else if code := errorCode(t, result); code != tt.code {
+				t.Errorf("getPatient: code = %q, want %q", code, tt.code)
+			}
+
+			result = env.do(tt.ctx, `query($includeDeleted: Boolean) {
+				getPatients(includeDeleted: $includeDeleted) { totalCount }
+			}`, variables)
+			wantCode := tt.code
+			if wantCode == "NOT_FOUND" {
+				wantCode = ""
+			}
+			if code := errorCode(t, result); code != wantCode {
+				t.Fatalf("getPatients: code = %q, want %q", code, wantCode)
+			}
+			if wantCode != "" {
+				return
+			}
+
+			var data struct {
+				GetPatients struct{ TotalCount int }
+			}
+			decode(t, result.Data, &data)
+			if data.GetPatients.TotalCount != tt.total {
+				t.Errorf("getPatients: totalCount = %d, want %d", data.GetPatients.TotalCount, tt.total)
+			}
+		})
+	}
+}
+
+func TestPatientStatusSummary(t *testing.T) {
+	type statusCount struct {
+		Status string
+		Count  int
+	}
+
+	tests := []struct {
+		name    string
+		active  int
+		deleted int
+		want    []statusCount
+	}{
+		{"active and deleted", 5, 3, []statusCount{{"active", 5}, {"deleted", 3}}},
+		{"active only", 2, 0, []statusCount{{"active", 2}}},
+		{"none", 0, 0, []statusCount{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			ctx := clinician(t)
+			for i := 0; i < tt.active+tt.deleted; i++ {
+				id := env.createPatient(t, ctx, "Ann Lee", fmt.Sprintf("ann%d@example.com", i), "+14155550100")
+				if i >= tt.active {
+					env.mustDo(t, ctx, `mutation($id: Int!) { delete(id: $id) { id } }`, map[string]interface{}{"id": id}, nil)
+				}
+			}
+
+			var data struct{ PatientStatusSummary []statusCount }
+			env.mustDo(t, asUser(t, "readonly-1", middleware.RoleReadonly), `{ patientStatusSummary { status count } }`, nil, &data)
+			if !reflect.DeepEqual(data.PatientStatusSummary, tt.want) {
+				t.Errorf("patientStatusSummary = %v, want %v", data.PatientStatusSummary, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestorePatient(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := clinician(t)
+	id := env.createPatient(t, ctx, "Ann Lee", "ann@example.com", "+14155550100")
+	env.mustDo(t, ctx, `mutation($id: Int!) { delete(id: $id) { id } }`, map[string]interface{}{"id": id}, nil)
+
+	tests := []struct {
+		name string
+		id   int
+		code string
+	}{
+		{"deleted", id, ""},
+		{"not deleted", id, "NOT_FOUND"},
+		{"unknown", 999, "NOT_FOUND"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := env.do(ctx, `mutation($id: Int!) { restore(id: $id) { id deletedAt } }`, map[string]interface{}{"id": tt.id})
+			if code := errorCode(t, result); code != tt.code {
+				t.Fatalf("code = %q, want %q: %v", code, tt.code, result.Errors)
+			}
+		})
+	}
+
+	var data struct {
+		GetPatient struct {
+			ID        int
+			DeletedAt *string
+		}
+	}
+	env.mustDo(t, ctx, `query($id: Int!) { getPatient(id: $id) { id deletedAt } }`, map[string]interface{}{"id": id}, &data)
+	if data.GetPatient.ID != id || data.GetPatient.DeletedAt != nil {
+		t.Errorf("getPatient = %+v after restore, want patient %d not deleted", data.GetPatient, id)
+	}
+}
+
+func TestGetPatientsSort(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := clinician(t)
+
+	ids := map[string]int{}
+	for _, p := range []struct{ name, email string }{
+		{"Bob Ray", "ann@example.com"},
+		{"Cid Moe", "cid@example.com"},
+		{"Ann Lee", "bob@example.com"},
+	} {
+		ids[p.name] = env.createPatient(t, ctx, p.name, p.email, "+14155550100")
+	}
+
+	tests := []struct {
+		name   string
+		args   string
+		want   []string
+		errMsg string
+	}{
+		{"default", "", []string{"Bob Ray", "Cid Moe", "Ann Lee"}, ""},
+		{"id desc", "sortBy: ID, sortOrder: DESC", []string{"Ann Lee", "Cid Moe", "Bob Ray"}, ""},
+		{"name", "sortBy: NAME", []string{"Ann Lee", "Bob Ray", "Cid Moe"}, ""},
+		{"name desc", "sortBy: NAME, sortOrder: DESC", []string{"Cid Moe", "Bob Ray", "Ann Lee"}, ""},
+		{"email desc", "sortBy: EMAIL, sortOrder: DESC", []string{"Cid Moe", "Ann Lee", "Bob Ray"}, ""},
+		{"sorted then paged", "sortBy: NAME, sortOrder: DESC, limit: 1, offset: 1", []string{"Bob Ray"}, ""},
+		{"unknown field", "sortBy: PHONE", nil, "PHONE"},
+		{"field as a string", `sortBy: "name"`, nil, "sortBy"},
+		{"unknown order", "sortOrder: SIDEWAYS", nil, "SIDEWAYS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := "{ getPatients { patients { id name } } }"
+			if tt.args != "" {
+				query = "{ getPatients(" + tt.args + ") { patients { id name } } }"
+			}
+
+			result := env.do(ctx, query, nil)
+			if tt.errMsg != "" {
+				if !result.HasErrors() || !strings.Contains(result.Errors[0].Message, tt.errMsg) {
+					t.Fatalf("errors = %v, want one about %s", result.Errors, tt.errMsg)
+				}
+				return
+			}
+			if result.HasErrors() {
+				t.Fatal(result.Errors)
+			}
+
+			var data struct {
+				GetPatients struct {
+					Patients []struct {
+						ID   int
+						Name string
+					}
+				}
+			}
+			decode(t, result.Data, &data)
+
+			var got []string
+			for _, patient := range data.GetPatients.Patients {
+				if patient.ID != ids[patient.Name] {
+					t.Errorf("%s has id %d, want %d", patient.Name, patient.ID, ids[patient.Name])
+				}
+				got = append(got, patient.Name)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("patients = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreatePatientValidation(t *testing.T) {
+	tests := []struct {
+		name, patientName, email, phone string
+		// fields are the invalid fields reported, none when the patient is
+		// created.
+		fields []string
+	}{
+		{"valid", "Ann Lee", "ann@example.com", "+14155550100", nil},
+		{"invalid email", "Ann Lee", "notanemail", "+14155550100", []string{"email"}},
+		{"invalid phone", "Ann Lee", "ann@example.com", "abc", []string{"phone"}},
+		{"everything invalid", "", "notanemail", "abc", []string{"name", "email", "phone"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			ctx := clinician(t)
+
+			result := env.do(ctx, `mutation($name: String, $email: String!, $phone: String!) {
+				create(name: $name, email: $email, phone: $phone) { id }
+			}`, map[string]interface{}{"name": tt.patientName, "email": tt.email, "phone": tt.phone})
+
+			var fields []string
+			if result.HasErrors() {
+				if code := errorCode(t, result); code != "BAD_USER_INPUT" {
+					t.Fatalf("code = %q, want BAD_USER_INPUT: %v", code, result.Errors)
+				}
+				for _, field := range result.Errors[0].Extensions["fields"].([]validation.FieldError) {
+					fields = append(fields, field.Field)
+				}
+			}
+			if fmt.Sprint(fields) != fmt.Sprint(tt.fields) {
+				t.Errorf("invalid fields = %v, want %v", fields, tt.fields)
+			}
+
+			var data struct {
+				GetPatients struct{ TotalCount int }
+			}
+			env.mustDo(t, ctx, `{ getPatients { totalCount } }`, nil, &data)
+			if created := data.GetPatients.TotalCount == 1; created != (tt.fields == nil) {
+				t.Errorf("%d patients stored, want one only when the input is valid", data.GetPatients.TotalCount)
+			}
+		})
+	}
+}
+
+func TestDeletePatient(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := clinician(t)
+	id := env.createPatient(t, ctx, "Ann Lee", "ann@example.com", "+14155550100")
+	deleted := env.createPatient(t, ctx, "Bob Ray", "bob@example.com", "+14155550111")
+	env.mustDo(t, ctx, `mutation($id: Int!) { delete(id: $id) { id } }`, map[string]interface{}{"id": deleted}, nil)
+
+	tests := []struct {
+		name string
+		id   int
+		code string
+	}{
+		{"existing", id, ""},
+		{"already deleted", deleted, "NOT_FOUND"},
+		{"unknown", 999, "NOT_FOUND"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := env.do(ctx, `mutation($id: Int!) { delete(id: $id) { id name email phone version deletedAt } }`, map[string]interface{}{"id": tt.id})
+
+			if tt.code != "" {
+				if code := errorCode(t, result); code != tt.code {
+					t.Fatalf("code = %q, want %q: %v", code, tt.code, result.Errors)
+				}
+				if message := result.Errors[0].Message; !strings.Contains(message, fmt.Sprint(tt.id)) {
+					t.Errorf("error %q does not name patient %d", message, tt.id)
+				}
+				return
+			}
+			if result.HasErrors() {
+				t.Fatal(result.Errors)
+			}
+
+			var data struct {
+				Delete struct {
+					ID                 int
+					Name, Email, Phone string
+					Version            int
+					DeletedAt          *string
+				}
+			}
+			decode(t, result.Data, &data)
+
+			got := data.Delete
+			if got.ID != id || got.Name != "Ann Lee" || got.Email != "ann@example.com" || got.Phone != "+14155550100" || got.Version != 2 || got.DeletedAt == nil {
+				t.Errorf("delete = %+v, want Ann Lee at version 2 with deletedAt", got)
+			}
+		})
+	}
+}
+
+// mockPatients records the calls the resolvers make to the repository and
+// answers them with Ann Lee under the requested id.
+type mockPatients struct {
+	store.PatientRepository
+	calls []string
+}
+
+func (m *mockPatients) patient(id int) *store.Patient {
+	return &store.Patient{ID: id, Name: "Ann Lee", Email: "ann@example.com", Phone: "+14155550100", Version: 1, ClinicID: 1}
+}
+
+func (m *mockPatients) Get(ctx context.Context, id int, includeDeleted bool) (*store.Patient, error) {
+	m.calls = append(m.calls, fmt.Sprintf("Get %d includeDeleted=%v", id, includeDeleted))
+	return m.patient(id), nil
+}
+
+func (m *mockPatients) List(ctx context.Context, opts store.ListOptions) ([]*store.Patient, int, error) {
+	m.calls = append(m.calls, fmt.Sprintf("List %+v", opts))
+	return []*store.Patient{m.patient(1)}, 1, nil
+}
+
+func (m *mockPatients) Create(ctx context.Context, actor, name, email, phone string, demographics store.Demographics) (*store.Patient, error) {
+	m.calls = append(m.calls, fmt.Sprintf("Create by %s %q %q %q", actor, name, email, phone))
+	return m.patient(1), nil
+}
+
+func (m *mockPatients) Update(ctx context.Context, actor string, id int, changes store.PatientChanges) (*store.Patient, error) {
+	deref := func(s *string) interface{} {
+		if s == nil {
+			return nil
+		}
+		return *s
+	}
+
+	m.calls = append(m.calls, fmt.Sprintf("Update by %s %d name=%v email=%v phone=%v version=%d",
+		actor, id, deref(changes.Name), deref(changes.Email), deref(changes.Phone), *changes.Version))
+	return m.patient(id), nil
+}
+
+func (m *mockPatients) Delete(ctx context.Context, actor string, id int) (*store.Patient, error) {
+	m.calls = append(m.calls, fmt.Sprintf("Delete by %s %d", actor, id))
+	return m.patient(id), nil
+}
+
+func TestResolversUseTheRepository(t *testing.T) {
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		query string
+		calls []string
+	}{
+		{"getPatient", clinician(t), `{ getPatient(id: 7) { id } }`, []string{"Get 7 includeDeleted=false"}},
+		{"getPatient including deleted", admin(t), `{ getPatient(id: 7, includeDeleted: true) { id } }`, []string{"Get 7 includeDeleted=true"}},
+		{
+			"getPatients", clinician(t), `{ getPatients(limit: 500, offset: 10, filter: {name: "ann"}, sortBy: NAME, sortOrder: DESC) { totalCount } }`,
+			[]string{"List {Filter:{Name:ann Email: Phone: EmailEquals: SharingConsent:} IncludeDeleted:false SortBy:name SortOrder:desc Limit:100 Offset:10}"},
+		},
+		{
+			"create", clinician(t), `mutation { create(name: " Ann Lee ", email: "ann@example.com", phone: "+1 415 555 0100") { id } }`,
+			[]string{`Create by clinician-1 "Ann Lee" "ann@example.com" "+14155550100"`},
+		},
+		{
+			"update", clinician(t), `mutation { update(id: 7, version: 3, email: "ann@test.org") { id } }`,
+			[]string{"Update by clinician-1 7 name=<nil> email=ann@test.org phone=<nil> version=3"},
+		},
+		{"delete", clinician(t), `mutation { delete(id: 7) { id } }`, []string{"Delete by clinician-1 7"}},
+		// Requests the resolvers reject do not reach the repository.
+		{"forbidden", clinician(t), `{ getPatient(id: 7, includeDeleted: true) { id } }`, nil},
+		{"invalid", clinician(t), `mutation { create(name: "Ann", email: "notanemail", phone: "+14155550100") { id } }`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patients := &mockPatients{}
+			env := newTestEnvWith(t, testRepos{patients: patients})
+
+			result := env.do(tt.ctx, tt.query, nil)
+			if tt.calls != nil && result.HasErrors() {
+				t.Fatal(result.Errors)
+			}
+
+			if fmt.Sprint(patients.calls) != fmt.Sprint(tt.calls) {
+				t.Errorf("calls = %q, want %q", patients.calls, tt.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package resolvers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// MaxJustificationLength is the longest justification erasePatient accepts,
+// in characters.
+const MaxJustificationLength = 2000
+
+// PatientArchive is everything stored about a patient, as exported for a
+// right of access request.
+type PatientArchive struct {
+	ExportedAt        time.Time                 `json:"exportedAt"`
+	Patient           *store.Patient            `json:"patient"`
+	Appointments      []*store.Appointment      `json:"appointments"`
+	Encounters        []*EncounterRecord        `json:"encounters"`
+	EmergencyContacts []*store.EmergencyContact `json:"emergencyContacts"`
+	Notes             []*store.Note             `json:"notes"`
+	InsurancePolicies []*store.InsurancePolicy  `json:"insurancePolicies"`
+	Consents          []*store.Consent          `json:"consents"`
+	CareTeam          []*store.Provider         `json:"careTeam"`
+	// Documents lists the metadata of the attached documents, whose files
+	// are downloaded separately.
+	Documents []*store.Document `json:"documents"`
+	// AuditTrail lists who changed and read the patient's records and
+	// when, newest first.
+	AuditTrail []*audit.Entry `json:"auditTrail"`
+}
+
+// EncounterRecord is an encounter with every revision of it, oldest first.
+type EncounterRecord struct {
+	*store.Encounter
+	Revisions []*store.EncounterRevision `json:"revisions"`
+}
+
+// ExportPatientData returns the archive of everything stored about a
+// patient, deleted or not, encoded as JSON. Only privacy officers may export
+// it, and the export is recorded in the audit log.
+func (r *Resolver) ExportPatientData(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, middleware.RolePrivacyOfficer)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	archive, err := r.patientArchive(params.Context, id)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not export the data of patient %d", id)
+	}
+
+	if err := r.logAccess(params.Context, userID, "export", archive.Patient); err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(encoded), nil
+}
+
+// patientArchive gathers the records of the patient id.
+func (r *Resolver) patientArchive(ctx context.Context, id int) (*PatientArchive, error) {
+	patient, err := r.patients.Get(ctx, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &PatientArchive{ExportedAt: time.Now().UTC(), Patient: patient}
+	ids := []int{id}
+
+	appointments, err := r.appointments.ListByPatients(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	archive.Appointments = append([]*store.Appointment{}, appointments[id]...)
+
+	if archive.Encounters, err = r.encounterRecords(ctx, id); err != nil {
+		return nil, err
+	}
+
+	contacts, err := r.contacts.ListByPatients(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	archive.EmergencyContacts = append([]*store.EmergencyContact{}, contacts[id]...)
+
+	notes, err := r.notes.ListByPatients(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	archive.Notes = append([]*store.Note{}, notes[id]...)
+
+	policies, err := r.policies.ListByPatients(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	archive.InsurancePolicies = append([]*store.InsurancePolicy{}, policies[id]...)
+
+	consents, err := r.consents.ListByPatients(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	archive.Consents = append([]*store.Consent{}, consents[id]...)
+
+	careTeams, err := r.providers.CareTeams(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	archive.CareTeam = append([]*store.Provider{}, careTeams[id]...)
+
+	documents, err := r.documents.ListByPatients(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	archive.Documents = append([]*store.Document{}, documents[id]...)
+
+	archive.AuditTrail = []*audit.Entry{}
+	for offset := 0; ; offset += MaxPageLimit {
+		entries, err := r.auditLog.Entries(ctx, id, MaxPageLimit, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		archive.AuditTrail = append(archive.AuditTrail, entries...)
+		if len(entries) < MaxPageLimit {
+			break
+		}
+	}
+
+	return archive, nil
+}
+
+// encounterRecords returns every encounter of the patient id with its
+// revisions, newest first.
+func (r *Resolver) encounterRecords(ctx context.Context, id int) ([]*EncounterRecord, error) {
+	records := []*EncounterRecord{}
+
+	for offset := 0; ; offset += MaxPageLimit {
+		pages, err := r.encounters.ListByPatients(ctx, []int{id}, MaxPageLimit, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		page := pages[id]
+		if page == nil || len(page.Encounters) == 0 {
+			break
+		}
+
+		encounterIDs := make([]int, len(page.Encounters))
+		for i, encounter := range page.Encounters {
+			encounterIDs[i] = encounter.ID
+		}
+
+		revisions, err := r.encounters.Revisions(ctx, encounterIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, encounter := range page.Encounters {
+			records = append(records, &EncounterRecord{Encounter: encounter, Revisions: revisions[encounter.ID]})
+		}
+
+		if len(page.Encounters) < MaxPageLimit {
+			break
+		}
+	}
+
+	return records, nil
+}
+
+// ErasePatient anonymizes a patient on their request, with the
+// justification recorded alongside. Only privacy officers may erase, and an
+// erasure cannot be undone.
+func (r *Resolver) ErasePatient(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, middleware.RolePrivacyOfficer)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+	justification, _ := params.Args["justification"].(string)
+	justification = strings.TrimSpace(justification)
+
+	switch {
+	case justification == "":
+		return nil, validation.Errors{{Field: "justification", Message: "justification must not be empty"}}
+	case utf8.RuneCountInString(justification) > MaxJustificationLength:
+		return nil, validation.Errors{{Field: "justification", Message: "justification must be at most 2000 characters"}}
+	}
+
+	erased, err := r.patients.Erase(params.Context, userID, id, justification)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not erase patient %d", id)
+	}
+
+	for _, patient := range erased {
+		r.publish(PatientDeleted, patient)
+	}
+
+	return erased[0], nil
+}
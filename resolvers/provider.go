@@ -0,0 +1,178 @@
+package resolvers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+func (r *Resolver) GetProvider(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, ReadRoles...); err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["id"].(int)
+
+	provider, err := r.providers.Get(params.Context, id)
+	if isNotFound(err) {
+		return nil, utils.NotFound("provider %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not get provider %d", id)
+	}
+
+	return provider, nil
+}
+
+func (r *Resolver) GetProviders(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, ReadRoles...); err != nil {
+		return nil, err
+	}
+
+	limit, offset, err := pageArgs(params.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	specialty, _ := params.Args["specialty"].(string)
+
+	providers, err := r.providers.List(params.Context, strings.TrimSpace(specialty), limit, offset)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not list providers")
+	}
+
+	return providers, nil
+}
+
+// CreateProvider adds a provider to the directory, which only admins
+// maintain.
+func (r *Resolver) CreateProvider(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, middleware.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	provider := &store.Provider{}
+	provider.Name, _ = params.Args["name"].(string)
+	provider.Specialty, _ = params.Args["specialty"].(string)
+	provider.Name = strings.TrimSpace(provider.Name)
+	provider.Specialty = strings.TrimSpace(provider.Specialty)
+
+	if message := validation.ValidateName(provider.Name); message != "" {
+		return nil, validation.Errors{{Field: "name", Message: message}}
+	}
+
+	if err := r.providers.Create(params.Context, provider); err != nil {
+		return nil, dbError(params.Context, err, "could not create provider")
+	}
+
+	return provider, nil
+}
+
+// AssignProvider adds a provider to the care team of a patient and returns
+// the patient.
+func (r *Resolver) AssignProvider(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	patientID, _ := params.Args["patientId"].(int)
+	providerID, _ := params.Args["providerId"].(int)
+
+	err = r.providers.Assign(params.Context, userID, patientID, providerID)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d or provider %d not found", patientID, providerID)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not assign provider %d to patient %d", providerID, patientID)
+	}
+
+	return r.careTeamPatient(params.Context, patientID)
+}
+
+// UnassignProvider removes a provider from the care team of a patient and
+// returns the patient.
+func (r *Resolver) UnassignProvider(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, WriteRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	patientID, _ := params.Args["patientId"].(int)
+	providerID, _ := params.Args["providerId"].(int)
+
+	err = r.providers.Unassign(params.Context, userID, patientID, providerID)
+	if isNotFound(err) {
+		return nil, utils.NotFound("provider %d is not on the care team of patient %d", providerID, patientID)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not unassign provider %d from patient %d", providerID, patientID)
+	}
+
+	return r.careTeamPatient(params.Context, patientID)
+}
+
+// careTeamPatient returns the patient whose care team changed, including a
+// deleted one, since providers can be unassigned from those too.
+func (r *Resolver) careTeamPatient(ctx context.Context, patientID int) (interface{}, error) {
+	patient, err := r.patients.Get(ctx, patientID, true)
+	if err != nil {
+		return nil, dbError(ctx, err, "could not get patient %d", patientID)
+	}
+
+	return patient, nil
+}
+
+// PatientCareTeam resolves the careTeam field of a patient.
+func (r *Resolver) PatientCareTeam(params graphql.ResolveParams) (interface{}, error) {
+	patient, ok := params.Source.(*store.Patient)
+	if !ok {
+		return nil, nil
+	}
+
+	thunk := load(params.Context, careTeamLoaderKey{}, r.providers.CareTeams, patient.ID)
+
+	return func() (interface{}, error) {
+		providers, err := thunk()
+		if err != nil {
+			return nil, dbError(params.Context, err, "could not list the care team of patient %d", patient.ID)
+		}
+
+		return providers, nil
+	}, nil
+}
+
+// ProviderPanel resolves the panel field of a provider, the patients whose
+// care team the provider is on. Their reads are recorded in the audit log.
+func (r *Resolver) ProviderPanel(params graphql.ResolveParams) (interface{}, error) {
+	provider, ok := params.Source.(*store.Provider)
+	if !ok {
+		return nil, nil
+	}
+
+	userID, err := authorize(params.Context, ReadRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	thunk := load(params.Context, panelLoaderKey{}, r.providers.Panels, provider.ID)
+
+	return func() (interface{}, error) {
+		patients, err := thunk()
+		if err != nil {
+			return nil, dbError(params.Context, err, "could not list the patients of provider %d", provider.ID)
+		}
+
+		if err := r.logAccess(params.Context, userID, "read", patients...); err != nil {
+			return nil, err
+		}
+
+		return patients, nil
+	}, nil
+}
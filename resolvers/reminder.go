@@ -0,0 +1,27 @@
+package resolvers
+
+import (
+	"github.com/graphql-go/graphql"
+)
+
+// GetRemindersByPatient pages through the appointment reminders sent, or to
+// be sent, to a patient.
+func (r *Resolver) GetRemindersByPatient(params graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(params.Context, ReadRoles...); err != nil {
+		return nil, err
+	}
+
+	patientID, _ := params.Args["patientId"].(int)
+
+	limit, offset, err := pageArgs(params.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	reminders, err := r.reminders.ListByPatient(params.Context, patientID, limit, offset)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not list reminders of patient %d", patientID)
+	}
+
+	return reminders, nil
+}
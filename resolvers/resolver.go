@@ -0,0 +1,178 @@
+// Package resolvers implements the GraphQL resolvers on top of the store
+// repositories, which are passed in so they can be replaced in tests.
+package resolvers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/document"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// ReadRoles may run queries and WriteRoles may also run mutations.
+var (
+	WriteRoles = []string{middleware.RoleAdmin, middleware.RoleClinician}
+	ReadRoles  = []string{middleware.RoleAdmin, middleware.RoleClinician, middleware.RoleReadonly}
+)
+
+// AuditLog reads the recorded patient mutations and records reads of
+// patient records.
+type AuditLog interface {
+	Entries(ctx context.Context, patientID, limit, offset int) ([]*audit.Entry, error)
+	Search(ctx context.Context, filter audit.Filter, limit, offset int) ([]*audit.Entry, int, error)
+	LogAccess(ctx context.Context, operation, performedBy string, patientIDs []int) error
+}
+
+// Resolver holds the dependencies of the resolvers. Its methods are
+// graphql.FieldResolveFn values wired into the schema.
+type Resolver struct {
+	patients     store.PatientRepository
+	appointments store.AppointmentRepository
+	providers    store.ProviderRepository
+	encounters   store.EncounterRepository
+	contacts     store.EmergencyContactRepository
+	notes        store.NoteRepository
+	policies     store.InsurancePolicyRepository
+	consents     store.ConsentRepository
+	duplicates   store.DuplicateReportRepository
+	clinics      store.ClinicRepository
+	reminders    store.ReminderRepository
+	documents    store.DocumentRepository
+	// signer signs the download links of documents; nil when documents are
+	// not stored.
+	signer *document.Signer
+	// idempotencyKeys keeps the idempotency keys of create mutations for
+	// idempotencyTTL.
+	idempotencyKeys store.IdempotencyKeyRepository
+	idempotencyTTL  time.Duration
+	// tx runs the writes of mutations touching several repositories in one
+	// transaction.
+	tx       store.Transactor
+	auditLog AuditLog
+	events   Events
+}
+
+func New(patients store.PatientRepository, appointments store.AppointmentRepository, providers store.ProviderRepository,
+	encounters store.EncounterRepository, contacts store.EmergencyContactRepository, notes store.NoteRepository, policies store.InsurancePolicyRepository,
+	consents store.ConsentRepository, duplicates store.DuplicateReportRepository,
+	clinics store.ClinicRepository, reminders store.ReminderRepository, documents store.DocumentRepository, signer *document.Signer,
+	idempotencyKeys store.IdempotencyKeyRepository, idempotencyTTL time.Duration,
+	tx store.Transactor, auditLog AuditLog, events Events) *Resolver {
+	return &Resolver{
+		patients:        patients,
+		appointments:    appointments,
+		providers:       providers,
+		encounters:      encounters,
+		contacts:        contacts,
+		notes:           notes,
+		policies:        policies,
+		consents:        consents,
+		duplicates:      duplicates,
+		clinics:         clinics,
+		reminders:       reminders,
+		documents:       documents,
+		signer:          signer,
+		idempotencyKeys: idempotencyKeys,
+		idempotencyTTL:  idempotencyTTL,
+		tx:              tx,
+		auditLog:        auditLog,
+		events:          events,
+	}
+}
+
+// authorize returns utils.ErrUnauthenticated when ctx has no signed in caller
+// and utils.ErrForbidden when the caller has none of roles. The caller's user
+// id is returned otherwise.
+func authorize(ctx context.Context, roles ...string) (string, error) {
+	userID, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		return "", utils.ErrUnauthenticated
+	}
+
+	if !middleware.HasRole(ctx, roles...) {
+		return "", utils.ErrForbidden
+	}
+
+	return userID, nil
+}
+
+// dbError logs a failed database operation at ERROR level and returns an
+// error that only tells clients which operation failed, so SQL and driver
+// details stay in the logs. Coded errors meant for clients, such as an
+// appointment conflict, are returned as they are, and operations cut off by
+// the request timeout fail with TIMEOUT. It returns nil when err is nil.
+func dbError(ctx context.Context, err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	var coded *utils.CodedError
+	if errors.As(err, &coded) {
+		return coded
+	}
+
+	operation := fmt.Sprintf(format, args...)
+
+	// The driver reports a cancelled statement as its own error, so the
+	// context tells whether the timeout caused it.
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		slog.WarnContext(ctx, "database operation timed out", "operation", operation, "error", err)
+		return &utils.CodedError{Code: "TIMEOUT", Message: operation + ": the request timed out"}
+	}
+
+	slog.ErrorContext(ctx, "database error", "operation", operation, "error", err)
+
+	return &utils.InternalError{Message: operation, Err: err}
+}
+
+// isNotFound reports whether a repository could not find the requested row.
+func isNotFound(err error) bool {
+	return errors.Is(err, store.ErrNotFound)
+}
+
+// pageArgs reads and checks the limit and offset arguments, clamping limit to
+// MaxPageLimit.
+func pageArgs(args map[string]interface{}) (limit, offset int, err error) {
+	limit, _ = args["limit"].(int)
+	offset, _ = args["offset"].(int)
+
+	if limit < 1 {
+		return 0, 0, fmt.Errorf("limit must be a positive number")
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	if offset < 0 {
+		return 0, 0, fmt.Errorf("offset must not be negative")
+	}
+
+	return limit, offset, nil
+}
+
+// timeArg parses the RFC 3339 argument name, returning nil when it was not
+// given.
+func timeArg(args map[string]interface{}, name string) (*time.Time, error) {
+	value, ok := args[name].(string)
+	if !ok {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 time: %v", name, err)
+	}
+
+	return &t, nil
+}
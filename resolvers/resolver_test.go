@@ -0,0 +1,185 @@
+package resolvers_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/pubsub"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/schema"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/store/memory"
+)
+
+var testSecret = []byte("test-secret-of-at-least-32-bytes!")
+
+// testEnv is the schema served from memory repositories, or from the ones
+// of testRepos that are set, as the server wires it.
+type testEnv struct {
+	db       *memory.DB
+	resolver *resolvers.Resolver
+	schema   graphql.Schema
+}
+
+// testRepos replace the memory repositories of a testEnv, whose database is
+// db, or a new one when it is nil.
+type testRepos struct {
+	db           *memory.DB
+	patients     store.PatientRepository
+	appointments store.AppointmentRepository
+	notes        store.NoteRepository
+}
+
+func newTestEnv(t testing.TB) *testEnv {
+	return newTestEnvWith(t, testRepos{})
+}
+
+// newTestEnvWith serves from the repositories of repos that are set rather
+// than from the memory stores.
+func newTestEnvWith(t testing.TB, repos testRepos) *testEnv {
+	t.Helper()
+
+	db := repos.db
+	if db == nil {
+		db = memory.New()
+	}
+	patients := repos.patients
+	if patients == nil {
+		patients = memory.NewPatientStore(db)
+	}
+	appointments := repos.appointments
+	if appointments == nil {
+		appointments = memory.NewAppointmentStore(db)
+	}
+	notes := repos.notes
+	if notes == nil {
+		notes = memory.NewNoteStore(db)
+	}
+
+	resolver := resolvers.New(
+		patients,
+		appointments,
+		memory.NewProviderStore(db),
+		memory.NewEncounterStore(db),
+		memory.NewEmergencyContactStore(db),
+		notes,
+		memory.NewInsurancePolicyStore(db),
+		memory.NewConsentStore(db),
+		memory.NewDuplicateReportStore(db),
+		memory.NewClinicStore(db),
+		memory.NewReminderStore(db),
+		memory.NewDocumentStore(db),
+		nil,
+		memory.NewIdempotencyKeyStore(db),
+		time.Hour,
+		memory.NewTxStore(db),
+		memory.NewAuditLogger(db),
+		pubsub.NewBroker(),
+	)
+
+	s, _, err := schema.New(resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testEnv{db: db, resolver: resolver, schema: s}
+}
+
+// asUser returns a context authenticated as user with roles, acting for
+// clinic 1.
+func asUser(t testing.TB, user string, roles ...string) context.Context {
+	t.Helper()
+
+	clinic := 1
+	token, err := middleware.IssueToken(testSecret, user, roles, &clinic, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := middleware.Authenticate(context.Background(), "Bearer "+token, "", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ctx
+}
+
+func admin(t testing.TB) context.Context {
+	return asUser(t, "admin-1", middleware.RoleAdmin)
+}
+
+func clinician(t testing.TB) context.Context {
+	return asUser(t, "clinician-1", middleware.RoleClinician)
+}
+
+// do runs query with variables as ctx.
+func (e *testEnv) do(ctx context.Context, query string, variables map[string]interface{}) *graphql.Result {
+	return graphql.Do(graphql.Params{
+		Context:        e.resolver.WithLoaders(ctx),
+		Schema:         e.schema,
+		RequestString:  query,
+		VariableValues: variables,
+	})
+}
+
+// mustDo runs query like do, failing the test on any error, and decodes the
+// data of the result into data.
+func (e *testEnv) mustDo(t testing.TB, ctx context.Context, query string, variables map[string]interface{}, data interface{}) {
+	t.Helper()
+
+	result := e.do(ctx, query, variables)
+	if result.HasErrors() {
+		t.Fatalf("%s: %v", query, result.Errors)
+	}
+
+	decode(t, result.Data, data)
+}
+
+// errorCode returns the code of the first error of result, or "" when it
+// has none.
+func errorCode(t testing.TB, result *graphql.Result) string {
+	t.Helper()
+
+	if !result.HasErrors() {
+		return ""
+	}
+
+	code, _ := result.Errors[0].Extensions["code"].(string)
+	return code
+}
+
+func decode(t testing.TB, value, target interface{}) {
+	t.Helper()
+
+	if target == nil {
+		return
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(b, target); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createPatient creates a patient as ctx and returns its id.
+func (e *testEnv) createPatient(t testing.TB, ctx context.Context, name, email, phone string) int {
+	t.Helper()
+
+	var data struct {
+		Create struct{ ID int }
+	}
+	e.mustDo(t, ctx, `mutation($name: String, $email: String!, $phone: String!) {
+		create(name: $name, email: $email, phone: $phone) { id }
+	}`, map[string]interface{}{"name": name, "email": email, "phone": phone}, &data)
+
+	return data.Create.ID
+}
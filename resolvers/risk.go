@@ -0,0 +1,179 @@
+package resolvers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// The risk levels of computePatientRiskScore, from the lowest.
+const (
+	RiskLow      = "low"
+	RiskMedium   = "medium"
+	RiskHigh     = "high"
+	RiskCritical = "critical"
+)
+
+// RiskScore is the triage risk of a patient computed from their notes and
+// the chief complaints and notes of their encounters.
+type RiskScore struct {
+	Score float64 `json:"score"`
+	Level string  `json:"level"`
+	// Factors are the risk factors found, in the order of riskFactors.
+	Factors []string `json:"factors"`
+}
+
+// riskFactors are the keywords the risk score looks for, matched by stem so
+// that "allergies" and "allergic" count as allergy, with what each adds to
+// the score when found, however often.
+var riskFactors = []struct {
+	factor string
+	stem   string
+	weight float64
+}{
+	{"diabetes", "diabet", 3},
+	{"hypertension", "hypertens", 2},
+	{"allergy", "allerg", 1},
+}
+
+// negations are the words ruling out a risk factor named shortly after them
+// in the same clause, as in "no known allergies" or "denies hypertension".
+var negations = map[string]bool{"no": true, "not": true, "denies": true, "denied": true, "without": true, "negative": true}
+
+// negationWindow is the number of words before a risk factor searched for a
+// negation.
+const negationWindow = 3
+
+// mentions reports whether text names stem other than right after a
+// negation. Only such simple negations are recognized: "allergy test came
+// back negative" or "allergies were ruled out" still count as the factor.
+func mentions(text, stem string) bool {
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], stem)
+		if i < 0 {
+			return false
+		}
+		i += offset
+
+		if !negated(text[:i]) {
+			return true
+		}
+		offset = i + len(stem)
+	}
+}
+
+// negated reports whether one of the last negationWindow words of before,
+// in the clause it ends with, is a negation. A "but" ends the clause as
+// well, so "no diabetes but hypertension" names hypertension.
+func negated(before string) bool {
+	words := strings.Fields(before[strings.LastIndexAny(before, ".,;:!?()\n")+1:])
+	for i := len(words) - 1; i >= 0 && i >= len(words)-negationWindow; i-- {
+		if words[i] == "but" {
+			return false
+		}
+		if negations[words[i]] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// riskLevel returns the level of a risk score: low without factors, medium
+// up to 2, high up to 4 and critical above.
+func riskLevel(score float64) string {
+	switch {
+	case score == 0:
+		return RiskLow
+	case score <= 2:
+		return RiskMedium
+	case score <= 4:
+		return RiskHigh
+	default:
+		return RiskCritical
+	}
+}
+
+// ComputePatientRiskScore scores a patient who is not deleted by the risk
+// factors named in their notes and the current revisions of their
+// encounters, recording the read in the audit log. Factors named only after a
+// simple negation, such as "no known allergies", are left out (see mentions).
+func (r *Resolver) ComputePatientRiskScore(params graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(params.Context, ReadRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := params.Args["patientId"].(int)
+
+	patient, err := r.patients.Get(params.Context, id, false)
+	if isNotFound(err) {
+		return nil, utils.NotFound("patient %d not found", id)
+	}
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not get patient %d", id)
+	}
+
+	encounters, err := r.allEncounters(params.Context, id)
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not list encounters of patient %d", id)
+	}
+
+	notes, err := r.notes.ListByPatients(params.Context, []int{id})
+	if err != nil {
+		return nil, dbError(params.Context, err, "could not list notes of patient %d", id)
+	}
+
+	if err := r.logAccess(params.Context, userID, "read", patient); err != nil {
+		return nil, err
+	}
+
+	var text strings.Builder
+	for _, encounter := range encounters {
+		text.WriteString(strings.ToLower(encounter.ChiefComplaint))
+		text.WriteString("\n")
+		text.WriteString(strings.ToLower(encounter.Notes))
+		text.WriteString("\n")
+	}
+	for _, note := range notes[id] {
+		text.WriteString(strings.ToLower(note.Body))
+		text.WriteString("\n")
+	}
+
+	score := &RiskScore{Factors: []string{}}
+	for _, f := range riskFactors {
+		if mentions(text.String(), f.stem) {
+			score.Score += f.weight
+			score.Factors = append(score.Factors, f.factor)
+		}
+	}
+	score.Level = riskLevel(score.Score)
+
+	return score, nil
+}
+
+// allEncounters returns every encounter of a patient, a page at a time.
+func (r *Resolver) allEncounters(ctx context.Context, patientID int) ([]*store.Encounter, error) {
+	var encounters []*store.Encounter
+
+	for offset := 0; ; offset += MaxPageLimit {
+		pages, err := r.encounters.ListByPatients(ctx, []int{patientID}, MaxPageLimit, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		page := pages[patientID]
+		if page == nil {
+			return encounters, nil
+		}
+		encounters = append(encounters, page.Encounters...)
+
+		if len(page.Encounters) < MaxPageLimit {
+			return encounters, nil
+		}
+	}
+}
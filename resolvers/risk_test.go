@@ -0,0 +1,128 @@
+package resolvers_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+)
+
+type riskScore struct {
+	Score   float64
+	Level   string
+	Factors []string
+}
+
+func TestComputePatientRiskScore(t *testing.T) {
+	tests := []struct {
+		name string
+		// encounters are the chief complaint and notes of each encounter.
+		encounters [][2]string
+		// amended replaces the notes of the first encounter, when set.
+		amended string
+		// notes are the bodies of the notes of the patient.
+		notes []string
+		want  riskScore
+	}{
+		{"no encounters", nil, "", nil, riskScore{0, "LOW", []string{}}},
+		{"no factors", [][2]string{{"Checkup", "All well"}}, "", nil, riskScore{0, "LOW", []string{}}},
+		{"allergy", [][2]string{{"Rash", "Allergic to penicillin"}}, "", nil, riskScore{1, "MEDIUM", []string{"allergy"}}},
+		{
+			"two factors in two encounters",
+			[][2]string{{"Follow-up", "Type 2 Diabetes, well controlled"}, {"Headache", "History of hypertension"}},
+			"", nil, riskScore{5, "CRITICAL", []string{"diabetes", "hypertension"}},
+		},
+		{
+			"hypertension and allergies",
+			[][2]string{{"Hypertension review", "Seasonal allergies"}},
+			"", nil, riskScore{3, "HIGH", []string{"hypertension", "allergy"}},
+		},
+		{"a factor repeated", [][2]string{{"Hypertension", "hypertension again"}}, "", nil, riskScore{2, "MEDIUM", []string{"hypertension"}}},
+		{"a note", nil, "", []string{"Type 1 diabetic since childhood"}, riskScore{3, "HIGH", []string{"diabetes"}}},
+		{
+			"notes and encounters",
+			[][2]string{{"Headache", "Hypertension suspected"}}, "", []string{"Allergic to latex"},
+			riskScore{3, "HIGH", []string{"hypertension", "allergy"}},
+		},
+		{"no known allergies", [][2]string{{"Checkup", "No known allergies"}}, "", nil, riskScore{0, "LOW", []string{}}},
+		{
+			"negated factors",
+			[][2]string{{"Checkup", "Denies allergies. Not diabetic, hypertension treated"}}, "", []string{"Patient without hypertension"},
+			riskScore{2, "MEDIUM", []string{"hypertension"}},
+		},
+		{"past a but", [][2]string{{"Rash", "No fever but allergic to latex"}}, "", nil, riskScore{1, "MEDIUM", []string{"allergy"}}},
+		{"negated and named", nil, "", []string{"No allergies reported", "Allergic to latex"}, riskScore{1, "MEDIUM", []string{"allergy"}}},
+		{"amended away", [][2]string{{"Checkup", "Suspected diabetes"}}, "Ruled out", nil, riskScore{0, "LOW", []string{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			ctx := clinician(t)
+			patientID := env.createPatient(t, ctx, "Ann Lee", "ann@example.com", "+14155550100")
+
+			for i, encounter := range tt.encounters {
+				var created struct{ CreateEncounter struct{ ID int } }
+				env.mustDo(t, ctx, `mutation($patientId: Int!, $chiefComplaint: String!, $notes: String) {
+					createEncounter(patientId: $patientId, encounteredAt: "2024-03-01T10:00:00Z", chiefComplaint: $chiefComplaint, notes: $notes) { id }
+				}`, map[string]interface{}{"patientId": patientID, "chiefComplaint": encounter[0], "notes": encounter[1]}, &created)
+
+				if i == 0 && tt.amended != "" {
+					env.mustDo(t, ctx, `mutation($id: Int!, $notes: String) { amendEncounter(id: $id, notes: $notes, reason: "Corrected") { id } }`,
+						map[string]interface{}{"id": created.CreateEncounter.ID, "notes": tt.amended}, nil)
+				}
+			}
+
+			if len(tt.notes) > 0 {
+				notes := []interface{}{}
+				for _, body := range tt.notes {
+					notes = append(notes, map[string]interface{}{"patientId": patientID, "body": body})
+				}
+				env.mustDo(t, ctx, `mutation($notes: [NoteInput!]!) { batchCreateNotes(notes: $notes) { created } }`,
+					map[string]interface{}{"notes": notes}, nil)
+			}
+
+			var data struct{ ComputePatientRiskScore riskScore }
+			env.mustDo(t, asUser(t, "readonly-1", middleware.RoleReadonly), `query($patientId: Int!) {
+				computePatientRiskScore(patientId: $patientId) { score level factors }
+			}`, map[string]interface{}{"patientId": patientID}, &data)
+			if !reflect.DeepEqual(data.ComputePatientRiskScore, tt.want) {
+				t.Errorf("computePatientRiskScore = %+v, want %+v", data.ComputePatientRiskScore, tt.want)
+			}
+
+			var log struct {
+				GetAuditLog []struct{ Operation, PerformedBy string }
+			}
+			env.mustDo(t, ctx, `query($patientId: Int!) { getAuditLog(patientId: $patientId, limit: 1) { operation performedBy } }`,
+				map[string]interface{}{"patientId": patientID}, &log)
+			if len(log.GetAuditLog) != 1 || log.GetAuditLog[0].Operation != "read" || log.GetAuditLog[0].PerformedBy != "readonly-1" {
+				t.Errorf("latest audit entries = %+v, want a read by readonly-1", log.GetAuditLog)
+			}
+		})
+	}
+}
+
+func TestComputePatientRiskScoreErrors(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := clinician(t)
+	deletedID := env.createPatient(t, ctx, "Ann Lee", "ann@example.com", "+14155550100")
+	env.mustDo(t, ctx, `mutation($id: Int!) { delete(id: $id) { id } }`, map[string]interface{}{"id": deletedID}, nil)
+
+	tests := []struct {
+		name      string
+		patientID int
+	}{
+		{"unknown patient", 999},
+		{"deleted patient", deletedID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := env.do(ctx, `query($patientId: Int!) { computePatientRiskScore(patientId: $patientId) { score } }`,
+				map[string]interface{}{"patientId": tt.patientID})
+			if code := errorCode(t, result); code != "NOT_FOUND" {
+				t.Errorf("code = %q, want NOT_FOUND: %v", code, result.Errors)
+			}
+		})
+	}
+}
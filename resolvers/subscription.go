@@ -0,0 +1,118 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/tenant"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// The Subscription root fields, which name the event topics the patient
+// mutations publish to with Topic.
+const (
+	PatientCreated = "patientCreated"
+	PatientUpdated = "patientUpdated"
+	PatientDeleted = "patientDeleted"
+	// PatientBulkImportCompleted sends a *BulkImportCompleted rather than a
+	// patient.
+	PatientBulkImportCompleted = "patientBulkImportCompleted"
+)
+
+// SubscriptionRoot is the key of the payload of the event, the changed
+// patient for most fields, in the root object a subscription event is
+// executed against.
+const SubscriptionRoot = "patient"
+
+// BulkImportCompletedEvent is the Event of a BulkImportCompleted.
+const BulkImportCompletedEvent = "patient.bulk_import_completed"
+
+// BulkImportCompleted is published to PatientBulkImportCompleted when a CSV
+// import of patients finishes. ImportedCount is how many rows were
+// imported, whether they created, updated or matched a patient.
+type BulkImportCompleted struct {
+	Event         string    `json:"event"`
+	ImportedCount int       `json:"importedCount"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// Topic returns the topic the events of the Subscription field about the
+// patients of a clinic are published to, so subscribers only receive the
+// changes of their own clinic.
+func Topic(field string, clinicID int) string {
+	return fmt.Sprintf("%s:%d", field, clinicID)
+}
+
+// Events publishes patient changes and delivers them to subscribers.
+type Events interface {
+	Publish(topic string, payload interface{})
+	Subscribe(topic string) (<-chan interface{}, func())
+}
+
+// Subscribe checks that the caller in ctx may read patients and subscribes to
+// the events of the Subscription field in the clinic ctx acts for. The
+// returned function unsubscribes.
+func (r *Resolver) Subscribe(ctx context.Context, field string) (<-chan interface{}, func(), error) {
+	if _, err := authorize(ctx, ReadRoles...); err != nil {
+		return nil, nil, err
+	}
+
+	switch field {
+	case PatientCreated, PatientUpdated, PatientDeleted, PatientBulkImportCompleted:
+	default:
+		return nil, nil, fmt.Errorf("unknown subscription %q", field)
+	}
+
+	clinicID, ok := tenant.ClinicFromContext(ctx)
+	if !ok {
+		return nil, nil, utils.ErrNoClinic
+	}
+
+	events, unsubscribe := r.events.Subscribe(Topic(field, clinicID))
+	return events, unsubscribe, nil
+}
+
+// PatientEvent resolves a Subscription field to the patient of the event
+// being delivered, which is recorded as a read by the subscriber.
+func (r *Resolver) PatientEvent(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := authorize(p.Context, ReadRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	root, _ := p.Source.(map[string]interface{})
+	patient, ok := root[SubscriptionRoot].(*store.Patient)
+	if !ok {
+		return nil, nil
+	}
+
+	if err := r.logAccess(p.Context, userID, "subscription", patient); err != nil {
+		return nil, err
+	}
+
+	return patient, nil
+}
+
+// BulkImportEvent resolves the PatientBulkImportCompleted field to the
+// import being delivered.
+func (r *Resolver) BulkImportEvent(p graphql.ResolveParams) (interface{}, error) {
+	if _, err := authorize(p.Context, ReadRoles...); err != nil {
+		return nil, err
+	}
+
+	root, _ := p.Source.(map[string]interface{})
+	event, ok := root[SubscriptionRoot].(*BulkImportCompleted)
+	if !ok {
+		return nil, nil
+	}
+
+	return event, nil
+}
+
+func (r *Resolver) publish(topic string, patient *store.Patient) {
+	r.events.Publish(Topic(topic, patient.ClinicID), patient)
+}
@@ -0,0 +1,257 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// registerAppointments contributes the appointments of patients and the
+// mutations booking and changing them. It returns the appointment input,
+// which registration takes as well.
+func registerAppointments(m *Module, r *resolvers.Resolver, patientType *graphql.Object) *graphql.InputObject {
+	m.Cost("getAppointmentsByPatient", 5)
+	m.Cost("getAppointmentsByDateRange", 10)
+	m.Cost("getClinicianSchedule", 10)
+	m.Cost("appointments", 5)
+
+	appointmentStatusType := m.Enum(
+		graphql.EnumConfig{
+			Name: "AppointmentStatus",
+			Values: graphql.EnumValueConfigMap{
+				"SCHEDULED": &graphql.EnumValueConfig{Value: "scheduled"},
+				"COMPLETED": &graphql.EnumValueConfig{Value: "completed"},
+				"CANCELLED": &graphql.EnumValueConfig{Value: "cancelled"},
+				"NO_SHOW":   &graphql.EnumValueConfig{Value: "no_show"},
+			},
+		},
+	)
+
+	appointmentType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "Appointment",
+			Description: "A scheduled visit of a patient.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"patientId": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"scheduledAt": &graphql.Field{
+					Type:        graphql.String,
+					Description: "When the appointment takes place, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						appointment, ok := params.Source.(*store.Appointment)
+						if !ok {
+							return nil, nil
+						}
+
+						return appointment.ScheduledAt.Format(time.RFC3339), nil
+					},
+				},
+				"endsAt": &graphql.Field{
+					Type:        graphql.String,
+					Description: "When the appointment ends, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						appointment, ok := params.Source.(*store.Appointment)
+						if !ok {
+							return nil, nil
+						}
+
+						return appointment.EndsAt.Format(time.RFC3339), nil
+					},
+				},
+				"providerId": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"reason": &graphql.Field{
+					Type: graphql.String,
+				},
+				"notes": &graphql.Field{
+					Type: graphql.String,
+				},
+				"status": &graphql.Field{
+					Type: appointmentStatusType,
+				},
+				"patient": &graphql.Field{
+					Type: patientType,
+				},
+			},
+		},
+	)
+
+	m.Extend(patientType, "appointments", &graphql.Field{
+		Type:        graphql.NewList(appointmentType),
+		Description: "The patient's appointments, soonest first.",
+		Resolve:     r.PatientAppointments,
+	})
+
+	appointmentInputType := m.InputObject(
+		graphql.InputObjectConfig{
+			Name:        "AppointmentInput",
+			Description: "An appointment to book, with the arguments of createAppointment but the patient.",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"providerId": &graphql.InputObjectFieldConfig{
+					Type: graphql.Int,
+				},
+				"scheduledAt": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"endsAt": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"reason": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"notes": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	m.Query("getAppointment", &graphql.Field{
+		Type:        appointmentType,
+		Description: "Get an appointment by id",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.GetAppointment,
+	})
+
+	m.Query("getAppointmentsByPatient", &graphql.Field{
+		Type:        graphql.NewList(appointmentType),
+		Description: "Lists the appointments of a patient, soonest first",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.GetAppointmentsByPatient,
+	})
+
+	m.Query("getAppointmentsByDateRange", &graphql.Field{
+		Type:        graphql.NewList(appointmentType),
+		Description: "Lists the appointments overlapping from and to (RFC 3339), soonest first, optionally of one provider",
+		Args: graphql.FieldConfigArgument{
+			"from": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"to": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"providerId": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+		},
+		Resolve: r.GetAppointmentsByDateRange,
+	})
+
+	scheduledPatientType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "ScheduledPatient",
+			Description: "A patient on the schedule of a clinician.",
+			Fields: graphql.Fields{
+				"patient": &graphql.Field{
+					Type: graphql.NewNonNull(patientType),
+				},
+				"appointment": &graphql.Field{
+					Type:        graphql.NewNonNull(appointmentType),
+					Description: "The appointment the patient is on the schedule for.",
+				},
+			},
+		},
+	)
+
+	m.Query("getClinicianSchedule", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(scheduledPatientType))),
+		Description: "Lists the patients who are not deleted of the scheduled appointments of a provider starting on a date such as 2024-06-30 (UTC), soonest first",
+		Args: graphql.FieldConfigArgument{
+			"clinicianId": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "The id of the provider.",
+			},
+			"date": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+		},
+		Resolve: r.GetClinicianSchedule,
+	})
+
+	m.Mutation("createAppointment", &graphql.Field{
+		Type:        appointmentType,
+		Description: "Schedules an appointment for a patient. Times are in RFC 3339 format and endsAt defaults to 30 minutes after scheduledAt. Overlapping bookings of the same provider are rejected.",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"providerId": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"scheduledAt": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"endsAt": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"reason": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"notes": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"idempotencyKey": idempotencyKeyArgument(),
+		},
+		Resolve: r.CreateAppointment,
+	})
+
+	m.Mutation("rescheduleAppointment", &graphql.Field{
+		Type:        appointmentType,
+		Description: "Moves a scheduled appointment. Times are in RFC 3339 format and the appointment keeps its duration unless endsAt is given.",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"scheduledAt": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"endsAt": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: r.RescheduleAppointment,
+	})
+
+	m.Mutation("updateAppointmentStatus", &graphql.Field{
+		Type:        appointmentType,
+		Description: "Changes the status of an appointment",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"status": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(appointmentStatusType),
+			},
+		},
+		Resolve: r.UpdateAppointmentStatus,
+	})
+
+	m.Mutation("cancelAppointment", &graphql.Field{
+		Type:        appointmentType,
+		Description: "Cancels an appointment",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.CancelAppointment,
+	})
+
+	return appointmentInputType
+}
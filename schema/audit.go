@@ -0,0 +1,161 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+)
+
+// registerAudit contributes the audit log queries.
+func registerAudit(m *Module, r *resolvers.Resolver) {
+	m.Cost("getAuditLog", 10)
+	m.Cost("getAuditEntries", 10)
+	m.Cost("getAuditEntriesAcrossClinics", 10)
+
+	auditEntryType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "AuditEntry",
+			Description: "A recorded patient mutation or read. oldValue and newValue are JSON encoded patients, and null for reads.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"operation": &graphql.Field{
+					Type: graphql.String,
+				},
+				"patientId": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"performedBy": &graphql.Field{
+					Type: graphql.String,
+				},
+				"clientIp": &graphql.Field{
+					Type: graphql.String,
+				},
+				"clinicId": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "The clinic of the patient.",
+				},
+				"occurredAt": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						entry, ok := params.Source.(*audit.Entry)
+						if !ok {
+							return nil, nil
+						}
+
+						return entry.OccurredAt.Format(time.RFC3339), nil
+					},
+				},
+				"oldValue": &graphql.Field{
+					Type: graphql.String,
+				},
+				"newValue": &graphql.Field{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	auditEntryConnectionType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "AuditEntryConnection",
+			Description: "A page of audit entries.",
+			Fields: graphql.Fields{
+				"entries": &graphql.Field{
+					Type: graphql.NewList(auditEntryType),
+				},
+				"totalCount": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"hasNextPage": &graphql.Field{
+					Type: graphql.Boolean,
+				},
+			},
+		},
+	)
+
+	m.Query("getAuditLog", &graphql.Field{
+		Type:        graphql.NewList(auditEntryType),
+		Description: "Lists the recorded mutations and reads of a patient, newest first. limit defaults to 20 and is clamped to 100.",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: resolvers.DefaultPageLimit,
+			},
+			"offset": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+			},
+		},
+		Resolve: r.GetAuditLog,
+	})
+
+	m.Query("getAuditEntries", &graphql.Field{
+		Type:        auditEntryConnectionType,
+		Description: "Pages through the audit log of all patients, newest first, optionally filtered by patient, user, operation (e.g. read, search, export, create, update) and an RFC 3339 time range. Only admins may call it.",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"performedBy": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"operation": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"from": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"to": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: resolvers.DefaultPageLimit,
+			},
+			"offset": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+			},
+		},
+		Resolve: r.GetAuditEntries,
+	})
+
+	m.Query("getAuditEntriesAcrossClinics", &graphql.Field{
+		Type:        auditEntryConnectionType,
+		Description: "getAuditEntries over the audit log of every clinic. Only admins may call it.",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"performedBy": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"operation": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"from": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"to": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: resolvers.DefaultPageLimit,
+			},
+			"offset": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+			},
+		},
+		Resolve: r.GetAuditEntriesAcrossClinics,
+	})
+}
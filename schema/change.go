@@ -0,0 +1,115 @@
+package schema
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/outbox"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// registerChanges contributes the change feed query, with the current
+// patients of the changes.
+func registerChanges(m *Module, r *resolvers.Resolver, patientType *graphql.Object) {
+	m.Cost("patientChanges", 10)
+
+	patientChangeTypeType := m.Enum(
+		graphql.EnumConfig{
+			Name:        "PatientChangeType",
+			Description: "How a patient changed. DELETED covers soft deletes and merges into another patient, PURGED the permanent removal of a deleted patient.",
+			Values: graphql.EnumValueConfigMap{
+				"CREATED": &graphql.EnumValueConfig{Value: outbox.PatientCreated},
+				"UPDATED": &graphql.EnumValueConfig{Value: outbox.PatientUpdated},
+				"DELETED": &graphql.EnumValueConfig{Value: outbox.PatientDeleted},
+				"ERASED":  &graphql.EnumValueConfig{Value: outbox.PatientErased},
+				"PURGED":  &graphql.EnumValueConfig{Value: store.PatientPurged},
+			},
+		},
+	)
+
+	patientChangeType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "PatientChange",
+			Description: "A change to a patient, from the change feed.",
+			Fields: graphql.Fields{
+				"cursor": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The place of the change in the feed, to pass as since to read the changes after it.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						change, ok := params.Source.(*store.PatientChangeEvent)
+						if !ok {
+							return nil, nil
+						}
+
+						return change.Cursor.String(), nil
+					},
+				},
+				"type": &graphql.Field{
+					Type: graphql.NewNonNull(patientChangeTypeType),
+				},
+				"patientId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"clinicId": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "The clinic of the patient.",
+				},
+				"changedAt": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the change was made, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						change, ok := params.Source.(*store.PatientChangeEvent)
+						if !ok {
+							return nil, nil
+						}
+
+						return change.ChangedAt.Format(time.RFC3339), nil
+					},
+				},
+				"patient": &graphql.Field{
+					Type:        patientType,
+					Description: fmt.Sprintf("The patient as it is now, which later changes may have changed again, or null once it was purged or unless the patient has a DATA_SHARING consent in effect for %q.", resolvers.ChangeFeedConsentScope),
+				},
+			},
+		},
+	)
+
+	patientChangeConnectionType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "PatientChangeConnection",
+			Description: "A page of the change feed.",
+			Fields: graphql.Fields{
+				"changes": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(patientChangeType))),
+				},
+				"endCursor": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The cursor of the last change of the page, or since when the page is empty. Pass it as since to read on.",
+				},
+				"hasNextPage": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Boolean),
+				},
+			},
+		},
+	)
+
+	m.Query("patientChanges", &graphql.Field{
+		Type: patientChangeConnectionType,
+		Description: fmt.Sprintf("Pages through the changes to the patients of the clinic in the order they were made, every created, updated, deleted, erased and purged patient, for systems keeping a copy in sync. Start without since and pass the endCursor of each page as the since of the next; a page may be empty while changes are still being committed, so poll again with the same cursor. first defaults to %d and is clamped to %d. Only the patients with a DATA_SHARING consent in effect for the %q scope are returned, and they are recorded in the audit log.",
+			resolvers.DefaultChangesLimit, resolvers.MaxChangesLimit, resolvers.ChangeFeedConsentScope),
+		Args: graphql.FieldConfigArgument{
+			"since": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "The cursor of the last change already read.",
+			},
+			"first": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: resolvers.DefaultChangesLimit,
+			},
+		},
+		Resolve: r.PatientChanges,
+	})
+}
@@ -0,0 +1,93 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// registerClinics contributes the clinics and the patient list across them,
+// filtered and sorted like getPatients.
+func registerClinics(m *Module, r *resolvers.Resolver, patientConnectionType *graphql.Object, patientFilterInputType *graphql.InputObject, patientSortFieldType, sortOrderType *graphql.Enum) {
+	m.Cost("getClinics", 10)
+	m.Cost("getPatientsAcrossClinics", 10)
+
+	clinicType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "Clinic",
+			Description: "A clinic sharing the deployment. Every patient and the records about them belong to one.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"name": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"createdAt": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the clinic was created, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						clinic, ok := params.Source.(*store.Clinic)
+						if !ok {
+							return nil, nil
+						}
+
+						return clinic.CreatedAt.Format(time.RFC3339), nil
+					},
+				},
+			},
+		},
+	)
+
+	m.Query("getClinics", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(clinicType))),
+		Description: "Lists the clinics by id. Only admins may call it.",
+		Resolve:     r.GetClinics,
+	})
+
+	m.Query("getPatientsAcrossClinics", &graphql.Field{
+		Type:        patientConnectionType,
+		Description: "getPatients over the patients of every clinic. The fields of the patients other than their own, such as appointments, are still those of the clinic of the request. Only admins may call it.",
+		Args: graphql.FieldConfigArgument{
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: resolvers.DefaultPageLimit,
+			},
+			"offset": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+			},
+			"filter": &graphql.ArgumentConfig{
+				Type: patientFilterInputType,
+			},
+			"includeDeleted": &graphql.ArgumentConfig{
+				Type:         graphql.Boolean,
+				DefaultValue: false,
+				Description:  "Also return soft-deleted patients. Only admins may set it.",
+			},
+			"sortBy": &graphql.ArgumentConfig{
+				Type:         patientSortFieldType,
+				DefaultValue: "id",
+			},
+			"sortOrder": &graphql.ArgumentConfig{
+				Type:         sortOrderType,
+				DefaultValue: "asc",
+			},
+		},
+		Resolve: r.GetPatientsAcrossClinics,
+	})
+
+	m.Mutation("createClinic", &graphql.Field{
+		Type:        graphql.NewNonNull(clinicType),
+		Description: "Adds a clinic. Only admins may call it.",
+		Args: graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+		},
+		Resolve: r.CreateClinic,
+	})
+}
@@ -0,0 +1,198 @@
+package schema
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// registerConsents contributes the consents of patients.
+func registerConsents(m *Module, r *resolvers.Resolver, patientType *graphql.Object) {
+	m.Cost("consents", 5)
+	m.Cost("consentStatus", 5)
+
+	consentTypeType := m.Enum(
+		graphql.EnumConfig{
+			Name:        "ConsentType",
+			Description: "What a patient consents to.",
+			Values: graphql.EnumValueConfigMap{
+				"TREATMENT": &graphql.EnumValueConfig{
+					Value:       store.ConsentTreatment,
+					Description: "Being treated by the clinic.",
+				},
+				"DATA_SHARING": &graphql.EnumValueConfig{
+					Value:       store.ConsentDataSharing,
+					Description: fmt.Sprintf("Their data being shared with other systems. patientChanges only returns the patients with one in effect for the %q scope.", resolvers.ChangeFeedConsentScope),
+				},
+				"MARKETING": &graphql.EnumValueConfig{
+					Value:       store.ConsentMarketing,
+					Description: "Being contacted about services of the clinic.",
+				},
+			},
+		},
+	)
+
+	consentType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "Consent",
+			Description: "A consent of a patient, in effect from grantedAt until it is revoked.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"patientId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"type": &graphql.Field{
+					Type: graphql.NewNonNull(consentTypeType),
+				},
+				"scope": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: fmt.Sprintf("What the consent was given for: %q for everything, or an integration such as %q.", store.ConsentScopeAll, resolvers.ChangeFeedConsentScope),
+				},
+				"active": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Boolean),
+					Description: "False once the consent was revoked.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						consent, ok := params.Source.(*store.Consent)
+						if !ok {
+							return nil, nil
+						}
+
+						return consent.RevokedAt == nil, nil
+					},
+				},
+				"grantedAt": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the patient gave the consent, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						consent, ok := params.Source.(*store.Consent)
+						if !ok {
+							return nil, nil
+						}
+
+						return consent.GrantedAt.Format(time.RFC3339), nil
+					},
+				},
+				"recordedBy": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The staff member who recorded the consent.",
+				},
+				"revokedAt": &graphql.Field{
+					Type:        graphql.String,
+					Description: "When the consent was revoked, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						consent, ok := params.Source.(*store.Consent)
+						if !ok || consent.RevokedAt == nil {
+							return nil, nil
+						}
+
+						return consent.RevokedAt.Format(time.RFC3339), nil
+					},
+				},
+				"revokedBy": &graphql.Field{
+					Type:        graphql.String,
+					Description: "The staff member who revoked the consent.",
+				},
+				"createdAt": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the consent was recorded, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						consent, ok := params.Source.(*store.Consent)
+						if !ok {
+							return nil, nil
+						}
+
+						return consent.CreatedAt.Format(time.RFC3339), nil
+					},
+				},
+			},
+		},
+	)
+
+	consentStatusType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "ConsentStatus",
+			Description: "Which types of consent of a patient are in effect.",
+			Fields: graphql.Fields{
+				"treatment": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Boolean),
+				},
+				"dataSharing": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Boolean),
+				},
+				"marketing": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Boolean),
+				},
+			},
+		},
+	)
+
+	m.Extend(patientType, "consents", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(consentType))),
+		Description: "The patient's consents, those in effect first, then the most recently granted first.",
+		Resolve:     r.PatientConsents,
+	})
+
+	m.Extend(patientType, "consentStatus", &graphql.Field{
+		Type:        graphql.NewNonNull(consentStatusType),
+		Description: fmt.Sprintf("Which types of consent of the patient are in effect for scope, given for it or for %q.", store.ConsentScopeAll),
+		Args: graphql.FieldConfigArgument{
+			"scope": &graphql.ArgumentConfig{
+				Type:         graphql.String,
+				DefaultValue: store.ConsentScopeAll,
+			},
+		},
+		Resolve: r.PatientConsentStatus,
+	})
+
+	consentInputType := m.InputObject(
+		graphql.InputObjectConfig{
+			Name: "ConsentInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"type": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(consentTypeType),
+				},
+				"scope": &graphql.InputObjectFieldConfig{
+					Type:         graphql.String,
+					DefaultValue: store.ConsentScopeAll,
+					Description:  fmt.Sprintf("What the consent is given for, up to %d letters, digits, underscores and hyphens.", validation.MaxConsentScopeLength),
+				},
+				"grantedAt": &graphql.InputObjectFieldConfig{
+					Type:        graphql.String,
+					Description: "When the patient gave the consent, in RFC 3339 format, now by default.",
+				},
+			},
+		},
+	)
+
+	m.Mutation("grantConsent", &graphql.Field{
+		Type:        graphql.NewNonNull(consentType),
+		Description: "Records a consent given by a patient. A consent of the same type and scope in effect fails with CONSENT_ALREADY_GRANTED.",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"consent": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(consentInputType),
+			},
+		},
+		Resolve: r.GrantConsent,
+	})
+
+	m.Mutation("revokeConsent", &graphql.Field{
+		Type:        graphql.NewNonNull(consentType),
+		Description: "Revokes a consent in effect. It stays listed with active false, as a record of what was consented to.",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.RevokeConsent,
+	})
+}
@@ -0,0 +1,78 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// registerContacts contributes the emergency contacts of patients. It
+// returns their input, which registration takes.
+func registerContacts(m *Module, r *resolvers.Resolver, patientType *graphql.Object) *graphql.InputObject {
+	m.Cost("emergencyContacts", 5)
+
+	emergencyContactType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "EmergencyContact",
+			Description: "A person to call about a patient.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"patientId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"name": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"relationship": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "How the contact is related to the patient, such as spouse or parent.",
+				},
+				"phone": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"createdAt": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the contact was added, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						contact, ok := params.Source.(*store.EmergencyContact)
+						if !ok {
+							return nil, nil
+						}
+
+						return contact.CreatedAt.Format(time.RFC3339), nil
+					},
+				},
+			},
+		},
+	)
+
+	m.Extend(patientType, "emergencyContacts", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(emergencyContactType))),
+		Description: "The patient's emergency contacts, oldest first.",
+		Resolve:     r.PatientEmergencyContacts,
+	})
+
+	emergencyContactInputType := m.InputObject(
+		graphql.InputObjectConfig{
+			Name: "EmergencyContactInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"name": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"relationship": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"phone": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+		},
+	)
+
+	return emergencyContactInputType
+}
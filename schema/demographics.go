@@ -0,0 +1,382 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// demographicsTypes are the types of the demographics of patients, shared by
+// the Patient type and the mutations writing patients.
+type demographicsTypes struct {
+	humanName, address, contactPoint, identifier                     *graphql.Object
+	gender, addressUse, contactPointSystem, contactPointUse, idType  *graphql.Enum
+	humanNameInput, addressInput, contactPointInput, identifierInput *graphql.InputObject
+}
+
+// registerDemographicsTypes contributes the types of the structured names,
+// addresses, contact points and identifiers of patients to the patients
+// module.
+func registerDemographicsTypes(m *Module) demographicsTypes {
+	var t demographicsTypes
+
+	t.gender = m.Enum(
+		graphql.EnumConfig{
+			Name:        "Gender",
+			Description: "The administrative gender of a patient, as in FHIR.",
+			Values: graphql.EnumValueConfigMap{
+				"MALE":    &graphql.EnumValueConfig{Value: store.GenderMale},
+				"FEMALE":  &graphql.EnumValueConfig{Value: store.GenderFemale},
+				"OTHER":   &graphql.EnumValueConfig{Value: store.GenderOther},
+				"UNKNOWN": &graphql.EnumValueConfig{Value: store.GenderUnknown},
+			},
+		},
+	)
+
+	t.addressUse = m.Enum(
+		graphql.EnumConfig{
+			Name: "AddressUse",
+			Values: graphql.EnumValueConfigMap{
+				"HOME":    &graphql.EnumValueConfig{Value: store.UseHome},
+				"WORK":    &graphql.EnumValueConfig{Value: store.UseWork},
+				"TEMP":    &graphql.EnumValueConfig{Value: store.UseTemp},
+				"OLD":     &graphql.EnumValueConfig{Value: store.UseOld},
+				"BILLING": &graphql.EnumValueConfig{Value: store.UseBilling},
+			},
+		},
+	)
+
+	t.contactPointSystem = m.Enum(
+		graphql.EnumConfig{
+			Name:        "ContactPointSystem",
+			Description: "How a contact point reaches the patient. PHONE, FAX and SMS values are E.164 numbers.",
+			Values: graphql.EnumValueConfigMap{
+				"PHONE": &graphql.EnumValueConfig{Value: store.TelecomPhone},
+				"FAX":   &graphql.EnumValueConfig{Value: store.TelecomFax},
+				"EMAIL": &graphql.EnumValueConfig{Value: store.TelecomEmail},
+				"PAGER": &graphql.EnumValueConfig{Value: store.TelecomPager},
+				"URL":   &graphql.EnumValueConfig{Value: store.TelecomURL},
+				"SMS":   &graphql.EnumValueConfig{Value: store.TelecomSMS},
+				"OTHER": &graphql.EnumValueConfig{Value: store.TelecomOther},
+			},
+		},
+	)
+
+	t.contactPointUse = m.Enum(
+		graphql.EnumConfig{
+			Name: "ContactPointUse",
+			Values: graphql.EnumValueConfigMap{
+				"HOME":   &graphql.EnumValueConfig{Value: store.UseHome},
+				"WORK":   &graphql.EnumValueConfig{Value: store.UseWork},
+				"TEMP":   &graphql.EnumValueConfig{Value: store.UseTemp},
+				"OLD":    &graphql.EnumValueConfig{Value: store.UseOld},
+				"MOBILE": &graphql.EnumValueConfig{Value: store.UseMobile},
+			},
+		},
+	)
+
+	t.idType = m.Enum(
+		graphql.EnumConfig{
+			Name:        "IdentifierType",
+			Description: "What an identifier of a patient is: a medical record number, a national identity number such as a social security number, a passport number or another identifier.",
+			Values: graphql.EnumValueConfigMap{
+				"MRN":         &graphql.EnumValueConfig{Value: store.IdentifierMRN},
+				"NATIONAL_ID": &graphql.EnumValueConfig{Value: store.IdentifierNationalID},
+				"PASSPORT":    &graphql.EnumValueConfig{Value: store.IdentifierPassport},
+				"OTHER":       &graphql.EnumValueConfig{Value: store.IdentifierOther},
+			},
+		},
+	)
+
+	t.humanName = m.Object(
+		graphql.ObjectConfig{
+			Name:        "HumanName",
+			Description: "The name of a patient in parts.",
+			Fields: graphql.Fields{
+				"prefix": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"given": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+					Description: "The first and middle names, in order.",
+				},
+				"family": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"suffix": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+		},
+	)
+
+	t.address = m.Object(
+		graphql.ObjectConfig{
+			Name: "Address",
+			Fields: graphql.Fields{
+				"use": &graphql.Field{
+					Type: t.addressUse,
+				},
+				"lines": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+				},
+				"city": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"state": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"postalCode": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"country": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "An ISO 3166-1 alpha-2 code such as DE, or empty.",
+				},
+			},
+		},
+	)
+
+	t.contactPoint = m.Object(
+		graphql.ObjectConfig{
+			Name:        "ContactPoint",
+			Description: "A way to reach a patient other than their email and phone.",
+			Fields: graphql.Fields{
+				"system": &graphql.Field{
+					Type: graphql.NewNonNull(t.contactPointSystem),
+				},
+				"value": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"use": &graphql.Field{
+					Type: t.contactPointUse,
+				},
+			},
+		},
+	)
+
+	t.identifier = m.Object(
+		graphql.ObjectConfig{
+			Name:        "Identifier",
+			Description: "An identifier of a patient in another system, such as the medical record number of another clinic.",
+			Fields: graphql.Fields{
+				"type": &graphql.Field{
+					Type: graphql.NewNonNull(t.idType),
+				},
+				"system": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The namespace of value, such as a URI of the issuer, or empty.",
+				},
+				"value": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+		},
+	)
+
+	t.humanNameInput = m.InputObject(
+		graphql.InputObjectConfig{
+			Name:        "HumanNameInput",
+			Description: "The name of a patient in parts. Parts left out are empty, so {} clears the structured name.",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"prefix": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"given": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+					Description: "The first and middle names, in order.",
+				},
+				"family": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"suffix": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	t.addressInput = m.InputObject(
+		graphql.InputObjectConfig{
+			Name:        "AddressInput",
+			Description: "A postal address, which needs at least a line, a city or a postal code.",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"use": &graphql.InputObjectFieldConfig{
+					Type: t.addressUse,
+				},
+				"lines": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewList(graphql.NewNonNull(graphql.String)),
+				},
+				"city": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"state": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"postalCode": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"country": &graphql.InputObjectFieldConfig{
+					Type:        graphql.String,
+					Description: "An ISO 3166-1 alpha-2 code such as DE.",
+				},
+			},
+		},
+	)
+
+	t.contactPointInput = m.InputObject(
+		graphql.InputObjectConfig{
+			Name: "ContactPointInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"system": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(t.contactPointSystem),
+				},
+				"value": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"use": &graphql.InputObjectFieldConfig{
+					Type: t.contactPointUse,
+				},
+			},
+		},
+	)
+
+	t.identifierInput = m.InputObject(
+		graphql.InputObjectConfig{
+			Name: "IdentifierInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"type": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(t.idType),
+				},
+				"system": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"value": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+		},
+	)
+
+	return t
+}
+
+// fields returns the demographic fields of the Patient type.
+func (t demographicsTypes) fields() graphql.Fields {
+	return graphql.Fields{
+		"structuredName": &graphql.Field{
+			Type:        t.humanName,
+			Description: "The name of the patient in parts, null when it was only given as a whole in name.",
+			Resolve: demographic(func(d store.Demographics) interface{} {
+				if d.StructuredName.Empty() {
+					return nil
+				}
+				return d.StructuredName
+			}),
+		},
+		"birthDate": &graphql.Field{
+			Type:        graphql.String,
+			Description: "The day the patient was born, such as 1984-03-21.",
+			Resolve: demographic(func(d store.Demographics) interface{} {
+				if d.BirthDate == nil {
+					return nil
+				}
+				return d.BirthDate.Format(time.DateOnly)
+			}),
+		},
+		"gender": &graphql.Field{
+			Type: t.gender,
+			Resolve: demographic(func(d store.Demographics) interface{} {
+				if d.Gender == "" {
+					return nil
+				}
+				return d.Gender
+			}),
+		},
+		"preferredLanguage": &graphql.Field{
+			Type:        graphql.String,
+			Description: "The language the patient prefers to be addressed in, as a BCP 47 tag such as pt-BR.",
+			Resolve: demographic(func(d store.Demographics) interface{} {
+				if d.PreferredLanguage == "" {
+					return nil
+				}
+				return d.PreferredLanguage
+			}),
+		},
+		"addresses": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(t.address))),
+			Resolve: demographic(func(d store.Demographics) interface{} {
+				return append([]store.Address{}, d.Addresses...)
+			}),
+		},
+		"telecoms": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(t.contactPoint))),
+			Description: "The ways to reach the patient other than email and phone.",
+			Resolve: demographic(func(d store.Demographics) interface{} {
+				return append([]store.ContactPoint{}, d.Telecoms...)
+			}),
+		},
+		"identifiers": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(t.identifier))),
+			Resolve: demographic(func(d store.Demographics) interface{} {
+				return append([]store.Identifier{}, d.Identifiers...)
+			}),
+		},
+	}
+}
+
+// demographic resolves a field of the demographics of the patient source.
+func demographic(value func(store.Demographics) interface{}) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		patient, ok := params.Source.(*store.Patient)
+		if !ok {
+			return nil, nil
+		}
+
+		return value(patient.Demographics), nil
+	}
+}
+
+// arguments adds the demographic arguments of the mutations writing a
+// patient to args and returns it. Lists replace those of the patient, so []
+// clears them.
+func (t demographicsTypes) arguments(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	for name, field := range t.inputFields(graphql.InputObjectConfigFieldMap{}) {
+		args[name] = &graphql.ArgumentConfig{Type: field.Type, Description: field.Description}
+	}
+
+	return args
+}
+
+// inputFields adds the demographic fields of the inputs writing a patient
+// to fields and returns it.
+func (t demographicsTypes) inputFields(fields graphql.InputObjectConfigFieldMap) graphql.InputObjectConfigFieldMap {
+	fields["structuredName"] = &graphql.InputObjectFieldConfig{
+		Type: t.humanNameInput,
+	}
+	fields["birthDate"] = &graphql.InputObjectFieldConfig{
+		Type:        graphql.String,
+		Description: "A day such as 1984-03-21, or an empty string to clear it.",
+	}
+	fields["gender"] = &graphql.InputObjectFieldConfig{
+		Type: t.gender,
+	}
+	fields["preferredLanguage"] = &graphql.InputObjectFieldConfig{
+		Type:        graphql.String,
+		Description: "A BCP 47 tag such as pt-BR, or an empty string to clear it.",
+	}
+	fields["addresses"] = &graphql.InputObjectFieldConfig{
+		Type: graphql.NewList(graphql.NewNonNull(t.addressInput)),
+	}
+	fields["telecoms"] = &graphql.InputObjectFieldConfig{
+		Type:        graphql.NewList(graphql.NewNonNull(t.contactPointInput)),
+		Description: "The ways to reach the patient other than email and phone.",
+	}
+	fields["identifiers"] = &graphql.InputObjectFieldConfig{
+		Type: graphql.NewList(graphql.NewNonNull(t.identifierInput)),
+	}
+
+	return fields
+}
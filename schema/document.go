@@ -0,0 +1,101 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// registerDocuments contributes the documents of patients, which are
+// uploaded and downloaded over the REST routes of the server.
+func registerDocuments(m *Module, r *resolvers.Resolver, patientType *graphql.Object) {
+	m.Cost("documents", 5)
+
+	documentKindType := m.Enum(
+		graphql.EnumConfig{
+			Name: "DocumentKind",
+			Values: graphql.EnumValueConfigMap{
+				"INSURANCE_CARD":  &graphql.EnumValueConfig{Value: "insurance_card"},
+				"REFERRAL_LETTER": &graphql.EnumValueConfig{Value: "referral_letter"},
+				"LAB_RESULT":      &graphql.EnumValueConfig{Value: "lab_result"},
+				"OTHER":           &graphql.EnumValueConfig{Value: "other"},
+			},
+		},
+	)
+
+	documentType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "Document",
+			Description: "A file attached to a patient. Files are uploaded with a multipart POST to /patients/{id}/documents.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"patientId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"kind": &graphql.Field{
+					Type: graphql.NewNonNull(documentKindType),
+				},
+				"filename": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"contentType": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The type detected from the content, application/pdf, image/jpeg or image/png.",
+				},
+				"size": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "The size of the file in bytes.",
+				},
+				"sha256": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The hex SHA-256 of the file.",
+				},
+				"description": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"uploadedBy": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"createdAt": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the document was uploaded, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						d, ok := params.Source.(*store.Document)
+						if !ok {
+							return nil, nil
+						}
+
+						return d.CreatedAt.Format(time.RFC3339), nil
+					},
+				},
+				"downloadUrl": &graphql.Field{
+					Type:        graphql.String,
+					Description: "A link downloading the file without a bearer token, valid for a few minutes and recorded in the audit log as a read by the caller. Null when document storage is not configured.",
+					Resolve:     r.DocumentDownloadURL,
+				},
+			},
+		},
+	)
+
+	m.Extend(patientType, "documents", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(documentType))),
+		Description: "The documents attached to the patient, newest first.",
+		Resolve:     r.PatientDocuments,
+	})
+
+	m.Mutation("deleteDocument", &graphql.Field{
+		Type:        graphql.NewNonNull(documentType),
+		Description: "Removes a document from its patient. Its file is deleted from the storage shortly after.",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.DeleteDocument,
+	})
+}
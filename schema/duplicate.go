@@ -0,0 +1,139 @@
+package schema
+
+import (
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+)
+
+// registerDuplicates contributes the detection, reports and merging of
+// duplicate patients.
+func registerDuplicates(m *Module, r *resolvers.Resolver, patientType *graphql.Object) {
+	m.Cost("getPendingDuplicateReports", 10)
+	m.Cost("findDuplicatePatients", 10)
+
+	duplicatePatientsType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "DuplicatePatients",
+			Description: "Two patients found by findDuplicatePatients who are likely the same person.",
+			Fields: graphql.Fields{
+				"patient": &graphql.Field{
+					Type:        graphql.NewNonNull(patientType),
+					Description: "The patient created first.",
+				},
+				"duplicate": &graphql.Field{
+					Type: graphql.NewNonNull(patientType),
+				},
+				"score": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Float),
+					Description: "How alike their names are, from 0 to 1.",
+				},
+				"sharedEmail": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Boolean),
+				},
+				"sharedPhone": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Boolean),
+				},
+			},
+		},
+	)
+
+	duplicateReportStatusType := m.Enum(
+		graphql.EnumConfig{
+			Name: "DuplicateReportStatus",
+			Values: graphql.EnumValueConfigMap{
+				"PENDING":   &graphql.EnumValueConfig{Value: "pending"},
+				"REVIEWED":  &graphql.EnumValueConfig{Value: "reviewed"},
+				"DISMISSED": &graphql.EnumValueConfig{Value: "dismissed"},
+			},
+		},
+	)
+
+	duplicateReportType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "DuplicateReport",
+			Description: "A staff report that two patients may be the same person.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"reportedPatientId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"suspectedDuplicateId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"reportedBy": &graphql.Field{
+					Type: graphql.String,
+				},
+				"status": &graphql.Field{
+					Type: graphql.NewNonNull(duplicateReportStatusType),
+				},
+			},
+		},
+	)
+
+	m.Query("getPendingDuplicateReports", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(duplicateReportType))),
+		Description: "Lists duplicate reports still waiting for review. Only admins may call it.",
+		Resolve:     r.GetPendingDuplicateReports,
+	})
+
+	m.Query("findDuplicatePatients", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(duplicatePatientsType))),
+		Description: "Finds pairs of patients who are not deleted, share an email or phone number and have names at least minSimilarity alike, from 0 to 1 (default 0.4), the most alike first. limit defaults to 20 and is clamped to 100.",
+		Args: graphql.FieldConfigArgument{
+			"minSimilarity": &graphql.ArgumentConfig{
+				Type:         graphql.Float,
+				DefaultValue: resolvers.DefaultDuplicateSimilarity,
+			},
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: resolvers.DefaultPageLimit,
+			},
+		},
+		Resolve: r.FindDuplicatePatients,
+	})
+
+	m.Mutation("reportDuplicate", &graphql.Field{
+		Type:        graphql.NewNonNull(duplicateReportType),
+		Description: "Flags a patient as a suspected duplicate of another for review",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"suspectedDuplicateId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.ReportDuplicate,
+	})
+
+	m.Mutation("reviewDuplicateReport", &graphql.Field{
+		Type:        graphql.NewNonNull(duplicateReportType),
+		Description: "Marks a duplicate report as reviewed or dismissed. Only admins may call it.",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"status": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(duplicateReportStatusType),
+			},
+		},
+		Resolve: r.ReviewDuplicateReport,
+	})
+
+	m.Mutation("mergePatients", &graphql.Field{
+		Type:        patientType,
+		Description: "Merges a duplicate patient into the primary one and returns the primary: the duplicate's appointments, encounters, emergency contacts, notes, insurance policies, documents and care team move to the primary, except active primary policies overlapping the primary's, which are deactivated first, pending duplicate reports between them are marked reviewed, and the duplicate is soft-deleted with mergedIntoId set. The merge is recorded in the audit log of the duplicate.",
+		Args: graphql.FieldConfigArgument{
+			"primaryId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"duplicateId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.MergePatients,
+	})
+}
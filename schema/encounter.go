@@ -0,0 +1,228 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// registerEncounters contributes the encounters of patients and their
+// revisions.
+func registerEncounters(m *Module, r *resolvers.Resolver, patientType *graphql.Object) {
+	m.Cost("encounters", 5)
+	m.Cost("revisions", 5)
+
+	// encounterRevisionFields are shared by EncounterRevision and Encounter,
+	// which inlines its latest revision.
+	encounterRevisionFields := graphql.Fields{
+		"revision": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: resolveRevision(func(revision *store.EncounterRevision) interface{} {
+				return revision.Revision
+			}),
+		},
+		"chiefComplaint": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: resolveRevision(func(revision *store.EncounterRevision) interface{} {
+				return revision.ChiefComplaint
+			}),
+		},
+		"notes": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: resolveRevision(func(revision *store.EncounterRevision) interface{} {
+				return revision.Notes
+			}),
+		},
+		"diagnosisCodes": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+			Description: "ICD-10 codes, such as E11.65.",
+			Resolve: resolveRevision(func(revision *store.EncounterRevision) interface{} {
+				return revision.DiagnosisCodes
+			}),
+		},
+		"reason": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Why the encounter was amended, null for the first revision.",
+			Resolve: resolveRevision(func(revision *store.EncounterRevision) interface{} {
+				return revision.Reason
+			}),
+		},
+		"recordedBy": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: resolveRevision(func(revision *store.EncounterRevision) interface{} {
+				return revision.RecordedBy
+			}),
+		},
+		"recordedAt": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "When the revision was recorded, in RFC 3339 format.",
+			Resolve: resolveRevision(func(revision *store.EncounterRevision) interface{} {
+				return revision.RecordedAt.Format(time.RFC3339)
+			}),
+		},
+	}
+
+	encounterRevisionType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "EncounterRevision",
+			Description: "One version of the clinical content of an encounter. Revision 1 is the encounter as recorded and later ones are amendments.",
+			Fields:      encounterRevisionFields,
+		},
+	)
+
+	encounterType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "Encounter",
+			Description: "A visit of a patient, with the clinical content of its latest revision. Encounters are amended, never edited in place.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"patientId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"providerId": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"encounteredAt": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the visit took place, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						encounter, ok := params.Source.(*store.Encounter)
+						if !ok {
+							return nil, nil
+						}
+
+						return encounter.EncounteredAt.Format(time.RFC3339), nil
+					},
+				},
+				"createdBy": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"revisions": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(encounterRevisionType))),
+					Description: "Every revision of the encounter, oldest first.",
+					Resolve:     r.EncounterRevisions,
+				},
+			},
+		},
+	)
+
+	for name, field := range encounterRevisionFields {
+		encounterType.AddFieldConfig(name, field)
+	}
+
+	encounterConnectionType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "EncounterConnection",
+			Description: "A page of encounters.",
+			Fields: graphql.Fields{
+				"entries": &graphql.Field{
+					Type: graphql.NewList(encounterType),
+				},
+				"totalCount": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"hasNextPage": &graphql.Field{
+					Type: graphql.Boolean,
+				},
+			},
+		},
+	)
+
+	m.Extend(patientType, "encounters", &graphql.Field{
+		Type:        encounterConnectionType,
+		Description: "A page of the patient's encounters, newest first. limit defaults to 20 and is clamped to 100.",
+		Args: graphql.FieldConfigArgument{
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: resolvers.DefaultPageLimit,
+			},
+			"offset": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+			},
+		},
+		Resolve: r.PatientEncounters,
+	})
+
+	m.Query("getEncounter", &graphql.Field{
+		Type:        encounterType,
+		Description: "Get an encounter by id, with its latest revision",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.GetEncounter,
+	})
+
+	m.Mutation("createEncounter", &graphql.Field{
+		Type:        graphql.NewNonNull(encounterType),
+		Description: "Records an encounter of a patient. encounteredAt is in RFC 3339 format and diagnosisCodes are ICD-10 codes.",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"providerId": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"encounteredAt": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"chiefComplaint": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"notes": &graphql.ArgumentConfig{
+				Type:         graphql.String,
+				DefaultValue: "",
+			},
+			"diagnosisCodes": &graphql.ArgumentConfig{
+				Type: graphql.NewList(graphql.NewNonNull(graphql.String)),
+			},
+		},
+		Resolve: r.CreateEncounter,
+	})
+
+	m.Mutation("amendEncounter", &graphql.Field{
+		Type:        graphql.NewNonNull(encounterType),
+		Description: "Amends an encounter by recording a new revision, keeping the earlier ones. Fields left out keep their current value.",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"chiefComplaint": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"notes": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"diagnosisCodes": &graphql.ArgumentConfig{
+				Type: graphql.NewList(graphql.NewNonNull(graphql.String)),
+			},
+			"reason": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+		},
+		Resolve: r.AmendEncounter,
+	})
+}
+
+// resolveRevision resolves a field of an encounter revision with field. An
+// Encounter source resolves it from its latest revision, which the default
+// resolver cannot reach since it does not look into embedded structs.
+func resolveRevision(field func(*store.EncounterRevision) interface{}) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		switch source := params.Source.(type) {
+		case *store.EncounterRevision:
+			return field(source), nil
+		case *store.Encounter:
+			return field(&source.EncounterRevision), nil
+		}
+
+		return nil, nil
+	}
+}
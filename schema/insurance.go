@@ -0,0 +1,228 @@
+package schema
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// registerInsurance contributes the insurance policies of patients.
+func registerInsurance(m *Module, r *resolvers.Resolver, patientType *graphql.Object) {
+	m.Cost("insurancePolicies", 5)
+
+	policyPriorityType := m.Enum(
+		graphql.EnumConfig{
+			Name:        "PolicyPriority",
+			Description: "The order the policies of a patient are billed in. A patient has at most one active PRIMARY policy in effect on any day.",
+			Values: graphql.EnumValueConfigMap{
+				"PRIMARY":   &graphql.EnumValueConfig{Value: "primary"},
+				"SECONDARY": &graphql.EnumValueConfig{Value: "secondary"},
+				"TERTIARY":  &graphql.EnumValueConfig{Value: "tertiary"},
+			},
+		},
+	)
+
+	insurancePolicyType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "InsurancePolicy",
+			Description: "The coverage of a patient by a payer, in effect from effectiveDate through expiryDate until it is deactivated.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"patientId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"payer": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The insurer or plan paying for the care.",
+				},
+				"memberId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"groupNumber": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The group number of employer plans, empty when there is none.",
+				},
+				"effectiveDate": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The first day of coverage, such as 2024-01-01.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						policy, ok := params.Source.(*store.InsurancePolicy)
+						if !ok {
+							return nil, nil
+						}
+
+						return policy.EffectiveDate.Format(time.DateOnly), nil
+					},
+				},
+				"expiryDate": &graphql.Field{
+					Type:        graphql.String,
+					Description: "The last day of coverage, null when the policy does not expire.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						policy, ok := params.Source.(*store.InsurancePolicy)
+						if !ok || policy.ExpiryDate == nil {
+							return nil, nil
+						}
+
+						return policy.ExpiryDate.Format(time.DateOnly), nil
+					},
+				},
+				"priority": &graphql.Field{
+					Type: graphql.NewNonNull(policyPriorityType),
+				},
+				"active": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Boolean),
+					Description: "False once the policy was deactivated.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						policy, ok := params.Source.(*store.InsurancePolicy)
+						if !ok {
+							return nil, nil
+						}
+
+						return policy.DeactivatedAt == nil, nil
+					},
+				},
+				"eligibleOn": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Boolean),
+					Description: "Whether the policy is active and in effect on date, such as 2024-06-30, today in UTC by default.",
+					Args: graphql.FieldConfigArgument{
+						"date": &graphql.ArgumentConfig{
+							Type: graphql.String,
+						},
+					},
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						policy, ok := params.Source.(*store.InsurancePolicy)
+						if !ok {
+							return nil, nil
+						}
+
+						day := time.Now().UTC().Truncate(24 * time.Hour)
+						if date, ok := params.Args["date"].(string); ok {
+							var err error
+							if day, err = time.Parse(time.DateOnly, date); err != nil {
+								return nil, fmt.Errorf("date must be a date such as 2024-06-30, got %q", date)
+							}
+						}
+
+						return policy.Overlaps(&store.InsurancePolicy{EffectiveDate: day, ExpiryDate: &day}), nil
+					},
+				},
+				"deactivatedAt": &graphql.Field{
+					Type:        graphql.String,
+					Description: "When the policy was deactivated, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						policy, ok := params.Source.(*store.InsurancePolicy)
+						if !ok || policy.DeactivatedAt == nil {
+							return nil, nil
+						}
+
+						return policy.DeactivatedAt.Format(time.RFC3339), nil
+					},
+				},
+				"createdAt": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the policy was added, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						policy, ok := params.Source.(*store.InsurancePolicy)
+						if !ok {
+							return nil, nil
+						}
+
+						return policy.CreatedAt.Format(time.RFC3339), nil
+					},
+				},
+				"updatedAt": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the policy was last changed, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						policy, ok := params.Source.(*store.InsurancePolicy)
+						if !ok {
+							return nil, nil
+						}
+
+						return policy.UpdatedAt.Format(time.RFC3339), nil
+					},
+				},
+			},
+		},
+	)
+
+	m.Extend(patientType, "insurancePolicies", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(insurancePolicyType))),
+		Description: "The patient's insurance policies, the active ones first, then by priority and the most recent first.",
+		Resolve:     r.PatientInsurancePolicies,
+	})
+
+	insurancePolicyInputType := m.InputObject(
+		graphql.InputObjectConfig{
+			Name: "InsurancePolicyInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"payer": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"memberId": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"groupNumber": &graphql.InputObjectFieldConfig{
+					Type:         graphql.String,
+					DefaultValue: "",
+				},
+				"effectiveDate": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The first day of coverage, such as 2024-01-01.",
+				},
+				"expiryDate": &graphql.InputObjectFieldConfig{
+					Type:        graphql.String,
+					Description: "The last day of coverage, left out when the policy does not expire.",
+				},
+				"priority": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(policyPriorityType),
+				},
+			},
+		},
+	)
+
+	m.Mutation("addInsurancePolicy", &graphql.Field{
+		Type:        graphql.NewNonNull(insurancePolicyType),
+		Description: "Adds an insurance policy to a patient. A PRIMARY policy in effect on a day another active PRIMARY policy of the patient is fails with PRIMARY_POLICY_CONFLICT.",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"policy": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(insurancePolicyInputType),
+			},
+		},
+		Resolve: r.AddInsurancePolicy,
+	})
+
+	m.Mutation("updateInsurancePolicy", &graphql.Field{
+		Type:        graphql.NewNonNull(insurancePolicyType),
+		Description: "Replaces the fields of an active insurance policy, checked like addInsurancePolicy. Deactivated policies cannot be changed.",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"policy": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(insurancePolicyInputType),
+			},
+		},
+		Resolve: r.UpdateInsurancePolicy,
+	})
+
+	m.Mutation("deactivateInsurancePolicy", &graphql.Field{
+		Type:        graphql.NewNonNull(insurancePolicyType),
+		Description: "Ends an insurance policy. It stays listed with active false, as a record of past coverage.",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.DeactivateInsurancePolicy,
+	})
+}
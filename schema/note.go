@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// registerNotes contributes the clinical notes of patients and their bulk
+// creation.
+func registerNotes(m *Module, r *resolvers.Resolver, patientType *graphql.Object) {
+	m.Cost("notes", 5)
+	m.Cost("batchCreateNotes", 10)
+
+	noteType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "Note",
+			Description: "A free-text clinical note about a patient.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"patientId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"body": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"createdBy": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"createdAt": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the note was added, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						note, ok := params.Source.(*store.Note)
+						if !ok {
+							return nil, nil
+						}
+
+						return note.CreatedAt.Format(time.RFC3339), nil
+					},
+				},
+			},
+		},
+	)
+
+	m.Extend(patientType, "notes", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(noteType))),
+		Description: "The patient's notes, oldest first.",
+		Resolve:     r.PatientNotes,
+	})
+
+	noteInputType := m.InputObject(
+		graphql.InputObjectConfig{
+			Name: "NoteInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"patientId": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"body": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: fmt.Sprintf("Trimmed, and at most %d characters.", validation.MaxNoteLength),
+				},
+			},
+		},
+	)
+
+	batchNoteErrorType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "BatchNoteError",
+			Description: "Why one note of batchCreateNotes was not created.",
+			Fields: graphql.Fields{
+				"index": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "The position of the note in the input list.",
+				},
+				"patientId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"code": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "BAD_USER_INPUT for an invalid body, or NOT_FOUND for an unknown or deleted patient.",
+				},
+				"message": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+		},
+	)
+
+	batchCreateNotesResultType := m.Object(
+		graphql.ObjectConfig{
+			Name: "BatchCreateNotesResult",
+			Fields: graphql.Fields{
+				"created": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "How many notes were created.",
+				},
+				"errors": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(batchNoteErrorType))),
+					Description: "The notes that were not created, in the order of the input.",
+				},
+			},
+		},
+	)
+
+	m.Mutation("batchCreateNotes", &graphql.Field{
+		Type:        graphql.NewNonNull(batchCreateNotesResultType),
+		Description: fmt.Sprintf("Adds up to %d notes to patients in one transaction, such as the clinical summaries of a legacy system. Notes that are invalid or whose patient is unknown or deleted are reported in errors and the others are still created. Each note is recorded in the audit log of its patient.", resolvers.MaxBatchSize),
+		Args: graphql.FieldConfigArgument{
+			"notes": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(noteInputType))),
+			},
+		},
+		Resolve: r.BatchCreateNotes,
+	})
+}
@@ -0,0 +1,648 @@
+package schema
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// patientTypes are the types of the patients module the other modules build
+// on.
+type patientTypes struct {
+	patient      *graphql.Object
+	connection   *graphql.Object
+	filter       *graphql.InputObject
+	sortField    *graphql.Enum
+	sortOrder    *graphql.Enum
+	demographics demographicsTypes
+}
+
+// registerPatients contributes the Patient type with its demographics, the
+// queries listing and searching patients, the mutations writing them, one at
+// a time or in bulk, and the subscriptions to their changes. It returns the types the other
+// modules build on.
+func registerPatients(m *Module, r *resolvers.Resolver) patientTypes {
+	m.Cost("getPatients", 10)
+	m.Cost("searchPatients", 10)
+	m.Cost("createPatients", 10)
+	m.Cost("updatePatients", 10)
+
+	demographics := registerDemographicsTypes(m)
+
+	patientType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "Patient",
+			Description: "This is a patient type.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"name": &graphql.Field{
+					Type: graphql.String,
+				},
+				"email": &graphql.Field{
+					Type: graphql.String,
+				},
+				"phone": &graphql.Field{
+					Type: graphql.String,
+				},
+				"deletedAt": &graphql.Field{
+					Type:        graphql.String,
+					Description: "When the patient was soft-deleted, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						patient, ok := params.Source.(*store.Patient)
+						if !ok || patient.DeletedAt == nil {
+							return nil, nil
+						}
+
+						return patient.DeletedAt.Format(time.RFC3339), nil
+					},
+				},
+				"mergedIntoId": &graphql.Field{
+					Type:        graphql.Int,
+					Description: "The patient this one was merged into by mergePatients, which also soft-deleted it.",
+				},
+				"version": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "Goes up with every change to the patient. Pass it to update so changes made since it was read are not overwritten.",
+				},
+				"clinicId": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "The clinic the patient belongs to.",
+				},
+				"erasedAt": &graphql.Field{
+					Type:        graphql.String,
+					Description: "When the patient was anonymized by erasePatient, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						patient, ok := params.Source.(*store.Patient)
+						if !ok || patient.ErasedAt == nil {
+							return nil, nil
+						}
+
+						return patient.ErasedAt.Format(time.RFC3339), nil
+					},
+				},
+			},
+		},
+	)
+	for name, field := range demographics.fields() {
+		m.Extend(patientType, name, field)
+	}
+
+	patientConnectionType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "PatientConnection",
+			Description: "A page of patients.",
+			Fields: graphql.Fields{
+				"patients": &graphql.Field{
+					Type: graphql.NewList(patientType),
+				},
+				"totalCount": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"hasNextPage": &graphql.Field{
+					Type: graphql.Boolean,
+				},
+			},
+		},
+	)
+
+	searchHighlightType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "SearchHighlight",
+			Description: "A field of a patient containing the search term.",
+			Fields: graphql.Fields{
+				"field": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "name, email or phone.",
+				},
+				"snippet": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The HTML-escaped value of the field with the matches wrapped in <mark> tags.",
+				},
+			},
+		},
+	)
+
+	patientSearchResultType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "PatientSearchResult",
+			Description: "A patient found by searchPatients.",
+			Fields: graphql.Fields{
+				"patient": &graphql.Field{
+					Type: graphql.NewNonNull(patientType),
+				},
+				"score": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Float),
+					Description: "How well the patient matched, from 0 to 1. Exact substring matches score 1.",
+				},
+				"highlights": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(searchHighlightType))),
+					Description: "The fields containing the term. Matches on a misspelled name may have none.",
+				},
+			},
+		},
+	)
+
+	patientFilterInputType := m.InputObject(
+		graphql.InputObjectConfig{
+			Name:        "PatientFilterInput",
+			Description: "Case-insensitive filters on patient fields, combined with AND.",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"name": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"email": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"phone": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"emailEquals": &graphql.InputObjectFieldConfig{
+					Type:        graphql.String,
+					Description: "Matches the whole email address, ignoring case.",
+				},
+			},
+		},
+	)
+
+	patientSortFieldType := m.Enum(
+		graphql.EnumConfig{
+			Name:        "PatientSortField",
+			Description: "The patient fields getPatients can sort by.",
+			Values: graphql.EnumValueConfigMap{
+				"ID":    &graphql.EnumValueConfig{Value: "id"},
+				"NAME":  &graphql.EnumValueConfig{Value: "name"},
+				"EMAIL": &graphql.EnumValueConfig{Value: "email"},
+			},
+		},
+	)
+
+	sortOrderType := m.Enum(
+		graphql.EnumConfig{
+			Name: "SortOrder",
+			Values: graphql.EnumValueConfigMap{
+				"ASC":  &graphql.EnumValueConfig{Value: "asc"},
+				"DESC": &graphql.EnumValueConfig{Value: "desc"},
+			},
+		},
+	)
+
+	batchModeType := m.Enum(
+		graphql.EnumConfig{
+			Name:        "BatchMode",
+			Description: "How a bulk mutation writes its patients: ATOMIC in one transaction, none of them when one fails, or BEST_EFFORT each on its own.",
+			Values: graphql.EnumValueConfigMap{
+				"ATOMIC":      &graphql.EnumValueConfig{Value: resolvers.BatchAtomic},
+				"BEST_EFFORT": &graphql.EnumValueConfig{Value: resolvers.BatchBestEffort},
+			},
+		},
+	)
+
+	fieldErrorType := m.Object(
+		graphql.ObjectConfig{
+			Name: "FieldError",
+			Fields: graphql.Fields{
+				"field": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"message": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+		},
+	)
+
+	itemErrorType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "ItemError",
+			Description: "Why one patient of a bulk mutation was not written.",
+			Fields: graphql.Fields{
+				"code": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The code the mutation of this patient alone would have failed with, such as BAD_USER_INPUT or VERSION_CONFLICT, or NOT_WRITTEN for the valid patients of an ATOMIC batch that failed on another one.",
+				},
+				"message": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"fields": &graphql.Field{
+					Type:        graphql.NewList(graphql.NewNonNull(fieldErrorType)),
+					Description: "The invalid fields, for BAD_USER_INPUT errors.",
+				},
+			},
+		},
+	)
+
+	patientResultType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "PatientResult",
+			Description: "The outcome of one patient of a bulk mutation, with either patient or error set.",
+			Fields: graphql.Fields{
+				"index": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "The position of the patient in the input list.",
+				},
+				"patient": &graphql.Field{
+					Type: patientType,
+				},
+				"error": &graphql.Field{
+					Type: itemErrorType,
+				},
+			},
+		},
+	)
+
+	patientInputType := m.InputObject(
+		graphql.InputObjectConfig{
+			Name: "PatientInput",
+			Fields: demographics.inputFields(graphql.InputObjectConfigFieldMap{
+				"name": &graphql.InputObjectFieldConfig{
+					Type:        graphql.String,
+					Description: "Required unless structuredName is given, whose parts then make the name.",
+				},
+				"email": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"phone": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			}),
+		},
+	)
+
+	patientUpdateInputType := m.InputObject(
+		graphql.InputObjectConfig{
+			Name:        "PatientUpdateInput",
+			Description: "The changes to one patient, like the arguments of update.",
+			Fields: demographics.inputFields(graphql.InputObjectConfigFieldMap{
+				"id": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"version": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "The version of the patient the changes were made to.",
+				},
+				"name": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"email": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"phone": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+			}),
+		},
+	)
+
+	m.Query("getPatient", &graphql.Field{
+		Type:        patientType,
+		Description: "Get a patient by id",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"includeDeleted": &graphql.ArgumentConfig{
+				Type:         graphql.Boolean,
+				DefaultValue: false,
+				Description:  "Also return soft-deleted patients. Only admins may set it.",
+			},
+		},
+		Resolve: r.GetPatient,
+	})
+
+	m.Query("getPatients", &graphql.Field{
+		Type:        patientConnectionType,
+		Description: "Gets a page of patients. limit defaults to 20 and values above 100 are clamped to 100.",
+		Args: graphql.FieldConfigArgument{
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: resolvers.DefaultPageLimit,
+			},
+			"offset": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+			},
+			"filter": &graphql.ArgumentConfig{
+				Type: patientFilterInputType,
+			},
+			"includeDeleted": &graphql.ArgumentConfig{
+				Type:         graphql.Boolean,
+				DefaultValue: false,
+				Description:  "Also return soft-deleted patients. Only admins may set it.",
+			},
+			"sortBy": &graphql.ArgumentConfig{
+				Type:         patientSortFieldType,
+				DefaultValue: "id",
+			},
+			"sortOrder": &graphql.ArgumentConfig{
+				Type:         sortOrderType,
+				DefaultValue: "asc",
+			},
+		},
+		Resolve: r.GetPatients,
+	})
+
+	statusCountType := m.Object(
+		graphql.ObjectConfig{
+			Name: "StatusCount",
+			Fields: graphql.Fields{
+				"status": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "active, or deleted for the soft-deleted patients.",
+				},
+				"count": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+			},
+		},
+	)
+
+	m.Query("patientStatusSummary", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(statusCountType))),
+		Description: "Counts the patients by status, active or deleted, leaving out the statuses no patient has.",
+		Resolve:     r.GetPatientStatusSummary,
+	})
+
+	m.Query("searchPatients", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(patientSearchResultType))),
+		Description: "Finds patients who are not deleted by part of their name or email, a misspelling of their name, or digits of their phone number such as the last four, best matches first. term must be at least 3 characters. limit defaults to 20 and is clamped to 100.",
+		Args: graphql.FieldConfigArgument{
+			"term": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: resolvers.DefaultPageLimit,
+			},
+		},
+		Resolve: r.SearchPatients,
+	})
+
+	m.Mutation("create", &graphql.Field{
+		Type:        patientType,
+		Description: "Creates a new patient. name may be left out when structuredName is given, whose parts then make the name.",
+		Args: demographics.arguments(graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"email": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"phone": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"idempotencyKey": idempotencyKeyArgument(),
+		}),
+		Resolve: r.CreatePatient,
+	})
+
+	m.Mutation("createPatientFromHL7", &graphql.Field{
+		Type:        graphql.NewNonNull(patientType),
+		Description: "Creates a new patient from an HL7 v2 ADT^A04 message",
+		Args: graphql.FieldConfigArgument{
+			"message": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"idempotencyKey": idempotencyKeyArgument(),
+		},
+		Resolve: r.CreatePatientFromHL7,
+	})
+
+	m.Mutation("createPatients", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(patientResultType))),
+		Description: fmt.Sprintf("Creates up to %d patients at once and returns the outcome of each, in the order of the input. Every patient is validated first; in ATOMIC mode an invalid patient or a failed write leaves nothing created.", resolvers.MaxBatchSize),
+		Args: graphql.FieldConfigArgument{
+			"patients": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(patientInputType))),
+			},
+			"mode": &graphql.ArgumentConfig{
+				Type:         batchModeType,
+				DefaultValue: resolvers.BatchAtomic,
+			},
+			"idempotencyKey": idempotencyKeyArgument(),
+		},
+		Resolve: r.CreatePatients,
+	})
+
+	m.Mutation("updatePatients", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(patientResultType))),
+		Description: fmt.Sprintf("Updates up to %d patients at once like update and returns the outcome of each, in the order of the input. Every change is validated first; in ATOMIC mode an invalid change or a failed write, such as a VERSION_CONFLICT, leaves nothing updated.", resolvers.MaxBatchSize),
+		Args: graphql.FieldConfigArgument{
+			"patients": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(patientUpdateInputType))),
+			},
+			"mode": &graphql.ArgumentConfig{
+				Type:         batchModeType,
+				DefaultValue: resolvers.BatchAtomic,
+			},
+		},
+		Resolve: r.UpdatePatients,
+	})
+
+	m.Mutation("update", &graphql.Field{
+		Type:        patientType,
+		Description: "Updates an existing patient. It fails with a VERSION_CONFLICT error, holding the patient as stored in its current extension, when the patient is no longer at version.",
+		Args: demographics.arguments(graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"version": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "The version of the patient the changes were made to.",
+			},
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"email": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"phone": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		}),
+		Resolve: r.UpdatePatient,
+	})
+
+	conflictChoiceType := m.Enum(
+		graphql.EnumConfig{
+			Name:        "ConflictChoice",
+			Description: "Which value resolveConflict keeps for a field: the LOCAL one of the update that conflicted, the REMOTE one stored since, or a CUSTOM one.",
+			Values: graphql.EnumValueConfigMap{
+				"LOCAL":  &graphql.EnumValueConfig{Value: resolvers.ConflictLocal},
+				"REMOTE": &graphql.EnumValueConfig{Value: resolvers.ConflictRemote},
+				"CUSTOM": &graphql.EnumValueConfig{Value: resolvers.ConflictCustom},
+			},
+		},
+	)
+
+	fieldResolutionInputType := m.InputObject(
+		graphql.InputObjectConfig{
+			Name: "FieldResolutionInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"choice": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(conflictChoiceType),
+				},
+				"custom": &graphql.InputObjectFieldConfig{
+					Type:        graphql.String,
+					Description: "The value to keep, required when choice is CUSTOM.",
+				},
+			},
+		},
+	)
+
+	conflictValuesInputType := m.InputObject(
+		graphql.InputObjectConfig{
+			Name:        "ConflictValuesInput",
+			Description: "The values of the update that failed with a VERSION_CONFLICT.",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"name": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"email": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"phone": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	resolutionInputType := m.InputObject(
+		graphql.InputObjectConfig{
+			Name:        "ResolutionInput",
+			Description: "How resolveConflict resolves each field. Fields left out keep their remote value.",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"local": &graphql.InputObjectFieldConfig{
+					Type:        conflictValuesInputType,
+					Description: "Required for the fields whose choice is LOCAL.",
+				},
+				"name": &graphql.InputObjectFieldConfig{
+					Type: fieldResolutionInputType,
+				},
+				"email": &graphql.InputObjectFieldConfig{
+					Type: fieldResolutionInputType,
+				},
+				"phone": &graphql.InputObjectFieldConfig{
+					Type: fieldResolutionInputType,
+				},
+			},
+		},
+	)
+
+	m.Mutation("resolveConflict", &graphql.Field{
+		Type:        graphql.NewNonNull(patientType),
+		Description: "Resolves a VERSION_CONFLICT of update by writing the values resolution chooses to the patient at its latest version, and returns the patient. Nothing is written when every chosen value is the remote one.",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"resolution": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(resolutionInputType),
+			},
+		},
+		Resolve: r.ResolveConflict,
+	})
+
+	m.Mutation("delete", &graphql.Field{
+		Type:        patientType,
+		Description: "Soft-deletes a patient by id and returns it, it can be brought back with restore",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.DeletePatient,
+	})
+
+	m.Mutation("restore", &graphql.Field{
+		Type:        patientType,
+		Description: "Restores a soft-deleted patient",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.RestorePatient,
+	})
+
+	m.Mutation("purge", &graphql.Field{
+		Type:        patientType,
+		Description: "Permanently removes a soft-deleted patient with its appointments, encounters, care team, emergency contacts, notes, insurance policies, consents, documents and duplicate reports. Only admins may call it.",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.PurgePatient,
+	})
+
+	// The subscriptions are executed once for every change, with the changed
+	// patient, or for patientBulkImportCompleted the import, as the root value.
+	m.Subscription(resolvers.PatientCreated, &graphql.Field{
+		Type:        graphql.NewNonNull(patientType),
+		Description: "Sends every newly created patient",
+		Resolve:     r.PatientEvent,
+	})
+
+	m.Subscription(resolvers.PatientUpdated, &graphql.Field{
+		Type:        graphql.NewNonNull(patientType),
+		Description: "Sends patients after they are updated or restored",
+		Resolve:     r.PatientEvent,
+	})
+
+	m.Subscription(resolvers.PatientDeleted, &graphql.Field{
+		Type:        graphql.NewNonNull(patientType),
+		Description: "Sends patients after they are soft-deleted",
+		Resolve:     r.PatientEvent,
+	})
+
+	bulkImportCompletedType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "BulkImportCompleted",
+			Description: "A CSV import of patients that finished.",
+			Fields: graphql.Fields{
+				"event": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "Always patient.bulk_import_completed.",
+				},
+				"importedCount": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "How many rows were imported, whether they created, updated or matched a patient.",
+				},
+				"timestamp": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the import finished, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						event, ok := params.Source.(*resolvers.BulkImportCompleted)
+						if !ok {
+							return nil, nil
+						}
+
+						return event.Timestamp.Format(time.RFC3339), nil
+					},
+				},
+			},
+		},
+	)
+
+	m.Subscription(resolvers.PatientBulkImportCompleted, &graphql.Field{
+		Type:        graphql.NewNonNull(bulkImportCompletedType),
+		Description: "Sends every CSV import of patients that finished without stopping early",
+		Resolve:     r.BulkImportEvent,
+	})
+
+	return patientTypes{
+		patient:      patientType,
+		connection:   patientConnectionType,
+		filter:       patientFilterInputType,
+		sortField:    patientSortFieldType,
+		sortOrder:    sortOrderType,
+		demographics: demographics,
+	}
+}
@@ -0,0 +1,38 @@
+package schema
+
+import (
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+)
+
+// registerPrivacy contributes the export and erasure of patients on their
+// request.
+func registerPrivacy(m *Module, r *resolvers.Resolver, patientType *graphql.Object) {
+	m.Cost("exportPatientData", 10)
+
+	m.Query("exportPatientData", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.String),
+		Description: "Exports everything stored about a patient, deleted or not, as a JSON document: the patient, appointments, encounters with every revision, emergency contacts, notes, insurance policies, consents, care team and audit trail. Only privacy officers may call it.",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.ExportPatientData,
+	})
+
+	m.Mutation("erasePatient", &graphql.Field{
+		Type:        graphql.NewNonNull(patientType),
+		Description: "Anonymizes a patient, and the duplicates merged into it, on their request: their name, email and phone are replaced, emergency contacts, insurance policies and documents deleted, appointment reasons and notes cleared, and the patient values in the audit log removed. Encounters and notes are kept with the anonymized patient. The patient is soft-deleted and cannot be restored. The justification is recorded. Only privacy officers may call it.",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"justification": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+		},
+		Resolve: r.ErasePatient,
+	})
+}
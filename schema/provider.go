@@ -0,0 +1,117 @@
+package schema
+
+import (
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+)
+
+// registerProviders contributes the providers and the care teams of
+// patients.
+func registerProviders(m *Module, r *resolvers.Resolver, patientType *graphql.Object) {
+	m.Cost("getProviders", 10)
+	m.Cost("careTeam", 5)
+	m.Cost("panel", 10)
+
+	providerType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "Provider",
+			Description: "A doctor or other clinician patients can be assigned to.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"name": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"specialty": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"panel": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(patientType))),
+					Description: "The patients whose care team the provider is on, by id. Deleted patients are left out.",
+					Resolve:     r.ProviderPanel,
+				},
+			},
+		},
+	)
+
+	m.Extend(patientType, "careTeam", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(providerType))),
+		Description: "The providers caring for the patient, by name.",
+		Resolve:     r.PatientCareTeam,
+	})
+
+	m.Query("getProvider", &graphql.Field{
+		Type:        providerType,
+		Description: "Get a provider by id",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.GetProvider,
+	})
+
+	m.Query("getProviders", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(providerType))),
+		Description: "Lists a page of providers by name, optionally of one specialty. limit defaults to 20 and is clamped to 100.",
+		Args: graphql.FieldConfigArgument{
+			"specialty": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: resolvers.DefaultPageLimit,
+			},
+			"offset": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+			},
+		},
+		Resolve: r.GetProviders,
+	})
+
+	m.Mutation("createProvider", &graphql.Field{
+		Type:        graphql.NewNonNull(providerType),
+		Description: "Adds a provider. Only admins may call it.",
+		Args: graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"specialty": &graphql.ArgumentConfig{
+				Type:         graphql.String,
+				DefaultValue: "",
+			},
+		},
+		Resolve: r.CreateProvider,
+	})
+
+	m.Mutation("assignProvider", &graphql.Field{
+		Type:        patientType,
+		Description: "Adds a provider to the care team of a patient, doing nothing when the provider is already on it",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"providerId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.AssignProvider,
+	})
+
+	m.Mutation("unassignProvider", &graphql.Field{
+		Type:        patientType,
+		Description: "Removes a provider from the care team of a patient",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"providerId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.UnassignProvider,
+	})
+}
@@ -0,0 +1,36 @@
+package schema
+
+import (
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+)
+
+// registerRegistration contributes registerPatient, which creates a patient
+// with the records of the appointments and contacts modules.
+func registerRegistration(m *Module, r *resolvers.Resolver, patients patientTypes, appointmentInputType, emergencyContactInputType *graphql.InputObject) {
+	m.Mutation("registerPatient", &graphql.Field{
+		Type:        graphql.NewNonNull(patients.patient),
+		Description: "Creates a patient with their first appointment and an emergency contact in one transaction: when any of them fails, for example on a conflicting booking, nothing is created.",
+		Args: patients.demographics.arguments(graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "Required unless structuredName is given, whose parts then make the name.",
+			},
+			"email": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"phone": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"appointment": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(appointmentInputType),
+			},
+			"emergencyContact": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(emergencyContactInputType),
+			},
+			"idempotencyKey": idempotencyKeyArgument(),
+		}),
+		Resolve: r.RegisterPatient,
+	})
+}
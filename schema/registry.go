@@ -0,0 +1,246 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/chain"
+	"github.com/codixir/smart-emerge-starter/complexity"
+)
+
+// Registry collects the types, root fields and field costs the modules of
+// the schema contribute, and assembles them into a graphql.Schema. A module
+// registers what its domain adds, through the Module it gets from Module,
+// and may extend the types of the modules registered before it, such as the
+// Patient fields of appointments or documents. Names registered twice are
+// collected as conflicts and reported together by Build, rather than one
+// silently replacing the other. Middlewares registered with Use and
+// Module.Use wrap the resolvers of the fields once the schema is built.
+type Registry struct {
+	// owners maps the types, as "Patient", and fields, as "Query.getPatient"
+	// or "Patient.appointments", to the module that registered them.
+	owners map[string]string
+	types  []graphql.Type
+	roots  map[string]graphql.Fields
+	costs  complexity.Costs
+	// costOwners maps a field name of costs to the module that set its cost.
+	costOwners map[string]string
+	conflicts  []string
+	// middlewares wrap every resolver, and moduleMiddlewares those of the
+	// fields of a module.
+	middlewares       []chain.Middleware
+	moduleMiddlewares map[string][]chain.Middleware
+}
+
+// The root types module fields are registered on.
+const (
+	queryRoot        = "Query"
+	mutationRoot     = "Mutations"
+	subscriptionRoot = "Subscription"
+)
+
+func NewRegistry() *Registry {
+	return &Registry{
+		owners: map[string]string{},
+		roots: map[string]graphql.Fields{
+			queryRoot:        {},
+			mutationRoot:     {},
+			subscriptionRoot: {},
+		},
+		costs:             complexity.Costs{},
+		costOwners:        map[string]string{},
+		moduleMiddlewares: map[string][]chain.Middleware{},
+	}
+}
+
+// Use adds middlewares around the resolver of every field of the schema,
+// outside those of the modules. They run in the order they are added, the
+// first one outermost.
+func (reg *Registry) Use(middlewares ...chain.Middleware) {
+	reg.middlewares = append(reg.middlewares, middlewares...)
+}
+
+// Module returns the Module registering for the module name, which conflicts
+// are reported with.
+func (reg *Registry) Module(name string) *Module {
+	return &Module{reg: reg, name: name}
+}
+
+// claim records key as registered by module, or a conflict when another
+// module, or the same one, registered it already.
+func (reg *Registry) claim(key, module string) bool {
+	if owner, ok := reg.owners[key]; ok {
+		reg.conflicts = append(reg.conflicts, fmt.Sprintf("%s is registered by both %s and %s", key, owner, module))
+		return false
+	}
+
+	reg.owners[key] = module
+	return true
+}
+
+// Build assembles the schema from everything registered and returns it with
+// the costs of its fields, for complexity.Check, with the resolvers wrapped
+// in the middlewares. It fails with every conflict at once when names were
+// registered twice, and when a root field has no resolver.
+func (reg *Registry) Build() (graphql.Schema, complexity.Costs, error) {
+	if len(reg.conflicts) > 0 {
+		return graphql.Schema{}, nil, fmt.Errorf("schema conflicts: %s", strings.Join(reg.conflicts, "; "))
+	}
+
+	config := graphql.SchemaConfig{Types: reg.types}
+
+	root := func(name string) *graphql.Object {
+		if len(reg.roots[name]) == 0 {
+			return nil
+		}
+		return graphql.NewObject(graphql.ObjectConfig{Name: name, Fields: reg.roots[name]})
+	}
+	config.Query = root(queryRoot)
+	config.Mutation = root(mutationRoot)
+	config.Subscription = root(subscriptionRoot)
+
+	schema, err := graphql.NewSchema(config)
+	if err != nil {
+		return schema, nil, err
+	}
+
+	if paths := missingResolvers(schema); len(paths) > 0 {
+		return schema, nil, fmt.Errorf("fields without a resolver: %v", paths)
+	}
+
+	chain.Apply(schema, func(field chain.Field, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+		module := reg.moduleMiddlewares[reg.owners[field.String()]]
+		return chain.Compose(append(append([]chain.Middleware{}, reg.middlewares...), module...)...)(field, next)
+	})
+
+	return schema, reg.costs, nil
+}
+
+// missingResolvers returns the paths of root query, mutation and subscription
+// fields that have no Resolve function. Nested object fields are skipped on
+// purpose, since graphql-go falls back to its default resolver for those.
+func missingResolvers(schema graphql.Schema) []string {
+	var paths []string
+
+	for _, root := range []*graphql.Object{schema.QueryType(), schema.MutationType(), schema.SubscriptionType()} {
+		if root == nil {
+			continue
+		}
+
+		for name, field := range root.Fields() {
+			if field.Resolve == nil {
+				paths = append(paths, root.Name()+"."+name)
+			}
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// Module registers the contributions of one module of the schema.
+type Module struct {
+	reg  *Registry
+	name string
+}
+
+// Use adds middlewares around the resolvers of the fields m registers,
+// those it adds to the types of other modules included, inside those of the
+// registry. They run in the order they are added, the first one outermost.
+func (m *Module) Use(middlewares ...chain.Middleware) {
+	m.reg.moduleMiddlewares[m.name] = append(m.reg.moduleMiddlewares[m.name], middlewares...)
+}
+
+// Object registers and returns the object type of config.
+func (m *Module) Object(config graphql.ObjectConfig) *graphql.Object {
+	object := graphql.NewObject(config)
+
+	// The fields of an object whose name conflicts are not claimed, so
+	// the conflict is reported once.
+	if fields, ok := config.Fields.(graphql.Fields); ok && m.named(object) {
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			m.reg.claim(config.Name+"."+name, m.name)
+		}
+	}
+
+	return object
+}
+
+// Enum registers and returns the enum type of config.
+func (m *Module) Enum(config graphql.EnumConfig) *graphql.Enum {
+	enum := graphql.NewEnum(config)
+	m.named(enum)
+	return enum
+}
+
+// InputObject registers and returns the input object type of config.
+func (m *Module) InputObject(config graphql.InputObjectConfig) *graphql.InputObject {
+	input := graphql.NewInputObject(config)
+	m.named(input)
+	return input
+}
+
+// named registers the named type t, reporting whether it was the first type
+// of its name.
+func (m *Module) named(t graphql.Type) bool {
+	if !m.reg.claim(t.Name(), m.name) {
+		return false
+	}
+
+	m.reg.types = append(m.reg.types, t)
+	return true
+}
+
+// Extend adds the field name to object, which may belong to another module.
+func (m *Module) Extend(object *graphql.Object, name string, field *graphql.Field) {
+	if m.reg.claim(object.Name()+"."+name, m.name) {
+		object.AddFieldConfig(name, field)
+	}
+}
+
+// Query registers the root query field name.
+func (m *Module) Query(name string, field *graphql.Field) {
+	m.root(queryRoot, name, field)
+}
+
+// Mutation registers the root mutation field name.
+func (m *Module) Mutation(name string, field *graphql.Field) {
+	m.root(mutationRoot, name, field)
+}
+
+// Subscription registers the root subscription field name, delivered over
+// /graphql/ws.
+func (m *Module) Subscription(name string, field *graphql.Field) {
+	m.root(subscriptionRoot, name, field)
+}
+
+func (m *Module) root(root, name string, field *graphql.Field) {
+	if m.reg.claim(root+"."+name, m.name) {
+		m.reg.roots[root][name] = field
+	}
+}
+
+// Cost sets the complexity cost of the fields named field, which load lists
+// of rows. Every other field costs 1. Costs are looked up by field name
+// alone, so modules setting different costs for the same name conflict.
+func (m *Module) Cost(field string, cost int) {
+	if current, ok := m.reg.costs[field]; ok {
+		if current != cost {
+			m.reg.conflicts = append(m.reg.conflicts, fmt.Sprintf("the cost of %s is %d in %s and %d in %s",
+				field, current, m.reg.costOwners[field], cost, m.name))
+		}
+		return
+	}
+
+	m.reg.costs[field] = cost
+	m.reg.costOwners[field] = m.name
+}
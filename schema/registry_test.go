@@ -0,0 +1,69 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+)
+
+func resolved(p graphql.ResolveParams) (interface{}, error) {
+	return "ok", nil
+}
+
+func TestMissingResolvers(t *testing.T) {
+	tests := []struct {
+		name     string
+		register func(m *Module)
+		want     string
+	}{
+		{
+			name: "every root field resolved",
+			register: func(m *Module) {
+				m.Query("ping", &graphql.Field{Type: graphql.String, Resolve: resolved})
+				m.Mutation("touch", &graphql.Field{Type: graphql.String, Resolve: resolved})
+			},
+		},
+		{
+			name: "query without a resolver",
+			register: func(m *Module) {
+				m.Query("ping", &graphql.Field{Type: graphql.String, Resolve: resolved})
+				m.Query("broken", &graphql.Field{Type: graphql.String})
+			},
+			want: "fields without a resolver: [Query.broken]",
+		},
+		{
+			name: "mutation and subscription without resolvers",
+			register: func(m *Module) {
+				m.Query("ping", &graphql.Field{Type: graphql.String, Resolve: resolved})
+				m.Mutation("touch", &graphql.Field{Type: graphql.String})
+				m.Subscription("watch", &graphql.Field{Type: graphql.String})
+			},
+			want: "fields without a resolver: [Mutations.touch Subscription.watch]",
+		},
+		{
+			name: "nested field without a resolver",
+			register: func(m *Module) {
+				object := m.Object(graphql.ObjectConfig{Name: "Thing", Fields: graphql.Fields{
+					"name": &graphql.Field{Type: graphql.String},
+				}})
+				m.Query("thing", &graphql.Field{Type: object, Resolve: resolved})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := NewRegistry()
+			tt.register(reg.Module("test"))
+
+			_, _, err := reg.Build()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("Build() = %v, want no error", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Fatalf("Build() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// registerReminders contributes the reminder history of patients.
+func registerReminders(m *Module, r *resolvers.Resolver) {
+	m.Cost("getRemindersByPatient", 10)
+
+	reminderChannelType := m.Enum(
+		graphql.EnumConfig{
+			Name: "ReminderChannel",
+			Values: graphql.EnumValueConfigMap{
+				"EMAIL": &graphql.EnumValueConfig{Value: "email"},
+				"SMS":   &graphql.EnumValueConfig{Value: "sms"},
+			},
+		},
+	)
+
+	reminderStatusType := m.Enum(
+		graphql.EnumConfig{
+			Name:        "ReminderStatus",
+			Description: "PENDING until the reminder is sent, FAILED once it ran out of attempts, and SKIPPED when the appointment was cancelled, moved or started, or the patient has no address on the channel.",
+			Values: graphql.EnumValueConfigMap{
+				"PENDING": &graphql.EnumValueConfig{Value: "pending"},
+				"SENT":    &graphql.EnumValueConfig{Value: "sent"},
+				"FAILED":  &graphql.EnumValueConfig{Value: "failed"},
+				"SKIPPED": &graphql.EnumValueConfig{Value: "skipped"},
+			},
+		},
+	)
+
+	reminderType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "Reminder",
+			Description: "A reminder of an appointment sent, or to be sent, to its patient on one channel.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"appointmentId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"patientId": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"channel": &graphql.Field{
+					Type: graphql.NewNonNull(reminderChannelType),
+				},
+				"scheduledFor": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The start of the appointment the reminder is about, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						reminder, ok := params.Source.(*store.Reminder)
+						if !ok {
+							return nil, nil
+						}
+
+						return reminder.ScheduledFor.Format(time.RFC3339), nil
+					},
+				},
+				"status": &graphql.Field{
+					Type: graphql.NewNonNull(reminderStatusType),
+				},
+				"attempts": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"lastError": &graphql.Field{
+					Type:        graphql.String,
+					Description: "Why the last attempt failed, or why the reminder was skipped.",
+				},
+				"sentAt": &graphql.Field{
+					Type:        graphql.String,
+					Description: "When the reminder was sent, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						reminder, ok := params.Source.(*store.Reminder)
+						if !ok || reminder.SentAt == nil {
+							return nil, nil
+						}
+
+						return reminder.SentAt.Format(time.RFC3339), nil
+					},
+				},
+				"createdAt": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "When the reminder was scheduled, in RFC 3339 format.",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						reminder, ok := params.Source.(*store.Reminder)
+						if !ok {
+							return nil, nil
+						}
+
+						return reminder.CreatedAt.Format(time.RFC3339), nil
+					},
+				},
+			},
+		},
+	)
+
+	m.Query("getRemindersByPatient", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(reminderType))),
+		Description: "Lists the appointment reminders of a patient, newest first. limit defaults to 20 and is clamped to 100.",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: resolvers.DefaultPageLimit,
+			},
+			"offset": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+			},
+		},
+		Resolve: r.GetRemindersByPatient,
+	})
+}
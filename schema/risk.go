@@ -0,0 +1,56 @@
+package schema
+
+import (
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/resolvers"
+)
+
+// registerRisk contributes the triage risk scores of patients.
+func registerRisk(m *Module, r *resolvers.Resolver) {
+	m.Cost("computePatientRiskScore", 10)
+
+	riskLevelType := m.Enum(
+		graphql.EnumConfig{
+			Name: "RiskLevel",
+			Values: graphql.EnumValueConfigMap{
+				"LOW":      &graphql.EnumValueConfig{Value: resolvers.RiskLow},
+				"MEDIUM":   &graphql.EnumValueConfig{Value: resolvers.RiskMedium},
+				"HIGH":     &graphql.EnumValueConfig{Value: resolvers.RiskHigh},
+				"CRITICAL": &graphql.EnumValueConfig{Value: resolvers.RiskCritical},
+			},
+		},
+	)
+
+	riskScoreType := m.Object(
+		graphql.ObjectConfig{
+			Name:        "RiskScore",
+			Description: "The triage risk of a patient.",
+			Fields: graphql.Fields{
+				"score": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Float),
+					Description: "The sum of the weights of the factors: 3 for diabetes, 2 for hypertension and 1 for allergy.",
+				},
+				"level": &graphql.Field{
+					Type:        graphql.NewNonNull(riskLevelType),
+					Description: "LOW for a score of 0, MEDIUM up to 2, HIGH up to 4 and CRITICAL above.",
+				},
+				"factors": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+					Description: "The risk factors found: diabetes, hypertension or allergy.",
+				},
+			},
+		},
+	)
+
+	m.Query("computePatientRiskScore", &graphql.Field{
+		Type:        graphql.NewNonNull(riskScoreType),
+		Description: "Scores the risk of a patient for triage by the risk factors named in their notes and in the chief complaints and notes of their encounters, as last amended. Factors named only after a simple negation, such as \"no known allergies\", are not counted. The read is recorded in the audit log of the patient.",
+		Args: graphql.FieldConfigArgument{
+			"patientId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+		},
+		Resolve: r.ComputePatientRiskScore,
+	})
+}
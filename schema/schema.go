@@ -0,0 +1,54 @@
+// Package schema defines the GraphQL schema and wires its fields to the
+// resolvers. Each domain registers its types, queries, mutations and
+// subscriptions in a module of its own file, and New assembles them with a
+// Registry.
+package schema
+
+import (
+	"fmt"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/chain"
+	"github.com/codixir/smart-emerge-starter/complexity"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+)
+
+// New builds the schema with its fields resolved by r, wrapped in
+// middlewares, the first one outermost, and returns it with the complexity
+// costs of the fields that load lists of rows. It fails when two modules
+// register the same type or field, or when a root field has no resolver.
+func New(r *resolvers.Resolver, middlewares ...chain.Middleware) (graphql.Schema, complexity.Costs, error) {
+	reg := NewRegistry()
+	reg.Use(middlewares...)
+
+	patients := registerPatients(reg.Module("patients"), r)
+	registerAudit(reg.Module("audit"), r)
+	registerChanges(reg.Module("changes"), r, patients.patient)
+	registerClinics(reg.Module("clinics"), r, patients.connection, patients.filter, patients.sortField, patients.sortOrder)
+	appointmentInputType := registerAppointments(reg.Module("appointments"), r, patients.patient)
+	registerReminders(reg.Module("reminders"), r)
+	emergencyContactInputType := registerContacts(reg.Module("contacts"), r, patients.patient)
+	registerNotes(reg.Module("notes"), r, patients.patient)
+	registerRegistration(reg.Module("registration"), r, patients, appointmentInputType, emergencyContactInputType)
+	registerInsurance(reg.Module("insurance"), r, patients.patient)
+	registerConsents(reg.Module("consents"), r, patients.patient)
+	registerDocuments(reg.Module("documents"), r, patients.patient)
+	registerProviders(reg.Module("providers"), r, patients.patient)
+	registerEncounters(reg.Module("encounters"), r, patients.patient)
+	registerRisk(reg.Module("risk"), r)
+	registerDuplicates(reg.Module("duplicates"), r, patients.patient)
+	registerPrivacy(reg.Module("privacy"), r, patients.patient)
+
+	return reg.Build()
+}
+
+// idempotencyKeyArgument is the idempotencyKey argument of the create
+// mutations.
+func idempotencyKeyArgument() *graphql.ArgumentConfig {
+	return &graphql.ArgumentConfig{
+		Type: graphql.String,
+		Description: fmt.Sprintf("Makes the mutation safe to retry: sent again with the same key and arguments while the key is kept, it returns what the first request created instead of creating it again. At most %d characters, unique per request; defaults to the Idempotency-Key header.",
+			resolvers.MaxIdempotencyKeyLength),
+	}
+}
@@ -0,0 +1,72 @@
+// Package seed fills a clinic with made up patients, for development and
+// load testing.
+package seed
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// batchSize is how many patients one Upsert call writes.
+const batchSize = 500
+
+var (
+	firstNames = []string{"Ava", "Ben", "Chloe", "Daniel", "Emma", "Felix", "Grace", "Henry", "Isla", "Jack",
+		"Kate", "Leo", "Maya", "Noah", "Olivia", "Priya", "Quinn", "Ravi", "Sofia", "Tom"}
+	lastNames = []string{"Adams", "Brown", "Chen", "Diaz", "Evans", "Fischer", "Garcia", "Hughes", "Ito", "Jones",
+		"Khan", "Lopez", "Murphy", "Nguyen", "Okafor", "Patel", "Rossi", "Smith", "Tanaka", "Walsh"}
+)
+
+// Patient returns the made up patient number n. The same n always gives the
+// same patient, with an email of the reserved example.com domain.
+func Patient(n int) *store.Patient {
+	first := firstNames[n%len(firstNames)]
+	last := lastNames[(n/len(firstNames))%len(lastNames)]
+
+	return &store.Patient{
+		Name:  first + " " + last,
+		Email: fmt.Sprintf("seed.patient%d@example.com", n),
+		// 555 numbers are not given out.
+		Phone: fmt.Sprintf("+1415555%04d", n%10000),
+	}
+}
+
+// Patients upserts the made up patients 1 to count into the clinic of ctx as
+// actor, and returns how many were created. Patients are matched by email,
+// so seeding again updates the patients of an earlier run rather than
+// adding to them.
+func Patients(ctx context.Context, patients store.PatientRepository, actor string, count int) (int, error) {
+	created := 0
+
+	for start := 1; start <= count; start += batchSize {
+		batch := make([]*store.Patient, 0, batchSize)
+		for n := start; n <= count && n < start+batchSize; n++ {
+			p := Patient(n)
+			if err := validation.Patient(&p.Name, &p.Email, &p.Phone); err != nil {
+				return created, fmt.Errorf("patient %d: %w", n, err)
+			}
+
+			batch = append(batch, p)
+		}
+
+		results, err := patients.Upsert(ctx, actor, batch)
+		if err != nil {
+			return created, err
+		}
+
+		for i, result := range results {
+			if result.Err != nil {
+				return created, fmt.Errorf("patient %d: %w", start+i, result.Err)
+			}
+
+			if result.Status == store.UpsertCreated {
+				created++
+			}
+		}
+	}
+
+	return created, nil
+}
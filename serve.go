@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/cache"
+	"github.com/codixir/smart-emerge-starter/chain"
+	"github.com/codixir/smart-emerge-starter/config"
+	"github.com/codixir/smart-emerge-starter/document"
+	"github.com/codixir/smart-emerge-starter/encryption"
+	"github.com/codixir/smart-emerge-starter/metrics"
+	"github.com/codixir/smart-emerge-starter/migrations"
+	"github.com/codixir/smart-emerge-starter/outbox"
+	"github.com/codixir/smart-emerge-starter/persisted"
+	"github.com/codixir/smart-emerge-starter/pubsub"
+	"github.com/codixir/smart-emerge-starter/reminder"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/schema"
+	"github.com/codixir/smart-emerge-starter/server"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/store/memory"
+	"github.com/codixir/smart-emerge-starter/tracing"
+)
+
+// repositories are the stores the resolvers and routes read and write.
+type repositories struct {
+	patients     store.PatientRepository
+	appointments store.AppointmentRepository
+	providers    store.ProviderRepository
+	encounters   store.EncounterRepository
+	contacts     store.EmergencyContactRepository
+	notes        store.NoteRepository
+	policies     store.InsurancePolicyRepository
+	consents     store.ConsentRepository
+	duplicates   store.DuplicateReportRepository
+	clinics      store.ClinicRepository
+	reminders    store.ReminderRepository
+	documents    store.DocumentRepository
+	deadLetters  store.HL7DeadLetterRepository
+	idempotency  store.IdempotencyKeyRepository
+	tx           store.Transactor
+	auditLog     resolvers.AuditLog
+}
+
+// memoryRepositories returns the repositories keeping their records in db.
+func memoryRepositories(db *memory.DB) repositories {
+	return repositories{
+		patients:     memory.NewPatientStore(db),
+		appointments: memory.NewAppointmentStore(db),
+		providers:    memory.NewProviderStore(db),
+		encounters:   memory.NewEncounterStore(db),
+		contacts:     memory.NewEmergencyContactStore(db),
+		notes:        memory.NewNoteStore(db),
+		policies:     memory.NewInsurancePolicyStore(db),
+		consents:     memory.NewConsentStore(db),
+		duplicates:   memory.NewDuplicateReportStore(db),
+		clinics:      memory.NewClinicStore(db),
+		reminders:    memory.NewReminderStore(db),
+		documents:    memory.NewDocumentStore(db),
+		deadLetters:  memory.NewHL7DeadLetterStore(db),
+		idempotency:  memory.NewIdempotencyKeyStore(db),
+		tx:           memory.NewTxStore(db),
+		auditLog:     memory.NewAuditLogger(db),
+	}
+}
+
+// serve runs the HTTP server, and the workers delivering events, reminders
+// and document deletions, until SIGINT or SIGTERM, applying the pending
+// migrations first. It still takes the flags of the commands that were
+// flags before, -migrate, -migrate-only, -rotate-keys and -approve-queries,
+// and runs the command instead.
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	migrateOnly := flags.Bool("migrate-only", false, "same as the migrate up command")
+	migrateCommand := flags.String("migrate", "", "same as the migrate command, with up or down")
+	migrateSteps := flags.Int("migrate-steps", 1, "how many migrations -migrate down reverts")
+	rotate := flags.Bool("rotate-keys", false, "same as the rotate-keys command")
+	approve := flags.String("approve-queries", "", "same as the approve-queries command, with the manifest")
+	flags.Parse(args)
+
+	switch {
+	case *migrateOnly:
+		return runMigrate([]string{"up"})
+	case *migrateCommand != "":
+		return runMigrate([]string{*migrateCommand, "-steps", strconv.Itoa(*migrateSteps)})
+	case *rotate:
+		return rotateKeys(nil)
+	case *approve != "":
+		return approveQueries([]string{*approve})
+	}
+
+	cfg, err := loadConfig(config.Load)
+	if err != nil {
+		return err
+	}
+
+	appLogger := slog.Default()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "smart-emerge")
+	logFatal(err)
+
+	var cipher *encryption.Cipher
+	if cfg.Encryption.Enabled() {
+		cipher, err = encryption.New(cfg.Encryption.Keys, cfg.Encryption.IndexKey)
+		logFatal(err)
+	}
+
+	var db, replicaDB *sql.DB
+	var repos repositories
+	var eventOutbox *outbox.Outbox
+	if cfg.Database.Memory() {
+		slog.Warn("keeping records in memory, they are lost when the server stops")
+		repos = memoryRepositories(memory.New())
+	} else {
+		db, err = openMigrated(cfg.Database)
+		logFatal(err)
+
+		auditLogger := audit.NewAuditLogger(db, cipher)
+		if cfg.Events.Enabled() {
+			eventOutbox = outbox.New(db, cipher)
+		}
+
+		var replica *store.Replica
+		if cfg.Database.ReplicaURL != "" {
+			replicaDB, err = tracing.OpenPostgres(cfg.Database.ReplicaURL)
+			logFatal(err)
+
+			configurePool(replicaDB, cfg.Database.Pool)
+			replica = store.NewReplica(replicaDB)
+			slog.Info("reading patient lists and searches from the read replica")
+		}
+
+		repos = repositories{
+			patients:     store.NewPatientStore(db, replica, auditLogger, eventOutbox, cipher, cfg.ExplainCostThreshold),
+			appointments: store.NewAppointmentStore(db, cipher),
+			providers:    store.NewProviderStore(db, auditLogger, cipher),
+			encounters:   store.NewEncounterStore(db, auditLogger),
+			contacts:     store.NewEmergencyContactStore(db, auditLogger, cipher),
+			notes:        store.NewNoteStore(db, auditLogger),
+			policies:     store.NewInsurancePolicyStore(db, auditLogger, cipher),
+			consents:     store.NewConsentStore(db, auditLogger),
+			duplicates:   store.NewDuplicateReportStore(db),
+			clinics:      store.NewClinicStore(db),
+			reminders:    store.NewReminderStore(db),
+			documents:    store.NewDocumentStore(db, auditLogger),
+			deadLetters:  store.NewHL7DeadLetterStore(db),
+			idempotency:  store.NewIdempotencyKeyStore(db),
+			tx:           store.NewTxStore(db),
+			auditLog:     auditLogger,
+		}
+	}
+
+	patientRepo := repos.patients
+	var redisCache *cache.Redis
+	switch cfg.Cache.Backend {
+	case "memory":
+		patientRepo = cache.NewPatients(repos.patients, cache.NewMemory(cfg.Cache.MaxEntries), cipher, cfg.Cache.PatientTTL, cfg.Cache.ListTTL)
+	case "redis":
+		redisCache, err = cache.NewRedis(context.Background(), cfg.Cache.RedisURL)
+		logFatal(err)
+		patientRepo = cache.NewPatients(repos.patients, redisCache, cipher, cfg.Cache.PatientTTL, cfg.Cache.ListTTL)
+	}
+	if cfg.Cache.Enabled() {
+		slog.Info("caching patient reads", "backend", cfg.Cache.Backend,
+			"patient_ttl", cfg.Cache.PatientTTL.String(), "list_ttl", cfg.Cache.ListTTL.String())
+	}
+
+	var persistedQueries *persisted.Queries
+	if cfg.PersistedQueries != persisted.Off {
+		persistedQueries = persisted.New(db, cfg.PersistedQueries)
+		slog.Info("persisted queries enabled", "mode", string(cfg.PersistedQueries))
+	}
+
+	var documentStorage document.Storage
+	var documentSigner *document.Signer
+	switch cfg.Documents.Storage {
+	case "local":
+		documentStorage, err = document.NewLocal(cfg.Documents.Dir)
+		logFatal(err)
+	case "s3":
+		documentStorage, err = document.NewS3(cfg.Documents.S3Endpoint, cfg.Documents.S3Region, cfg.Documents.S3Bucket,
+			cfg.Documents.S3AccessKeyID, cfg.Documents.S3SecretAccessKey, cfg.Documents.S3SessionToken)
+		logFatal(err)
+	}
+	if cfg.Documents.Enabled() {
+		documentSigner = document.NewSigner(cfg.Server.JWTSecret, cfg.Documents.URLTTL)
+		slog.Info("storing patient documents", "storage", cfg.Documents.Storage, "max_size_bytes", cfg.Documents.MaxBytes)
+	}
+
+	events := pubsub.NewBroker()
+
+	resolver := resolvers.New(
+		patientRepo,
+		repos.appointments,
+		repos.providers,
+		repos.encounters,
+		repos.contacts,
+		repos.notes,
+		repos.policies,
+		repos.consents,
+		repos.duplicates,
+		repos.clinics,
+		repos.reminders,
+		repos.documents,
+		documentSigner,
+		repos.idempotency,
+		cfg.IdempotencyTTL,
+		repos.tx,
+		repos.auditLog,
+		events,
+	)
+
+	appMetrics := metrics.New(db, replicaDB)
+
+	// The resolver middlewares wrap every resolver, the first one outermost,
+	// so the spans and metrics cover the others.
+	resolverMiddlewares := []chain.Middleware{
+		tracing.ResolverMiddleware(),
+		appMetrics.ResolverMiddleware(),
+		chain.Logging(appLogger, cfg.SlowResolverThreshold),
+	}
+	if cfg.MaxStringArgumentLength > 0 {
+		resolverMiddlewares = append(resolverMiddlewares, chain.MaxStringLength(cfg.MaxStringArgumentLength))
+	}
+
+	graphqlSchema, costs, err := schema.New(resolver, resolverMiddlewares...)
+	logFatal(err)
+
+	srv := server.New(cfg.Server, server.Deps{
+		Logger:     appLogger,
+		DB:         db,
+		Migrations: migrations.FS,
+		Schema:     graphqlSchema,
+		Costs:      costs,
+		Resolver:   resolver,
+		Patients:   patientRepo,
+		Consents:   repos.consents,
+		Events:     events,
+		AuditLog:   repos.auditLog,
+		Metrics:    appMetrics,
+		Queries:    persistedQueries,
+
+		Documents:        repos.documents,
+		DocumentStorage:  documentStorage,
+		DocumentSigner:   documentSigner,
+		MaxDocumentBytes: cfg.Documents.MaxBytes,
+
+		HL7DeadLetters: repos.deadLetters,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The dispatcher keeps delivering the events of requests finishing during
+	// the shutdown, and is stopped before the database is closed.
+	dispatchCtx, stopDispatch := context.WithCancel(context.Background())
+	dispatched := make(chan struct{})
+	var natsPublisher *outbox.NATS
+	if cfg.Events.Enabled() {
+		var publishers outbox.Publishers
+		if len(cfg.Events.WebhookURLs) > 0 {
+			publishers = append(publishers, outbox.NewWebhook(cfg.Events.WebhookURLs, cfg.Events.WebhookSecret))
+		}
+		if cfg.Events.NATSURL != "" {
+			natsPublisher, err = outbox.NewNATS(cfg.Events.NATSURL, cfg.Events.NATSSubjectPrefix)
+			logFatal(err)
+			publishers = append(publishers, natsPublisher)
+		}
+
+		dispatcher := outbox.NewDispatcher(eventOutbox, publishers, cfg.Events.PollInterval, cfg.Events.Timeout, cfg.Events.MaxAttempts)
+		go func() {
+			defer close(dispatched)
+
+			slog.Info("publishing patient events", "webhooks", len(cfg.Events.WebhookURLs), "nats", cfg.Events.NATSURL != "")
+			dispatcher.Run(dispatchCtx)
+		}()
+	} else {
+		close(dispatched)
+	}
+
+	// The reminder scheduler stops with the outbox dispatcher.
+	reminded := make(chan struct{})
+	if cfg.Reminders.Enabled() {
+		notifiers := map[string]reminder.Notifier{}
+		if cfg.Reminders.SMTPHost != "" {
+			notifiers[reminder.Email] = reminder.NewSMTP(cfg.Reminders.SMTPHost, cfg.Reminders.SMTPPort,
+				cfg.Reminders.SMTPUsername, cfg.Reminders.SMTPPassword, cfg.Reminders.SMTPFrom)
+		}
+		if cfg.Reminders.TwilioAccountSID != "" {
+			notifiers[reminder.SMS] = reminder.NewTwilio(cfg.Reminders.TwilioAccountSID, cfg.Reminders.TwilioAuthToken, cfg.Reminders.TwilioFrom)
+		}
+
+		scheduler := reminder.NewScheduler(db, repos.patients, notifiers, cfg.Reminders.Lead, cfg.Reminders.PollInterval,
+			cfg.Reminders.Timeout, cfg.Reminders.MaxAttempts, cfg.Reminders.Location)
+		go func() {
+			defer close(reminded)
+
+			slog.Info("sending appointment reminders", "channels", scheduler.Channels(), "lead", cfg.Reminders.Lead.String())
+			scheduler.Run(dispatchCtx)
+		}()
+	} else {
+		close(reminded)
+	}
+
+	// The document sweeper stops with the outbox dispatcher too.
+	swept := make(chan struct{})
+	if cfg.Documents.Enabled() {
+		sweeper := document.NewSweeper(db, documentStorage, cfg.Documents.SweepInterval)
+		go func() {
+			defer close(swept)
+			sweeper.Run(dispatchCtx)
+		}()
+	} else {
+		close(swept)
+	}
+
+	var challenges func(http.Handler) http.Handler
+	if cfg.Autocert() {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		srv.TLSConfig = manager.TLSConfig()
+		challenges = manager.HTTPHandler
+	}
+
+	var redirect *http.Server
+	if cfg.HTTPRedirectAddr != "" {
+		redirect = server.NewRedirect(cfg.HTTPRedirectAddr, cfg.Server, challenges)
+
+		go func() {
+			slog.Info("redirecting HTTP to HTTPS", "addr", redirect.Addr)
+
+			if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+				logFatal(err)
+			}
+		}()
+	}
+
+	go func() {
+		slog.Info("listening", "addr", srv.Addr, "tls", cfg.TLS(), "autocert", cfg.Autocert())
+
+		var err error
+		if cfg.TLS() {
+			// With autocert the certificates come from srv.TLSConfig.
+			err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+
+		if err != http.ErrServerClosed {
+			logFatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("shutting down, waiting for open requests", "timeout", cfg.ShutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx, srv); err != nil {
+		slog.Error("shutdown timed out, closed open connections", "error", err)
+	}
+
+	if redirect != nil {
+		redirect.Close()
+	}
+
+	stopDispatch()
+	<-dispatched
+	<-reminded
+	<-swept
+
+	if natsPublisher != nil {
+		if err := natsPublisher.Close(); err != nil {
+			slog.Error("closing nats", "error", err)
+		}
+	}
+
+	if db != nil {
+		if err := db.Close(); err != nil {
+			slog.Error("closing database", "error", err)
+		}
+	}
+
+	if replicaDB != nil {
+		if err := replicaDB.Close(); err != nil {
+			slog.Error("closing read replica", "error", err)
+		}
+	}
+
+	if redisCache != nil {
+		if err := redisCache.Close(); err != nil {
+			slog.Error("closing redis", "error", err)
+		}
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("flushing traces", "error", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/codixir/smart-emerge-starter/document"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+// documentContentTypes are the types of file that can be uploaded, as
+// detected from their content.
+var documentContentTypes = []string{"application/pdf", "image/jpeg", "image/png"}
+
+// documentResponse is a document as the upload answers it.
+type documentResponse struct {
+	*store.Document
+	DownloadURL string `json:"downloadUrl"`
+}
+
+// uploadDocument attaches the file field of a multipart form to the patient
+// of the id route variable. The kind field is one of store.DocumentKinds and
+// the description field is optional. The type of the file is detected from
+// its content rather than trusted from the client, and only PDFs, JPEGs and
+// PNGs of up to maxBytes are accepted. The file is stored before its
+// metadata, and removed again when the metadata cannot be written, so a
+// document never points at a missing file.
+func uploadDocument(documents store.DocumentRepository, storage document.Storage, signer *document.Signer, maxBytes int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.UserIDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		if !middleware.HasRole(r.Context(), resolvers.WriteRoles...) {
+			http.Error(w, "uploading documents needs the admin or clinician role", http.StatusForbidden)
+			return
+		}
+
+		if storage == nil {
+			http.Error(w, "document storage is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		clinicID, ok := tenant.ClinicFromContext(r.Context())
+		if !ok {
+			http.Error(w, "the token has no clinic, pass one in the "+middleware.ClinicHeader+" header", http.StatusForbidden)
+			return
+		}
+
+		patientID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid patient id", http.StatusBadRequest)
+			return
+		}
+
+		// The form fields come on top of the file, so a little more than
+		// maxBytes is allowed.
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1<<20)
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			documentUploadError(w, err)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		d := &store.Document{
+			PatientID:   patientID,
+			Kind:        r.FormValue("kind"),
+			Description: strings.TrimSpace(r.FormValue("description")),
+		}
+		if !validDocumentKind(d.Kind) {
+			http.Error(w, fmt.Sprintf("kind must be one of %s, got %q", strings.Join(store.DocumentKinds, ", "), d.Kind), http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "the upload needs a file field", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		if header.Size > maxBytes {
+			http.Error(w, fmt.Sprintf("the file is larger than %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if header.Size == 0 {
+			http.Error(w, "the file is empty", http.StatusBadRequest)
+			return
+		}
+
+		sniff := make([]byte, 512)
+		n, err := io.ReadFull(file, sniff)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			http.Error(w, fmt.Sprintf("could not read the upload: %v", err), http.StatusBadRequest)
+			return
+		}
+		contentType, _, _ := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+		if !allowedDocumentType(contentType) {
+			http.Error(w, fmt.Sprintf("documents must be PDF, JPEG or PNG files, got %s", contentType), http.StatusUnsupportedMediaType)
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, fmt.Sprintf("could not read the upload: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		d.Filename = documentFilename(header.Filename)
+		d.ContentType = contentType
+		d.Size = header.Size
+
+		d.StorageKey, err = document.NewKey(clinicID, patientID)
+		if err != nil {
+			documentServerError(w, r, "could not store the document", err)
+			return
+		}
+
+		hash := sha256.New()
+		if err := storage.Put(r.Context(), d.StorageKey, io.TeeReader(file, hash), d.Size, d.ContentType); err != nil {
+			documentServerError(w, r, "could not store the document", err)
+			return
+		}
+		d.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+		if err := documents.Create(r.Context(), userID, d); err != nil {
+			if err := storage.Delete(r.Context(), d.StorageKey); err != nil {
+				slog.ErrorContext(r.Context(), "deleting document file", "error", err)
+			}
+
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, fmt.Sprintf("patient %d not found", patientID), http.StatusNotFound)
+				return
+			}
+			documentServerError(w, r, "could not save the document", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(documentResponse{Document: d, DownloadURL: signer.URL(d.ID, userID, clinicID)})
+	}
+}
+
+// downloadDocument serves the content of the document of the id route
+// variable to the holder of a link signed by signer, which needs no bearer
+// token so it can be opened by a browser. The document is read in the
+// clinic the link was issued for, and the download is recorded in the audit
+// log as a read of the patient by the user it was issued to.
+func downloadDocument(documents store.DocumentRepository, storage document.Storage, signer *document.Signer, accessLog AccessLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if storage == nil {
+			http.Error(w, "document storage is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "invalid document id", http.StatusBadRequest)
+			return
+		}
+
+		userID, clinicID, err := signer.Verify(id, r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		ctx := tenant.WithClinic(r.Context(), clinicID)
+
+		d, err := documents.Get(ctx, id)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, fmt.Sprintf("document %d not found", id), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			documentServerError(w, r, "could not read the document", err)
+			return
+		}
+
+		content, err := storage.Open(ctx, d.StorageKey)
+		if errors.Is(err, document.ErrNotFound) {
+			http.Error(w, fmt.Sprintf("document %d not found", id), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			documentServerError(w, r, "could not read the document", err)
+			return
+		}
+		defer content.Close()
+
+		if err := accessLog.LogAccess(ctx, "download_document", userID, []int{d.PatientID}); err != nil {
+			documentServerError(w, r, "could not record the download", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", d.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(d.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": d.Filename}))
+		w.Header().Set("Cache-Control", "no-store")
+
+		if _, err := io.Copy(w, content); err != nil && r.Context().Err() == nil {
+			slog.ErrorContext(r.Context(), "sending document", "document_id", id, "error", err)
+		}
+	}
+}
+
+func validDocumentKind(kind string) bool {
+	for _, k := range store.DocumentKinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+func allowedDocumentType(contentType string) bool {
+	for _, t := range documentContentTypes {
+		if t == contentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// documentFilename returns the base name of an uploaded file name, which
+// browsers may send as a path, or "document" when there is none.
+func documentFilename(name string) string {
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return "document"
+	}
+
+	return name
+}
+
+// documentUploadError answers an upload whose form could not be read.
+func documentUploadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("the upload is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	http.Error(w, fmt.Sprintf("the upload must be a multipart form: %v", err), http.StatusBadRequest)
+}
+
+func documentServerError(w http.ResponseWriter, r *http.Request, operation string, err error) {
+	slog.ErrorContext(r.Context(), "document error", "operation", operation, "error", err)
+	http.Error(w, operation, http.StatusInternalServerError)
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// exportFlushEvery is how many rows are written, and their reads audited,
+// between flushes.
+const exportFlushEvery = 100
+
+// AccessLog records reads of patient records.
+type AccessLog interface {
+	LogAccess(ctx context.Context, operation, performedBy string, patientIDs []int) error
+}
+
+// patientWriter writes exported patients in one format. flush pushes any
+// buffered rows to the underlying writer and close ends the document.
+type patientWriter interface {
+	write(p *store.Patient) error
+	flush() error
+	close() error
+}
+
+// exportPatients streams the patients as CSV or as a JSON array, chosen by
+// the Accept header (CSV by default). The optional parameters match the
+// arguments of getPatients: filter takes a JSON encoded PatientFilterInput,
+// includeDeleted=true adds soft-deleted patients, for admins only, and sortBy
+// (id, name or email) and sortOrder (asc or desc) order them, by id by
+// default. columns picks the fields written, as a comma separated list of
+// exportColumns. Rows are written in batches as they are read so memory use
+// does not grow with the table, and each batch is recorded in accessLog
+// before it is sent.
+func exportPatients(patients store.PatientRepository, accessLog AccessLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.UserIDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		if !middleware.HasRole(r.Context(), resolvers.ReadRoles...) {
+			http.Error(w, "export needs the admin, clinician or readonly role", http.StatusForbidden)
+			return
+		}
+
+		format, ok := exportFormat(r.Header.Get("Accept"))
+		if !ok {
+			http.Error(w, "export is available as text/csv or application/json", http.StatusNotAcceptable)
+			return
+		}
+
+		query := r.URL.Query()
+
+		var opts store.ListOptions
+		if v := query.Get("filter"); v != "" {
+			if err := json.Unmarshal([]byte(v), &opts.Filter); err != nil {
+				http.Error(w, fmt.Sprintf("invalid filter: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if v := query.Get("includeDeleted"); v != "" {
+			includeDeleted, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid includeDeleted %q, it must be true or false", v), http.StatusBadRequest)
+				return
+			}
+			if includeDeleted && !middleware.HasRole(r.Context(), middleware.RoleAdmin) {
+				http.Error(w, resolvers.ErrDeletedForbidden.Message, http.StatusForbidden)
+				return
+			}
+			opts.IncludeDeleted = includeDeleted
+		}
+
+		opts.SortBy = strings.ToLower(query.Get("sortBy"))
+		opts.SortOrder = strings.ToLower(query.Get("sortOrder"))
+		if err := store.CheckSort(opts.SortBy, opts.SortOrder); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		columns, err := selectExportColumns(query.Get("columns"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The headers are only sent with the first row, so a query that fails
+		// before then can still be answered with a 500. Once rows have been
+		// sent the status can no longer change, so later errors are only
+		// logged and the response is cut short.
+		var out patientWriter
+		begin := func() {
+			if out != nil {
+				return
+			}
+
+			w.Header().Set("Content-Type", format)
+			if format == "text/csv" {
+				w.Header().Set("Content-Disposition", `attachment; filename="patients.csv"`)
+				out = newCSVPatientWriter(w, columns)
+			} else {
+				out = newJSONPatientWriter(w, columns)
+			}
+		}
+
+		flusher, _ := w.(http.Flusher)
+		flush := func() error {
+			err := out.flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return err
+		}
+
+		var batch []*store.Patient
+		var writeErr error
+		writeBatch := func() error {
+			ids := make([]int, len(batch))
+			for i, patient := range batch {
+				ids[i] = patient.ID
+			}
+
+			if err := accessLog.LogAccess(r.Context(), "export", userID, ids); err != nil {
+				return err
+			}
+
+			begin()
+			for _, patient := range batch {
+				if writeErr = out.write(patient); writeErr != nil {
+					return writeErr
+				}
+			}
+			batch = batch[:0]
+
+			writeErr = flush()
+			return writeErr
+		}
+
+		err = patients.Each(r.Context(), opts, func(patient *store.Patient) error {
+			if batch = append(batch, patient); len(batch) < exportFlushEvery {
+				return nil
+			}
+			return writeBatch()
+		})
+		if err == nil && len(batch) > 0 {
+			err = writeBatch()
+		}
+
+		var coded *utils.CodedError
+		switch {
+		case writeErr != nil:
+			slog.WarnContext(r.Context(), "patient export aborted", "error", writeErr)
+			return
+		case errors.As(err, &coded) && out == nil:
+			http.Error(w, coded.Message, http.StatusBadRequest)
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "database error", "operation", "could not export patients", "error", err)
+			if out == nil {
+				http.Error(w, "could not export patients", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		begin()
+		out.close()
+		flush()
+	}
+}
+
+// exportFormat picks the export media type from an Accept header.
+func exportFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return "text/csv", true
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case "text/csv", "text/*", "*/*":
+			return "text/csv", true
+		case "application/json":
+			return "application/json", true
+		}
+	}
+
+	return "", false
+}
+
+// exportColumn is a patient field the export can write.
+type exportColumn struct {
+	name  string
+	value func(p *store.Patient) interface{}
+}
+
+// exportColumns are the fields the columns parameter can pick, in the order
+// they are written.
+var exportColumns = []exportColumn{
+	{"id", func(p *store.Patient) interface{} { return p.ID }},
+	{"name", func(p *store.Patient) interface{} { return p.Name }},
+	{"email", func(p *store.Patient) interface{} { return p.Email }},
+	{"phone", func(p *store.Patient) interface{} { return p.Phone }},
+	{"deletedAt", func(p *store.Patient) interface{} {
+		if p.DeletedAt == nil {
+			return nil
+		}
+		return p.DeletedAt.Format(time.RFC3339)
+	}},
+}
+
+// selectExportColumns returns the exportColumns named in the comma separated
+// list, or nil for an empty one so each format writes its default columns.
+func selectExportColumns(list string) ([]exportColumn, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	selected := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+
+		known := false
+		for _, column := range exportColumns {
+			known = known || column.name == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q, the columns are id, name, email, phone and deletedAt", name)
+		}
+
+		selected[name] = true
+	}
+
+	var columns []exportColumn
+	for _, column := range exportColumns {
+		if selected[column.name] {
+			columns = append(columns, column)
+		}
+	}
+
+	return columns, nil
+}
+
+type csvPatientWriter struct {
+	w       *csv.Writer
+	columns []exportColumn
+}
+
+// newCSVPatientWriter writes columns, by default every one but deletedAt,
+// starting with their header row.
+func newCSVPatientWriter(w io.Writer, columns []exportColumn) *csvPatientWriter {
+	if columns == nil {
+		columns = exportColumns[:4]
+	}
+
+	cw := &csvPatientWriter{w: csv.NewWriter(w), columns: columns}
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.name
+	}
+	cw.w.Write(header)
+
+	return cw
+}
+
+func (c *csvPatientWriter) write(p *store.Patient) error {
+	record := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		if value := column.value(p); value != nil {
+			record[i] = fmt.Sprint(value)
+		}
+	}
+
+	return c.w.Write(record)
+}
+
+func (c *csvPatientWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvPatientWriter) close() error {
+	return c.flush()
+}
+
+// jsonPatientWriter writes a JSON array one element at a time.
+type jsonPatientWriter struct {
+	w       io.Writer
+	enc     *json.Encoder
+	columns []exportColumn
+	count   int
+}
+
+// newJSONPatientWriter writes objects with columns, or whole patients when
+// columns is nil.
+func newJSONPatientWriter(w io.Writer, columns []exportColumn) *jsonPatientWriter {
+	io.WriteString(w, "[")
+	return &jsonPatientWriter{w: w, enc: json.NewEncoder(w), columns: columns}
+}
+
+func (j *jsonPatientWriter) write(p *store.Patient) error {
+	if j.count > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.count++
+
+	if j.columns == nil {
+		return j.enc.Encode(p)
+	}
+
+	object := make(map[string]interface{}, len(j.columns))
+	for _, column := range j.columns {
+		object[column.name] = column.value(p)
+	}
+
+	return j.enc.Encode(object)
+}
+
+func (j *jsonPatientWriter) flush() error {
+	return nil
+}
+
+func (j *jsonPatientWriter) close() error {
+	_, err := io.WriteString(j.w, "]\n")
+	return err
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/store/memory"
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+// exportRows is the number of patients TestExport exports.
+const exportRows = 1000
+
+// maxExportAlloc bounds the memory allocated while exporting exportRows
+// patients, which stays well below it when rows are streamed.
+const maxExportAlloc = 10 << 20
+
+func TestExport(t *testing.T) {
+	db := memory.New()
+	patients := memory.NewPatientStore(db)
+	ctx := tenant.WithClinic(context.Background(), 1)
+	for i := 0; i < exportRows; i++ {
+		name := fmt.Sprintf("Patient %d", i)
+		if i%10 == 0 {
+			name = fmt.Sprintf("Tenth %d", i)
+		}
+
+		_, err := patients.Create(ctx, "admin-1", name, fmt.Sprintf("patient%d@example.com", i), fmt.Sprintf("+1415555%04d", i), store.Demographics{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := newTestServerOn(t, Config{}, db)
+
+	tests := []struct {
+		name   string
+		accept string
+		filter string
+		rows   int
+	}{
+		{"csv", "text/csv", "", exportRows},
+		{"json", "application/json", "", exportRows},
+		{"csv filtered", "text/csv", `{"name": "tenth"}`, exportRows / 10},
+		{"json filtered", "application/json", `{"name": "tenth"}`, exportRows / 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/patients/export"
+			if tt.filter != "" {
+				path += "?filter=" + url.QueryEscape(tt.filter)
+			}
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+
+			resp := request(t, srv.URL, "GET", path, map[string]string{
+				"Authorization": bearer(t, "admin-1", middleware.RoleAdmin),
+				"Accept":        tt.accept,
+			})
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d", resp.StatusCode)
+			}
+			if got := resp.Header.Get("Content-Type"); got != tt.accept {
+				t.Errorf("Content-Type = %q, want %q", got, tt.accept)
+			}
+
+			rows := countExportRows(t, tt.accept, resp.Body)
+
+			runtime.ReadMemStats(&after)
+
+			if rows != tt.rows {
+				t.Errorf("exported %d rows, want %d", rows, tt.rows)
+			}
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > maxExportAlloc {
+				t.Errorf("export allocated %d bytes, want at most %d", alloc, maxExportAlloc)
+			}
+		})
+	}
+}
+
+// countExportRows returns the number of patients in an export of format,
+// checking the CSV header row and that the JSON is an array of patients.
+func countExportRows(t *testing.T, format string, body io.Reader) int {
+	t.Helper()
+
+	if format == "text/csv" {
+		r := csv.NewReader(bufio.NewReader(body))
+		header, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header[0] != "id" {
+			t.Errorf("header = %v, want the column names", header)
+		}
+
+		rows := 0
+		for {
+			_, err := r.Read()
+			if err == io.EOF {
+				return rows
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows++
+		}
+	}
+
+	dec := json.NewDecoder(body)
+	if token, err := dec.Token(); err != nil || token != json.Delim('[') {
+		t.Fatalf("export starts with %v, %v, want an array", token, err)
+	}
+
+	rows := 0
+	for dec.More() {
+		var patient struct{ ID int }
+		if err := dec.Decode(&patient); err != nil {
+			t.Fatal(err)
+		}
+		if patient.ID == 0 {
+			t.Errorf("row %d has no id", rows)
+		}
+		rows++
+	}
+
+	return rows
+}
+
+func TestExportIncludeDeleted(t *testing.T) {
+	srv := newTestServer(t, Config{})
+
+	tests := []struct {
+		name   string
+		query  string
+		role   string
+		status int
+	}{
+		{"admin", "?includeDeleted=true", middleware.RoleAdmin, http.StatusOK},
+		{"clinician", "?includeDeleted=true", middleware.RoleClinician, http.StatusForbidden},
+		{"readonly", "?includeDeleted=true", middleware.RoleReadonly, http.StatusForbidden},
+		{"clinician without deleted", "?includeDeleted=false", middleware.RoleClinician, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := get(t, srv, "/patients/export"+tt.query, "user-1", tt.role)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestExportRequests(t *testing.T) {
+	srv := newTestServer(t, Config{})
+
+	tests := []struct {
+		name   string
+		query  string
+		header map[string]string
+		status int
+	}{
+		{"unauthenticated", "", map[string]string{}, http.StatusUnauthorized},
+		{"no role", "", map[string]string{"Authorization": bearer(t, "user-1")}, http.StatusForbidden},
+		{"unknown format", "", map[string]string{"Authorization": bearer(t, "user-1", middleware.RoleReadonly), "Accept": "application/xml"}, http.StatusNotAcceptable},
+		{"bad filter", "?filter=name", map[string]string{"Authorization": bearer(t, "user-1", middleware.RoleReadonly)}, http.StatusBadRequest},
+		{"bad sort", "?sortBy=phone", map[string]string{"Authorization": bearer(t, "user-1", middleware.RoleReadonly)}, http.StatusBadRequest},
+		{"bad column", "?columns=ssn", map[string]string{"Authorization": bearer(t, "user-1", middleware.RoleReadonly)}, http.StatusBadRequest},
+		{"readonly", "", map[string]string{"Authorization": bearer(t, "user-1", middleware.RoleReadonly)}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := request(t, srv.URL, "GET", "/patients/export"+tt.query, tt.header)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+
+	"github.com/codixir/smart-emerge-starter/complexity"
+	"github.com/codixir/smart-emerge-starter/logger"
+	"github.com/codixir/smart-emerge-starter/metrics"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/persisted"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/tracing"
+)
+
+// graphqlRequest is the body of a GraphQL request sent as application/json.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    requestExtensions      `json:"extensions"`
+}
+
+// requestExtensions are the extensions of a GraphQL request the server reads.
+type requestExtensions struct {
+	// PersistedQuery carries the hash of the query, sent instead of or
+	// along with its text.
+	PersistedQuery *persisted.Extension `json:"persistedQuery"`
+}
+
+// parseGraphQLRequest reads the GraphQL request from a JSON body when the
+// request is sent as application/json, and from the query string otherwise,
+// where variables and extensions are passed as JSON encoded strings. A
+// request without a query or a persisted query hash is rejected.
+func parseGraphQLRequest(r *http.Request) (graphqlRequest, error) {
+	var req graphqlRequest
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if r.Method == http.MethodPost && mediaType == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, fmt.Errorf("invalid JSON body: %w", err)
+		}
+	} else {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				return req, fmt.Errorf("invalid variables parameter: %v", err)
+			}
+		}
+
+		if extensions := r.URL.Query().Get("extensions"); extensions != "" {
+			if err := json.Unmarshal([]byte(extensions), &req.Extensions); err != nil {
+				return req, fmt.Errorf("invalid extensions parameter: %v", err)
+			}
+		}
+	}
+
+	if strings.TrimSpace(req.Query) == "" && req.Extensions.PersistedQuery == nil {
+		return req, fmt.Errorf("query is required")
+	}
+
+	return req, nil
+}
+
+// graphqlHandler executes GET and POST GraphQL requests against s, rejecting
+// queries over limits, measured with costs, before they run. Introspection also needs a bearer
+// token, so anonymous callers cannot read the schema. Queries may be sent by
+// their persisted hash, and only the ones queries allows are run. Executed
+// operations are traced, logged, and recorded in m unless it is nil. Errors
+// carry the request ID in their extensions.
+func graphqlHandler(s graphql.Schema, costs complexity.Costs, resolver *resolvers.Resolver, queries *persisted.Queries, limits complexity.Limits, m *metrics.Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "only GET and POST are supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req, err := parseGraphQLRequest(r)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			http.Error(w, fmt.Sprintf("the request body is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sentText := req.Query != ""
+		if req.Query, err = queries.Resolve(r.Context(), req.Query, req.Extensions.PersistedQuery); err != nil {
+			// Apollo clients only retry with the query text on a 200.
+			code := http.StatusBadRequest
+			if errors.Is(err, persisted.ErrNotFound) || errors.Is(err, persisted.ErrNotSupported) {
+				code = http.StatusOK
+			}
+			writeErrors(w, r.Context(), code, err)
+			return
+		}
+
+		_, authenticated := middleware.UserIDFromContext(r.Context())
+
+		checked := limits
+		if !authenticated {
+			checked.DisableIntrospection = true
+		}
+
+		if err := complexity.Check(req.Query, req.OperationName, costs, checked); err != nil {
+			writeErrors(w, r.Context(), http.StatusBadRequest, err)
+			return
+		}
+
+		// Only callers with a token register queries, so anonymous clients
+		// cannot fill the table.
+		if sentText && authenticated {
+			if err := queries.Register(r.Context(), req.Query, req.OperationName, req.Extensions.PersistedQuery); err != nil {
+				slog.WarnContext(r.Context(), "could not register a persisted query", "error", err)
+			}
+		}
+
+		operationType, rootFields, operationName := describeOperation(req.Query, req.OperationName)
+		ctx, span := tracing.StartOperation(r.Context(), operationType, rootFields, req.OperationName)
+
+		start := time.Now()
+		result := graphql.Do(graphql.Params{
+			Context:        resolver.WithLoaders(resolvers.WithIdempotencyKey(ctx, r.Header.Get(middleware.IdempotencyKeyHeader))),
+			Schema:         s,
+			RequestString:  req.Query,
+			OperationName:  req.OperationName,
+			VariableValues: req.Variables,
+		})
+		tracing.EndOperation(span, result)
+		elapsed := time.Since(start)
+
+		if m != nil {
+			m.ObserveOperation(operationType, rootFields, elapsed, result)
+		}
+		logOperation(ctx, operationType, rootFields, operationName, elapsed, result)
+		result.Errors = withRequestID(ctx, result.Errors)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := writeResult(w, result); err != nil {
+			slog.WarnContext(ctx, "graphql response aborted", "error", err)
+		}
+	}
+}
+
+// writeErrors answers a request rejected before it ran with err as the only
+// GraphQL error, with the code of a *utils.CodedError in its extensions.
+func writeErrors(w http.ResponseWriter, ctx context.Context, code int, err error) {
+	formatted := gqlerrors.FormatError(err)
+
+	var extended gqlerrors.ExtendedError
+	if errors.As(err, &extended) {
+		formatted.Extensions = extended.Extensions()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	errs := withRequestID(ctx, []gqlerrors.FormattedError{formatted})
+	json.NewEncoder(w).Encode(&graphql.Result{Errors: errs})
+}
+
+// logOperation logs an executed operation with its caller, duration and
+// outcome, and the error codes when it failed. The request ID is added from
+// ctx by the logger.
+func logOperation(ctx context.Context, operationType string, rootFields []string, operationName string, d time.Duration, result *graphql.Result) {
+	userID, _ := middleware.UserIDFromContext(ctx)
+
+	outcome := "success"
+	var codes []string
+	if result.HasErrors() {
+		outcome = "error"
+
+		for _, err := range result.Errors {
+			code, _ := err.Extensions["code"].(string)
+			if code == "" {
+				code = "UNKNOWN"
+			}
+			codes = append(codes, code)
+		}
+	}
+
+	attrs := []interface{}{
+		"operation_type", operationType,
+		"operation_name", operationName,
+		"fields", rootFields,
+		"user_id", userID,
+		"duration_ms", float64(d.Microseconds()) / 1000,
+		"outcome", outcome,
+	}
+	if len(codes) > 0 {
+		attrs = append(attrs, "error_codes", codes)
+	}
+
+	slog.InfoContext(ctx, "graphql operation", attrs...)
+}
+
+// withRequestID adds the request ID of ctx to the extensions of errs as
+// requestId, so a user reporting an error can be matched with its logs.
+func withRequestID(ctx context.Context, errs []gqlerrors.FormattedError) []gqlerrors.FormattedError {
+	id, ok := logger.RequestIDFromContext(ctx)
+	if !ok {
+		return errs
+	}
+
+	for i := range errs {
+		extensions := make(map[string]interface{}, len(errs[i].Extensions)+1)
+		for key, value := range errs[i].Extensions {
+			extensions[key] = value
+		}
+		extensions["requestId"] = id
+
+		errs[i].Extensions = extensions
+	}
+
+	return errs
+}
+
+// describeOperation returns the type and the sorted root field names of the
+// operation a request runs, which label its metrics and spans without the
+// unbounded operation names clients choose, and its name for the logs, empty
+// when it is anonymous. Unparsable queries are "unknown".
+func describeOperation(query, operationName string) (string, []string, string) {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return "unknown", nil, operationName
+	}
+
+	for _, definition := range doc.Definitions {
+		op, ok := definition.(*ast.OperationDefinition)
+		if !ok || (operationName != "" && (op.Name == nil || op.Name.Value != operationName)) {
+			continue
+		}
+
+		var fields []string
+		for _, selection := range op.SelectionSet.Selections {
+			if field, ok := selection.(*ast.Field); ok {
+				fields = append(fields, field.Name.Value)
+			}
+		}
+		sort.Strings(fields)
+
+		name := ""
+		if op.Name != nil {
+			name = op.Name.Value
+		}
+
+		return op.Operation, fields, name
+	}
+
+	return "unknown", nil, operationName
+}
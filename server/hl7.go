@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/codixir/smart-emerge-starter/hl7"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/pubsub"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/tenant"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// hl7MaxBytes bounds the size of an HL7 message.
+const hl7MaxBytes = 1 << 20
+
+// hl7ContentType is the media type of HL7 v2 messages in their pipe encoding.
+const hl7ContentType = "x-application/hl7-v2+er7"
+
+// ingestADT creates or updates a patient from the HL7 v2 ADT^A01, ADT^A04 or
+// ADT^A08 message sent as the request body, matching the patient by email
+// like the CSV import, and answers with an HL7 ACK. The demographics of the
+// message replace the stored ones it has, keeping those it leaves out. Messages that cannot be
+// parsed or hold an invalid patient are answered with an AE, or an AR for
+// other message types, and recorded in deadLetters. The HTTP status is 200
+// whenever the message was acknowledged, so interface engines read the
+// outcome from the ACK, and 500 when the message could not be processed or
+// recorded and should be sent again.
+func ingestADT(patients store.PatientRepository, deadLetters store.HL7DeadLetterRepository, events *pubsub.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.UserIDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		if !middleware.HasRole(r.Context(), resolvers.WriteRoles...) {
+			http.Error(w, "HL7 ingestion needs the admin or clinician role", http.StatusForbidden)
+			return
+		}
+
+		if _, ok := tenant.ClinicFromContext(r.Context()); !ok {
+			http.Error(w, "the token has no clinic, pass one in the "+middleware.ClinicHeader+" header", http.StatusForbidden)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, hl7MaxBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("the message is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, fmt.Sprintf("could not read the message: %v", err), http.StatusBadRequest)
+			return
+		}
+		raw := string(body)
+
+		// The header is read on its own, so messages whose patient cannot be
+		// read are still acknowledged with their control ID.
+		header, _ := hl7.ParseHeader(raw)
+
+		reject := func(code string, reason error) {
+			l := &store.HL7DeadLetter{ControlID: header.ControlID, Message: raw, Error: reason.Error()}
+			if err := deadLetters.Add(r.Context(), userID, l); err != nil {
+				slog.ErrorContext(r.Context(), "database error", "operation", "could not record the HL7 message", "error", err)
+				writeAck(w, http.StatusInternalServerError, hl7.Ack(header, hl7.AckError, "the message could not be recorded, send it again"))
+				return
+			}
+
+			slog.WarnContext(r.Context(), "HL7 message rejected", "control_id", header.ControlID, "dead_letter_id", l.ID, "error", reason)
+			writeAck(w, http.StatusOK, hl7.Ack(header, code, reason.Error()))
+		}
+
+		message, err := hl7.ParseADT(raw)
+		if errors.Is(err, hl7.ErrUnsupported) {
+			reject(hl7.AckReject, err)
+			return
+		}
+		if err != nil {
+			reject(hl7.AckError, err)
+			return
+		}
+
+		demographics, err := resolvers.HL7Patient(&message.Patient)
+		if err != nil {
+			reject(hl7.AckError, err)
+			return
+		}
+		patient := &store.Patient{Name: message.Patient.Name, Email: message.Patient.Email, Phone: message.Patient.Phone, Demographics: demographics}
+
+		results, err := patients.Upsert(r.Context(), userID, []*store.Patient{patient})
+		if err == nil {
+			err = results[0].Err
+		}
+
+		var coded *utils.CodedError
+		if errors.As(err, &coded) {
+			reject(hl7.AckError, coded)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "database error", "operation", "could not ingest the HL7 message", "control_id", header.ControlID, "error", err)
+			writeAck(w, http.StatusInternalServerError, hl7.Ack(header, hl7.AckError, "the patient could not be saved, send the message again"))
+			return
+		}
+
+		switch result := results[0]; result.Status {
+		case store.UpsertCreated:
+			events.Publish(resolvers.Topic(resolvers.PatientCreated, result.Patient.ClinicID), result.Patient)
+		case store.UpsertUpdated:
+			events.Publish(resolvers.Topic(resolvers.PatientUpdated, result.Patient.ClinicID), result.Patient)
+		}
+
+		writeAck(w, http.StatusOK, hl7.Ack(message.Header, hl7.AckAccept, ""))
+	}
+}
+
+func writeAck(w http.ResponseWriter, status int, ack string) {
+	w.Header().Set("Content-Type", hl7ContentType)
+	w.WriteHeader(status)
+	io.WriteString(w, ack)
+}
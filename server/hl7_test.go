@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/pubsub"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/store/memory"
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+// adt returns an ADT message of event with the PID segment pid.
+func adt(event, pid string) string {
+	return "MSH|^~\\&|REGADT|MCM|SE|SE|20240301100000||ADT^" + event + "|MSG00001|P|2.5\rEVN|" + event + "|20240301100000\r" + pid
+}
+
+func TestIngestADTDemographics(t *testing.T) {
+	db := memory.New()
+	ctx := tenant.WithClinic(context.Background(), 1)
+	patients := memory.NewPatientStore(db)
+	srv := newTestServerWith(t, Config{}, db, patients, pubsub.NewBroker())
+
+	ingest := func(t *testing.T, message string) string {
+		t.Helper()
+
+		req, err := http.NewRequest("POST", srv.URL+"/hl7/adt", strings.NewReader(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", hl7ContentType)
+		req.Header.Set("Authorization", bearer(t, "clinician-1", middleware.RoleClinician))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		ack, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", resp.StatusCode, http.StatusOK, ack)
+		}
+		return string(ack)
+	}
+
+	stored := func(t *testing.T) *store.Patient {
+		t.Helper()
+
+		list, _, err := patients.List(ctx, store.ListOptions{Filter: store.PatientFilter{EmailEquals: "john@example.com"}, Limit: 10})
+		if err != nil || len(list) != 1 {
+			t.Fatalf("patients with the email = %v, %v, want one", list, err)
+		}
+		return list[0]
+	}
+
+	ack := ingest(t, adt("A04", "PID|||12345^^^MCM^MR||Doe^John||19610615|M|||1200 N ELM STREET^^GREENSBORO^NC^27401^US^H||+14155552671^PRN^PH^john@example.com"))
+	if !strings.Contains(ack, "MSA|AA|MSG00001") {
+		t.Fatalf("ACK of the ADT^A04 = %q, want an AA", ack)
+	}
+
+	patient := stored(t)
+	if patient.BirthDate == nil || patient.BirthDate.Format("2006-01-02") != "1961-06-15" || patient.Gender != store.GenderMale {
+		t.Errorf("birth date %v and gender %q, want 1961-06-15 and male", patient.BirthDate, patient.Gender)
+	}
+	if fmt.Sprint(patient.Identifiers) != "[{mrn MCM 12345}]" {
+		t.Errorf("identifiers = %+v, want the MCM medical record number 12345", patient.Identifiers)
+	}
+	if fmt.Sprint(patient.Addresses) != "[{home [1200 N ELM STREET] GREENSBORO NC 27401 US}]" {
+		t.Errorf("addresses = %+v, want the home address in GREENSBORO", patient.Addresses)
+	}
+
+	// An update with a new address keeps the birth date and gender it does
+	// not send.
+	ack = ingest(t, adt("A08", "PID|||12345^^^MCM^MR||Doe^John||||||9 Oak Ave^^DURHAM^NC^27701^US^H||+14155552671^PRN^PH^john@example.com"))
+	if !strings.Contains(ack, "MSA|AA|MSG00001") {
+		t.Fatalf("ACK of the ADT^A08 = %q, want an AA", ack)
+	}
+
+	patient = stored(t)
+	if fmt.Sprint(patient.Addresses) != "[{home [9 Oak Ave] DURHAM NC 27701 US}]" || patient.Version != 2 {
+		t.Errorf("addresses = %+v at version %d, want the home address in DURHAM at version 2", patient.Addresses, patient.Version)
+	}
+	if patient.BirthDate == nil || patient.Gender != store.GenderMale {
+		t.Errorf("birth date %v and gender %q, want them kept", patient.BirthDate, patient.Gender)
+	}
+
+	for _, tt := range []struct {
+		name, pid, err string
+	}{
+		{"no email", "PID|||1||Roe^Jane||||||||+14155550100^PRN^PH", "email"},
+		{"birth date in the future", "PID|||1||Roe^Jane||29800101||||||+14155550100^PRN^PH^jane@example.com", "birthDate"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := ingest(t, adt("A04", tt.pid))
+			if !strings.Contains(ack, "MSA|AE|MSG00001") || !strings.Contains(ack, tt.err) {
+				t.Errorf("ACK = %q, want an AE about %s", ack, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/pubsub"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/tenant"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+const (
+	// importMaxBytes bounds the size of an import upload.
+	importMaxBytes = 10 << 20
+	// importBatchSize is how many rows are upserted per transaction.
+	importBatchSize = 500
+)
+
+// importReport is the response of an import. Errors has an entry for every
+// row that was not imported.
+type importReport struct {
+	Created   int              `json:"created"`
+	Updated   int              `json:"updated"`
+	Unchanged int              `json:"unchanged"`
+	Failed    int              `json:"failed"`
+	Errors    []importRowError `json:"errors"`
+	// Error is set when the import stopped early. The rows of the batches
+	// before it were imported and are counted above.
+	Error string `json:"error,omitempty"`
+}
+
+// importRowError explains why the row on Line was not imported, either with
+// the invalid Fields or with a Message.
+type importRowError struct {
+	Line    int                     `json:"line"`
+	Fields  []validation.FieldError `json:"fields,omitempty"`
+	Message string                  `json:"message,omitempty"`
+}
+
+// importRow is a valid row waiting to be upserted.
+type importRow struct {
+	line    int
+	patient *store.Patient
+}
+
+// importPatients creates and updates patients from a CSV upload, sent as the
+// request body or as the file field of a multipart form. The header row
+// names the name, email and phone columns in any order; an id column, as
+// written by the export, is ignored since rows are matched by email. Every
+// row is validated before anything is written, so a malformed file changes
+// nothing, and the valid rows are then upserted in transactions of
+// importBatchSize rows. An import that did not stop early is published as a
+// patient.bulk_import_completed event to the outbox and to the
+// patientBulkImportCompleted subscribers of the clinic; when the event
+// cannot be written the import still succeeds, with a warning logged.
+func importPatients(patients store.PatientRepository, events *pubsub.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.UserIDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		if !middleware.HasRole(r.Context(), resolvers.WriteRoles...) {
+			http.Error(w, "import needs the admin or clinician role", http.StatusForbidden)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+
+		upload, err := importUpload(r)
+		if err != nil {
+			importError(w, err)
+			return
+		}
+		defer upload.Close()
+
+		report := &importReport{Errors: []importRowError{}}
+
+		rows, err := readImportRows(upload, report)
+		if err != nil {
+			importError(w, err)
+			return
+		}
+
+		for start := 0; start < len(rows); start += importBatchSize {
+			batch := rows[start:min(start+importBatchSize, len(rows))]
+
+			if err := upsertBatch(r, patients, events, userID, batch, report); err != nil {
+				slog.ErrorContext(r.Context(), "database error", "operation", "could not import patients", "error", err)
+				report.Error = fmt.Sprintf("the import stopped at line %d, the rows before it were imported", batch[0].line)
+				writeImportReport(w, http.StatusInternalServerError, report)
+				return
+			}
+		}
+
+		summary := store.ImportSummary{
+			ImportedCount: report.Created + report.Updated + report.Unchanged,
+			Created:       report.Created,
+			Updated:       report.Updated,
+			Unchanged:     report.Unchanged,
+			Failed:        report.Failed,
+		}
+		if err := patients.CompleteImport(r.Context(), summary); err != nil {
+			slog.WarnContext(r.Context(), "could not publish the completed import", "imported", summary.ImportedCount, "error", err)
+		}
+
+		clinicID, _ := tenant.ClinicFromContext(r.Context())
+		events.Publish(resolvers.Topic(resolvers.PatientBulkImportCompleted, clinicID), &resolvers.BulkImportCompleted{
+			Event:         resolvers.BulkImportCompletedEvent,
+			ImportedCount: summary.ImportedCount,
+			Timestamp:     time.Now().UTC(),
+		})
+
+		writeImportReport(w, http.StatusOK, report)
+	}
+}
+
+// importUpload returns the CSV file of an import request.
+func importUpload(r *http.Request) (io.ReadCloser, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// readImportRows parses and validates the rows of upload, adding the invalid
+// ones to report. The error is set when the file itself cannot be read.
+func readImportRows(upload io.Reader, report *importReport) ([]importRow, error) {
+	reader := csv.NewReader(upload)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, badImportError("the file is empty, it needs a header row with name, email and phone")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := importColumns(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			if i := columns[name]; i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		patient := &store.Patient{Name: field("name"), Email: field("email"), Phone: field("phone")}
+
+		if err := validation.Patient(&patient.Name, &patient.Email, &patient.Phone); err != nil {
+			fields, _ := err.(validation.Errors)
+			report.Failed++
+			report.Errors = append(report.Errors, importRowError{Line: line, Fields: fields})
+			continue
+		}
+
+		rows = append(rows, importRow{line: line, patient: patient})
+	}
+}
+
+// importColumns maps the columns of an import header to their index.
+func importColumns(header []string) (map[string]int, error) {
+	columns := map[string]int{}
+
+	for i, name := range header {
+		if i == 0 {
+			// Excel starts the CSV files it saves with a byte order mark.
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		switch name {
+		case "id":
+			continue
+		case "name", "email", "phone":
+		default:
+			return nil, badImportError(fmt.Sprintf("unknown column %q, the columns are name, email and phone", name))
+		}
+
+		if _, ok := columns[name]; ok {
+			return nil, badImportError(fmt.Sprintf("column %q appears twice", name))
+		}
+		columns[name] = i
+	}
+
+	for _, name := range []string{"name", "email", "phone"} {
+		if _, ok := columns[name]; !ok {
+			return nil, badImportError(fmt.Sprintf("the header row has no %s column", name))
+		}
+	}
+
+	return columns, nil
+}
+
+// upsertBatch upserts the patients of batch in one transaction and adds the
+// outcome of each to report, publishing the created and updated ones.
+func upsertBatch(r *http.Request, patients store.PatientRepository, events *pubsub.Broker, userID string, batch []importRow, report *importReport) error {
+	toUpsert := make([]*store.Patient, len(batch))
+	for i, row := range batch {
+		toUpsert[i] = row.patient
+	}
+
+	results, err := patients.Upsert(r.Context(), userID, toUpsert)
+	if err != nil {
+		return err
+	}
+
+	for i, result := range results {
+		var coded *utils.CodedError
+		switch {
+		case errors.As(result.Err, &coded):
+			report.Failed++
+			report.Errors = append(report.Errors, importRowError{Line: batch[i].line, Message: coded.Message})
+		case result.Err != nil:
+			slog.ErrorContext(r.Context(), "database error", "operation", "could not import patient", "line", batch[i].line, "error", result.Err)
+			report.Failed++
+			report.Errors = append(report.Errors, importRowError{Line: batch[i].line, Message: "could not import the row"})
+		case result.Status == store.UpsertCreated:
+			report.Created++
+			events.Publish(resolvers.Topic(resolvers.PatientCreated, result.Patient.ClinicID), result.Patient)
+		case result.Status == store.UpsertUpdated:
+			report.Updated++
+			events.Publish(resolvers.Topic(resolvers.PatientUpdated, result.Patient.ClinicID), result.Patient)
+		default:
+			report.Unchanged++
+		}
+	}
+
+	return nil
+}
+
+// badImportError is an import file that is valid CSV but cannot be used.
+type badImportError string
+
+func (e badImportError) Error() string {
+	return string(e)
+}
+
+// importError answers an import whose file could not be read.
+func importError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	var parseErr *csv.ParseError
+	var badImport badImportError
+
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("the upload is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+	case errors.As(err, &parseErr):
+		http.Error(w, fmt.Sprintf("invalid CSV, nothing was imported: %v", parseErr), http.StatusBadRequest)
+	case errors.As(err, &badImport):
+		http.Error(w, string(badImport)+", nothing was imported", http.StatusBadRequest)
+	default:
+		http.Error(w, fmt.Sprintf("could not read the upload: %v", err), http.StatusBadRequest)
+	}
+}
+
+func writeImportReport(w http.ResponseWriter, status int, report *importReport) {
+	sort.SliceStable(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
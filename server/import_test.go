@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/pubsub"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/store/memory"
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+// completingPatients records the imports completed, failing them with err.
+type completingPatients struct {
+	store.PatientRepository
+	completed []store.ImportSummary
+	err       error
+}
+
+func (p *completingPatients) CompleteImport(ctx context.Context, summary store.ImportSummary) error {
+	p.completed = append(p.completed, summary)
+	return p.err
+}
+
+func TestImportPublishesCompletion(t *testing.T) {
+	tests := []struct {
+		name   string
+		csv    string
+		status int
+		// completed is the summary written to the outbox, nil for none.
+		completed *store.ImportSummary
+		// completeErr fails writing the summary.
+		completeErr error
+	}{
+		{
+			name:      "created, updated, unchanged and invalid rows",
+			csv:       "name,email,phone\nBob Ray,bob@example.com,+14155550111\nAnn Lee,ann@example.com,+14155550199\nCy Day,cy@example.com,+14155550122\nNo Email,,+14155550133\n",
+			status:    http.StatusOK,
+			completed: &store.ImportSummary{ImportedCount: 3, Created: 1, Updated: 1, Unchanged: 1, Failed: 1},
+		},
+		{name: "no rows", csv: "name,email,phone\n", status: http.StatusOK, completed: &store.ImportSummary{}},
+		{
+			name:        "the event cannot be written",
+			csv:         "name,email,phone\nBob Ray,bob@example.com,+14155550111\n",
+			status:      http.StatusOK,
+			completed:   &store.ImportSummary{ImportedCount: 1, Created: 1},
+			completeErr: errors.New("connection reset"),
+		},
+		{name: "unknown column", csv: "name,email,fax\n", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.New()
+			ctx := tenant.WithClinic(context.Background(), 1)
+			patients := memory.NewPatientStore(db)
+			for _, p := range [][3]string{{"Ann Lee", "ann@example.com", "+14155550100"}, {"Cy Day", "cy@example.com", "+14155550122"}} {
+				if _, err := patients.Create(ctx, "admin-1", p[0], p[1], p[2], store.Demographics{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			events := pubsub.NewBroker()
+			subscribed, unsubscribe := events.Subscribe(resolvers.Topic(resolvers.PatientBulkImportCompleted, 1))
+			defer unsubscribe()
+			recording := &completingPatients{PatientRepository: patients, err: tt.completeErr}
+			srv := newTestServerWith(t, Config{}, db, recording, events)
+
+			req, err := http.NewRequest("POST", srv.URL+"/patients/import", strings.NewReader(tt.csv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "text/csv")
+			req.Header.Set("Authorization", bearer(t, "clinician-1", middleware.RoleClinician))
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+
+			if tt.completed == nil {
+				if len(recording.completed) != 0 {
+					t.Errorf("completed imports = %+v, want none", recording.completed)
+				}
+				return
+			}
+			if len(recording.completed) != 1 || recording.completed[0] != *tt.completed {
+				t.Errorf("completed imports = %+v, want [%+v]", recording.completed, *tt.completed)
+			}
+
+			// The subscribers get the event before the report is written.
+			select {
+			case payload := <-subscribed:
+				event, ok := payload.(*resolvers.BulkImportCompleted)
+				if !ok || event.Event != "patient.bulk_import_completed" || event.ImportedCount != tt.completed.ImportedCount {
+					t.Errorf("subscription event = %+v, want patient.bulk_import_completed with %d imported", payload, tt.completed.ImportedCount)
+				}
+			default:
+				t.Error("no subscription event")
+			}
+		})
+	}
+}
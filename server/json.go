@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/graphql-go/graphql"
+)
+
+// writeResult writes result to w as json.Encoder would, but an object member
+// or list element at a time through a small buffer, so a long list of
+// patients is sent as it is encoded rather than encoded whole first.
+func writeResult(w io.Writer, result *graphql.Result) error {
+	b := bufio.NewWriter(w)
+
+	b.WriteString(`{"data":`)
+	if err := writeJSON(b, result.Data); err != nil {
+		return err
+	}
+
+	if len(result.Errors) > 0 {
+		b.WriteString(`,"errors":[`)
+		for i, formatted := range result.Errors {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+
+			if err := writeJSON(b, formatted); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	}
+
+	b.WriteString("}\n")
+	return b.Flush()
+}
+
+// writeJSON writes v to b, walking the maps and slices graphql-go builds
+// results of and marshalling the rest. The keys of maps are written sorted,
+// as json.Marshal writes them.
+func writeJSON(b *bufio.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+
+			name, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			b.Write(name)
+			b.WriteByte(':')
+
+			if err := writeJSON(b, v[key]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+
+	case []interface{}:
+		b.WriteByte('[')
+		for i, element := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+
+			if err := writeJSON(b, element); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b.Write(encoded)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// NewRedirect returns a plain HTTP server on addr redirecting every request
+// to the same host and path over HTTPS, on the port of the HTTPS server cfg
+// describes. When challenges is set, such as the HTTPHandler of an autocert
+// Manager, it wraps the redirect so it can answer ACME HTTP-01 challenges.
+func NewRedirect(addr string, cfg Config, challenges func(fallback http.Handler) http.Handler) *http.Server {
+	_, httpsPort, _ := net.SplitHostPort(cfg.Addr)
+
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hostname := strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+		if name, _, err := net.SplitHostPort(r.Host); err == nil {
+			hostname = name
+		}
+
+		host := hostname
+		switch {
+		case httpsPort != "" && httpsPort != "443":
+			host = net.JoinHostPort(hostname, httpsPort)
+		case strings.Contains(hostname, ":"):
+			host = "[" + hostname + "]"
+		}
+
+		// 308 rather than 301, so clients repeat POST requests as POST.
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+	if challenges != nil {
+		h = challenges(h)
+	}
+
+	return &http.Server{
+		Addr:         addr,
+		Handler:      h,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+// request sends method to path on srv with the given headers.
+func request(t *testing.T, srvURL, method, path string, header map[string]string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srvURL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func TestCORSPreflight(t *testing.T) {
+	srv := newTestServer(t, Config{CORSAllowedOrigins: []string{"https://app.example.com"}})
+
+	tests := []struct {
+		name   string
+		path   string
+		origin string
+		status int
+		acao   string
+	}{
+		{"GraphQL from an allowed origin", "/patient", "https://app.example.com", http.StatusNoContent, "https://app.example.com"},
+		{"GraphQL from another origin", "/patient", "https://evil.example.com", http.StatusForbidden, ""},
+		{"FHIR from an allowed origin", "/fhir/Patient/1", "https://app.example.com", http.StatusNoContent, "https://app.example.com"},
+		{"export from an allowed origin", "/patients/export", "https://app.example.com", http.StatusNoContent, "https://app.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := request(t, srv.URL, "OPTIONS", tt.path, map[string]string{
+				"Origin":                        tt.origin,
+				"Access-Control-Request-Method": "POST",
+			})
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.acao {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.acao)
+			}
+		})
+	}
+}
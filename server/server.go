@@ -0,0 +1,196 @@
+// Package server wires the HTTP routes, middleware and GraphQL endpoint
+// together.
+package server
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/complexity"
+	"github.com/codixir/smart-emerge-starter/document"
+	"github.com/codixir/smart-emerge-starter/fhir"
+	"github.com/codixir/smart-emerge-starter/handler"
+	"github.com/codixir/smart-emerge-starter/logger"
+	"github.com/codixir/smart-emerge-starter/metrics"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/persisted"
+	"github.com/codixir/smart-emerge-starter/pubsub"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/tracing"
+)
+
+// Config holds the server settings.
+type Config struct {
+	Addr         string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// JWTSecret verifies HS256 bearer tokens.
+	JWTSecret []byte
+	// RateLimitRPS is the per-client request rate; zero disables limiting.
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// TokenRateLimitRPS is the request rate of each authenticated user;
+	// zero disables limiting.
+	TokenRateLimitRPS   float64
+	TokenRateLimitBurst int
+	// MaxRequestBytes bounds the bodies of GraphQL and FHIR requests and
+	// the subscription messages; zero disables it.
+	MaxRequestBytes int64
+	// CompressionMinBytes is the smallest response body compressed for
+	// clients accepting gzip or deflate; zero disables compression.
+	CompressionMinBytes int
+	// TrustProxy takes the client IP from X-Forwarded-For, for rate limiting
+	// and the audit log, which only servers behind a proxy should do.
+	TrustProxy bool
+	// CORSAllowedOrigins lists the origins allowed to call the API, "*"
+	// allowing any.
+	CORSAllowedOrigins []string
+	// HSTSMaxAge is sent in Strict-Transport-Security when positive, which
+	// only HTTPS servers should do.
+	HSTSMaxAge time.Duration
+	// QueryLimits bounds the depth and complexity of GraphQL queries.
+	QueryLimits complexity.Limits
+	// RequestTimeout bounds GraphQL and FHIR requests and each subscription
+	// event; zero disables it.
+	RequestTimeout time.Duration
+	// GraphQLEndpoint is the path the GraphQL Playground and GraphiQL send
+	// queries to.
+	GraphQLEndpoint string
+	// Production disables the development-only endpoints.
+	Production bool
+	// SimulateLoad serves /admin/simulate-load outside production.
+	SimulateLoad bool
+	// GraphiQL serves the GraphiQL IDE at /graphiql to admins, with its
+	// vendored files or, when set, those of the GraphiQLAssetsDir directory.
+	GraphiQL          bool
+	GraphiQLAssetsDir string
+}
+
+// Deps are the services the routes are served from.
+type Deps struct {
+	Logger *slog.Logger
+	// DB is nil when the records are kept in memory.
+	DB *sql.DB
+	// Migrations, when set, are checked to be applied by /readyz.
+	Migrations fs.FS
+	Schema     graphql.Schema
+	// Costs are the complexity costs of the fields of Schema.
+	Costs    complexity.Costs
+	Resolver *resolvers.Resolver
+	Patients store.PatientRepository
+	// Consents are read by the FHIR endpoints, which only share the
+	// patients who consented to it.
+	Consents store.ConsentRepository
+	Events   *pubsub.Broker
+	AuditLog resolvers.AuditLog
+	// Queries holds the persisted queries; nil runs any query sent as text
+	// and no persisted ones.
+	Queries *persisted.Queries
+	// Metrics, when set, instruments the routes and serves /metrics.
+	Metrics *metrics.Metrics
+	// Documents and DocumentSigner serve the documents of patients, whose
+	// files are kept in DocumentStorage; a nil DocumentStorage answers 503.
+	Documents       store.DocumentRepository
+	DocumentStorage document.Storage
+	DocumentSigner  *document.Signer
+	// MaxDocumentBytes bounds the size of uploaded documents.
+	MaxDocumentBytes int64
+	// HL7DeadLetters records the HL7 messages that could not be ingested.
+	HL7DeadLetters store.HL7DeadLetterRepository
+}
+
+// New returns the HTTP server for cfg, serving from deps. Shutdown also
+// closes the subscription WebSockets, which it does not track itself, and
+// stops the background work of the middlewares.
+func New(cfg Config, deps Deps) *http.Server {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	srv := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      newRouter(ctx, cfg, deps),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+	srv.RegisterOnShutdown(cancel)
+
+	return srv
+}
+
+// Shutdown stops srv accepting connections and waits for the open requests
+// to finish until ctx is done. Requests still running then are cut off, by
+// closing their connections, so the database is not closed underneath them
+// indefinitely; the error tells that it happened.
+func Shutdown(ctx context.Context, srv *http.Server) error {
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return err
+	}
+
+	return nil
+}
+
+// newRouter returns the routes of cfg, whose subscriptions and middlewares
+// stop when ctx is done.
+func newRouter(ctx context.Context, cfg Config, deps Deps) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(logger.RequestLogger(deps.Logger))
+	r.Use(middleware.SecurityHeaders(cfg.HSTSMaxAge))
+	r.Use(middleware.Compress(cfg.CompressionMinBytes))
+	r.Use(middleware.ClientIPMiddleware(cfg.TrustProxy))
+	r.Use(tracing.Middleware())
+	if deps.Metrics != nil {
+		r.Use(deps.Metrics.Middleware())
+		r.Handle("/metrics", deps.Metrics.Handler()).Methods("GET")
+	}
+	if cfg.RateLimitRPS > 0 {
+		r.Use(middleware.RateLimiter(ctx, cfg.RateLimitRPS, cfg.RateLimitBurst, cfg.TrustProxy))
+	}
+	r.Use(middleware.CORSMiddleware(cfg.CORSAllowedOrigins))
+	r.Use(middleware.JWTMiddleware(cfg.JWTSecret))
+	if cfg.TokenRateLimitRPS > 0 {
+		r.Use(middleware.TokenRateLimiter(ctx, cfg.TokenRateLimitRPS, cfg.TokenRateLimitBurst))
+	}
+
+	r.HandleFunc("/healthz", handler.Healthz).Methods("GET")
+	r.HandleFunc("/readyz", handler.Readyz(deps.DB, deps.Migrations)).Methods("GET")
+	r.HandleFunc("/playground", handler.Playground(cfg.GraphQLEndpoint, cfg.Production, handler.PlaygroundFS)).Methods("GET")
+	r.PathPrefix("/playground/assets/").Handler(handler.PlaygroundAssetsHandler("/playground/assets/", cfg.Production, handler.PlaygroundFS)).Methods("GET")
+	graphiqlAssets := handler.GraphiQLFS
+	if cfg.GraphiQLAssetsDir != "" {
+		graphiqlAssets = os.DirFS(cfg.GraphiQLAssetsDir)
+	}
+	r.HandleFunc("/graphiql", handler.GraphiQL(cfg.GraphQLEndpoint, cfg.GraphiQL, graphiqlAssets)).Methods("GET")
+	r.PathPrefix("/graphiql/assets/").Handler(handler.GraphiQLAssetsHandler("/graphiql/assets/", cfg.GraphiQL, graphiqlAssets)).Methods("GET")
+	r.HandleFunc("/patients/export", exportPatients(deps.Patients, deps.AuditLog)).Methods("GET")
+	r.HandleFunc("/patients/import", importPatients(deps.Patients, deps.Events)).Methods("POST")
+	r.HandleFunc("/patients/{id:[0-9]+}/documents", uploadDocument(deps.Documents, deps.DocumentStorage, deps.DocumentSigner, deps.MaxDocumentBytes)).Methods("POST")
+	r.HandleFunc("/documents/{id:[0-9]+}/content", downloadDocument(deps.Documents, deps.DocumentStorage, deps.DocumentSigner, deps.AuditLog)).Methods("GET")
+	r.HandleFunc("/hl7/adt", ingestADT(deps.Patients, deps.HL7DeadLetters, deps.Events)).Methods("POST")
+	fhirRouter := r.PathPrefix("/fhir").Subrouter()
+	fhirRouter.Use(middleware.Timeout(cfg.RequestTimeout), middleware.MaxBodySize(cfg.MaxRequestBytes))
+	fhir.Register(fhirRouter, deps.Patients, deps.Consents, deps.Events, deps.AuditLog)
+	r.HandleFunc("/admin/simulate-load", simulateLoadHandler(deps.Schema, deps.Resolver, cfg.SimulateLoad, cfg.Production)).Methods("GET")
+	r.Handle("/patient", middleware.Timeout(cfg.RequestTimeout)(middleware.MaxBodySize(cfg.MaxRequestBytes)(
+		graphqlHandler(deps.Schema, deps.Costs, deps.Resolver, deps.Queries, cfg.QueryLimits, deps.Metrics))))
+	r.HandleFunc("/graphql/ws", subscriptionHandler(deps.Schema, deps.Costs, deps.Resolver, deps.Queries, cfg, ctx.Done())).Methods("GET")
+	// The routes above only match their methods, and mux runs no middleware
+	// for a request no route matches, so this one lets CORSMiddleware answer
+	// the preflights of every route.
+	r.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return r
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/pubsub"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/schema"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/store/memory"
+)
+
+var testSecret = []byte("test-secret-of-at-least-32-bytes!")
+
+// newTestServer serves the routes of cfg from memory repositories.
+func newTestServer(t *testing.T, cfg Config) *httptest.Server {
+	t.Helper()
+
+	return newTestServerOn(t, cfg, memory.New())
+}
+
+// newTestServerOn serves the routes of cfg from the memory repositories of
+// db.
+func newTestServerOn(t *testing.T, cfg Config, db *memory.DB) *httptest.Server {
+	t.Helper()
+
+	return newTestServerWith(t, cfg, db, memory.NewPatientStore(db), pubsub.NewBroker())
+}
+
+// newTestServerWith serves the routes of cfg from the memory repositories of
+// db but patients, publishing their events to events.
+func newTestServerWith(t *testing.T, cfg Config, db *memory.DB, patients store.PatientRepository, events *pubsub.Broker) *httptest.Server {
+	t.Helper()
+
+	consents := memory.NewConsentStore(db)
+	auditLog := memory.NewAuditLogger(db)
+	resolver := resolvers.New(
+		patients,
+		memory.NewAppointmentStore(db),
+		memory.NewProviderStore(db),
+		memory.NewEncounterStore(db),
+		memory.NewEmergencyContactStore(db),
+		memory.NewNoteStore(db),
+		memory.NewInsurancePolicyStore(db),
+		consents,
+		memory.NewDuplicateReportStore(db),
+		memory.NewClinicStore(db),
+		memory.NewReminderStore(db),
+		memory.NewDocumentStore(db),
+		nil,
+		memory.NewIdempotencyKeyStore(db),
+		time.Hour,
+		memory.NewTxStore(db),
+		auditLog,
+		events,
+	)
+
+	graphqlSchema, costs, err := schema.New(resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.JWTSecret = testSecret
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	srv := httptest.NewServer(newRouter(ctx, cfg, Deps{
+		Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Schema:         graphqlSchema,
+		Costs:          costs,
+		Resolver:       resolver,
+		Patients:       patients,
+		Consents:       consents,
+		AuditLog:       auditLog,
+		Documents:      memory.NewDocumentStore(db),
+		HL7DeadLetters: memory.NewHL7DeadLetterStore(db),
+		Events:         events,
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// get requests path from srv, with a token of user with roles in clinic 1
+// unless user is "".
+func get(t *testing.T, srv *httptest.Server, path, user string, roles ...string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != "" {
+		req.Header.Set("Authorization", bearer(t, user, roles...))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// bearer returns the Authorization header of user with roles in clinic 1.
+func bearer(t *testing.T, user string, roles ...string) string {
+	t.Helper()
+
+	clinic := 1
+	token, err := middleware.IssueToken(testSecret, user, roles, &clinic, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return "Bearer " + token
+}
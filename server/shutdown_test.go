@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		work     time.Duration
+		timeout  time.Duration
+		err      error
+		answered bool
+	}{
+		{"drains open requests", 200 * time.Millisecond, 5 * time.Second, nil, true},
+		{"cuts off requests past the timeout", 5 * time.Second, 100 * time.Millisecond, context.DeadlineExceeded, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			released := make(chan struct{})
+			defer close(released)
+
+			srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-time.After(tt.work):
+				case <-released:
+				}
+				io.WriteString(w, "done")
+			})}
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.Serve(listener)
+			url := "http://" + listener.Addr().String()
+
+			type answer struct {
+				body string
+				err  error
+			}
+			answered := make(chan answer, 1)
+			go func() {
+				resp, err := http.Get(url)
+				if err != nil {
+					answered <- answer{err: err}
+					return
+				}
+				defer resp.Body.Close()
+
+				body, err := io.ReadAll(resp.Body)
+				answered <- answer{body: string(body), err: err}
+			}()
+
+			<-started
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			if err := Shutdown(ctx, srv); !errors.Is(err, tt.err) {
+				t.Errorf("Shutdown() = %v, want %v", err, tt.err)
+			}
+
+			got := <-answered
+			if tt.answered && (got.err != nil || got.body != "done") {
+				t.Errorf("request got %q, %v, want it to complete", got.body, got.err)
+			}
+			if !tt.answered && got.err == nil {
+				t.Errorf("request got %q, want its connection closed", got.body)
+			}
+
+			if resp, err := http.Get(url); err == nil {
+				resp.Body.Close()
+				t.Error("the server still accepts requests after Shutdown")
+			}
+		})
+	}
+}
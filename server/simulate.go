@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+)
+
+const simulateLoadQuery = "{getPatients{patients{id, name, email, phone}}}"
+
+// The largest concurrency and count a simulation accepts, so one request
+// cannot overload the server.
+const (
+	maxSimulateConcurrency = 50
+	maxSimulateCount       = 1000
+)
+
+type loadReport struct {
+	P50Ms  float64 `json:"p50Ms"`
+	P95Ms  float64 `json:"p95Ms"`
+	P99Ms  float64 `json:"p99Ms"`
+	Errors int     `json:"errors"`
+}
+
+// simulateLoadHandler runs count getPatients queries against the schema using
+// concurrency goroutines, as the calling admin, and reports latency
+// percentiles. It is only served when enabled outside production, and its
+// reads are not recorded in the audit log.
+func simulateLoadHandler(schema graphql.Schema, resolver *resolvers.Resolver, enabled, production bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !enabled || production {
+			http.NotFound(w, r)
+			return
+		}
+
+		if _, ok := middleware.UserIDFromContext(r.Context()); !ok {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		if !middleware.HasRole(r.Context(), middleware.RoleAdmin) {
+			http.Error(w, "simulating load needs the admin role", http.StatusForbidden)
+			return
+		}
+
+		concurrency, err := positiveIntParam(r, "concurrency", 10, maxSimulateConcurrency)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("concurrency must be an integer from 1 to %d", maxSimulateConcurrency), http.StatusBadRequest)
+			return
+		}
+
+		count, err := positiveIntParam(r, "count", 100, maxSimulateCount)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("count must be an integer from 1 to %d", maxSimulateCount), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(simulateLoad(r.Context(), schema, resolver, concurrency, count))
+	}
+}
+
+func simulateLoad(ctx context.Context, schema graphql.Schema, resolver *resolvers.Resolver, concurrency, count int) loadReport {
+	ctx = resolvers.WithLoadTest(ctx)
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		report    loadReport
+		latencies = make([]time.Duration, 0, count)
+		jobs      = make(chan struct{})
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				start := time.Now()
+				result := graphql.Do(graphql.Params{
+					Context:       resolver.WithLoaders(ctx),
+					Schema:        schema,
+					RequestString: simulateLoadQuery,
+				})
+				elapsed := time.Since(start)
+
+				mu.Lock()
+				latencies = append(latencies, elapsed)
+				if result.HasErrors() {
+					report.Errors++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50Ms = percentileMs(latencies, 0.50)
+	report.P95Ms = percentileMs(latencies, 0.95)
+	report.P99Ms = percentileMs(latencies, 0.99)
+
+	return report
+}
+
+// percentileMs returns the nearest-rank percentile of sorted in milliseconds.
+func percentileMs(sorted []time.Duration, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return float64(sorted[rank]) / float64(time.Millisecond)
+}
+
+// positiveIntParam returns the query parameter name, fallback when it is
+// not given, failing unless it is an integer from 1 to limit.
+func positiveIntParam(r *http.Request, name string, fallback, limit int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err == nil && (n <= 0 || n > limit) {
+		err = strconv.ErrRange
+	}
+
+	return n, err
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/store/memory"
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+func TestSimulateLoadHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		disabled   bool
+		production bool
+		query      string
+		user       string
+		roles      []string
+		status     int
+	}{
+		{"admin", false, false, "?concurrency=2&count=5", "admin-1", []string{middleware.RoleAdmin}, http.StatusOK},
+		{"defaults", false, false, "", "admin-1", []string{middleware.RoleAdmin}, http.StatusOK},
+		{"anonymous", false, false, "", "", nil, http.StatusUnauthorized},
+		{"clinician", false, false, "", "clinician-1", []string{middleware.RoleClinician}, http.StatusForbidden},
+		{"not enabled", true, false, "", "admin-1", []string{middleware.RoleAdmin}, http.StatusNotFound},
+		{"production", false, true, "", "admin-1", []string{middleware.RoleAdmin}, http.StatusNotFound},
+		{"concurrency zero", false, false, "?concurrency=0", "admin-1", []string{middleware.RoleAdmin}, http.StatusBadRequest},
+		{"concurrency over the maximum", false, false, "?concurrency=51", "admin-1", []string{middleware.RoleAdmin}, http.StatusBadRequest},
+		{"count at the maximum", false, false, "?concurrency=50&count=1000", "admin-1", []string{middleware.RoleAdmin}, http.StatusOK},
+		{"count over the maximum", false, false, "?count=1001", "admin-1", []string{middleware.RoleAdmin}, http.StatusBadRequest},
+		{"count not a number", false, false, "?count=many", "admin-1", []string{middleware.RoleAdmin}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, Config{SimulateLoad: !tt.disabled, Production: tt.production})
+
+			resp := get(t, srv, "/admin/simulate-load"+tt.query, tt.user, tt.roles...)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			var report loadReport
+			if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Errors != 0 {
+				t.Fatalf("report.Errors = %d, want 0", report.Errors)
+			}
+		})
+	}
+}
+
+func TestSimulateLoadIsNotAudited(t *testing.T) {
+	db := memory.New()
+	ctx := tenant.WithClinic(context.Background(), 1)
+	patient, err := memory.NewPatientStore(db).Create(ctx, "admin-1", "Ann Lee", "ann@example.com", "+14155550100", store.Demographics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServerOn(t, Config{SimulateLoad: true}, db)
+
+	resp := get(t, srv, "/admin/simulate-load?concurrency=2&count=5", "admin-1", middleware.RoleAdmin)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	entries, err := memory.NewAuditLogger(db).Entries(ctx, patient.ID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Operation != "create" {
+			t.Errorf("audit entry %s by %s, want only the create", e.Operation, e.PerformedBy)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+
+	"github.com/codixir/smart-emerge-starter/complexity"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/persisted"
+	"github.com/codixir/smart-emerge-starter/resolvers"
+	"github.com/codixir/smart-emerge-starter/tracing"
+)
+
+// subscriptionProtocol is the WebSocket subprotocol of the graphql-ws
+// library, https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md.
+const subscriptionProtocol = "graphql-transport-ws"
+
+const (
+	connectionInitTimeout = 10 * time.Second
+	wsWriteTimeout        = 10 * time.Second
+)
+
+// Close codes defined by the graphql-transport-ws protocol.
+const (
+	closeInvalidMessage     = 4400
+	closeUnauthorized       = 4401
+	closeForbidden          = 4403
+	closeBadSubprotocol     = 4406
+	closeInitTimeout        = 4408
+	closeSubscriberExists   = 4409
+	closeTooManyInitRequest = 4429
+)
+
+// wsMessage is a graphql-transport-ws message.
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// subscriptionHandler serves GraphQL subscriptions over WebSocket using the
+// graphql-transport-ws protocol. Callers authenticate with a bearer token in
+// the Authorization header of the handshake or in an Authorization key of
+// the connection_init payload, since browsers cannot set headers on
+// WebSockets. Subscriptions may be sent by their persisted hash, and only
+// the ones queries allows are run. Sessions are closed with 1001 once
+// shutdown is done.
+func subscriptionHandler(s graphql.Schema, costs complexity.Costs, resolver *resolvers.Resolver, queries *persisted.Queries, cfg Config, shutdown <-chan struct{}) http.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{subscriptionProtocol},
+		CheckOrigin:  originAllowed(cfg.CORSAllowedOrigins),
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already replied with an HTTP error.
+			return
+		}
+		defer conn.Close()
+
+		if cfg.MaxRequestBytes > 0 {
+			// Larger messages close the connection with 1009.
+			conn.SetReadLimit(cfg.MaxRequestBytes)
+		}
+
+		session := &wsSession{
+			conn:          conn,
+			ctx:           r.Context(),
+			schema:        s,
+			costs:         costs,
+			resolver:      resolver,
+			queries:       queries,
+			limits:        cfg.QueryLimits,
+			timeout:       cfg.RequestTimeout,
+			secret:        cfg.JWTSecret,
+			subscriptions: map[string]func(){},
+		}
+
+		if conn.Subprotocol() != subscriptionProtocol {
+			session.close(closeBadSubprotocol, "Subprotocol not acceptable")
+			return
+		}
+
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-shutdown:
+				session.close(websocket.CloseGoingAway, "server shutting down")
+			case <-done:
+			}
+		}()
+
+		session.serve()
+	}
+}
+
+// originAllowed accepts handshakes without an Origin header, from the
+// server's own host and from the CORS allowed origins.
+func originAllowed(allowedOrigins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" || allowed == origin {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// wsSession is one subscription WebSocket connection.
+type wsSession struct {
+	conn     *websocket.Conn
+	ctx      context.Context
+	schema   graphql.Schema
+	costs    complexity.Costs
+	resolver *resolvers.Resolver
+	queries  *persisted.Queries
+	limits   complexity.Limits
+	timeout  time.Duration
+	secret   []byte
+
+	writeMu sync.Mutex
+
+	mu            sync.Mutex
+	subscriptions map[string]func()
+	wg            sync.WaitGroup
+}
+
+// serve reads messages until the connection closes, then stops the
+// session's subscriptions.
+func (s *wsSession) serve() {
+	defer func() {
+		s.mu.Lock()
+		for _, unsubscribe := range s.subscriptions {
+			unsubscribe()
+		}
+		s.mu.Unlock()
+
+		s.wg.Wait()
+	}()
+
+	acknowledged := false
+	s.conn.SetReadDeadline(time.Now().Add(connectionInitTimeout))
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			var netErr interface{ Timeout() bool }
+			if !acknowledged && errors.As(err, &netErr) && netErr.Timeout() {
+				s.close(closeInitTimeout, "Connection initialisation timeout")
+			}
+			return
+		}
+
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.close(closeInvalidMessage, "Invalid message received")
+			return
+		}
+
+		switch msg.Type {
+		case "connection_init":
+			if acknowledged {
+				s.close(closeTooManyInitRequest, "Too many initialisation requests")
+				return
+			}
+
+			if err := s.authenticate(msg.Payload); err != nil {
+				s.close(closeForbidden, "Forbidden")
+				return
+			}
+
+			acknowledged = true
+			s.conn.SetReadDeadline(time.Time{})
+			s.send(wsMessage{Type: "connection_ack"})
+
+		case "ping":
+			s.send(wsMessage{Type: "pong"})
+
+		case "pong":
+
+		case "subscribe":
+			if !acknowledged {
+				s.close(closeUnauthorized, "Unauthorized")
+				return
+			}
+
+			if msg.ID == "" {
+				s.close(closeInvalidMessage, "Invalid message received")
+				return
+			}
+
+			if !s.subscribe(msg.ID, msg.Payload) {
+				s.close(closeSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", msg.ID))
+				return
+			}
+
+		case "complete":
+			s.mu.Lock()
+			unsubscribe := s.subscriptions[msg.ID]
+			delete(s.subscriptions, msg.ID)
+			s.mu.Unlock()
+
+			if unsubscribe != nil {
+				unsubscribe()
+			}
+
+		default:
+			s.close(closeInvalidMessage, "Invalid message received")
+			return
+		}
+	}
+}
+
+// authenticate replaces the caller from the handshake with the bearer token
+// in the connection_init payload, when there is one, acting for the clinic
+// of its X-Clinic-ID parameter like the header.
+func (s *wsSession) authenticate(payload json.RawMessage) error {
+	var params map[string]interface{}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &params); err != nil {
+			return err
+		}
+	}
+
+	authorization, _ := params["Authorization"].(string)
+	if authorization == "" {
+		authorization, _ = params["authorization"].(string)
+	}
+	if authorization == "" {
+		return nil
+	}
+
+	clinic, _ := params[middleware.ClinicHeader].(string)
+	ctx, err := middleware.Authenticate(s.ctx, authorization, clinic, s.secret)
+	if err != nil {
+		return err
+	}
+
+	s.ctx = ctx
+	return nil
+}
+
+// subscribe starts the subscription id, sending an error message when the
+// request is invalid or not allowed. It returns false when id is already in
+// use on this connection.
+func (s *wsSession) subscribe(id string, payload json.RawMessage) bool {
+	s.mu.Lock()
+	_, exists := s.subscriptions[id]
+	s.mu.Unlock()
+	if exists {
+		return false
+	}
+
+	var req graphqlRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		s.sendErrors(id, fmt.Errorf("invalid subscribe payload: %v", err))
+		return true
+	}
+
+	sentText := req.Query != ""
+	var err error
+	if req.Query, err = s.queries.Resolve(s.ctx, req.Query, req.Extensions.PersistedQuery); err != nil {
+		s.sendErrors(id, err)
+		return true
+	}
+
+	field, err := s.subscriptionField(req)
+	if err != nil {
+		s.sendErrors(id, err)
+		return true
+	}
+
+	events, unsubscribe, err := s.resolver.Subscribe(s.ctx, field)
+	if err != nil {
+		s.sendErrors(id, err)
+		return true
+	}
+
+	// Subscribe has checked the caller, so the query can be registered.
+	if sentText {
+		if err := s.queries.Register(s.ctx, req.Query, req.OperationName, req.Extensions.PersistedQuery); err != nil {
+			slog.WarnContext(s.ctx, "could not register a persisted query", "error", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.subscriptions[id] = unsubscribe
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for event := range events {
+			s.sendPayload("next", id, s.execute(req, field, event))
+		}
+	}()
+
+	return true
+}
+
+// execute runs the subscription req on field for one event, within the
+// request timeout and in a span of its own.
+func (s *wsSession) execute(req graphqlRequest, field string, event interface{}) *graphql.Result {
+	ctx := s.ctx
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	ctx, span := tracing.StartOperation(ctx, ast.OperationTypeSubscription, []string{field}, req.OperationName)
+	start := time.Now()
+	result := graphql.Do(graphql.Params{
+		Context:        s.resolver.WithLoaders(ctx),
+		Schema:         s.schema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		RootObject:     map[string]interface{}{resolvers.SubscriptionRoot: event},
+	})
+	tracing.EndOperation(span, result)
+
+	logOperation(ctx, ast.OperationTypeSubscription, []string{field}, req.OperationName, time.Since(start), result)
+	result.Errors = withRequestID(ctx, result.Errors)
+
+	return result
+}
+
+// subscriptionField checks req against the schema and query limits and
+// returns the Subscription field it selects. Only subscription operations
+// with a single root field are accepted.
+func (s *wsSession) subscriptionField(req graphqlRequest) (string, error) {
+	if strings.TrimSpace(req.Query) == "" {
+		return "", fmt.Errorf("query is required")
+	}
+
+	if err := complexity.Check(req.Query, req.OperationName, s.costs, s.limits); err != nil {
+		return "", err
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		return "", err
+	}
+
+	if validation := graphql.ValidateDocument(&s.schema, doc, nil); !validation.IsValid {
+		return "", validation.Errors[0]
+	}
+
+	var operation *ast.OperationDefinition
+	for _, definition := range doc.Definitions {
+		op, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		if req.OperationName == "" || (op.Name != nil && op.Name.Value == req.OperationName) {
+			if operation != nil {
+				return "", fmt.Errorf("operationName is required when the document has several operations")
+			}
+			operation = op
+		}
+	}
+
+	if operation == nil {
+		return "", fmt.Errorf("unknown operation %q", req.OperationName)
+	}
+
+	if operation.Operation != ast.OperationTypeSubscription {
+		return "", fmt.Errorf("only subscription operations can be sent over this connection, got %s", operation.Operation)
+	}
+
+	selections := operation.SelectionSet.Selections
+	if len(selections) != 1 {
+		return "", fmt.Errorf("a subscription must select exactly one field")
+	}
+
+	field, ok := selections[0].(*ast.Field)
+	if !ok {
+		return "", fmt.Errorf("a subscription must select its field directly")
+	}
+
+	return field.Name.Value, nil
+}
+
+func (s *wsSession) sendErrors(id string, err error) {
+	formatted := gqlerrors.FormatError(err)
+
+	var extended gqlerrors.ExtendedError
+	if errors.As(err, &extended) {
+		formatted.Extensions = extended.Extensions()
+	}
+
+	s.sendPayload("error", id, withRequestID(s.ctx, []gqlerrors.FormattedError{formatted}))
+}
+
+func (s *wsSession) sendPayload(messageType, id string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		slog.ErrorContext(s.ctx, "encoding subscription message", "error", err)
+		return
+	}
+
+	s.send(wsMessage{ID: id, Type: messageType, Payload: data})
+}
+
+func (s *wsSession) send(msg wsMessage) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := s.conn.WriteJSON(msg); err != nil {
+		slog.DebugContext(s.ctx, "writing subscription message", "error", err)
+	}
+}
+
+// close sends a close frame with code and reason and closes the connection,
+// which ends serve.
+func (s *wsSession) close(code int, reason string) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	deadline := time.Now().Add(time.Second)
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	s.conn.Close()
+}
@@ -0,0 +1,314 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/encryption"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+type Appointment struct {
+	ID          int       `json:"id"`
+	PatientID   int       `json:"patientId"`
+	ProviderID  *int      `json:"providerId"`
+	ScheduledAt time.Time `json:"scheduledAt"`
+	EndsAt      time.Time `json:"endsAt"`
+	Reason      string    `json:"reason"`
+	Notes       string    `json:"notes"`
+	Status      string    `json:"status"`
+	Patient     *Patient  `json:"patient"`
+}
+
+// AppointmentRepository reads and writes the appointments of the clinic the
+// context acts for. Methods return ErrNotFound when the appointment, or for
+// Book the patient, does not exist there.
+type AppointmentRepository interface {
+	// Get returns an appointment with its patient.
+	Get(ctx context.Context, id int) (*Appointment, error)
+	// ListByPatient returns the appointments of a patient with the patient,
+	// soonest first.
+	ListByPatient(ctx context.Context, patientID int) ([]*Appointment, error)
+	// ListByPatients returns the appointments of many patients grouped by
+	// patient id, soonest first, without their patients.
+	ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*Appointment, error)
+	// ListBetween returns the appointments overlapping [from, to) with their
+	// patients, soonest first, optionally only those of one provider.
+	ListBetween(ctx context.Context, from, to time.Time, providerID *int) ([]*Appointment, error)
+	// ListScheduled returns the scheduled appointments of a provider
+	// starting in [from, to) with their patients, soonest first, leaving out
+	// those of soft-deleted patients.
+	ListScheduled(ctx context.Context, providerID int, from, to time.Time) ([]*Appointment, error)
+	// Book inserts a scheduled appointment for a patient who is not deleted
+	// and returns its id. Overlapping bookings of the provider fail with an
+	// APPOINTMENT_CONFLICT *utils.CodedError.
+	Book(ctx context.Context, a *Appointment) (int, error)
+	// Reschedule moves a scheduled appointment to start. When end is nil the
+	// appointment keeps its current duration. Moves that are not allowed
+	// fail with a *utils.CodedError.
+	Reschedule(ctx context.Context, id int, start time.Time, end *time.Time) error
+	// SetStatus changes the status of an appointment and returns it.
+	SetStatus(ctx context.Context, id int, status string) (*Appointment, error)
+}
+
+// DefaultAppointmentDuration is used when an appointment is booked without an
+// end time.
+const DefaultAppointmentDuration = 30 * time.Minute
+
+const appointmentColumns = "id, patient_id, provider_id, scheduled_at, ends_at, reason, notes, status"
+
+// appointmentSelect joins each appointment with its patient so the patient
+// field can be served without another query.
+var appointmentSelect = `select a.id, a.patient_id, a.provider_id, a.scheduled_at, a.ends_at, a.reason, a.notes, a.status,
+	` + qualifiedPatientColumns("p") + `
+	from appointments a join patients p on p.id = a.patient_id`
+
+// fields returns the scan destinations of appointmentColumns, in order.
+func (a *Appointment) fields() []interface{} {
+	return []interface{}{&a.ID, &a.PatientID, &a.ProviderID, &a.ScheduledAt, &a.EndsAt, &a.Reason, &a.Notes, &a.Status}
+}
+
+// scanAppointment reads a row selected with appointmentSelect, decrypting
+// its patient with c.
+func scanAppointment(row rowScanner, c *encryption.Cipher) (*Appointment, error) {
+	appointment := &Appointment{Patient: &Patient{}}
+	patient := appointment.Patient
+
+	err := row.Scan(append(appointment.fields(), patient.fields()...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unsealPatient(c, patient); err != nil {
+		return nil, err
+	}
+
+	return appointment, nil
+}
+
+// AppointmentStore is the Postgres AppointmentRepository.
+type AppointmentStore struct {
+	db     *sql.DB
+	cipher *encryption.Cipher
+}
+
+// NewAppointmentStore returns an AppointmentStore decrypting the patients of
+// appointments with cipher.
+func NewAppointmentStore(db *sql.DB, cipher *encryption.Cipher) *AppointmentStore {
+	return &AppointmentStore{db: db, cipher: cipher}
+}
+
+func (s *AppointmentStore) Get(ctx context.Context, id int) (*Appointment, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	appointment, err := scanAppointment(conn(ctx, s.db).QueryRowContext(ctx,
+		appointmentSelect+" where a.id = $1 and "+inClinic("a.clinic_id", 2), id, clinic), s.cipher)
+	return appointment, notFound(err)
+}
+
+func (s *AppointmentStore) ListByPatient(ctx context.Context, patientID int) ([]*Appointment, error) {
+	return s.list(ctx, appointmentSelect+" where a.patient_id = $1 and "+inClinic("a.clinic_id", 2)+" order by a.scheduled_at", patientID)
+}
+
+func (s *AppointmentStore) ListBetween(ctx context.Context, from, to time.Time, providerID *int) ([]*Appointment, error) {
+	return s.list(ctx,
+		appointmentSelect+" where a.scheduled_at < $2 and a.ends_at > $1 and ($3::integer is null or a.provider_id = $3) and "+
+			inClinic("a.clinic_id", 4)+" order by a.scheduled_at",
+		from, to, providerID)
+}
+
+func (s *AppointmentStore) ListScheduled(ctx context.Context, providerID int, from, to time.Time) ([]*Appointment, error) {
+	return s.list(ctx,
+		appointmentSelect+" where a.provider_id = $1 and a.scheduled_at >= $2 and a.scheduled_at < $3 and a.status = 'scheduled' and p.deleted_at is null and "+
+			inClinic("a.clinic_id", 4)+" order by a.scheduled_at, a.id",
+		providerID, from, to)
+}
+
+// list runs a query selecting appointmentSelect, with the clinic scope as
+// the placeholder after args.
+func (s *AppointmentStore) list(ctx context.Context, stmt string, args ...interface{}) ([]*Appointment, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx, stmt, append(args, clinic)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appointments := []*Appointment{}
+	for rows.Next() {
+		appointment, err := scanAppointment(rows, s.cipher)
+		if err != nil {
+			return nil, err
+		}
+
+		appointments = append(appointments, appointment)
+	}
+
+	return appointments, rows.Err()
+}
+
+func (s *AppointmentStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*Appointment, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx,
+		"select "+appointmentColumns+" from appointments where patient_id = any($1) and "+inClinic("clinic_id", 2)+" order by scheduled_at",
+		pq.Array(patientIDs), clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byPatient := make(map[int][]*Appointment, len(patientIDs))
+	for rows.Next() {
+		appointment := &Appointment{}
+
+		if err := rows.Scan(appointment.fields()...); err != nil {
+			return nil, err
+		}
+
+		byPatient[appointment.PatientID] = append(byPatient[appointment.PatientID], appointment)
+	}
+
+	return byPatient, rows.Err()
+}
+
+func (s *AppointmentStore) Book(ctx context.Context, a *Appointment) (int, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		if a.ProviderID != nil {
+			if err := checkProviderAvailable(ctx, tx, clinic, *a.ProviderID, a.ScheduledAt, a.EndsAt, 0); err != nil {
+				return err
+			}
+		}
+
+		stmt := `insert into appointments(patient_id, provider_id, scheduled_at, ends_at, reason, notes, clinic_id)
+			select id, $2::integer, $3::timestamptz, $4::timestamptz, $5::text, $6::text, clinic_id from patients
+			where id = $1 and deleted_at is null and ` + inClinic("clinic_id", 7) + ` returning id`
+		return tx.QueryRowContext(ctx, stmt, a.PatientID, a.ProviderID, a.ScheduledAt, a.EndsAt, a.Reason, a.Notes, clinic).Scan(&id)
+	})
+
+	return id, notFound(err)
+}
+
+func (s *AppointmentStore) Reschedule(ctx context.Context, id int, start time.Time, end *time.Time) error {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		current := &Appointment{}
+		err := tx.QueryRowContext(ctx, "select "+appointmentColumns+" from appointments where id = $1 and "+inClinic("clinic_id", 2)+" for update",
+			id, clinic).Scan(current.fields()...)
+		if err != nil {
+			return err
+		}
+
+		if current.Status != "scheduled" {
+			return &utils.CodedError{
+				Code:    "BAD_USER_INPUT",
+				Message: fmt.Sprintf("appointment %d is %s and cannot be rescheduled", id, current.Status),
+			}
+		}
+
+		newEnd := start.Add(current.EndsAt.Sub(current.ScheduledAt))
+		if end != nil {
+			newEnd = *end
+		}
+		if !newEnd.After(start) {
+			return &utils.CodedError{Code: "BAD_USER_INPUT", Message: "endsAt must be after scheduledAt"}
+		}
+
+		if current.ProviderID != nil {
+			if err := checkProviderAvailable(ctx, tx, clinic, *current.ProviderID, start, newEnd, id); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "update appointments set scheduled_at = $1, ends_at = $2 where id = $3", start, newEnd, id)
+		return err
+	})
+
+	return notFound(err)
+}
+
+func (s *AppointmentStore) SetStatus(ctx context.Context, id int, status string) (*Appointment, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var updatedID int
+
+	err = conn(ctx, s.db).QueryRowContext(ctx, "update appointments set status = $1 where id = $2 and "+inClinic("clinic_id", 3)+" returning id",
+		status, id, clinic).Scan(&updatedID)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return s.Get(ctx, updatedID)
+}
+
+// appointmentConflictLock namespaces the advisory locks taken while checking
+// a provider's schedule, so they cannot collide with other advisory locks.
+const appointmentConflictLock = 5001
+
+// checkProviderAvailable returns an APPOINTMENT_CONFLICT error when the
+// provider has an appointment that is not cancelled overlapping [start, end),
+// and a NOT_FOUND error when the provider is not one of the clinic of the
+// clinicScope argument clinic. The appointment with id excludeID is ignored,
+// so pass 0 for new bookings. It takes a transaction scoped advisory lock on
+// the provider first, so two overlapping bookings cannot both pass the
+// check.
+func checkProviderAvailable(ctx context.Context, tx *sql.Tx, clinic interface{}, providerID int, start, end time.Time, excludeID int) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, "select exists (select 1 from providers where id = $1 and "+inClinic("clinic_id", 2)+")",
+		providerID, clinic).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return utils.NotFound("provider %d not found", providerID)
+	}
+
+	if _, err := tx.ExecContext(ctx, "select pg_advisory_xact_lock($1, $2)", appointmentConflictLock, providerID); err != nil {
+		return err
+	}
+
+	var conflictID int
+	err = tx.QueryRowContext(ctx, `select id from appointments
+		where provider_id = $1 and id <> $2 and status <> 'cancelled' and scheduled_at < $4 and ends_at > $3
+		order by scheduled_at limit 1`, providerID, excludeID, start, end).Scan(&conflictID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return &utils.CodedError{
+		Code:    "APPOINTMENT_CONFLICT",
+		Message: fmt.Sprintf("provider %d already has appointment %d at that time", providerID, conflictID),
+	}
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PatientPurged is the change type of a purge. Purges are in the change feed
+// but publish no event, the deletion of the patient having been published
+// already.
+const PatientPurged = "patient.purged"
+
+// ChangeCursor is the place of a change in the feed: the transaction that
+// made it and its id, which changes are ordered by. The zero ChangeCursor is
+// the start of the feed.
+type ChangeCursor struct {
+	Tx int64
+	ID int64
+}
+
+// String returns the opaque form of c clients page through the feed with.
+func (c ChangeCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.Tx, c.ID)))
+}
+
+// ParseChangeCursor reads a cursor returned by ChangeCursor.String, an empty
+// s being the start of the feed.
+func ParseChangeCursor(s string) (ChangeCursor, error) {
+	if s == "" {
+		return ChangeCursor{}, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ChangeCursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+
+	tx, id, _ := strings.Cut(string(decoded), ":")
+	var c ChangeCursor
+	if c.Tx, err = strconv.ParseInt(tx, 10, 64); err != nil {
+		return ChangeCursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return ChangeCursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+
+	return c, nil
+}
+
+// PatientChangeEvent is a change to a patient as read from the change feed.
+// Type is one of the outbox event types or PatientPurged. Patient is the
+// patient as it is now, rather than after the change, and nil once it was
+// purged.
+type PatientChangeEvent struct {
+	Cursor    ChangeCursor
+	Type      string
+	PatientID int
+	ClinicID  int
+	ChangedAt time.Time
+	Patient   *Patient
+}
+
+// recordChange adds the change of changeType to patientID to the feed inside
+// tx, so it is only read if the change commits. The change belongs to the
+// clinic ctx acts for.
+func recordChange(ctx context.Context, tx *sql.Tx, changeType string, patientID int) error {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"insert into patient_changes(change_type, patient_id, clinic_id) values($1, $2, $3)",
+		changeType, patientID, clinicID)
+
+	return err
+}
+
+// publish records the change of eventType to patientID in the feed and
+// writes its event to the outbox, both inside tx.
+func (s *PatientStore) publish(ctx context.Context, tx *sql.Tx, eventType string, patientID int, patient *Patient) error {
+	if err := recordChange(ctx, tx, eventType, patientID); err != nil {
+		return err
+	}
+
+	return s.events.Write(ctx, tx, eventType, patientID, patient)
+}
+
+// Changes reads up to limit changes after since from the primary, as
+// changes committed there may not have reached the replica yet. Changes
+// whose transaction is still running, or started after one that is, are
+// left for a later call: transaction ids are handed out as transactions
+// start but commit in any order, so reading past a running transaction
+// could skip its changes for good.
+func (s *PatientStore) Changes(ctx context.Context, since ChangeCursor, limit int) ([]*PatientChangeEvent, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `select tx_id, id, change_type, patient_id, clinic_id, changed_at from patient_changes
+		where `+inClinic("clinic_id", 1)+` and (tx_id, id) > ($2, $3) and tx_id < txid_snapshot_xmin(txid_current_snapshot())
+		order by tx_id, id limit $4`,
+		clinic, since.Tx, since.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*PatientChangeEvent{}
+	var ids []int64
+	for rows.Next() {
+		c := &PatientChangeEvent{}
+		if err := rows.Scan(&c.Cursor.Tx, &c.Cursor.ID, &c.Type, &c.PatientID, &c.ClinicID, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, c)
+		ids = append(ids, int64(c.PatientID))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	patientRows, err := s.db.QueryContext(ctx, "select "+patientSelectColumns+" from patients where id = any($1)", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer patientRows.Close()
+
+	patients := map[int]*Patient{}
+	for patientRows.Next() {
+		p, err := scanPatient(patientRows, s.cipher)
+		if err != nil {
+			return nil, err
+		}
+
+		patients[p.ID] = p
+	}
+	if err := patientRows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, c := range changes {
+		c.Patient = patients[c.PatientID]
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Clinic is a tenant of the deployment. Every other record belongs to one.
+type Clinic struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ClinicRepository reads and writes the clinics themselves, which unlike the
+// other repositories is not scoped to the clinic of the context.
+type ClinicRepository interface {
+	// List returns every clinic by id.
+	List(ctx context.Context) ([]*Clinic, error)
+	// Create inserts clinic and sets its ID and CreatedAt.
+	Create(ctx context.Context, clinic *Clinic) error
+}
+
+// ClinicStore is the Postgres ClinicRepository.
+type ClinicStore struct {
+	db *sql.DB
+}
+
+func NewClinicStore(db *sql.DB) *ClinicStore {
+	return &ClinicStore{db: db}
+}
+
+func (s *ClinicStore) List(ctx context.Context) ([]*Clinic, error) {
+	rows, err := conn(ctx, s.db).QueryContext(ctx, "select id, name, created_at from clinics order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clinics := []*Clinic{}
+	for rows.Next() {
+		clinic := &Clinic{}
+
+		if err := rows.Scan(&clinic.ID, &clinic.Name, &clinic.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		clinics = append(clinics, clinic)
+	}
+
+	return clinics, rows.Err()
+}
+
+func (s *ClinicStore) Create(ctx context.Context, clinic *Clinic) error {
+	return conn(ctx, s.db).QueryRowContext(ctx, "insert into clinics(name) values($1) returning id, created_at", clinic.Name).
+		Scan(&clinic.ID, &clinic.CreatedAt)
+}
@@ -0,0 +1,210 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/outbox"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// The consent types.
+const (
+	ConsentTreatment   = "treatment"
+	ConsentDataSharing = "data_sharing"
+	ConsentMarketing   = "marketing"
+)
+
+// ConsentTypes are the types of consents.
+var ConsentTypes = []string{ConsentTreatment, ConsentDataSharing, ConsentMarketing}
+
+// ConsentScopeAll is the scope of a consent covering every integration.
+const ConsentScopeAll = "all"
+
+// The scopes of the integrations sending patient data to third parties: the
+// events relayed from the outbox to webhooks and NATS, and the FHIR API.
+const (
+	ConsentScopeEvents = outbox.ConsentScope
+	ConsentScopeFHIR   = "fhir"
+)
+
+// Consent is the consent of a patient to treatment, to sharing their data or
+// to marketing, within Scope: ConsentScopeAll or the integration it was
+// given for. It is in effect from GrantedAt until it is revoked.
+// RecordedBy and RevokedBy are the staff members who recorded the grant and
+// the revocation.
+type Consent struct {
+	ID         int        `json:"id"`
+	PatientID  int        `json:"patientId"`
+	Type       string     `json:"type"`
+	Scope      string     `json:"scope"`
+	GrantedAt  time.Time  `json:"grantedAt"`
+	RecordedBy string     `json:"recordedBy"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	RevokedBy  *string    `json:"revokedBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// Covers reports whether c is in effect and of consentType, for scope or
+// for every scope.
+func (c *Consent) Covers(consentType, scope string) bool {
+	return c.RevokedAt == nil && c.Type == consentType && (c.Scope == ConsentScopeAll || c.Scope == scope)
+}
+
+// ConsentRepository reads and writes the consents of the patients of the
+// clinic the context acts for. Writes are recorded in the audit log of the
+// patient as performed by actor.
+type ConsentRepository interface {
+	// Grant records c for a patient who is not deleted, as recorded by
+	// actor, and sets its ID, RecordedBy and CreatedAt, returning
+	// ErrNotFound when there is no such patient. It fails with a
+	// CONSENT_ALREADY_GRANTED *utils.CodedError when a consent of the same
+	// type and scope is in effect.
+	Grant(ctx context.Context, actor string, c *Consent) error
+	// Revoke ends a consent in effect and returns it, or ErrNotFound. The
+	// consent is kept, as a record of what was consented to.
+	Revoke(ctx context.Context, actor string, id int) (*Consent, error)
+	// ListByPatients returns the consents of many patients grouped by
+	// patient id, those in effect first, then the most recently granted
+	// first.
+	ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*Consent, error)
+}
+
+const consentColumns = "id, patient_id, consent_type, scope, granted_at, recorded_by, revoked_at, revoked_by, created_at"
+
+// ConsentStore is the Postgres ConsentRepository.
+type ConsentStore struct {
+	db    *sql.DB
+	audit *audit.AuditLogger
+}
+
+func NewConsentStore(db *sql.DB, auditLogger *audit.AuditLogger) *ConsentStore {
+	return &ConsentStore{db: db, audit: auditLogger}
+}
+
+func scanConsent(row rowScanner) (*Consent, error) {
+	c := &Consent{}
+
+	err := row.Scan(&c.ID, &c.PatientID, &c.Type, &c.Scope, &c.GrantedAt, &c.RecordedBy, &c.RevokedAt, &c.RevokedBy, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *ConsentStore) Grant(ctx context.Context, actor string, c *Consent) error {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		// Locking the patient makes the grants of a patient run one at a
+		// time, so two cannot both pass the check.
+		var clinicID int
+		err := tx.QueryRowContext(ctx,
+			"select clinic_id from patients where id = $1 and deleted_at is null and "+inClinic("clinic_id", 2)+" for update",
+			c.PatientID, clinic).Scan(&clinicID)
+		if err != nil {
+			return err
+		}
+
+		var grantedID int
+		err = tx.QueryRowContext(ctx,
+			"select id from consents where patient_id = $1 and consent_type = $2 and scope = $3 and revoked_at is null",
+			c.PatientID, c.Type, c.Scope).Scan(&grantedID)
+		if err == nil {
+			return ConsentAlreadyGranted(c, grantedID)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		c.RecordedBy = actor
+		err = tx.QueryRowContext(ctx, `insert into consents(patient_id, clinic_id, consent_type, scope, granted_at, recorded_by)
+			values ($1, $2, $3, $4, $5, $6) returning id, created_at`,
+			c.PatientID, clinicID, c.Type, c.Scope, c.GrantedAt, actor).Scan(&c.ID, &c.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		return s.audit.Log(ctx, tx, "grant_consent", c.PatientID, actor, nil, c)
+	})
+
+	return notFound(err)
+}
+
+// ConsentAlreadyGranted is the error of a grant of c while the consent
+// grantedID of the same type and scope is in effect.
+func ConsentAlreadyGranted(c *Consent, grantedID int) error {
+	return &utils.CodedError{
+		Code:    "CONSENT_ALREADY_GRANTED",
+		Message: fmt.Sprintf("patient %d already has %s consent %d in effect for %s, revoke it first", c.PatientID, c.Type, grantedID, c.Scope),
+	}
+}
+
+func (s *ConsentStore) Revoke(ctx context.Context, actor string, id int) (*Consent, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked *Consent
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		before, err := scanConsent(tx.QueryRowContext(ctx,
+			"select "+consentColumns+" from consents where id = $1 and "+inClinic("clinic_id", 2)+" for update", id, clinic))
+		if err != nil {
+			return err
+		}
+		if before.RevokedAt != nil {
+			return &utils.CodedError{Code: "BAD_USER_INPUT", Message: fmt.Sprintf("consent %d is already revoked", id)}
+		}
+
+		revoked, err = scanConsent(tx.QueryRowContext(ctx,
+			"update consents set revoked_at = now(), revoked_by = $2 where id = $1 returning "+consentColumns, id, actor))
+		if err != nil {
+			return err
+		}
+
+		return s.audit.Log(ctx, tx, "revoke_consent", revoked.PatientID, actor, before, revoked)
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return revoked, nil
+}
+
+func (s *ConsentStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*Consent, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx, "select "+consentColumns+` from consents
+		where patient_id = any($1) and `+inClinic("clinic_id", 2)+`
+		order by revoked_at is not null, granted_at desc, id desc`,
+		pq.Array(patientIDs), clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byPatient := make(map[int][]*Consent, len(patientIDs))
+	for rows.Next() {
+		c, err := scanConsent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		byPatient[c.PatientID] = append(byPatient[c.PatientID], c)
+	}
+
+	return byPatient, rows.Err()
+}
@@ -0,0 +1,188 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/encryption"
+)
+
+// EmergencyContact is a person to call about a patient.
+type EmergencyContact struct {
+	ID           int       `json:"id"`
+	PatientID    int       `json:"patientId"`
+	Name         string    `json:"name"`
+	Relationship string    `json:"relationship"`
+	Phone        string    `json:"phone"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// EmergencyContactRepository reads and adds the emergency contacts of
+// patients. Additions are recorded in the audit log of the patient as
+// performed by actor.
+type EmergencyContactRepository interface {
+	// Add adds contact to a patient who is not deleted and sets its ID and
+	// CreatedAt, returning ErrNotFound when there is no such patient.
+	Add(ctx context.Context, actor string, contact *EmergencyContact) error
+	// ListByPatients returns the emergency contacts of many patients
+	// grouped by patient id, oldest first.
+	ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*EmergencyContact, error)
+}
+
+// contactPhoneField is the field the phones of emergency contacts are
+// encrypted as.
+const contactPhoneField = "emergency_contact_phone"
+
+const emergencyContactColumns = "id, patient_id, name, relationship, phone, created_at"
+
+// EmergencyContactStore is the Postgres EmergencyContactRepository. Phones
+// are encrypted with the cipher like those of patients.
+type EmergencyContactStore struct {
+	db     *sql.DB
+	audit  *audit.AuditLogger
+	cipher *encryption.Cipher
+}
+
+func NewEmergencyContactStore(db *sql.DB, auditLogger *audit.AuditLogger, cipher *encryption.Cipher) *EmergencyContactStore {
+	return &EmergencyContactStore{db: db, audit: auditLogger, cipher: cipher}
+}
+
+func (s *EmergencyContactStore) Add(ctx context.Context, actor string, contact *EmergencyContact) error {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return err
+	}
+
+	phone, err := s.cipher.Encrypt(contactPhoneField, contact.Phone)
+	if err != nil {
+		return err
+	}
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		stmt := `insert into emergency_contacts(patient_id, name, relationship, phone, clinic_id)
+			select id, $2::text, $3::text, $4::text, clinic_id from patients
+			where id = $1 and deleted_at is null and ` + inClinic("clinic_id", 5) + ` returning id, created_at`
+		err := tx.QueryRowContext(ctx, stmt, contact.PatientID, contact.Name, contact.Relationship, phone, clinic).
+			Scan(&contact.ID, &contact.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		return s.audit.Log(ctx, tx, "add_emergency_contact", contact.PatientID, actor, nil, contact)
+	})
+
+	return notFound(err)
+}
+
+func (s *EmergencyContactStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*EmergencyContact, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx,
+		"select "+emergencyContactColumns+" from emergency_contacts where patient_id = any($1) and "+inClinic("clinic_id", 2)+" order by created_at, id",
+		pq.Array(patientIDs), clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byPatient := make(map[int][]*EmergencyContact, len(patientIDs))
+	for rows.Next() {
+		contact := &EmergencyContact{}
+
+		err := rows.Scan(&contact.ID, &contact.PatientID, &contact.Name, &contact.Relationship, &contact.Phone, &contact.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if contact.Phone, err = s.cipher.Decrypt(contactPhoneField, contact.Phone); err != nil {
+			return nil, err
+		}
+
+		byPatient[contact.PatientID] = append(byPatient[contact.PatientID], contact)
+	}
+
+	return byPatient, rows.Err()
+}
+
+// Reencrypt encrypts the phone of every emergency contact with the current
+// key like PatientStore.Reencrypt, returning how many contacts were updated.
+func (s *EmergencyContactStore) Reencrypt(ctx context.Context) (int, error) {
+	updated, lastID := 0, 0
+
+	for {
+		var n, read int
+
+		err := audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+			var err error
+			n, read, lastID, err = s.reencryptBatch(ctx, tx, lastID)
+			return err
+		})
+		if err != nil {
+			return updated, err
+		}
+
+		updated += n
+		if read < reencryptBatchSize {
+			return updated, nil
+		}
+	}
+}
+
+func (s *EmergencyContactStore) reencryptBatch(ctx context.Context, tx *sql.Tx, afterID int) (updated, read, lastID int, err error) {
+	rows, err := tx.QueryContext(ctx, "select id, phone from emergency_contacts where id > $1 order by id limit $2 for update",
+		afterID, reencryptBatchSize)
+	if err != nil {
+		return 0, 0, afterID, err
+	}
+
+	type row struct {
+		id    int
+		phone string
+	}
+
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.phone); err != nil {
+			rows.Close()
+			return 0, 0, afterID, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, afterID, err
+	}
+
+	lastID = afterID
+	for _, r := range batch {
+		lastID = r.id
+		if s.cipher.Current(r.phone) {
+			continue
+		}
+
+		phone, err := s.cipher.Decrypt(contactPhoneField, r.phone)
+		if err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		if phone, err = s.cipher.Encrypt(contactPhoneField, phone); err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		if _, err := tx.ExecContext(ctx, "update emergency_contacts set phone = $1 where id = $2", phone, r.id); err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		updated++
+	}
+
+	return updated, len(batch), lastID, nil
+}
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/encryption"
+)
+
+// The administrative genders of patients, as in FHIR. The gender of a
+// patient is "" when it was not recorded.
+const (
+	GenderMale    = "male"
+	GenderFemale  = "female"
+	GenderOther   = "other"
+	GenderUnknown = "unknown"
+)
+
+// Genders are the administrative genders.
+var Genders = []string{GenderMale, GenderFemale, GenderOther, GenderUnknown}
+
+// The uses of addresses and contact points, as in FHIR. Only addresses are
+// for billing and only contact points mobile.
+const (
+	UseHome    = "home"
+	UseWork    = "work"
+	UseTemp    = "temp"
+	UseOld     = "old"
+	UseBilling = "billing"
+	UseMobile  = "mobile"
+)
+
+// AddressUses and TelecomUses are the uses of addresses and contact points.
+var (
+	AddressUses = []string{UseHome, UseWork, UseTemp, UseOld, UseBilling}
+	TelecomUses = []string{UseHome, UseWork, UseTemp, UseOld, UseMobile}
+)
+
+// The systems of contact points, as in FHIR.
+const (
+	TelecomPhone = "phone"
+	TelecomFax   = "fax"
+	TelecomEmail = "email"
+	TelecomPager = "pager"
+	TelecomURL   = "url"
+	TelecomSMS   = "sms"
+	TelecomOther = "other"
+)
+
+// TelecomSystems are the systems of contact points.
+var TelecomSystems = []string{TelecomPhone, TelecomFax, TelecomEmail, TelecomPager, TelecomURL, TelecomSMS, TelecomOther}
+
+// The types of identifiers: the medical record number a clinic or another
+// system gave the patient, a national identity number such as a social
+// security number, a passport number, or another identifier.
+const (
+	IdentifierMRN        = "mrn"
+	IdentifierNationalID = "national_id"
+	IdentifierPassport   = "passport"
+	IdentifierOther      = "other"
+)
+
+// IdentifierTypes are the types of identifiers.
+var IdentifierTypes = []string{IdentifierMRN, IdentifierNationalID, IdentifierPassport, IdentifierOther}
+
+// HumanName is the name of a patient in parts. Given holds the first and
+// middle names, in order.
+type HumanName struct {
+	Prefix string   `json:"prefix"`
+	Given  []string `json:"given"`
+	Family string   `json:"family"`
+	Suffix string   `json:"suffix"`
+}
+
+// Empty reports whether no part of n was recorded.
+func (n HumanName) Empty() bool {
+	return n.Prefix == "" && len(n.Given) == 0 && n.Family == "" && n.Suffix == ""
+}
+
+// String joins the parts of n in the western order, the prefix and given
+// names before the family name, as the display name of a patient given only
+// in parts.
+func (n HumanName) String() string {
+	parts := append(append([]string{n.Prefix}, n.Given...), n.Family, n.Suffix)
+
+	words := parts[:0]
+	for _, part := range parts {
+		if part != "" {
+			words = append(words, part)
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// Address is a postal address of a patient. Country is an ISO 3166-1 alpha-2
+// code such as "DE".
+type Address struct {
+	Use        string   `json:"use"`
+	Lines      []string `json:"lines"`
+	City       string   `json:"city"`
+	State      string   `json:"state"`
+	PostalCode string   `json:"postalCode"`
+	Country    string   `json:"country"`
+}
+
+// ContactPoint is a way to reach a patient other than their email and
+// phone, such as a work phone or a second email. Use is "" when not known.
+type ContactPoint struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+	Use    string `json:"use"`
+}
+
+// Identifier identifies a patient in another system. System is the
+// namespace of Value, such as a URI of the issuer, and may be "".
+type Identifier struct {
+	Type   string `json:"type"`
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+// Demographics are what is known about a patient beyond their name, email
+// and phone, aligned with the FHIR Patient resource. BirthDate is a date at
+// midnight UTC, nil when not known; Gender is one of Genders and
+// PreferredLanguage a BCP 47 tag such as "pt-BR", both "" when not known.
+type Demographics struct {
+	StructuredName    HumanName      `json:"structuredName"`
+	BirthDate         *time.Time     `json:"birthDate"`
+	Gender            string         `json:"gender"`
+	PreferredLanguage string         `json:"preferredLanguage"`
+	Addresses         []Address      `json:"addresses"`
+	Telecoms          []ContactPoint `json:"telecoms"`
+	Identifiers       []Identifier   `json:"identifiers"`
+}
+
+// ApplyDemographics sets the demographics of d that c changes.
+func (c PatientChanges) ApplyDemographics(d *Demographics) {
+	if c.StructuredName != nil {
+		d.StructuredName = *c.StructuredName
+	}
+	if c.BirthDate != nil {
+		d.BirthDate = nil
+		if !c.BirthDate.IsZero() {
+			birthDate := *c.BirthDate
+			d.BirthDate = &birthDate
+		}
+	}
+	if c.Gender != nil {
+		d.Gender = *c.Gender
+	}
+	if c.PreferredLanguage != nil {
+		d.PreferredLanguage = *c.PreferredLanguage
+	}
+	if c.Addresses != nil {
+		d.Addresses = *c.Addresses
+	}
+	if c.Telecoms != nil {
+		d.Telecoms = *c.Telecoms
+	}
+	if c.Identifiers != nil {
+		d.Identifiers = *c.Identifiers
+	}
+}
+
+// storedDemographics is the JSON of the demographics column.
+type storedDemographics struct {
+	Addresses   []Address      `json:"addresses,omitempty"`
+	Telecoms    []ContactPoint `json:"telecoms,omitempty"`
+	Identifiers []Identifier   `json:"identifiers,omitempty"`
+}
+
+// sealDemographics returns the stored value of the demographics column of
+// d, encrypted with c, or "" when d has no addresses, contact points or
+// identifiers.
+func sealDemographics(c *encryption.Cipher, d Demographics) (string, error) {
+	if len(d.Addresses) == 0 && len(d.Telecoms) == 0 && len(d.Identifiers) == 0 {
+		return "", nil
+	}
+
+	b, err := json.Marshal(storedDemographics{Addresses: d.Addresses, Telecoms: d.Telecoms, Identifiers: d.Identifiers})
+	if err != nil {
+		return "", err
+	}
+
+	return c.Encrypt(demographicsField, string(b))
+}
+
+// unsealDemographics decrypts the stored value of the demographics column
+// into d.
+func unsealDemographics(c *encryption.Cipher, stored string, d *Demographics) error {
+	plaintext, err := c.Decrypt(demographicsField, stored)
+	if err != nil || plaintext == "" {
+		return err
+	}
+
+	var decoded storedDemographics
+	if err := json.Unmarshal([]byte(plaintext), &decoded); err != nil {
+		return err
+	}
+
+	d.Addresses, d.Telecoms, d.Identifiers = decoded.Addresses, decoded.Telecoms, decoded.Identifiers
+	return nil
+}
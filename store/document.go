@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+)
+
+// Document is a file attached to a patient, such as an insurance card or a
+// lab result. Its content is kept by the document storage under StorageKey.
+type Document struct {
+	ID          int    `json:"id"`
+	PatientID   int    `json:"patientId"`
+	ClinicID    int    `json:"clinicId"`
+	Kind        string `json:"kind"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	// SHA256 is the hex SHA-256 of the content.
+	SHA256      string    `json:"sha256"`
+	Description string    `json:"description"`
+	StorageKey  string    `json:"-"`
+	UploadedBy  string    `json:"uploadedBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// DocumentKinds are the kinds of documents, as stored in Document.Kind.
+var DocumentKinds = []string{"insurance_card", "referral_letter", "lab_result", "other"}
+
+// DocumentRepository reads and writes the document metadata of the clinic
+// the context acts for. Uploads and deletions are recorded in the audit log
+// of the patient as performed by actor. Deleting a document, or purging or
+// erasing its patient, queues its file for deletion from the storage.
+type DocumentRepository interface {
+	// Create inserts d for a patient who is not deleted and sets its ID and
+	// CreatedAt, returning ErrNotFound when there is no such patient.
+	Create(ctx context.Context, actor string, d *Document) error
+	// Get returns a document, or ErrNotFound.
+	Get(ctx context.Context, id int) (*Document, error)
+	// ListByPatients returns the documents of many patients grouped by
+	// patient id, newest first.
+	ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*Document, error)
+	// Delete removes a document and returns it, or ErrNotFound.
+	Delete(ctx context.Context, actor string, id int) (*Document, error)
+}
+
+const documentColumns = "id, patient_id, clinic_id, kind, filename, content_type, size_bytes, sha256, description, storage_key, uploaded_by, created_at"
+
+// queueDocumentDeletions are the statements queueing the files of the
+// documents of the patients in $1, an array of ids, for deletion and
+// removing the documents, run by purges and erasures.
+var queueDocumentDeletions = []string{
+	"insert into document_deletions(storage_key) select storage_key from documents where patient_id = any($1) on conflict do nothing",
+	"delete from documents where patient_id = any($1)",
+}
+
+func scanDocument(row rowScanner) (*Document, error) {
+	d := &Document{}
+
+	err := row.Scan(&d.ID, &d.PatientID, &d.ClinicID, &d.Kind, &d.Filename, &d.ContentType, &d.Size, &d.SHA256,
+		&d.Description, &d.StorageKey, &d.UploadedBy, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// DocumentStore is the Postgres DocumentRepository.
+type DocumentStore struct {
+	db    *sql.DB
+	audit *audit.AuditLogger
+}
+
+func NewDocumentStore(db *sql.DB, auditLogger *audit.AuditLogger) *DocumentStore {
+	return &DocumentStore{db: db, audit: auditLogger}
+}
+
+func (s *DocumentStore) Create(ctx context.Context, actor string, d *Document) error {
+	clinic, err := clinicOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		stmt := `insert into documents(patient_id, kind, filename, content_type, size_bytes, sha256, description, storage_key, uploaded_by, clinic_id)
+			select id, $2::text, $3::text, $4::text, $5::bigint, $6::text, $7::text, $8::text, $9::text, clinic_id from patients
+			where id = $1 and deleted_at is null and ` + inClinic("clinic_id", 10) + ` returning id, clinic_id, created_at`
+		err := tx.QueryRowContext(ctx, stmt, d.PatientID, d.Kind, d.Filename, d.ContentType, d.Size, d.SHA256,
+			d.Description, d.StorageKey, actor, clinic).Scan(&d.ID, &d.ClinicID, &d.CreatedAt)
+		if err != nil {
+			return err
+		}
+		d.UploadedBy = actor
+
+		return s.audit.Log(ctx, tx, "upload_document", d.PatientID, actor, nil, d)
+	})
+
+	return notFound(err)
+}
+
+func (s *DocumentStore) Get(ctx context.Context, id int) (*Document, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := scanDocument(conn(ctx, s.db).QueryRowContext(ctx,
+		"select "+documentColumns+" from documents where id = $1 and "+inClinic("clinic_id", 2), id, clinic))
+	return d, notFound(err)
+}
+
+func (s *DocumentStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*Document, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx,
+		"select "+documentColumns+" from documents where patient_id = any($1) and "+inClinic("clinic_id", 2)+" order by created_at desc, id desc",
+		pq.Array(patientIDs), clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byPatient := make(map[int][]*Document, len(patientIDs))
+	for rows.Next() {
+		d, err := scanDocument(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		byPatient[d.PatientID] = append(byPatient[d.PatientID], d)
+	}
+
+	return byPatient, rows.Err()
+}
+
+func (s *DocumentStore) Delete(ctx context.Context, actor string, id int) (*Document, error) {
+	clinic, err := clinicOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted *Document
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		d, err := scanDocument(tx.QueryRowContext(ctx,
+			"delete from documents where id = $1 and "+inClinic("clinic_id", 2)+" returning "+documentColumns, id, clinic))
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "insert into document_deletions(storage_key) values($1) on conflict do nothing", d.StorageKey); err != nil {
+			return err
+		}
+
+		deleted = d
+		return s.audit.Log(ctx, tx, "delete_document", d.PatientID, actor, d, nil)
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return deleted, nil
+}
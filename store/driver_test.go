@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// stubResult answers a statement sent to a stub database: the rows of
+// Columns, or Err.
+type stubResult struct {
+	Columns []string
+	Rows    [][]driver.Value
+	Err     error
+}
+
+// stubAnswer returns the result of query run with args. Transactions ending
+// are sent as the queries "COMMIT" and "ROLLBACK", whose Err is returned.
+type stubAnswer func(query string, args []driver.NamedValue) stubResult
+
+var (
+	stubAnswers sync.Map
+	stubCount   atomic.Int64
+)
+
+func init() {
+	sql.Register("stub", stubDriver{})
+}
+
+// stubDB returns a database answering every statement with answer, for the
+// code paths that need a *sql.DB but no Postgres.
+func stubDB(t *testing.T, answer stubAnswer) *sql.DB {
+	t.Helper()
+
+	name := fmt.Sprintf("stub-%d", stubCount.Add(1))
+	stubAnswers.Store(name, answer)
+
+	db, err := sql.Open("stub", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		stubAnswers.Delete(name)
+	})
+
+	return db
+}
+
+type stubDriver struct{}
+
+func (stubDriver) Open(name string) (driver.Conn, error) {
+	answer, ok := stubAnswers.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("no stub database %q", name)
+	}
+
+	return &stubConn{answer: answer.(stubAnswer)}, nil
+}
+
+type stubConn struct {
+	answer stubAnswer
+}
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("the stub driver does not prepare statements")
+}
+
+func (c *stubConn) Close() error { return nil }
+
+func (c *stubConn) Begin() (driver.Tx, error) { return stubTx{conn: c}, nil }
+
+func (c *stubConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.answer(query, args)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+
+	return &stubRows{columns: result.Columns, rows: result.Rows}, nil
+}
+
+func (c *stubConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if result := c.answer(query, args); result.Err != nil {
+		return nil, result.Err
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+// CheckNamedValue accepts every argument as is, such as the pq arrays.
+func (c *stubConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+type stubTx struct {
+	conn *stubConn
+}
+
+func (tx stubTx) Commit() error   { return tx.conn.answer("COMMIT", nil).Err }
+func (tx stubTx) Rollback() error { return tx.conn.answer("ROLLBACK", nil).Err }
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+
+func (r *stubRows) Close() error { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+type DuplicateReport struct {
+	ID                   int     `json:"id"`
+	ReportedPatientID    int     `json:"reportedPatientId"`
+	SuspectedDuplicateID int     `json:"suspectedDuplicateId"`
+	ReportedBy           *string `json:"reportedBy"`
+	Status               string  `json:"status"`
+}
+
+// DuplicateReportRepository reads and writes reports of suspected duplicate
+// patients of the clinic the context acts for.
+type DuplicateReportRepository interface {
+	// ListPending returns the reports still waiting for review, oldest first.
+	ListPending(ctx context.Context) ([]*DuplicateReport, error)
+	// Create inserts report and sets its ID.
+	Create(ctx context.Context, report *DuplicateReport) error
+	// SetStatus changes the status of a report and returns it, or
+	// ErrNotFound when it does not exist.
+	SetStatus(ctx context.Context, id int, status string) (*DuplicateReport, error)
+}
+
+const duplicateReportColumns = "id, reported_patient_id, suspected_duplicate_id, reported_by, status"
+
+func scanDuplicateReport(row rowScanner) (*DuplicateReport, error) {
+	report := &DuplicateReport{}
+
+	err := row.Scan(&report.ID, &report.ReportedPatientID, &report.SuspectedDuplicateID, &report.ReportedBy, &report.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// DuplicateReportStore is the Postgres DuplicateReportRepository.
+type DuplicateReportStore struct {
+	db *sql.DB
+}
+
+func NewDuplicateReportStore(db *sql.DB) *DuplicateReportStore {
+	return &DuplicateReportStore{db: db}
+}
+
+func (s *DuplicateReportStore) ListPending(ctx context.Context) ([]*DuplicateReport, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx,
+		"select "+duplicateReportColumns+" from duplicate_reports where status = 'pending' and "+inClinic("clinic_id", 1)+" order by id", clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*DuplicateReport{}
+	for rows.Next() {
+		report, err := scanDuplicateReport(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
+
+// Create fails the foreign keys to patients, which include the clinic, for
+// patients of another clinic.
+func (s *DuplicateReportStore) Create(ctx context.Context, report *DuplicateReport) error {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt := "insert into duplicate_reports(reported_patient_id, suspected_duplicate_id, reported_by, status, clinic_id) values($1, $2, $3, $4, $5) returning id;"
+
+	return conn(ctx, s.db).QueryRowContext(ctx, stmt, report.ReportedPatientID, report.SuspectedDuplicateID, report.ReportedBy, report.Status, clinicID).
+		Scan(&report.ID)
+}
+
+func (s *DuplicateReportStore) SetStatus(ctx context.Context, id int, status string) (*DuplicateReport, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := "update duplicate_reports set status = $1 where id = $2 and " + inClinic("clinic_id", 3) + " returning " + duplicateReportColumns
+
+	report, err := scanDuplicateReport(conn(ctx, s.db).QueryRowContext(ctx, stmt, status, id, clinic))
+	return report, notFound(err)
+}
@@ -0,0 +1,309 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// Encounter is a visit of a patient with its current, latest revision.
+type Encounter struct {
+	ID            int       `json:"id"`
+	PatientID     int       `json:"patientId"`
+	ProviderID    *int      `json:"providerId"`
+	EncounteredAt time.Time `json:"encounteredAt"`
+	CreatedBy     string    `json:"createdBy"`
+	CreatedAt     time.Time `json:"createdAt"`
+	EncounterRevision
+}
+
+// EncounterRevision is one immutable version of the clinical content of an
+// encounter. Revision 1 is the encounter as first recorded and every later
+// one is an amendment with a Reason.
+type EncounterRevision struct {
+	Revision       int       `json:"revision"`
+	ChiefComplaint string    `json:"chiefComplaint"`
+	Notes          string    `json:"notes"`
+	DiagnosisCodes []string  `json:"diagnosisCodes"`
+	Reason         *string   `json:"reason"`
+	RecordedBy     string    `json:"recordedBy"`
+	RecordedAt     time.Time `json:"recordedAt"`
+}
+
+// EncounterPage is one page of the encounters of a patient.
+type EncounterPage struct {
+	Encounters []*Encounter
+	// TotalCount is the number of encounters of the patient across all
+	// pages.
+	TotalCount int
+}
+
+// EncounterAmendment holds the clinical fields an amendment changes. Nil
+// fields keep the value of the current revision.
+type EncounterAmendment struct {
+	ChiefComplaint *string
+	Notes          *string
+	DiagnosisCodes *[]string
+	Reason         string
+}
+
+// EncounterRepository reads and records encounters. Encounters are never
+// edited in place: an amendment adds a revision and keeps the earlier ones.
+// Writes are recorded in the audit log of the patient as performed by actor.
+// Methods only see the encounters of the clinic the context acts for, and
+// return ErrNotFound when the encounter, or for Create the patient, does not
+// exist there.
+type EncounterRepository interface {
+	Get(ctx context.Context, id int) (*Encounter, error)
+	// ListByPatients returns the same page of the encounters of many
+	// patients, newest first, by patient id. Patients without encounters
+	// may be left out.
+	ListByPatients(ctx context.Context, patientIDs []int, limit, offset int) (map[int]*EncounterPage, error)
+	// Revisions returns every revision of many encounters grouped by
+	// encounter id, oldest first.
+	Revisions(ctx context.Context, ids []int) (map[int][]*EncounterRevision, error)
+	// Create records encounter for a patient who is not deleted as its
+	// first revision, and sets its ID and the recorded fields. An unknown
+	// provider fails with a NOT_FOUND *utils.CodedError.
+	Create(ctx context.Context, actor string, encounter *Encounter) error
+	// Amend adds a revision to an encounter and returns the amended
+	// encounter.
+	Amend(ctx context.Context, actor string, id int, amendment EncounterAmendment) (*Encounter, error)
+}
+
+const encounterColumns = `e.id, e.patient_id, e.provider_id, e.encountered_at, e.created_by, e.created_at,
+	r.revision, r.chief_complaint, r.notes, r.diagnosis_codes, r.reason, r.recorded_by, r.recorded_at`
+
+// encounterFrom joins each encounter with its latest revision.
+const encounterFrom = `from encounters e join lateral (
+		select * from encounter_revisions where encounter_id = e.id order by revision desc limit 1
+	) r on true`
+
+const encounterSelect = "select " + encounterColumns + " " + encounterFrom
+
+const encounterRevisionColumns = "revision, chief_complaint, notes, diagnosis_codes, reason, recorded_by, recorded_at"
+
+// fields returns the scan destinations of encounterRevisionColumns, in order.
+func (r *EncounterRevision) fields() []interface{} {
+	return []interface{}{&r.Revision, &r.ChiefComplaint, &r.Notes, (*pq.StringArray)(&r.DiagnosisCodes), &r.Reason, &r.RecordedBy, &r.RecordedAt}
+}
+
+// fields returns the scan destinations of encounterColumns, in order.
+func (e *Encounter) fields() []interface{} {
+	return append([]interface{}{&e.ID, &e.PatientID, &e.ProviderID, &e.EncounteredAt, &e.CreatedBy, &e.CreatedAt},
+		e.EncounterRevision.fields()...)
+}
+
+func scanEncounter(row rowScanner) (*Encounter, error) {
+	encounter := &Encounter{}
+
+	if err := row.Scan(encounter.fields()...); err != nil {
+		return nil, err
+	}
+
+	return encounter, nil
+}
+
+// EncounterStore is the Postgres EncounterRepository.
+type EncounterStore struct {
+	db    *sql.DB
+	audit *audit.AuditLogger
+}
+
+func NewEncounterStore(db *sql.DB, auditLogger *audit.AuditLogger) *EncounterStore {
+	return &EncounterStore{db: db, audit: auditLogger}
+}
+
+func (s *EncounterStore) Get(ctx context.Context, id int) (*Encounter, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	encounter, err := scanEncounter(conn(ctx, s.db).QueryRowContext(ctx,
+		encounterSelect+" where e.id = $1 and "+inClinic("e.clinic_id", 2), id, clinic))
+	return encounter, notFound(err)
+}
+
+func (s *EncounterStore) ListByPatients(ctx context.Context, patientIDs []int, limit, offset int) (map[int]*EncounterPage, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Numbering the encounters of each patient pages them all in one query,
+	// with the count of each patient alongside.
+	rows, err := conn(ctx, s.db).QueryContext(ctx, `select * from (
+			select `+encounterColumns+`,
+				row_number() over (partition by e.patient_id order by e.encountered_at desc, e.id desc) as n,
+				count(*) over (partition by e.patient_id) as total
+			`+encounterFrom+` where e.patient_id = any($1) and `+inClinic("e.clinic_id", 4)+`
+		) page where n > $3 and n <= $2 + $3 order by patient_id, n`,
+		pq.Array(patientIDs), limit, offset, clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byPatient := make(map[int]*EncounterPage, len(patientIDs))
+	for rows.Next() {
+		encounter := &Encounter{}
+		var n, total int
+
+		err := rows.Scan(append(encounter.fields(), &n, &total)...)
+		if err != nil {
+			return nil, err
+		}
+
+		page, ok := byPatient[encounter.PatientID]
+		if !ok {
+			page = &EncounterPage{Encounters: []*Encounter{}, TotalCount: total}
+			byPatient[encounter.PatientID] = page
+		}
+		page.Encounters = append(page.Encounters, encounter)
+	}
+
+	return byPatient, rows.Err()
+}
+
+func (s *EncounterStore) Revisions(ctx context.Context, ids []int) (map[int][]*EncounterRevision, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx,
+		"select encounter_id, "+encounterRevisionColumns+" from encounter_revisions where encounter_id = any($1) and "+
+			inClinic("clinic_id", 2)+" order by revision",
+		pq.Array(ids), clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byEncounter := make(map[int][]*EncounterRevision, len(ids))
+	for rows.Next() {
+		var encounterID int
+		revision := &EncounterRevision{}
+
+		if err := rows.Scan(append([]interface{}{&encounterID}, revision.fields()...)...); err != nil {
+			return nil, err
+		}
+
+		byEncounter[encounterID] = append(byEncounter[encounterID], revision)
+	}
+
+	return byEncounter, rows.Err()
+}
+
+func (s *EncounterStore) Create(ctx context.Context, actor string, encounter *Encounter) error {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		if encounter.ProviderID != nil {
+			var exists bool
+			err := tx.QueryRowContext(ctx, "select exists (select 1 from providers where id = $1 and "+inClinic("clinic_id", 2)+")",
+				*encounter.ProviderID, clinic).Scan(&exists)
+			if err != nil {
+				return err
+			}
+
+			if !exists {
+				return utils.NotFound("provider %d not found", *encounter.ProviderID)
+			}
+		}
+
+		err := tx.QueryRowContext(ctx, `insert into encounters(patient_id, provider_id, encountered_at, created_by, clinic_id)
+			select id, $2::integer, $3::timestamptz, $4::text, clinic_id from patients
+			where id = $1 and deleted_at is null and `+inClinic("clinic_id", 5)+`
+			returning id, created_at`,
+			encounter.PatientID, encounter.ProviderID, encounter.EncounteredAt, actor, clinic).Scan(&encounter.ID, &encounter.CreatedAt)
+		if err != nil {
+			return err
+		}
+		encounter.CreatedBy = actor
+
+		encounter.Revision = 1
+		encounter.Reason = nil
+		if err := s.insertRevision(ctx, tx, actor, encounter); err != nil {
+			return err
+		}
+
+		return s.audit.Log(ctx, tx, "create_encounter", encounter.PatientID, actor, nil, encounter)
+	})
+
+	return notFound(err)
+}
+
+func (s *EncounterStore) Amend(ctx context.Context, actor string, id int, amendment EncounterAmendment) (*Encounter, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var amended *Encounter
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		// Locking the encounter serializes amendments, so two cannot take
+		// the same revision number.
+		var locked int
+		err := tx.QueryRowContext(ctx, "select id from encounters where id = $1 and "+inClinic("clinic_id", 2)+" for update",
+			id, clinic).Scan(&locked)
+		if err != nil {
+			return err
+		}
+
+		before, err := scanEncounter(tx.QueryRowContext(ctx, encounterSelect+" where e.id = $1", id))
+		if err != nil {
+			return err
+		}
+
+		after := *before
+		after.Revision = before.Revision + 1
+		after.Reason = &amendment.Reason
+		if amendment.ChiefComplaint != nil {
+			after.ChiefComplaint = *amendment.ChiefComplaint
+		}
+		if amendment.Notes != nil {
+			after.Notes = *amendment.Notes
+		}
+		if amendment.DiagnosisCodes != nil {
+			after.DiagnosisCodes = *amendment.DiagnosisCodes
+		}
+
+		if err := s.insertRevision(ctx, tx, actor, &after); err != nil {
+			return err
+		}
+
+		amended = &after
+		return s.audit.Log(ctx, tx, "amend_encounter", after.PatientID, actor, before, after)
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return amended, nil
+}
+
+// insertRevision records the revision of encounter and sets its recorded
+// fields.
+func (s *EncounterStore) insertRevision(ctx context.Context, tx *sql.Tx, actor string, encounter *Encounter) error {
+	if encounter.DiagnosisCodes == nil {
+		encounter.DiagnosisCodes = []string{}
+	}
+
+	encounter.RecordedBy = actor
+
+	return tx.QueryRowContext(ctx, `insert into encounter_revisions(encounter_id, revision, chief_complaint, notes, diagnosis_codes, reason, recorded_by, clinic_id)
+		select $1, $2, $3, $4, $5, $6, $7, clinic_id from encounters where id = $1 returning recorded_at`,
+		encounter.ID, encounter.Revision, encounter.ChiefComplaint, encounter.Notes, pq.Array(encounter.DiagnosisCodes),
+		encounter.Reason, actor).Scan(&encounter.RecordedAt)
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/outbox"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// ErasedPatientName is the name erased patients are left with.
+const ErasedPatientName = "Erased patient"
+
+// erasedEmail returns the placeholder email of the erased patient id, which
+// keeps emails unique within the clinic and can never be delivered.
+func erasedEmail(id int) string {
+	return fmt.Sprintf("erased-%d@erased.invalid", id)
+}
+
+// Erase anonymizes the patient and the duplicates merged into it, which are
+// the same person: their name, email and phone are replaced and their
+// demographics cleared, their emergency contacts, insurance policies and
+// documents deleted, the free text of their appointments cleared, and the patient values recorded in their audit
+// entries and undelivered events removed. The patients are soft-deleted.
+// Encounters and notes are medical records the clinic has to keep, so they
+// stay with the anonymized patient, as do the audit entries themselves. The
+// erasure is recorded in patient_erasures with justification, and in the
+// audit log without an old value.
+func (s *PatientStore) Erase(ctx context.Context, actor string, id int, justification string) ([]*Patient, error) {
+	var erased []*Patient
+
+	_, err := s.audited(ctx, actor, "erase", func(tx *sql.Tx) (*Patient, *Patient, error) {
+		clinic, err := clinicScope(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		before, err := scanPatient(tx.QueryRowContext(ctx,
+			"select "+patientSelectColumns+" from patients where id = $1 and "+inClinic("clinic_id", 2)+" for update", id, clinic), s.cipher)
+		if err != nil {
+			return nil, nil, err
+		}
+		if before.ErasedAt != nil {
+			return nil, nil, &utils.CodedError{
+				Code:    "BAD_USER_INPUT",
+				Message: fmt.Sprintf("patient %d was already erased", id),
+			}
+		}
+
+		ids, err := mergedPatients(ctx, tx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, stmt := range append([]string{
+			"delete from emergency_contacts where patient_id = any($1)",
+			"delete from insurance_policies where patient_id = any($1)",
+			"update appointments set reason = '', notes = '' where patient_id = any($1)",
+			"update audit_logs set old_value = null, new_value = null where patient_id = any($1)",
+			"delete from outbox_events where patient_id = any($1)",
+		}, queueDocumentDeletions...) {
+			if _, err := tx.ExecContext(ctx, stmt, pq.Array(ids)); err != nil {
+				return nil, nil, fmt.Errorf("erasing patient %d: %w", id, err)
+			}
+		}
+
+		erased = make([]*Patient, 0, len(ids))
+		for _, patientID := range ids {
+			patient, err := s.anonymize(ctx, tx, actor, patientID, justification)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if patientID == id {
+				erased = append([]*Patient{patient}, erased...)
+				continue
+			}
+			erased = append(erased, patient)
+
+			// The erasure of id is recorded by audited, the merged
+			// duplicates are recorded here.
+			if err := s.audit.Log(ctx, tx, "erase", patientID, actor, nil, patient); err != nil {
+				return nil, nil, err
+			}
+			if err := s.publish(ctx, tx, outbox.PatientErased, patientID, patient); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		return nil, erased[0], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return erased, nil
+}
+
+// mergedPatients returns id and the ids of the patients merged into it,
+// directly or through other merged patients, locking them.
+func mergedPatients(ctx context.Context, tx *sql.Tx, id int) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, `with recursive merged(id) as (
+			select $1::integer
+			union
+			select p.id from patients p join merged m on p.merged_into_id = m.id
+		)
+		select p.id from patients p join merged m on m.id = p.id order by p.id for update of p`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var patientID int
+		if err := rows.Scan(&patientID); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, patientID)
+	}
+
+	return ids, rows.Err()
+}
+
+// anonymize replaces the identifying fields of the patient id, soft-deletes
+// it and records its erasure, returning the anonymized patient.
+func (s *PatientStore) anonymize(ctx context.Context, tx *sql.Tx, actor string, id int, justification string) (*Patient, error) {
+	sealed, err := sealPatient(s.cipher, erasedEmail(id), "")
+	if err != nil {
+		return nil, err
+	}
+
+	patient, err := scanPatient(tx.QueryRowContext(ctx, `update patients set name = $1, email = $2, phone = $3,
+			email_index = $4, phone_index = $5, phone_last4_index = $6,
+			name_prefix = '', given_names = '{}', family_name = '', name_suffix = '', birth_date = null,
+			gender = '', preferred_language = '', demographics = '',
+			deleted_at = coalesce(deleted_at, now()), erased_at = now(), version = version + 1
+		where id = $7 returning `+patientSelectColumns,
+		ErasedPatientName, sealed.email, sealed.phone, sealed.emailIndex, sealed.phoneIndex, sealed.phoneLast4Index, id), s.cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"insert into patient_erasures(patient_id, clinic_id, performed_by, justification) values($1, $2, $3, $4)",
+		id, patient.ClinicID, actor, justification)
+	if err != nil {
+		return nil, err
+	}
+
+	return patient, nil
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// HL7DeadLetter is an HL7 v2 message that could not be ingested.
+type HL7DeadLetter struct {
+	ID int64 `json:"id"`
+	// ControlID is the MSH-10 control ID of the message, empty when its
+	// header could not be read.
+	ControlID string `json:"controlId"`
+	Message   string `json:"message"`
+	// Error is why the message was rejected.
+	Error      string    `json:"error"`
+	ReceivedBy string    `json:"receivedBy"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// HL7DeadLetterRepository records the HL7 messages the clinic the context
+// acts for could not ingest.
+type HL7DeadLetterRepository interface {
+	// Add records l as received by actor and sets its ID and ReceivedAt.
+	Add(ctx context.Context, actor string, l *HL7DeadLetter) error
+}
+
+// HL7DeadLetterStore is the Postgres HL7DeadLetterRepository.
+type HL7DeadLetterStore struct {
+	db *sql.DB
+}
+
+func NewHL7DeadLetterStore(db *sql.DB) *HL7DeadLetterStore {
+	return &HL7DeadLetterStore{db: db}
+}
+
+func (s *HL7DeadLetterStore) Add(ctx context.Context, actor string, l *HL7DeadLetter) error {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	l.ReceivedBy = actor
+
+	return conn(ctx, s.db).QueryRowContext(ctx,
+		`insert into hl7_dead_letters(clinic_id, control_id, message, error, received_by)
+		values ($1, $2, $3, $4, $5) returning id, received_at`,
+		clinicID, l.ControlID, l.Message, l.Error, actor).Scan(&l.ID, &l.ReceivedAt)
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// idempotencyPruneBatch is how many expired keys a claim deletes at most, so
+// the table is kept small without a worker of its own.
+const idempotencyPruneBatch = 20
+
+// IdempotencyKey is a key a client sent with a create mutation, so a retry
+// of the request can be answered with the outcome of the first one.
+type IdempotencyKey struct {
+	Key       string
+	Operation string
+	// RequestHash identifies the arguments the key was first sent with.
+	RequestHash string
+	// Response is the outcome of the request, nil while it runs.
+	Response  json.RawMessage
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// IdempotencyKeyRepository keeps the idempotency keys each user of the
+// clinic the context acts for sent.
+type IdempotencyKeyRepository interface {
+	// Claim records key as sent by actor for operation, with the arguments
+	// hashing to requestHash, until expiresAt, and returns nil. When actor
+	// sent key before and it has not expired, nothing is recorded and the
+	// stored key is returned instead. Claims of a key in transactions
+	// running at the same time wait for the first one to end, so Claim and
+	// the writes of the request should run in one transaction.
+	Claim(ctx context.Context, actor, key, operation, requestHash string, expiresAt time.Time) (*IdempotencyKey, error)
+	// Complete stores response as the outcome of the request of the key
+	// actor claimed.
+	Complete(ctx context.Context, actor, key string, response []byte) error
+}
+
+// IdempotencyKeyStore is the Postgres IdempotencyKeyRepository.
+type IdempotencyKeyStore struct {
+	db *sql.DB
+}
+
+func NewIdempotencyKeyStore(db *sql.DB) *IdempotencyKeyStore {
+	return &IdempotencyKeyStore{db: db}
+}
+
+// Claim also deletes a batch of expired keys, of any clinic, skipping those
+// other claims are deleting.
+func (s *IdempotencyKeyStore) Claim(ctx context.Context, actor, key, operation, requestHash string, expiresAt time.Time) (*IdempotencyKey, error) {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	db := conn(ctx, s.db)
+
+	_, err = db.ExecContext(ctx,
+		`delete from idempotency_keys where id in (
+			select id from idempotency_keys where expires_at <= now() limit $1 for update skip locked
+		)`, idempotencyPruneBatch)
+	if err != nil {
+		return nil, err
+	}
+
+	// An expired key is claimed again in place.
+	var id int64
+	err = db.QueryRowContext(ctx,
+		`insert into idempotency_keys(clinic_id, user_id, key, operation, request_hash, expires_at)
+		values ($1, $2, $3, $4, $5, $6)
+		on conflict (clinic_id, user_id, key) do update
+		set operation = excluded.operation, request_hash = excluded.request_hash, response = null,
+			created_at = now(), expires_at = excluded.expires_at
+		where idempotency_keys.expires_at <= now()
+		returning id`,
+		clinicID, actor, key, operation, requestHash, expiresAt).Scan(&id)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	stored := &IdempotencyKey{Key: key}
+	var response []byte
+	err = db.QueryRowContext(ctx,
+		`select operation, request_hash, response, created_at, expires_at from idempotency_keys
+		where clinic_id = $1 and user_id = $2 and key = $3`,
+		clinicID, actor, key).Scan(&stored.Operation, &stored.RequestHash, &response, &stored.CreatedAt, &stored.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if response != nil {
+		stored.Response = response
+	}
+
+	return stored, nil
+}
+
+func (s *IdempotencyKeyStore) Complete(ctx context.Context, actor, key string, response []byte) error {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := conn(ctx, s.db).ExecContext(ctx,
+		"update idempotency_keys set response = $4 where clinic_id = $1 and user_id = $2 and key = $3",
+		clinicID, actor, key, string(response))
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/outbox"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// The statuses of an UpsertResult.
+const (
+	UpsertCreated   = "created"
+	UpsertUpdated   = "updated"
+	UpsertUnchanged = "unchanged"
+)
+
+// UpsertResult is the outcome of upserting one patient.
+type UpsertResult struct {
+	// Patient is the stored patient, nil when Err is set.
+	Patient *Patient
+	// Status is one of UpsertCreated, UpsertUpdated or UpsertUnchanged.
+	Status string
+	// Err is why the patient was not stored. A *utils.CodedError explains
+	// it to the caller; other errors are database errors.
+	Err error
+}
+
+// Upsert matches patients to the stored ones of the clinic by email,
+// ignoring case. A match gets the changes of UpsertChanges, keeping its id
+// and email, and a patient without one is created with the demographics of
+// the given patient. Each write is recorded in
+// the audit log. A soft-deleted match fails with a PATIENT_DELETED
+// *utils.CodedError rather than being changed.
+func (s *PatientStore) Upsert(ctx context.Context, actor string, patients []*Patient) ([]UpsertResult, error) {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]UpsertResult, len(patients))
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		for i, patient := range patients {
+			// A failed statement aborts a Postgres transaction, so each row
+			// runs in a savepoint that is rolled back on its own when it
+			// fails, keeping the rows before it.
+			if _, err := tx.ExecContext(ctx, "savepoint upsert_row"); err != nil {
+				return err
+			}
+
+			result, err := s.upsert(ctx, tx, actor, clinicID, patient)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				if _, err := tx.ExecContext(ctx, "rollback to savepoint upsert_row"); err != nil {
+					return err
+				}
+
+				results[i] = UpsertResult{Err: err}
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, "release savepoint upsert_row"); err != nil {
+				return err
+			}
+
+			results[i] = result
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// UpsertChanges returns the changes an upsert of patient makes to the stored
+// patient before: the name and phone, and the birth date, gender, addresses
+// and identifiers patient has. Demographics patient leaves out, as CSV rows
+// do, stay as stored. The changes are Empty when they change nothing.
+func UpsertChanges(before, patient *Patient) PatientChanges {
+	var changes PatientChanges
+
+	if patient.Name != before.Name {
+		changes.Name = &patient.Name
+	}
+	if patient.Phone != before.Phone {
+		changes.Phone = &patient.Phone
+	}
+	if patient.BirthDate != nil && (before.BirthDate == nil || !patient.BirthDate.Equal(*before.BirthDate)) {
+		changes.BirthDate = patient.BirthDate
+	}
+	if patient.Gender != "" && patient.Gender != before.Gender {
+		changes.Gender = &patient.Gender
+	}
+	if len(patient.Addresses) > 0 && !reflect.DeepEqual(patient.Addresses, before.Addresses) {
+		changes.Addresses = &patient.Addresses
+	}
+	if len(patient.Identifiers) > 0 && !reflect.DeepEqual(patient.Identifiers, before.Identifiers) {
+		changes.Identifiers = &patient.Identifiers
+	}
+
+	return changes
+}
+
+// ImportSummary counts the rows of an import by outcome. ImportedCount is
+// the rows created, updated or found unchanged.
+type ImportSummary struct {
+	ImportedCount int `json:"importedCount"`
+	Created       int `json:"created"`
+	Updated       int `json:"updated"`
+	Unchanged     int `json:"unchanged"`
+	Failed        int `json:"failed"`
+}
+
+// CompleteImport writes a PatientBulkImportCompleted event holding summary
+// to the outbox. The batches of an import commit on their own, so the event
+// is written once the last of them committed and is never published for
+// rows that rolled back.
+func (s *PatientStore) CompleteImport(ctx context.Context, summary ImportSummary) error {
+	if _, err := clinicOf(ctx); err != nil {
+		return err
+	}
+
+	return audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		return s.events.Write(ctx, tx, outbox.PatientBulkImportCompleted, 0, summary)
+	})
+}
+
+func (s *PatientStore) upsert(ctx context.Context, tx *sql.Tx, actor string, clinicID int, patient *Patient) (UpsertResult, error) {
+	match, key := "lower(email) = lower($1)", interface{}(patient.Email)
+	if s.cipher.Enabled() {
+		match, key = "email_index = $1", emailIndex(s.cipher, patient.Email)
+	}
+
+	before, err := scanPatient(tx.QueryRowContext(ctx,
+		"select "+patientSelectColumns+" from patients where "+match+" and clinic_id = $2 for update", key, clinicID), s.cipher)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		created := &Patient{Name: patient.Name, Email: patient.Email, Phone: patient.Phone, ClinicID: clinicID, Demographics: patient.Demographics}
+		if err := s.insert(ctx, tx, created); err != nil {
+			return UpsertResult{}, err
+		}
+
+		if err := s.audit.Log(ctx, tx, "create", created.ID, actor, nil, created); err != nil {
+			return UpsertResult{}, err
+		}
+
+		return UpsertResult{Patient: created, Status: UpsertCreated}, s.publish(ctx, tx, outbox.PatientCreated, created.ID, created)
+	case err != nil:
+		return UpsertResult{}, err
+	case before.DeletedAt != nil:
+		return UpsertResult{}, &utils.CodedError{
+			Code:    "PATIENT_DELETED",
+			Message: "the patient with this email is deleted and must be restored before it can be imported",
+		}
+	}
+
+	changes := UpsertChanges(before, patient)
+	if changes.Empty() {
+		return UpsertResult{Patient: before, Status: UpsertUnchanged}, nil
+	}
+
+	set, args, err := patientUpdateClause(changes, before.Demographics, s.cipher)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+
+	stmt := fmt.Sprintf("update patients set %s, version = version + 1 where id = $%d returning %s", set, len(args)+1, patientSelectColumns)
+	after, err := scanPatient(tx.QueryRowContext(ctx, stmt, append(args, before.ID)...), s.cipher)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+
+	if err := s.audit.Log(ctx, tx, "update", after.ID, actor, before, after); err != nil {
+		return UpsertResult{}, err
+	}
+
+	return UpsertResult{Patient: after, Status: UpsertUpdated}, s.publish(ctx, tx, outbox.PatientUpdated, after.ID, after)
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/codixir/smart-emerge-starter/outbox"
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+func TestCompleteImport(t *testing.T) {
+	summary := ImportSummary{ImportedCount: 3, Created: 1, Updated: 1, Unchanged: 1, Failed: 2}
+
+	tests := []struct {
+		name       string
+		insertErr  error
+		statements []string
+	}{
+		{name: "written", statements: []string{"insert into outbox_events", "COMMIT"}},
+		{name: "insert fails", insertErr: errors.New("connection reset"), statements: []string{"insert into outbox_events", "ROLLBACK"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var statements []string
+			var args []driver.NamedValue
+			db := stubDB(t, func(query string, queryArgs []driver.NamedValue) stubResult {
+				if strings.HasPrefix(query, "insert into outbox_events") {
+					statements = append(statements, "insert into outbox_events")
+					args = queryArgs
+					return stubResult{Err: tt.insertErr}
+				}
+
+				statements = append(statements, query)
+				return stubResult{}
+			})
+
+			s := NewPatientStore(db, nil, nil, outbox.New(db, nil), nil, 0)
+			err := s.CompleteImport(tenant.WithClinic(context.Background(), 1), summary)
+			if !errors.Is(err, tt.insertErr) {
+				t.Fatalf("err = %v, want %v", err, tt.insertErr)
+			}
+
+			if fmt.Sprint(statements) != fmt.Sprint(tt.statements) {
+				t.Errorf("statements = %q, want %q", statements, tt.statements)
+			}
+
+			if len(args) != 4 {
+				t.Fatalf("insert args = %v, want the type, patient, payload and clinic", args)
+			}
+			if args[0].Value != outbox.PatientBulkImportCompleted || fmt.Sprint(args[1].Value) != "0" || fmt.Sprint(args[3].Value) != "1" {
+				t.Errorf("event = %v of patient %v in clinic %v, want %s of patient 0 in clinic 1",
+					args[0].Value, args[1].Value, args[3].Value, outbox.PatientBulkImportCompleted)
+			}
+
+			var payload ImportSummary
+			if err := json.Unmarshal([]byte(fmt.Sprint(args[2].Value)), &payload); err != nil || payload != summary {
+				t.Errorf("payload = %v (%v), want %+v", args[2].Value, err, summary)
+			}
+		})
+	}
+}
+
+func TestCompleteImportNeedsClinic(t *testing.T) {
+	db := stubDB(t, func(query string, args []driver.NamedValue) stubResult {
+		t.Errorf("unexpected statement %q", query)
+		return stubResult{}
+	})
+
+	s := NewPatientStore(db, nil, nil, outbox.New(db, nil), nil, 0)
+	if err := s.CompleteImport(context.Background(), ImportSummary{}); err == nil {
+		t.Error("err = nil, want one without a clinic")
+	}
+}
@@ -0,0 +1,373 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/encryption"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// InsurancePolicy is the coverage of a patient by a payer. It is in effect
+// from EffectiveDate through ExpiryDate, or indefinitely when ExpiryDate is
+// nil, unless it was deactivated. The dates are days, at midnight UTC.
+type InsurancePolicy struct {
+	ID            int        `json:"id"`
+	PatientID     int        `json:"patientId"`
+	Payer         string     `json:"payer"`
+	MemberID      string     `json:"memberId"`
+	GroupNumber   string     `json:"groupNumber"`
+	EffectiveDate time.Time  `json:"effectiveDate"`
+	ExpiryDate    *time.Time `json:"expiryDate"`
+	// Priority is the order the policies of a patient are billed in, one of
+	// PolicyPriorities.
+	Priority      string     `json:"priority"`
+	DeactivatedAt *time.Time `json:"deactivatedAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+// PolicyPriorities are the priorities of insurance policies, first billed
+// first.
+var PolicyPriorities = []string{PrimaryPolicy, "secondary", "tertiary"}
+
+// PrimaryPolicy is the priority of the policy billed first, of which a
+// patient has at most one in effect on any day.
+const PrimaryPolicy = "primary"
+
+// Overlaps reports whether p and other are both active and in effect on
+// some day.
+func (p *InsurancePolicy) Overlaps(other *InsurancePolicy) bool {
+	if p.DeactivatedAt != nil || other.DeactivatedAt != nil {
+		return false
+	}
+
+	return (other.ExpiryDate == nil || !p.EffectiveDate.After(*other.ExpiryDate)) &&
+		(p.ExpiryDate == nil || !other.EffectiveDate.After(*p.ExpiryDate))
+}
+
+// InsurancePolicyRepository reads and writes the insurance policies of the
+// patients of the clinic the context acts for. Writes are recorded in the
+// audit log of the patient as performed by actor, and fail with a
+// PRIMARY_POLICY_CONFLICT *utils.CodedError when they would leave a patient
+// with two active primary policies in effect on the same day.
+type InsurancePolicyRepository interface {
+	// Add adds p to a patient who is not deleted and sets its ID, CreatedAt
+	// and UpdatedAt, returning ErrNotFound when there is no such patient.
+	Add(ctx context.Context, actor string, p *InsurancePolicy) error
+	// Update replaces the payer, member ID, group number, dates and
+	// priority of the policy p.ID of a patient who is not deleted and
+	// returns it, or ErrNotFound. Deactivated policies cannot be changed.
+	Update(ctx context.Context, actor string, p *InsurancePolicy) (*InsurancePolicy, error)
+	// Deactivate ends a policy and returns it, or ErrNotFound. The policy is
+	// kept, as a record of past coverage.
+	Deactivate(ctx context.Context, actor string, id int) (*InsurancePolicy, error)
+	// ListByPatients returns the policies of many patients grouped by
+	// patient id, the active ones first, then by priority and the most
+	// recent first.
+	ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*InsurancePolicy, error)
+}
+
+// memberIDField is the field the member IDs of policies are encrypted as.
+const memberIDField = "insurance_member_id"
+
+const insurancePolicyColumns = "id, patient_id, payer, member_id, group_number, effective_date, expiry_date, priority, deactivated_at, created_at, updated_at"
+
+// dateParam returns t as the text of a Postgres date, or nil, so the day
+// does not depend on the time zone of the session.
+func dateParam(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+
+	return t.Format(time.DateOnly)
+}
+
+// InsurancePolicyStore is the Postgres InsurancePolicyRepository. Member IDs
+// are encrypted with the cipher like the phones of patients.
+type InsurancePolicyStore struct {
+	db     *sql.DB
+	audit  *audit.AuditLogger
+	cipher *encryption.Cipher
+}
+
+func NewInsurancePolicyStore(db *sql.DB, auditLogger *audit.AuditLogger, cipher *encryption.Cipher) *InsurancePolicyStore {
+	return &InsurancePolicyStore{db: db, audit: auditLogger, cipher: cipher}
+}
+
+func (s *InsurancePolicyStore) scan(row rowScanner) (*InsurancePolicy, error) {
+	p := &InsurancePolicy{}
+
+	err := row.Scan(&p.ID, &p.PatientID, &p.Payer, &p.MemberID, &p.GroupNumber, &p.EffectiveDate, &p.ExpiryDate,
+		&p.Priority, &p.DeactivatedAt, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.MemberID, err = s.cipher.Decrypt(memberIDField, p.MemberID); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (s *InsurancePolicyStore) Add(ctx context.Context, actor string, p *InsurancePolicy) error {
+	memberID, err := s.cipher.Encrypt(memberIDField, p.MemberID)
+	if err != nil {
+		return err
+	}
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		patient, err := lockPatient(ctx, tx, p.PatientID, false, s.cipher)
+		if err != nil {
+			return err
+		}
+
+		if err := checkPrimaryPolicy(ctx, tx, p); err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `insert into insurance_policies(patient_id, clinic_id, payer, member_id, group_number, effective_date, expiry_date, priority)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id, created_at, updated_at`,
+			p.PatientID, patient.ClinicID, p.Payer, memberID, p.GroupNumber, dateParam(&p.EffectiveDate), dateParam(p.ExpiryDate), p.Priority).
+			Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		return s.audit.Log(ctx, tx, "add_insurance_policy", p.PatientID, actor, nil, p)
+	})
+
+	return notFound(err)
+}
+
+func (s *InsurancePolicyStore) Update(ctx context.Context, actor string, p *InsurancePolicy) (*InsurancePolicy, error) {
+	memberID, err := s.cipher.Encrypt(memberIDField, p.MemberID)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated *InsurancePolicy
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		before, err := s.lock(ctx, tx, p.ID)
+		if err != nil {
+			return err
+		}
+		if before.DeactivatedAt != nil {
+			return &utils.CodedError{Code: "BAD_USER_INPUT", Message: fmt.Sprintf("insurance policy %d is deactivated and cannot be changed", p.ID)}
+		}
+
+		p.PatientID = before.PatientID
+		if err := checkPrimaryPolicy(ctx, tx, p); err != nil {
+			return err
+		}
+
+		updated, err = s.scan(tx.QueryRowContext(ctx, `update insurance_policies
+			set payer = $2, member_id = $3, group_number = $4, effective_date = $5, expiry_date = $6, priority = $7, updated_at = now()
+			where id = $1 returning `+insurancePolicyColumns,
+			p.ID, p.Payer, memberID, p.GroupNumber, dateParam(&p.EffectiveDate), dateParam(p.ExpiryDate), p.Priority))
+		if err != nil {
+			return err
+		}
+
+		return s.audit.Log(ctx, tx, "update_insurance_policy", updated.PatientID, actor, before, updated)
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return updated, nil
+}
+
+func (s *InsurancePolicyStore) Deactivate(ctx context.Context, actor string, id int) (*InsurancePolicy, error) {
+	var deactivated *InsurancePolicy
+	err := audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		before, err := s.lock(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if before.DeactivatedAt != nil {
+			return &utils.CodedError{Code: "BAD_USER_INPUT", Message: fmt.Sprintf("insurance policy %d is already deactivated", id)}
+		}
+
+		deactivated, err = s.scan(tx.QueryRowContext(ctx,
+			"update insurance_policies set deactivated_at = now(), updated_at = now() where id = $1 returning "+insurancePolicyColumns, id))
+		if err != nil {
+			return err
+		}
+
+		return s.audit.Log(ctx, tx, "deactivate_insurance_policy", deactivated.PatientID, actor, before, deactivated)
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return deactivated, nil
+}
+
+// lock locks the policy id of a patient of the clinic of ctx who is not
+// deleted within tx, the patient first so the checks of policies of the
+// same patient run one at a time, and returns the policy. sql.ErrNoRows is
+// returned when there is no such policy.
+func (s *InsurancePolicyStore) lock(ctx context.Context, tx *sql.Tx, id int) (*InsurancePolicy, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var patientID int
+	err = tx.QueryRowContext(ctx, "select patient_id from insurance_policies where id = $1 and "+inClinic("clinic_id", 2), id, clinic).
+		Scan(&patientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := lockPatient(ctx, tx, patientID, false, s.cipher); err != nil {
+		return nil, err
+	}
+
+	return s.scan(tx.QueryRowContext(ctx,
+		"select "+insurancePolicyColumns+" from insurance_policies where id = $1 and patient_id = $2 for update", id, patientID))
+}
+
+// checkPrimaryPolicy returns a PRIMARY_POLICY_CONFLICT error when p is a
+// primary policy in effect on a day another active primary policy of its
+// patient is. The patient must be locked by tx, so two writes cannot both
+// pass the check.
+func checkPrimaryPolicy(ctx context.Context, tx *sql.Tx, p *InsurancePolicy) error {
+	if p.Priority != PrimaryPolicy {
+		return nil
+	}
+
+	var conflictID int
+	err := tx.QueryRowContext(ctx, `select id from insurance_policies
+		where patient_id = $1 and id <> $2 and priority = 'primary' and deactivated_at is null
+			and effective_date <= coalesce($4::date, 'infinity') and coalesce(expiry_date, 'infinity') >= $3::date
+		order by effective_date limit 1`, p.PatientID, p.ID, dateParam(&p.EffectiveDate), dateParam(p.ExpiryDate)).Scan(&conflictID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return PrimaryPolicyConflict(p.PatientID, conflictID)
+}
+
+// PrimaryPolicyConflict is the error of a write giving the patient a second
+// active primary policy in effect at the time of the policy conflictID.
+func PrimaryPolicyConflict(patientID, conflictID int) error {
+	return &utils.CodedError{
+		Code:    "PRIMARY_POLICY_CONFLICT",
+		Message: fmt.Sprintf("patient %d already has primary insurance policy %d in effect at that time, deactivate it or change its dates first", patientID, conflictID),
+	}
+}
+
+func (s *InsurancePolicyStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*InsurancePolicy, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx, "select "+insurancePolicyColumns+` from insurance_policies
+		where patient_id = any($1) and `+inClinic("clinic_id", 2)+`
+		order by deactivated_at is not null, array_position($3::text[], priority), effective_date desc, id desc`,
+		pq.Array(patientIDs), clinic, pq.Array(PolicyPriorities))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byPatient := make(map[int][]*InsurancePolicy, len(patientIDs))
+	for rows.Next() {
+		p, err := s.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		byPatient[p.PatientID] = append(byPatient[p.PatientID], p)
+	}
+
+	return byPatient, rows.Err()
+}
+
+// Reencrypt encrypts the member ID of every policy with the current key like
+// PatientStore.Reencrypt, returning how many policies were updated.
+func (s *InsurancePolicyStore) Reencrypt(ctx context.Context) (int, error) {
+	updated, lastID := 0, 0
+
+	for {
+		var n, read int
+
+		err := audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+			var err error
+			n, read, lastID, err = s.reencryptBatch(ctx, tx, lastID)
+			return err
+		})
+		if err != nil {
+			return updated, err
+		}
+
+		updated += n
+		if read < reencryptBatchSize {
+			return updated, nil
+		}
+	}
+}
+
+func (s *InsurancePolicyStore) reencryptBatch(ctx context.Context, tx *sql.Tx, afterID int) (updated, read, lastID int, err error) {
+	rows, err := tx.QueryContext(ctx, "select id, member_id from insurance_policies where id > $1 order by id limit $2 for update",
+		afterID, reencryptBatchSize)
+	if err != nil {
+		return 0, 0, afterID, err
+	}
+
+	type row struct {
+		id       int
+		memberID string
+	}
+
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.memberID); err != nil {
+			rows.Close()
+			return 0, 0, afterID, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, afterID, err
+	}
+
+	lastID = afterID
+	for _, r := range batch {
+		lastID = r.id
+		if s.cipher.Current(r.memberID) {
+			continue
+		}
+
+		memberID, err := s.cipher.Decrypt(memberIDField, r.memberID)
+		if err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		if memberID, err = s.cipher.Encrypt(memberIDField, memberID); err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		if _, err := tx.ExecContext(ctx, "update insurance_policies set member_id = $1 where id = $2", memberID, r.id); err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		updated++
+	}
+
+	return updated, len(batch), lastID, nil
+}
@@ -0,0 +1,288 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// AppointmentStore is the memory store.AppointmentRepository.
+type AppointmentStore struct {
+	db *DB
+}
+
+func NewAppointmentStore(db *DB) *AppointmentStore {
+	return &AppointmentStore{db: db}
+}
+
+// withPatient returns a copy of a with its patient.
+func (d *data) withPatient(a appointment) *store.Appointment {
+	result := a.Appointment
+	patient := d.patients[a.PatientID]
+	result.Patient = &patient
+
+	return &result
+}
+
+func (s *AppointmentStore) Get(ctx context.Context, id int) (*store.Appointment, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *store.Appointment
+	err = s.db.read(ctx, func(d *data) error {
+		a, ok := d.appointments[id]
+		if !ok || !inScope(a.clinicID) {
+			return store.ErrNotFound
+		}
+
+		result = d.withPatient(a)
+		return nil
+	})
+
+	return result, err
+}
+
+func (s *AppointmentStore) ListByPatient(ctx context.Context, patientID int) ([]*store.Appointment, error) {
+	return s.list(ctx, func(a appointment) bool { return a.PatientID == patientID })
+}
+
+func (s *AppointmentStore) ListBetween(ctx context.Context, from, to time.Time, providerID *int) ([]*store.Appointment, error) {
+	return s.list(ctx, func(a appointment) bool {
+		return a.ScheduledAt.Before(to) && a.EndsAt.After(from) &&
+			(providerID == nil || (a.ProviderID != nil && *a.ProviderID == *providerID))
+	})
+}
+
+func (s *AppointmentStore) ListScheduled(ctx context.Context, providerID int, from, to time.Time) ([]*store.Appointment, error) {
+	appointments, err := s.list(ctx, func(a appointment) bool {
+		return a.ProviderID != nil && *a.ProviderID == providerID && a.Status == "scheduled" &&
+			!a.ScheduledAt.Before(from) && a.ScheduledAt.Before(to)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scheduled := []*store.Appointment{}
+	for _, a := range appointments {
+		if a.Patient.DeletedAt == nil {
+			scheduled = append(scheduled, a)
+		}
+	}
+
+	return scheduled, nil
+}
+
+// list returns the appointments of the clinic scope matching keep with
+// their patients, soonest first.
+func (s *AppointmentStore) list(ctx context.Context, keep func(appointment) bool) ([]*store.Appointment, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	appointments := []*store.Appointment{}
+	err = s.db.read(ctx, func(d *data) error {
+		for _, a := range d.appointments {
+			if inScope(a.clinicID) && keep(a) {
+				appointments = append(appointments, d.withPatient(a))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortAppointments(appointments)
+	return appointments, nil
+}
+
+// sortAppointments sorts appointments soonest first, breaking ties by id.
+func sortAppointments(appointments []*store.Appointment) {
+	sort.Slice(appointments, func(i, j int) bool {
+		a, b := appointments[i], appointments[j]
+		if !a.ScheduledAt.Equal(b.ScheduledAt) {
+			return a.ScheduledAt.Before(b.ScheduledAt)
+		}
+		return a.ID < b.ID
+	})
+}
+
+func (s *AppointmentStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*store.Appointment, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := idSet(patientIDs)
+	byPatient := make(map[int][]*store.Appointment, len(patientIDs))
+
+	err = s.db.read(ctx, func(d *data) error {
+		for _, a := range d.appointments {
+			if wanted[a.PatientID] && inScope(a.clinicID) {
+				a := a.Appointment
+				byPatient[a.PatientID] = append(byPatient[a.PatientID], &a)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, appointments := range byPatient {
+		sortAppointments(appointments)
+	}
+
+	return byPatient, nil
+}
+
+func (s *AppointmentStore) Book(ctx context.Context, a *store.Appointment) (int, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = s.db.write(ctx, func(d *data) error {
+		if a.ProviderID != nil {
+			if err := d.checkProviderAvailable(inScope, *a.ProviderID, a.ScheduledAt, a.EndsAt, 0); err != nil {
+				return err
+			}
+		}
+
+		patient, ok := d.patients[a.PatientID]
+		if !ok || patient.DeletedAt != nil || !inScope(patient.ClinicID) {
+			return store.ErrNotFound
+		}
+
+		id = d.nextID("appointments")
+		d.appointments[id] = appointment{
+			Appointment: store.Appointment{
+				ID:          id,
+				PatientID:   a.PatientID,
+				ProviderID:  a.ProviderID,
+				ScheduledAt: a.ScheduledAt,
+				EndsAt:      a.EndsAt,
+				Reason:      a.Reason,
+				Notes:       a.Notes,
+				Status:      "scheduled",
+			},
+			clinicID: patient.ClinicID,
+		}
+
+		return nil
+	})
+
+	return id, err
+}
+
+func (s *AppointmentStore) Reschedule(ctx context.Context, id int, start time.Time, end *time.Time) error {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.db.write(ctx, func(d *data) error {
+		current, ok := d.appointments[id]
+		if !ok || !inScope(current.clinicID) {
+			return store.ErrNotFound
+		}
+
+		if current.Status != "scheduled" {
+			return &utils.CodedError{
+				Code:    "BAD_USER_INPUT",
+				Message: fmt.Sprintf("appointment %d is %s and cannot be rescheduled", id, current.Status),
+			}
+		}
+
+		newEnd := start.Add(current.EndsAt.Sub(current.ScheduledAt))
+		if end != nil {
+			newEnd = *end
+		}
+		if !newEnd.After(start) {
+			return &utils.CodedError{Code: "BAD_USER_INPUT", Message: "endsAt must be after scheduledAt"}
+		}
+
+		if current.ProviderID != nil {
+			if err := d.checkProviderAvailable(inScope, *current.ProviderID, start, newEnd, id); err != nil {
+				return err
+			}
+		}
+
+		current.ScheduledAt, current.EndsAt = start, newEnd
+		d.appointments[id] = current
+		return nil
+	})
+}
+
+func (s *AppointmentStore) SetStatus(ctx context.Context, id int, status string) (*store.Appointment, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *store.Appointment
+	err = s.db.write(ctx, func(d *data) error {
+		a, ok := d.appointments[id]
+		if !ok || !inScope(a.clinicID) {
+			return store.ErrNotFound
+		}
+
+		a.Status = status
+		d.appointments[id] = a
+		result = d.withPatient(a)
+		return nil
+	})
+
+	return result, err
+}
+
+// checkProviderAvailable returns an APPOINTMENT_CONFLICT error when the
+// provider has an appointment that is not cancelled overlapping [start, end),
+// and a NOT_FOUND error when the provider is not one of the clinic scope.
+// The appointment with id excludeID is ignored.
+func (d *data) checkProviderAvailable(inScope func(int) bool, providerID int, start, end time.Time, excludeID int) error {
+	if p, ok := d.providers[providerID]; !ok || !inScope(p.clinicID) {
+		return utils.NotFound("provider %d not found", providerID)
+	}
+
+	var conflict *appointment
+	for _, a := range d.appointments {
+		if a.ProviderID == nil || *a.ProviderID != providerID || a.ID == excludeID || a.Status == "cancelled" {
+			continue
+		}
+
+		if a.ScheduledAt.Before(end) && a.EndsAt.After(start) && (conflict == nil || a.ScheduledAt.Before(conflict.ScheduledAt)) {
+			a := a
+			conflict = &a
+		}
+	}
+
+	if conflict == nil {
+		return nil
+	}
+
+	return &utils.CodedError{
+		Code:    "APPOINTMENT_CONFLICT",
+		Message: fmt.Sprintf("provider %d already has appointment %d at that time", providerID, conflict.ID),
+	}
+}
+
+// idSet returns ids as a set.
+func idSet(ids []int) map[int]bool {
+	set := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	return set
+}
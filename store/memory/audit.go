@@ -0,0 +1,166 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/middleware"
+	"github.com/codixir/smart-emerge-starter/tenant"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// AuditLogger is the memory audit log, read like audit.AuditLogger. The
+// repositories of the same DB write to it.
+type AuditLogger struct {
+	db *DB
+}
+
+func NewAuditLogger(db *DB) *AuditLogger {
+	return &AuditLogger{db: db}
+}
+
+// log records operation on patientID in d, like audit.AuditLogger.Log, and
+// the change of a patient operation in the change feed. The entry belongs
+// to the clinic ctx acts for.
+func (d *data) log(ctx context.Context, operation string, patientID int, performedBy string, oldValue, newValue interface{}) error {
+	clinicID, ok := tenant.ClinicFromContext(ctx)
+	if !ok {
+		return utils.ErrNoClinic
+	}
+
+	oldJSON, err := encode(oldValue)
+	if err != nil {
+		return err
+	}
+
+	newJSON, err := encode(newValue)
+	if err != nil {
+		return err
+	}
+
+	d.audit = append(d.audit, audit.Entry{
+		ID:          d.nextID("audit_logs"),
+		Operation:   operation,
+		PatientID:   patientID,
+		PerformedBy: performedBy,
+		ClientIP:    clientIP(ctx),
+		ClinicID:    clinicID,
+		OccurredAt:  now(),
+		OldValue:    oldJSON,
+		NewValue:    newJSON,
+	})
+
+	if changeType, ok := changeTypes[operation]; ok {
+		return d.recordChange(ctx, changeType, patientID)
+	}
+	return nil
+}
+
+// LogAccess records a read of each patient in patientIDs that exists, in
+// the clinic of the patient.
+func (l *AuditLogger) LogAccess(ctx context.Context, operation, performedBy string, patientIDs []int) error {
+	return l.db.write(ctx, func(d *data) error {
+		for _, id := range patientIDs {
+			patient, ok := d.patients[id]
+			if !ok {
+				continue
+			}
+
+			d.audit = append(d.audit, audit.Entry{
+				ID:          d.nextID("audit_logs"),
+				Operation:   operation,
+				PatientID:   id,
+				PerformedBy: performedBy,
+				ClientIP:    clientIP(ctx),
+				ClinicID:    patient.ClinicID,
+				OccurredAt:  now(),
+			})
+		}
+
+		return nil
+	})
+}
+
+// Entries returns a page of the audit entries for patientID, newest first.
+func (l *AuditLogger) Entries(ctx context.Context, patientID, limit, offset int) ([]*audit.Entry, error) {
+	entries, _, err := l.Search(ctx, audit.Filter{PatientID: &patientID}, limit, offset)
+	return entries, err
+}
+
+// Search returns a page of the entries matching filter, newest first, and
+// the number of matching entries across all pages.
+func (l *AuditLogger) Search(ctx context.Context, filter audit.Filter, limit, offset int) ([]*audit.Entry, int, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var matched []*audit.Entry
+	err = l.db.read(ctx, func(d *data) error {
+		for _, entry := range d.audit {
+			if inScope(entry.ClinicID) && matchesFilter(entry, filter) {
+				entry := entry
+				matched = append(matched, &entry)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].OccurredAt.Equal(matched[j].OccurredAt) {
+			return matched[i].OccurredAt.After(matched[j].OccurredAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+
+	start, end := page(len(matched), limit, offset)
+	return append([]*audit.Entry{}, matched[start:end]...), len(matched), nil
+}
+
+// matchesFilter reports whether entry matches every set field of filter.
+func matchesFilter(entry audit.Entry, filter audit.Filter) bool {
+	switch {
+	case filter.PatientID != nil && entry.PatientID != *filter.PatientID:
+		return false
+	case filter.PerformedBy != "" && entry.PerformedBy != filter.PerformedBy:
+		return false
+	case filter.Operation != "" && entry.Operation != filter.Operation:
+		return false
+	case filter.From != nil && entry.OccurredAt.Before(*filter.From):
+		return false
+	case filter.To != nil && !entry.OccurredAt.Before(*filter.To):
+		return false
+	}
+
+	return true
+}
+
+// clientIP returns the client IP stored in ctx by the HTTP middleware, or
+// nil outside of HTTP requests.
+func clientIP(ctx context.Context) *string {
+	if ip, ok := middleware.ClientIPFromContext(ctx); ok {
+		return &ip
+	}
+
+	return nil
+}
+
+func encode(value interface{}) (*string, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := string(b)
+	return &encoded, nil
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/codixir/smart-emerge-starter/outbox"
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// changeTypes are the change types of the audited patient operations, as
+// the Postgres store records them.
+var changeTypes = map[string]string{
+	"create":  outbox.PatientCreated,
+	"update":  outbox.PatientUpdated,
+	"restore": outbox.PatientUpdated,
+	"delete":  outbox.PatientDeleted,
+	"merge":   outbox.PatientDeleted,
+	"erase":   outbox.PatientErased,
+	"purge":   store.PatientPurged,
+}
+
+// recordChange adds the change of changeType to patientID to the feed, in
+// the clinic ctx acts for. Writes hold the lock of the DB, so changes are
+// added in the order they are made, and the cursor of a change is its id.
+func (d *data) recordChange(ctx context.Context, changeType string, patientID int) error {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	d.changes = append(d.changes, store.PatientChangeEvent{
+		Cursor:    store.ChangeCursor{ID: int64(d.nextID("patient_changes"))},
+		Type:      changeType,
+		PatientID: patientID,
+		ClinicID:  clinicID,
+		ChangedAt: now(),
+	})
+
+	return nil
+}
+
+// Changes returns up to limit changes after since, with the patients as
+// they are now.
+func (s *PatientStore) Changes(ctx context.Context, since store.ChangeCursor, limit int) ([]*store.PatientChangeEvent, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []*store.PatientChangeEvent{}
+	err = s.db.read(ctx, func(d *data) error {
+		for _, c := range d.changes {
+			if len(changes) == limit {
+				break
+			}
+
+			after := c.Cursor.Tx > since.Tx || c.Cursor.Tx == since.Tx && c.Cursor.ID > since.ID
+			if !after || !inScope(c.ClinicID) {
+				continue
+			}
+
+			c := c
+			if patient, ok := d.patients[c.PatientID]; ok {
+				c.Patient = &patient
+			}
+			changes = append(changes, &c)
+		}
+
+		return nil
+	})
+
+	return changes, err
+}
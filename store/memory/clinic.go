@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// ClinicStore is the memory store.ClinicRepository.
+type ClinicStore struct {
+	db *DB
+}
+
+func NewClinicStore(db *DB) *ClinicStore {
+	return &ClinicStore{db: db}
+}
+
+func (s *ClinicStore) List(ctx context.Context) ([]*store.Clinic, error) {
+	clinics := []*store.Clinic{}
+
+	err := s.db.read(ctx, func(d *data) error {
+		for _, clinic := range d.clinics {
+			clinic := clinic
+			clinics = append(clinics, &clinic)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(clinics, func(i, j int) bool { return clinics[i].ID < clinics[j].ID })
+	return clinics, nil
+}
+
+func (s *ClinicStore) Create(ctx context.Context, clinic *store.Clinic) error {
+	return s.db.write(ctx, func(d *data) error {
+		clinic.ID = d.nextID("clinics")
+		clinic.CreatedAt = now()
+		d.clinics[clinic.ID] = *clinic
+		return nil
+	})
+}
@@ -0,0 +1,110 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// ConsentStore is the memory store.ConsentRepository.
+type ConsentStore struct {
+	db *DB
+}
+
+func NewConsentStore(db *DB) *ConsentStore {
+	return &ConsentStore{db: db}
+}
+
+func (s *ConsentStore) Grant(ctx context.Context, actor string, c *store.Consent) error {
+	return s.db.write(ctx, func(d *data) error {
+		patient, err := d.lockPatient(ctx, c.PatientID, false)
+		if err != nil {
+			return err
+		}
+
+		for _, granted := range d.consents {
+			if granted.PatientID == c.PatientID && granted.Type == c.Type && granted.Scope == c.Scope && granted.RevokedAt == nil {
+				return store.ConsentAlreadyGranted(c, granted.ID)
+			}
+		}
+
+		c.ID = d.nextID("consents")
+		c.RecordedBy = actor
+		c.CreatedAt = now()
+		d.consents[c.ID] = consent{Consent: *c, clinicID: patient.ClinicID}
+
+		return d.log(ctx, "grant_consent", c.PatientID, actor, nil, c)
+	})
+}
+
+func (s *ConsentStore) Revoke(ctx context.Context, actor string, id int) (*store.Consent, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked store.Consent
+	err = s.db.write(ctx, func(d *data) error {
+		before, ok := d.consents[id]
+		if !ok || !inScope(before.clinicID) {
+			return store.ErrNotFound
+		}
+		if before.RevokedAt != nil {
+			return &utils.CodedError{Code: "BAD_USER_INPUT", Message: fmt.Sprintf("consent %d is already revoked", id)}
+		}
+
+		revoked = before.Consent
+		revokedAt := now()
+		revoked.RevokedAt, revoked.RevokedBy = &revokedAt, &actor
+		d.consents[id] = consent{Consent: revoked, clinicID: before.clinicID}
+
+		return d.log(ctx, "revoke_consent", revoked.PatientID, actor, before.Consent, revoked)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &revoked, nil
+}
+
+func (s *ConsentStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*store.Consent, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := idSet(patientIDs)
+	byPatient := make(map[int][]*store.Consent, len(patientIDs))
+
+	err = s.db.read(ctx, func(d *data) error {
+		for _, c := range d.consents {
+			if wanted[c.PatientID] && inScope(c.clinicID) {
+				c := c.Consent
+				byPatient[c.PatientID] = append(byPatient[c.PatientID], &c)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, consents := range byPatient {
+		sort.Slice(consents, func(i, j int) bool {
+			a, b := consents[i], consents[j]
+			switch {
+			case (a.RevokedAt == nil) != (b.RevokedAt == nil):
+				return a.RevokedAt == nil
+			case !a.GrantedAt.Equal(b.GrantedAt):
+				return a.GrantedAt.After(b.GrantedAt)
+			}
+			return a.ID > b.ID
+		})
+	}
+
+	return byPatient, nil
+}
@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// EmergencyContactStore is the memory store.EmergencyContactRepository.
+type EmergencyContactStore struct {
+	db *DB
+}
+
+func NewEmergencyContactStore(db *DB) *EmergencyContactStore {
+	return &EmergencyContactStore{db: db}
+}
+
+func (s *EmergencyContactStore) Add(ctx context.Context, actor string, c *store.EmergencyContact) error {
+	return s.db.write(ctx, func(d *data) error {
+		patient, err := d.lockPatient(ctx, c.PatientID, false)
+		if err != nil {
+			return err
+		}
+
+		c.ID = d.nextID("emergency_contacts")
+		c.CreatedAt = now()
+		d.contacts[c.ID] = contact{EmergencyContact: *c, clinicID: patient.ClinicID}
+
+		return d.log(ctx, "add_emergency_contact", c.PatientID, actor, nil, c)
+	})
+}
+
+func (s *EmergencyContactStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*store.EmergencyContact, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := idSet(patientIDs)
+	byPatient := make(map[int][]*store.EmergencyContact, len(patientIDs))
+
+	err = s.db.read(ctx, func(d *data) error {
+		for _, c := range d.contacts {
+			if wanted[c.PatientID] && inScope(c.clinicID) {
+				c := c.EmergencyContact
+				byPatient[c.PatientID] = append(byPatient[c.PatientID], &c)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, contacts := range byPatient {
+		sort.Slice(contacts, func(i, j int) bool {
+			if !contacts[i].CreatedAt.Equal(contacts[j].CreatedAt) {
+				return contacts[i].CreatedAt.Before(contacts[j].CreatedAt)
+			}
+			return contacts[i].ID < contacts[j].ID
+		})
+	}
+
+	return byPatient, nil
+}
@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// DocumentStore is the memory store.DocumentRepository. Documents need
+// Postgres, so there are never any and none can be attached.
+type DocumentStore struct {
+	db *DB
+}
+
+func NewDocumentStore(db *DB) *DocumentStore {
+	return &DocumentStore{db: db}
+}
+
+func (s *DocumentStore) Create(ctx context.Context, actor string, d *store.Document) error {
+	if _, err := clinicOf(ctx); err != nil {
+		return err
+	}
+
+	return store.ErrNotFound
+}
+
+func (s *DocumentStore) Get(ctx context.Context, id int) (*store.Document, error) {
+	if _, err := scope(ctx); err != nil {
+		return nil, err
+	}
+
+	return nil, store.ErrNotFound
+}
+
+func (s *DocumentStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*store.Document, error) {
+	if _, err := scope(ctx); err != nil {
+		return nil, err
+	}
+
+	return map[int][]*store.Document{}, nil
+}
+
+func (s *DocumentStore) Delete(ctx context.Context, actor string, id int) (*store.Document, error) {
+	if _, err := clinicOf(ctx); err != nil {
+		return nil, err
+	}
+
+	return nil, store.ErrNotFound
+}
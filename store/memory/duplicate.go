@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// DuplicateReportStore is the memory store.DuplicateReportRepository.
+type DuplicateReportStore struct {
+	db *DB
+}
+
+func NewDuplicateReportStore(db *DB) *DuplicateReportStore {
+	return &DuplicateReportStore{db: db}
+}
+
+func (s *DuplicateReportStore) ListPending(ctx context.Context) ([]*store.DuplicateReport, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []*store.DuplicateReport{}
+	err = s.db.read(ctx, func(d *data) error {
+		for _, r := range d.reports {
+			if r.Status == "pending" && inScope(r.clinicID) {
+				r := r.DuplicateReport
+				reports = append(reports, &r)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	return reports, nil
+}
+
+// Create fails for patients of another clinic, as the foreign keys of the
+// Postgres store do.
+func (s *DuplicateReportStore) Create(ctx context.Context, r *store.DuplicateReport) error {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.db.write(ctx, func(d *data) error {
+		for _, patientID := range []int{r.ReportedPatientID, r.SuspectedDuplicateID} {
+			if patient, ok := d.patients[patientID]; !ok || patient.ClinicID != clinicID {
+				return fmt.Errorf("patient %d is not a patient of clinic %d", patientID, clinicID)
+			}
+		}
+
+		r.ID = d.nextID("duplicate_reports")
+		d.reports[r.ID] = report{DuplicateReport: *r, clinicID: clinicID}
+		return nil
+	})
+}
+
+func (s *DuplicateReportStore) SetStatus(ctx context.Context, id int, status string) (*store.DuplicateReport, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result store.DuplicateReport
+	err = s.db.write(ctx, func(d *data) error {
+		r, ok := d.reports[id]
+		if !ok || !inScope(r.clinicID) {
+			return store.ErrNotFound
+		}
+
+		r.Status = status
+		d.reports[id] = r
+		result = r.DuplicateReport
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
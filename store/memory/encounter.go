@@ -0,0 +1,212 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// EncounterStore is the memory store.EncounterRepository.
+type EncounterStore struct {
+	db *DB
+}
+
+func NewEncounterStore(db *DB) *EncounterStore {
+	return &EncounterStore{db: db}
+}
+
+// withRevision returns e with its latest revision.
+func (d *data) withRevision(e encounter) *store.Encounter {
+	revisions := d.revisions[e.ID]
+
+	return &store.Encounter{
+		ID:                e.ID,
+		PatientID:         e.PatientID,
+		ProviderID:        e.ProviderID,
+		EncounteredAt:     e.EncounteredAt,
+		CreatedBy:         e.CreatedBy,
+		CreatedAt:         e.CreatedAt,
+		EncounterRevision: revisions[len(revisions)-1],
+	}
+}
+
+func (s *EncounterStore) Get(ctx context.Context, id int) (*store.Encounter, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *store.Encounter
+	err = s.db.read(ctx, func(d *data) error {
+		e, ok := d.encounters[id]
+		if !ok || !inScope(e.clinicID) {
+			return store.ErrNotFound
+		}
+
+		result = d.withRevision(e)
+		return nil
+	})
+
+	return result, err
+}
+
+func (s *EncounterStore) ListByPatients(ctx context.Context, patientIDs []int, limit, offset int) (map[int]*store.EncounterPage, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := idSet(patientIDs)
+	all := make(map[int][]*store.Encounter, len(patientIDs))
+
+	err = s.db.read(ctx, func(d *data) error {
+		for _, e := range d.encounters {
+			if wanted[e.PatientID] && inScope(e.clinicID) {
+				all[e.PatientID] = append(all[e.PatientID], d.withRevision(e))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byPatient := make(map[int]*store.EncounterPage, len(all))
+	for patientID, encounters := range all {
+		sort.Slice(encounters, func(i, j int) bool {
+			a, b := encounters[i], encounters[j]
+			if !a.EncounteredAt.Equal(b.EncounteredAt) {
+				return a.EncounteredAt.After(b.EncounteredAt)
+			}
+			return a.ID > b.ID
+		})
+
+		start, end := page(len(encounters), limit, offset)
+		if start < end {
+			byPatient[patientID] = &store.EncounterPage{Encounters: encounters[start:end], TotalCount: len(encounters)}
+		}
+	}
+
+	return byPatient, nil
+}
+
+func (s *EncounterStore) Revisions(ctx context.Context, ids []int) (map[int][]*store.EncounterRevision, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byEncounter := make(map[int][]*store.EncounterRevision, len(ids))
+	err = s.db.read(ctx, func(d *data) error {
+		for _, id := range ids {
+			e, ok := d.encounters[id]
+			if !ok || !inScope(e.clinicID) {
+				continue
+			}
+
+			for _, revision := range d.revisions[id] {
+				revision := revision
+				byEncounter[id] = append(byEncounter[id], &revision)
+			}
+		}
+
+		return nil
+	})
+
+	return byEncounter, err
+}
+
+func (s *EncounterStore) Create(ctx context.Context, actor string, e *store.Encounter) error {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.db.write(ctx, func(d *data) error {
+		if e.ProviderID != nil {
+			if p, ok := d.providers[*e.ProviderID]; !ok || !inScope(p.clinicID) {
+				return utils.NotFound("provider %d not found", *e.ProviderID)
+			}
+		}
+
+		patient, err := d.lockPatient(ctx, e.PatientID, false)
+		if err != nil {
+			return err
+		}
+
+		e.ID = d.nextID("encounters")
+		e.CreatedBy = actor
+		e.CreatedAt = now()
+		d.encounters[e.ID] = encounter{
+			ID:            e.ID,
+			PatientID:     e.PatientID,
+			ProviderID:    e.ProviderID,
+			EncounteredAt: e.EncounteredAt,
+			CreatedBy:     e.CreatedBy,
+			CreatedAt:     e.CreatedAt,
+			clinicID:      patient.ClinicID,
+		}
+
+		e.Revision = 1
+		e.Reason = nil
+		d.insertRevision(actor, e)
+
+		return d.log(ctx, "create_encounter", e.PatientID, actor, nil, e)
+	})
+}
+
+func (s *EncounterStore) Amend(ctx context.Context, actor string, id int, amendment store.EncounterAmendment) (*store.Encounter, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var amended *store.Encounter
+	err = s.db.write(ctx, func(d *data) error {
+		e, ok := d.encounters[id]
+		if !ok || !inScope(e.clinicID) {
+			return store.ErrNotFound
+		}
+
+		before := d.withRevision(e)
+		after := *before
+		after.Revision = before.Revision + 1
+		after.Reason = &amendment.Reason
+		if amendment.ChiefComplaint != nil {
+			after.ChiefComplaint = *amendment.ChiefComplaint
+		}
+		if amendment.Notes != nil {
+			after.Notes = *amendment.Notes
+		}
+		if amendment.DiagnosisCodes != nil {
+			after.DiagnosisCodes = *amendment.DiagnosisCodes
+		}
+
+		d.insertRevision(actor, &after)
+
+		amended = &after
+		return d.log(ctx, "amend_encounter", after.PatientID, actor, before, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return amended, nil
+}
+
+// insertRevision records the revision of e and sets its recorded fields.
+func (d *data) insertRevision(actor string, e *store.Encounter) {
+	if e.DiagnosisCodes == nil {
+		e.DiagnosisCodes = []string{}
+	}
+
+	e.RecordedBy = actor
+	e.RecordedAt = now()
+
+	revision := e.EncounterRevision
+	revision.DiagnosisCodes = append([]string{}, e.DiagnosisCodes...)
+	d.revisions[e.ID] = append(d.revisions[e.ID], revision)
+}
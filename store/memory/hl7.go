@@ -0,0 +1,36 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// HL7DeadLetterStore is the memory store.HL7DeadLetterRepository.
+type HL7DeadLetterStore struct {
+	db *DB
+}
+
+func NewHL7DeadLetterStore(db *DB) *HL7DeadLetterStore {
+	return &HL7DeadLetterStore{db: db}
+}
+
+func (s *HL7DeadLetterStore) Add(ctx context.Context, actor string, l *store.HL7DeadLetter) error {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.db.write(ctx, func(d *data) error {
+		if err := d.checkClinic(clinicID); err != nil {
+			return err
+		}
+
+		l.ID = int64(d.nextID("hl7_dead_letters"))
+		l.ReceivedBy = actor
+		l.ReceivedAt = now()
+
+		d.deadLetters = append(d.deadLetters, deadLetter{HL7DeadLetter: *l, clinicID: clinicID})
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// IdempotencyKeyStore is the memory store.IdempotencyKeyRepository.
+type IdempotencyKeyStore struct {
+	db *DB
+}
+
+func NewIdempotencyKeyStore(db *DB) *IdempotencyKeyStore {
+	return &IdempotencyKeyStore{db: db}
+}
+
+// Claim also deletes every expired key.
+func (s *IdempotencyKeyStore) Claim(ctx context.Context, actor, key, operation, requestHash string, expiresAt time.Time) (*store.IdempotencyKey, error) {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored *store.IdempotencyKey
+	err = s.db.write(ctx, func(d *data) error {
+		if err := d.checkClinic(clinicID); err != nil {
+			return err
+		}
+
+		at := now()
+		for id, k := range d.idempotencyKeys {
+			if !k.ExpiresAt.After(at) {
+				delete(d.idempotencyKeys, id)
+			}
+		}
+
+		id := idempotencyKeyID{clinicID: clinicID, userID: actor, key: key}
+		if k, ok := d.idempotencyKeys[id]; ok {
+			stored = &k
+			return nil
+		}
+
+		d.idempotencyKeys[id] = store.IdempotencyKey{
+			Key:         key,
+			Operation:   operation,
+			RequestHash: requestHash,
+			CreatedAt:   at,
+			ExpiresAt:   expiresAt,
+		}
+		return nil
+	})
+
+	return stored, err
+}
+
+func (s *IdempotencyKeyStore) Complete(ctx context.Context, actor, key string, response []byte) error {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.db.write(ctx, func(d *data) error {
+		id := idempotencyKeyID{clinicID: clinicID, userID: actor, key: key}
+		k, ok := d.idempotencyKeys[id]
+		if !ok {
+			return store.ErrNotFound
+		}
+
+		k.Response = append([]byte(nil), response...)
+		d.idempotencyKeys[id] = k
+		return nil
+	})
+}
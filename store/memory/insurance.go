@@ -0,0 +1,189 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// InsurancePolicyStore is the memory store.InsurancePolicyRepository.
+type InsurancePolicyStore struct {
+	db *DB
+}
+
+func NewInsurancePolicyStore(db *DB) *InsurancePolicyStore {
+	return &InsurancePolicyStore{db: db}
+}
+
+func (s *InsurancePolicyStore) Add(ctx context.Context, actor string, p *store.InsurancePolicy) error {
+	return s.db.write(ctx, func(d *data) error {
+		patient, err := d.lockPatient(ctx, p.PatientID, false)
+		if err != nil {
+			return err
+		}
+
+		if err := d.checkPrimaryPolicy(p); err != nil {
+			return err
+		}
+
+		p.ID = d.nextID("insurance_policies")
+		p.CreatedAt = now()
+		p.UpdatedAt = p.CreatedAt
+		d.policies[p.ID] = policy{InsurancePolicy: *p, clinicID: patient.ClinicID}
+
+		return d.log(ctx, "add_insurance_policy", p.PatientID, actor, nil, p)
+	})
+}
+
+func (s *InsurancePolicyStore) Update(ctx context.Context, actor string, p *store.InsurancePolicy) (*store.InsurancePolicy, error) {
+	var updated store.InsurancePolicy
+	err := s.db.write(ctx, func(d *data) error {
+		before, err := d.lockPolicy(ctx, p.ID)
+		if err != nil {
+			return err
+		}
+		if before.DeactivatedAt != nil {
+			return &utils.CodedError{Code: "BAD_USER_INPUT", Message: fmt.Sprintf("insurance policy %d is deactivated and cannot be changed", p.ID)}
+		}
+
+		p.PatientID = before.PatientID
+		if err := d.checkPrimaryPolicy(p); err != nil {
+			return err
+		}
+
+		updated = before.InsurancePolicy
+		updated.Payer, updated.MemberID, updated.GroupNumber = p.Payer, p.MemberID, p.GroupNumber
+		updated.EffectiveDate, updated.ExpiryDate, updated.Priority = p.EffectiveDate, p.ExpiryDate, p.Priority
+		updated.UpdatedAt = now()
+		d.policies[p.ID] = policy{InsurancePolicy: updated, clinicID: before.clinicID}
+
+		return d.log(ctx, "update_insurance_policy", updated.PatientID, actor, before.InsurancePolicy, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+func (s *InsurancePolicyStore) Deactivate(ctx context.Context, actor string, id int) (*store.InsurancePolicy, error) {
+	var deactivated store.InsurancePolicy
+	err := s.db.write(ctx, func(d *data) error {
+		before, err := d.lockPolicy(ctx, id)
+		if err != nil {
+			return err
+		}
+		if before.DeactivatedAt != nil {
+			return &utils.CodedError{Code: "BAD_USER_INPUT", Message: fmt.Sprintf("insurance policy %d is already deactivated", id)}
+		}
+
+		deactivated = before.InsurancePolicy
+		deactivatedAt := now()
+		deactivated.DeactivatedAt = &deactivatedAt
+		deactivated.UpdatedAt = deactivatedAt
+		d.policies[id] = policy{InsurancePolicy: deactivated, clinicID: before.clinicID}
+
+		return d.log(ctx, "deactivate_insurance_policy", deactivated.PatientID, actor, before.InsurancePolicy, deactivated)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &deactivated, nil
+}
+
+// lockPolicy returns the policy id of a patient of the clinic of ctx who is
+// not deleted, or store.ErrNotFound.
+func (d *data) lockPolicy(ctx context.Context, id int) (policy, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return policy{}, err
+	}
+
+	p, ok := d.policies[id]
+	if !ok || !inScope(p.clinicID) {
+		return policy{}, store.ErrNotFound
+	}
+
+	if _, err := d.lockPatient(ctx, p.PatientID, false); err != nil {
+		return policy{}, err
+	}
+
+	return p, nil
+}
+
+// checkPrimaryPolicy fails like the Postgres store when p is a primary
+// policy in effect on a day another active primary policy of its patient
+// is, naming the one starting first.
+func (d *data) checkPrimaryPolicy(p *store.InsurancePolicy) error {
+	if p.Priority != store.PrimaryPolicy {
+		return nil
+	}
+
+	var conflict *store.InsurancePolicy
+	for _, other := range d.policies {
+		other := other.InsurancePolicy
+		if other.PatientID != p.PatientID || other.ID == p.ID || other.Priority != store.PrimaryPolicy || !p.Overlaps(&other) {
+			continue
+		}
+
+		if conflict == nil || other.EffectiveDate.Before(conflict.EffectiveDate) {
+			conflict = &other
+		}
+	}
+
+	if conflict != nil {
+		return store.PrimaryPolicyConflict(p.PatientID, conflict.ID)
+	}
+
+	return nil
+}
+
+func (s *InsurancePolicyStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*store.InsurancePolicy, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := idSet(patientIDs)
+	byPatient := make(map[int][]*store.InsurancePolicy, len(patientIDs))
+
+	err = s.db.read(ctx, func(d *data) error {
+		for _, p := range d.policies {
+			if wanted[p.PatientID] && inScope(p.clinicID) {
+				p := p.InsurancePolicy
+				byPatient[p.PatientID] = append(byPatient[p.PatientID], &p)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rank := make(map[string]int, len(store.PolicyPriorities))
+	for i, priority := range store.PolicyPriorities {
+		rank[priority] = i
+	}
+
+	for _, policies := range byPatient {
+		sort.Slice(policies, func(i, j int) bool {
+			a, b := policies[i], policies[j]
+			switch {
+			case (a.DeactivatedAt == nil) != (b.DeactivatedAt == nil):
+				return a.DeactivatedAt == nil
+			case rank[a.Priority] != rank[b.Priority]:
+				return rank[a.Priority] < rank[b.Priority]
+			case !a.EffectiveDate.Equal(b.EffectiveDate):
+				return a.EffectiveDate.After(b.EffectiveDate)
+			}
+			return a.ID > b.ID
+		})
+	}
+
+	return byPatient, nil
+}
@@ -0,0 +1,361 @@
+// Package memory implements the store repositories and the audit log in the
+// memory of the process, so the server runs without a Postgres server for
+// local development. Records are lost when the process exits. The
+// repositories behave like the Postgres ones, clinic scoping and audit
+// entries included, with emails and phones kept in plaintext and the
+// trigram matches of pg_trgm computed in Go.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/tenant"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// DB holds the records shared by the repositories of this package. A write
+// holds its lock, and so does a transaction of TxStore for as long as it
+// runs.
+type DB struct {
+	mu   sync.Mutex
+	data *data
+}
+
+// New returns an empty DB with the default clinic, clinic 1, as migration
+// 016 creates it.
+func New() *DB {
+	d := &data{
+		clinics:         map[int]store.Clinic{},
+		patients:        map[int]store.Patient{},
+		appointments:    map[int]appointment{},
+		providers:       map[int]provider{},
+		careTeams:       map[careTeamKey]careTeamMember{},
+		contacts:        map[int]contact{},
+		notes:           map[int]note{},
+		policies:        map[int]policy{},
+		consents:        map[int]consent{},
+		encounters:      map[int]encounter{},
+		revisions:       map[int][]store.EncounterRevision{},
+		reports:         map[int]report{},
+		lastIDs:         map[string]int{},
+		idempotencyKeys: map[idempotencyKeyID]store.IdempotencyKey{},
+	}
+
+	d.clinics[1] = store.Clinic{ID: 1, Name: "Default clinic", CreatedAt: now()}
+	d.lastIDs["clinics"] = 1
+
+	return &DB{data: d}
+}
+
+// data is every record of a DB. The records are kept as values, so copying
+// the maps copies the records, and pointer and slice fields are replaced
+// rather than changed in place.
+type data struct {
+	clinics      map[int]store.Clinic
+	patients     map[int]store.Patient
+	appointments map[int]appointment
+	providers    map[int]provider
+	careTeams    map[careTeamKey]careTeamMember
+	contacts     map[int]contact
+	notes        map[int]note
+	policies     map[int]policy
+	consents     map[int]consent
+	encounters   map[int]encounter
+	// revisions holds the revisions of each encounter by encounter id,
+	// oldest first.
+	revisions map[int][]store.EncounterRevision
+	reports   map[int]report
+	audit     []audit.Entry
+	erasures  []erasure
+	// deadLetters are oldest first.
+	deadLetters     []deadLetter
+	idempotencyKeys map[idempotencyKeyID]store.IdempotencyKey
+	// changes is the change feed of the patients, oldest first.
+	changes []store.PatientChangeEvent
+	// lastIDs is the last id given out for each table, like the sequences
+	// of serial columns.
+	lastIDs map[string]int
+}
+
+type appointment struct {
+	store.Appointment
+	clinicID int
+}
+
+type provider struct {
+	store.Provider
+	clinicID int
+}
+
+type careTeamKey struct {
+	patientID, providerID int
+}
+
+type careTeamMember struct {
+	clinicID   int
+	assignedAt time.Time
+}
+
+type contact struct {
+	store.EmergencyContact
+	clinicID int
+}
+
+type note struct {
+	store.Note
+	clinicID int
+}
+
+type policy struct {
+	store.InsurancePolicy
+	clinicID int
+}
+
+type consent struct {
+	store.Consent
+	clinicID int
+}
+
+// encounter is an encounter without its revisions, which are kept apart.
+type encounter struct {
+	ID            int
+	PatientID     int
+	ProviderID    *int
+	EncounteredAt time.Time
+	CreatedBy     string
+	CreatedAt     time.Time
+	clinicID      int
+}
+
+type report struct {
+	store.DuplicateReport
+	clinicID int
+}
+
+// erasure is a row of patient_erasures.
+type erasure struct {
+	patientID     int
+	clinicID      int
+	performedBy   string
+	justification string
+	erasedAt      time.Time
+}
+
+// deadLetter is a row of hl7_dead_letters.
+type deadLetter struct {
+	store.HL7DeadLetter
+	clinicID int
+}
+
+// idempotencyKeyID is the unique key of idempotency_keys.
+type idempotencyKeyID struct {
+	clinicID int
+	userID   string
+	key      string
+}
+
+// nextID returns the next id of table.
+func (d *data) nextID(table string) int {
+	d.lastIDs[table]++
+	return d.lastIDs[table]
+}
+
+// checkClinic fails when there is no clinic clinicID, which the foreign
+// keys to clinics refuse.
+func (d *data) checkClinic(clinicID int) error {
+	if _, ok := d.clinics[clinicID]; !ok {
+		return fmt.Errorf("clinic %d does not exist", clinicID)
+	}
+
+	return nil
+}
+
+// clone returns a copy of d whose changes leave d as it is.
+func (d *data) clone() *data {
+	c := &data{
+		clinics:         make(map[int]store.Clinic, len(d.clinics)),
+		patients:        make(map[int]store.Patient, len(d.patients)),
+		appointments:    make(map[int]appointment, len(d.appointments)),
+		providers:       make(map[int]provider, len(d.providers)),
+		careTeams:       make(map[careTeamKey]careTeamMember, len(d.careTeams)),
+		contacts:        make(map[int]contact, len(d.contacts)),
+		notes:           make(map[int]note, len(d.notes)),
+		policies:        make(map[int]policy, len(d.policies)),
+		consents:        make(map[int]consent, len(d.consents)),
+		encounters:      make(map[int]encounter, len(d.encounters)),
+		revisions:       make(map[int][]store.EncounterRevision, len(d.revisions)),
+		reports:         make(map[int]report, len(d.reports)),
+		audit:           append([]audit.Entry(nil), d.audit...),
+		erasures:        append([]erasure(nil), d.erasures...),
+		deadLetters:     append([]deadLetter(nil), d.deadLetters...),
+		changes:         append([]store.PatientChangeEvent(nil), d.changes...),
+		lastIDs:         make(map[string]int, len(d.lastIDs)),
+		idempotencyKeys: make(map[idempotencyKeyID]store.IdempotencyKey, len(d.idempotencyKeys)),
+	}
+
+	for k, v := range d.clinics {
+		c.clinics[k] = v
+	}
+	for k, v := range d.patients {
+		c.patients[k] = v
+	}
+	for k, v := range d.appointments {
+		c.appointments[k] = v
+	}
+	for k, v := range d.providers {
+		c.providers[k] = v
+	}
+	for k, v := range d.careTeams {
+		c.careTeams[k] = v
+	}
+	for k, v := range d.contacts {
+		c.contacts[k] = v
+	}
+	for k, v := range d.notes {
+		c.notes[k] = v
+	}
+	for k, v := range d.policies {
+		c.policies[k] = v
+	}
+	for k, v := range d.consents {
+		c.consents[k] = v
+	}
+	for k, v := range d.encounters {
+		c.encounters[k] = v
+	}
+	for k, v := range d.revisions {
+		c.revisions[k] = append([]store.EncounterRevision(nil), v...)
+	}
+	for k, v := range d.reports {
+		c.reports[k] = v
+	}
+	for k, v := range d.idempotencyKeys {
+		c.idempotencyKeys[k] = v
+	}
+	for k, v := range d.lastIDs {
+		c.lastIDs[k] = v
+	}
+
+	return c
+}
+
+type txKey struct{}
+
+// inTx reports whether ctx runs in a transaction of db, which holds its
+// lock.
+func (db *DB) inTx(ctx context.Context) bool {
+	tx, _ := ctx.Value(txKey{}).(*DB)
+	return tx == db
+}
+
+// read runs fn with the records of db, under its lock unless ctx runs in a
+// transaction of db.
+func (db *DB) read(ctx context.Context, fn func(d *data) error) error {
+	if db.inTx(ctx) {
+		return fn(db.data)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return fn(db.data)
+}
+
+// write runs fn like read, undoing its changes when it fails, so a failed
+// write changes nothing, as a failed statement does in Postgres.
+func (db *DB) write(ctx context.Context, fn func(d *data) error) error {
+	if !db.inTx(ctx) {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+	}
+
+	saved := db.data.clone()
+	if err := fn(db.data); err != nil {
+		db.data = saved
+		return err
+	}
+
+	return nil
+}
+
+// TxStore is the memory store.Transactor. A transaction holds the lock of
+// the DB until it ends, so transactions and writes run one at a time.
+type TxStore struct {
+	db *DB
+}
+
+func NewTxStore(db *DB) *TxStore {
+	return &TxStore{db: db}
+}
+
+func (s *TxStore) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.db.inTx(ctx) {
+		return fn(ctx)
+	}
+
+	return s.db.write(ctx, func(*data) error {
+		return fn(context.WithValue(ctx, txKey{}, s.db))
+	})
+}
+
+// scope returns whether the records of a clinic are seen by ctx: those of
+// the clinic it acts for, or every clinic under tenant.WithAllClinics. It
+// fails with utils.ErrNoClinic when ctx acts for no clinic.
+func scope(ctx context.Context) (func(clinicID int) bool, error) {
+	if tenant.AllClinics(ctx) {
+		return func(int) bool { return true }, nil
+	}
+
+	clinicID, ok := tenant.ClinicFromContext(ctx)
+	if !ok {
+		return nil, utils.ErrNoClinic
+	}
+
+	return func(id int) bool { return id == clinicID }, nil
+}
+
+// clinicOf returns the clinic new records of ctx are created in, failing
+// with utils.ErrNoClinic when ctx acts for no clinic.
+func clinicOf(ctx context.Context) (int, error) {
+	clinicID, ok := tenant.ClinicFromContext(ctx)
+	if !ok {
+		return 0, utils.ErrNoClinic
+	}
+
+	return clinicID, nil
+}
+
+// now returns the current time as Postgres stores it, in UTC to the
+// microsecond.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// page returns the bounds of the page of n records at offset with limit.
+func page(n, limit, offset int) (int, int) {
+	start := min(max(offset, 0), n)
+	return start, min(start+max(limit, 0), n)
+}
+
+var (
+	_ store.PatientRepository          = (*PatientStore)(nil)
+	_ store.AppointmentRepository      = (*AppointmentStore)(nil)
+	_ store.DuplicateReportRepository  = (*DuplicateReportStore)(nil)
+	_ store.ProviderRepository         = (*ProviderStore)(nil)
+	_ store.EncounterRepository        = (*EncounterStore)(nil)
+	_ store.EmergencyContactRepository = (*EmergencyContactStore)(nil)
+	_ store.NoteRepository             = (*NoteStore)(nil)
+	_ store.ClinicRepository           = (*ClinicStore)(nil)
+	_ store.ReminderRepository         = (*ReminderStore)(nil)
+	_ store.InsurancePolicyRepository  = (*InsurancePolicyStore)(nil)
+	_ store.ConsentRepository          = (*ConsentStore)(nil)
+	_ store.DocumentRepository         = (*DocumentStore)(nil)
+	_ store.HL7DeadLetterRepository    = (*HL7DeadLetterStore)(nil)
+	_ store.IdempotencyKeyRepository   = (*IdempotencyKeyStore)(nil)
+	_ store.Transactor                 = (*TxStore)(nil)
+)
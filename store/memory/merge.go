@@ -0,0 +1,290 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/codixir/smart-emerge-starter/outbox"
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+func (s *PatientStore) FindDuplicates(ctx context.Context, minSimilarity float64, limit int) ([]*store.DuplicateCandidate, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []*store.DuplicateCandidate{}
+	err = s.db.read(ctx, func(d *data) error {
+		var active []store.Patient
+		for _, patient := range d.patients {
+			if patient.DeletedAt == nil && inScope(patient.ClinicID) {
+				active = append(active, patient)
+			}
+		}
+		sort.Slice(active, func(i, j int) bool { return active[i].ID < active[j].ID })
+
+		for i, a := range active {
+			for _, b := range active[i+1:] {
+				if a.ClinicID != b.ClinicID {
+					continue
+				}
+
+				digits := phoneNumberDigits(a.Phone)
+				sharedEmail := strings.EqualFold(a.Email, b.Email)
+				sharedPhone := digits != "" && digits == phoneNumberDigits(b.Phone)
+				if !sharedEmail && !sharedPhone {
+					continue
+				}
+
+				score := similarity(strings.ToLower(a.Name), strings.ToLower(b.Name))
+				if score < minSimilarity {
+					continue
+				}
+
+				a, b := a, b
+				candidates = append(candidates, &store.DuplicateCandidate{
+					Patient: &a, Duplicate: &b, Score: score, SharedEmail: sharedEmail, SharedPhone: sharedPhone,
+				})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+	if len(candidates) > limit {
+		candidates = candidates[:max(limit, 0)]
+	}
+
+	return candidates, nil
+}
+
+// Merge moves the records of the duplicate to the primary patient and
+// archives the duplicate like the Postgres store.
+func (s *PatientStore) Merge(ctx context.Context, actor string, primaryID, duplicateID int) (*store.Patient, error) {
+	if primaryID == duplicateID {
+		return nil, &utils.CodedError{Code: "BAD_USER_INPUT", Message: "a patient cannot be merged into itself"}
+	}
+
+	var primary store.Patient
+	err := s.db.write(ctx, func(d *data) error {
+		var err error
+		if primary, err = d.lockPatient(ctx, primaryID, false); err != nil {
+			return err
+		}
+
+		before, err := d.lockPatient(ctx, duplicateID, false)
+		if err != nil {
+			return err
+		}
+
+		for id, a := range d.appointments {
+			if a.PatientID == duplicateID {
+				a.PatientID = primaryID
+				d.appointments[id] = a
+			}
+		}
+		for id, e := range d.encounters {
+			if e.PatientID == duplicateID {
+				e.PatientID = primaryID
+				d.encounters[id] = e
+			}
+		}
+		for id, c := range d.contacts {
+			if c.PatientID == duplicateID {
+				c.PatientID = primaryID
+				d.contacts[id] = c
+			}
+		}
+		for id, n := range d.notes {
+			if n.PatientID == duplicateID {
+				n.PatientID = primaryID
+				d.notes[id] = n
+			}
+		}
+		d.movePolicies(primaryID, duplicateID)
+		for key, member := range d.careTeams {
+			if key.patientID != duplicateID {
+				continue
+			}
+
+			moved := careTeamKey{patientID: primaryID, providerID: key.providerID}
+			if _, ok := d.careTeams[moved]; !ok {
+				d.careTeams[moved] = member
+			}
+			delete(d.careTeams, key)
+		}
+		for id, r := range d.reports {
+			between := (r.ReportedPatientID == primaryID && r.SuspectedDuplicateID == duplicateID) ||
+				(r.ReportedPatientID == duplicateID && r.SuspectedDuplicateID == primaryID)
+			if r.Status == "pending" && between {
+				r.Status = "reviewed"
+				d.reports[id] = r
+			}
+		}
+
+		deletedAt := now()
+		archived := before
+		archived.DeletedAt = &deletedAt
+		archived.MergedIntoID = &primaryID
+		archived.Version++
+		d.patients[duplicateID] = archived
+
+		if err := d.recordChange(ctx, outbox.PatientUpdated, primaryID); err != nil {
+			return err
+		}
+
+		return d.log(ctx, "merge", duplicateID, actor, before, archived)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &primary, nil
+}
+
+// Erase anonymizes the patient and the duplicates merged into it like the
+// Postgres store.
+func (s *PatientStore) Erase(ctx context.Context, actor string, id int, justification string) ([]*store.Patient, error) {
+	var erased []*store.Patient
+
+	err := s.db.write(ctx, func(d *data) error {
+		inScope, err := scope(ctx)
+		if err != nil {
+			return err
+		}
+
+		patient, ok := d.patients[id]
+		if !ok || !inScope(patient.ClinicID) {
+			return store.ErrNotFound
+		}
+		if patient.ErasedAt != nil {
+			return &utils.CodedError{
+				Code:    "BAD_USER_INPUT",
+				Message: fmt.Sprintf("patient %d was already erased", id),
+			}
+		}
+
+		ids := d.mergedPatients(id)
+		isErased := make(map[int]bool, len(ids))
+		for _, patientID := range ids {
+			isErased[patientID] = true
+		}
+
+		for contactID, c := range d.contacts {
+			if isErased[c.PatientID] {
+				delete(d.contacts, contactID)
+			}
+		}
+		for policyID, p := range d.policies {
+			if isErased[p.PatientID] {
+				delete(d.policies, policyID)
+			}
+		}
+		for appointmentID, a := range d.appointments {
+			if isErased[a.PatientID] {
+				a.Reason, a.Notes = "", ""
+				d.appointments[appointmentID] = a
+			}
+		}
+		for i := range d.audit {
+			if isErased[d.audit[i].PatientID] {
+				d.audit[i].OldValue, d.audit[i].NewValue = nil, nil
+			}
+		}
+
+		erased = make([]*store.Patient, 0, len(ids))
+		for _, patientID := range ids {
+			anonymized := d.anonymize(actor, patientID, justification)
+			erased = append(erased, anonymized)
+
+			if err := d.log(ctx, "erase", patientID, actor, nil, anonymized); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return erased, nil
+}
+
+// mergedPatients returns id and the ids of the patients merged into it,
+// directly or through other merged patients, id first and the others by id.
+func (d *data) mergedPatients(id int) []int {
+	ids := []int{id}
+
+	for i := 0; i < len(ids); i++ {
+		var merged []int
+		for _, patient := range d.patients {
+			if patient.MergedIntoID != nil && *patient.MergedIntoID == ids[i] {
+				merged = append(merged, patient.ID)
+			}
+		}
+
+		sort.Ints(merged)
+		ids = append(ids, merged...)
+	}
+
+	sort.Ints(ids[1:])
+	return ids
+}
+
+// anonymize replaces the identifying fields of the patient id, soft-deletes
+// it and records its erasure, returning the anonymized patient.
+func (d *data) anonymize(actor string, id int, justification string) *store.Patient {
+	erasedAt := now()
+
+	patient := d.patients[id]
+	patient.Name = store.ErasedPatientName
+	patient.Email = fmt.Sprintf("erased-%d@erased.invalid", id)
+	patient.Phone = ""
+	patient.Demographics = store.Demographics{}
+	if patient.DeletedAt == nil {
+		patient.DeletedAt = &erasedAt
+	}
+	patient.ErasedAt = &erasedAt
+	patient.Version++
+	d.patients[id] = patient
+
+	d.erasures = append(d.erasures, erasure{
+		patientID: id, clinicID: patient.ClinicID, performedBy: actor, justification: justification, erasedAt: erasedAt,
+	})
+
+	return &patient
+}
+
+// movePolicies moves the insurance policies of the duplicate to the primary
+// patient, deactivating the primary policies of the duplicate in effect at
+// the same time as one of the primary patient first.
+func (d *data) movePolicies(primaryID, duplicateID int) {
+	for id, p := range d.policies {
+		if p.PatientID != duplicateID {
+			continue
+		}
+
+		if p.Priority == store.PrimaryPolicy && p.DeactivatedAt == nil {
+			for _, other := range d.policies {
+				if other.PatientID == primaryID && other.Priority == store.PrimaryPolicy && p.Overlaps(&other.InsurancePolicy) {
+					deactivatedAt := now()
+					p.DeactivatedAt = &deactivatedAt
+					p.UpdatedAt = deactivatedAt
+					break
+				}
+			}
+		}
+
+		p.PatientID = primaryID
+		d.policies[id] = p
+	}
+}
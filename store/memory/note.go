@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// NoteStore is the memory store.NoteRepository.
+type NoteStore struct {
+	db *DB
+}
+
+func NewNoteStore(db *DB) *NoteStore {
+	return &NoteStore{db: db}
+}
+
+func (s *NoteStore) Add(ctx context.Context, actor string, n *store.Note) error {
+	return s.db.write(ctx, func(d *data) error {
+		patient, err := d.lockPatient(ctx, n.PatientID, false)
+		if err != nil {
+			return err
+		}
+
+		n.ID = d.nextID("patient_notes")
+		n.CreatedBy = actor
+		n.CreatedAt = now()
+		d.notes[n.ID] = note{Note: *n, clinicID: patient.ClinicID}
+
+		return d.log(ctx, "add_note", n.PatientID, actor, nil, n)
+	})
+}
+
+func (s *NoteStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*store.Note, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := idSet(patientIDs)
+	byPatient := make(map[int][]*store.Note, len(patientIDs))
+
+	err = s.db.read(ctx, func(d *data) error {
+		for _, n := range d.notes {
+			if wanted[n.PatientID] && inScope(n.clinicID) {
+				n := n.Note
+				byPatient[n.PatientID] = append(byPatient[n.PatientID], &n)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, notes := range byPatient {
+		sort.Slice(notes, func(i, j int) bool {
+			if !notes[i].CreatedAt.Equal(notes[j].CreatedAt) {
+				return notes[i].CreatedAt.Before(notes[j].CreatedAt)
+			}
+			return notes[i].ID < notes[j].ID
+		})
+	}
+
+	return byPatient, nil
+}
@@ -0,0 +1,599 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// PatientStore is the memory store.PatientRepository.
+type PatientStore struct {
+	db *DB
+}
+
+func NewPatientStore(db *DB) *PatientStore {
+	return &PatientStore{db: db}
+}
+
+func (s *PatientStore) Get(ctx context.Context, id int, includeDeleted bool) (*store.Patient, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var patient store.Patient
+	err = s.db.read(ctx, func(d *data) error {
+		var ok bool
+		patient, ok = d.patients[id]
+		if !ok || !inScope(patient.ClinicID) || (!includeDeleted && patient.DeletedAt != nil) {
+			return store.ErrNotFound
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &patient, nil
+}
+
+func (s *PatientStore) GetMany(ctx context.Context, ids []int) (map[int]*store.Patient, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	patients := make(map[int]*store.Patient, len(ids))
+	err = s.db.read(ctx, func(d *data) error {
+		for _, id := range ids {
+			if patient, ok := d.patients[id]; ok && inScope(patient.ClinicID) && patient.DeletedAt == nil {
+				patients[id] = &patient
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return patients, nil
+}
+
+func (s *PatientStore) List(ctx context.Context, opts store.ListOptions) ([]*store.Patient, int, error) {
+	patients, err := s.matching(ctx, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start, end := page(len(patients), opts.Limit, opts.Offset)
+	return append([]*store.Patient{}, patients[start:end]...), len(patients), nil
+}
+
+func (s *PatientStore) StatusSummary(ctx context.Context) ([]*store.StatusCount, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var active, deleted int
+	err = s.db.read(ctx, func(d *data) error {
+		for _, patient := range d.patients {
+			switch {
+			case !inScope(patient.ClinicID):
+			case patient.DeletedAt != nil:
+				deleted++
+			default:
+				active++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := []*store.StatusCount{}
+	for _, c := range []store.StatusCount{{Status: "active", Count: active}, {Status: "deleted", Count: deleted}} {
+		if c.Count > 0 {
+			c := c
+			counts = append(counts, &c)
+		}
+	}
+
+	return counts, nil
+}
+
+func (s *PatientStore) Each(ctx context.Context, opts store.ListOptions, fn func(*store.Patient) error) error {
+	patients, err := s.matching(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, patient := range patients {
+		if err := fn(patient); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matching returns every patient matching the filter of opts, in its order.
+func (s *PatientStore) matching(ctx context.Context, opts store.ListOptions) ([]*store.Patient, error) {
+	if err := store.CheckSort(opts.SortBy, opts.SortOrder); err != nil {
+		return nil, err
+	}
+
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	patients := []*store.Patient{}
+	err = s.db.read(ctx, func(d *data) error {
+		for _, patient := range d.patients {
+			if inScope(patient.ClinicID) && (opts.IncludeDeleted || patient.DeletedAt == nil) && matchesPatientFilter(patient, opts.Filter) && d.sharingConsented(patient.ID, opts.Filter.SharingConsent) {
+				patient := patient
+				patients = append(patients, &patient)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortPatients(patients, opts.SortBy, opts.SortOrder)
+	return patients, nil
+}
+
+// matchesPatientFilter reports whether patient matches the non-empty fields
+// of filter, as the ILIKE conditions of the Postgres store do.
+func matchesPatientFilter(patient store.Patient, filter store.PatientFilter) bool {
+	contains := func(value, part string) bool {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(part))
+	}
+
+	switch {
+	case filter.Name != "" && !contains(patient.Name, filter.Name):
+		return false
+	case filter.Email != "" && !contains(patient.Email, filter.Email):
+		return false
+	case filter.Phone != "" && !contains(patient.Phone, filter.Phone):
+		return false
+	case filter.EmailEquals != "" && !strings.EqualFold(patient.Email, filter.EmailEquals):
+		return false
+	}
+
+	return true
+}
+
+// sharingConsented reports whether the patient has a data sharing consent in
+// effect covering scope, or scope is "" and not filtered on.
+func (d *data) sharingConsented(patientID int, scope string) bool {
+	if scope == "" {
+		return true
+	}
+
+	for _, c := range d.consents {
+		if c.PatientID == patientID && c.Covers(store.ConsentDataSharing, scope) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortPatients sorts patients by sortBy in sortOrder, which store.CheckSort
+// accepted, breaking ties by id.
+func sortPatients(patients []*store.Patient, sortBy, sortOrder string) {
+	key := func(p *store.Patient) string {
+		switch sortBy {
+		case "name":
+			return p.Name
+		case "email":
+			return p.Email
+		}
+		return ""
+	}
+
+	sort.Slice(patients, func(i, j int) bool {
+		a, b := patients[i], patients[j]
+		if sortOrder == "desc" {
+			a, b = b, a
+		}
+
+		if ka, kb := key(a), key(b); ka != kb {
+			return ka < kb
+		}
+		return a.ID < b.ID
+	})
+}
+
+func (s *PatientStore) Create(ctx context.Context, actor, name, email, phone string, demographics store.Demographics) (*store.Patient, error) {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var patient store.Patient
+	err = s.db.write(ctx, func(d *data) error {
+		if err := d.checkClinic(clinicID); err != nil {
+			return err
+		}
+		if err := d.checkEmail(clinicID, email, 0); err != nil {
+			return err
+		}
+
+		patient = store.Patient{
+			ID: d.nextID("patients"), Name: name, Email: email, Phone: phone, Version: 1, ClinicID: clinicID,
+			Demographics: demographics,
+		}
+		d.patients[patient.ID] = patient
+
+		return d.log(ctx, "create", patient.ID, actor, nil, patient)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &patient, nil
+}
+
+// checkEmail fails when another patient of the clinic than exceptID has
+// email, which the unique index of Postgres refuses.
+func (d *data) checkEmail(clinicID int, email string, exceptID int) error {
+	for _, patient := range d.patients {
+		if patient.ClinicID == clinicID && patient.Email == email && patient.ID != exceptID {
+			return fmt.Errorf("patient %d of clinic %d already has the email %s", patient.ID, clinicID, email)
+		}
+	}
+
+	return nil
+}
+
+// lockPatient returns a patient of the clinic of ctx, soft-deleted or not as
+// deleted chooses, and ErrNotFound when there is none.
+func (d *data) lockPatient(ctx context.Context, id int, deleted bool) (store.Patient, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return store.Patient{}, err
+	}
+
+	patient, ok := d.patients[id]
+	if !ok || !inScope(patient.ClinicID) || (patient.DeletedAt != nil) != deleted {
+		return store.Patient{}, store.ErrNotFound
+	}
+
+	return patient, nil
+}
+
+func (s *PatientStore) Update(ctx context.Context, actor string, id int, changes store.PatientChanges) (*store.Patient, error) {
+	if changes.Empty() {
+		return nil, fmt.Errorf("update needs at least one field to change")
+	}
+
+	var after store.Patient
+	err := s.db.write(ctx, func(d *data) error {
+		before, err := d.lockPatient(ctx, id, false)
+		if err != nil {
+			return err
+		}
+
+		if changes.Version != nil && before.Version != *changes.Version {
+			return &utils.CodedError{
+				Code: "VERSION_CONFLICT",
+				Message: fmt.Sprintf("patient %d was changed since version %d and is now at version %d",
+					id, *changes.Version, before.Version),
+				Details: map[string]interface{}{"current": &before},
+			}
+		}
+
+		after = before
+		if changes.Name != nil {
+			after.Name = *changes.Name
+		}
+		if changes.Email != nil {
+			if err := d.checkEmail(before.ClinicID, *changes.Email, id); err != nil {
+				return err
+			}
+			after.Email = *changes.Email
+		}
+		if changes.Phone != nil {
+			after.Phone = *changes.Phone
+		}
+		changes.ApplyDemographics(&after.Demographics)
+		after.Version++
+		d.patients[id] = after
+
+		return d.log(ctx, "update", id, actor, before, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &after, nil
+}
+
+func (s *PatientStore) Delete(ctx context.Context, actor string, id int) (*store.Patient, error) {
+	var after store.Patient
+	err := s.db.write(ctx, func(d *data) error {
+		before, err := d.lockPatient(ctx, id, false)
+		if err != nil {
+			return err
+		}
+
+		deletedAt := now()
+		after = before
+		after.DeletedAt = &deletedAt
+		after.Version++
+		d.patients[id] = after
+
+		return d.log(ctx, "delete", id, actor, before, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &after, nil
+}
+
+func (s *PatientStore) Restore(ctx context.Context, actor string, id int) (*store.Patient, error) {
+	var after store.Patient
+	err := s.db.write(ctx, func(d *data) error {
+		before, err := d.lockPatient(ctx, id, true)
+		if err != nil {
+			return err
+		}
+
+		if before.ErasedAt != nil {
+			return &utils.CodedError{
+				Code:    "BAD_USER_INPUT",
+				Message: fmt.Sprintf("patient %d was erased and cannot be restored", id),
+			}
+		}
+
+		if before.MergedIntoID != nil {
+			return &utils.CodedError{
+				Code:    "BAD_USER_INPUT",
+				Message: fmt.Sprintf("patient %d was merged into patient %d and cannot be restored", id, *before.MergedIntoID),
+			}
+		}
+
+		after = before
+		after.DeletedAt = nil
+		after.Version++
+		d.patients[id] = after
+
+		return d.log(ctx, "restore", id, actor, before, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &after, nil
+}
+
+func (s *PatientStore) Purge(ctx context.Context, actor string, id int) (*store.Patient, error) {
+	var purged store.Patient
+	err := s.db.write(ctx, func(d *data) error {
+		var err error
+		if purged, err = d.lockPatient(ctx, id, true); err != nil {
+			return err
+		}
+
+		for appointmentID, a := range d.appointments {
+			if a.PatientID == id {
+				delete(d.appointments, appointmentID)
+			}
+		}
+		for key := range d.careTeams {
+			if key.patientID == id {
+				delete(d.careTeams, key)
+			}
+		}
+		for contactID, c := range d.contacts {
+			if c.PatientID == id {
+				delete(d.contacts, contactID)
+			}
+		}
+		for noteID, n := range d.notes {
+			if n.PatientID == id {
+				delete(d.notes, noteID)
+			}
+		}
+		for policyID, p := range d.policies {
+			if p.PatientID == id {
+				delete(d.policies, policyID)
+			}
+		}
+		for consentID, c := range d.consents {
+			if c.PatientID == id {
+				delete(d.consents, consentID)
+			}
+		}
+		for encounterID, e := range d.encounters {
+			if e.PatientID == id {
+				delete(d.encounters, encounterID)
+				delete(d.revisions, encounterID)
+			}
+		}
+		for reportID, r := range d.reports {
+			if r.ReportedPatientID == id || r.SuspectedDuplicateID == id {
+				delete(d.reports, reportID)
+			}
+		}
+		delete(d.patients, id)
+
+		return d.log(ctx, "purge", id, actor, purged, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &purged, nil
+}
+
+// Upsert matches patients by email like the Postgres store. Each row either
+// fails before changing anything or is stored, so no row needs undoing.
+func (s *PatientStore) Upsert(ctx context.Context, actor string, patients []*store.Patient) ([]store.UpsertResult, error) {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]store.UpsertResult, len(patients))
+
+	err = s.db.write(ctx, func(d *data) error {
+		if err := d.checkClinic(clinicID); err != nil {
+			return err
+		}
+
+		for i, patient := range patients {
+			result, err := d.upsert(ctx, actor, clinicID, patient)
+			if err != nil {
+				results[i] = store.UpsertResult{Err: err}
+				continue
+			}
+
+			results[i] = result
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// CompleteImport publishes nothing, the memory store having no outbox.
+func (s *PatientStore) CompleteImport(ctx context.Context, summary store.ImportSummary) error {
+	_, err := clinicOf(ctx)
+	return err
+}
+
+func (d *data) upsert(ctx context.Context, actor string, clinicID int, patient *store.Patient) (store.UpsertResult, error) {
+	var before *store.Patient
+	for _, stored := range d.patients {
+		if stored.ClinicID == clinicID && strings.EqualFold(stored.Email, patient.Email) {
+			stored := stored
+			before = &stored
+			break
+		}
+	}
+
+	switch {
+	case before == nil:
+		created := store.Patient{
+			ID: d.nextID("patients"), Name: patient.Name, Email: patient.Email, Phone: patient.Phone, Version: 1, ClinicID: clinicID,
+			Demographics: patient.Demographics,
+		}
+		d.patients[created.ID] = created
+
+		return store.UpsertResult{Patient: &created, Status: store.UpsertCreated}, d.log(ctx, "create", created.ID, actor, nil, created)
+	case before.DeletedAt != nil:
+		return store.UpsertResult{}, &utils.CodedError{
+			Code:    "PATIENT_DELETED",
+			Message: "the patient with this email is deleted and must be restored before it can be imported",
+		}
+	}
+
+	changes := store.UpsertChanges(before, patient)
+	if changes.Empty() {
+		return store.UpsertResult{Patient: before, Status: store.UpsertUnchanged}, nil
+	}
+
+	after := *before
+	after.Name = patient.Name
+	after.Phone = patient.Phone
+	changes.ApplyDemographics(&after.Demographics)
+	after.Version++
+	d.patients[after.ID] = after
+
+	return store.UpsertResult{Patient: &after, Status: store.UpsertUpdated}, d.log(ctx, "update", after.ID, actor, before, after)
+}
+
+// wordSimilarityThreshold is the default pg_trgm.word_similarity_threshold,
+// above which the <% operator of the Postgres search matches.
+const wordSimilarityThreshold = 0.6
+
+func (s *PatientStore) Search(ctx context.Context, term string, limit int) ([]*store.PatientMatch, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	term = strings.ToLower(strings.TrimSpace(term))
+	digits := store.PhoneDigits(term)
+
+	matches := []*store.PatientMatch{}
+	err = s.db.read(ctx, func(d *data) error {
+		for _, patient := range d.patients {
+			if patient.DeletedAt != nil || !inScope(patient.ClinicID) {
+				continue
+			}
+
+			name, email := strings.ToLower(patient.Name), strings.ToLower(patient.Email)
+
+			score := 0.0
+			if strings.Contains(name, term) || strings.Contains(email, term) {
+				score = 1
+			} else if similar := max(wordSimilarity(term, name), wordSimilarity(term, email)); similar >= wordSimilarityThreshold {
+				score = similar
+			}
+			if digits != "" && strings.Contains(phoneNumberDigits(patient.Phone), digits) {
+				score = 1
+			}
+
+			if score > 0 {
+				patient := patient
+				matches = append(matches, &store.PatientMatch{Patient: &patient, Score: score})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		switch {
+		case a.Score != b.Score:
+			return a.Score > b.Score
+		case a.Patient.Name != b.Patient.Name:
+			return a.Patient.Name < b.Patient.Name
+		}
+		return a.Patient.ID < b.Patient.ID
+	})
+
+	if len(matches) > limit {
+		matches = matches[:max(limit, 0)]
+	}
+	for _, match := range matches {
+		match.Highlights = store.Highlights(match.Patient, term)
+	}
+
+	return matches, nil
+}
+
+// phoneNumberDigits returns the digits of phone, dropping everything else.
+func phoneNumberDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+}
@@ -0,0 +1,81 @@
+package memory_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/store"
+	"github.com/codixir/smart-emerge-starter/store/memory"
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+func TestListSharingConsent(t *testing.T) {
+	db := memory.New()
+	patients := memory.NewPatientStore(db)
+	consents := memory.NewConsentStore(db)
+	ctx := tenant.WithClinic(context.Background(), 1)
+
+	// The patients are named after the scope of their data sharing consent.
+	grants := map[string][]string{
+		"fhir":     {store.ConsentScopeFHIR},
+		"all":      {store.ConsentScopeAll},
+		"events":   {store.ConsentScopeEvents},
+		"none":     nil,
+		"revoked":  {store.ConsentScopeFHIR},
+		"and both": {store.ConsentScopeFHIR, store.ConsentScopeEvents},
+	}
+
+	for i, name := range []string{"fhir", "all", "events", "none", "revoked", "and both"} {
+		patient, err := patients.Create(ctx, "admin-1", name, fmt.Sprintf("p%d@example.com", i), "+14155550100", store.Demographics{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, scope := range grants[name] {
+			consent := &store.Consent{PatientID: patient.ID, Type: store.ConsentDataSharing, Scope: scope, GrantedAt: time.Now()}
+			if err := consents.Grant(ctx, "admin-1", consent); err != nil {
+				t.Fatal(err)
+			}
+			if name == "revoked" {
+				if _, err := consents.Revoke(ctx, "admin-1", consent.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	marketing := &store.Consent{PatientID: 4, Type: store.ConsentMarketing, Scope: store.ConsentScopeAll, GrantedAt: time.Now()}
+	if err := consents.Grant(ctx, "admin-1", marketing); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		consent string
+		want    []string
+	}{
+		{"", []string{"fhir", "all", "events", "none", "revoked", "and both"}},
+		{store.ConsentScopeFHIR, []string{"fhir", "all", "and both"}},
+		{store.ConsentScopeEvents, []string{"all", "events", "and both"}},
+		{"change_feed", []string{"all"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.consent, func(t *testing.T) {
+			list, total, err := patients.List(ctx, store.ListOptions{Filter: store.PatientFilter{SharingConsent: tt.consent}, Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, patient := range list {
+				got = append(got, patient.Name)
+			}
+
+			if total != len(tt.want) || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("List() = %v of %d, want %v", got, total, tt.want)
+			}
+		})
+	}
+}
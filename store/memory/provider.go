@@ -0,0 +1,199 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// ProviderStore is the memory store.ProviderRepository.
+type ProviderStore struct {
+	db *DB
+}
+
+func NewProviderStore(db *DB) *ProviderStore {
+	return &ProviderStore{db: db}
+}
+
+func (s *ProviderStore) Get(ctx context.Context, id int) (*store.Provider, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result store.Provider
+	err = s.db.read(ctx, func(d *data) error {
+		p, ok := d.providers[id]
+		if !ok || !inScope(p.clinicID) {
+			return store.ErrNotFound
+		}
+
+		result = p.Provider
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (s *ProviderStore) List(ctx context.Context, specialty string, limit, offset int) ([]*store.Provider, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := []*store.Provider{}
+	err = s.db.read(ctx, func(d *data) error {
+		for _, p := range d.providers {
+			if inScope(p.clinicID) && (specialty == "" || p.Specialty == specialty) {
+				p := p.Provider
+				providers = append(providers, &p)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortProviders(providers)
+	start, end := page(len(providers), limit, offset)
+	return providers[start:end], nil
+}
+
+// sortProviders sorts providers by name, breaking ties by id.
+func sortProviders(providers []*store.Provider) {
+	sort.Slice(providers, func(i, j int) bool {
+		if providers[i].Name != providers[j].Name {
+			return providers[i].Name < providers[j].Name
+		}
+		return providers[i].ID < providers[j].ID
+	})
+}
+
+func (s *ProviderStore) Create(ctx context.Context, p *store.Provider) error {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.db.write(ctx, func(d *data) error {
+		if err := d.checkClinic(clinicID); err != nil {
+			return err
+		}
+
+		p.ID = d.nextID("providers")
+		d.providers[p.ID] = provider{Provider: *p, clinicID: clinicID}
+		return nil
+	})
+}
+
+func (s *ProviderStore) Assign(ctx context.Context, actor string, patientID, providerID int) error {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.db.write(ctx, func(d *data) error {
+		patient, err := d.lockPatient(ctx, patientID, false)
+		if err != nil {
+			return err
+		}
+
+		p, ok := d.providers[providerID]
+		if !ok || !inScope(p.clinicID) {
+			return store.ErrNotFound
+		}
+
+		key := careTeamKey{patientID: patientID, providerID: providerID}
+		if _, ok := d.careTeams[key]; ok {
+			// Already on the care team, so there is no change to record.
+			return nil
+		}
+		d.careTeams[key] = careTeamMember{clinicID: patient.ClinicID, assignedAt: now()}
+
+		return d.log(ctx, "assign_provider", patientID, actor, nil, p.Provider)
+	})
+}
+
+func (s *ProviderStore) Unassign(ctx context.Context, actor string, patientID, providerID int) error {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.db.write(ctx, func(d *data) error {
+		key := careTeamKey{patientID: patientID, providerID: providerID}
+		member, ok := d.careTeams[key]
+		if !ok || !inScope(member.clinicID) {
+			return store.ErrNotFound
+		}
+		delete(d.careTeams, key)
+
+		return d.log(ctx, "unassign_provider", patientID, actor, d.providers[providerID].Provider, nil)
+	})
+}
+
+func (s *ProviderStore) CareTeams(ctx context.Context, patientIDs []int) (map[int][]*store.Provider, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := idSet(patientIDs)
+	byPatient := make(map[int][]*store.Provider, len(patientIDs))
+
+	err = s.db.read(ctx, func(d *data) error {
+		for key, member := range d.careTeams {
+			if wanted[key.patientID] && inScope(member.clinicID) {
+				p := d.providers[key.providerID].Provider
+				byPatient[key.patientID] = append(byPatient[key.patientID], &p)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, providers := range byPatient {
+		sortProviders(providers)
+	}
+
+	return byPatient, nil
+}
+
+func (s *ProviderStore) Panels(ctx context.Context, providerIDs []int) (map[int][]*store.Patient, error) {
+	inScope, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := idSet(providerIDs)
+	byProvider := make(map[int][]*store.Patient, len(providerIDs))
+
+	err = s.db.read(ctx, func(d *data) error {
+		for key, member := range d.careTeams {
+			patient := d.patients[key.patientID]
+			if wanted[key.providerID] && inScope(member.clinicID) && patient.DeletedAt == nil {
+				byProvider[key.providerID] = append(byProvider[key.providerID], &patient)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, patients := range byProvider {
+		sort.Slice(patients, func(i, j int) bool { return patients[i].ID < patients[j].ID })
+	}
+
+	return byProvider, nil
+}
@@ -0,0 +1,25 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/codixir/smart-emerge-starter/store"
+)
+
+// ReminderStore is the memory store.ReminderRepository. Reminders need
+// Postgres, so there are never any.
+type ReminderStore struct {
+	db *DB
+}
+
+func NewReminderStore(db *DB) *ReminderStore {
+	return &ReminderStore{db: db}
+}
+
+func (s *ReminderStore) ListByPatient(ctx context.Context, patientID, limit, offset int) ([]*store.Reminder, error) {
+	if _, err := scope(ctx); err != nil {
+		return nil, err
+	}
+
+	return []*store.Reminder{}, nil
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"strings"
+	"unicode"
+)
+
+// trigrams returns the trigrams of s in order as pg_trgm extracts them: each
+// word, a run of letters and digits, is lower-cased and padded with two
+// spaces before and one after.
+func trigrams(s string) []string {
+	var result []string
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			result = append(result, string(padded[i:i+3]))
+		}
+	}
+
+	return result
+}
+
+func trigramSet(trigrams []string) map[string]bool {
+	set := make(map[string]bool, len(trigrams))
+	for _, t := range trigrams {
+		set[t] = true
+	}
+
+	return set
+}
+
+// setSimilarity returns the shared trigrams of a and b over all of theirs.
+func setSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for t := range a {
+		if b[t] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// similarity is the similarity function of pg_trgm.
+func similarity(a, b string) float64 {
+	return setSimilarity(trigramSet(trigrams(a)), trigramSet(trigrams(b)))
+}
+
+// wordSimilarity is the word_similarity function of pg_trgm: the greatest
+// similarity between the trigrams of a and those of a continuous extent of
+// the ordered trigrams of b.
+func wordSimilarity(a, b string) float64 {
+	set := trigramSet(trigrams(a))
+	ordered := trigrams(b)
+
+	best := 0.0
+	for start := range ordered {
+		extent := map[string]bool{}
+		for _, t := range ordered[start:] {
+			extent[t] = true
+			best = max(best, setSimilarity(set, extent))
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/codixir/smart-emerge-starter/outbox"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// DuplicateCandidate is a pair of patients FindDuplicates considers likely to
+// be the same person, the one created first as Patient.
+type DuplicateCandidate struct {
+	Patient   *Patient `json:"patient"`
+	Duplicate *Patient `json:"duplicate"`
+	// Score is the trigram similarity of their names, from 0 to 1.
+	Score       float64 `json:"score"`
+	SharedEmail bool    `json:"sharedEmail"`
+	SharedPhone bool    `json:"sharedPhone"`
+}
+
+// duplicatesStmt pairs patients of the same clinic, the one of $3, who are
+// not soft-deleted, share an email, ignoring case, or the digits of a phone
+// number, and have names at least $1 similar. $2 limits the pairs returned.
+var duplicatesStmt = `select ` + qualifiedPatientColumns("a") + `, ` + qualifiedPatientColumns("b") + `,
+		similarity(lower(a.name), lower(b.name)) as score,
+		lower(a.email) = lower(b.email) as shared_email,
+		regexp_replace(a.phone, '[^0-9]', '', 'g') = regexp_replace(b.phone, '[^0-9]', '', 'g') as shared_phone
+	from patients a join patients b on a.id < b.id and a.clinic_id = b.clinic_id and (
+		lower(a.email) = lower(b.email)
+		or (regexp_replace(a.phone, '[^0-9]', '', 'g') <> ''
+			and regexp_replace(a.phone, '[^0-9]', '', 'g') = regexp_replace(b.phone, '[^0-9]', '', 'g'))
+	)
+	where a.deleted_at is null and b.deleted_at is null and ` + inClinic("a.clinic_id", 3) + `
+		and similarity(lower(a.name), lower(b.name)) >= $1
+	order by score desc, a.id, b.id limit $2`
+
+// encryptedDuplicatesStmt is duplicatesStmt for encrypted emails and phones,
+// which are compared through their blind indexes.
+var encryptedDuplicatesStmt = `select ` + qualifiedPatientColumns("a") + `, ` + qualifiedPatientColumns("b") + `,
+		similarity(lower(a.name), lower(b.name)) as score,
+		coalesce(a.email_index = b.email_index, false) as shared_email,
+		coalesce(a.phone_index = b.phone_index, false) as shared_phone
+	from patients a join patients b on a.id < b.id and a.clinic_id = b.clinic_id
+		and (a.email_index = b.email_index or a.phone_index = b.phone_index)
+	where a.deleted_at is null and b.deleted_at is null and ` + inClinic("a.clinic_id", 3) + `
+		and similarity(lower(a.name), lower(b.name)) >= $1
+	order by score desc, a.id, b.id limit $2`
+
+// FindDuplicates returns up to limit pairs of patients who are not
+// soft-deleted, share an email or phone number and whose names are at least
+// minSimilarity alike, the most similar names first.
+func (s *PatientStore) FindDuplicates(ctx context.Context, minSimilarity float64, limit int) ([]*DuplicateCandidate, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := duplicatesStmt
+	if s.cipher.Enabled() {
+		stmt = encryptedDuplicatesStmt
+	}
+
+	rows, err := readConn(ctx, s.db, s.replica).QueryContext(ctx, stmt, minSimilarity, limit, clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []*DuplicateCandidate{}
+	for rows.Next() {
+		candidate := &DuplicateCandidate{Patient: &Patient{}, Duplicate: &Patient{}}
+
+		dest := append(candidate.Patient.fields(), candidate.Duplicate.fields()...)
+		dest = append(dest, &candidate.Score, &candidate.SharedEmail, &candidate.SharedPhone)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		for _, patient := range []*Patient{candidate.Patient, candidate.Duplicate} {
+			if err := unsealPatient(s.cipher, patient); err != nil {
+				return nil, err
+			}
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, rows.Err()
+}
+
+// Merge moves the appointments, encounters, emergency contacts, notes,
+// insurance policies, documents and care team of the duplicate patient to the primary
+// one and archives the duplicate: it is soft-deleted with MergedIntoID set
+// to the primary. The primary policies of the duplicate in effect at the
+// same time as one of the primary are deactivated, so the primary keeps its
+// own. Pending duplicate reports between the two are marked reviewed. The
+// merge is recorded in the audit log against the duplicate, and published as
+// the deletion of the duplicate and an update of the primary. The primary
+// patient is returned.
+func (s *PatientStore) Merge(ctx context.Context, actor string, primaryID, duplicateID int) (*Patient, error) {
+	if primaryID == duplicateID {
+		return nil, &utils.CodedError{Code: "BAD_USER_INPUT", Message: "a patient cannot be merged into itself"}
+	}
+
+	var primary *Patient
+
+	_, err := s.audited(ctx, actor, "merge", func(tx *sql.Tx) (*Patient, *Patient, error) {
+		// Lock the patients in id order, so merges of the same two patients
+		// in opposite directions cannot deadlock.
+		locked := map[int]*Patient{}
+		for _, id := range []int{min(primaryID, duplicateID), max(primaryID, duplicateID)} {
+			patient, err := lockPatient(ctx, tx, id, false, s.cipher)
+			if err != nil {
+				return nil, nil, err
+			}
+			locked[id] = patient
+		}
+
+		for _, step := range []struct {
+			stmt string
+			args []interface{}
+		}{
+			{"update appointments set patient_id = $1 where patient_id = $2", []interface{}{primaryID, duplicateID}},
+			{"update encounters set patient_id = $1 where patient_id = $2", []interface{}{primaryID, duplicateID}},
+			{"update emergency_contacts set patient_id = $1 where patient_id = $2", []interface{}{primaryID, duplicateID}},
+			{"update patient_notes set patient_id = $1 where patient_id = $2", []interface{}{primaryID, duplicateID}},
+			{"update documents set patient_id = $1 where patient_id = $2", []interface{}{primaryID, duplicateID}},
+			{`update insurance_policies d set deactivated_at = now(), updated_at = now()
+				where d.patient_id = $2 and d.priority = 'primary' and d.deactivated_at is null and exists (
+					select 1 from insurance_policies p where p.patient_id = $1 and p.priority = 'primary' and p.deactivated_at is null
+						and p.effective_date <= coalesce(d.expiry_date, 'infinity') and coalesce(p.expiry_date, 'infinity') >= d.effective_date)`,
+				[]interface{}{primaryID, duplicateID}},
+			{"update insurance_policies set patient_id = $1 where patient_id = $2", []interface{}{primaryID, duplicateID}},
+			{`insert into care_team_members(patient_id, provider_id, assigned_at, clinic_id)
+				select $1, provider_id, assigned_at, clinic_id from care_team_members where patient_id = $2
+				on conflict do nothing`, []interface{}{primaryID, duplicateID}},
+			{"delete from care_team_members where patient_id = $1", []interface{}{duplicateID}},
+			{`update duplicate_reports set status = 'reviewed' where status = 'pending' and (
+				(reported_patient_id = $1 and suspected_duplicate_id = $2) or (reported_patient_id = $2 and suspected_duplicate_id = $1))`,
+				[]interface{}{primaryID, duplicateID}},
+		} {
+			if _, err := tx.ExecContext(ctx, step.stmt, step.args...); err != nil {
+				return nil, nil, fmt.Errorf("merging patient %d into %d: %w", duplicateID, primaryID, err)
+			}
+		}
+
+		stmt := `update patients set deleted_at = now(), merged_into_id = $1, version = version + 1
+			where id = $2 returning ` + patientSelectColumns
+		archived, err := scanPatient(tx.QueryRowContext(ctx, stmt, primaryID, duplicateID), s.cipher)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		primary = locked[primaryID]
+		if err := s.publish(ctx, tx, outbox.PatientUpdated, primaryID, primary); err != nil {
+			return nil, nil, err
+		}
+
+		return locked[duplicateID], archived, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return primary, nil
+}
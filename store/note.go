@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+)
+
+// Note is a free-text clinical note about a patient.
+type Note struct {
+	ID        int       `json:"id"`
+	PatientID int       `json:"patientId"`
+	Body      string    `json:"body"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// NoteRepository reads and adds the notes of patients. Additions are
+// recorded in the audit log of the patient as performed by actor.
+type NoteRepository interface {
+	// Add adds note to a patient who is not deleted and sets its ID,
+	// CreatedBy and CreatedAt, returning ErrNotFound when there is no such
+	// patient.
+	Add(ctx context.Context, actor string, note *Note) error
+	// ListByPatients returns the notes of many patients grouped by patient
+	// id, oldest first.
+	ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*Note, error)
+}
+
+const noteColumns = "id, patient_id, body, created_by, created_at"
+
+// NoteStore is the Postgres NoteRepository.
+type NoteStore struct {
+	db    *sql.DB
+	audit *audit.AuditLogger
+}
+
+func NewNoteStore(db *sql.DB, auditLogger *audit.AuditLogger) *NoteStore {
+	return &NoteStore{db: db, audit: auditLogger}
+}
+
+func (s *NoteStore) Add(ctx context.Context, actor string, note *Note) error {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		stmt := `insert into patient_notes(patient_id, body, created_by, clinic_id)
+			select id, $2::text, $3::text, clinic_id from patients
+			where id = $1 and deleted_at is null and ` + inClinic("clinic_id", 4) + ` returning id, created_at`
+		err := tx.QueryRowContext(ctx, stmt, note.PatientID, note.Body, actor, clinic).Scan(&note.ID, &note.CreatedAt)
+		if err != nil {
+			return err
+		}
+		note.CreatedBy = actor
+
+		return s.audit.Log(ctx, tx, "add_note", note.PatientID, actor, nil, note)
+	})
+
+	return notFound(err)
+}
+
+func (s *NoteStore) ListByPatients(ctx context.Context, patientIDs []int) (map[int][]*Note, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx,
+		"select "+noteColumns+" from patient_notes where patient_id = any($1) and "+inClinic("clinic_id", 2)+" order by created_at, id",
+		pq.Array(patientIDs), clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byPatient := make(map[int][]*Note, len(patientIDs))
+	for rows.Next() {
+		note := &Note{}
+
+		if err := rows.Scan(&note.ID, &note.PatientID, &note.Body, &note.CreatedBy, &note.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		byPatient[note.PatientID] = append(byPatient[note.PatientID], note)
+	}
+
+	return byPatient, rows.Err()
+}
@@ -0,0 +1,840 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/encryption"
+	"github.com/codixir/smart-emerge-starter/outbox"
+	"github.com/codixir/smart-emerge-starter/utils"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+type Patient struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	Phone     string     `json:"phone"`
+	DeletedAt *time.Time `json:"deletedAt"`
+	// Version starts at 1 and goes up with every change to the patient.
+	Version int `json:"version"`
+	// MergedIntoID is the patient this one was merged into as a duplicate,
+	// which also soft-deleted it.
+	MergedIntoID *int `json:"mergedIntoId"`
+	ClinicID     int  `json:"clinicId"`
+	// ErasedAt is when the patient was anonymized by Erase, which also
+	// soft-deleted it.
+	ErasedAt *time.Time `json:"erasedAt"`
+	Demographics
+
+	// sealedDemographics is the demographics column as scanned, until
+	// unsealPatient decodes it into Demographics.
+	sealedDemographics string
+}
+
+// PatientFilter narrows a patient listing. Name, Email and Phone match as
+// case-insensitive substrings and EmailEquals matches the whole address,
+// ignoring case. When emails and phones are encrypted, Email and Phone match
+// whole values too. SharingConsent, a consent scope, keeps only the patients
+// with a data sharing consent in effect covering it. Empty fields are not
+// filtered on.
+type PatientFilter struct {
+	Name           string `json:"name"`
+	Email          string `json:"email"`
+	Phone          string `json:"phone"`
+	EmailEquals    string `json:"emailEquals"`
+	SharingConsent string `json:"sharingConsent,omitempty"`
+}
+
+// ListOptions selects a page of patients. SortBy is one of id, name or email
+// and SortOrder is asc or desc; both default to id ascending.
+type ListOptions struct {
+	Filter         PatientFilter
+	IncludeDeleted bool
+	SortBy         string
+	SortOrder      string
+	Limit          int
+	Offset         int
+}
+
+// StatusCount is how many patients have a status: active, or deleted for
+// the soft-deleted ones.
+type StatusCount struct {
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// PatientChanges holds the fields of a partial update. Nil fields keep their
+// stored value, and the others replace it: an empty StructuredName, Gender,
+// PreferredLanguage or list, or a zero BirthDate, clears it. When Version is
+// set the update only applies to the patient at that version.
+type PatientChanges struct {
+	Name              *string
+	Email             *string
+	Phone             *string
+	StructuredName    *HumanName
+	BirthDate         *time.Time
+	Gender            *string
+	PreferredLanguage *string
+	Addresses         *[]Address
+	Telecoms          *[]ContactPoint
+	Identifiers       *[]Identifier
+	Version           *int
+}
+
+// Empty reports whether c changes no field.
+func (c PatientChanges) Empty() bool {
+	return c == PatientChanges{Version: c.Version}
+}
+
+// PatientRepository reads and writes patients. Writes are recorded in the
+// audit log as performed by actor, in the same transaction as the change.
+// Methods only see the patients of the clinic the context acts for, and
+// return ErrNotFound when the patient does not exist there.
+type PatientRepository interface {
+	// Get returns a patient, or ErrNotFound when it is soft-deleted unless
+	// includeDeleted is set.
+	Get(ctx context.Context, id int, includeDeleted bool) (*Patient, error)
+	// GetMany returns the patients of ids who are not soft-deleted, by id,
+	// with one query. Patients that do not exist are left out.
+	GetMany(ctx context.Context, ids []int) (map[int]*Patient, error)
+	// List returns a page of patients and the number of patients matching
+	// the filter across all pages.
+	List(ctx context.Context, opts ListOptions) ([]*Patient, int, error)
+	// StatusSummary counts the patients by status, in the order of their
+	// status, leaving out the statuses no patient has.
+	StatusSummary(ctx context.Context) ([]*StatusCount, error)
+	// Each calls fn with every patient matching opts, in its order, as they
+	// are read. Limit and Offset are ignored. It stops at the first error
+	// from fn.
+	Each(ctx context.Context, opts ListOptions, fn func(*Patient) error) error
+	// Upsert creates the patients whose email is not known yet and updates
+	// the others, in one transaction. The results are in the order of
+	// patients, and a row that fails only fails its own result.
+	Upsert(ctx context.Context, actor string, patients []*Patient) ([]UpsertResult, error)
+	// CompleteImport publishes the outcome of an import whose rows were all
+	// upserted.
+	CompleteImport(ctx context.Context, summary ImportSummary) error
+	// Search returns up to limit patients who are not soft-deleted and
+	// match term by name, email or phone, the best matches first.
+	Search(ctx context.Context, term string, limit int) ([]*PatientMatch, error)
+	// Create creates a patient with demographics besides its name, email
+	// and phone.
+	Create(ctx context.Context, actor, name, email, phone string, demographics Demographics) (*Patient, error)
+	// Update applies changes to a patient. When the patient is not at the
+	// expected version it fails with a VERSION_CONFLICT *utils.CodedError
+	// holding the stored patient as the current detail.
+	Update(ctx context.Context, actor string, id int, changes PatientChanges) (*Patient, error)
+	// Delete soft-deletes a patient and returns it.
+	Delete(ctx context.Context, actor string, id int) (*Patient, error)
+	// Restore brings back a soft-deleted patient, returning ErrNotFound when
+	// it does not exist or is not deleted. Patients archived by Merge or
+	// anonymized by Erase cannot be restored.
+	Restore(ctx context.Context, actor string, id int) (*Patient, error)
+	// Purge permanently removes a soft-deleted patient with its appointments,
+	// encounters, care team memberships, emergency contacts, notes,
+	// insurance policies, consents, documents and duplicate reports,
+	// returning the removed patient or ErrNotFound when it does not exist or
+	// is not deleted.
+	Purge(ctx context.Context, actor string, id int) (*Patient, error)
+	// FindDuplicates returns up to limit pairs of patients who are not
+	// soft-deleted, share an email or phone number and have names at least
+	// minSimilarity alike, from 0 to 1, the most similar first.
+	FindDuplicates(ctx context.Context, minSimilarity float64, limit int) ([]*DuplicateCandidate, error)
+	// Merge moves the records of the duplicate patient to the primary one,
+	// archives the duplicate and returns the primary. Both must exist and
+	// not be soft-deleted.
+	Merge(ctx context.Context, actor string, primaryID, duplicateID int) (*Patient, error)
+	// Erase anonymizes a patient and the duplicates merged into it on their
+	// request, recording who did so and why, and returns the anonymized
+	// patients, the one with id first. Erasing a patient twice fails with a
+	// BAD_USER_INPUT *utils.CodedError.
+	Erase(ctx context.Context, actor string, id int, justification string) ([]*Patient, error)
+	// Changes returns up to limit changes to the patients after the change
+	// at since, in the order they were made, from the change feed every
+	// mutation of a patient adds to. Changes are only read once they can no
+	// longer be preceded by changes still to commit, so paging through the
+	// feed with the cursor of the last change read misses none.
+	Changes(ctx context.Context, since ChangeCursor, limit int) ([]*PatientChangeEvent, error)
+}
+
+// patientSelectColumns lists the columns read by scanPatient, in order.
+const patientSelectColumns = "id, name, email, phone, deleted_at, version, merged_into_id, clinic_id, erased_at, " +
+	"name_prefix, given_names, family_name, name_suffix, birth_date, gender, preferred_language, demographics"
+
+// qualifiedPatientColumns returns patientSelectColumns qualified with alias,
+// for queries joining patients with other tables.
+func qualifiedPatientColumns(alias string) string {
+	columns := strings.Split(patientSelectColumns, ", ")
+	for i := range columns {
+		columns[i] = alias + "." + columns[i]
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// fields returns the scan destinations of patientSelectColumns, in order.
+func (p *Patient) fields() []interface{} {
+	return []interface{}{&p.ID, &p.Name, &p.Email, &p.Phone, &p.DeletedAt, &p.Version, &p.MergedIntoID, &p.ClinicID, &p.ErasedAt,
+		&p.StructuredName.Prefix, pq.Array(&p.StructuredName.Given), &p.StructuredName.Family, &p.StructuredName.Suffix,
+		&p.BirthDate, &p.Gender, &p.PreferredLanguage, &p.sealedDemographics}
+}
+
+// scanPatient reads a row selected with patientSelectColumns, decrypting it
+// with c.
+func scanPatient(row rowScanner, c *encryption.Cipher) (*Patient, error) {
+	patient := &Patient{}
+
+	if err := row.Scan(patient.fields()...); err != nil {
+		return nil, err
+	}
+
+	if err := unsealPatient(c, patient); err != nil {
+		return nil, err
+	}
+
+	return patient, nil
+}
+
+// PatientStore is the Postgres PatientRepository. With a cipher, emails and
+// phone numbers are stored encrypted and looked up by their blind indexes,
+// so the email and phone filters match whole values rather than parts, and
+// patients cannot be sorted by email.
+type PatientStore struct {
+	db     *sql.DB
+	audit  *audit.AuditLogger
+	events *outbox.Outbox
+	cipher *encryption.Cipher
+
+	// replica runs the listing, search and duplicate queries when it is not
+	// nil.
+	replica *Replica
+
+	// explainCostThreshold is the query plan cost above which listing
+	// queries log a warning. Zero disables the EXPLAIN check.
+	explainCostThreshold float64
+}
+
+// NewPatientStore returns a PatientStore reading lists, search results and
+// duplicate candidates from replica, nil to read everything from db, writing audit entries with
+// auditLogger, lifecycle events to events, nil to publish none, and
+// encrypting PHI with cipher, nil to store it in plaintext. Listing queries
+// whose estimated plan cost exceeds explainCostThreshold log a warning; pass
+// 0 to skip the check.
+func NewPatientStore(db *sql.DB, replica *Replica, auditLogger *audit.AuditLogger, events *outbox.Outbox, cipher *encryption.Cipher, explainCostThreshold float64) *PatientStore {
+	return &PatientStore{db: db, replica: replica, audit: auditLogger, events: events, cipher: cipher, explainCostThreshold: explainCostThreshold}
+}
+
+func (s *PatientStore) Get(ctx context.Context, id int, includeDeleted bool) (*Patient, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := "select " + patientSelectColumns + " from patients where id=$1 and " + inClinic("clinic_id", 2)
+	if !includeDeleted {
+		stmt += " and deleted_at is null"
+	}
+
+	patient, err := scanPatient(conn(ctx, s.db).QueryRowContext(ctx, stmt, id, clinic), s.cipher)
+	return patient, notFound(err)
+}
+
+func (s *PatientStore) GetMany(ctx context.Context, ids []int) (map[int]*Patient, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx,
+		"select "+patientSelectColumns+" from patients where id = any($1) and "+inClinic("clinic_id", 2)+" and deleted_at is null",
+		pq.Array(ids), clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	patients := make(map[int]*Patient, len(ids))
+	for rows.Next() {
+		patient, err := scanPatient(rows, s.cipher)
+		if err != nil {
+			return nil, err
+		}
+
+		patients[patient.ID] = patient
+	}
+
+	return patients, rows.Err()
+}
+
+func (s *PatientStore) List(ctx context.Context, opts ListOptions) ([]*Patient, int, error) {
+	orderBy, err := patientOrderClause(opts.SortBy, opts.SortOrder, s.cipher.Enabled())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	where, args := patientFilterClause(opts.Filter, opts.IncludeDeleted, clinic, s.cipher)
+
+	var total int
+	err = readConn(ctx, s.db, s.replica).QueryRowContext(ctx, "select count(*) from patients"+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stmt := fmt.Sprintf("select %s from patients%s%s limit $%d offset $%d",
+		patientSelectColumns, where, orderBy, len(args)+1, len(args)+2)
+	args = append(args, opts.Limit, opts.Offset)
+	s.warnOnHighCost(ctx, stmt, args...)
+
+	rows, err := readConn(ctx, s.db, s.replica).QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	patients := []*Patient{}
+	for rows.Next() {
+		patient, err := scanPatient(rows, s.cipher)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		patients = append(patients, patient)
+	}
+
+	return patients, total, rows.Err()
+}
+
+// statusSummaryStmt counts the patients of the clinic of $1 by status.
+var statusSummaryStmt = `select case when deleted_at is not null then 'deleted' else 'active' end as status, count(*)
+	from patients where ` + inClinic("clinic_id", 1) + ` group by 1 order by 1`
+
+func (s *PatientStore) StatusSummary(ctx context.Context) ([]*StatusCount, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := readConn(ctx, s.db, s.replica).QueryContext(ctx, statusSummaryStmt, clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []*StatusCount{}
+	for rows.Next() {
+		count := &StatusCount{}
+		if err := rows.Scan(&count.Status, &count.Count); err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+func (s *PatientStore) Each(ctx context.Context, opts ListOptions, fn func(*Patient) error) error {
+	orderBy, err := patientOrderClause(opts.SortBy, opts.SortOrder, s.cipher.Enabled())
+	if err != nil {
+		return err
+	}
+
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return err
+	}
+
+	where, args := patientFilterClause(opts.Filter, opts.IncludeDeleted, clinic, s.cipher)
+
+	rows, err := readConn(ctx, s.db, s.replica).QueryContext(ctx, "select "+patientSelectColumns+" from patients"+where+orderBy, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		patient, err := scanPatient(rows, s.cipher)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(patient); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *PatientStore) Create(ctx context.Context, actor, name, email, phone string, demographics Demographics) (*Patient, error) {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.audited(ctx, actor, "create", func(tx *sql.Tx) (*Patient, *Patient, error) {
+		patient := &Patient{Name: name, Email: email, Phone: phone, ClinicID: clinicID, Demographics: demographics}
+		return nil, patient, s.insert(ctx, tx, patient)
+	})
+}
+
+// insert inserts patient into its clinic, setting its id and version.
+func (s *PatientStore) insert(ctx context.Context, tx *sql.Tx, patient *Patient) error {
+	sealed, err := sealPatient(s.cipher, patient.Email, patient.Phone)
+	if err != nil {
+		return err
+	}
+
+	sealedDemographics, err := sealDemographics(s.cipher, patient.Demographics)
+	if err != nil {
+		return err
+	}
+
+	n := patient.StructuredName
+	stmt := `insert into patients(name, email, phone, email_index, phone_index, phone_last4_index, clinic_id,
+			name_prefix, given_names, family_name, name_suffix, birth_date, gender, preferred_language, demographics)
+		values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) returning id, version`
+	return tx.QueryRowContext(ctx, stmt, patient.Name, sealed.email, sealed.phone,
+		sealed.emailIndex, sealed.phoneIndex, sealed.phoneLast4Index, patient.ClinicID,
+		n.Prefix, pq.Array(nonNil(n.Given)), n.Family, n.Suffix, patient.BirthDate, patient.Gender,
+		patient.PreferredLanguage, sealedDemographics).Scan(&patient.ID, &patient.Version)
+}
+
+func (s *PatientStore) Update(ctx context.Context, actor string, id int, changes PatientChanges) (*Patient, error) {
+	if changes.Empty() {
+		return nil, fmt.Errorf("update needs at least one field to change")
+	}
+
+	return s.audited(ctx, actor, "update", func(tx *sql.Tx) (*Patient, *Patient, error) {
+		before, err := lockPatient(ctx, tx, id, false, s.cipher)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if changes.Version != nil && before.Version != *changes.Version {
+			return nil, nil, &utils.CodedError{
+				Code: "VERSION_CONFLICT",
+				Message: fmt.Sprintf("patient %d was changed since version %d and is now at version %d",
+					id, *changes.Version, before.Version),
+				Details: map[string]interface{}{"current": before},
+			}
+		}
+
+		set, args, err := patientUpdateClause(changes, before.Demographics, s.cipher)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		stmt := fmt.Sprintf("update patients set %s, version = version + 1 where id = $%d returning %s",
+			set, len(args)+1, patientSelectColumns)
+
+		after, err := scanPatient(tx.QueryRowContext(ctx, stmt, append(args, id)...), s.cipher)
+		return before, after, err
+	})
+}
+
+func (s *PatientStore) Delete(ctx context.Context, actor string, id int) (*Patient, error) {
+	return s.audited(ctx, actor, "delete", func(tx *sql.Tx) (*Patient, *Patient, error) {
+		before, err := lockPatient(ctx, tx, id, false, s.cipher)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		stmt := "update patients set deleted_at = now(), version = version + 1 where id = $1 returning " + patientSelectColumns
+		after, err := scanPatient(tx.QueryRowContext(ctx, stmt, id), s.cipher)
+		return before, after, err
+	})
+}
+
+func (s *PatientStore) Restore(ctx context.Context, actor string, id int) (*Patient, error) {
+	return s.audited(ctx, actor, "restore", func(tx *sql.Tx) (*Patient, *Patient, error) {
+		before, err := lockPatient(ctx, tx, id, true, s.cipher)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if before.ErasedAt != nil {
+			return nil, nil, &utils.CodedError{
+				Code:    "BAD_USER_INPUT",
+				Message: fmt.Sprintf("patient %d was erased and cannot be restored", id),
+			}
+		}
+
+		if before.MergedIntoID != nil {
+			return nil, nil, &utils.CodedError{
+				Code:    "BAD_USER_INPUT",
+				Message: fmt.Sprintf("patient %d was merged into patient %d and cannot be restored", id, *before.MergedIntoID),
+			}
+		}
+
+		stmt := "update patients set deleted_at = null, version = version + 1 where id = $1 returning " + patientSelectColumns
+		after, err := scanPatient(tx.QueryRowContext(ctx, stmt, id), s.cipher)
+		return before, after, err
+	})
+}
+
+// Purge keeps the audit entries of the patient, and records the purge with
+// the removed patient as the old value.
+func (s *PatientStore) Purge(ctx context.Context, actor string, id int) (*Patient, error) {
+	var purged *Patient
+
+	_, err := s.audited(ctx, actor, "purge", func(tx *sql.Tx) (*Patient, *Patient, error) {
+		before, err := lockPatient(ctx, tx, id, true, s.cipher)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, stmt := range queueDocumentDeletions {
+			if _, err := tx.ExecContext(ctx, stmt, pq.Array([]int{id})); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		for _, stmt := range []string{
+			"delete from appointments where patient_id = $1",
+			"delete from care_team_members where patient_id = $1",
+			"delete from emergency_contacts where patient_id = $1",
+			"delete from patient_notes where patient_id = $1",
+			"delete from insurance_policies where patient_id = $1",
+			"delete from consents where patient_id = $1",
+			"delete from encounter_revisions where encounter_id in (select id from encounters where patient_id = $1)",
+			"delete from encounters where patient_id = $1",
+			"delete from duplicate_reports where reported_patient_id = $1 or suspected_duplicate_id = $1",
+			"delete from patients where id = $1",
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		purged = before
+		return before, nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return purged, nil
+}
+
+// lockPatient selects a patient of the clinic of ctx for update within tx,
+// decrypting it with c. deleted chooses between active and soft-deleted
+// patients; sql.ErrNoRows is returned when there is no matching patient.
+// Statements changing the locked patient by id stay in its clinic.
+func lockPatient(ctx context.Context, tx *sql.Tx, id int, deleted bool, c *encryption.Cipher) (*Patient, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := "select " + patientSelectColumns + " from patients where id = $1 and deleted_at is null and " + inClinic("clinic_id", 2) + " for update"
+	if deleted {
+		stmt = "select " + patientSelectColumns + " from patients where id = $1 and deleted_at is not null and " + inClinic("clinic_id", 2) + " for update"
+	}
+
+	return scanPatient(tx.QueryRowContext(ctx, stmt, id, clinic), c)
+}
+
+// lifecycleEvents are the outbox events of the audited operations. A purge
+// publishes nothing, the patient's deletion having been published already.
+var lifecycleEvents = map[string]string{
+	"create":  outbox.PatientCreated,
+	"update":  outbox.PatientUpdated,
+	"restore": outbox.PatientUpdated,
+	"delete":  outbox.PatientDeleted,
+	"merge":   outbox.PatientDeleted,
+	"erase":   outbox.PatientErased,
+}
+
+// audited runs fn in a transaction and records the patient before and after
+// the operation in the audit log as part of the same transaction, so neither
+// is kept without the other, along with the lifecycle event of the
+// operation in the outbox and the change feed. The patient after the
+// operation is returned.
+func (s *PatientStore) audited(ctx context.Context, actor, operation string, fn func(tx *sql.Tx) (before, after *Patient, err error)) (*Patient, error) {
+	var result *Patient
+
+	err := audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		before, after, err := fn(tx)
+		if err != nil {
+			return err
+		}
+
+		var oldValue, newValue interface{}
+		patientID := 0
+		if before != nil {
+			oldValue, patientID = before, before.ID
+		}
+		if after != nil {
+			newValue, patientID = after, after.ID
+		}
+
+		result = after
+		if err := s.audit.Log(ctx, tx, operation, patientID, actor, oldValue, newValue); err != nil {
+			return err
+		}
+
+		if eventType, ok := lifecycleEvents[operation]; ok {
+			return s.publish(ctx, tx, eventType, patientID, after)
+		}
+		if operation == "purge" {
+			return recordChange(ctx, tx, PatientPurged, patientID)
+		}
+		return nil
+	})
+
+	return result, notFound(err)
+}
+
+// patientFilterClause builds a WHERE clause from the non-empty fields of
+// filter, combined with AND, limited to the clinic of the clinicScope
+// argument clinic. Soft-deleted patients are excluded unless includeDeleted
+// is set. When c is enabled the email and phone filters compare blind
+// indexes, matching whole values only; the others match parts of the value,
+// with % and _ taken literally. Placeholders are numbered from 1 and the
+// values are returned in order.
+func patientFilterClause(filter PatientFilter, includeDeleted bool, clinic interface{}, c *encryption.Cipher) (string, []interface{}) {
+	conditions := []string{inClinic("clinic_id", 1)}
+	args := []interface{}{clinic}
+
+	if !includeDeleted {
+		conditions = append(conditions, "deleted_at is null")
+	}
+
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Name != "" {
+		add(`name ILIKE '%%' || $%d || '%%' escape '\'`, escapeLike(filter.Name))
+	}
+
+	if !c.Enabled() {
+		if filter.Email != "" {
+			add(`email ILIKE '%%' || $%d || '%%' escape '\'`, escapeLike(filter.Email))
+		}
+		if filter.Phone != "" {
+			add(`phone ILIKE '%%' || $%d || '%%' escape '\'`, escapeLike(filter.Phone))
+		}
+		if filter.EmailEquals != "" {
+			add("lower(email) = lower($%d)", filter.EmailEquals)
+		}
+	} else {
+		for _, email := range []string{filter.Email, filter.EmailEquals} {
+			if email != "" {
+				add("email_index = $%d", *emailIndex(c, email))
+			}
+		}
+		if filter.Phone != "" {
+			add("phone_index = $%d", *phoneIndex(c, filter.Phone))
+		}
+	}
+
+	if filter.SharingConsent != "" {
+		add(`exists (select 1 from consents where consents.patient_id = patients.id
+			and consents.consent_type = '`+ConsentDataSharing+`' and consents.revoked_at is null
+			and consents.scope in ('`+ConsentScopeAll+`', $%d))`, filter.SharingConsent)
+	}
+
+	return " where " + strings.Join(conditions, " and "), args
+}
+
+// patientSortColumns is the allowlist of columns patients can be sorted by.
+// Sort options are only ever mapped through it, never put into SQL as is.
+var patientSortColumns = map[string]string{
+	"":      "id",
+	"id":    "id",
+	"name":  "name",
+	"email": "email",
+}
+
+// CheckSort returns an error when patients cannot be sorted by sortBy in
+// sortOrder, as List and Each would. Sorting by email is accepted here and
+// only rejected by a PatientStore that encrypts emails.
+func CheckSort(sortBy, sortOrder string) error {
+	_, err := patientOrderClause(sortBy, sortOrder, false)
+	return err
+}
+
+// patientOrderClause builds the ORDER BY clause of a patient listing. Ties
+// are broken by id so pages stay stable. Encrypted emails sort in no useful
+// order, so sorting by email fails with a *utils.CodedError when encrypted
+// is set.
+func patientOrderClause(sortBy, sortOrder string, encrypted bool) (string, error) {
+	column, ok := patientSortColumns[sortBy]
+	if !ok {
+		return "", fmt.Errorf("cannot sort patients by %q", sortBy)
+	}
+
+	if encrypted && column == "email" {
+		return "", &utils.CodedError{
+			Code:    "BAD_USER_INPUT",
+			Message: "patients cannot be sorted by email while emails are encrypted",
+		}
+	}
+
+	direction := "asc"
+	switch sortOrder {
+	case "", "asc":
+	case "desc":
+		direction = "desc"
+	default:
+		return "", fmt.Errorf("sort order must be asc or desc, got %q", sortOrder)
+	}
+
+	clause := " order by " + column + " " + direction
+	if column != "id" {
+		clause += ", id " + direction
+	}
+
+	return clause, nil
+}
+
+// patientUpdateClause builds the SET clause of a partial patient update from
+// the non-nil fields of changes, so omitted fields keep their stored value.
+// Emails and phones are sealed with c along with their blind indexes, and
+// changed addresses, contact points or identifiers with the others of
+// stored, the demographics of the patient as they are, as they share a
+// column. Placeholders are numbered from 1.
+func patientUpdateClause(changes PatientChanges, stored Demographics, c *encryption.Cipher) (string, []interface{}, error) {
+	var assignments []string
+	var args []interface{}
+
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if changes.Name != nil {
+		set("name", *changes.Name)
+	}
+
+	if changes.Email != nil {
+		email, err := c.Encrypt(emailField, *changes.Email)
+		if err != nil {
+			return "", nil, err
+		}
+
+		set("email", email)
+		set("email_index", emailIndex(c, *changes.Email))
+	}
+
+	if changes.Phone != nil {
+		phone, err := c.Encrypt(phoneField, *changes.Phone)
+		if err != nil {
+			return "", nil, err
+		}
+
+		set("phone", phone)
+		set("phone_index", phoneIndex(c, *changes.Phone))
+		set("phone_last4_index", phoneLast4Index(c, PhoneDigits(validation.NormalizePhone(*changes.Phone))))
+	}
+
+	if n := changes.StructuredName; n != nil {
+		set("name_prefix", n.Prefix)
+		set("given_names", pq.Array(nonNil(n.Given)))
+		set("family_name", n.Family)
+		set("name_suffix", n.Suffix)
+	}
+
+	if changes.BirthDate != nil {
+		var birthDate *time.Time
+		if !changes.BirthDate.IsZero() {
+			birthDate = changes.BirthDate
+		}
+
+		set("birth_date", birthDate)
+	}
+
+	if changes.Gender != nil {
+		set("gender", *changes.Gender)
+	}
+
+	if changes.PreferredLanguage != nil {
+		set("preferred_language", *changes.PreferredLanguage)
+	}
+
+	if changes.Addresses != nil || changes.Telecoms != nil || changes.Identifiers != nil {
+		changes.ApplyDemographics(&stored)
+
+		demographics, err := sealDemographics(c, stored)
+		if err != nil {
+			return "", nil, err
+		}
+
+		set("demographics", demographics)
+	}
+
+	return strings.Join(assignments, ", "), args, nil
+}
+
+// nonNil returns values, or an empty slice when it is nil, for the NOT NULL
+// array columns.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+
+	return values
+}
+
+// explainCost runs EXPLAIN on the given statement and returns the planner's
+// total cost estimate for it.
+func (s *PatientStore) explainCost(ctx context.Context, stmt string, args ...interface{}) (float64, error) {
+	var planJSON string
+
+	err := conn(ctx, s.db).QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+stmt, args...).Scan(&planJSON)
+	if err != nil {
+		return 0, err
+	}
+
+	var plans []struct {
+		Plan struct {
+			TotalCost float64 `json:"Total Cost"`
+		} `json:"Plan"`
+	}
+
+	if err := json.Unmarshal([]byte(planJSON), &plans); err != nil {
+		return 0, err
+	}
+
+	if len(plans) == 0 {
+		return 0, fmt.Errorf("empty query plan")
+	}
+
+	return plans[0].Plan.TotalCost, nil
+}
+
+// warnOnHighCost logs a warning when the plan cost of stmt exceeds
+// explainCostThreshold. Failures to explain are logged and otherwise ignored.
+func (s *PatientStore) warnOnHighCost(ctx context.Context, stmt string, args ...interface{}) {
+	if s.explainCostThreshold <= 0 {
+		return
+	}
+
+	cost, err := s.explainCost(ctx, stmt, args...)
+	if err != nil {
+		slog.WarnContext(ctx, "could not explain query", "statement", stmt, "error", err)
+		return
+	}
+
+	if cost > s.explainCostThreshold {
+		slog.WarnContext(ctx, fmt.Sprintf("high cost query plan (%.2f > %.2f)", cost, s.explainCostThreshold),
+			"cost", cost, "threshold", s.explainCostThreshold, "statement", stmt)
+	}
+}
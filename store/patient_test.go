@@ -0,0 +1,449 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/encryption"
+	"github.com/codixir/smart-emerge-starter/tenant"
+)
+
+// captureLogs sends the default logger to the returned buffer for the rest
+// of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	return &logs
+}
+
+func TestListWarnsOnHighCost(t *testing.T) {
+	tests := []struct {
+		name       string
+		threshold  float64
+		explainErr error
+		explained  bool
+		want       []string
+	}{
+		{name: "disabled", threshold: 0},
+		{name: "below the threshold", threshold: 1000, explained: true},
+		{name: "above the threshold", threshold: 10, explained: true, want: []string{"high cost", `"cost":42.5`, "42.50 > 10.00"}},
+		{name: "explain fails", threshold: 10, explainErr: errors.New("explain failed"), explained: true, want: []string{"could not explain query", "explain failed"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+
+			explained := false
+			db := stubDB(t, func(query string, args []driver.NamedValue) stubResult {
+				switch {
+				case strings.HasPrefix(query, "EXPLAIN (FORMAT JSON) select "):
+					explained = true
+					if tt.explainErr != nil {
+						return stubResult{Err: tt.explainErr}
+					}
+					return stubResult{Columns: []string{"QUERY PLAN"}, Rows: [][]driver.Value{{`[{"Plan": {"Node Type": "Seq Scan", "Total Cost": 42.5}}]`}}}
+				case strings.HasPrefix(query, "select count(*)"):
+					return stubResult{Columns: []string{"count"}, Rows: [][]driver.Value{{int64(0)}}}
+				default:
+					return stubResult{Columns: []string{"id"}}
+				}
+			})
+
+			s := NewPatientStore(db, nil, nil, nil, nil, tt.threshold)
+			if _, _, err := s.List(tenant.WithClinic(context.Background(), 1), ListOptions{Limit: 10}); err != nil {
+				t.Fatal(err)
+			}
+
+			if explained != tt.explained {
+				t.Errorf("explained = %v, want %v", explained, tt.explained)
+			}
+
+			if len(tt.want) == 0 && logs.Len() > 0 {
+				t.Errorf("logged %s, want nothing", logs)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("logs %s do not contain %s", logs, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPatientFilterClause(t *testing.T) {
+	const clinic = " where ($1::integer is null or clinic_id = $1) and deleted_at is null"
+
+	tests := []struct {
+		name   string
+		filter PatientFilter
+		where  string
+		args   []interface{}
+	}{
+		{"no filter", PatientFilter{}, clinic, []interface{}{1}},
+		{"name", PatientFilter{Name: "ann"}, clinic + " and name ILIKE '%' || $2 || '%' escape '\\'", []interface{}{1, "ann"}},
+		{"email", PatientFilter{Email: "example"}, clinic + " and email ILIKE '%' || $2 || '%' escape '\\'", []interface{}{1, "example"}},
+		{"phone", PatientFilter{Phone: "555"}, clinic + " and phone ILIKE '%' || $2 || '%' escape '\\'", []interface{}{1, "555"}},
+		{
+			"name and email", PatientFilter{Name: "ann", Email: "example"},
+			clinic + " and name ILIKE '%' || $2 || '%' escape '\\' and email ILIKE '%' || $3 || '%' escape '\\'",
+			[]interface{}{1, "ann", "example"},
+		},
+		{
+			"name and phone", PatientFilter{Name: "ann", Phone: "555"},
+			clinic + " and name ILIKE '%' || $2 || '%' escape '\\' and phone ILIKE '%' || $3 || '%' escape '\\'",
+			[]interface{}{1, "ann", "555"},
+		},
+		{
+			"email and phone", PatientFilter{Email: "example", Phone: "555"},
+			clinic + " and email ILIKE '%' || $2 || '%' escape '\\' and phone ILIKE '%' || $3 || '%' escape '\\'",
+			[]interface{}{1, "example", "555"},
+		},
+		{
+			"name, email and phone", PatientFilter{Name: "ann", Email: "example", Phone: "555"},
+			clinic + " and name ILIKE '%' || $2 || '%' escape '\\' and email ILIKE '%' || $3 || '%' escape '\\' and phone ILIKE '%' || $4 || '%' escape '\\'",
+			[]interface{}{1, "ann", "example", "555"},
+		},
+		{"whole email", PatientFilter{EmailEquals: "Ann@Example.com"}, clinic + " and lower(email) = lower($2)", []interface{}{1, "Ann@Example.com"}},
+		{
+			"wildcards", PatientFilter{Name: `100%_a\b`, Email: "_@"},
+			clinic + ` and name ILIKE '%' || $2 || '%' escape '\' and email ILIKE '%' || $3 || '%' escape '\'`,
+			[]interface{}{1, `100\%\_a\\b`, `\_@`},
+		},
+		{
+			"injection attempt", PatientFilter{Name: "'; drop table patients; --"},
+			clinic + " and name ILIKE '%' || $2 || '%' escape '\\'",
+			[]interface{}{1, "'; drop table patients; --"},
+		},
+		{
+			"sharing consent", PatientFilter{SharingConsent: ConsentScopeFHIR},
+			clinic + ` and exists (select 1 from consents where consents.patient_id = patients.id
+			and consents.consent_type = 'data_sharing' and consents.revoked_at is null
+			and consents.scope in ('all', $2))`,
+			[]interface{}{1, ConsentScopeFHIR},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := patientFilterClause(tt.filter, false, 1, nil)
+
+			if where != tt.where {
+				t.Errorf("where = %q, want %q", where, tt.where)
+			}
+			if fmt.Sprint(args) != fmt.Sprint(tt.args) {
+				t.Errorf("args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestPatientFilterClauseEncrypted(t *testing.T) {
+	c, err := encryption.New([]encryption.Key{{ID: "k1", Secret: bytes.Repeat([]byte{1}, 32)}}, bytes.Repeat([]byte{2}, encryption.MinIndexKeyLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	where, args := patientFilterClause(PatientFilter{Name: "ann", Email: " Ann@Example.com", Phone: "+1 415 555 0100"}, true, nil, c)
+
+	want := " where ($1::integer is null or clinic_id = $1) and name ILIKE '%' || $2 || '%' escape '\\' and email_index = $3 and phone_index = $4"
+	if where != want {
+		t.Errorf("where = %q, want %q", where, want)
+	}
+
+	wantArgs := []interface{}{nil, "ann", *emailIndex(c, "ann@example.com"), *phoneIndex(c, "+14155550100")}
+	if fmt.Sprint(args) != fmt.Sprint(wantArgs) {
+		t.Errorf("args = %v, want %v", args, wantArgs)
+	}
+}
+
+func TestPatientOrderClause(t *testing.T) {
+	tests := []struct {
+		name      string
+		sortBy    string
+		sortOrder string
+		encrypted bool
+		want      string
+		err       string
+	}{
+		{name: "id", sortBy: "id", want: " order by id asc"},
+		{name: "id desc", sortBy: "id", sortOrder: "desc", want: " order by id desc"},
+		{name: "name", sortBy: "name", sortOrder: "asc", want: " order by name asc, id asc"},
+		{name: "email desc", sortBy: "email", sortOrder: "desc", want: " order by email desc, id desc"},
+		{name: "unknown field", sortBy: "phone", err: `cannot sort patients by "phone"`},
+		{name: "injection", sortBy: "id; drop table patients", err: "cannot sort patients by"},
+		{name: "unknown order", sortBy: "id", sortOrder: "sideways", err: "sort order must be asc or desc"},
+		{name: "encrypted email", sortBy: "email", encrypted: true, err: "while emails are encrypted"},
+		{name: "encrypted name", sortBy: "name", encrypted: true, want: " order by name asc, id asc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := patientOrderClause(tt.sortBy, tt.sortOrder, tt.encrypted)
+			switch {
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("err = %v, want one containing %q", err, tt.err)
+			case tt.err == "" && err != nil:
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("clause = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListOrdersBeforeLimit(t *testing.T) {
+	var stmt string
+	db := stubDB(t, func(query string, args []driver.NamedValue) stubResult {
+		if strings.HasPrefix(query, "select count(*)") {
+			return stubResult{Columns: []string{"count"}, Rows: [][]driver.Value{{int64(0)}}}
+		}
+
+		stmt = query
+		return stubResult{Columns: []string{"id"}}
+	})
+
+	s := NewPatientStore(db, nil, nil, nil, nil, 0)
+	_, _, err := s.List(tenant.WithClinic(context.Background(), 1), ListOptions{Limit: 10, Offset: 20, SortBy: "name", SortOrder: "desc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(stmt, " order by name desc, id desc limit $2 offset $3") {
+		t.Errorf("query %q does not end with its order then its page", stmt)
+	}
+}
+
+func TestStatusSummary(t *testing.T) {
+	var stmt string
+	var args []driver.NamedValue
+	db := stubDB(t, func(query string, a []driver.NamedValue) stubResult {
+		stmt, args = query, a
+		return stubResult{Columns: []string{"status", "count"}, Rows: [][]driver.Value{{"active", int64(5)}, {"deleted", int64(3)}}}
+	})
+
+	s := NewPatientStore(db, nil, nil, nil, nil, 0)
+	counts, err := s.StatusSummary(tenant.WithClinic(context.Background(), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(counts) != 2 || *counts[0] != (StatusCount{"active", 5}) || *counts[1] != (StatusCount{"deleted", 3}) {
+		t.Errorf("StatusSummary = %v, want 5 active and 3 deleted", counts)
+	}
+	if !strings.Contains(stmt, "group by 1") || len(args) != 1 || args[0].Value != 1 {
+		t.Errorf("query %q with %v does not group the patients of clinic 1", stmt, args)
+	}
+}
+
+func TestPatientUpdateClause(t *testing.T) {
+	name, email, phone := "Ann Lee", "ann@example.com", "+14155550100"
+
+	tests := []struct {
+		name    string
+		changes PatientChanges
+		set     string
+		args    []interface{}
+	}{
+		{"nothing", PatientChanges{}, "", nil},
+		{"name", PatientChanges{Name: &name}, "name = $1", []interface{}{name}},
+		{"email", PatientChanges{Email: &email}, "email = $1, email_index = $2", []interface{}{email, (*string)(nil)}},
+		{
+			"phone", PatientChanges{Phone: &phone},
+			"phone = $1, phone_index = $2, phone_last4_index = $3",
+			[]interface{}{phone, (*string)(nil), (*string)(nil)},
+		},
+		{
+			"name and email", PatientChanges{Name: &name, Email: &email},
+			"name = $1, email = $2, email_index = $3",
+			[]interface{}{name, email, (*string)(nil)},
+		},
+		{
+			"name and phone", PatientChanges{Name: &name, Phone: &phone},
+			"name = $1, phone = $2, phone_index = $3, phone_last4_index = $4",
+			[]interface{}{name, phone, (*string)(nil), (*string)(nil)},
+		},
+		{
+			"email and phone", PatientChanges{Email: &email, Phone: &phone},
+			"email = $1, email_index = $2, phone = $3, phone_index = $4, phone_last4_index = $5",
+			[]interface{}{email, (*string)(nil), phone, (*string)(nil), (*string)(nil)},
+		},
+		{
+			"name, email and phone", PatientChanges{Name: &name, Email: &email, Phone: &phone},
+			"name = $1, email = $2, email_index = $3, phone = $4, phone_index = $5, phone_last4_index = $6",
+			[]interface{}{name, email, (*string)(nil), phone, (*string)(nil), (*string)(nil)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, args, err := patientUpdateClause(tt.changes, Demographics{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if set != tt.set {
+				t.Errorf("set = %q, want %q", set, tt.set)
+			}
+			if fmt.Sprint(args) != fmt.Sprint(tt.args) {
+				t.Errorf("args = %v, want %v", args, tt.args)
+			}
+			if tt.changes.Empty() != (tt.set == "") {
+				t.Errorf("Empty() = %v with set %q", tt.changes.Empty(), set)
+			}
+		})
+	}
+}
+
+func TestPatientDelete(t *testing.T) {
+	deletedAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	row := func(deletedAt interface{}, version int64) []driver.Value {
+		return []driver.Value{int64(7), "Ann Lee", "ann@example.com", "+14155550100", deletedAt, version, nil, int64(1), nil,
+			"", []byte("{}"), "", "", nil, "", "", "{}"}
+	}
+	columns := strings.Split(patientSelectColumns, ", ")
+
+	tests := []struct {
+		name      string
+		locked    stubResult
+		updateErr error
+		// statements name the statements run, in order.
+		statements []string
+		err        error
+	}{
+		{
+			name:       "deleted",
+			locked:     stubResult{Columns: columns, Rows: [][]driver.Value{row(nil, 1)}},
+			statements: []string{"select", "update", "insert into audit_logs", "insert into patient_changes", "COMMIT"},
+		},
+		{
+			name:       "not found",
+			locked:     stubResult{Columns: columns},
+			statements: []string{"select", "ROLLBACK"},
+			err:        ErrNotFound,
+		},
+		{
+			name:       "update fails",
+			locked:     stubResult{Columns: columns, Rows: [][]driver.Value{row(nil, 1)}},
+			updateErr:  errors.New("connection reset"),
+			statements: []string{"select", "update", "ROLLBACK"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var statements []string
+			db := stubDB(t, func(query string, args []driver.NamedValue) stubResult {
+				switch {
+				case strings.HasPrefix(query, "insert into"):
+					table, _, _ := strings.Cut(strings.Fields(query)[2], "(")
+					statements = append(statements, "insert into "+table)
+					return stubResult{}
+				case strings.HasPrefix(query, "select"):
+					statements = append(statements, "select")
+					if !strings.Contains(query, "deleted_at is null") || !strings.HasSuffix(query, "for update") {
+						t.Errorf("the patient is read with %q, want it locked if not deleted", query)
+					}
+					return tt.locked
+				case strings.HasPrefix(query, "update patients set deleted_at = now()"):
+					statements = append(statements, "update")
+					if tt.updateErr != nil {
+						return stubResult{Err: tt.updateErr}
+					}
+					return stubResult{Columns: columns, Rows: [][]driver.Value{row(deletedAt, 2)}}
+				}
+
+				statements = append(statements, query)
+				return stubResult{}
+			})
+
+			s := NewPatientStore(db, nil, audit.NewAuditLogger(db, nil), nil, nil, 0)
+			patient, err := s.Delete(tenant.WithClinic(context.Background(), 1), "admin-1", 7)
+
+			switch {
+			case tt.updateErr != nil:
+				if !errors.Is(err, tt.updateErr) {
+					t.Fatalf("err = %v, want %v", err, tt.updateErr)
+				}
+			case err != tt.err:
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+
+			if fmt.Sprint(statements) != fmt.Sprint(tt.statements) {
+				t.Errorf("statements = %q, want %q", statements, tt.statements)
+			}
+
+			if tt.err != nil || tt.updateErr != nil {
+				return
+			}
+			if patient.ID != 7 || patient.Name != "Ann Lee" || patient.Email != "ann@example.com" || patient.Version != 2 ||
+				patient.DeletedAt == nil || !patient.DeletedAt.Equal(deletedAt) {
+				t.Errorf("deleted patient = %+v, want patient 7 deleted at %v", patient, deletedAt)
+			}
+		})
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	row := func(id int64, name string) []driver.Value {
+		return []driver.Value{id, name, strings.ToLower(strings.Fields(name)[0]) + "@example.com", "+14155550100", nil, int64(1), nil, int64(1), nil,
+			"", []byte("{}"), "", "", nil, "", "", "{}"}
+	}
+	columns := strings.Split(patientSelectColumns, ", ")
+
+	var query string
+	var args []driver.NamedValue
+	db := stubDB(t, func(q string, a []driver.NamedValue) stubResult {
+		query, args = q, a
+		// The rows come back in another order than the ids, and patient 8
+		// does not exist or is deleted.
+		return stubResult{Columns: columns, Rows: [][]driver.Value{row(7, "Ann Lee"), row(9, "Cy Day")}}
+	})
+
+	s := NewPatientStore(db, nil, nil, nil, nil, 0)
+	patients, err := s.GetMany(tenant.WithClinic(context.Background(), 1), []int{9, 8, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(query, "id = any($1)") || !strings.Contains(query, "deleted_at is null") {
+		t.Errorf("query = %q, want the patients of the ids who are not deleted", query)
+	}
+	if ids, err := args[0].Value.(driver.Valuer).Value(); err != nil || ids != "{9,8,7}" || fmt.Sprint(args[1].Value) != "1" {
+		t.Errorf("args = %v, %v, want the ids {9,8,7} in clinic 1", ids, args[1].Value)
+	}
+
+	if len(patients) != 2 || patients[7].Name != "Ann Lee" || patients[9].Name != "Cy Day" {
+		t.Errorf("patients = %v, want Ann Lee as 7 and Cy Day as 9", patients)
+	}
+	if _, ok := patients[8]; ok {
+		t.Error("patient 8 is returned, want it left out")
+	}
+}
+
+func TestGetManyNeedsClinic(t *testing.T) {
+	db := stubDB(t, func(query string, args []driver.NamedValue) stubResult {
+		t.Errorf("unexpected statement %q", query)
+		return stubResult{}
+	})
+
+	s := NewPatientStore(db, nil, nil, nil, nil, 0)
+	if _, err := s.GetMany(context.Background(), []int{1}); err == nil {
+		t.Error("err = nil, want one without a clinic")
+	}
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/encryption"
+	"github.com/codixir/smart-emerge-starter/validation"
+)
+
+// The PHI fields of patients, used as the associated data of their
+// encrypted values and to separate their blind indexes.
+const (
+	emailField        = "email"
+	phoneField        = "phone"
+	phoneLast4Field   = "phone_last4"
+	demographicsField = "demographics"
+)
+
+// sealedPatient holds the stored values of the PHI columns of a patient:
+// email and phone, encrypted when encryption is enabled, and their blind
+// indexes, nil when it is not.
+type sealedPatient struct {
+	email, phone                            string
+	emailIndex, phoneIndex, phoneLast4Index *string
+}
+
+// sealPatient encrypts the email and phone of a patient and computes their
+// blind indexes.
+func sealPatient(c *encryption.Cipher, email, phone string) (sealedPatient, error) {
+	var sealed sealedPatient
+	var err error
+
+	if sealed.email, err = c.Encrypt(emailField, email); err != nil {
+		return sealed, err
+	}
+
+	if sealed.phone, err = c.Encrypt(phoneField, phone); err != nil {
+		return sealed, err
+	}
+
+	sealed.emailIndex = emailIndex(c, email)
+	sealed.phoneIndex = phoneIndex(c, phone)
+	sealed.phoneLast4Index = phoneLast4Index(c, PhoneDigits(validation.NormalizePhone(phone)))
+
+	return sealed, nil
+}
+
+// unsealPatient decrypts the email, phone and demographics of a scanned
+// patient in place.
+func unsealPatient(c *encryption.Cipher, patient *Patient) error {
+	var err error
+
+	if patient.Email, err = c.Decrypt(emailField, patient.Email); err != nil {
+		return err
+	}
+
+	if patient.Phone, err = c.Decrypt(phoneField, patient.Phone); err != nil {
+		return err
+	}
+
+	sealed := patient.sealedDemographics
+	patient.sealedDemographics = ""
+	return unsealDemographics(c, sealed, &patient.Demographics)
+}
+
+// emailIndex returns the blind index of email, which ignores case, or nil
+// when encryption is disabled.
+func emailIndex(c *encryption.Cipher, email string) *string {
+	return index(c, emailField, strings.ToLower(strings.TrimSpace(email)))
+}
+
+// phoneIndex returns the blind index of phone in E.164, or nil when
+// encryption is disabled.
+func phoneIndex(c *encryption.Cipher, phone string) *string {
+	return index(c, phoneField, validation.NormalizePhone(phone))
+}
+
+// phoneLast4Index returns the blind index of the last four of digits, or nil
+// when there are fewer or encryption is disabled.
+func phoneLast4Index(c *encryption.Cipher, digits string) *string {
+	if len(digits) < 4 {
+		return nil
+	}
+
+	return index(c, phoneLast4Field, digits[len(digits)-4:])
+}
+
+func index(c *encryption.Cipher, field, value string) *string {
+	if !c.Enabled() {
+		return nil
+	}
+
+	blind := c.Index(field, value)
+	return &blind
+}
+
+// reencryptBatchSize is how many patients Reencrypt updates per transaction.
+const reencryptBatchSize = 500
+
+// Reencrypt encrypts the email, phone and demographics of every patient with
+// the current key and recomputes their blind indexes, so older keys can be
+// removed and the index key replaced. Plaintext values left from before
+// encryption was enabled are encrypted too. Patients already up to date are
+// skipped, so an interrupted run can be started again. It returns how many
+// patients were updated.
+func (s *PatientStore) Reencrypt(ctx context.Context) (int, error) {
+	updated, lastID := 0, 0
+
+	for {
+		var n, read int
+
+		err := audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+			var err error
+			n, read, lastID, err = s.reencryptBatch(ctx, tx, lastID)
+			return err
+		})
+		if err != nil {
+			return updated, err
+		}
+
+		updated += n
+		if read < reencryptBatchSize {
+			return updated, nil
+		}
+	}
+}
+
+// reencryptBatch re-encrypts the next reencryptBatchSize patients after
+// afterID, returning how many it updated and read and the last id read.
+func (s *PatientStore) reencryptBatch(ctx context.Context, tx *sql.Tx, afterID int) (updated, read, lastID int, err error) {
+	rows, err := tx.QueryContext(ctx, `select id, email, phone, email_index, phone_index, phone_last4_index, demographics
+		from patients where id > $1 order by id limit $2 for update`, afterID, reencryptBatchSize)
+	if err != nil {
+		return 0, 0, afterID, err
+	}
+
+	type row struct {
+		id                                      int
+		email, phone, demographics              string
+		emailIndex, phoneIndex, phoneLast4Index sql.NullString
+	}
+
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.email, &r.phone, &r.emailIndex, &r.phoneIndex, &r.phoneLast4Index, &r.demographics); err != nil {
+			rows.Close()
+			return 0, 0, afterID, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, afterID, err
+	}
+
+	lastID = afterID
+	for _, r := range batch {
+		lastID = r.id
+
+		patient := &Patient{ID: r.id, Email: r.email, Phone: r.phone, sealedDemographics: r.demographics}
+		if err := unsealPatient(s.cipher, patient); err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		sealed, err := sealPatient(s.cipher, patient.Email, patient.Phone)
+		if err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		demographics, err := sealDemographics(s.cipher, patient.Demographics)
+		if err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		if s.cipher.Current(r.email) && s.cipher.Current(r.phone) && (r.demographics == "" || s.cipher.Current(r.demographics)) &&
+			sameIndex(r.emailIndex, sealed.emailIndex) && sameIndex(r.phoneIndex, sealed.phoneIndex) &&
+			sameIndex(r.phoneLast4Index, sealed.phoneLast4Index) {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `update patients set email = $1, phone = $2, email_index = $3, phone_index = $4,
+			phone_last4_index = $5, demographics = $6 where id = $7`,
+			sealed.email, sealed.phone, sealed.emailIndex, sealed.phoneIndex, sealed.phoneLast4Index, demographics, r.id)
+		if err != nil {
+			return updated, len(batch), lastID, err
+		}
+
+		updated++
+	}
+
+	return updated, len(batch), lastID, nil
+}
+
+func sameIndex(stored sql.NullString, blind *string) bool {
+	if blind == nil {
+		return !stored.Valid
+	}
+
+	return stored.Valid && stored.String == *blind
+}
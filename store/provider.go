@@ -0,0 +1,243 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/codixir/smart-emerge-starter/encryption"
+)
+
+type Provider struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Specialty string `json:"specialty"`
+}
+
+// ProviderRepository reads and writes the providers of the clinic the
+// context acts for and the care teams linking them to its patients. Methods
+// return ErrNotFound when the provider, or the patient of a care team
+// change, does not exist there.
+type ProviderRepository interface {
+	Get(ctx context.Context, id int) (*Provider, error)
+	// List returns a page of providers by name, optionally only those of one
+	// specialty.
+	List(ctx context.Context, specialty string, limit, offset int) ([]*Provider, error)
+	// Create inserts provider in the clinic and sets its ID.
+	Create(ctx context.Context, provider *Provider) error
+	// Assign adds a provider to the care team of a patient who is not
+	// deleted. Assigning a provider already on the team does nothing.
+	Assign(ctx context.Context, actor string, patientID, providerID int) error
+	// Unassign removes a provider from the care team of a patient, returning
+	// ErrNotFound when the provider is not on it.
+	Unassign(ctx context.Context, actor string, patientID, providerID int) error
+	// CareTeams returns the care teams of many patients grouped by patient
+	// id, by provider name.
+	CareTeams(ctx context.Context, patientIDs []int) (map[int][]*Provider, error)
+	// Panels returns the patients of many providers grouped by provider id,
+	// by patient id. Soft-deleted patients are left out.
+	Panels(ctx context.Context, providerIDs []int) (map[int][]*Patient, error)
+}
+
+const providerColumns = "id, name, specialty"
+
+func scanProvider(row rowScanner) (*Provider, error) {
+	provider := &Provider{}
+
+	if err := row.Scan(&provider.ID, &provider.Name, &provider.Specialty); err != nil {
+		return nil, err
+	}
+
+	return provider, nil
+}
+
+// ProviderStore is the Postgres ProviderRepository. Care team changes are
+// recorded in the audit log of the patient, in the same transaction.
+type ProviderStore struct {
+	db     *sql.DB
+	audit  *audit.AuditLogger
+	cipher *encryption.Cipher
+}
+
+// NewProviderStore returns a ProviderStore decrypting the patients of panels
+// with cipher.
+func NewProviderStore(db *sql.DB, auditLogger *audit.AuditLogger, cipher *encryption.Cipher) *ProviderStore {
+	return &ProviderStore{db: db, audit: auditLogger, cipher: cipher}
+}
+
+func (s *ProviderStore) Get(ctx context.Context, id int) (*Provider, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := scanProvider(conn(ctx, s.db).QueryRowContext(ctx,
+		"select "+providerColumns+" from providers where id = $1 and "+inClinic("clinic_id", 2), id, clinic))
+	return provider, notFound(err)
+}
+
+func (s *ProviderStore) List(ctx context.Context, specialty string, limit, offset int) ([]*Provider, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx,
+		"select "+providerColumns+" from providers where ($1 = '' or specialty = $1) and "+inClinic("clinic_id", 4)+
+			" order by name, id limit $2 offset $3",
+		specialty, limit, offset, clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	providers := []*Provider{}
+	for rows.Next() {
+		provider, err := scanProvider(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		providers = append(providers, provider)
+	}
+
+	return providers, rows.Err()
+}
+
+func (s *ProviderStore) Create(ctx context.Context, provider *Provider) error {
+	clinicID, err := clinicOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	return conn(ctx, s.db).QueryRowContext(ctx, "insert into providers(name, specialty, clinic_id) values($1, $2, $3) returning id",
+		provider.Name, provider.Specialty, clinicID).Scan(&provider.ID)
+}
+
+func (s *ProviderStore) Assign(ctx context.Context, actor string, patientID, providerID int) error {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		patient, err := lockPatient(ctx, tx, patientID, false, s.cipher)
+		if err != nil {
+			return err
+		}
+
+		provider, err := scanProvider(tx.QueryRowContext(ctx,
+			"select "+providerColumns+" from providers where id = $1 and "+inClinic("clinic_id", 2), providerID, clinic))
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx,
+			"insert into care_team_members(patient_id, provider_id, clinic_id) values($1, $2, $3) on conflict do nothing",
+			patientID, providerID, patient.ClinicID)
+		if err != nil {
+			return err
+		}
+
+		added, err := result.RowsAffected()
+		if err != nil || added == 0 {
+			// Already on the care team, so there is no change to record.
+			return err
+		}
+
+		return s.audit.Log(ctx, tx, "assign_provider", patientID, actor, nil, provider)
+	})
+
+	return notFound(err)
+}
+
+func (s *ProviderStore) Unassign(ctx context.Context, actor string, patientID, providerID int) error {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		var removedID int
+		err := tx.QueryRowContext(ctx,
+			"delete from care_team_members where patient_id = $1 and provider_id = $2 and "+inClinic("clinic_id", 3)+" returning provider_id",
+			patientID, providerID, clinic).Scan(&removedID)
+		if err != nil {
+			return err
+		}
+
+		provider, err := scanProvider(tx.QueryRowContext(ctx, "select "+providerColumns+" from providers where id = $1", removedID))
+		if err != nil {
+			return err
+		}
+
+		return s.audit.Log(ctx, tx, "unassign_provider", patientID, actor, provider, nil)
+	})
+
+	return notFound(err)
+}
+
+func (s *ProviderStore) CareTeams(ctx context.Context, patientIDs []int) (map[int][]*Provider, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx, `select c.patient_id, p.id, p.name, p.specialty
+		from care_team_members c join providers p on p.id = c.provider_id
+		where c.patient_id = any($1) and `+inClinic("c.clinic_id", 2)+` order by p.name, p.id`, pq.Array(patientIDs), clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byPatient := make(map[int][]*Provider, len(patientIDs))
+	for rows.Next() {
+		var patientID int
+		provider := &Provider{}
+
+		if err := rows.Scan(&patientID, &provider.ID, &provider.Name, &provider.Specialty); err != nil {
+			return nil, err
+		}
+
+		byPatient[patientID] = append(byPatient[patientID], provider)
+	}
+
+	return byPatient, rows.Err()
+}
+
+func (s *ProviderStore) Panels(ctx context.Context, providerIDs []int) (map[int][]*Patient, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx, `select c.provider_id, `+qualifiedPatientColumns("p")+`
+		from care_team_members c join patients p on p.id = c.patient_id
+		where c.provider_id = any($1) and p.deleted_at is null and `+inClinic("c.clinic_id", 2)+` order by p.id`,
+		pq.Array(providerIDs), clinic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byProvider := make(map[int][]*Patient, len(providerIDs))
+	for rows.Next() {
+		var providerID int
+		patient := &Patient{}
+
+		if err := rows.Scan(append([]interface{}{&providerID}, patient.fields()...)...); err != nil {
+			return nil, err
+		}
+
+		if err := unsealPatient(s.cipher, patient); err != nil {
+			return nil, err
+		}
+
+		byProvider[providerID] = append(byProvider[providerID], patient)
+	}
+
+	return byProvider, rows.Err()
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Reminder is a reminder of an appointment sent, or to be sent, to its
+// patient on one channel.
+type Reminder struct {
+	ID            int64  `json:"id"`
+	AppointmentID int    `json:"appointmentId"`
+	PatientID     int    `json:"patientId"`
+	Channel       string `json:"channel"`
+	// ScheduledFor is the start of the appointment the reminder is about.
+	ScheduledFor time.Time `json:"scheduledFor"`
+	Status       string    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	// LastError is why the last attempt failed, or why the reminder was
+	// skipped.
+	LastError *string    `json:"lastError"`
+	SentAt    *time.Time `json:"sentAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// ReminderRepository reads the appointment reminders of the clinic the
+// context acts for, which the reminder package writes.
+type ReminderRepository interface {
+	// ListByPatient returns a page of the reminders of the appointments of a
+	// patient, newest first.
+	ListByPatient(ctx context.Context, patientID, limit, offset int) ([]*Reminder, error)
+}
+
+// ReminderStore is the Postgres ReminderRepository.
+type ReminderStore struct {
+	db *sql.DB
+}
+
+func NewReminderStore(db *sql.DB) *ReminderStore {
+	return &ReminderStore{db: db}
+}
+
+func (s *ReminderStore) ListByPatient(ctx context.Context, patientID, limit, offset int) ([]*Reminder, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, s.db).QueryContext(ctx, `select r.id, r.appointment_id, a.patient_id, r.channel, r.scheduled_for,
+			r.status, r.attempts, r.last_error, r.sent_at, r.created_at
+		from appointment_reminders r join appointments a on a.id = r.appointment_id
+		where a.patient_id = $1 and `+inClinic("r.clinic_id", 2)+`
+		order by r.created_at desc, r.id desc limit $3 offset $4`,
+		patientID, clinic, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []*Reminder{}
+	for rows.Next() {
+		r := &Reminder{}
+
+		err := rows.Scan(&r.ID, &r.AppointmentID, &r.PatientID, &r.Channel, &r.ScheduledFor,
+			&r.Status, &r.Attempts, &r.LastError, &r.SentAt, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		reminders = append(reminders, r)
+	}
+
+	return reminders, rows.Err()
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+	"github.com/lib/pq"
+)
+
+// replicaRetryInterval is how long reads go to the primary after the replica
+// could not be reached.
+const replicaRetryInterval = 30 * time.Second
+
+// Replica is a read replica of the primary database. The listing and search
+// queries of PatientStore run on it, and when it cannot be reached they run
+// on the primary instead, for replicaRetryInterval before it is tried again.
+// Replicas lag behind the primary, so these reads may miss the latest writes
+// for a moment.
+type Replica struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func NewReplica(db *sql.DB) *Replica {
+	return &Replica{db: db}
+}
+
+// available reports whether reads should be sent to the replica. A nil
+// Replica is never available.
+func (r *Replica) available() bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return time.Now().After(r.downUntil)
+}
+
+// markDown sends reads to the primary for replicaRetryInterval, after err
+// from the replica.
+func (r *Replica) markDown(ctx context.Context, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.downUntil = time.Now().Add(replicaRetryInterval)
+	slog.WarnContext(ctx, "read replica unavailable, reading from the primary", "retry_in", replicaRetryInterval.String(), "error", err)
+}
+
+// unreachable reports whether err means the database could not be reached
+// or is not accepting queries, rather than that the query failed.
+func unreachable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 is connection exceptions, and 57 operator intervention,
+		// such as a server shutting down or still starting up.
+		class := pqErr.Code.Class()
+		return class == "08" || class == "57"
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, driver.ErrBadConn)
+}
+
+// replicaConn reads from a replica, falling back to the primary for
+// statements that cannot reach it. Writes go to the primary.
+type replicaConn struct {
+	primary *sql.DB
+	replica *Replica
+}
+
+func (c replicaConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.primary.ExecContext(ctx, query, args...)
+}
+
+func (c replicaConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := c.replica.db.QueryContext(ctx, query, args...)
+	if unreachable(err) {
+		c.replica.markDown(ctx, err)
+		return c.primary.QueryContext(ctx, query, args...)
+	}
+
+	return rows, err
+}
+
+func (c replicaConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	row := c.replica.db.QueryRowContext(ctx, query, args...)
+	if err := row.Err(); unreachable(err) {
+		c.replica.markDown(ctx, err)
+		return c.primary.QueryRowContext(ctx, query, args...)
+	}
+
+	return row
+}
+
+// readConn is conn for reads that may run on replica: those outside a
+// transaction while the replica is available.
+func readConn(ctx context.Context, db *sql.DB, replica *Replica) queryer {
+	if _, ok := audit.TxFromContext(ctx); ok || !replica.available() {
+		return conn(ctx, db)
+	}
+
+	return replicaConn{primary: db, replica: replica}
+}
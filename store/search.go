@@ -0,0 +1,240 @@
+package store
+
+import (
+	"context"
+	"html"
+	"strings"
+)
+
+// PatientMatch is a patient found by Search, with how well it matched.
+type PatientMatch struct {
+	Patient *Patient `json:"patient"`
+	// Score ranks the match, from 0 to 1. Substring matches score 1 and
+	// fuzzy matches their trigram word similarity.
+	Score float64 `json:"score"`
+	// Highlights has a highlight for every field containing the term.
+	// Fuzzy matches may have none.
+	Highlights []Highlight `json:"highlights"`
+}
+
+// Highlight is the value of a matched field, HTML-escaped, with every
+// occurrence of the search term wrapped in <mark> tags.
+type Highlight struct {
+	Field   string `json:"field"`
+	Snippet string `json:"snippet"`
+}
+
+// searchStmt finds patients whose name or email contains the term ($1,
+// lower-cased, with $3 its LIKE pattern) or resembles one of their words, or
+// whose phone digits contain the digits of the term ($2), in the clinic of
+// $5. Each condition is served by a trigram index of migration 010.
+var searchStmt = `select ` + patientSelectColumns + `, score from (
+		select *, greatest(
+			case when lower(name) like $3 or lower(email) like $3 then 1
+				else greatest(word_similarity($1, lower(name)), word_similarity($1, lower(email))) end,
+			case when $2 <> '' and regexp_replace(phone, '[^0-9]', '', 'g') like '%' || $2 || '%' then 1 else 0 end
+		) as score
+		from patients
+		where deleted_at is null and ` + inClinic("clinic_id", 5) + ` and (
+			lower(name) like $3 or lower(email) like $3 or $1 <% lower(name) or $1 <% lower(email)
+			or ($2 <> '' and regexp_replace(phone, '[^0-9]', '', 'g') like '%' || $2 || '%')
+		)
+	) matches order by score desc, name, id limit $4`
+
+// encryptedSearchStmt is searchStmt for encrypted emails and phones, which
+// can only be matched whole through their blind indexes: the email index
+// ($3) against the term, and the phone index ($4) and the index of the last
+// four digits ($5) against its digits. Names match as in searchStmt, with $1
+// the lower-cased term and $2 its LIKE pattern, and $7 is the clinic.
+var encryptedSearchStmt = `select ` + patientSelectColumns + `, score from (
+		select *, case when lower(name) like $2 or email_index = $3 or phone_index = $4 or phone_last4_index = $5 then 1
+			else word_similarity($1, lower(name)) end as score
+		from patients
+		where deleted_at is null and ` + inClinic("clinic_id", 7) + ` and (
+			lower(name) like $2 or $1 <% lower(name)
+			or email_index = $3 or phone_index = $4 or phone_last4_index = $5
+		)
+	) matches order by score desc, name, id limit $6`
+
+// Search returns up to limit patients who are not soft-deleted and match
+// term, the best matches first. A term made only of digits and phone
+// punctuation, such as the last four digits of a number, also matches phone
+// numbers containing those digits. With encryption, emails and phones only
+// match in full, or by exactly their last four digits.
+func (s *PatientStore) Search(ctx context.Context, term string, limit int) ([]*PatientMatch, error) {
+	clinic, err := clinicScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	term = strings.ToLower(strings.TrimSpace(term))
+	digits := PhoneDigits(term)
+	pattern := "%" + escapeLike(term) + "%"
+
+	stmt, args := searchStmt, []interface{}{term, digits, pattern, limit, clinic}
+	if s.cipher.Enabled() {
+		var phone, last4 *string
+		if digits != "" {
+			phone = phoneIndex(s.cipher, term)
+		}
+		if len(digits) == 4 {
+			last4 = phoneLast4Index(s.cipher, digits)
+		}
+
+		stmt, args = encryptedSearchStmt, []interface{}{term, pattern, emailIndex(s.cipher, term), phone, last4, limit, clinic}
+	}
+	s.warnOnHighCost(ctx, stmt, args...)
+
+	rows, err := readConn(ctx, s.db, s.replica).QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []*PatientMatch{}
+	for rows.Next() {
+		patient := &Patient{}
+		match := &PatientMatch{Patient: patient}
+
+		err := rows.Scan(append(patient.fields(), &match.Score)...)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := unsealPatient(s.cipher, patient); err != nil {
+			return nil, err
+		}
+
+		match.Highlights = highlights(patient, term, digits)
+		matches = append(matches, match)
+	}
+
+	return matches, rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// PhoneDigits returns the digits of term when it looks like part of a phone
+// number, with nothing but digits, spaces and + ( ) - . in it, and an empty
+// string otherwise.
+func PhoneDigits(term string) string {
+	var digits strings.Builder
+
+	for _, r := range term {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case !strings.ContainsRune(" +()-.", r):
+			return ""
+		}
+	}
+
+	return digits.String()
+}
+
+// Highlights returns the highlights Search gives patient for term, for the
+// other PatientRepository implementations.
+func Highlights(patient *Patient, term string) []Highlight {
+	term = strings.ToLower(strings.TrimSpace(term))
+	return highlights(patient, term, PhoneDigits(term))
+}
+
+// highlights returns the highlights of the fields of patient containing the
+// lower-cased term, or for the phone its digits.
+func highlights(patient *Patient, term, digits string) []Highlight {
+	result := []Highlight{}
+
+	for _, f := range []struct{ field, value string }{
+		{"name", patient.Name},
+		{"email", patient.Email},
+	} {
+		if snippet, ok := markTerm(f.value, term); ok {
+			result = append(result, Highlight{Field: f.field, Snippet: snippet})
+		}
+	}
+
+	if snippet, ok := markDigits(patient.Phone, digits); ok {
+		result = append(result, Highlight{Field: "phone", Snippet: snippet})
+	}
+
+	return result
+}
+
+// markTerm marks every occurrence of term in value, ignoring case, and
+// reports whether there was one.
+func markTerm(value, term string) (string, bool) {
+	var spans [][2]int
+
+	for i := 0; i+len(term) <= len(value); {
+		if strings.EqualFold(value[i:i+len(term)], term) {
+			spans = append(spans, [2]int{i, i + len(term)})
+			i += len(term)
+			continue
+		}
+
+		i++
+	}
+
+	return mark(value, spans), len(spans) > 0
+}
+
+// markDigits marks the part of phone whose digits are digits, punctuation
+// in between included, and reports whether there was one.
+func markDigits(phone, digits string) (string, bool) {
+	if digits == "" {
+		return "", false
+	}
+
+	// positions maps each digit of phone to its byte offset.
+	var positions []int
+	var all strings.Builder
+	for i, r := range phone {
+		if r >= '0' && r <= '9' {
+			positions = append(positions, i)
+			all.WriteRune(r)
+		}
+	}
+
+	start := strings.Index(all.String(), digits)
+	if start < 0 {
+		return "", false
+	}
+
+	end := positions[start+len(digits)-1] + 1
+	return mark(phone, [][2]int{{positions[start], end}}), true
+}
+
+// mark HTML-escapes s and wraps the byte ranges of spans, which are ordered
+// and do not overlap, in <mark> tags.
+func mark(s string, spans [][2]int) string {
+	var b strings.Builder
+
+	last := 0
+	for _, span := range spans {
+		b.WriteString(html.EscapeString(s[last:span[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(s[span[0]:span[1]]))
+		b.WriteString("</mark>")
+		last = span[1]
+	}
+	b.WriteString(html.EscapeString(s[last:]))
+
+	return b.String()
+}
+
+// Reindex rebuilds the indexes of the patients table, the trigram indexes of
+// Search among them, which bulk imports and seeding leave bloated, without
+// blocking writes, and refreshes its planner statistics. It cannot run in a
+// transaction, and REINDEX CONCURRENTLY needs Postgres 12.
+func (s *PatientStore) Reindex(ctx context.Context) error {
+	for _, stmt := range []string{"reindex table concurrently patients", "analyze patients"} {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+// Package store keeps patients, appointments, encounters, providers,
+// emergency contacts and duplicate reports in Postgres behind repository interfaces, so callers can
+// be tested against other implementations. Every record belongs to a clinic,
+// and the repositories only read and write those of the clinic their
+// context acts for, set with the tenant package.
+package store
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrNotFound is returned when the requested row does not exist.
+var ErrNotFound = errors.New("store: not found")
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound and returns other errors as is.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+var (
+	_ PatientRepository          = (*PatientStore)(nil)
+	_ AppointmentRepository      = (*AppointmentStore)(nil)
+	_ DuplicateReportRepository  = (*DuplicateReportStore)(nil)
+	_ ProviderRepository         = (*ProviderStore)(nil)
+	_ EncounterRepository        = (*EncounterStore)(nil)
+	_ EmergencyContactRepository = (*EmergencyContactStore)(nil)
+	_ NoteRepository             = (*NoteStore)(nil)
+	_ ClinicRepository           = (*ClinicStore)(nil)
+	_ Transactor                 = (*TxStore)(nil)
+)
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codixir/smart-emerge-starter/tenant"
+	"github.com/codixir/smart-emerge-starter/utils"
+)
+
+// clinicScope returns the argument of the inClinic condition for ctx: the
+// clinic ctx acts for, or nil for every clinic under tenant.WithAllClinics.
+// It fails with utils.ErrNoClinic when ctx acts for no clinic.
+func clinicScope(ctx context.Context) (interface{}, error) {
+	if tenant.AllClinics(ctx) {
+		return nil, nil
+	}
+
+	clinicID, ok := tenant.ClinicFromContext(ctx)
+	if !ok {
+		return nil, utils.ErrNoClinic
+	}
+
+	return clinicID, nil
+}
+
+// clinicOf returns the clinic new records of ctx are created in, failing
+// with utils.ErrNoClinic when ctx acts for no clinic.
+func clinicOf(ctx context.Context) (int, error) {
+	clinicID, ok := tenant.ClinicFromContext(ctx)
+	if !ok {
+		return 0, utils.ErrNoClinic
+	}
+
+	return clinicID, nil
+}
+
+// inClinic returns the condition limiting column to the clinic of the
+// clinicScope argument in placeholder n, which is true for every row when
+// the argument is nil.
+func inClinic(column string, n int) string {
+	return fmt.Sprintf("($%[2]d::integer is null or %[1]s = $%[2]d)", column, n)
+}
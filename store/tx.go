@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/codixir/smart-emerge-starter/audit"
+)
+
+// Transactor runs several repository calls in one database transaction, so
+// either all of their writes are kept or none is.
+type Transactor interface {
+	// InTx runs fn in a transaction, committing when it returns nil and
+	// rolling back otherwise. Repository calls made with the context passed
+	// to fn run in the transaction, and an InTx nested in fn joins it.
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// TxStore is the Postgres Transactor.
+type TxStore struct {
+	db *sql.DB
+}
+
+func NewTxStore(db *sql.DB) *TxStore {
+	return &TxStore{db: db}
+}
+
+func (s *TxStore) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return audit.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		return fn(audit.ContextWithTx(ctx, tx))
+	})
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conn returns the transaction of an InTx call when ctx carries one and db
+// otherwise, so writes of a single statement, which need no transaction of
+// their own, still join one.
+func conn(ctx context.Context, db *sql.DB) queryer {
+	if tx, ok := audit.TxFromContext(ctx); ok {
+		return tx
+	}
+
+	return db
+}
@@ -0,0 +1,38 @@
+// Package tenant carries the clinic a request acts for in its context. The
+// stores scope every query and mutation to that clinic, so the clinics
+// sharing a deployment never see each other's records.
+package tenant
+
+import "context"
+
+type contextKey int
+
+const (
+	clinicIDKey contextKey = iota
+	allClinicsKey
+)
+
+// WithClinic returns ctx acting for the clinic with id clinicID, or for no
+// clinic when it is 0.
+func WithClinic(ctx context.Context, clinicID int) context.Context {
+	return context.WithValue(ctx, clinicIDKey, clinicID)
+}
+
+// ClinicFromContext returns the clinic ctx acts for.
+func ClinicFromContext(ctx context.Context) (int, bool) {
+	clinicID, _ := ctx.Value(clinicIDKey).(int)
+	return clinicID, clinicID > 0
+}
+
+// WithAllClinics returns ctx reading the records of every clinic, for the
+// cross-clinic queries of admins. Writes still need the clinic of
+// WithClinic.
+func WithAllClinics(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allClinicsKey, true)
+}
+
+// AllClinics reports whether ctx reads the records of every clinic.
+func AllClinics(ctx context.Context) bool {
+	all, _ := ctx.Value(allClinicsKey).(bool)
+	return all
+}
@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/codixir/smart-emerge-starter/chain"
+)
+
+// StartOperation starts the span of a GraphQL operation of operationType
+// selecting rootFields, named like "query getPatient". The document is not
+// recorded since its arguments may hold patient data.
+func StartOperation(ctx context.Context, operationType string, rootFields []string, operationName string) (context.Context, trace.Span) {
+	name := operationType
+	if len(rootFields) > 0 {
+		name += " " + strings.Join(rootFields, ",")
+	}
+
+	attributes := []attribute.KeyValue{semconv.GraphqlOperationTypeKey.String(operationType)}
+	if operationName != "" {
+		attributes = append(attributes, semconv.GraphqlOperationName(operationName))
+	}
+
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// EndOperation marks span as failed when result has errors and ends it.
+func EndOperation(span trace.Span, result *graphql.Result) {
+	if result.HasErrors() {
+		span.SetStatus(codes.Error, result.Errors[0].Message)
+		span.SetAttributes(attribute.Int("graphql.errors", len(result.Errors)))
+	}
+
+	span.End()
+}
+
+// ResolverMiddleware wraps the fields that return objects, the root fields
+// and relations such as Patient.appointments, in a span each. Resolvers
+// formatting scalars are left alone so they do not add a span per row.
+// Resolvers returning a thunk are traced until the thunk is returned, not
+// until it is resolved.
+func ResolverMiddleware() chain.Middleware {
+	return func(field chain.Field, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+		if !field.Root && field.Leaf() {
+			return next
+		}
+
+		name := field.String()
+		tracer := otel.Tracer(instrumentationName)
+
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			ctx, span := tracer.Start(p.Context, name, trace.WithAttributes(attribute.String("graphql.field", name)))
+			defer span.End()
+
+			p.Context = ctx
+			result, err := next(p)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+
+			return result, err
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Package tracing sets up OpenTelemetry tracing: the OTLP exporter, W3C trace
+// context propagation, and spans for HTTP requests, GraphQL operations and
+// resolvers, and database calls.
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/XSAM/otelsql"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/codixir/smart-emerge-starter/middleware"
+)
+
+const instrumentationName = "github.com/codixir/smart-emerge-starter"
+
+// Setup installs the W3C trace context propagator and, when
+// OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, a
+// tracer provider exporting spans over OTLP/HTTP. The exporter, sampler and
+// resource are configured by the standard OTEL_* variables, OTEL_SERVICE_NAME
+// overriding serviceName. Without an endpoint no spans are recorded, but
+// incoming trace context is still passed on. The returned function flushes
+// the pending spans and stops the exporter.
+func Setup(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating the OTLP exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("building the trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// OpenPostgres opens the Postgres database at dataSourceName like sql.Open,
+// with a span for every query, statement and transaction. Spans record the
+// SQL text but not the arguments, so patient data stays out of the traces.
+func OpenPostgres(dataSourceName string) (*sql.DB, error) {
+	return otelsql.Open("postgres", dataSourceName,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
+}
+
+// Middleware continues the trace of W3C traceparent headers, or starts a new
+// one, with a server span per request named by the path template of the
+// matched route. Responses with a 5xx status mark the span as failed.
+func Middleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+			route := "unknown"
+			if current := mux.CurrentRoute(r); current != nil {
+				if template, err := current.GetPathTemplate(); err == nil {
+					route = template
+				}
+			}
+
+			attributes := []attribute.KeyValue{
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			}
+			if ip, ok := middleware.ClientIPFromContext(r.Context()); ok {
+				attributes = append(attributes, semconv.ClientAddress(ip))
+			}
+
+			ctx, span := otel.Tracer(instrumentationName).Start(ctx, r.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(attributes...),
+			)
+			defer span.End()
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+			if rec.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(rec.status))
+			}
+		})
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes on to streaming responses such as the export.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades take over the connection.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", r.ResponseWriter)
+	}
+
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
@@ -0,0 +1,75 @@
+package utils
+
+import "fmt"
+
+// Wrap adds context describing the failed operation to err, keeping err
+// available to errors.Is and errors.As. It returns nil when err is nil.
+func Wrap(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), err)
+}
+
+// CodedError is an error reported to GraphQL clients with a machine readable
+// code in the extensions of the response error. Details are added to the
+// extensions next to the code.
+type CodedError struct {
+	Code    string
+	Message string
+	Details map[string]interface{}
+}
+
+func (e *CodedError) Error() string {
+	return e.Message
+}
+
+// Extensions implements gqlerrors.ExtendedError.
+func (e *CodedError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": e.Code}
+	for key, value := range e.Details {
+		extensions[key] = value
+	}
+
+	return extensions
+}
+
+// ErrUnauthenticated is returned by resolvers that need a signed in caller.
+var ErrUnauthenticated = &CodedError{Code: "UNAUTHENTICATED", Message: "Unauthenticated"}
+
+// ErrForbidden is returned by resolvers when the caller lacks a required role.
+var ErrForbidden = &CodedError{Code: "FORBIDDEN", Message: "Forbidden"}
+
+// ErrNoClinic is returned when a request reaches clinic records without
+// acting for a clinic.
+var ErrNoClinic = &CodedError{
+	Code:    "FORBIDDEN",
+	Message: "the request does not act for a clinic, use a token with a clinic_id claim or, as an admin, send X-Clinic-ID",
+}
+
+// NotFound returns a NOT_FOUND error with the formatted message.
+func NotFound(format string, args ...interface{}) error {
+	return &CodedError{Code: "NOT_FOUND", Message: fmt.Sprintf(format, args...)}
+}
+
+// InternalError hides the details of Err, such as SQL or driver messages,
+// from GraphQL clients, which only see Message. Err is kept for logging and
+// for errors.Is and errors.As.
+type InternalError struct {
+	Message string
+	Err     error
+}
+
+func (e *InternalError) Error() string {
+	return e.Message
+}
+
+func (e *InternalError) Unwrap() error {
+	return e.Err
+}
+
+// Extensions implements gqlerrors.ExtendedError.
+func (e *InternalError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": "INTERNAL_SERVER_ERROR"}
+}
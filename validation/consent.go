@@ -0,0 +1,47 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxConsentScopeLength is the longest scope of a consent accepted, in
+// characters.
+const MaxConsentScopeLength = 64
+
+// Consent normalizes the given consent fields in place and returns Errors
+// describing every invalid one, or nil. The scope is trimmed and lowercased
+// and must be made of letters, digits, underscores and hyphens, and
+// grantedAt, which may be nil, must be an RFC 3339 time not in the future.
+func Consent(scope *string, grantedAt *string) error {
+	var errs Errors
+
+	*scope = strings.ToLower(strings.TrimSpace(*scope))
+	switch {
+	case *scope == "":
+		errs = append(errs, FieldError{Field: "scope", Message: "scope must not be empty"})
+	case len(*scope) > MaxConsentScopeLength:
+		errs = append(errs, FieldError{Field: "scope", Message: fmt.Sprintf("scope must be at most %d characters", MaxConsentScopeLength)})
+	case strings.Trim(*scope, "abcdefghijklmnopqrstuvwxyz0123456789_-") != "":
+		errs = append(errs, FieldError{Field: "scope", Message: "scope must only hold letters, digits, underscores and hyphens"})
+	}
+
+	if grantedAt != nil {
+		*grantedAt = strings.TrimSpace(*grantedAt)
+		granted, err := time.Parse(time.RFC3339, *grantedAt)
+
+		switch {
+		case err != nil:
+			errs = append(errs, FieldError{Field: "grantedAt", Message: "grantedAt must be an RFC 3339 time such as 2024-01-31T09:30:00Z"})
+		case granted.After(time.Now()):
+			errs = append(errs, FieldError{Field: "grantedAt", Message: "grantedAt must not be in the future"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxRelationshipLength is the longest relationship of an emergency contact
+// accepted, in characters.
+const MaxRelationshipLength = 100
+
+// EmergencyContact normalizes the given emergency contact fields in place
+// and returns Errors describing every invalid one, or nil. The name and
+// relationship are trimmed and the phone converted to E.164.
+func EmergencyContact(name, relationship, phone *string) error {
+	var errs Errors
+
+	*name = strings.TrimSpace(*name)
+	if message := ValidateName(*name); message != "" {
+		errs = append(errs, FieldError{Field: "name", Message: message})
+	}
+
+	*relationship = strings.TrimSpace(*relationship)
+	switch {
+	case *relationship == "":
+		errs = append(errs, FieldError{Field: "relationship", Message: "relationship must not be empty"})
+	case utf8.RuneCountInString(*relationship) > MaxRelationshipLength:
+		errs = append(errs, FieldError{
+			Field:   "relationship",
+			Message: fmt.Sprintf("relationship must be at most %d characters", MaxRelationshipLength),
+		})
+	}
+
+	*phone = NormalizePhone(*phone)
+	if message := ValidatePhone(*phone); message != "" {
+		errs = append(errs, FieldError{Field: "phone", Message: message})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MaxNamePartLength is the longest prefix, given name, family name or
+	// suffix accepted, in characters.
+	MaxNamePartLength = 100
+	// MaxGivenNames is the most given names a patient may have.
+	MaxGivenNames = 5
+	// MaxAddressFieldLength is the longest address line, city, state or
+	// postal code accepted, in characters.
+	MaxAddressFieldLength = 200
+	// MaxAddressLines is the most lines an address may have.
+	MaxAddressLines = 4
+	// MaxContactPointLength is the longest value of a contact point or an
+	// identifier, and the longest identifier system, accepted in characters.
+	MaxContactPointLength = 255
+	// MaxDemographicsEntries is the most addresses, contact points or
+	// identifiers a patient may have, each.
+	MaxDemographicsEntries = 10
+)
+
+var (
+	// languagePattern matches BCP 47 language tags such as "en", "pt-BR" or
+	// "zh-Hant-TW".
+	languagePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+	countryPattern  = regexp.MustCompile(`^[A-Z]{2}$`)
+	// earliestBirthDate is the earliest birth date accepted, which catches
+	// mistyped years.
+	earliestBirthDate = time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Within returns e with the fields prefixed by path, so the errors of an
+// entry of a list read like "addresses[0].city".
+func (e Errors) Within(path string) Errors {
+	prefixed := make(Errors, len(e))
+	for i, fieldErr := range e {
+		prefixed[i] = FieldError{Field: path + "." + fieldErr.Field, Message: path + "." + fieldErr.Message}
+	}
+
+	return prefixed
+}
+
+// HumanName normalizes the parts of a structured name in place and returns
+// Errors describing every invalid one, or nil. The parts are trimmed and
+// empty given names dropped. No part is required, so an empty name clears
+// the structured name.
+func HumanName(prefix *string, given *[]string, family, suffix *string) error {
+	var errs Errors
+
+	for _, part := range []struct {
+		field string
+		value *string
+	}{{"prefix", prefix}, {"family", family}, {"suffix", suffix}} {
+		*part.value = strings.TrimSpace(*part.value)
+		if utf8.RuneCountInString(*part.value) > MaxNamePartLength {
+			errs = append(errs, FieldError{Field: part.field, Message: fmt.Sprintf("%s must be at most %d characters", part.field, MaxNamePartLength)})
+		}
+	}
+
+	names := []string{}
+	for _, name := range *given {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		if utf8.RuneCountInString(name) > MaxNamePartLength {
+			errs = append(errs, FieldError{Field: "given", Message: fmt.Sprintf("given must hold names of at most %d characters", MaxNamePartLength)})
+		}
+		names = append(names, name)
+	}
+	if len(names) > MaxGivenNames {
+		errs = append(errs, FieldError{Field: "given", Message: fmt.Sprintf("given must hold at most %d names", MaxGivenNames)})
+	}
+	*given = names
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// BirthDate trims birthDate in place and returns Errors when it is not a
+// day such as 1984-03-21 between 1900 and today.
+func BirthDate(birthDate *string) error {
+	*birthDate = strings.TrimSpace(*birthDate)
+	date, err := time.Parse(time.DateOnly, *birthDate)
+
+	switch {
+	case err != nil:
+		return Errors{{Field: "birthDate", Message: "birthDate must be a date such as 1984-03-21"}}
+	case date.After(time.Now()):
+		return Errors{{Field: "birthDate", Message: "birthDate must not be in the future"}}
+	case date.Before(earliestBirthDate):
+		return Errors{{Field: "birthDate", Message: "birthDate must not be before 1900"}}
+	}
+
+	return nil
+}
+
+// NormalizeLanguage trims a BCP 47 language tag and writes its subtags in
+// their conventional case: "PT-br" becomes "pt-BR" and "zh-hant" "zh-Hant".
+func NormalizeLanguage(tag string) string {
+	subtags := strings.Split(strings.TrimSpace(tag), "-")
+
+	for i, subtag := range subtags {
+		switch {
+		case i == 0:
+			subtags[i] = strings.ToLower(subtag)
+		case len(subtag) == 2:
+			subtags[i] = strings.ToUpper(subtag)
+		case len(subtag) == 4:
+			subtags[i] = strings.ToUpper(subtag[:1]) + strings.ToLower(subtag[1:])
+		default:
+			subtags[i] = strings.ToLower(subtag)
+		}
+	}
+
+	return strings.Join(subtags, "-")
+}
+
+// ValidateLanguage returns why tag is not a BCP 47 language tag, or "". An
+// empty tag, one not known, is valid.
+func ValidateLanguage(tag string) string {
+	if tag != "" && !languagePattern.MatchString(tag) {
+		return "preferredLanguage must be a BCP 47 language tag such as en or pt-BR"
+	}
+
+	return ""
+}
+
+// Address normalizes the given address fields in place and returns Errors
+// describing every invalid one, or nil. The fields are trimmed, empty lines
+// dropped and the country upper-cased, which must be an ISO 3166-1 alpha-2
+// code such as DE when given. An address needs at least a line, a city or
+// a postal code.
+func Address(lines *[]string, city, state, postalCode, country *string) error {
+	var errs Errors
+
+	kept := []string{}
+	for _, line := range *lines {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		if utf8.RuneCountInString(line) > MaxAddressFieldLength {
+			errs = append(errs, FieldError{Field: "lines", Message: fmt.Sprintf("lines must be at most %d characters", MaxAddressFieldLength)})
+		}
+		kept = append(kept, line)
+	}
+	if len(kept) > MaxAddressLines {
+		errs = append(errs, FieldError{Field: "lines", Message: fmt.Sprintf("lines must hold at most %d lines", MaxAddressLines)})
+	}
+	*lines = kept
+
+	for _, f := range []struct {
+		field string
+		value *string
+	}{{"city", city}, {"state", state}, {"postalCode", postalCode}} {
+		*f.value = strings.TrimSpace(*f.value)
+		if utf8.RuneCountInString(*f.value) > MaxAddressFieldLength {
+			errs = append(errs, FieldError{Field: f.field, Message: fmt.Sprintf("%s must be at most %d characters", f.field, MaxAddressFieldLength)})
+		}
+	}
+
+	*country = strings.ToUpper(strings.TrimSpace(*country))
+	if *country != "" && !countryPattern.MatchString(*country) {
+		errs = append(errs, FieldError{Field: "country", Message: "country must be an ISO 3166-1 alpha-2 code such as DE"})
+	}
+
+	if len(kept) == 0 && *city == "" && *postalCode == "" {
+		errs = append(errs, FieldError{Field: "lines", Message: "lines, city or postalCode must be given"})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// ContactPoint normalizes the value of a contact point of system in place
+// and returns Errors when it is invalid, or nil. Emails are trimmed and
+// checked like the email of a patient, and phone, fax and SMS numbers
+// converted to E.164; other values only need to be given.
+func ContactPoint(system string, value *string) error {
+	var message string
+
+	switch system {
+	case "email":
+		*value = strings.TrimSpace(*value)
+		if message = ValidateEmail(*value); message != "" {
+			message = "value must be a valid email address"
+		}
+	case "phone", "fax", "sms":
+		*value = NormalizePhone(*value)
+		if message = ValidatePhone(*value); message != "" {
+			message = "value must be an E.164 number such as +14155552671"
+		}
+	default:
+		*value = strings.TrimSpace(*value)
+		switch {
+		case *value == "":
+			message = "value must not be empty"
+		case utf8.RuneCountInString(*value) > MaxContactPointLength:
+			message = fmt.Sprintf("value must be at most %d characters", MaxContactPointLength)
+		}
+	}
+
+	if message != "" {
+		return Errors{{Field: "value", Message: message}}
+	}
+
+	return nil
+}
+
+// Identifier trims the system and value of an identifier in place and
+// returns Errors describing every invalid one, or nil. The value is
+// required and the system, which may be empty, is typically a URI.
+func Identifier(system, value *string) error {
+	var errs Errors
+
+	*system = strings.TrimSpace(*system)
+	if utf8.RuneCountInString(*system) > MaxContactPointLength {
+		errs = append(errs, FieldError{Field: "system", Message: fmt.Sprintf("system must be at most %d characters", MaxContactPointLength)})
+	}
+
+	*value = strings.TrimSpace(*value)
+	switch {
+	case *value == "":
+		errs = append(errs, FieldError{Field: "value", Message: "value must not be empty"})
+	case utf8.RuneCountInString(*value) > MaxContactPointLength:
+		errs = append(errs, FieldError{Field: "value", Message: fmt.Sprintf("value must be at most %d characters", MaxContactPointLength)})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
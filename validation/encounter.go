@@ -0,0 +1,66 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxChiefComplaintLength is the longest chief complaint accepted, in
+// characters.
+const MaxChiefComplaintLength = 500
+
+// diagnosisCodePattern matches ICD-10 codes such as J45 or E11.65.
+var diagnosisCodePattern = regexp.MustCompile(`^[A-Z][0-9][0-9A-Z](\.[0-9A-Z]{1,4})?$`)
+
+// Encounter normalizes the given encounter fields in place and returns
+// Errors describing every invalid one, or nil. The chief complaint is
+// trimmed and diagnosis codes are trimmed, upper-cased and deduplicated. A
+// nil field, one left out of an amendment, is skipped.
+func Encounter(chiefComplaint *string, diagnosisCodes *[]string) error {
+	var errs Errors
+
+	if chiefComplaint != nil {
+		*chiefComplaint = strings.TrimSpace(*chiefComplaint)
+
+		switch {
+		case *chiefComplaint == "":
+			errs = append(errs, FieldError{Field: "chiefComplaint", Message: "chiefComplaint must not be empty"})
+		case utf8.RuneCountInString(*chiefComplaint) > MaxChiefComplaintLength:
+			errs = append(errs, FieldError{
+				Field:   "chiefComplaint",
+				Message: fmt.Sprintf("chiefComplaint must be at most %d characters", MaxChiefComplaintLength),
+			})
+		}
+	}
+
+	if diagnosisCodes != nil {
+		seen := map[string]bool{}
+		codes := []string{}
+
+		for _, code := range *diagnosisCodes {
+			code = strings.ToUpper(strings.TrimSpace(code))
+			if !diagnosisCodePattern.MatchString(code) {
+				errs = append(errs, FieldError{
+					Field:   "diagnosisCodes",
+					Message: fmt.Sprintf("diagnosis code %q must be an ICD-10 code such as E11.65", code),
+				})
+				continue
+			}
+
+			if !seen[code] {
+				seen[code] = true
+				codes = append(codes, code)
+			}
+		}
+
+		*diagnosisCodes = codes
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MaxPayerLength is the longest payer name of an insurance policy
+	// accepted, in characters.
+	MaxPayerLength = 200
+	// MaxMemberIDLength is the longest member ID or group number of an
+	// insurance policy accepted, in characters.
+	MaxMemberIDLength = 64
+)
+
+// InsurancePolicy normalizes the given insurance policy fields in place and
+// returns Errors describing every invalid one, or nil. The payer, member ID
+// and group number are trimmed, and the dates must be days such as
+// 2024-01-31, the expiry date, which may be nil, not before the effective
+// one.
+func InsurancePolicy(payer, memberID, groupNumber, effectiveDate, expiryDate *string) error {
+	var errs Errors
+
+	*payer = strings.TrimSpace(*payer)
+	switch {
+	case *payer == "":
+		errs = append(errs, FieldError{Field: "payer", Message: "payer must not be empty"})
+	case utf8.RuneCountInString(*payer) > MaxPayerLength:
+		errs = append(errs, FieldError{Field: "payer", Message: fmt.Sprintf("payer must be at most %d characters", MaxPayerLength)})
+	}
+
+	*memberID = strings.TrimSpace(*memberID)
+	switch {
+	case *memberID == "":
+		errs = append(errs, FieldError{Field: "memberId", Message: "memberId must not be empty"})
+	case utf8.RuneCountInString(*memberID) > MaxMemberIDLength:
+		errs = append(errs, FieldError{Field: "memberId", Message: fmt.Sprintf("memberId must be at most %d characters", MaxMemberIDLength)})
+	}
+
+	*groupNumber = strings.TrimSpace(*groupNumber)
+	if utf8.RuneCountInString(*groupNumber) > MaxMemberIDLength {
+		errs = append(errs, FieldError{Field: "groupNumber", Message: fmt.Sprintf("groupNumber must be at most %d characters", MaxMemberIDLength)})
+	}
+
+	*effectiveDate = strings.TrimSpace(*effectiveDate)
+	effective, err := time.Parse(time.DateOnly, *effectiveDate)
+	if err != nil {
+		errs = append(errs, FieldError{Field: "effectiveDate", Message: "effectiveDate must be a date such as 2024-01-31"})
+	}
+
+	if expiryDate != nil {
+		*expiryDate = strings.TrimSpace(*expiryDate)
+		expiry, expiryErr := time.Parse(time.DateOnly, *expiryDate)
+
+		switch {
+		case expiryErr != nil:
+			errs = append(errs, FieldError{Field: "expiryDate", Message: "expiryDate must be a date such as 2024-12-31"})
+		case err == nil && expiry.Before(effective):
+			errs = append(errs, FieldError{Field: "expiryDate", Message: "expiryDate must not be before effectiveDate"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxNoteLength is the longest note body accepted, in characters.
+const MaxNoteLength = 10000
+
+// Note trims the body of a note in place and returns Errors describing it
+// when it is invalid, or nil.
+func Note(body *string) error {
+	*body = strings.TrimSpace(*body)
+
+	switch {
+	case *body == "":
+		return Errors{{Field: "body", Message: "body must not be empty"}}
+	case utf8.RuneCountInString(*body) > MaxNoteLength:
+		return Errors{{Field: "body", Message: fmt.Sprintf("body must be at most %d characters", MaxNoteLength)}}
+	}
+
+	return nil
+}
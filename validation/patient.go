@@ -0,0 +1,134 @@
+// Package validation checks and normalizes patient, emergency contact,
+// encounter and insurance policy input before it reaches the database.
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxNameLength is the longest patient name accepted, in characters.
+	MaxNameLength = 255
+	// MaxEmailLength is the longest email address accepted (RFC 5321).
+	MaxEmailLength = 254
+)
+
+var (
+	emailPattern = regexp.MustCompile(`^[a-zA-Z0-9.!#$%&'*+/=?^_\x60{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)+$`)
+	phonePattern = regexp.MustCompile(`^\+[1-9]\d{7,14}$`)
+	// phoneFormatting matches the separators people type into phone numbers.
+	phoneFormatting = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+)
+
+// FieldError is a problem with one input field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors lists the invalid fields of an input. It is reported to GraphQL
+// clients with the BAD_USER_INPUT code and the fields in the extensions.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Message
+	}
+
+	return "invalid input: " + strings.Join(messages, "; ")
+}
+
+// Extensions implements gqlerrors.ExtendedError.
+func (e Errors) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": "BAD_USER_INPUT", "fields": []FieldError(e)}
+}
+
+// Patient normalizes the given patient fields in place and returns Errors
+// describing every invalid one, or nil. Names and emails are trimmed and
+// phone numbers converted to E.164. A nil field, one left out of a partial
+// update, is skipped.
+func Patient(name, email, phone *string) error {
+	var errs Errors
+
+	for _, f := range []struct {
+		name      string
+		value     *string
+		normalize func(string) string
+		check     func(string) string
+	}{
+		{"name", name, strings.TrimSpace, ValidateName},
+		{"email", email, strings.TrimSpace, ValidateEmail},
+		{"phone", phone, NormalizePhone, ValidatePhone},
+	} {
+		if f.value == nil {
+			continue
+		}
+
+		*f.value = f.normalize(*f.value)
+		if message := f.check(*f.value); message != "" {
+			errs = append(errs, FieldError{Field: f.name, Message: message})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// NormalizePhone removes spaces, dashes, dots and parentheses from phone and
+// writes its international prefix as "+", turning a leading "00" into "+"
+// and adding "+" to numbers given without one, which must then start with
+// their country code. The result is only E.164 if ValidatePhone accepts it.
+func NormalizePhone(phone string) string {
+	phone = phoneFormatting.Replace(strings.TrimSpace(phone))
+
+	switch {
+	case strings.HasPrefix(phone, "+"):
+		return phone
+	case strings.HasPrefix(phone, "00"):
+		return "+" + strings.TrimPrefix(phone, "00")
+	case phone == "":
+		return phone
+	}
+
+	return "+" + phone
+}
+
+// ValidateName returns why name is not a valid patient name, or "".
+func ValidateName(name string) string {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return "name must not be empty"
+	case utf8.RuneCountInString(name) > MaxNameLength:
+		return fmt.Sprintf("name must be at most %d characters", MaxNameLength)
+	}
+
+	return ""
+}
+
+// ValidateEmail returns why email is not a valid email address, or "".
+func ValidateEmail(email string) string {
+	switch {
+	case len(email) > MaxEmailLength:
+		return fmt.Sprintf("email must be at most %d characters", MaxEmailLength)
+	case !emailPattern.MatchString(email):
+		return "email must be a valid email address"
+	}
+
+	return ""
+}
+
+// ValidatePhone returns why phone is not an E.164 phone number, or "".
+func ValidatePhone(phone string) string {
+	if !phonePattern.MatchString(phone) {
+		return "phone must be an E.164 number such as +14155552671"
+	}
+
+	return ""
+}
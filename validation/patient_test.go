@@ -0,0 +1,100 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPatient(t *testing.T) {
+	const (
+		name  = "Ann Lee"
+		email = "ann@example.com"
+		phone = "+14155552671"
+	)
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name    string
+		in      [3]*string
+		want    [3]string
+		invalid []string
+	}{
+		{"valid", [3]*string{str(name), str(email), str(phone)}, [3]string{name, email, phone}, nil},
+		{"trimmed", [3]*string{str("  Ann Lee "), str(" ann@example.com\t"), str(" +14155552671 ")}, [3]string{name, email, phone}, nil},
+		{"name with accents", [3]*string{str("Zoë Ångström"), str(email), str(phone)}, [3]string{"Zoë Ångström", email, phone}, nil},
+		{"name of 255 characters", [3]*string{str(strings.Repeat("é", 255)), str(email), str(phone)}, [3]string{strings.Repeat("é", 255), email, phone}, nil},
+		{"empty name", [3]*string{str(""), str(email), str(phone)}, [3]string{"", email, phone}, []string{"name"}},
+		{"blank name", [3]*string{str("   "), str(email), str(phone)}, [3]string{"", email, phone}, []string{"name"}},
+		{"name of 256 characters", [3]*string{str(strings.Repeat("a", 256)), str(email), str(phone)}, [3]string{strings.Repeat("a", 256), email, phone}, []string{"name"}},
+		{"email with plus and subdomain", [3]*string{str(name), str("ann+test@mail.example.co.uk"), str(phone)}, [3]string{name, "ann+test@mail.example.co.uk", phone}, nil},
+		{"email without at", [3]*string{str(name), str("notanemail"), str(phone)}, [3]string{name, "notanemail", phone}, []string{"email"}},
+		{"email without domain", [3]*string{str(name), str("ann@"), str(phone)}, [3]string{name, "ann@", phone}, []string{"email"}},
+		{"email without top level domain", [3]*string{str(name), str("ann@localhost"), str(phone)}, [3]string{name, "ann@localhost", phone}, []string{"email"}},
+		{"email with spaces", [3]*string{str(name), str("ann lee@example.com"), str(phone)}, [3]string{name, "ann lee@example.com", phone}, []string{"email"}},
+		{"email with two ats", [3]*string{str(name), str("ann@@example.com"), str(phone)}, [3]string{name, "ann@@example.com", phone}, []string{"email"}},
+		{"email too long", [3]*string{str(name), str(strings.Repeat("a", 250) + "@example.com"), str(phone)}, [3]string{name, strings.Repeat("a", 250) + "@example.com", phone}, []string{"email"}},
+		{"phone formatted", [3]*string{str(name), str(email), str("+1 (415) 555-2671")}, [3]string{name, email, phone}, nil},
+		{"phone with 00 prefix", [3]*string{str(name), str(email), str("0044 20 7946 0958")}, [3]string{name, email, "+442079460958"}, nil},
+		{"phone without plus", [3]*string{str(name), str(email), str("14155552671")}, [3]string{name, email, phone}, nil},
+		{"phone with letters", [3]*string{str(name), str(email), str("abc")}, [3]string{name, email, "+abc"}, []string{"phone"}},
+		{"phone too short", [3]*string{str(name), str(email), str("+1415555")}, [3]string{name, email, "+1415555"}, []string{"phone"}},
+		{"phone too long", [3]*string{str(name), str(email), str("+1415555267112345")}, [3]string{name, email, "+1415555267112345"}, []string{"phone"}},
+		{"phone starting with 0", [3]*string{str(name), str(email), str("+04155552671")}, [3]string{name, email, "+04155552671"}, []string{"phone"}},
+		{"empty phone", [3]*string{str(name), str(email), str("")}, [3]string{name, email, ""}, []string{"phone"}},
+		{"everything invalid", [3]*string{str(""), str("notanemail"), str("abc")}, [3]string{"", "notanemail", "+abc"}, []string{"name", "email", "phone"}},
+		{"email and phone invalid", [3]*string{str(name), str("ann@"), str("123")}, [3]string{name, "ann@", "+123"}, []string{"email", "phone"}},
+		{"only name given", [3]*string{str(" Ann Park "), nil, nil}, [3]string{"Ann Park", "", ""}, nil},
+		{"only an invalid email given", [3]*string{nil, str("notanemail"), nil}, [3]string{"", "notanemail", ""}, []string{"email"}},
+		{"nothing given", [3]*string{nil, nil, nil}, [3]string{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Patient(tt.in[0], tt.in[1], tt.in[2])
+
+			var invalid []string
+			if err != nil {
+				errs, ok := err.(Errors)
+				if !ok {
+					t.Fatalf("err = %T, want Errors", err)
+				}
+				for _, fieldErr := range errs {
+					invalid = append(invalid, fieldErr.Field)
+					if !strings.HasPrefix(fieldErr.Message, fieldErr.Field+" ") {
+						t.Errorf("message %q does not name the field %s", fieldErr.Message, fieldErr.Field)
+					}
+				}
+			}
+			if fmt.Sprint(invalid) != fmt.Sprint(tt.invalid) {
+				t.Errorf("invalid fields = %v, want %v", invalid, tt.invalid)
+			}
+
+			for i, value := range tt.in {
+				if value != nil && *value != tt.want[i] {
+					t.Errorf("field %d normalized to %q, want %q", i, *value, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	err := Patient(new(string), new(string), new(string))
+	if err == nil {
+		t.Fatal("no error for empty fields")
+	}
+
+	want := "invalid input: name must not be empty; email must be a valid email address; phone must be an E.164 number such as +14155552671"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+
+	extensions := err.(Errors).Extensions()
+	if extensions["code"] != "BAD_USER_INPUT" {
+		t.Errorf("code = %v, want BAD_USER_INPUT", extensions["code"])
+	}
+	if fields, _ := extensions["fields"].([]FieldError); len(fields) != 3 || fields[1].Field != "email" {
+		t.Errorf("fields = %v, want name, email and phone", extensions["fields"])
+	}
+}